@@ -3,7 +3,10 @@ package main
 import (
 	"context"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -14,15 +17,25 @@ import (
 	"github.com/ansible/receptor/pkg/logger"
 	"github.com/ansible/receptor/pkg/netceptor"
 	_ "github.com/ansible/receptor/pkg/services"
+	"github.com/ansible/receptor/pkg/telemetry"
+	"github.com/ansible/receptor/pkg/utils"
 	_ "github.com/ansible/receptor/pkg/version"
 	"github.com/ansible/receptor/pkg/workceptor"
 	"github.com/ghjm/cmdline"
 )
 
 type nodeCfg struct {
-	ID           string `description:"Node ID. Defaults to local hostname." barevalue:"yes"`
-	AllowedPeers string `description:"Comma separated list of peer node-IDs to allow"`
-	DataDir      string `description:"Directory in which to store node data"`
+	ID                 string `description:"Node ID. Defaults to local hostname." barevalue:"yes"`
+	AllowedPeers       string `description:"Comma separated list of peer node-IDs to allow"`
+	DataDir            string `description:"Directory in which to store node data"`
+	DisplayName        string `description:"Human-friendly name for this node, shown in status.  Unlike the node ID, it does not affect routing."`
+	Role               string `description:"Role of this node in the mesh: full, transit (relay only, no service advertisements) or edge (never used as a relay)" default:"full"`
+	MaxHops            int    `description:"Maximum number of times a message sent by this node may be forwarded" default:"30"`
+	SendTimeout        string `description:"Close a backend connection when sending to it blocks for this long (0 to disable)" default:"60s"`
+	SessionBufferLimit int    `description:"Most bytes of session data that backends may buffer in memory, across all connections (0 for no limit)" default:"67108864"`
+	RouteMaxAge        string `description:"Evict a node from the routing table when it has sent no routing update for this long (0 to disable)" default:"0"`
+	ClockSkewThreshold string `description:"Warn when a neighbor's clock differs from ours by more than this (0 to disable)" default:"5s"`
+	MessageSizeBuckets string `description:"Comma separated upper bounds in bytes of the buckets of each backend session's message size histograms" default:"64,256,1024,4096,16384,65536"`
 }
 
 func (cfg nodeCfg) Init() error {
@@ -41,6 +54,10 @@ func (cfg nodeCfg) Init() error {
 	if strings.ToLower(cfg.ID) == "localhost" {
 		return fmt.Errorf("node ID \"localhost\" is reserved")
 	}
+	err = netceptor.ValidateNodeRole(cfg.Role)
+	if err != nil {
+		return err
+	}
 	var allowedPeers []string
 	if cfg.AllowedPeers != "" {
 		allowedPeers = strings.Split(cfg.AllowedPeers, ",")
@@ -49,6 +66,58 @@ func (cfg nodeCfg) Init() error {
 		}
 	}
 	netceptor.MainInstance = netceptor.New(context.Background(), cfg.ID, allowedPeers)
+	err = netceptor.MainInstance.SetRole(cfg.Role)
+	if err != nil {
+		return err
+	}
+	err = netceptor.MainInstance.SetNodeDisplayName(cfg.DisplayName)
+	if err != nil {
+		return err
+	}
+	err = netceptor.MainInstance.SetMaxForwardingHops(cfg.MaxHops)
+	if err != nil {
+		return err
+	}
+	sendTimeout, err := time.ParseDuration(cfg.SendTimeout)
+	if err != nil {
+		return fmt.Errorf("invalid send timeout %s: %s", cfg.SendTimeout, err)
+	}
+	err = netceptor.MainInstance.SetSendTimeout(sendTimeout)
+	if err != nil {
+		return err
+	}
+	err = netceptor.SessionBufferBudget.SetLimit(int64(cfg.SessionBufferLimit))
+	if err != nil {
+		return err
+	}
+	routeMaxAge, err := time.ParseDuration(cfg.RouteMaxAge)
+	if err != nil {
+		return fmt.Errorf("invalid route max age %s: %s", cfg.RouteMaxAge, err)
+	}
+	err = netceptor.MainInstance.SetRouteMaxAge(routeMaxAge)
+	if err != nil {
+		return err
+	}
+	clockSkewThreshold, err := time.ParseDuration(cfg.ClockSkewThreshold)
+	if err != nil {
+		return fmt.Errorf("invalid clock skew threshold %s: %s", cfg.ClockSkewThreshold, err)
+	}
+	err = netceptor.MainInstance.SetClockSkewThreshold(clockSkewThreshold)
+	if err != nil {
+		return err
+	}
+	var messageSizeBuckets []int
+	for _, b := range strings.Split(cfg.MessageSizeBuckets, ",") {
+		bound, err := strconv.Atoi(strings.TrimSpace(b))
+		if err != nil {
+			return fmt.Errorf("invalid message size bucket %s: %s", b, err)
+		}
+		messageSizeBuckets = append(messageSizeBuckets, bound)
+	}
+	err = netceptor.MainInstance.SetMessageSizeBuckets(messageSizeBuckets)
+	if err != nil {
+		return err
+	}
 	workceptor.MainInstance, err = workceptor.New(context.Background(), netceptor.MainInstance, cfg.DataDir)
 	if err != nil {
 		return err
@@ -63,13 +132,51 @@ func (cfg nodeCfg) Init() error {
 }
 
 func (cfg nodeCfg) Run() error {
+	utils.RecordEffectiveConfig("node", cfg)
 	workceptor.MainInstance.ListKnownUnitIDs() // Triggers a scan of unit dirs and restarts any that need it
 
 	return nil
 }
 
+type readinessCfg struct {
+	File    string `description:"File to write, holding the process ID, once the node is ready"`
+	Systemd bool   `description:"Notify systemd with sd_notify READY=1 once the node is ready" default:"false"`
+}
+
+// readiness is the readiness config, if one was given.
+var readiness *readinessCfg
+
+func (cfg readinessCfg) Prepare() error {
+	if cfg.File == "" && !cfg.Systemd {
+		return fmt.Errorf("readiness requires a file, systemd, or both")
+	}
+
+	return nil
+}
+
+func (cfg readinessCfg) Run() error {
+	utils.RecordEffectiveConfig("readiness", cfg)
+	if cfg.File != "" {
+		// A file left by an earlier run must not be mistaken for readiness
+		if err := utils.RemoveReadinessFile(cfg.File); err != nil {
+			return fmt.Errorf("error removing readiness file %s: %s", cfg.File, err)
+		}
+	}
+	readiness = &cfg
+
+	return nil
+}
+
+// notifyReady tells the supervisor that the node is ready, using whichever methods were configured.
+func (cfg readinessCfg) notifyReady() error {
+	return utils.NotifyReady(cfg.File, cfg.Systemd)
+}
+
 type nullBackendCfg struct{}
 
+// localOnly is set when the node runs self-contained, so it will never establish a backend session.
+var localOnly bool
+
 // make the nullBackendCfg object be usable as a do-nothing Backend.
 func (cfg nullBackendCfg) Start(ctx context.Context, wg *sync.WaitGroup) (chan netceptor.BackendSession, error) {
 	return make(chan netceptor.BackendSession), nil
@@ -77,6 +184,8 @@ func (cfg nullBackendCfg) Start(ctx context.Context, wg *sync.WaitGroup) (chan n
 
 // Run runs the action, in this case adding a null backend to keep the wait group alive.
 func (cfg nullBackendCfg) Run() error {
+	utils.RecordEffectiveConfig("local-only", cfg)
+	localOnly = true
 	err := netceptor.MainInstance.AddBackend(&nullBackendCfg{}, 1.0, nil)
 	if err != nil {
 		return err
@@ -89,10 +198,69 @@ func (cfg nullBackendCfg) Reload() error {
 	return cfg.Run()
 }
 
+// expandedConfigFile holds the merged config, when --config names a directory or a compressed file.
+var expandedConfigFile string
+
+// expandedConfigDir is the directory under the node's data dir that holds expandedConfigFile.  It is private
+// to the user receptor runs as, since the config may contain secrets.
+const expandedConfigDir = ".receptor-config"
+
+// prepareConfig loads the config named by --config.  If it is a directory of fragments or is
+// compressed, the merged config is written to expandedConfigFile under the data dir, and the returned
+// args refer to it.
+func prepareConfig(args []string, configArg int) ([]string, *utils.LoadedConfig, error) {
+	if configArg < 0 {
+		return args, nil, nil
+	}
+	lc, err := utils.LoadConfig(args[configArg])
+	if err != nil {
+		return nil, nil, fmt.Errorf("error loading config: %s", err)
+	}
+	if !lc.Expanded {
+		return args, lc, nil
+	}
+	if expandedConfigFile == "" {
+		dataDir := lc.NodeDataDir()
+		if dataDir == "" {
+			dataDir = filepath.Join(os.TempDir(), "receptor")
+		}
+		dir := filepath.Join(dataDir, expandedConfigDir)
+		err = os.MkdirAll(dir, 0o700)
+		if err != nil {
+			return nil, nil, fmt.Errorf("could not create directory for merged config: %s", err)
+		}
+		expandedConfigFile = filepath.Join(dir, fmt.Sprintf("merged-%d.yml", os.Getpid()))
+	}
+	err = ioutil.WriteFile(expandedConfigFile, lc.Data, 0o600)
+	if err != nil {
+		return nil, nil, err
+	}
+	expandedArgs := make([]string, len(args))
+	copy(expandedArgs, args)
+	expandedArgs[configArg] = expandedConfigFile
+
+	return expandedArgs, lc, nil
+}
+
+// exit removes any merged config file, flushes pending traces and exits.
+func exit(code int) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	_ = telemetry.Shutdown(ctx)
+	cancel()
+	if expandedConfigFile != "" {
+		_ = os.Remove(expandedConfigFile)
+	}
+	if readiness != nil && readiness.File != "" {
+		_ = utils.RemoveReadinessFile(readiness.File)
+	}
+	os.Exit(code)
+}
+
 func main() {
 	cl := cmdline.NewCmdline()
 	cl.AddConfigType("node", "Node configuration of this instance", nodeCfg{}, cmdline.Required, cmdline.Singleton)
 	cl.AddConfigType("local-only", "Run a self-contained node with no backends", nullBackendCfg{}, cmdline.Singleton)
+	cl.AddConfigType("readiness", "Signal a supervisor once a backend connection is up", readinessCfg{}, cmdline.Singleton)
 
 	// Add registered config types from imported modules
 	for _, appName := range []string{
@@ -106,44 +274,61 @@ func main() {
 		"receptor-proxies",
 		"receptor-backends",
 		"receptor-workers",
+		"receptor-telemetry",
 	} {
 		cl.AddRegisteredConfigTypes(appName)
 	}
 
 	osArgs := os.Args[1:]
 
-	err := cl.ParseAndRun(osArgs, []string{"Init", "Prepare", "Run"}, cmdline.ShowHelpIfNoArgs)
-	if err != nil {
-		fmt.Printf("Error: %s\n", err)
-		os.Exit(1)
-	}
-	if cl.WhatRan() != "" {
-		// We ran an exclusive command, so we aren't starting any back-ends
-		os.Exit(0)
-	}
-
 	configPath := ""
+	configArg := -1
 	for i, arg := range osArgs {
 		if arg == "--config" || arg == "-c" {
 			if len(osArgs) > i+1 {
 				configPath = osArgs[i+1]
+				configArg = i + 1
 			}
 
 			break
 		}
 	}
 
+	args, lc, err := prepareConfig(osArgs, configArg)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		exit(1)
+	}
+	err = cl.ParseAndRun(args, []string{"Init", "Prepare", "Run"}, cmdline.ShowHelpIfNoArgs)
+	if lc != nil {
+		err = lc.ExplainError(err)
+	}
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		exit(1)
+	}
+	if cl.WhatRan() != "" {
+		// We ran an exclusive command, so we aren't starting any back-ends
+		exit(0)
+	}
+
 	// only allow reloading if a configuration file was provided. If ReloadCL is
 	// not set, then the control service reload command will fail
 	if configPath != "" {
 		// create closure with the passed in args to be ran during a reload
 		reloadParseAndRun := func(toRun []string) error {
-			return cl.ParseAndRun(osArgs, toRun)
+			// Reload the config, so that added or removed fragments are picked up
+			args, lc, err := prepareConfig(osArgs, configArg)
+			if err != nil {
+				return err
+			}
+
+			return lc.ExplainError(cl.ParseAndRun(args, toRun))
 		}
 		err = controlsvc.InitReload(configPath, reloadParseAndRun)
 		if err != nil {
 			fmt.Printf("Error: %s\n", err)
-			os.Exit(1)
+			exit(1)
 		}
 	}
 	done := make(chan struct{})
@@ -157,15 +342,29 @@ func main() {
 	case <-done:
 		if netceptor.MainInstance.BackendCount() > 0 {
 			logger.Error("All backends have failed. Exiting.\n")
-			os.Exit(1)
+			exit(1)
 		} else {
 			logger.Warning("Nothing to do - no backends were specified.\n")
 			fmt.Printf("Run %s --help for command line instructions.\n", os.Args[0])
-			os.Exit(1)
+			exit(1)
 		}
 	case <-time.After(100 * time.Millisecond):
 	}
 	logger.Info("Initialization complete\n")
+	if readiness != nil {
+		go func() {
+			var err error
+			if localOnly {
+				err = readiness.notifyReady()
+			} else {
+				err = netceptor.MainInstance.NotifyWhenReady(readiness.notifyReady)
+			}
+			if err != nil {
+				logger.Error("Error signalling readiness: %s\n", err)
+			}
+		}()
+	}
 
 	<-netceptor.MainInstance.NetceptorDone()
+	exit(0)
 }
@@ -0,0 +1,123 @@
+//go:build !no_websocket_backend && !no_backends
+// +build !no_websocket_backend,!no_backends
+
+package backends
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/ansible/receptor/pkg/logger"
+	"github.com/ansible/receptor/pkg/tls"
+	"github.com/ansible/receptor/pkg/utils"
+)
+
+// websocketALPNProtocol is the ALPN protocol of receptor's own websocket connections.
+const websocketALPNProtocol = "http/1.1"
+
+// ALPNHandler handles a connection to a websocket listener that negotiated another service's ALPN
+// protocol.  The TLS handshake is complete when it is called, and the connection is closed when it returns.
+type ALPNHandler func(conn *tls.Conn)
+
+// validateALPNProtocol returns an error if proto cannot be routed to another service.
+func validateALPNProtocol(proto string) error {
+	if proto == "" || len(proto) > 255 {
+		return fmt.Errorf("ALPN protocol %q must be 1 to 255 bytes long", proto)
+	}
+	if proto == websocketALPNProtocol {
+		return fmt.Errorf("ALPN protocol %s is used by receptor itself", proto)
+	}
+
+	return nil
+}
+
+// parseALPNTarget splits the target of an ALPN protocol, tcp:host:port or unix:path, into a network and address.
+func parseALPNTarget(target string) (string, string, error) {
+	parts := strings.SplitN(target, ":", 2)
+	if len(parts) == 2 {
+		switch parts[0] {
+		case "tcp":
+			if _, _, err := net.SplitHostPort(parts[1]); err == nil {
+				return parts[0], parts[1], nil
+			}
+		case "unix":
+			if parts[1] != "" {
+				return parts[0], parts[1], nil
+			}
+		}
+	}
+
+	return "", "", fmt.Errorf("ALPN target %s must be tcp:host:port or unix:path", target)
+}
+
+// ALPNForwardHandler returns a handler that forwards the decrypted stream of each connection to target,
+// which is tcp:host:port or unix:path.
+func ALPNForwardHandler(proto string, target string) (ALPNHandler, error) {
+	network, address, err := parseALPNTarget(target)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(conn *tls.Conn) {
+		tc, err := net.Dial(network, address)
+		if err != nil {
+			logger.Error("Error forwarding ALPN protocol %s connection to %s: %s\n", proto, target, err)
+
+			return
+		}
+		utils.BridgeConns(conn, "ALPN "+proto+" connection", tc, target)
+	}, nil
+}
+
+// SetALPNHandler routes TLS connections that negotiate the ALPN protocol proto to handler, instead of
+// treating them as receptor connections, so that other services can share the listener's port.
+// Connections that negotiate http/1.1, or no protocol, are receptor's.  It requires TLS, and is only
+// effective if used prior to calling Start.
+func (b *WebsocketListener) SetALPNHandler(proto string, handler ALPNHandler) error {
+	if err := validateALPNProtocol(proto); err != nil {
+		return err
+	}
+	if b.alpnHandlers == nil {
+		b.alpnHandlers = make(map[string]ALPNHandler)
+	}
+	b.alpnHandlers[proto] = handler
+
+	return nil
+}
+
+// alpnServerConfig returns the TLS config and next protocol handlers of a listener with ALPN handlers.
+func (b *WebsocketListener) alpnServerConfig() (*tls.Config, map[string]func(*http.Server, *tls.Conn, http.Handler)) {
+	protos := make([]string, 0, len(b.alpnHandlers))
+	nextProto := make(map[string]func(*http.Server, *tls.Conn, http.Handler))
+	for proto, handler := range b.alpnHandlers {
+		handler := handler
+		protos = append(protos, proto)
+		nextProto[proto] = func(_ *http.Server, conn *tls.Conn, _ http.Handler) {
+			handler(conn)
+		}
+	}
+	sort.Strings(protos)
+
+	// Receptor's protocol comes first, so it is chosen if a client offers it along with others
+	protos = append([]string{websocketALPNProtocol}, protos...)
+	tlscfg := b.tlscfg.Clone()
+	tlscfg.NextProtos = protos
+	if getConfig := tlscfg.GetConfigForClient; getConfig != nil {
+		// The configs chosen by SNI must offer the protocols too
+		tlscfg.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			cfg, err := getConfig(hello)
+			if err != nil || cfg == nil {
+				return cfg, err
+			}
+			cfg = cfg.Clone()
+			cfg.NextProtos = protos
+
+			return cfg, nil
+		}
+	}
+
+	return tlscfg, nextProto
+}
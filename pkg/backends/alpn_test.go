@@ -0,0 +1,178 @@
+package backends
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// selfSignedCert returns a TLS certificate for localhost, signed by its own key.
+func selfSignedCert(t *testing.T) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-1 * time.Minute),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return tls.Certificate{Certificate: [][]byte{certDER}, PrivateKey: key}
+}
+
+// echoListener echoes back everything written to each of its connections, and returns its address.
+func echoListener(t *testing.T) string {
+	li, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = li.Close() })
+	go func() {
+		for {
+			conn, err := li.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_, _ = io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	return li.Addr().String()
+}
+
+func TestWebsocketListenerALPN(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	li, err := NewWebsocketListener("127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{selfSignedCert(t)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// One protocol is handled in process, the other is forwarded to another service
+	err = li.SetALPNHandler("greet", func(conn *tls.Conn) {
+		_, _ = conn.Write([]byte("hello from " + conn.ConnectionState().NegotiatedProtocol))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	forward, err := ALPNForwardHandler("echo", "tcp:"+echoListener(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := li.SetALPNHandler("echo", forward); err != nil {
+		t.Fatal(err)
+	}
+	sessChan, err := li.Start(ctx, &sync.WaitGroup{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := li.Addr().String()
+	dialTLS := func(protos ...string) *tls.Conn {
+		conn, err := tls.Dial("tcp", addr, &tls.Config{InsecureSkipVerify: true, NextProtos: protos})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = conn.Close() })
+		_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+		return conn
+	}
+
+	conn := dialTLS("greet")
+	data, err := ioutil.ReadAll(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "hello from greet" {
+		t.Fatalf("expected the greet handler to answer, got %q", data)
+	}
+
+	conn = dialTLS("echo")
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "ping" {
+		t.Fatalf("expected the echo service to answer, got %q", buf)
+	}
+
+	// Receptor's own protocol wins when a client offers it along with another
+	conn = dialTLS("greet", "http/1.1")
+	if err := conn.Handshake(); err != nil {
+		t.Fatal(err)
+	}
+	if proto := conn.ConnectionState().NegotiatedProtocol; proto != "http/1.1" {
+		t.Fatalf("expected http/1.1 to be negotiated, got %q", proto)
+	}
+
+	// A receptor dialer, which offers no protocol, still gets a receptor session
+	d, err := NewWebsocketDialer("wss://"+addr+"/", &tls.Config{InsecureSkipVerify: true}, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dialerChan, err := d.Start(ctx, &sync.WaitGroup{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case sess := <-dialerChan:
+		acceptSession(t, sess, sessChan, "hello")
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out dialing websocket")
+	}
+}
+
+func TestWebsocketListenerALPNErrors(t *testing.T) {
+	li, err := NewWebsocketListener("127.0.0.1:0", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, proto := range []string{"", "http/1.1", string(make([]byte, 256))} {
+		if err := li.SetALPNHandler(proto, func(*tls.Conn) {}); err == nil {
+			t.Fatalf("expected ALPN protocol %q to be refused", proto)
+		}
+	}
+	if err := li.SetALPNHandler("imap", func(*tls.Conn) {}); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if _, err := li.Start(ctx, &sync.WaitGroup{}); err == nil {
+		t.Fatal("expected ALPN protocols without TLS to be refused")
+	}
+
+	for _, target := range []string{"tcp:localhost:143", "unix:/run/imap.sock"} {
+		if _, err := ALPNForwardHandler("imap", target); err != nil {
+			t.Fatalf("expected target %s to be accepted, got %s", target, err)
+		}
+	}
+	for _, target := range []string{"", "localhost:143", "tcp:localhost", "unix:", "udp:localhost:143"} {
+		if _, err := ALPNForwardHandler("imap", target); err == nil {
+			t.Fatalf("expected target %q to be refused", target)
+		}
+	}
+}
@@ -6,6 +6,7 @@ package backends
 import (
 	"errors"
 	"fmt"
+	"net"
 
 	"github.com/ansible/receptor/pkg/netceptor"
 )
@@ -25,7 +26,8 @@ func validateDialCost(rawCost *float64) (float64, error) {
 	return cost, nil
 }
 
-func validateListenerCost(rawCost *float64, rawNodeCost map[string]float64) (float64, map[string]float64, error) {
+// validateNodeCosts checks a connection cost, defaulting to 1.0, and any per-node costs that override it.
+func validateNodeCosts(rawCost *float64, rawNodeCost map[string]float64) (float64, map[string]float64, error) {
 	cost := 1.0
 	if rawCost != nil {
 		cost = *rawCost
@@ -42,6 +44,98 @@ func validateListenerCost(rawCost *float64, rawNodeCost map[string]float64) (flo
 	return cost, rawNodeCost, nil
 }
 
+// newLinkCost returns the composite cost configured for a connection, or nil if neither component is set.
+func newLinkCost(latency float64, bandwidth float64) (*netceptor.LinkCost, error) {
+	if latency == 0 && bandwidth == 0 {
+		return nil, nil
+	}
+	lc := &netceptor.LinkCost{Latency: latency, Bandwidth: bandwidth}
+	if err := lc.Validate(); err != nil {
+		return nil, fmt.Errorf("composite connection cost: %w", err)
+	}
+
+	return lc, nil
+}
+
+// ErrInvalidNetwork indicates a listener network that is unknown or does not match its bind address.
+var ErrInvalidNetwork = errors.New("invalid listener network")
+
+// validateListenNetwork checks that network is one of tcp, tcp4 or tcp6, and that a literal
+// IP bind address belongs to the requested address family.
+func validateListenNetwork(network string, bindAddr string) error {
+	switch network {
+	case "", "tcp":
+		return nil
+	case "tcp4", "tcp6":
+	default:
+		return fmt.Errorf("%w: %s (must be tcp, tcp4 or tcp6)", ErrInvalidNetwork, network)
+	}
+	ip := net.ParseIP(bindAddr)
+	if ip == nil {
+		return nil
+	}
+	isV4 := ip.To4() != nil
+	if network == "tcp4" && !isV4 {
+		return fmt.Errorf("%w: bind address %s is not an IPv4 address", ErrInvalidNetwork, bindAddr)
+	}
+	if network == "tcp6" && isV4 {
+		return fmt.Errorf("%w: bind address %s is not an IPv6 address", ErrInvalidNetwork, bindAddr)
+	}
+
+	return nil
+}
+
+// ErrNoInterfaceAddress indicates that a network interface has no address usable for listening.
+var ErrNoInterfaceAddress = errors.New("interface has no suitable address")
+
+// interfaceAddr returns the current address of the named network interface that is usable with network
+// (tcp, tcp4 or tcp6).  Global addresses are preferred over link-local ones, and for plain tcp, IPv4 is
+// preferred over IPv6.
+func interfaceAddr(name string, network string) (string, error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return "", fmt.Errorf("could not find interface %s: %w", name, err)
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return "", fmt.Errorf("could not get addresses of interface %s: %w", name, err)
+	}
+	var best string
+	bestRank := -1
+	for _, addr := range addrs {
+		ipnet, ok := addr.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		ip := ipnet.IP
+		isV4 := ip.To4() != nil
+		if (network == "tcp4" && !isV4) || (network == "tcp6" && isV4) {
+			continue
+		}
+		rank := 0
+		host := ip.String()
+		if ip.IsLinkLocalUnicast() {
+			if !isV4 {
+				host = fmt.Sprintf("%s%%%s", host, name)
+			}
+		} else {
+			rank += 2
+		}
+		if isV4 {
+			rank++
+		}
+		if rank > bestRank {
+			best = host
+			bestRank = rank
+		}
+	}
+	if bestRank < 0 {
+		return "", fmt.Errorf("%w: %s (network %s)", ErrNoInterfaceAddress, name, network)
+	}
+
+	return best, nil
+}
+
 // Backends is a set of backends used by a receptor instance.
 type Backends struct {
 	// Dial to other instances.
@@ -0,0 +1,128 @@
+//go:build !no_tcp_backend && !no_backends
+// +build !no_tcp_backend,!no_backends
+
+// Package backendstest provides meshes of Netceptor nodes for use in tests.
+package backendstest
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ansible/receptor/pkg/backends"
+	"github.com/ansible/receptor/pkg/netceptor"
+)
+
+// Link is a loopback TCP link between two nodes, which a test can break to simulate a network failure.
+type Link struct {
+	li    net.Listener
+	lock  sync.Mutex
+	conns map[net.Conn]struct{}
+}
+
+// ConnectedNodes returns two Netceptor instances, node1 and node2, that are connected to each other over
+// loopback TCP.
+func ConnectedNodes(ctx context.Context, t testing.TB) (*netceptor.Netceptor, *netceptor.Netceptor) {
+	n1, n2, _ := ConnectedNodesWithLink(ctx, t)
+
+	return n1, n2
+}
+
+// ConnectedNodesWithLink is ConnectedNodes, also returning the link between the nodes.  Node1 dials node2
+// through the link, and redials whenever the link is broken.
+func ConnectedNodesWithLink(ctx context.Context, t testing.TB) (*netceptor.Netceptor, *netceptor.Netceptor, *Link) {
+	n1 := netceptor.New(ctx, "node1", nil)
+	n2 := netceptor.New(ctx, "node2", nil)
+	li, err := backends.NewTCPListener("localhost:0", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := n2.AddBackend(li, 1.0, nil); err != nil {
+		t.Fatal(err)
+	}
+	link, err := newLink(li.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(link.close)
+	d, err := backends.NewTCPDialer(link.li.Addr().String(), true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := n1.AddBackend(d, 1.0, nil); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		if _, ok := n1.Status().RoutingTable["node2"]; ok {
+			return n1, n2, link
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	t.Fatal("nodes did not connect")
+
+	return nil, nil, nil
+}
+
+// newLink starts forwarding connections to address.
+func newLink(address string) (*Link, error) {
+	li, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		return nil, err
+	}
+	l := &Link{
+		li:    li,
+		conns: make(map[net.Conn]struct{}),
+	}
+	go l.serve(address)
+
+	return l, nil
+}
+
+func (l *Link) serve(address string) {
+	for {
+		conn, err := l.li.Accept()
+		if err != nil {
+			return
+		}
+		target, err := net.Dial("tcp", address)
+		if err != nil {
+			_ = conn.Close()
+
+			continue
+		}
+		l.lock.Lock()
+		l.conns[conn] = struct{}{}
+		l.conns[target] = struct{}{}
+		l.lock.Unlock()
+		go l.forward(conn, target)
+		go l.forward(target, conn)
+	}
+}
+
+func (l *Link) forward(dst net.Conn, src net.Conn) {
+	_, _ = io.Copy(dst, src)
+	_ = dst.Close()
+	_ = src.Close()
+	l.lock.Lock()
+	delete(l.conns, dst)
+	delete(l.conns, src)
+	l.lock.Unlock()
+}
+
+// Break closes the connections currently carried by the link.  The dialing node reconnects through it.
+func (l *Link) Break() {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	for conn := range l.conns {
+		_ = conn.Close()
+	}
+}
+
+func (l *Link) close() {
+	_ = l.li.Close()
+	l.Break()
+}
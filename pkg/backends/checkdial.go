@@ -0,0 +1,136 @@
+//go:build !no_backends
+// +build !no_backends
+
+package backends
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ansible/receptor/pkg/netceptor"
+	"github.com/mitchellh/mapstructure"
+)
+
+// dialConfig is the config of a dialer, as given in the dial section of the backends config.
+type dialConfig interface {
+	newBackend() (netceptor.Backend, error)
+}
+
+// dialConfigTypes creates an empty config of each kind of dialer, by its name in the dial section.
+var dialConfigTypes = make(map[string]func() dialConfig)
+
+// registerDialConfig makes a kind of dialer available to CheckDial.
+func registerDialConfig(kind string, newConfig func() dialConfig) {
+	dialConfigTypes[kind] = newConfig
+}
+
+// DialConfigKinds returns the kinds of dialer that CheckDial can build.
+func DialConfigKinds() []string {
+	kinds := make([]string, 0, len(dialConfigTypes))
+	for kind := range dialConfigTypes {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+
+	return kinds
+}
+
+// DialCheck is the outcome of a test connection made by CheckDial.
+type DialCheck struct {
+	Connected bool
+	Error     string
+	Elapsed   time.Duration
+	// Details are what the connection negotiated, for backends that report it.
+	Details map[string]interface{}
+}
+
+// CheckDial builds a dialer from its config, in the form used in the dial section of the backends config,
+// and makes a single connection attempt with it.  The connection is closed again without being added to
+// the mesh.  An error is returned if the config is invalid; a failure to connect is reported in the result.
+func CheckDial(kind string, config map[string]interface{}, timeout time.Duration) (*DialCheck, error) {
+	newConfig, ok := dialConfigTypes[kind]
+	if !ok {
+		return nil, fmt.Errorf("unknown backend type %s, must be one of %v", kind, DialConfigKinds())
+	}
+	dc := newConfig()
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		DecodeHook:  mapstructure.StringToTimeDurationHookFunc(),
+		ErrorUnused: true,
+		Result:      dc,
+	})
+	if err != nil {
+		return nil, err
+	}
+	withoutRedial := map[string]interface{}{"no-redial": true}
+	for k, v := range config {
+		if k != "no-redial" {
+			withoutRedial[k] = v
+		}
+	}
+	if err := decoder.Decode(withoutRedial); err != nil {
+		return nil, fmt.Errorf("invalid %s backend config: %w", kind, err)
+	}
+	b, err := dc.newBackend()
+	if err != nil {
+		return nil, err
+	}
+
+	result := &DialCheck{}
+	var dialErrLock sync.Mutex
+	var dialErr error
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	ctx = withDialErrorFunc(ctx, func(err error) {
+		dialErrLock.Lock()
+		dialErr = err
+		dialErrLock.Unlock()
+	})
+	start := time.Now()
+	sessChan, err := b.Start(ctx, &sync.WaitGroup{})
+	if err != nil {
+		result.Error = err.Error()
+
+		return result, nil
+	}
+	select {
+	case sess, ok := <-sessChan:
+		result.Elapsed = time.Since(start)
+		if ok {
+			result.Connected = true
+			result.Details = sessionDetails(sess)
+			_ = sess.Close()
+
+			return result, nil
+		}
+	case <-ctx.Done():
+		result.Elapsed = time.Since(start)
+	}
+	dialErrLock.Lock()
+	defer dialErrLock.Unlock()
+	switch {
+	case dialErr != nil:
+		result.Error = dialErr.Error()
+	case ctx.Err() != nil:
+		result.Error = fmt.Sprintf("timed out after %s", timeout)
+	default:
+		result.Error = "connection failed"
+	}
+
+	return result, nil
+}
+
+// sessionDetails returns what a session reports about its connection.
+func sessionDetails(sess netceptor.BackendSession) map[string]interface{} {
+	details := make(map[string]interface{})
+	if cr, ok := sess.(netceptor.CompressionReporter); ok {
+		details["Compression"] = cr.CompressionNegotiated()
+	}
+	if _, ok := sess.(*muxStream); ok {
+		details["Multiplexed"] = true
+	}
+
+	return details
+}
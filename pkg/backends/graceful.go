@@ -0,0 +1,394 @@
+//go:build !windows && !no_backends
+// +build !windows,!no_backends
+
+package backends
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/ansible/receptor/pkg/logger"
+	"github.com/ansible/receptor/pkg/netceptor"
+	"github.com/ansible/receptor/pkg/utils"
+	"github.com/ghjm/cmdline"
+)
+
+// gracefulAckTimeout is how long either side of a handoff waits for the new process to adopt
+// the inherited listeners before giving up on them.
+const gracefulAckTimeout = 30 * time.Second
+
+// gracefulMaxListeners is the maximum number of listening sockets passed in one handoff.
+const gracefulMaxListeners = 256
+
+var gracefulAck = []byte("ready\n")
+
+// gracefulRegistry tracks the listening sockets of this process, so they can be handed to a new
+// process, and any sockets inherited from a previous process that are waiting to be adopted.
+// Listeners are keyed by their configured address, so the new process must configure the same address.
+type gracefulRegistry struct {
+	lock       sync.Mutex
+	listeners  map[string]*net.TCPListener
+	inherited  map[string]*net.TCPListener
+	handedOff  map[*net.TCPListener]bool
+	parentConn *net.UnixConn
+}
+
+var graceful = &gracefulRegistry{
+	listeners: make(map[string]*net.TCPListener),
+	inherited: make(map[string]*net.TCPListener),
+	handedOff: make(map[*net.TCPListener]bool),
+}
+
+// registerGracefulListener makes a listener available for handoff to a new process.
+func registerGracefulListener(address string, li *net.TCPListener) {
+	graceful.lock.Lock()
+	defer graceful.lock.Unlock()
+	graceful.listeners[address] = li
+}
+
+// unregisterGracefulListener removes a listener that has been closed.
+func unregisterGracefulListener(address string, li *net.TCPListener) {
+	graceful.lock.Lock()
+	defer graceful.lock.Unlock()
+	if graceful.listeners[address] == li {
+		delete(graceful.listeners, address)
+	}
+}
+
+// wasHandedOff reports whether a listener was closed because it was handed to a new process, rather than
+// because of an error.  The record is removed, so this is only true once per listener.
+func wasHandedOff(li *net.TCPListener) bool {
+	graceful.lock.Lock()
+	defer graceful.lock.Unlock()
+	handedOff := graceful.handedOff[li]
+	delete(graceful.handedOff, li)
+
+	return handedOff
+}
+
+// takeInheritedListener returns the listener inherited from a previous process for this address,
+// or nil if there is none.  Once every inherited listener has been adopted, the previous process
+// is told it can stop accepting connections.
+func takeInheritedListener(address string) *net.TCPListener {
+	graceful.lock.Lock()
+	defer graceful.lock.Unlock()
+	li, ok := graceful.inherited[address]
+	if !ok {
+		return nil
+	}
+	delete(graceful.inherited, address)
+	logger.Info("Adopted inherited listener for %s\n", address)
+	if len(graceful.inherited) == 0 {
+		graceful.ackParent()
+	}
+
+	return li
+}
+
+// ackParent tells the previous process that the handoff is complete.  Must be called with the lock held.
+func (g *gracefulRegistry) ackParent() {
+	if g.parentConn == nil {
+		return
+	}
+	_, err := g.parentConn.Write(gracefulAck)
+	if err != nil {
+		logger.Warning("Error acknowledging listener handoff: %s\n", err)
+	}
+	_ = g.parentConn.Close()
+	g.parentConn = nil
+}
+
+// releaseUnadopted closes any inherited listeners that were not claimed by a configured backend.
+func (g *gracefulRegistry) releaseUnadopted() {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	for address, li := range g.inherited {
+		logger.Warning("Inherited listener for %s was not adopted by any backend; closing it\n", address)
+		_ = li.Close()
+		delete(g.inherited, address)
+	}
+	g.ackParent()
+}
+
+// InheritListeners connects to a running receptor's graceful restart socket and takes over its
+// listening sockets.  It returns the number of listeners inherited, which is zero if no previous
+// process is listening on the socket.
+func InheritListeners(socketPath string) (int, error) {
+	conn, err := net.DialUnix("unix", nil, &net.UnixAddr{Name: socketPath, Net: "unix"})
+	if errors.Is(err, syscall.ENOENT) || errors.Is(err, syscall.ECONNREFUSED) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("could not connect to graceful restart socket %s: %w", socketPath, err)
+	}
+	err = conn.SetReadDeadline(time.Now().Add(gracefulAckTimeout))
+	if err != nil {
+		_ = conn.Close()
+
+		return 0, err
+	}
+	buf := make([]byte, 65536)
+	oob := make([]byte, syscall.CmsgSpace(gracefulMaxListeners*4))
+	n, oobn, _, _, err := conn.ReadMsgUnix(buf, oob)
+	if err != nil {
+		_ = conn.Close()
+
+		return 0, fmt.Errorf("error receiving listeners: %w", err)
+	}
+	fds, err := parseRights(oob[:oobn])
+	if err != nil {
+		_ = conn.Close()
+
+		return 0, err
+	}
+	var addresses []string
+	err = json.Unmarshal(buf[:n], &addresses)
+	if err == nil && len(addresses) != len(fds) {
+		err = fmt.Errorf("received %d addresses but %d sockets", len(addresses), len(fds))
+	}
+	if err != nil {
+		for _, fd := range fds {
+			_ = syscall.Close(fd)
+		}
+		_ = conn.Close()
+
+		return 0, fmt.Errorf("invalid listener handoff: %w", err)
+	}
+	_ = conn.SetReadDeadline(time.Time{})
+	graceful.lock.Lock()
+	defer graceful.lock.Unlock()
+	for i, fd := range fds {
+		f := os.NewFile(uintptr(fd), addresses[i])
+		li, err := net.FileListener(f)
+		_ = f.Close()
+		if err != nil {
+			logger.Error("Could not use inherited listener for %s: %s\n", addresses[i], err)
+
+			continue
+		}
+		tli, ok := li.(*net.TCPListener)
+		if !ok {
+			_ = li.Close()
+			logger.Error("Inherited listener for %s is not a TCP listener\n", addresses[i])
+
+			continue
+		}
+		graceful.inherited[addresses[i]] = tli
+	}
+	graceful.parentConn = conn
+	if len(graceful.inherited) == 0 {
+		graceful.ackParent()
+	}
+
+	return len(graceful.inherited), nil
+}
+
+func parseRights(oob []byte) ([]int, error) {
+	msgs, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing socket control message: %w", err)
+	}
+	fds := make([]int, 0)
+	for i := range msgs {
+		msgFds, err := syscall.ParseUnixRights(&msgs[i])
+		if err != nil {
+			return nil, fmt.Errorf("error parsing socket rights: %w", err)
+		}
+		fds = append(fds, msgFds...)
+	}
+
+	return fds, nil
+}
+
+// ServeGracefulRestart listens on a Unix socket for a new receptor process.  When one connects,
+// all registered listening sockets are passed to it.  Once the new process has adopted them (or
+// the wait times out), this process stops accepting connections, and onHandoff is called so the
+// caller can drain its existing sessions.  Only one handoff is served.
+func ServeGracefulRestart(ctx context.Context, socketPath string, onHandoff func()) error {
+	err := os.RemoveAll(socketPath)
+	if err != nil {
+		return fmt.Errorf("could not overwrite socket file: %w", err)
+	}
+	uli, err := net.ListenUnix("unix", &net.UnixAddr{Name: socketPath, Net: "unix"})
+	if err != nil {
+		return fmt.Errorf("could not listen on graceful restart socket: %w", err)
+	}
+	// The new process replaces the socket file, so it must not be removed when we close.
+	uli.SetUnlinkOnClose(false)
+	err = os.Chmod(socketPath, 0o600)
+	if err != nil {
+		_ = uli.Close()
+
+		return fmt.Errorf("error setting socket file permissions: %w", err)
+	}
+	go func() {
+		<-ctx.Done()
+		_ = uli.Close()
+	}()
+	go func() {
+		defer uli.Close()
+		for {
+			conn, err := uli.AcceptUnix()
+			if err != nil {
+				if ctx.Err() == nil {
+					logger.Error("Error accepting graceful restart connection: %s\n", err)
+				}
+
+				return
+			}
+			err = handoffListeners(conn)
+			_ = conn.Close()
+			if err != nil {
+				logger.Error("Listener handoff failed: %s\n", err)
+
+				continue
+			}
+			if onHandoff != nil {
+				onHandoff()
+			}
+
+			return
+		}
+	}()
+
+	return nil
+}
+
+// handoffListeners sends the registered listeners over conn, waits for the new process to
+// adopt them, and then closes this process's copies.
+func handoffListeners(conn *net.UnixConn) error {
+	graceful.lock.Lock()
+	addresses := make([]string, 0, len(graceful.listeners))
+	listeners := make([]*net.TCPListener, 0, len(graceful.listeners))
+	files := make([]*os.File, 0, len(graceful.listeners))
+	for address, li := range graceful.listeners {
+		f, err := li.File()
+		if err != nil {
+			graceful.lock.Unlock()
+			for _, f := range files {
+				_ = f.Close()
+			}
+
+			return fmt.Errorf("could not get file for listener %s: %w", address, err)
+		}
+		addresses = append(addresses, address)
+		listeners = append(listeners, li)
+		files = append(files, f)
+	}
+	graceful.lock.Unlock()
+	defer func() {
+		for _, f := range files {
+			_ = f.Close()
+		}
+	}()
+	if len(files) > gracefulMaxListeners {
+		return fmt.Errorf("too many listeners to hand off (%d)", len(files))
+	}
+	data, err := json.Marshal(addresses)
+	if err != nil {
+		return err
+	}
+	// Fd would put the sockets in blocking mode, which they share with this process's listeners, and
+	// a blocked Accept could then not be interrupted when the listeners are closed
+	fds := make([]int, len(files))
+	for i, f := range files {
+		rc, err := f.SyscallConn()
+		if err != nil {
+			return err
+		}
+		err = rc.Control(func(fd uintptr) {
+			fds[i] = int(fd)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	var oob []byte
+	if len(fds) > 0 {
+		oob = syscall.UnixRights(fds...)
+	}
+	_, _, err = conn.WriteMsgUnix(data, oob, nil)
+	if err != nil {
+		return fmt.Errorf("error sending listeners: %w", err)
+	}
+	logger.Info("Handed off %d listeners to new process\n", len(files))
+
+	// Keep accepting until the new process is ready, so no connections are missed
+	buf := make([]byte, len(gracefulAck))
+	_ = conn.SetReadDeadline(time.Now().Add(gracefulAckTimeout))
+	_, err = conn.Read(buf)
+	if err != nil {
+		logger.Warning("New process did not confirm listener handoff: %s\n", err)
+	}
+
+	for i, li := range listeners {
+		unregisterGracefulListener(addresses[i], li)
+		graceful.lock.Lock()
+		graceful.handedOff[li] = true
+		graceful.lock.Unlock()
+		_ = li.Close()
+	}
+
+	return nil
+}
+
+// **************************************************************************
+// Command line
+// **************************************************************************
+
+// gracefulRestartCfg is the cmdline configuration object for graceful restarts.
+type gracefulRestartCfg struct {
+	Socket    string `description:"Unix socket used to hand listeners to a new receptor process" barevalue:"yes" required:"yes"`
+	DrainTime string `description:"Maximum time to wait for existing sessions to close after a handoff" default:"30s"`
+}
+
+// Init inherits listeners from a previous receptor process, if one is running.
+func (cfg gracefulRestartCfg) Init() error {
+	if _, err := time.ParseDuration(cfg.DrainTime); err != nil {
+		return fmt.Errorf("invalid drain time %s: %s", cfg.DrainTime, err)
+	}
+	n, err := InheritListeners(cfg.Socket)
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		logger.Info("Inherited %d listeners from previous process\n", n)
+	}
+
+	return nil
+}
+
+// Run runs the action.
+func (cfg gracefulRestartCfg) Run() error {
+	utils.RecordEffectiveConfig("graceful-restart", cfg)
+	drainTime, err := time.ParseDuration(cfg.DrainTime)
+	if err != nil {
+		return err
+	}
+	go func() {
+		time.Sleep(gracefulAckTimeout)
+		graceful.releaseUnadopted()
+	}()
+
+	return ServeGracefulRestart(context.Background(), cfg.Socket, func() {
+		logger.Info("Draining sessions before exit\n")
+		deadline := time.Now().Add(drainTime)
+		for time.Now().Before(deadline) && len(netceptor.MainInstance.Status().Connections) > 0 {
+			time.Sleep(500 * time.Millisecond)
+		}
+		netceptor.MainInstance.Shutdown()
+	})
+}
+
+func init() {
+	cmdline.RegisterConfigTypeForApp("receptor-backends",
+		"graceful-restart", "Hand listening sockets to a new receptor process on restart", gracefulRestartCfg{},
+		cmdline.Singleton, cmdline.Section(backendSection))
+}
@@ -0,0 +1,196 @@
+//go:build linux
+// +build linux
+
+package backends
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ansible/receptor/pkg/netceptor"
+	"github.com/gorilla/websocket"
+)
+
+// countSessions counts sessions arriving on sessChan, closing each one.
+func countSessions(sessChan chan netceptor.BackendSession, count *int64) {
+	for sess := range sessChan {
+		atomic.AddInt64(count, 1)
+		_ = sess.Close()
+	}
+}
+
+func TestGracefulRestart(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "receptor-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	socketPath := path.Join(tmpdir, "graceful.sock")
+
+	// Start the "old" process's listener and handoff server
+	oldCtx, oldCancel := context.WithCancel(context.Background())
+	defer oldCancel()
+	oldLi, err := NewTCPListener("127.0.0.1:0", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldSessions, err := oldLi.Start(oldCtx, &sync.WaitGroup{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := oldLi.Addr().String()
+	var accepted int64
+	go countSessions(oldSessions, &accepted)
+	handedOff := make(chan struct{})
+	err = ServeGracefulRestart(oldCtx, socketPath, func() { close(handedOff) })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Keep connecting throughout the transition
+	var dialed int64
+	stopDialing := make(chan struct{})
+	dialErr := make(chan error, 1)
+	dialDone := make(chan struct{})
+	go func() {
+		defer close(dialDone)
+		for {
+			select {
+			case <-stopDialing:
+				return
+			default:
+			}
+			conn, err := net.DialTimeout("tcp", address, time.Second)
+			if err != nil {
+				dialErr <- err
+
+				return
+			}
+			atomic.AddInt64(&dialed, 1)
+			_ = conn.Close()
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+
+	// Simulate the "new" process inheriting and adopting the listener
+	time.Sleep(200 * time.Millisecond)
+	n, err := InheritListeners(socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Fatalf("expected to inherit 1 listener, got %d", n)
+	}
+	newCtx, newCancel := context.WithCancel(context.Background())
+	defer newCancel()
+	newLi, err := NewTCPListener("127.0.0.1:0", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newSessions, err := newLi.Start(newCtx, &sync.WaitGroup{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if newLi.Addr().String() != address {
+		t.Fatalf("new listener is on %s, expected inherited address %s", newLi.Addr().String(), address)
+	}
+	go countSessions(newSessions, &accepted)
+	select {
+	case <-handedOff:
+	case <-time.After(10 * time.Second):
+		t.Fatal("old process did not complete the handoff")
+	}
+
+	time.Sleep(200 * time.Millisecond)
+	close(stopDialing)
+	<-dialDone
+	select {
+	case err := <-dialErr:
+		t.Fatalf("connection failed during restart: %s", err)
+	default:
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadInt64(&accepted) < atomic.LoadInt64(&dialed) && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+	}
+	if atomic.LoadInt64(&accepted) != atomic.LoadInt64(&dialed) {
+		t.Fatalf("dialed %d connections but only %d were accepted", dialed, accepted)
+	}
+}
+
+func TestGracefulRestartWebsocket(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "receptor-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	socketPath := path.Join(tmpdir, "graceful.sock")
+
+	oldCtx, oldCancel := context.WithCancel(context.Background())
+	defer oldCancel()
+	oldLi, err := NewWebsocketListener("127.0.0.1:0", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldSessions, err := oldLi.Start(oldCtx, &sync.WaitGroup{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := oldLi.Addr().String()
+	var oldAccepted int64
+	go countSessions(oldSessions, &oldAccepted)
+	handedOff := make(chan struct{})
+	err = ServeGracefulRestart(oldCtx, socketPath, func() { close(handedOff) })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := InheritListeners(socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Fatalf("expected to inherit 1 listener, got %d", n)
+	}
+	newCtx, newCancel := context.WithCancel(context.Background())
+	defer newCancel()
+	newLi, err := NewWebsocketListener("127.0.0.1:0", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newSessions, err := newLi.Start(newCtx, &sync.WaitGroup{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if newLi.Addr().String() != address {
+		t.Fatalf("new listener is on %s, expected inherited address %s", newLi.Addr().String(), address)
+	}
+	var newAccepted int64
+	go countSessions(newSessions, &newAccepted)
+	select {
+	case <-handedOff:
+	case <-time.After(10 * time.Second):
+		t.Fatal("old process did not complete the handoff")
+	}
+
+	// Connections now reach the new process's websocket server
+	conn, _, err := websocket.DefaultDialer.Dial("ws://"+address+"/", nil)
+	if err != nil {
+		t.Fatalf("connection failed after restart: %s", err)
+	}
+	defer conn.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadInt64(&newAccepted) == 0 && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+	}
+	if atomic.LoadInt64(&newAccepted) != 1 {
+		t.Fatal("new process did not accept the websocket connection")
+	}
+}
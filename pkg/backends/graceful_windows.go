@@ -0,0 +1,20 @@
+//go:build windows && !no_backends
+// +build windows,!no_backends
+
+package backends
+
+import "net"
+
+// Listener handoff relies on passing file descriptors over Unix sockets, which is not available on Windows.
+
+func registerGracefulListener(address string, li *net.TCPListener) {}
+
+func unregisterGracefulListener(address string, li *net.TCPListener) {}
+
+func takeInheritedListener(address string) *net.TCPListener {
+	return nil
+}
+
+func wasHandedOff(li *net.TCPListener) bool {
+	return false
+}
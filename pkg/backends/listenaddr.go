@@ -0,0 +1,190 @@
+//go:build !no_backends
+// +build !no_backends
+
+package backends
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ErrListenerConflict indicates that two listener configs would bind the same address.
+var ErrListenerConflict = errors.New("listener address conflict")
+
+// listenClaim is the address a listener config will bind to.
+type listenClaim struct {
+	entry   string
+	action  string
+	network string
+	host    string
+	port    string
+	// reusePort is set if the listener sets SO_REUSEPORT, so it can share the port with other listeners of
+	// the same type that do.
+	reusePort bool
+}
+
+// listenClaims collects the addresses of the listener configs in one pass over the configuration, so that
+// conflicts can be reported before anything is bound.  A pass starts with the Init phase at startup, or the
+// InitReload phase on reload, and each listener config claims its address when it is prepared.
+type listenClaims struct {
+	lock    sync.Mutex
+	claims  []listenClaim
+	counts  map[string]int
+	running []listenClaim
+}
+
+var listenerClaims = &listenClaims{
+	counts: make(map[string]int),
+}
+
+// resetListenerClaims starts a new pass over the configuration.
+func resetListenerClaims() {
+	listenerClaims.lock.Lock()
+	defer listenerClaims.lock.Unlock()
+	listenerClaims.claims = nil
+	listenerClaims.counts = make(map[string]int)
+}
+
+// claimListenAddress records that a listener config of the given action will bind address.
+// The network is tcp or udp; websocket listeners claim tcp addresses.
+func claimListenAddress(action string, network string, address string) {
+	claimListenAddressWithOptions(action, network, address, false)
+}
+
+// claimListenAddressWithOptions is claimListenAddress for a listener that may set SO_REUSEPORT.
+func claimListenAddressWithOptions(action string, network string, address string, reusePort bool) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		// Bad addresses are reported when the listener is started
+		return
+	}
+	listenerClaims.lock.Lock()
+	defer listenerClaims.lock.Unlock()
+	listenerClaims.counts[action]++
+	listenerClaims.claims = append(listenerClaims.claims, listenClaim{
+		entry:     fmt.Sprintf("%s #%d (%s)", action, listenerClaims.counts[action], address),
+		action:    action,
+		network:   strings.TrimRight(network, "46"),
+		host:      host,
+		port:      port,
+		reusePort: reusePort,
+	})
+}
+
+// isWildcardHost returns true if a bind host listens on all addresses.
+func isWildcardHost(host string) bool {
+	ip := net.ParseIP(host)
+
+	return host == "" || (ip != nil && ip.IsUnspecified())
+}
+
+// overlaps returns true if two claims cannot both be bound.  SO_REUSEPORT lets the kernel spread connections
+// across the listeners sharing a port, so only listeners of the same type, which speak the same protocol, may
+// share one.
+func (c listenClaim) overlaps(other listenClaim) bool {
+	if c.network != other.network || c.port != other.port {
+		return false
+	}
+	if c.reusePort && other.reusePort && c.action == other.action {
+		return false
+	}
+
+	return c.host == other.host || isWildcardHost(c.host) || isWildcardHost(other.host)
+}
+
+// conflictError returns an error naming every group of claims that overlap each other, or nil if there are none.
+func conflictError(claims []listenClaim) error {
+	conflicting := make(map[string][]string)
+	for i := range claims {
+		for j := range claims {
+			if i != j && claims[i].overlaps(claims[j]) {
+				key := fmt.Sprintf("%s port %s", claims[i].network, claims[i].port)
+				conflicting[key] = append(conflicting[key], claims[i].entry)
+
+				break
+			}
+		}
+	}
+	if len(conflicting) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(conflicting))
+	for key := range conflicting {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	msgs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		msgs = append(msgs, fmt.Sprintf("%s is used by %s", key, strings.Join(conflicting[key], ", ")))
+	}
+
+	return fmt.Errorf("%w: %s", ErrListenerConflict, strings.Join(msgs, "; "))
+}
+
+// checkListenerConflicts returns an error naming all listener configs in the current pass whose addresses
+// conflict.  If this pass is the one that will be run, its claims become the running listeners.
+func checkListenerConflicts(running bool) error {
+	listenerClaims.lock.Lock()
+	defer listenerClaims.lock.Unlock()
+	if err := conflictError(listenerClaims.claims); err != nil {
+		return err
+	}
+	if running {
+		listenerClaims.running = append([]listenClaim{}, listenerClaims.claims...)
+		releaseUnclaimedRetained(listenerClaims.running)
+	}
+
+	return nil
+}
+
+// probeListenAddresses tries binding each address claimed in the current pass that is not already bound by
+// a running listener, so that a reload that would fail to bind can be rejected before the running
+// listeners are stopped.
+func probeListenAddresses() error {
+	listenerClaims.lock.Lock()
+	claims := append([]listenClaim{}, listenerClaims.claims...)
+	running := append([]listenClaim{}, listenerClaims.running...)
+	listenerClaims.lock.Unlock()
+	msgs := make([]string, 0)
+	for _, claim := range claims {
+		bound := false
+		for _, r := range running {
+			if claim.overlaps(r) {
+				bound = true
+
+				break
+			}
+		}
+		if bound {
+			continue
+		}
+		address := net.JoinHostPort(claim.host, claim.port)
+		var err error
+		if claim.network == "udp" {
+			var pc net.PacketConn
+			pc, err = net.ListenPacket("udp", address)
+			if err == nil {
+				_ = pc.Close()
+			}
+		} else {
+			var li net.Listener
+			li, err = listenSocketOptions{reusePort: claim.reusePort}.listen(context.Background(), "tcp", address)
+			if err == nil {
+				_ = li.Close()
+			}
+		}
+		if err != nil {
+			msgs = append(msgs, fmt.Sprintf("%s: %s", claim.entry, err))
+		}
+	}
+	if len(msgs) > 0 {
+		return fmt.Errorf("cannot bind listeners: %s", strings.Join(msgs, "; "))
+	}
+
+	return nil
+}
@@ -0,0 +1,136 @@
+package backends
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/ansible/receptor/pkg/netceptor"
+	"github.com/ghjm/cmdline"
+)
+
+// freePort returns a TCP port that is not in use.
+func freePort(t *testing.T) string {
+	li, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer li.Close()
+
+	return strconv.Itoa(li.Addr().(*net.TCPAddr).Port)
+}
+
+func newBackendsCmdline() *cmdline.Cmdline {
+	cl := cmdline.NewCmdline()
+	cl.AddRegisteredConfigTypes("receptor-backends")
+
+	return cl
+}
+
+func TestListenerConflictValidation(t *testing.T) {
+	port := freePort(t)
+	otherPort := freePort(t)
+	err := newBackendsCmdline().ParseAndRun([]string{
+		"--tcp-listener", "bindaddr=127.0.0.1", "port=" + port,
+		"--udp-listener", "bindaddr=127.0.0.1", "port=" + port,
+		"--tcp-listener", "bindaddr=127.0.0.1", "port=" + otherPort,
+		"--ws-listener", "port=" + port,
+	}, []string{"Init", "Prepare", "Run"})
+	if err == nil {
+		t.Fatal("expected conflicting listeners to fail validation")
+	}
+	msg := err.Error()
+	for _, expected := range []string{
+		ErrListenerConflict.Error(),
+		"tcp port " + port,
+		"tcp-listener #1 (127.0.0.1:" + port + ")",
+		"ws-listener #1 (0.0.0.0:" + port + ")",
+	} {
+		if !strings.Contains(msg, expected) {
+			t.Errorf("expected %q in error: %s", expected, msg)
+		}
+	}
+	for _, unexpected := range []string{"udp-listener", "tcp-listener #2"} {
+		if strings.Contains(msg, unexpected) {
+			t.Errorf("did not expect %q in error: %s", unexpected, msg)
+		}
+	}
+	// Nothing should have been bound
+	li, err := net.Listen("tcp", "127.0.0.1:"+port)
+	if err != nil {
+		t.Fatalf("port was bound despite the validation error: %s", err)
+	}
+	_ = li.Close()
+}
+
+func TestReloadListenerConflictPreservesListener(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	oldInstance := netceptor.MainInstance
+	netceptor.MainInstance = netceptor.New(ctx, "node1", nil)
+	defer func() {
+		netceptor.MainInstance.CancelBackends()
+		netceptor.MainInstance = oldInstance
+	}()
+	port := freePort(t)
+	err := newBackendsCmdline().ParseAndRun([]string{
+		"--tcp-listener", "bindaddr=127.0.0.1", "port=" + port,
+	}, []string{"Init", "Prepare", "Run"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Checked without connecting, so no sessions are started
+	assertListening := func() {
+		if count := netceptor.MainInstance.BackendCount(); count != 1 {
+			t.Fatalf("expected the existing listener backend to be running, found %d backends", count)
+		}
+		li, err := net.Listen("tcp", "127.0.0.1:"+port)
+		if err == nil {
+			_ = li.Close()
+			t.Fatal("existing listener no longer holds its port")
+		}
+	}
+	assertListening()
+	validateReload := func(args ...string) error {
+		return newBackendsCmdline().ParseAndRun(args, []string{"InitReload", "PreReload", "ValidateReload"})
+	}
+
+	// Two new listeners on the same port are rejected
+	newPort := freePort(t)
+	err = validateReload(
+		"--tcp-listener", "bindaddr=127.0.0.1", "port="+port,
+		"--tcp-listener", "bindaddr=127.0.0.1", "port="+newPort,
+		"--ws-listener", "bindaddr=127.0.0.1", "port="+newPort,
+	)
+	if err == nil || !strings.Contains(err.Error(), "tcp-listener #2") || !strings.Contains(err.Error(), "ws-listener #1") {
+		t.Errorf("expected conflict naming tcp-listener #2 and ws-listener #1, got %v", err)
+	}
+	assertListening()
+
+	// A port already used by another socket is rejected, but the running listener's own port is not
+	busy, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer busy.Close()
+	err = validateReload(
+		"--tcp-listener", "bindaddr=127.0.0.1", "port="+port,
+		"--tcp-listener", "bindaddr=127.0.0.1", "port="+strconv.Itoa(busy.Addr().(*net.TCPAddr).Port),
+	)
+	if err == nil || !strings.Contains(err.Error(), "cannot bind listeners: tcp-listener #2") {
+		t.Errorf("expected bind error for tcp-listener #2, got %v", err)
+	}
+	assertListening()
+
+	// A valid configuration passes
+	err = validateReload(
+		"--tcp-listener", "bindaddr=127.0.0.1", "port="+port,
+		"--tcp-listener", "bindaddr=127.0.0.1", "port="+newPort,
+	)
+	if err != nil {
+		t.Errorf("expected valid reload to pass validation, got %s", err)
+	}
+	assertListening()
+}
@@ -0,0 +1,58 @@
+//go:build !no_backends
+// +build !no_backends
+
+package backends
+
+import (
+	"context"
+	"fmt"
+	"net"
+)
+
+// listenSocketOptions are options of a listening socket that must be set when it is bound.
+type listenSocketOptions struct {
+	// backlog is the most connections that may wait to be accepted.  Zero leaves the system default.
+	backlog int
+	// reusePort sets SO_REUSEPORT, so that several processes can listen on the same port, with the
+	// kernel spreading new connections between them.
+	reusePort bool
+}
+
+// validateListenSocketOptions returns an error if a backlog or SO_REUSEPORT cannot be used on this platform.
+func validateListenSocketOptions(backlog int, reusePort bool) error {
+	if backlog < 0 {
+		return fmt.Errorf("listen backlog must not be negative")
+	}
+	if backlog > 0 && !listenBacklogSupported {
+		return fmt.Errorf("setting the listen backlog is not supported on this platform")
+	}
+	if reusePort && !reusePortSupported {
+		return fmt.Errorf("SO_REUSEPORT is not supported on this platform")
+	}
+
+	return nil
+}
+
+// listen binds address with the options.
+func (o listenSocketOptions) listen(ctx context.Context, network string, address string) (net.Listener, error) {
+	if err := validateListenSocketOptions(o.backlog, o.reusePort); err != nil {
+		return nil, err
+	}
+	lc := net.ListenConfig{}
+	if o.reusePort {
+		lc.Control = reusePortControl
+	}
+	li, err := lc.Listen(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+	if o.backlog > 0 {
+		if err := setListenBacklog(li, o.backlog); err != nil {
+			_ = li.Close()
+
+			return nil, fmt.Errorf("could not set listen backlog on %s: %w", address, err)
+		}
+	}
+
+	return li, nil
+}
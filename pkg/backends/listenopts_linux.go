@@ -0,0 +1,55 @@
+//go:build !no_backends
+// +build !no_backends
+
+package backends
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	listenBacklogSupported = true
+	reusePortSupported     = true
+)
+
+// reusePortControl sets SO_REUSEPORT on a socket before it is bound.
+func reusePortControl(network, address string, c syscall.RawConn) error {
+	var serr error
+	err := c.Control(func(fd uintptr) {
+		serr = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1)
+	})
+	if err != nil {
+		return err
+	}
+	if serr != nil {
+		return fmt.Errorf("could not set SO_REUSEPORT on socket: %w", serr)
+	}
+
+	return nil
+}
+
+// setListenBacklog changes the backlog of a listening socket.  Linux applies a new backlog when listen is
+// called again on a socket that is already listening.  The kernel caps it at net.core.somaxconn.
+func setListenBacklog(li net.Listener, backlog int) error {
+	tli, ok := li.(*net.TCPListener)
+	if !ok {
+		return fmt.Errorf("not a TCP listener")
+	}
+	rc, err := tli.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var serr error
+	err = rc.Control(func(fd uintptr) {
+		serr = unix.Listen(int(fd), backlog)
+	})
+	if err != nil {
+		return err
+	}
+
+	return serr
+}
@@ -0,0 +1,120 @@
+package backends
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestListenReusePort(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	opts := listenSocketOptions{reusePort: true, backlog: 16}
+	first, err := opts.listen(ctx, "tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+	address := first.Addr().String()
+
+	// Without SO_REUSEPORT the port is taken
+	if li, err := (listenSocketOptions{}).listen(ctx, "tcp", address); err == nil {
+		_ = li.Close()
+		t.Fatal("expected a listener without SO_REUSEPORT to fail to bind the same port")
+	}
+	second, err := opts.listen(ctx, "tcp", address)
+	if err != nil {
+		t.Fatalf("expected a second listener with SO_REUSEPORT to bind the same port, got %s", err)
+	}
+	defer second.Close()
+
+	// The kernel spreads new connections between the listeners
+	counts := make([]int, 2)
+	lock := sync.Mutex{}
+	for i, li := range []net.Listener{first, second} {
+		go func(i int, li net.Listener) {
+			for {
+				conn, err := li.Accept()
+				if err != nil {
+					return
+				}
+				lock.Lock()
+				counts[i]++
+				lock.Unlock()
+				_ = conn.Close()
+			}
+		}(i, li)
+	}
+	const total = 100
+	for i := 0; i < total; i++ {
+		conn, err := net.DialTimeout("tcp", address, 2*time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = conn.Close()
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		lock.Lock()
+		accepted := counts[0] + counts[1]
+		both := counts[0] > 0 && counts[1] > 0
+		lock.Unlock()
+		if accepted == total {
+			if !both {
+				t.Fatalf("expected connections to be spread between the listeners, got %v", counts)
+			}
+
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("only %d of %d connections were accepted", accepted, total)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestValidateListenSocketOptions(t *testing.T) {
+	if err := validateListenSocketOptions(1024, true); err != nil {
+		t.Fatalf("expected a backlog and SO_REUSEPORT to be allowed on Linux, got %s", err)
+	}
+	if err := validateListenSocketOptions(-1, false); err == nil {
+		t.Fatal("expected a negative backlog to be refused")
+	}
+}
+
+func TestListenerConflictReusePort(t *testing.T) {
+	port := freePort(t)
+	err := newBackendsCmdline().ParseAndRun([]string{
+		"--tcp-listener", "bindaddr=127.0.0.1", "port=" + port, "reuseport=true",
+		"--tcp-listener", "bindaddr=127.0.0.1", "port=" + port, "reuseport=true",
+	}, []string{"Init", "Prepare"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := checkListenerConflicts(false); err != nil {
+		t.Fatalf("expected listeners of the same type that both set SO_REUSEPORT to share a port, got %s", err)
+	}
+	err = newBackendsCmdline().ParseAndRun([]string{
+		"--tcp-listener", "bindaddr=127.0.0.1", "port=" + port, "reuseport=true",
+		"--ws-listener", "bindaddr=127.0.0.1", "port=" + port, "reuseport=true",
+	}, []string{"Init", "Prepare"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := checkListenerConflicts(false); !errors.Is(err, ErrListenerConflict) {
+		t.Fatalf("expected listeners of different types to conflict even if both set SO_REUSEPORT, got %v", err)
+	}
+	err = newBackendsCmdline().ParseAndRun([]string{
+		"--tcp-listener", "bindaddr=127.0.0.1", "port=" + port, "reuseport=true",
+		"--ws-listener", "bindaddr=127.0.0.1", "port=" + port, "backlog=64",
+	}, []string{"Init", "Prepare"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := checkListenerConflicts(false); !errors.Is(err, ErrListenerConflict) {
+		t.Fatalf("expected a listener without SO_REUSEPORT to conflict with one that sets it, got %v", err)
+	}
+}
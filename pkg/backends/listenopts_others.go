@@ -0,0 +1,25 @@
+//go:build !linux && !no_backends
+// +build !linux,!no_backends
+
+package backends
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+)
+
+const (
+	listenBacklogSupported = false
+	reusePortSupported     = false
+)
+
+// reusePortControl is never used, because SO_REUSEPORT is refused by validateListenSocketOptions.
+func reusePortControl(network, address string, c syscall.RawConn) error {
+	return fmt.Errorf("SO_REUSEPORT is not supported on this platform")
+}
+
+// setListenBacklog is never used, because a backlog is refused by validateListenSocketOptions.
+func setListenBacklog(li net.Listener, backlog int) error {
+	return fmt.Errorf("setting the listen backlog is not supported on this platform")
+}
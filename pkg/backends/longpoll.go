@@ -0,0 +1,1059 @@
+//go:build !no_longpoll_backend && !no_backends
+// +build !no_longpoll_backend,!no_backends
+
+package backends
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ansible/receptor/pkg/logger"
+	"github.com/ansible/receptor/pkg/netceptor"
+	"github.com/ansible/receptor/pkg/tls"
+	"github.com/ansible/receptor/pkg/utils"
+	"github.com/ghjm/cmdline"
+)
+
+// The HTTP long-poll backend carries a session over ordinary HTTP requests, for networks where a proxy
+// blocks websockets.  The dialer opens a session with a POST, then keeps one GET outstanding, which the
+// listener holds until it has messages to send, and sends its own messages with POSTs.  Messages are
+// numbered in each direction, and each end keeps what it has sent until the other end acknowledges it,
+// so a request that fails can be retried without messages being lost, repeated or reordered.
+
+const (
+	// DefaultLongPollTimeout is how long a poll waits for messages before returning empty.
+	DefaultLongPollTimeout = 20 * time.Second
+
+	// maxLongPollTimeout is the longest a listener holds a poll.
+	maxLongPollTimeout = time.Minute
+
+	// longPollSessionExpiry is how long a session may go without any requests before it is closed.
+	longPollSessionExpiry = 2 * maxLongPollTimeout
+
+	// longPollRequestMargin is how much longer than the poll timeout the dialer waits for a response.
+	longPollRequestMargin = 10 * time.Second
+
+	// longPollWindow is how many messages each end may send before the other end acknowledges them.
+	longPollWindow = 64
+
+	// longPollBatchBytes is the size above which no more messages are added to a request or response.
+	longPollBatchBytes = 1024 * 1024
+
+	// maxLongPollBody is the largest request or response body that is read.
+	maxLongPollBody = 64 * 1024 * 1024
+)
+
+// errLongPollClosed is returned by a long-poll session that has been closed.
+var errLongPollClosed = errors.New("long-poll session closed")
+
+// longPollSession implements BackendSession for both ends of an HTTP long-poll connection.
+type longPollSession struct {
+	recvChan      chan []byte
+	inLock        sync.Mutex
+	inSeq         uint64
+	outLock       sync.Mutex
+	outbox        [][]byte
+	outBytes      int64
+	outBase       uint64
+	outSignal     chan struct{}
+	writeDeadline time.Time
+	closed        chan struct{}
+	closeOnce     sync.Once
+	onClose       func()
+	lastUsed      int64
+}
+
+func newLongPollSession(onClose func()) *longPollSession {
+	s := &longPollSession{
+		recvChan:  make(chan []byte, longPollWindow),
+		outSignal: make(chan struct{}),
+		closed:    make(chan struct{}),
+		onClose:   onClose,
+	}
+	s.touch()
+
+	return s
+}
+
+// Send sends data over the session.  It blocks while the other end has not acknowledged a full window
+// of messages.  If the session buffer budget has no room for the data, the session is closed instead.
+func (s *longPollSession) Send(data []byte) error {
+	size := int64(len(data))
+	s.outLock.Lock()
+	var expired <-chan time.Time
+	if len(s.outbox) >= longPollWindow && !s.writeDeadline.IsZero() {
+		timer := time.NewTimer(time.Until(s.writeDeadline))
+		defer timer.Stop()
+		expired = timer.C
+	}
+	for len(s.outbox) >= longPollWindow && !s.isClosed() {
+		signal := s.outSignal
+		s.outLock.Unlock()
+		select {
+		case <-signal:
+		case <-s.closed:
+		case <-expired:
+			return fmt.Errorf("%w: long-poll window still full", netceptor.ErrSendTimeout)
+		}
+		s.outLock.Lock()
+	}
+	if s.isClosed() {
+		s.outLock.Unlock()
+
+		return errLongPollClosed
+	}
+	if !netceptor.SessionBufferBudget.TryAcquire(size) {
+		s.outLock.Unlock()
+		logger.Warning("Closing long-poll session: %s\n", netceptor.ErrSessionBufferFull)
+		_ = s.Close()
+
+		return netceptor.ErrSessionBufferFull
+	}
+	msg := make([]byte, len(data))
+	copy(msg, data)
+	s.outbox = append(s.outbox, msg)
+	s.outBytes += size
+	s.notifyLocked()
+	s.outLock.Unlock()
+
+	return nil
+}
+
+// SetWriteDeadline sets the time by which a send must find room in the window of unacknowledged messages,
+// after which Send fails with an error wrapping netceptor.ErrSendTimeout.  The zero time disables the deadline.
+func (s *longPollSession) SetWriteDeadline(t time.Time) error {
+	s.outLock.Lock()
+	s.writeDeadline = t
+	s.outLock.Unlock()
+
+	return nil
+}
+
+// Recv receives data via the session.
+func (s *longPollSession) Recv(timeout time.Duration) ([]byte, error) {
+	// Messages that arrived before the session closed are still delivered
+	select {
+	case msg := <-s.recvChan:
+		return msg, nil
+	default:
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case msg := <-s.recvChan:
+		return msg, nil
+	case <-s.closed:
+		return nil, errLongPollClosed
+	case <-timer.C:
+		return nil, netceptor.ErrTimeout
+	}
+}
+
+// Close closes the session.
+func (s *longPollSession) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.closed)
+		s.outLock.Lock()
+		netceptor.SessionBufferBudget.Release(s.outBytes)
+		s.outbox = nil
+		s.outBytes = 0
+		s.outLock.Unlock()
+		if s.onClose != nil {
+			s.onClose()
+		}
+	})
+
+	return nil
+}
+
+func (s *longPollSession) isClosed() bool {
+	select {
+	case <-s.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+// notifyLocked wakes everything waiting for the outbox to change.  outLock must be held.
+func (s *longPollSession) notifyLocked() {
+	close(s.outSignal)
+	s.outSignal = make(chan struct{})
+}
+
+// touch records that a request has been made on the session.
+func (s *longPollSession) touch() {
+	atomic.StoreInt64(&s.lastUsed, time.Now().UnixNano())
+}
+
+// idle returns how long it has been since a request was made on the session.
+func (s *longPollSession) idle() time.Duration {
+	return time.Since(time.Unix(0, atomic.LoadInt64(&s.lastUsed)))
+}
+
+// acknowledge discards the sent messages numbered below next, which the other end has received.
+func (s *longPollSession) acknowledge(next uint64) error {
+	s.outLock.Lock()
+	defer s.outLock.Unlock()
+	if next < s.outBase || next > s.outBase+uint64(len(s.outbox)) {
+		return fmt.Errorf("acknowledgement of message %d is outside of sent messages %d to %d",
+			next, s.outBase, s.outBase+uint64(len(s.outbox)))
+	}
+	if next > s.outBase {
+		acked := int64(0)
+		for _, msg := range s.outbox[:next-s.outBase] {
+			acked += int64(len(msg))
+		}
+		netceptor.SessionBufferBudget.Release(acked)
+		s.outBytes -= acked
+		s.outbox = append([][]byte(nil), s.outbox[next-s.outBase:]...)
+		s.outBase = next
+		s.notifyLocked()
+	}
+
+	return nil
+}
+
+// pending waits up to wait for unacknowledged messages, and returns the number of the first one and as
+// many of them as fit in a batch.  It returns no messages if the wait ends first.
+func (s *longPollSession) pending(wait time.Duration, cancel <-chan struct{}) (uint64, [][]byte) {
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	s.outLock.Lock()
+	defer s.outLock.Unlock()
+	for len(s.outbox) == 0 {
+		signal := s.outSignal
+		s.outLock.Unlock()
+		stop := false
+		select {
+		case <-signal:
+		case <-timer.C:
+			stop = true
+		case <-s.closed:
+			stop = true
+		case <-cancel:
+			stop = true
+		}
+		s.outLock.Lock()
+		if stop {
+			return s.outBase, nil
+		}
+	}
+	size := 0
+	n := 0
+	for n < len(s.outbox) {
+		if n > 0 && size+len(s.outbox[n]) > longPollBatchBytes {
+			break
+		}
+		size += len(s.outbox[n])
+		n++
+	}
+
+	return s.outBase, append([][]byte(nil), s.outbox[:n]...)
+}
+
+// deliver queues received messages, the first of which is numbered seq, for Recv, skipping any that
+// were already received.  It blocks while the queue is full, which holds back the other end.
+func (s *longPollSession) deliver(seq uint64, msgs [][]byte, cancel <-chan struct{}) error {
+	s.inLock.Lock()
+	defer s.inLock.Unlock()
+	if seq > s.inSeq {
+		return fmt.Errorf("expected message %d but got %d", s.inSeq, seq)
+	}
+	skip := s.inSeq - seq
+	if skip >= uint64(len(msgs)) {
+		return nil
+	}
+	for _, msg := range msgs[skip:] {
+		select {
+		case s.recvChan <- msg:
+			s.inSeq++
+		case <-s.closed:
+			return errLongPollClosed
+		case <-cancel:
+			return fmt.Errorf("request cancelled")
+		}
+	}
+
+	return nil
+}
+
+// writeLongPollMessages writes messages, each preceded by its length.
+func writeLongPollMessages(w io.Writer, msgs [][]byte) error {
+	lenBuf := make([]byte, 4)
+	for _, msg := range msgs {
+		binary.BigEndian.PutUint32(lenBuf, uint32(len(msg)))
+		if _, err := w.Write(lenBuf); err != nil {
+			return err
+		}
+		if _, err := w.Write(msg); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// readLongPollMessages reads messages written by writeLongPollMessages, up to the end of r.
+func readLongPollMessages(r io.Reader) ([][]byte, error) {
+	var msgs [][]byte
+	lenBuf := make([]byte, 4)
+	for {
+		_, err := io.ReadFull(r, lenBuf)
+		if err == io.EOF {
+			return msgs, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading message length: %w", err)
+		}
+		msgLen := binary.BigEndian.Uint32(lenBuf)
+		if msgLen > maxLongPollBody {
+			return nil, fmt.Errorf("message of %d bytes is too large", msgLen)
+		}
+		msg := make([]byte, msgLen)
+		if _, err := io.ReadFull(r, msg); err != nil {
+			return nil, fmt.Errorf("reading message: %w", err)
+		}
+		msgs = append(msgs, msg)
+	}
+}
+
+// HTTPLongPollDialer implements Backend for outbound HTTP long-poll.
+type HTTPLongPollDialer struct {
+	address      string
+	redial       bool
+	redialDelays redialDelays
+	tlscfg       *tls.Config
+	pollTimeout  time.Duration
+	proxyURL     *url.URL
+}
+
+// NewHTTPLongPollDialer instantiates a new HTTPLongPollDialer backend.  The address is the URL of the
+// listener, using the http or https scheme.
+func NewHTTPLongPollDialer(address string, tlscfg *tls.Config, redial bool) (*HTTPLongPollDialer, error) {
+	u, err := url.Parse(address)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("address %s must use the http or https scheme", address)
+	}
+	hd := HTTPLongPollDialer{
+		address:      address,
+		redial:       redial,
+		redialDelays: defaultRedialDelays(),
+		tlscfg:       tlscfg,
+		pollTimeout:  DefaultLongPollTimeout,
+	}
+
+	return &hd, nil
+}
+
+// SetPollTimeout sets how long each poll asks the listener to wait for messages.  It should be shorter
+// than the timeout of any proxy in between.  The listener waits for at most a minute.
+// It is only effective if used prior to calling Start.
+func (b *HTTPLongPollDialer) SetPollTimeout(timeout time.Duration) {
+	b.pollTimeout = timeout
+}
+
+// SetProxy sets a forward proxy that the dialer connects through, overriding the proxy environment variables.
+// It is only effective if used prior to calling Start.
+func (b *HTTPLongPollDialer) SetProxy(proxyURL *url.URL) {
+	b.proxyURL = proxyURL
+}
+
+// SetRedialDelays sets how long the dialer waits to redial after a connection is lost or a dial fails: first
+// for the first retry, then backing off up to max.  It is only effective if used prior to calling Start.
+func (b *HTTPLongPollDialer) SetRedialDelays(first time.Duration, max time.Duration) {
+	b.redialDelays = newRedialDelays(first, max)
+}
+
+// String returns a description of the dialer.
+func (b *HTTPLongPollDialer) String() string {
+	return "http-longpoll-peer " + b.address
+}
+
+// Start runs the given session function over this backend service.
+func (b *HTTPLongPollDialer) Start(ctx context.Context, wg *sync.WaitGroup) (chan netceptor.BackendSession, error) {
+	return dialerSession(ctx, wg, b.redial, b.redialDelays,
+		func(closeChan chan struct{}) (netceptor.BackendSession, error) {
+			return b.open(ctx, closeChan)
+		})
+}
+
+// longPollStatusError is returned for a request that the listener answered with an error.
+type longPollStatusError struct {
+	status int
+	msg    string
+}
+
+func (e *longPollStatusError) Error() string {
+	return fmt.Sprintf("long-poll listener returned %d: %s", e.status, e.msg)
+}
+
+// retryable returns whether a request that failed with err may succeed if it is made again.
+func retryable(err error) bool {
+	var se *longPollStatusError
+	if errors.As(err, &se) {
+		return se.status >= 500
+	}
+
+	return true
+}
+
+// request makes a request to the listener, and returns the response body if it succeeded.
+func (b *HTTPLongPollDialer) request(ctx context.Context, client *http.Client, method string, query url.Values,
+	body []byte, timeout time.Duration) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	u, err := url.Parse(b.address)
+	if err != nil {
+		return nil, err
+	}
+	u.RawQuery = query.Encode()
+	var bodyReader io.Reader
+	if body != nil {
+		bodyReader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bodyReader)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxLongPollBody))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, &longPollStatusError{status: resp.StatusCode, msg: strings.TrimSpace(string(respBody))}
+	}
+
+	return respBody, nil
+}
+
+// open opens a new session with the listener, and starts the requests that carry it.
+func (b *HTTPLongPollDialer) open(ctx context.Context, closeChan chan struct{}) (netceptor.BackendSession, error) {
+	transport := &http.Transport{
+		Proxy:           http.ProxyFromEnvironment,
+		TLSClientConfig: b.tlscfg,
+	}
+	if b.proxyURL != nil {
+		transport.Proxy = http.ProxyURL(b.proxyURL)
+	}
+	client := &http.Client{Transport: transport}
+	idBytes, err := b.request(ctx, client, http.MethodPost, url.Values{}, nil, b.pollTimeout+longPollRequestMargin)
+	if err != nil {
+		return nil, err
+	}
+	id := string(idBytes)
+	sessCtx, cancel := context.WithCancel(ctx)
+	s := newLongPollSession(func() {
+		cancel()
+		close(closeChan)
+		// Tell the listener, so that its end of the session closes right away
+		go func() {
+			_, _ = b.request(context.Background(), client, http.MethodDelete, url.Values{"session": {id}}, nil,
+				longPollRequestMargin)
+			transport.CloseIdleConnections()
+		}()
+	})
+	go b.sendLoop(sessCtx, client, s, id)
+	go b.pollLoop(sessCtx, client, s, id)
+	logger.Debug("Opened long-poll session with %s\n", b.address)
+
+	return s, nil
+}
+
+// retry calls f until it succeeds, it fails in a way that retrying will not fix, or the session expires.
+func (b *HTTPLongPollDialer) retry(ctx context.Context, s *longPollSession, f func() error) error {
+	delay := utils.NewIncrementalDuration(time.Second, 10*time.Second, 2)
+	var firstFailure time.Time
+	for {
+		err := f()
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if firstFailure.IsZero() {
+			firstFailure = time.Now()
+		}
+		if !retryable(err) || time.Since(firstFailure) > longPollSessionExpiry {
+			logger.Warning("Closing long-poll session with %s: %s\n", b.address, err)
+			_ = s.Close()
+
+			return err
+		}
+		logger.Debug("Long-poll request to %s failed (will retry): %s\n", b.address, err)
+		select {
+		case <-delay.NextTimeout():
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// sendLoop sends the session's messages to the listener, in order.
+func (b *HTTPLongPollDialer) sendLoop(ctx context.Context, client *http.Client, s *longPollSession, id string) {
+	for {
+		seq, msgs := s.pending(b.pollTimeout, ctx.Done())
+		if ctx.Err() != nil {
+			return
+		}
+		if len(msgs) == 0 {
+			continue
+		}
+		body := &bytes.Buffer{}
+		_ = writeLongPollMessages(body, msgs)
+		query := url.Values{"session": {id}, "seq": {strconv.FormatUint(seq, 10)}}
+		err := b.retry(ctx, s, func() error {
+			_, err := b.request(ctx, client, http.MethodPost, query, body.Bytes(),
+				b.pollTimeout+longPollRequestMargin)
+
+			return err
+		})
+		if err != nil {
+			return
+		}
+		if err := s.acknowledge(seq + uint64(len(msgs))); err != nil {
+			logger.Error("Closing long-poll session with %s: %s\n", b.address, err)
+			_ = s.Close()
+
+			return
+		}
+	}
+}
+
+// pollLoop polls the listener for the session's messages.
+func (b *HTTPLongPollDialer) pollLoop(ctx context.Context, client *http.Client, s *longPollSession, id string) {
+	var next uint64
+	for {
+		query := url.Values{
+			"session": {id},
+			"next":    {strconv.FormatUint(next, 10)},
+			"wait":    {b.pollTimeout.String()},
+		}
+		var msgs [][]byte
+		err := b.retry(ctx, s, func() error {
+			body, err := b.request(ctx, client, http.MethodGet, query, nil, b.pollTimeout+longPollRequestMargin)
+			if err != nil {
+				return err
+			}
+			msgs, err = readLongPollMessages(bytes.NewReader(body))
+
+			return err
+		})
+		if err != nil {
+			return
+		}
+		if err := s.deliver(next, msgs, ctx.Done()); err != nil {
+			if ctx.Err() == nil {
+				logger.Error("Closing long-poll session with %s: %s\n", b.address, err)
+				_ = s.Close()
+			}
+
+			return
+		}
+		next += uint64(len(msgs))
+	}
+}
+
+// HTTPLongPollListener implements Backend for inbound HTTP long-poll.
+type HTTPLongPollListener struct {
+	address      string
+	path         string
+	tlscfg       *tls.Config
+	li           net.Listener
+	server       *http.Server
+	sessionsLock sync.Mutex
+	sessions     map[string]*longPollSession
+}
+
+// NewHTTPLongPollListener instantiates a new HTTPLongPollListener backend.
+func NewHTTPLongPollListener(address string, tlscfg *tls.Config) (*HTTPLongPollListener, error) {
+	hl := HTTPLongPollListener{
+		address:  address,
+		path:     "/",
+		tlscfg:   tlscfg,
+		sessions: make(map[string]*longPollSession),
+	}
+
+	return &hl, nil
+}
+
+// SetPath sets the URI path that the listener will be hosted on.
+// It is only effective if used prior to calling Start.
+func (b *HTTPLongPollListener) SetPath(path string) {
+	b.path = path
+}
+
+// Addr returns the network address the listener is listening on.
+func (b *HTTPLongPollListener) Addr() net.Addr {
+	if b.li == nil {
+		return nil
+	}
+
+	return b.li.Addr()
+}
+
+// Path returns the URI path the listener is configured on.
+func (b *HTTPLongPollListener) Path() string {
+	return b.path
+}
+
+// String returns a description of the listener.
+func (b *HTTPLongPollListener) String() string {
+	return "http-longpoll-listener " + b.address + b.path
+}
+
+// Start runs the given session function over the HTTPLongPollListener backend.
+func (b *HTTPLongPollListener) Start(ctx context.Context, wg *sync.WaitGroup) (chan netceptor.BackendSession, error) {
+	sessChan := make(chan netceptor.BackendSession)
+	mux := http.NewServeMux()
+	mux.HandleFunc(b.path, b.handler(ctx, sessChan))
+	li, err := net.Listen("tcp", b.address)
+	if err != nil {
+		return nil, fmt.Errorf("could not listen on %s: %w", b.address, err)
+	}
+	b.li = li
+	b.server = &http.Server{
+		Addr:    b.address,
+		Handler: mux,
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		var err error
+		if b.tlscfg == nil {
+			err = b.server.Serve(b.li)
+		} else {
+			b.server.TLSConfig = b.tlscfg
+			err = b.server.ServeTLS(b.li, "", "")
+		}
+		if err != nil && err != http.ErrServerClosed {
+			logger.Error("HTTP server error: %s\n", err)
+		}
+	}()
+	go b.expireSessions(ctx)
+	logger.Debug("Listening for HTTP long-poll on %s path %s\n", b.Addr().String(), b.path)
+
+	return sessChan, nil
+}
+
+// expireSessions closes sessions whose dialer has stopped making requests, and closes everything once
+// ctx is done.
+func (b *HTTPLongPollListener) expireSessions(ctx context.Context) {
+	ticker := time.NewTicker(longPollSessionExpiry / 4)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			_ = b.server.Close()
+			for _, s := range b.allSessions() {
+				_ = s.Close()
+			}
+
+			return
+		}
+		for _, s := range b.allSessions() {
+			if s.idle() > longPollSessionExpiry {
+				logger.Warning("Closing long-poll session that has not been used for %s\n", longPollSessionExpiry)
+				_ = s.Close()
+			}
+		}
+	}
+}
+
+func (b *HTTPLongPollListener) allSessions() []*longPollSession {
+	b.sessionsLock.Lock()
+	defer b.sessionsLock.Unlock()
+	sessions := make([]*longPollSession, 0, len(b.sessions))
+	for _, s := range b.sessions {
+		sessions = append(sessions, s)
+	}
+
+	return sessions
+}
+
+func (b *HTTPLongPollListener) session(id string) *longPollSession {
+	b.sessionsLock.Lock()
+	defer b.sessionsLock.Unlock()
+
+	return b.sessions[id]
+}
+
+// handler returns the HTTP handler for the requests that carry the listener's sessions.
+func (b *HTTPLongPollListener) handler(ctx context.Context, sessChan chan netceptor.BackendSession) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Responses must never be cached by a proxy
+		w.Header().Set("Cache-Control", "no-store")
+		query := r.URL.Query()
+		id := query.Get("session")
+		if id == "" {
+			if r.Method != http.MethodPost {
+				http.Error(w, "no session", http.StatusBadRequest)
+
+				return
+			}
+			b.open(ctx, w, r, sessChan)
+
+			return
+		}
+		s := b.session(id)
+		if s == nil {
+			http.Error(w, "unknown or closed session", http.StatusGone)
+
+			return
+		}
+		s.touch()
+		defer s.touch()
+		switch r.Method {
+		case http.MethodGet:
+			b.poll(w, r, s, query)
+		case http.MethodPost:
+			b.push(w, r, s, query)
+		case http.MethodDelete:
+			_ = s.Close()
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// open starts a new session, and responds with its ID.
+func (b *HTTPLongPollListener) open(ctx context.Context, w http.ResponseWriter, r *http.Request,
+	sessChan chan netceptor.BackendSession) {
+	idBytes := make([]byte, 16)
+	if _, err := rand.Read(idBytes); err != nil {
+		http.Error(w, "could not create session", http.StatusInternalServerError)
+
+		return
+	}
+	id := hex.EncodeToString(idBytes)
+	s := newLongPollSession(func() {
+		b.sessionsLock.Lock()
+		delete(b.sessions, id)
+		b.sessionsLock.Unlock()
+	})
+	b.sessionsLock.Lock()
+	b.sessions[id] = s
+	b.sessionsLock.Unlock()
+	select {
+	case sessChan <- s:
+	case <-ctx.Done():
+		_ = s.Close()
+		http.Error(w, "listener is shutting down", http.StatusServiceUnavailable)
+
+		return
+	case <-r.Context().Done():
+		_ = s.Close()
+
+		return
+	}
+	logger.Debug("Opened long-poll session from %s\n", r.RemoteAddr)
+	_, _ = w.Write([]byte(id))
+}
+
+// poll acknowledges the messages the dialer has received, and responds with the next ones once there are any.
+func (b *HTTPLongPollListener) poll(w http.ResponseWriter, r *http.Request, s *longPollSession, query url.Values) {
+	next, err := strconv.ParseUint(query.Get("next"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid next", http.StatusBadRequest)
+
+		return
+	}
+	wait := DefaultLongPollTimeout
+	if waitStr := query.Get("wait"); waitStr != "" {
+		wait, err = time.ParseDuration(waitStr)
+		if err != nil || wait < 0 {
+			http.Error(w, "invalid wait", http.StatusBadRequest)
+
+			return
+		}
+		if wait > maxLongPollTimeout {
+			wait = maxLongPollTimeout
+		}
+	}
+	if err := s.acknowledge(next); err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+
+		return
+	}
+	_, msgs := s.pending(wait, r.Context().Done())
+	if s.isClosed() {
+		http.Error(w, "session closed", http.StatusGone)
+
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	_ = writeLongPollMessages(w, msgs)
+}
+
+// push receives messages from the dialer.
+func (b *HTTPLongPollListener) push(w http.ResponseWriter, r *http.Request, s *longPollSession, query url.Values) {
+	seq, err := strconv.ParseUint(query.Get("seq"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid seq", http.StatusBadRequest)
+
+		return
+	}
+	msgs, err := readLongPollMessages(http.MaxBytesReader(w, r.Body, maxLongPollBody))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+	err = s.deliver(seq, msgs, r.Context().Done())
+	switch {
+	case errors.Is(err, errLongPollClosed):
+		http.Error(w, "session closed", http.StatusGone)
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// **************************************************************************
+// Command line
+// **************************************************************************
+
+// httpLongPollListenerCfg is the cmdline configuration object for an HTTP long-poll listener.
+type httpLongPollListenerCfg struct {
+	BindAddr      string             `description:"Local address to bind to" default:"0.0.0.0"`
+	Port          int                `description:"Local TCP port to run http server on" barevalue:"yes" required:"yes"`
+	Path          string             `description:"URI path to the long-poll server" default:"/"`
+	TLS           string             `description:"Name of TLS server config"`
+	Cost          float64            `description:"Connection cost (weight)" default:"1.0"`
+	LatencyCost   float64            `description:"Latency component of a composite connection cost, used to route interactive traffic"`
+	BandwidthCost float64            `description:"Bandwidth component of a composite connection cost, used to route bulk traffic"`
+	NodeCost      map[string]float64 `description:"Per-node costs"`
+	PSK           string             `description:"Pre-shared key that dialers must prove knowledge of" redact:"true"`
+	PreviousPSKs  []string           `description:"Previous pre-shared keys that dialers may still use while the key is rotated" redact:"true"`
+}
+
+// Prepare verifies the parameters are correct.
+func (cfg httpLongPollListenerCfg) Prepare() error {
+	if cfg.Cost <= 0.0 {
+		return fmt.Errorf("connection cost must be positive")
+	}
+	if _, err := newLinkCost(cfg.LatencyCost, cfg.BandwidthCost); err != nil {
+		return err
+	}
+	for node, cost := range cfg.NodeCost {
+		if cost <= 0.0 {
+			return fmt.Errorf("connection cost must be positive for %s", node)
+		}
+	}
+	claimListenAddress("http-longpoll-listener", "tcp", net.JoinHostPort(cfg.BindAddr, strconv.Itoa(cfg.Port)))
+
+	return nil
+}
+
+// Run runs the action.
+func (cfg httpLongPollListenerCfg) Run() error {
+	if err := checkListenerConflicts(true); err != nil {
+		return err
+	}
+	utils.RecordEffectiveConfig("http-longpoll-listener", cfg)
+	address := net.JoinHostPort(cfg.BindAddr, strconv.Itoa(cfg.Port))
+	tlscfg, err := netceptor.MainInstance.GetServerTLSConfig(cfg.TLS)
+	if err != nil {
+		return err
+	}
+	b, err := NewHTTPLongPollListener(address, tlscfg)
+	if err != nil {
+		logger.Error("Error creating listener %s: %s\n", address, err)
+
+		return err
+	}
+	b.SetPath(cfg.Path)
+	wb, err := wrapPSK(b, cfg.PSK, cfg.PreviousPSKs, true)
+	if err != nil {
+		return err
+	}
+	lc, err := newLinkCost(cfg.LatencyCost, cfg.BandwidthCost)
+	if err != nil {
+		return err
+	}
+
+	return netceptor.MainInstance.AddBackendWithOptions(wb, cfg.Cost, cfg.NodeCost, netceptor.BackendOptions{LinkCost: lc})
+}
+
+// httpLongPollDialerCfg is the cmdline configuration object for an HTTP long-poll dialer.
+type httpLongPollDialerCfg struct {
+	Address         string             `description:"URL to connect to, using http or https" barevalue:"yes" required:"yes"`
+	Redial          bool               `description:"Keep redialing on lost connection" default:"true"`
+	FirstRetryDelay string             `description:"Delay before the first redial after a lost connection or failed dial" default:"100ms"`
+	MaxRetryDelay   string             `description:"Longest delay between redials as failures continue" default:"20s"`
+	TLS             string             `description:"Name of TLS client config"`
+	Cost            float64            `description:"Connection cost (weight)" default:"1.0"`
+	LatencyCost     float64            `description:"Latency component of a composite connection cost, used to route interactive traffic"`
+	BandwidthCost   float64            `description:"Bandwidth component of a composite connection cost, used to route bulk traffic"`
+	NodeCost        map[string]float64 `description:"Per-node costs, overriding Cost for those nodes"`
+	PSK             string             `description:"Pre-shared key to authenticate to the listener with" redact:"true"`
+	PollTimeout     string             `description:"How long the listener may hold a poll open, shorter than any proxy timeout (at most 1m)" default:"20s"`
+	ProxyURL        string             `description:"Forward proxy to connect through, as http://host:port or socks5://host:port"`
+	ProxyUser       string             `description:"User name to authenticate to the proxy with"`
+	ProxyPass       string             `description:"Password to authenticate to the proxy with" redact:"true"`
+}
+
+// Prepare verifies the parameters are correct.
+func (cfg httpLongPollDialerCfg) Prepare() error {
+	if cfg.Cost <= 0.0 {
+		return fmt.Errorf("connection cost must be positive")
+	}
+	if _, err := newLinkCost(cfg.LatencyCost, cfg.BandwidthCost); err != nil {
+		return err
+	}
+	for node, cost := range cfg.NodeCost {
+		if cost <= 0.0 {
+			return fmt.Errorf("connection cost must be positive for %s", node)
+		}
+	}
+	u, err := url.Parse(cfg.Address)
+	if err != nil {
+		return fmt.Errorf("address %s is not a valid URL: %s", cfg.Address, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("address %s must use the http or https scheme", cfg.Address)
+	}
+	pollTimeout, err := time.ParseDuration(cfg.PollTimeout)
+	if err != nil {
+		return fmt.Errorf("invalid poll timeout %s: %s", cfg.PollTimeout, err)
+	}
+	if pollTimeout <= 0 || pollTimeout > maxLongPollTimeout {
+		return fmt.Errorf("poll timeout must be positive and at most %s", maxLongPollTimeout)
+	}
+	if cfg.ProxyURL != "" {
+		if _, err := ParseProxyURL(cfg.ProxyURL, cfg.ProxyUser, cfg.ProxyPass); err != nil {
+			return err
+		}
+	} else if cfg.ProxyUser != "" || cfg.ProxyPass != "" {
+		return fmt.Errorf("proxy credentials given without a proxy URL")
+	}
+	if _, _, err := parseRedialDelays(cfg.FirstRetryDelay, cfg.MaxRetryDelay); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Run runs the action.
+func (cfg httpLongPollDialerCfg) Run() error {
+	utils.RecordEffectiveConfig("http-longpoll-peer", cfg)
+	logger.Debug("Running HTTP long-poll peer connection %s\n", cfg.Address)
+	u, err := url.Parse(cfg.Address)
+	if err != nil {
+		return err
+	}
+	tlsCfgName := cfg.TLS
+	if u.Scheme == "https" && tlsCfgName == "" {
+		tlsCfgName = "default"
+	}
+	tlscfg, err := netceptor.MainInstance.GetClientTLSConfig(tlsCfgName, u.Hostname(), "dns")
+	if err != nil {
+		return err
+	}
+	b, err := NewHTTPLongPollDialer(cfg.Address, tlscfg, cfg.Redial)
+	if err != nil {
+		logger.Error("Error creating peer %s: %s\n", cfg.Address, err)
+
+		return err
+	}
+	first, max, err := parseRedialDelays(cfg.FirstRetryDelay, cfg.MaxRetryDelay)
+	if err != nil {
+		return err
+	}
+	b.SetRedialDelays(first, max)
+	pollTimeout, err := time.ParseDuration(cfg.PollTimeout)
+	if err != nil {
+		return err
+	}
+	b.SetPollTimeout(pollTimeout)
+	if cfg.ProxyURL != "" {
+		proxyURL, err := ParseProxyURL(cfg.ProxyURL, cfg.ProxyUser, cfg.ProxyPass)
+		if err != nil {
+			return err
+		}
+		b.SetProxy(proxyURL)
+	}
+	wb, err := wrapPSK(b, cfg.PSK, nil, false)
+	if err != nil {
+		return err
+	}
+	lc, err := newLinkCost(cfg.LatencyCost, cfg.BandwidthCost)
+	if err != nil {
+		return err
+	}
+
+	return netceptor.MainInstance.AddBackendWithOptions(wb, cfg.Cost, cfg.NodeCost, netceptor.BackendOptions{LinkCost: lc})
+}
+
+func (cfg httpLongPollDialerCfg) PreReload() error {
+	return cfg.Prepare()
+}
+
+func (cfg httpLongPollListenerCfg) PreReload() error {
+	return cfg.Prepare()
+}
+
+func (cfg httpLongPollDialerCfg) Reload() error {
+	return cfg.Run()
+}
+
+func (cfg httpLongPollListenerCfg) Reload() error {
+	return cfg.Run()
+}
+
+func (cfg httpLongPollListenerCfg) Init() error {
+	resetListenerClaims()
+
+	return nil
+}
+
+func (cfg httpLongPollListenerCfg) InitReload() error {
+	resetListenerClaims()
+
+	return nil
+}
+
+func (cfg httpLongPollListenerCfg) ValidateReload() error {
+	if err := checkListenerConflicts(false); err != nil {
+		return err
+	}
+
+	return probeListenAddresses()
+}
+
+func init() {
+	cmdline.RegisterConfigTypeForApp("receptor-backends",
+		"http-longpoll-listener", "Run an HTTP long-poll listener, for networks that block websockets",
+		httpLongPollListenerCfg{}, cmdline.Section(backendSection))
+	cmdline.RegisterConfigTypeForApp("receptor-backends",
+		"http-longpoll-peer", "Make an outbound HTTP long-poll connection to a peer, for networks that block websockets",
+		httpLongPollDialerCfg{}, cmdline.Section(backendSection))
+}
@@ -0,0 +1,336 @@
+package backends
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ansible/receptor/pkg/netceptor"
+)
+
+// longPollSessionPair connects a long-poll dialer to a listener and returns the dialer and listener sessions.
+func longPollSessionPair(ctx context.Context, t *testing.T) (netceptor.BackendSession, netceptor.BackendSession) {
+	li, err := NewHTTPLongPollListener("127.0.0.1:0", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	li.SetPath("/poll")
+	liChan, err := li.Start(ctx, &sync.WaitGroup{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := NewHTTPLongPollDialer(fmt.Sprintf("http://%s/poll", li.Addr()), nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.SetPollTimeout(time.Second)
+	dChan, err := d.Start(ctx, &sync.WaitGroup{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var dSess, liSess netceptor.BackendSession
+	for dSess == nil || liSess == nil {
+		select {
+		case dSess = <-dChan:
+		case liSess = <-liChan:
+		case <-time.After(10 * time.Second):
+			t.Fatal("timed out waiting for long-poll sessions")
+		}
+	}
+
+	return dSess, liSess
+}
+
+// longPollMessage returns the i'th test message, which has a varying size.
+func longPollMessage(i int) []byte {
+	return bytes.Repeat([]byte{byte(i)}, 1+(i*37)%5000)
+}
+
+// sendAndCheck sends count messages from one session to another, and checks they arrive intact and in order.
+func sendAndCheck(from netceptor.BackendSession, to netceptor.BackendSession, count int) error {
+	errChan := make(chan error, 1)
+	go func() {
+		for i := 0; i < count; i++ {
+			if err := from.Send(longPollMessage(i)); err != nil {
+				errChan <- err
+
+				return
+			}
+		}
+		errChan <- nil
+	}()
+	for i := 0; i < count; i++ {
+		msg, err := to.Recv(10 * time.Second)
+		if err != nil {
+			return fmt.Errorf("message %d: %w", i, err)
+		}
+		if !bytes.Equal(msg, longPollMessage(i)) {
+			return fmt.Errorf("message %d: got %d bytes, expected %d", i, len(msg), len(longPollMessage(i)))
+		}
+	}
+
+	return <-errChan
+}
+
+func TestLongPollRoundTrip(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	dSess, liSess := longPollSessionPair(ctx, t)
+
+	// More messages than the window, in both directions at once
+	count := 5 * longPollWindow
+	errChan := make(chan error, 2)
+	go func() { errChan <- sendAndCheck(dSess, liSess, count) }()
+	go func() { errChan <- sendAndCheck(liSess, dSess, count) }()
+	for i := 0; i < 2; i++ {
+		if err := <-errChan; err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestLongPollFlowControl(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	dSess, liSess := longPollSessionPair(ctx, t)
+
+	var sentLock sync.Mutex
+	sent := 0
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 10*longPollWindow; i++ {
+			if err := dSess.Send(longPollMessage(i)); err != nil {
+				return
+			}
+			sentLock.Lock()
+			sent++
+			sentLock.Unlock()
+		}
+	}()
+	// With nothing receiving, the dialer is held back once the windows are full
+	time.Sleep(500 * time.Millisecond)
+	sentLock.Lock()
+	held := sent
+	sentLock.Unlock()
+	if held >= 10*longPollWindow {
+		t.Fatalf("expected sends to block, but all %d were sent", held)
+	}
+	for i := 0; i < 10*longPollWindow; i++ {
+		msg, err := liSess.Recv(10 * time.Second)
+		if err != nil {
+			t.Fatalf("message %d: %s", i, err)
+		}
+		if !bytes.Equal(msg, longPollMessage(i)) {
+			t.Fatalf("message %d arrived out of order", i)
+		}
+	}
+	<-done
+}
+
+func TestLongPollSendTimeout(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	dSess, _ := longPollSessionPair(ctx, t)
+	if err := dSess.(netceptor.WriteDeadlineSession).SetWriteDeadline(time.Now().Add(300 * time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+	// With nothing receiving, sends block once the windows are full
+	start := time.Now()
+	var err error
+	for i := 0; i < 10*longPollWindow; i++ {
+		if err = dSess.Send(longPollMessage(i)); err != nil {
+			break
+		}
+	}
+	if !errors.Is(err, netceptor.ErrSendTimeout) {
+		t.Fatalf("expected a send timeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 300*time.Millisecond {
+		t.Fatalf("send timed out after %s, before the deadline", elapsed)
+	}
+}
+
+func TestLongPollClose(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Closing either end closes the other
+	dSess, liSess := longPollSessionPair(ctx, t)
+	_ = dSess.Close()
+	if _, err := liSess.Recv(10 * time.Second); err == nil || err == netceptor.ErrTimeout {
+		t.Fatalf("expected the listener session to close, got %v", err)
+	}
+	dSess, liSess = longPollSessionPair(ctx, t)
+	_ = liSess.Close()
+	if _, err := dSess.Recv(10 * time.Second); err == nil || err == netceptor.ErrTimeout {
+		t.Fatalf("expected the dialer session to close, got %v", err)
+	}
+	if err := dSess.Send([]byte("hello")); err == nil {
+		t.Fatal("expected send on a closed session to fail")
+	}
+}
+
+func TestLongPollDeliver(t *testing.T) {
+	s := newLongPollSession(nil)
+	msgs := [][]byte{[]byte("a"), []byte("b"), []byte("c")}
+	if err := s.deliver(0, msgs[:2], nil); err != nil {
+		t.Fatal(err)
+	}
+	// A retried request overlapping messages already received
+	if err := s.deliver(1, msgs[1:], nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.deliver(0, msgs[:1], nil); err != nil {
+		t.Fatal(err)
+	}
+	// A gap in the messages
+	if err := s.deliver(5, msgs, nil); err == nil {
+		t.Fatal("expected a gap in the messages to be refused")
+	}
+	for _, expected := range []string{"a", "b", "c"} {
+		msg, err := s.Recv(time.Second)
+		if err != nil || string(msg) != expected {
+			t.Fatalf("expected %s, got %s (%v)", expected, msg, err)
+		}
+	}
+	if _, err := s.Recv(10 * time.Millisecond); err != netceptor.ErrTimeout {
+		t.Fatalf("expected no more messages, got %v", err)
+	}
+}
+
+func TestLongPollAcknowledge(t *testing.T) {
+	s := newLongPollSession(nil)
+	for _, data := range []string{"a", "b", "c"} {
+		if err := s.Send([]byte(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	seq, msgs := s.pending(time.Second, nil)
+	if seq != 0 || len(msgs) != 3 {
+		t.Fatalf("expected 3 messages from 0, got %d from %d", len(msgs), seq)
+	}
+	if err := s.acknowledge(2); err != nil {
+		t.Fatal(err)
+	}
+	seq, msgs = s.pending(time.Second, nil)
+	if seq != 2 || len(msgs) != 1 || string(msgs[0]) != "c" {
+		t.Fatalf("expected message c at 2, got %d messages from %d", len(msgs), seq)
+	}
+	if err := s.acknowledge(1); err == nil {
+		t.Fatal("expected an acknowledgement of discarded messages to be refused")
+	}
+	if err := s.acknowledge(4); err == nil {
+		t.Fatal("expected an acknowledgement of unsent messages to be refused")
+	}
+	if err := s.acknowledge(3); err != nil {
+		t.Fatal(err)
+	}
+	if _, msgs := s.pending(10*time.Millisecond, nil); len(msgs) != 0 {
+		t.Fatalf("expected no pending messages, got %d", len(msgs))
+	}
+}
+
+func TestLongPollMesh(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	n1 := netceptor.New(ctx, "node1", nil)
+	defer n1.Shutdown()
+	n2 := netceptor.New(ctx, "node2", nil)
+	defer n2.Shutdown()
+	li, err := NewHTTPLongPollListener("127.0.0.1:0", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := n1.AddBackend(li, 1.0, nil); err != nil {
+		t.Fatal(err)
+	}
+	d, err := NewHTTPLongPollDialer(fmt.Sprintf("http://%s/", li.Addr()), nil, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := n2.AddBackend(d, 1.0, nil); err != nil {
+		t.Fatal(err)
+	}
+	waitForPathCost(t, n2, "node1", 1.0)
+	waitForPathCost(t, n1, "node2", 1.0)
+}
+
+func TestNewHTTPLongPollDialerScheme(t *testing.T) {
+	if _, err := NewHTTPLongPollDialer("ws://127.0.0.1:8080/", nil, true); err == nil {
+		t.Fatal("expected a ws URL to be refused")
+	}
+	if _, err := NewHTTPLongPollDialer("https://127.0.0.1:8080/", nil, true); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLongPollBufferBudget(t *testing.T) {
+	budget := netceptor.SessionBufferBudget
+	if err := budget.SetLimit(64 * 1024); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = budget.SetLimit(netceptor.DefaultSessionBufferLimit)
+	}()
+	before := budget.Status()
+
+	// Many sessions whose peers never acknowledge anything
+	const msgSize = 1000
+	sessions := make([]*longPollSession, 0)
+	errChan := make(chan error, 20)
+	for i := 0; i < 20; i++ {
+		s := newLongPollSession(nil)
+		sessions = append(sessions, s)
+		go func() {
+			for {
+				if err := s.Send(make([]byte, msgSize)); err != nil {
+					errChan <- err
+
+					return
+				}
+			}
+		}()
+	}
+	// Senders that fill their window wait for acknowledgements, and the rest are closed once the budget is
+	// used up, rather than waiting for room
+	shed := 0
+	deadline := time.After(5 * time.Second)
+	for shed == 0 {
+		select {
+		case err := <-errChan:
+			if err != netceptor.ErrSessionBufferFull {
+				t.Fatalf("expected a full session buffer, got %v", err)
+			}
+			shed++
+		case <-deadline:
+			t.Fatal("timed out waiting for a session to be closed")
+		}
+	}
+	status := budget.Status()
+	if status.Used > status.Limit {
+		t.Errorf("expected at most %d bytes buffered, got %d", status.Limit, status.Used)
+	}
+	if status.Refused == before.Refused {
+		t.Error("expected sends to be refused buffer space")
+	}
+
+	// Closing the sessions returns their buffers and releases the waiting senders
+	for _, s := range sessions {
+		_ = s.Close()
+	}
+	for i := shed; i < len(sessions); i++ {
+		select {
+		case <-errChan:
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for senders to fail on closed sessions")
+		}
+	}
+	if used := budget.Used(); used != before.Used {
+		t.Errorf("expected %d bytes buffered after closing, got %d", before.Used, used)
+	}
+}
@@ -0,0 +1,240 @@
+//go:build !no_backends
+// +build !no_backends
+
+package backends
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ansible/receptor/pkg/logger"
+	"github.com/ansible/receptor/pkg/netceptor"
+)
+
+// ErrPSKAuthFailed indicates that the remote end of a session did not prove knowledge of the pre-shared key.
+var ErrPSKAuthFailed = errors.New("pre-shared key authentication failed")
+
+const (
+	pskNonceLen         = 32
+	pskHandshakeTimeout = 10 * time.Second
+)
+
+// PSK handshake message types.  The dialer always speaks first, because some
+// listeners (such as UDP) do not know about a session until they receive data.
+// The dialer also proves itself first, so a listener with several keys can
+// answer with the proof for whichever key the dialer used.
+const (
+	pskMsgHello    = 1 // dialer -> listener: dialer nonce
+	pskMsgServer   = 2 // listener -> dialer: listener nonce
+	pskMsgClient   = 3 // dialer -> listener: dialer proof
+	pskMsgAccepted = 4 // listener -> dialer: listener proof
+)
+
+var pskMagic = []byte("RPSK")
+
+// PSKBackend wraps another backend, requiring every session to complete an HMAC
+// challenge-response using a pre-shared key before it is handed to Netceptor.
+// The key itself is never sent over the wire.
+type PSKBackend struct {
+	backend  netceptor.Backend
+	keys     [][]byte
+	listener bool
+}
+
+// NewPSKBackend wraps a backend with pre-shared key authentication.  Set listener
+// to true if the wrapped backend accepts inbound sessions.
+func NewPSKBackend(backend netceptor.Backend, psk string, listener bool) (*PSKBackend, error) {
+	return NewPSKBackendWithKeys(backend, []string{psk}, listener)
+}
+
+// NewPSKBackendWithKeys wraps a backend with pre-shared key authentication using an ordered list of keys.
+// Dialers authenticate with the first key.  Listeners accept any of the keys, so the key can be rotated
+// without dropping links: add the new key first on the listeners, move the dialers to it, and then remove
+// the old key from the listeners.
+func NewPSKBackendWithKeys(backend netceptor.Backend, keys []string, listener bool) (*PSKBackend, error) {
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("pre-shared key must not be empty")
+	}
+	b := &PSKBackend{
+		backend:  backend,
+		listener: listener,
+	}
+	for _, key := range keys {
+		if key == "" {
+			return nil, fmt.Errorf("pre-shared key must not be empty")
+		}
+		b.keys = append(b.keys, []byte(key))
+	}
+
+	return b, nil
+}
+
+// String returns a description of the wrapped backend.
+func (b *PSKBackend) String() string {
+	if s, ok := b.backend.(fmt.Stringer); ok {
+		return s.String()
+	}
+
+	return fmt.Sprintf("%T", b.backend)
+}
+
+// Start starts the wrapped backend and authenticates each session it produces.
+func (b *PSKBackend) Start(ctx context.Context, wg *sync.WaitGroup) (chan netceptor.BackendSession, error) {
+	innerChan, err := b.backend.Start(ctx, wg)
+	if err != nil {
+		return nil, err
+	}
+	sessChan := make(chan netceptor.BackendSession)
+	go func() {
+		handshakeWg := sync.WaitGroup{}
+		defer func() {
+			handshakeWg.Wait()
+			close(sessChan)
+		}()
+		for sess := range innerChan {
+			handshakeWg.Add(1)
+			go func(sess netceptor.BackendSession) {
+				defer handshakeWg.Done()
+				var err error
+				if b.listener {
+					err = b.listenerHandshake(sess)
+				} else {
+					err = b.dialerHandshake(sess)
+				}
+				if err != nil {
+					logger.Error("Rejecting backend session: %s\n", err)
+					reportDialError(ctx, fmt.Errorf("pre-shared key handshake failed: %w", err))
+					_ = sess.Close()
+
+					return
+				}
+				select {
+				case sessChan <- sess:
+				case <-ctx.Done():
+					_ = sess.Close()
+				}
+			}(sess)
+		}
+	}()
+
+	return sessChan, nil
+}
+
+// pskProof computes the HMAC proving knowledge of a key, bound to both nonces and the role of the sender.
+func pskProof(key []byte, role string, ownNonce []byte, peerNonce []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	_, _ = mac.Write([]byte(role))
+	_, _ = mac.Write(peerNonce)
+	_, _ = mac.Write(ownNonce)
+
+	return mac.Sum(nil)
+}
+
+func pskSend(sess netceptor.BackendSession, msgType byte, parts ...[]byte) error {
+	msg := append([]byte{}, pskMagic...)
+	msg = append(msg, msgType)
+	for _, p := range parts {
+		msg = append(msg, p...)
+	}
+
+	return sess.Send(msg)
+}
+
+// pskRecv receives a handshake message of the given type and returns its payload.
+func pskRecv(sess netceptor.BackendSession, msgType byte, payloadLen int) ([]byte, error) {
+	msg, err := sess.Recv(pskHandshakeTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("error receiving PSK handshake: %w", err)
+	}
+	if len(msg) != len(pskMagic)+1+payloadLen || !bytes.Equal(msg[:len(pskMagic)], pskMagic) ||
+		msg[len(pskMagic)] != msgType {
+		return nil, fmt.Errorf("%w: unexpected handshake message", ErrPSKAuthFailed)
+	}
+
+	return msg[len(pskMagic)+1:], nil
+}
+
+func pskNonce() ([]byte, error) {
+	nonce := make([]byte, pskNonceLen)
+	_, err := rand.Read(nonce)
+
+	return nonce, err
+}
+
+func (b *PSKBackend) dialerHandshake(sess netceptor.BackendSession) error {
+	nonce, err := pskNonce()
+	if err != nil {
+		return err
+	}
+	if err := pskSend(sess, pskMsgHello, nonce); err != nil {
+		return err
+	}
+	peerNonce, err := pskRecv(sess, pskMsgServer, pskNonceLen)
+	if err != nil {
+		return err
+	}
+	key := b.keys[0]
+	if err := pskSend(sess, pskMsgClient, pskProof(key, "dialer", nonce, peerNonce)); err != nil {
+		return err
+	}
+	proof, err := pskRecv(sess, pskMsgAccepted, sha256.Size)
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal(proof, pskProof(key, "listener", peerNonce, nonce)) {
+		return ErrPSKAuthFailed
+	}
+
+	return nil
+}
+
+func (b *PSKBackend) listenerHandshake(sess netceptor.BackendSession) error {
+	peerNonce, err := pskRecv(sess, pskMsgHello, pskNonceLen)
+	if err != nil {
+		return err
+	}
+	nonce, err := pskNonce()
+	if err != nil {
+		return err
+	}
+	if err := pskSend(sess, pskMsgServer, nonce); err != nil {
+		return err
+	}
+	proof, err := pskRecv(sess, pskMsgClient, sha256.Size)
+	if err != nil {
+		return err
+	}
+	for i, key := range b.keys {
+		if !hmac.Equal(proof, pskProof(key, "dialer", peerNonce, nonce)) {
+			continue
+		}
+		if i > 0 {
+			logger.Info("Backend session authenticated with previous pre-shared key %d\n", i)
+		}
+
+		return pskSend(sess, pskMsgAccepted, pskProof(key, "listener", nonce, peerNonce))
+	}
+
+	return ErrPSKAuthFailed
+}
+
+// wrapPSK wraps a backend with PSK authentication if a key is configured.  Listeners also accept
+// the previous keys, which dialers may still be using while the key is rotated.
+func wrapPSK(backend netceptor.Backend, psk string, previous []string, listener bool) (netceptor.Backend, error) {
+	if psk == "" {
+		if len(previous) > 0 {
+			return nil, fmt.Errorf("previous pre-shared keys require a current pre-shared key")
+		}
+
+		return backend, nil
+	}
+
+	return NewPSKBackendWithKeys(backend, append([]string{psk}, previous...), listener)
+}
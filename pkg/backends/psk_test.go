@@ -0,0 +1,94 @@
+package backends
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ansible/receptor/pkg/netceptor"
+)
+
+// pskConnect sets up a loopback TCP listener and dialer with the given keys, and reports
+// whether the two nodes established a connection within the timeout.
+func pskConnect(t *testing.T, listenerPSK string, dialerPSK string, timeout time.Duration, previous ...string) bool {
+	n1 := netceptor.New(context.Background(), "node1", nil)
+	defer n1.Shutdown()
+	li, err := NewTCPListener("localhost:0", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wli, err := wrapPSK(li, listenerPSK, previous, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = n1.AddBackend(wli, 1.0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n2 := netceptor.New(context.Background(), "node2", nil)
+	defer n2.Shutdown()
+	d, err := NewTCPDialer(li.Addr().String(), true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wd, err := wrapPSK(d, dialerPSK, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = n2.AddBackend(wd, 1.0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		_, ok1 := n1.Status().RoutingTable["node2"]
+		_, ok2 := n2.Status().RoutingTable["node1"]
+		if ok1 && ok2 {
+			return true
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	return false
+}
+
+func TestPSKMatching(t *testing.T) {
+	if !pskConnect(t, "secret", "secret", 10*time.Second) {
+		t.Fatal("nodes with matching pre-shared keys did not connect")
+	}
+}
+
+func TestPSKMismatch(t *testing.T) {
+	if pskConnect(t, "secret", "wrong", 3*time.Second) {
+		t.Fatal("nodes with mismatched pre-shared keys connected")
+	}
+}
+
+func TestPSKMissing(t *testing.T) {
+	if pskConnect(t, "secret", "", 3*time.Second) {
+		t.Fatal("dialer without a pre-shared key connected to a listener requiring one")
+	}
+}
+
+func TestPSKRotation(t *testing.T) {
+	if !pskConnect(t, "new", "old", 10*time.Second, "old") {
+		t.Fatal("dialer with the previous pre-shared key did not connect during rotation")
+	}
+	if !pskConnect(t, "new", "new", 10*time.Second, "old") {
+		t.Fatal("dialer with the new pre-shared key did not connect during rotation")
+	}
+	if pskConnect(t, "new", "old", 3*time.Second) {
+		t.Fatal("dialer with a removed pre-shared key connected")
+	}
+}
+
+func TestPSKKeysValidation(t *testing.T) {
+	if _, err := wrapPSK(nil, "", []string{"old"}, true); err == nil {
+		t.Fatal("expected previous keys without a current key to be rejected")
+	}
+	if _, err := wrapPSK(nil, "new", []string{""}, true); err == nil {
+		t.Fatal("expected an empty previous key to be rejected")
+	}
+}
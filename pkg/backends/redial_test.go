@@ -0,0 +1,85 @@
+package backends
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ansible/receptor/pkg/netceptor"
+)
+
+func TestDialerSessionRedialDelays(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := &sync.WaitGroup{}
+	lock := &sync.Mutex{}
+	attempts := make([]time.Time, 0)
+	df := func(closeChan chan struct{}) (netceptor.BackendSession, error) {
+		lock.Lock()
+		defer lock.Unlock()
+		attempts = append(attempts, time.Now())
+		if len(attempts) == 6 {
+			cancel()
+		}
+
+		return nil, fmt.Errorf("refused")
+	}
+	delays := redialDelays{first: 10 * time.Millisecond, backoff: 100 * time.Millisecond, max: 200 * time.Millisecond}
+	if _, err := dialerSession(ctx, wg, true, delays, df); err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+
+	lock.Lock()
+	defer lock.Unlock()
+	if len(attempts) != 6 {
+		t.Fatalf("expected 6 dial attempts, got %d", len(attempts))
+	}
+	gaps := make([]time.Duration, 0)
+	for i := 1; i < len(attempts); i++ {
+		gaps = append(gaps, attempts[i].Sub(attempts[i-1]))
+	}
+	// The first retry is quick, then the delay backs off from 100ms by half each time, up to 200ms
+	if gaps[0] >= 90*time.Millisecond {
+		t.Fatalf("expected a quick first retry, waited %s", gaps[0])
+	}
+	wantMin := []time.Duration{100 * time.Millisecond, 150 * time.Millisecond, 200 * time.Millisecond, 200 * time.Millisecond}
+	for i, want := range wantMin {
+		gap := gaps[i+1]
+		if gap < want || gap > want+time.Second {
+			t.Fatalf("expected retry %d after about %s, waited %s", i+2, want, gap)
+		}
+	}
+}
+
+func TestParseRedialDelays(t *testing.T) {
+	first, max, err := parseRedialDelays("", "")
+	if err != nil || first != DefaultFirstRetryDelay || max != DefaultMaxRetryDelay {
+		t.Fatalf("expected default delays, got %s, %s, %v", first, max, err)
+	}
+	first, max, err = parseRedialDelays("0s", "1m")
+	if err != nil || first != 0 || max != time.Minute {
+		t.Fatalf("expected 0s and 1m, got %s, %s, %v", first, max, err)
+	}
+	for _, bad := range [][2]string{{"soon", "20s"}, {"1s", "later"}, {"-1s", "20s"}, {"1s", "0s"}} {
+		if _, _, err := parseRedialDelays(bad[0], bad[1]); err == nil {
+			t.Fatalf("expected an error for first %s and max %s", bad[0], bad[1])
+		}
+	}
+	first, max, err = redialDelaysOrDefault(nil, nil)
+	if err != nil || first != DefaultFirstRetryDelay || max != DefaultMaxRetryDelay {
+		t.Fatalf("expected default delays, got %s, %s, %v", first, max, err)
+	}
+	zero, minute := time.Duration(0), time.Minute
+	first, max, err = redialDelaysOrDefault(&zero, &minute)
+	if err != nil || first != 0 || max != time.Minute {
+		t.Fatalf("expected 0s and 1m, got %s, %s, %v", first, max, err)
+	}
+	if _, _, err := redialDelaysOrDefault(nil, &zero); err == nil {
+		t.Fatal("expected an error for a zero max delay")
+	}
+	if d := newRedialDelays(time.Minute, 2*time.Second); d.first != 2*time.Second || d.backoff != 2*time.Second {
+		t.Fatalf("expected delays capped to the max, got %+v", d)
+	}
+}
@@ -0,0 +1,167 @@
+//go:build !no_backends
+// +build !no_backends
+
+package backends
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/ansible/receptor/pkg/logger"
+)
+
+// retainRegistry holds the listeners on privileged ports that were stopped by a reload, so that the
+// reloaded config can keep using them.  Binding a privileged port needs root or CAP_NET_BIND_SERVICE,
+// which receptor may no longer have by the time it is reloaded.  Listeners are keyed by their network
+// and configured address, so the reloaded config must configure the same address to reuse one.
+type retainRegistry struct {
+	lock      sync.Mutex
+	listeners map[string]retainedSocket
+}
+
+// retainedSocket is a listening socket kept bound between runs of a backend.
+type retainedSocket struct {
+	address string
+	li      *net.TCPListener
+}
+
+var retained = &retainRegistry{
+	listeners: make(map[string]retainedSocket),
+}
+
+// privilegedPortStart returns the first port that can be bound without privileges.  It is a variable
+// so tests can treat ordinary ports as privileged.
+var privilegedPortStart = unprivilegedPortStart
+
+// isPrivilegedAddress returns true if binding address needs privileges.
+func isPrivilegedAddress(address string) bool {
+	_, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return false
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return false
+	}
+
+	return port > 0 && port < privilegedPortStart()
+}
+
+func retainKey(network string, address string) string {
+	return network + " " + address
+}
+
+// listenRetained binds address with opts, or reuses the listener retained for it by a previous run of the backend.
+// Listeners on privileged ports are returned wrapped so that closing them retains the socket for reuse
+// rather than closing it; release must be called once the listener is no longer accepting, and either
+// retains or closes the socket.
+func listenRetained(network string, address string, opts listenSocketOptions) (net.Listener, func(retain bool), error) {
+	key := retainKey(network, address)
+	retained.lock.Lock()
+	rs, ok := retained.listeners[key]
+	delete(retained.listeners, key)
+	retained.lock.Unlock()
+	tli := rs.li
+	if ok {
+		if err := tli.SetDeadline(time.Time{}); err != nil {
+			_ = tli.Close()
+
+			return nil, nil, fmt.Errorf("could not reuse listener on %s: %w", address, err)
+		}
+		logger.Debug("Reusing retained listener on privileged address %s\n", address)
+	} else {
+		li, err := opts.listen(context.Background(), network, address)
+		if err != nil {
+			if errors.Is(err, syscall.EACCES) && isPrivilegedAddress(address) {
+				err = fmt.Errorf("%w (binding a privileged port needs root or CAP_NET_BIND_SERVICE)", err)
+			}
+
+			return nil, nil, err
+		}
+		tli, ok = li.(*net.TCPListener)
+		if !ok || !isPrivilegedAddress(address) {
+			return li, func(bool) { _ = li.Close() }, nil
+		}
+	}
+	rl := &retainedListener{TCPListener: tli}
+	release := func(retain bool) {
+		if !retain {
+			_ = tli.Close()
+
+			return
+		}
+		retained.lock.Lock()
+		defer retained.lock.Unlock()
+		if old, ok := retained.listeners[key]; ok && old.li != tli {
+			_ = old.li.Close()
+		}
+		retained.listeners[key] = retainedSocket{address: address, li: tli}
+	}
+
+	return rl, release, nil
+}
+
+// releaseUnclaimedRetained closes the retained listeners whose addresses are not claimed by a listener
+// config in claims, because the reloaded config no longer uses them.
+func releaseUnclaimedRetained(claims []listenClaim) {
+	retained.lock.Lock()
+	defer retained.lock.Unlock()
+	for key, rs := range retained.listeners {
+		used := false
+		for _, claim := range claims {
+			if rs.address == net.JoinHostPort(claim.host, claim.port) {
+				used = true
+
+				break
+			}
+		}
+		if !used {
+			logger.Debug("Closing retained listener %s that is no longer configured\n", key)
+			_ = rs.li.Close()
+			delete(retained.listeners, key)
+		}
+	}
+}
+
+// retainedListener is a listener on a privileged port whose Close stops accepting connections without
+// closing the socket.
+type retainedListener struct {
+	*net.TCPListener
+	lock   sync.Mutex
+	closed bool
+}
+
+// Accept waits for and returns the next connection to the listener.
+func (li *retainedListener) Accept() (net.Conn, error) {
+	conn, err := li.TCPListener.Accept()
+	li.lock.Lock()
+	closed := li.closed
+	li.lock.Unlock()
+	if closed {
+		if conn != nil {
+			_ = conn.Close()
+		}
+
+		return nil, net.ErrClosed
+	}
+
+	return conn, err
+}
+
+// Close stops accepting connections, leaving the socket bound.
+func (li *retainedListener) Close() error {
+	li.lock.Lock()
+	defer li.lock.Unlock()
+	if li.closed {
+		return nil
+	}
+	li.closed = true
+
+	return li.TCPListener.SetDeadline(time.Now())
+}
@@ -0,0 +1,25 @@
+//go:build !no_backends
+// +build !no_backends
+
+package backends
+
+import (
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// unprivilegedPortStart returns the first port that can be bound without CAP_NET_BIND_SERVICE, which
+// Linux makes configurable.
+func unprivilegedPortStart() int {
+	data, err := ioutil.ReadFile("/proc/sys/net/ipv4/ip_unprivileged_port_start")
+	if err != nil {
+		return 1024
+	}
+	port, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return 1024
+	}
+
+	return port
+}
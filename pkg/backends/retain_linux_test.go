@@ -0,0 +1,142 @@
+package backends
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// retainedSocketFor returns the socket retained for a tcp address, or nil if there is none.
+func retainedSocketFor(address string) *net.TCPListener {
+	retained.lock.Lock()
+	defer retained.lock.Unlock()
+
+	return retained.listeners[retainKey("tcp", address)].li
+}
+
+func TestWebsocketListenerRetainsPrivilegedPort(t *testing.T) {
+	// Treat every port as privileged, so the test does not need to bind one
+	privilegedPortStart = func() int { return 65536 }
+	defer func() {
+		privilegedPortStart = unprivilegedPortStart
+		releaseUnclaimedRetained(nil)
+	}()
+	probe, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := probe.Addr().String()
+	_ = probe.Close()
+
+	ctx1, cancel1 := context.WithCancel(context.Background())
+	wg1 := &sync.WaitGroup{}
+	li1, err := NewWebsocketListener(address, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := li1.Start(ctx1, wg1); err != nil {
+		t.Fatal(err)
+	}
+	cancel1()
+	wg1.Wait()
+
+	// Stopping the backend, as a reload does, keeps the socket bound
+	tli := retainedSocketFor(address)
+	if tli == nil {
+		t.Fatal("expected the listener to be retained")
+	}
+	if li, err := net.Listen("tcp", address); err == nil {
+		_ = li.Close()
+		t.Fatal("expected the retained socket to still be bound")
+	}
+
+	// The reloaded listener reuses the socket rather than binding a new one
+	ctx2, cancel2 := context.WithCancel(context.Background())
+	defer cancel2()
+	li2, err := NewWebsocketListener(address, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lsc, err := li2.Start(ctx2, &sync.WaitGroup{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if retainedSocketFor(address) != nil {
+		t.Fatal("expected the retained listener to be taken")
+	}
+	rl, ok := li2.li.(*retainedListener)
+	if !ok || rl.TCPListener != tli {
+		t.Fatalf("expected the retained socket to be reused, got %v", li2.li)
+	}
+	d, err := NewWebsocketDialer(fmt.Sprintf("ws://%s/", address), nil, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Start(ctx2, &sync.WaitGroup{}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-lsc:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out connecting to the reused listener")
+	}
+}
+
+func TestReleaseUnclaimedRetained(t *testing.T) {
+	privilegedPortStart = func() int { return 65536 }
+	defer func() {
+		privilegedPortStart = unprivilegedPortStart
+		releaseUnclaimedRetained(nil)
+	}()
+	// Port 0 is never privileged, so an ephemeral listener is not retained
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := &sync.WaitGroup{}
+	li, err := NewWebsocketListener("127.0.0.1:0", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := li.Start(ctx, wg); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	wg.Wait()
+	if retainedSocketFor("127.0.0.1:0") != nil {
+		t.Fatal("expected an ephemeral port not to be retained")
+	}
+
+	probe, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, port, _ := net.SplitHostPort(probe.Addr().String())
+	_ = probe.Close()
+	ctx, cancel = context.WithCancel(context.Background())
+	wg = &sync.WaitGroup{}
+	li, err = NewWebsocketListener(net.JoinHostPort("127.0.0.1", port), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := li.Start(ctx, wg); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	wg.Wait()
+
+	// A reloaded config that still claims the address keeps the socket, and one that does not closes it
+	releaseUnclaimedRetained([]listenClaim{{network: "tcp", host: "127.0.0.1", port: port}})
+	if retainedSocketFor(net.JoinHostPort("127.0.0.1", port)) == nil {
+		t.Fatal("expected the claimed socket to stay retained")
+	}
+	releaseUnclaimedRetained([]listenClaim{{network: "tcp", host: "127.0.0.1", port: "1"}})
+	if retainedSocketFor(net.JoinHostPort("127.0.0.1", port)) != nil {
+		t.Fatal("expected the unclaimed socket to be closed")
+	}
+	rebind, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", port))
+	if err != nil {
+		t.Fatalf("expected the port to be free again: %s", err)
+	}
+	_ = rebind.Close()
+}
@@ -0,0 +1,9 @@
+//go:build !linux && !no_backends
+// +build !linux,!no_backends
+
+package backends
+
+// unprivilegedPortStart returns the first port that can be bound without privileges.
+func unprivilegedPortStart() int {
+	return 1024
+}
@@ -0,0 +1,307 @@
+//go:build !no_stdio_backend && !no_backends
+// +build !no_stdio_backend,!no_backends
+
+package backends
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/ansible/receptor/pkg/framer"
+	"github.com/ansible/receptor/pkg/logger"
+	"github.com/ansible/receptor/pkg/netceptor"
+	"github.com/ansible/receptor/pkg/utils"
+	"github.com/ghjm/cmdline"
+	"github.com/google/shlex"
+)
+
+// StdioBackend implements Backend over a pair of streams, such as the stdin and stdout of this process, or
+// of a child process that it starts.
+type StdioBackend struct {
+	reader       io.Reader
+	writer       io.Writer
+	closer       func() error
+	command      string
+	args         []string
+	redial       bool
+	redialDelays redialDelays
+}
+
+// NewStdioBackend instantiates a backend that reads messages from reader and writes them to writer.  It has
+// a single session, which calls closer, if given, when it is closed.
+func NewStdioBackend(reader io.Reader, writer io.Writer, closer func() error) *StdioBackend {
+	return &StdioBackend{
+		reader:       reader,
+		writer:       writer,
+		closer:       closer,
+		redialDelays: defaultRedialDelays(),
+	}
+}
+
+// NewStdioCommandBackend instantiates a backend that runs a command and connects to it over its stdin and
+// stdout.  The command's stderr goes to this process's stderr.  If redial is true, the command is run again
+// whenever it exits.
+func NewStdioCommandBackend(command string, args []string, redial bool) *StdioBackend {
+	return &StdioBackend{
+		command:      command,
+		args:         args,
+		redial:       redial,
+		redialDelays: defaultRedialDelays(),
+	}
+}
+
+// SetRedialDelays sets how long the backend waits to run its command again after it exits or fails to start:
+// first for the first retry, then backing off up to max.  It is only effective if used prior to calling Start.
+func (b *StdioBackend) SetRedialDelays(first time.Duration, max time.Duration) {
+	b.redialDelays = newRedialDelays(first, max)
+}
+
+// String returns a description of the backend.
+func (b *StdioBackend) String() string {
+	if b.command == "" {
+		return "stdio-peer"
+	}
+
+	return "stdio-peer " + b.command
+}
+
+// Start runs the given session function over the StdioBackend.
+func (b *StdioBackend) Start(ctx context.Context, wg *sync.WaitGroup) (chan netceptor.BackendSession, error) {
+	if b.command == "" {
+		// The streams cannot be reopened, so there is only ever one session
+		return dialerSession(ctx, wg, false, b.redialDelays,
+			func(closeChan chan struct{}) (netceptor.BackendSession, error) {
+				return newStdioSession(b.reader, b.writer, b.closer, closeChan), nil
+			})
+	}
+
+	return dialerSession(ctx, wg, b.redial, b.redialDelays,
+		func(closeChan chan struct{}) (netceptor.BackendSession, error) {
+			cmd := exec.CommandContext(ctx, b.command, b.args...)
+			cmd.Stderr = os.Stderr
+			stdin, err := cmd.StdinPipe()
+			if err != nil {
+				return nil, err
+			}
+			stdout, err := cmd.StdoutPipe()
+			if err != nil {
+				return nil, err
+			}
+			if err := cmd.Start(); err != nil {
+				return nil, err
+			}
+			logger.Debug("Started stdio peer command %s with PID %d\n", b.command, cmd.Process.Pid)
+
+			return newStdioSession(stdout, stdin, func() error {
+				_ = stdin.Close()
+				_ = cmd.Process.Kill()
+				_ = cmd.Wait()
+
+				return nil
+			}, closeChan), nil
+		})
+}
+
+// StdioSession implements BackendSession over a pair of streams.  Each message is prefixed with its length.
+type StdioSession struct {
+	writer          io.Writer
+	closer          func() error
+	framer          framer.Framer
+	writeLock       sync.Mutex
+	recvChan        chan []byte
+	recvErr         error
+	closeChan       chan struct{}
+	closeOnce       sync.Once
+	doneChan        chan struct{}
+	closeChanCloser sync.Once
+}
+
+// newStdioSession allocates a new StdioSession, and starts reading messages from reader.
+func newStdioSession(reader io.Reader, writer io.Writer, closer func() error, closeChan chan struct{}) *StdioSession {
+	ss := &StdioSession{
+		writer:    writer,
+		closer:    closer,
+		framer:    framer.New(),
+		recvChan:  make(chan []byte),
+		closeChan: closeChan,
+		doneChan:  make(chan struct{}),
+	}
+	go ss.readLoop(reader)
+
+	return ss
+}
+
+// readLoop reads messages from the stream until it fails or the session is closed.  Streams such as pipes
+// cannot be given a read deadline, so Recv waits for the messages read here instead.
+func (ss *StdioSession) readLoop(reader io.Reader) {
+	defer close(ss.recvChan)
+	fr := framer.New()
+	buf := make([]byte, utils.NormalBufferSize)
+	for {
+		n, err := reader.Read(buf)
+		if n > 0 {
+			fr.RecvData(buf[:n])
+			for fr.MessageReady() {
+				msg, merr := fr.GetMessage()
+				if merr != nil {
+					ss.recvErr = merr
+
+					return
+				}
+				select {
+				case ss.recvChan <- msg:
+				case <-ss.doneChan:
+					return
+				}
+			}
+		}
+		if err != nil {
+			ss.recvErr = err
+
+			return
+		}
+	}
+}
+
+// Send sends data over the session.
+func (ss *StdioSession) Send(data []byte) error {
+	buf := ss.framer.SendData(data)
+	ss.writeLock.Lock()
+	defer ss.writeLock.Unlock()
+	n, err := ss.writer.Write(buf)
+	if err != nil {
+		return sendError(err)
+	}
+	if n != len(buf) {
+		return fmt.Errorf("partial data sent")
+	}
+
+	return nil
+}
+
+// SetWriteDeadline sets the time by which sends must complete, after which Send fails with an error wrapping
+// netceptor.ErrSendTimeout.  A timed out send may have written part of a frame, so the session cannot be
+// used after one.  The zero time disables the deadline.  It returns os.ErrNoDeadline if the writer, such as
+// a regular file or a writer that is not a file, does not support deadlines.
+func (ss *StdioSession) SetWriteDeadline(t time.Time) error {
+	wd, ok := ss.writer.(interface{ SetWriteDeadline(time.Time) error })
+	if !ok {
+		return os.ErrNoDeadline
+	}
+
+	return wd.SetWriteDeadline(t)
+}
+
+// Recv receives data via the session.
+func (ss *StdioSession) Recv(timeout time.Duration) ([]byte, error) {
+	select {
+	case <-ss.doneChan:
+		return nil, io.EOF
+	default:
+	}
+	select {
+	case msg, ok := <-ss.recvChan:
+		if !ok {
+			return nil, ss.recvErr
+		}
+
+		return msg, nil
+	case <-time.After(timeout):
+		return nil, netceptor.ErrTimeout
+	case <-ss.doneChan:
+		return nil, io.EOF
+	}
+}
+
+// Close closes the session.
+func (ss *StdioSession) Close() error {
+	var err error
+	ss.closeOnce.Do(func() {
+		close(ss.doneChan)
+		if ss.closer != nil {
+			err = ss.closer()
+		}
+	})
+	if ss.closeChan != nil {
+		ss.closeChanCloser.Do(func() {
+			close(ss.closeChan)
+		})
+	}
+
+	return err
+}
+
+// **************************************************************************
+// Command line
+// **************************************************************************
+
+// stdioDialerCfg is the cmdline configuration object for a stdio peer.
+type stdioDialerCfg struct {
+	Command         string  `description:"Command to run and connect to over its stdin and stdout. If empty, the stdin and stdout of this process are used."`
+	Params          string  `description:"Command-line parameters of the command"`
+	Redial          bool    `description:"Run the command again when it exits" default:"true"`
+	FirstRetryDelay string  `description:"Delay before the first rerun after the command exits or fails to start" default:"100ms"`
+	MaxRetryDelay   string  `description:"Longest delay between reruns as failures continue" default:"20s"`
+	Cost            float64 `description:"Connection cost (weight)" default:"1.0"`
+}
+
+// Prepare verifies the parameters are correct.
+func (cfg stdioDialerCfg) Prepare() error {
+	if cfg.Cost <= 0.0 {
+		return fmt.Errorf("connection cost must be positive")
+	}
+	if cfg.Command == "" && cfg.Params != "" {
+		return fmt.Errorf("params require a command")
+	}
+	if _, err := shlex.Split(cfg.Params); err != nil {
+		return fmt.Errorf("invalid params: %s", err)
+	}
+	if _, _, err := parseRedialDelays(cfg.FirstRetryDelay, cfg.MaxRetryDelay); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Init moves log output off stdout as early as possible when it will carry the mesh protocol, so that nothing
+// logged while the rest of the config is prepared corrupts the stream.
+func (cfg stdioDialerCfg) Init() error {
+	if cfg.Command == "" {
+		logger.LogToStderr()
+	}
+
+	return nil
+}
+
+// Run runs the action.
+func (cfg stdioDialerCfg) Run() error {
+	utils.RecordEffectiveConfig("stdio-peer", cfg)
+	var b *StdioBackend
+	if cfg.Command == "" {
+		b = NewStdioBackend(os.Stdin, os.Stdout, nil)
+	} else {
+		args, err := shlex.Split(cfg.Params)
+		if err != nil {
+			return err
+		}
+		b = NewStdioCommandBackend(cfg.Command, args, cfg.Redial)
+		first, max, err := parseRedialDelays(cfg.FirstRetryDelay, cfg.MaxRetryDelay)
+		if err != nil {
+			return err
+		}
+		b.SetRedialDelays(first, max)
+	}
+
+	return netceptor.MainInstance.AddBackend(b, cfg.Cost, nil)
+}
+
+func init() {
+	cmdline.RegisterConfigTypeForApp("receptor-backends",
+		"stdio-peer", "Make a backend connection over stdin and stdout, of this process or of a command it runs",
+		stdioDialerCfg{}, cmdline.Section(backendSection))
+}
@@ -0,0 +1,170 @@
+//go:build !no_stdio_backend && !no_backends
+// +build !no_stdio_backend,!no_backends
+
+package backends
+
+import (
+	"context"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ansible/receptor/pkg/logger"
+	"github.com/ansible/receptor/pkg/netceptor"
+)
+
+// stdioPipePair returns two backends joined by a pair of OS pipes, as if each were the other's child process.
+func stdioPipePair(t *testing.T) (*StdioBackend, *StdioBackend) {
+	r1, w1, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r2, w2, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b1 := NewStdioBackend(r1, w2, func() error {
+		_ = w2.Close()
+
+		return r1.Close()
+	})
+	b2 := NewStdioBackend(r2, w1, func() error {
+		_ = w1.Close()
+
+		return r2.Close()
+	})
+
+	return b1, b2
+}
+
+func TestStdioSession(t *testing.T) {
+	r1, w1, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r2, w2, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s1 := newStdioSession(r1, w2, func() error {
+		_ = w2.Close()
+
+		return r1.Close()
+	}, nil)
+	s2 := newStdioSession(r2, w1, func() error {
+		_ = w1.Close()
+
+		return r2.Close()
+	}, nil)
+	defer s2.Close()
+
+	if err := sendAndCheck(s1, s2, 100); err != nil {
+		t.Fatal(err)
+	}
+	if err := sendAndCheck(s2, s1, 100); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s2.Recv(10 * time.Millisecond); err != netceptor.ErrTimeout {
+		t.Fatalf("expected a timeout with nothing sent, got %v", err)
+	}
+
+	// Closing one end is seen as the end of the stream at the other
+	if err := s1.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s2.Recv(5 * time.Second); err != io.EOF {
+		t.Fatalf("expected EOF after the peer closed, got %v", err)
+	}
+	if _, err := s1.Recv(time.Second); err != io.EOF {
+		t.Fatalf("expected EOF from a closed session, got %v", err)
+	}
+}
+
+func TestStdioMesh(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	n1 := netceptor.New(ctx, "node1", nil)
+	defer n1.Shutdown()
+	n2 := netceptor.New(ctx, "node2", nil)
+	defer n2.Shutdown()
+	b1, b2 := stdioPipePair(t)
+	if err := n1.AddBackend(b1, 1.0, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := n2.AddBackend(b2, 1.0, nil); err != nil {
+		t.Fatal(err)
+	}
+	waitForPathCost(t, n2, "node1", 1.0)
+	waitForPathCost(t, n1, "node2", 1.0)
+
+	li, err := n1.Listen("echo", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer li.Close()
+	go func() {
+		conn, err := li.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, _ = io.Copy(conn, conn)
+	}()
+	conn, err := n2.Dial("node1", "echo", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.SetDeadline(time.Now().Add(10 * time.Second))
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "hello" {
+		t.Errorf("expected the echo service on node1 to return hello, got %q", buf)
+	}
+}
+
+func TestStdioDialerCfg(t *testing.T) {
+	good := []stdioDialerCfg{
+		{Cost: 1.0, FirstRetryDelay: "100ms", MaxRetryDelay: "20s"},
+		{Command: "ssh", Params: "-T remote receptor --stdio-peer", Cost: 1.0, FirstRetryDelay: "1s", MaxRetryDelay: "1m"},
+	}
+	for _, cfg := range good {
+		if err := cfg.Prepare(); err != nil {
+			t.Fatalf("expected %+v to be valid, got %s", cfg, err)
+		}
+	}
+	bad := []stdioDialerCfg{
+		{Cost: 0, FirstRetryDelay: "100ms", MaxRetryDelay: "20s"},
+		{Params: "-T", Cost: 1.0, FirstRetryDelay: "100ms", MaxRetryDelay: "20s"},
+		{Command: "ssh", Params: `"unterminated`, Cost: 1.0, FirstRetryDelay: "100ms", MaxRetryDelay: "20s"},
+		{Command: "ssh", Cost: 1.0, FirstRetryDelay: "soon", MaxRetryDelay: "20s"},
+	}
+	for _, cfg := range bad {
+		if err := cfg.Prepare(); err == nil {
+			t.Fatalf("expected %+v to be refused", cfg)
+		}
+	}
+}
+
+func TestStdioDialerReservesStdout(t *testing.T) {
+	cfg := stdioDialerCfg{Command: "ssh", Cost: 1.0, FirstRetryDelay: "100ms", MaxRetryDelay: "20s"}
+	if err := cfg.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if logger.StdoutReserved() {
+		t.Fatal("expected a peer that runs a command to leave stdout alone")
+	}
+	cfg.Command = ""
+	if err := cfg.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if !logger.StdoutReserved() {
+		t.Fatal("expected a peer on this process's stdio to reserve stdout from startup")
+	}
+}
@@ -21,25 +21,38 @@ import (
 
 // TCPDialer implements Backend for outbound TCP.
 type TCPDialer struct {
-	address string
-	redial  bool
-	tls     *tls.Config
+	address      string
+	redial       bool
+	redialDelays redialDelays
+	tls          *tls.Config
 }
 
 // NewTCPDialer instantiates a new TCP backend.
 func NewTCPDialer(address string, redial bool, tls *tls.Config) (*TCPDialer, error) {
 	td := TCPDialer{
-		address: address,
-		redial:  redial,
-		tls:     tls,
+		address:      address,
+		redial:       redial,
+		redialDelays: defaultRedialDelays(),
+		tls:          tls,
 	}
 
 	return &td, nil
 }
 
+// SetRedialDelays sets how long the dialer waits to redial after a connection is lost or a dial fails: first
+// for the first retry, then backing off up to max.  It is only effective if used prior to calling Start.
+func (b *TCPDialer) SetRedialDelays(first time.Duration, max time.Duration) {
+	b.redialDelays = newRedialDelays(first, max)
+}
+
+// String returns a description of the dialer.
+func (b *TCPDialer) String() string {
+	return "tcp-peer " + b.address
+}
+
 // Start runs the given session function over this backend service.
 func (b *TCPDialer) Start(ctx context.Context, wg *sync.WaitGroup) (chan netceptor.BackendSession, error) {
-	return dialerSession(ctx, wg, b.redial, 5*time.Second,
+	return dialerSession(ctx, wg, b.redial, b.redialDelays,
 		func(closeChan chan struct{}) (netceptor.BackendSession, error) {
 			var conn net.Conn
 			var err error
@@ -60,10 +73,11 @@ func (b *TCPDialer) Start(ctx context.Context, wg *sync.WaitGroup) (chan netcept
 
 // TCPListener implements Backend for inbound TCP.
 type TCPListener struct {
-	address string
-	tls     *tls.Config
-	li      net.Listener
-	innerLi *net.TCPListener
+	address  string
+	tls      *tls.Config
+	li       net.Listener
+	innerLi  *net.TCPListener
+	sockOpts listenSocketOptions
 }
 
 // NewTCPListener instantiates a new TCPListener backend.
@@ -77,6 +91,18 @@ func NewTCPListener(address string, tls *tls.Config) (*TCPListener, error) {
 	return &tl, nil
 }
 
+// SetBacklog sets the most connections that may wait to be accepted.  Zero leaves the system default.
+// It is only effective if used prior to calling Start.
+func (b *TCPListener) SetBacklog(backlog int) {
+	b.sockOpts.backlog = backlog
+}
+
+// SetReusePort sets whether the listener sets SO_REUSEPORT, so that other processes can listen on the
+// same port and share its connections.  It is only effective if used prior to calling Start.
+func (b *TCPListener) SetReusePort(reusePort bool) {
+	b.sockOpts.reusePort = reusePort
+}
+
 // Addr returns the network address the listener is listening on.
 func (b *TCPListener) Addr() net.Addr {
 	if b.li == nil {
@@ -86,29 +112,36 @@ func (b *TCPListener) Addr() net.Addr {
 	return b.li.Addr()
 }
 
+// String returns a description of the listener.
+func (b *TCPListener) String() string {
+	return "tcp-listener " + b.address
+}
+
 // Start runs the given session function over the TCPListener backend.
 func (b *TCPListener) Start(ctx context.Context, wg *sync.WaitGroup) (chan netceptor.BackendSession, error) {
 	sessChan, err := listenerSession(ctx, wg,
 		func() error {
-			var err error
-			lc := net.ListenConfig{}
-			li, err := lc.Listen(ctx, "tcp", b.address)
-			if err != nil {
-				return err
-			}
-			var ok bool
-			tli, ok := li.(*net.TCPListener)
-			if !ok {
-				return fmt.Errorf("listen returned a non-TCP listener")
+			tli := takeInheritedListener(b.address)
+			if tli == nil {
+				li, err := b.sockOpts.listen(ctx, "tcp", b.address)
+				if err != nil {
+					return err
+				}
+				var ok bool
+				tli, ok = li.(*net.TCPListener)
+				if !ok {
+					return fmt.Errorf("listen returned a non-TCP listener")
+				}
 			}
 			if b.tls == nil {
-				b.li = li
+				b.li = tli
 				b.innerLi = tli
 			} else {
 				tlsLi := tls.NewListener(tli, b.tls)
 				b.li = tlsLi
 				b.innerLi = tli
 			}
+			registerGracefulListener(b.address, tli)
 
 			return nil
 		}, func() (netceptor.BackendSession, error) {
@@ -136,6 +169,7 @@ func (b *TCPListener) Start(ctx context.Context, wg *sync.WaitGroup) (chan netce
 
 			return newTCPSession(c, nil), nil
 		}, func() {
+			unregisterGracefulListener(b.address, b.innerLi)
 			_ = b.li.Close()
 		})
 	if err == nil {
@@ -170,7 +204,7 @@ func (ns *TCPSession) Send(data []byte) error {
 	buf := ns.framer.SendData(data)
 	n, err := ns.conn.Write(buf)
 	if err != nil {
-		return err
+		return sendError(err)
 	}
 	if n != len(buf) {
 		return fmt.Errorf("partial data sent")
@@ -179,6 +213,13 @@ func (ns *TCPSession) Send(data []byte) error {
 	return nil
 }
 
+// SetWriteDeadline sets the time by which sends must complete, after which Send fails with an error wrapping
+// netceptor.ErrSendTimeout.  A timed out send may have written part of a frame, so the session cannot be
+// used after one.  The zero time disables the deadline.
+func (ns *TCPSession) SetWriteDeadline(t time.Time) error {
+	return ns.conn.SetWriteDeadline(t)
+}
+
 // Recv receives data via the session.
 func (ns *TCPSession) Recv(timeout time.Duration) ([]byte, error) {
 	buf := make([]byte, utils.NormalBufferSize)
@@ -225,11 +266,17 @@ func (ns *TCPSession) Close() error {
 
 // tcpListenerCfg is the cmdline configuration object for a TCP listener.
 type tcpListenerCfg struct {
-	BindAddr string             `description:"Local address to bind to" default:"0.0.0.0"`
-	Port     int                `description:"Local TCP port to listen on" barevalue:"yes" required:"yes"`
-	TLS      string             `description:"Name of TLS server config"`
-	Cost     float64            `description:"Connection cost (weight)" default:"1.0"`
-	NodeCost map[string]float64 `description:"Per-node costs"`
+	BindAddr      string             `description:"Local address to bind to" default:"0.0.0.0"`
+	Port          int                `description:"Local TCP port to listen on" barevalue:"yes" required:"yes"`
+	TLS           string             `description:"Name of TLS server config"`
+	Cost          float64            `description:"Connection cost (weight)" default:"1.0"`
+	LatencyCost   float64            `description:"Latency component of a composite connection cost, used to route interactive traffic"`
+	BandwidthCost float64            `description:"Bandwidth component of a composite connection cost, used to route bulk traffic"`
+	NodeCost      map[string]float64 `description:"Per-node costs"`
+	PSK           string             `description:"Pre-shared key that dialers must prove knowledge of" redact:"true"`
+	PreviousPSKs  []string           `description:"Previous pre-shared keys that dialers may still use while the key is rotated" redact:"true"`
+	Backlog       int                `description:"Most connections that may wait to be accepted (0 for system default, Linux only)" default:"0"`
+	ReusePort     bool               `description:"Set SO_REUSEPORT so that other processes can listen on the same port and share its connections (Linux only)" default:"false"`
 }
 
 // Prepare verifies the parameters are correct.
@@ -237,17 +284,28 @@ func (cfg tcpListenerCfg) Prepare() error {
 	if cfg.Cost <= 0.0 {
 		return fmt.Errorf("connection cost must be positive")
 	}
+	if _, err := newLinkCost(cfg.LatencyCost, cfg.BandwidthCost); err != nil {
+		return err
+	}
 	for node, cost := range cfg.NodeCost {
 		if cost <= 0.0 {
 			return fmt.Errorf("connection cost must be positive for %s", node)
 		}
 	}
+	if err := validateListenSocketOptions(cfg.Backlog, cfg.ReusePort); err != nil {
+		return err
+	}
+	claimListenAddressWithOptions("tcp-listener", "tcp", fmt.Sprintf("%s:%d", cfg.BindAddr, cfg.Port), cfg.ReusePort)
 
 	return nil
 }
 
 // Run runs the action.
 func (cfg tcpListenerCfg) Run() error {
+	if err := checkListenerConflicts(true); err != nil {
+		return err
+	}
+	utils.RecordEffectiveConfig("tcp-listener", cfg)
 	address := fmt.Sprintf("%s:%d", cfg.BindAddr, cfg.Port)
 	tlscfg, err := netceptor.MainInstance.GetServerTLSConfig(cfg.TLS)
 	if err != nil {
@@ -259,7 +317,17 @@ func (cfg tcpListenerCfg) Run() error {
 
 		return err
 	}
-	err = netceptor.MainInstance.AddBackend(b, cfg.Cost, cfg.NodeCost)
+	b.SetBacklog(cfg.Backlog)
+	b.SetReusePort(cfg.ReusePort)
+	wb, err := wrapPSK(b, cfg.PSK, cfg.PreviousPSKs, true)
+	if err != nil {
+		return err
+	}
+	lc, err := newLinkCost(cfg.LatencyCost, cfg.BandwidthCost)
+	if err != nil {
+		return err
+	}
+	err = netceptor.MainInstance.AddBackendWithOptions(wb, cfg.Cost, cfg.NodeCost, netceptor.BackendOptions{LinkCost: lc})
 	if err != nil {
 		return err
 	}
@@ -269,10 +337,15 @@ func (cfg tcpListenerCfg) Run() error {
 
 // tcpDialerCfg is the cmdline configuration object for a TCP dialer.
 type tcpDialerCfg struct {
-	Address string  `description:"Remote address (Host:Port) to connect to" barevalue:"yes" required:"yes"`
-	Redial  bool    `description:"Keep redialing on lost connection" default:"true"`
-	TLS     string  `description:"Name of TLS client config"`
-	Cost    float64 `description:"Connection cost (weight)" default:"1.0"`
+	Address         string  `description:"Remote address (Host:Port) to connect to" barevalue:"yes" required:"yes"`
+	Redial          bool    `description:"Keep redialing on lost connection" default:"true"`
+	FirstRetryDelay string  `description:"Delay before the first redial after a lost connection or failed dial" default:"100ms"`
+	MaxRetryDelay   string  `description:"Longest delay between redials as failures continue" default:"20s"`
+	TLS             string  `description:"Name of TLS client config"`
+	Cost            float64 `description:"Connection cost (weight)" default:"1.0"`
+	LatencyCost     float64 `description:"Latency component of a composite connection cost, used to route interactive traffic"`
+	BandwidthCost   float64 `description:"Bandwidth component of a composite connection cost, used to route bulk traffic"`
+	PSK             string  `description:"Pre-shared key to authenticate to the listener with" redact:"true"`
 }
 
 // Prepare verifies the parameters are correct.
@@ -280,12 +353,19 @@ func (cfg tcpDialerCfg) Prepare() error {
 	if cfg.Cost <= 0.0 {
 		return fmt.Errorf("connection cost must be positive")
 	}
+	if _, err := newLinkCost(cfg.LatencyCost, cfg.BandwidthCost); err != nil {
+		return err
+	}
+	if _, _, err := parseRedialDelays(cfg.FirstRetryDelay, cfg.MaxRetryDelay); err != nil {
+		return err
+	}
 
 	return nil
 }
 
 // Run runs the action.
 func (cfg tcpDialerCfg) Run() error {
+	utils.RecordEffectiveConfig("tcp-peer", cfg)
 	logger.Debug("Running TCP peer connection %s\n", cfg.Address)
 	host, _, err := net.SplitHostPort(cfg.Address)
 	if err != nil {
@@ -301,7 +381,20 @@ func (cfg tcpDialerCfg) Run() error {
 
 		return err
 	}
-	err = netceptor.MainInstance.AddBackend(b, cfg.Cost, nil)
+	first, max, err := parseRedialDelays(cfg.FirstRetryDelay, cfg.MaxRetryDelay)
+	if err != nil {
+		return err
+	}
+	b.SetRedialDelays(first, max)
+	wb, err := wrapPSK(b, cfg.PSK, nil, false)
+	if err != nil {
+		return err
+	}
+	lc, err := newLinkCost(cfg.LatencyCost, cfg.BandwidthCost)
+	if err != nil {
+		return err
+	}
+	err = netceptor.MainInstance.AddBackendWithOptions(wb, cfg.Cost, nil, netceptor.BackendOptions{LinkCost: lc})
 	if err != nil {
 		return err
 	}
@@ -325,11 +418,32 @@ func (cfg tcpListenerCfg) Reload() error {
 	return cfg.Run()
 }
 
+func (cfg tcpListenerCfg) Init() error {
+	resetListenerClaims()
+
+	return nil
+}
+
+func (cfg tcpListenerCfg) InitReload() error {
+	resetListenerClaims()
+
+	return nil
+}
+
+func (cfg tcpListenerCfg) ValidateReload() error {
+	if err := checkListenerConflicts(false); err != nil {
+		return err
+	}
+
+	return probeListenAddresses()
+}
+
 func init() {
 	cmdline.RegisterConfigTypeForApp("receptor-backends",
 		"tcp-listener", "Run a backend listener on a TCP port", tcpListenerCfg{}, cmdline.Section(backendSection))
 	cmdline.RegisterConfigTypeForApp("receptor-backends",
 		"tcp-peer", "Make an outbound backend connection to a TCP peer", tcpDialerCfg{}, cmdline.Section(backendSection))
+	registerDialConfig("tcp", func() dialConfig { return &TCPDial{} })
 }
 
 // TCPListen for incoming connections.
@@ -340,8 +454,20 @@ type TCPListen struct {
 	Address string `mapstructure:"address"`
 	// Path cost for this connection. Defaults to 1.0, may not be <= 0.0.`
 	Cost *float64 `mapstructure:"cost"`
+	// Latency component of a composite cost for this connection, used to route interactive traffic.
+	LatencyCost float64 `mapstructure:"latency-cost"`
+	// Bandwidth component of a composite cost for this connection, used to route bulk traffic.
+	BandwidthCost float64 `mapstructure:"bandwidth-cost"`
 	// Extra costs for specific nodes connecting.
 	NodeCosts map[string]float64 `mapstructure:"node-costs"`
+	// Pre-shared key that dialers must prove knowledge of. Leave empty for none.
+	PSK string `mapstructure:"psk"`
+	// Previous pre-shared keys that dialers may still use while the key is rotated.
+	PreviousPSKs []string `mapstructure:"previous-psks"`
+	// Most connections that may wait to be accepted. Defaults to 0, the system default. Linux only.
+	Backlog int `mapstructure:"backlog"`
+	// Set SO_REUSEPORT so that other processes can listen on the same port and share its connections. Linux only.
+	ReusePort bool `mapstructure:"reuse-port"`
 }
 
 func (c TCPListen) setup(nc *netceptor.Netceptor) error {
@@ -358,13 +484,28 @@ func (c TCPListen) setup(nc *netceptor.Netceptor) error {
 	if err != nil {
 		return fmt.Errorf("could not create tcp listener %s from config: %w", c.Address, err)
 	}
+	if err := validateListenSocketOptions(c.Backlog, c.ReusePort); err != nil {
+		return fmt.Errorf("invalid tcp listener config for %s: %w", c.Address, err)
+	}
+	b.SetBacklog(c.Backlog)
+	b.SetReusePort(c.ReusePort)
+
+	cost, nodeCosts, err := validateNodeCosts(c.Cost, c.NodeCosts)
+	if err != nil {
+		return fmt.Errorf("invalid tcp listener config for %s: %w", c.Address, err)
+	}
+
+	wb, err := wrapPSK(b, c.PSK, c.PreviousPSKs, true)
+	if err != nil {
+		return fmt.Errorf("invalid tcp listener config for %s: %w", c.Address, err)
+	}
 
-	cost, nodeCosts, err := validateListenerCost(c.Cost, c.NodeCosts)
+	lc, err := newLinkCost(c.LatencyCost, c.BandwidthCost)
 	if err != nil {
 		return fmt.Errorf("invalid tcp listener config for %s: %w", c.Address, err)
 	}
 
-	if err := nc.AddBackend(b, cost, nodeCosts); err != nil {
+	if err := nc.AddBackendWithOptions(wb, cost, nodeCosts, netceptor.BackendOptions{LinkCost: lc}); err != nil {
 		return fmt.Errorf("error creating backend for tcp listener %s: %w", c.Address, err)
 	}
 
@@ -379,23 +520,53 @@ type TCPDial struct {
 	Address string `mapstructure:"address"`
 	// Path cost for this connection. Defaults to 1.0, may not be <= 0.0.`
 	Cost *float64 `mapstructure:"cost"`
+	// Latency component of a composite cost for this connection, used to route interactive traffic.
+	LatencyCost float64 `mapstructure:"latency-cost"`
+	// Bandwidth component of a composite cost for this connection, used to route bulk traffic.
+	BandwidthCost float64 `mapstructure:"bandwidth-cost"`
 	// Do not keep redialing on lost connection.
 	NoRedial bool `mapstructure:"no-redial"`
+	// Delay before the first redial after a lost connection or failed dial. Defaults to 100ms.
+	FirstRetryDelay *time.Duration `mapstructure:"first-retry-delay"`
+	// Longest delay between redials as failures continue. Defaults to 20s.
+	MaxRetryDelay *time.Duration `mapstructure:"max-retry-delay"`
+	// Pre-shared key to authenticate to the listener with. Leave empty for none.
+	PSK string `mapstructure:"psk"`
 }
 
-func (c TCPDial) setup(nc *netceptor.Netceptor) error {
+// newBackend creates the dialer, without adding it to a netceptor.
+func (c TCPDial) newBackend() (netceptor.Backend, error) {
 	var tlsConf *tls.Config
 	var err error
 	if c.TLS != nil {
 		tlsConf, err = c.TLS.TLSConfig()
 		if err != nil {
-			return fmt.Errorf("could not create tls config for tcp dial %s: %w", c.Address, err)
+			return nil, fmt.Errorf("could not create tls config for tcp dial %s: %w", c.Address, err)
 		}
 	}
 
 	b, err := NewTCPDialer(c.Address, !c.NoRedial, tlsConf)
 	if err != nil {
-		return fmt.Errorf("could not create tcp dial %s from config: %w", c.Address, err)
+		return nil, fmt.Errorf("could not create tcp dial %s from config: %w", c.Address, err)
+	}
+	first, max, err := redialDelaysOrDefault(c.FirstRetryDelay, c.MaxRetryDelay)
+	if err != nil {
+		return nil, fmt.Errorf("invalid tcp dial config for %s: %w", c.Address, err)
+	}
+	b.SetRedialDelays(first, max)
+
+	wb, err := wrapPSK(b, c.PSK, nil, false)
+	if err != nil {
+		return nil, fmt.Errorf("invalid tcp dial config for %s: %w", c.Address, err)
+	}
+
+	return wb, nil
+}
+
+func (c TCPDial) setup(nc *netceptor.Netceptor) error {
+	wb, err := c.newBackend()
+	if err != nil {
+		return err
 	}
 
 	cost, err := validateDialCost(c.Cost)
@@ -403,7 +574,12 @@ func (c TCPDial) setup(nc *netceptor.Netceptor) error {
 		return fmt.Errorf("invalid cost for tcp dial %s: %w", c.Address, err)
 	}
 
-	if err := nc.AddBackend(b, cost, nil); err != nil {
+	lc, err := newLinkCost(c.LatencyCost, c.BandwidthCost)
+	if err != nil {
+		return fmt.Errorf("invalid tcp dial config for %s: %w", c.Address, err)
+	}
+
+	if err := nc.AddBackendWithOptions(wb, cost, nil, netceptor.BackendOptions{LinkCost: lc}); err != nil {
 		return fmt.Errorf("error creating backend for tcp dial %s: %w", c.Address, err)
 	}
 
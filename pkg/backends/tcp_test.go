@@ -0,0 +1,29 @@
+package backends
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/ansible/receptor/pkg/netceptor"
+)
+
+func TestTCPSendTimeout(t *testing.T) {
+	server, client := net.Pipe()
+	// The client end is never read from, so sends block
+	defer client.Close()
+	sess := newTCPSession(server, nil)
+	defer sess.Close()
+	if err := sess.SetWriteDeadline(time.Now().Add(300 * time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	err := sess.Send([]byte("hello"))
+	if !errors.Is(err, netceptor.ErrSendTimeout) {
+		t.Fatalf("expected a send timeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 300*time.Millisecond {
+		t.Fatalf("send timed out after %s, before the deadline", elapsed)
+	}
+}
@@ -21,8 +21,9 @@ const UDPMaxPacketLen = 65507
 
 // UDPDialer implements Backend for outbound UDP.
 type UDPDialer struct {
-	address string
-	redial  bool
+	address      string
+	redial       bool
+	redialDelays redialDelays
 }
 
 // NewUDPDialer instantiates a new UDPDialer backend.
@@ -32,16 +33,28 @@ func NewUDPDialer(address string, redial bool) (*UDPDialer, error) {
 		return nil, err
 	}
 	nd := UDPDialer{
-		address: address,
-		redial:  redial,
+		address:      address,
+		redial:       redial,
+		redialDelays: defaultRedialDelays(),
 	}
 
 	return &nd, nil
 }
 
+// SetRedialDelays sets how long the dialer waits to redial after a connection is lost or a dial fails: first
+// for the first retry, then backing off up to max.  It is only effective if used prior to calling Start.
+func (b *UDPDialer) SetRedialDelays(first time.Duration, max time.Duration) {
+	b.redialDelays = newRedialDelays(first, max)
+}
+
+// String returns a description of the dialer.
+func (b *UDPDialer) String() string {
+	return "udp-peer " + b.address
+}
+
 // Start runs the given session function over this backend service.
 func (b *UDPDialer) Start(ctx context.Context, wg *sync.WaitGroup) (chan netceptor.BackendSession, error) {
-	return dialerSession(ctx, wg, b.redial, 5*time.Second,
+	return dialerSession(ctx, wg, b.redial, b.redialDelays,
 		func(closeChan chan struct{}) (netceptor.BackendSession, error) {
 			dialer := net.Dialer{}
 			conn, err := dialer.DialContext(ctx, "udp", b.address)
@@ -76,7 +89,7 @@ func (ns *UDPDialerSession) Send(data []byte) error {
 	}
 	n, err := ns.conn.Write(data)
 	if err != nil {
-		return err
+		return sendError(err)
 	}
 	if n != len(data) {
 		return fmt.Errorf("partial data sent")
@@ -85,6 +98,12 @@ func (ns *UDPDialerSession) Send(data []byte) error {
 	return nil
 }
 
+// SetWriteDeadline sets the time by which sends must complete, after which Send fails with an error wrapping
+// netceptor.ErrSendTimeout.  The zero time disables the deadline.
+func (ns *UDPDialerSession) SetWriteDeadline(t time.Time) error {
+	return ns.conn.SetWriteDeadline(t)
+}
+
 // Recv receives data via the session.
 func (ns *UDPDialerSession) Recv(timeout time.Duration) ([]byte, error) {
 	err := ns.conn.SetReadDeadline(time.Now().Add(timeout))
@@ -154,6 +173,11 @@ func (b *UDPListener) LocalAddr() net.Addr {
 	return b.conn.LocalAddr()
 }
 
+// String returns a description of the listener.
+func (b *UDPListener) String() string {
+	return "udp-listener " + b.laddr.String()
+}
+
 // Start runs the given session function over the UDPListener backend.
 func (b *UDPListener) Start(ctx context.Context, wg *sync.WaitGroup) (chan netceptor.BackendSession, error) {
 	sessChan := make(chan netceptor.BackendSession)
@@ -234,7 +258,7 @@ type UDPListenerSession struct {
 func (ns *UDPListenerSession) Send(data []byte) error {
 	n, err := ns.li.conn.WriteToUDP(data, ns.raddr)
 	if err != nil {
-		return err
+		return sendError(err)
 	} else if n != len(data) {
 		return fmt.Errorf("partial data sent")
 	}
@@ -242,6 +266,13 @@ func (ns *UDPListenerSession) Send(data []byte) error {
 	return nil
 }
 
+// SetWriteDeadline sets the time by which sends must complete, after which Send fails with an error wrapping
+// netceptor.ErrSendTimeout.  The zero time disables the deadline.  The listener's sessions share one socket,
+// so this also moves the deadline of sends on the listener's other sessions.
+func (ns *UDPListenerSession) SetWriteDeadline(t time.Time) error {
+	return ns.li.conn.SetWriteDeadline(t)
+}
+
 // Recv receives data from the session.
 func (ns *UDPListenerSession) Recv(timeout time.Duration) ([]byte, error) {
 	select {
@@ -267,10 +298,14 @@ func (ns *UDPListenerSession) Close() error {
 
 // udpListenerCfg is the cmdline configuration object for a UDP listener.
 type udpListenerCfg struct {
-	BindAddr string             `description:"Local address to bind to" default:"0.0.0.0"`
-	Port     int                `description:"Local UDP port to listen on" barevalue:"yes" required:"yes"`
-	Cost     float64            `description:"Connection cost (weight)" default:"1.0"`
-	NodeCost map[string]float64 `description:"Per-node costs"`
+	BindAddr      string             `description:"Local address to bind to" default:"0.0.0.0"`
+	Port          int                `description:"Local UDP port to listen on" barevalue:"yes" required:"yes"`
+	Cost          float64            `description:"Connection cost (weight)" default:"1.0"`
+	LatencyCost   float64            `description:"Latency component of a composite connection cost, used to route interactive traffic"`
+	BandwidthCost float64            `description:"Bandwidth component of a composite connection cost, used to route bulk traffic"`
+	NodeCost      map[string]float64 `description:"Per-node costs"`
+	PSK           string             `description:"Pre-shared key that dialers must prove knowledge of" redact:"true"`
+	PreviousPSKs  []string           `description:"Previous pre-shared keys that dialers may still use while the key is rotated" redact:"true"`
 }
 
 // Prepare verifies the parameters are correct.
@@ -278,17 +313,25 @@ func (cfg udpListenerCfg) Prepare() error {
 	if cfg.Cost <= 0.0 {
 		return fmt.Errorf("connection cost must be positive")
 	}
+	if _, err := newLinkCost(cfg.LatencyCost, cfg.BandwidthCost); err != nil {
+		return err
+	}
 	for node, cost := range cfg.NodeCost {
 		if cost <= 0.0 {
 			return fmt.Errorf("connection cost must be positive for %s", node)
 		}
 	}
+	claimListenAddress("udp-listener", "udp", fmt.Sprintf("%s:%d", cfg.BindAddr, cfg.Port))
 
 	return nil
 }
 
 // Run runs the action.
 func (cfg udpListenerCfg) Run() error {
+	if err := checkListenerConflicts(true); err != nil {
+		return err
+	}
+	utils.RecordEffectiveConfig("udp-listener", cfg)
 	address := fmt.Sprintf("%s:%d", cfg.BindAddr, cfg.Port)
 	b, err := NewUDPListener(address)
 	if err != nil {
@@ -296,7 +339,15 @@ func (cfg udpListenerCfg) Run() error {
 
 		return err
 	}
-	err = netceptor.MainInstance.AddBackend(b, cfg.Cost, cfg.NodeCost)
+	wb, err := wrapPSK(b, cfg.PSK, cfg.PreviousPSKs, true)
+	if err != nil {
+		return err
+	}
+	lc, err := newLinkCost(cfg.LatencyCost, cfg.BandwidthCost)
+	if err != nil {
+		return err
+	}
+	err = netceptor.MainInstance.AddBackendWithOptions(wb, cfg.Cost, cfg.NodeCost, netceptor.BackendOptions{LinkCost: lc})
 	if err != nil {
 		logger.Error("Error creating backend for %s: %s\n", address, err)
 
@@ -308,9 +359,14 @@ func (cfg udpListenerCfg) Run() error {
 
 // udpDialerCfg is the cmdline configuration object for a UDP listener.
 type udpDialerCfg struct {
-	Address string  `description:"Host:Port to connect to" barevalue:"yes" required:"yes"`
-	Redial  bool    `description:"Keep redialing on lost connection" default:"true"`
-	Cost    float64 `description:"Connection cost (weight)" default:"1.0"`
+	Address         string  `description:"Host:Port to connect to" barevalue:"yes" required:"yes"`
+	Redial          bool    `description:"Keep redialing on lost connection" default:"true"`
+	FirstRetryDelay string  `description:"Delay before the first redial after a lost connection or failed dial" default:"100ms"`
+	MaxRetryDelay   string  `description:"Longest delay between redials as failures continue" default:"20s"`
+	Cost            float64 `description:"Connection cost (weight)" default:"1.0"`
+	LatencyCost     float64 `description:"Latency component of a composite connection cost, used to route interactive traffic"`
+	BandwidthCost   float64 `description:"Bandwidth component of a composite connection cost, used to route bulk traffic"`
+	PSK             string  `description:"Pre-shared key to authenticate to the listener with" redact:"true"`
 }
 
 // Prepare verifies the parameters are correct.
@@ -318,12 +374,19 @@ func (cfg udpDialerCfg) Prepare() error {
 	if cfg.Cost <= 0.0 {
 		return fmt.Errorf("connection cost must be positive")
 	}
+	if _, err := newLinkCost(cfg.LatencyCost, cfg.BandwidthCost); err != nil {
+		return err
+	}
+	if _, _, err := parseRedialDelays(cfg.FirstRetryDelay, cfg.MaxRetryDelay); err != nil {
+		return err
+	}
 
 	return nil
 }
 
 // Run runs the action.
 func (cfg udpDialerCfg) Run() error {
+	utils.RecordEffectiveConfig("udp-peer", cfg)
 	logger.Debug("Running UDP peer connection %s\n", cfg.Address)
 	b, err := NewUDPDialer(cfg.Address, cfg.Redial)
 	if err != nil {
@@ -331,7 +394,20 @@ func (cfg udpDialerCfg) Run() error {
 
 		return err
 	}
-	err = netceptor.MainInstance.AddBackend(b, cfg.Cost, nil)
+	first, max, err := parseRedialDelays(cfg.FirstRetryDelay, cfg.MaxRetryDelay)
+	if err != nil {
+		return err
+	}
+	b.SetRedialDelays(first, max)
+	wb, err := wrapPSK(b, cfg.PSK, nil, false)
+	if err != nil {
+		return err
+	}
+	lc, err := newLinkCost(cfg.LatencyCost, cfg.BandwidthCost)
+	if err != nil {
+		return err
+	}
+	err = netceptor.MainInstance.AddBackendWithOptions(wb, cfg.Cost, nil, netceptor.BackendOptions{LinkCost: lc})
 	if err != nil {
 		logger.Error("Error creating backend for %s: %s\n", cfg.Address, err)
 
@@ -357,11 +433,32 @@ func (cfg udpListenerCfg) Reload() error {
 	return cfg.Run()
 }
 
+func (cfg udpListenerCfg) Init() error {
+	resetListenerClaims()
+
+	return nil
+}
+
+func (cfg udpListenerCfg) InitReload() error {
+	resetListenerClaims()
+
+	return nil
+}
+
+func (cfg udpListenerCfg) ValidateReload() error {
+	if err := checkListenerConflicts(false); err != nil {
+		return err
+	}
+
+	return probeListenAddresses()
+}
+
 func init() {
 	cmdline.RegisterConfigTypeForApp("receptor-backends",
 		"UDP-listener", "Run a backend listener on a UDP port", udpListenerCfg{}, cmdline.Section(backendSection))
 	cmdline.RegisterConfigTypeForApp("receptor-backends",
 		"UDP-peer", "Make an outbound backend connection to a UDP peer", udpDialerCfg{}, cmdline.Section(backendSection))
+	registerDialConfig("udp", func() dialConfig { return &UDPDial{} })
 }
 
 // UDPListen for incoming connections.
@@ -370,8 +467,16 @@ type UDPListen struct {
 	Address string `mapstructure:"address"`
 	// Path cost for this connection. Defaults to 1.0, may not be <= 0.0.`
 	Cost *float64 `mapstructure:"cost"`
+	// Latency component of a composite cost for this connection, used to route interactive traffic.
+	LatencyCost float64 `mapstructure:"latency-cost"`
+	// Bandwidth component of a composite cost for this connection, used to route bulk traffic.
+	BandwidthCost float64 `mapstructure:"bandwidth-cost"`
 	// Extra costs for specific nodes connecting.
 	NodeCosts map[string]float64 `mapstructure:"node-costs"`
+	// Pre-shared key that dialers must prove knowledge of. Leave empty for none.
+	PSK string `mapstructure:"psk"`
+	// Previous pre-shared keys that dialers may still use while the key is rotated.
+	PreviousPSKs []string `mapstructure:"previous-psks"`
 }
 
 func (c UDPListen) setup(nc *netceptor.Netceptor) error {
@@ -380,12 +485,22 @@ func (c UDPListen) setup(nc *netceptor.Netceptor) error {
 		return fmt.Errorf("could not create udp listener for %s from config: %w", c.Address, err)
 	}
 
-	cost, nodeCosts, err := validateListenerCost(c.Cost, c.NodeCosts)
+	cost, nodeCosts, err := validateNodeCosts(c.Cost, c.NodeCosts)
+	if err != nil {
+		return fmt.Errorf("invalid udp listener config for %s: %w", c.Address, err)
+	}
+
+	wb, err := wrapPSK(b, c.PSK, c.PreviousPSKs, true)
+	if err != nil {
+		return fmt.Errorf("invalid udp listener config for %s: %w", c.Address, err)
+	}
+
+	lc, err := newLinkCost(c.LatencyCost, c.BandwidthCost)
 	if err != nil {
 		return fmt.Errorf("invalid udp listener config for %s: %w", c.Address, err)
 	}
 
-	if err := nc.AddBackend(b, cost, nodeCosts); err != nil {
+	if err := nc.AddBackendWithOptions(wb, cost, nodeCosts, netceptor.BackendOptions{LinkCost: lc}); err != nil {
 		return fmt.Errorf("error creating backend for udp listener %s: %w", c.Address, err)
 	}
 
@@ -398,14 +513,44 @@ type UDPDial struct {
 	Address string `mapstructure:"address"`
 	// Path cost for this connection. Defaults to 1.0, may not be <= 0.0.`
 	Cost *float64 `mapstructure:"cost"`
+	// Latency component of a composite cost for this connection, used to route interactive traffic.
+	LatencyCost float64 `mapstructure:"latency-cost"`
+	// Bandwidth component of a composite cost for this connection, used to route bulk traffic.
+	BandwidthCost float64 `mapstructure:"bandwidth-cost"`
 	// Do not keep redialing on lost connection.
 	NoRedial bool `mapstructure:"no-redial"`
+	// Delay before the first redial after a lost connection or failed dial. Defaults to 100ms.
+	FirstRetryDelay *time.Duration `mapstructure:"first-retry-delay"`
+	// Longest delay between redials as failures continue. Defaults to 20s.
+	MaxRetryDelay *time.Duration `mapstructure:"max-retry-delay"`
+	// Pre-shared key to authenticate to the listener with. Leave empty for none.
+	PSK string `mapstructure:"psk"`
 }
 
-func (c UDPDial) setup(nc *netceptor.Netceptor) error {
+// newBackend creates the dialer, without adding it to a netceptor.
+func (c UDPDial) newBackend() (netceptor.Backend, error) {
 	b, err := NewUDPDialer(c.Address, !c.NoRedial)
 	if err != nil {
-		return fmt.Errorf("could not create udp connection for %s from config: %w", c.Address, err)
+		return nil, fmt.Errorf("could not create udp connection for %s from config: %w", c.Address, err)
+	}
+	first, max, err := redialDelaysOrDefault(c.FirstRetryDelay, c.MaxRetryDelay)
+	if err != nil {
+		return nil, fmt.Errorf("invalid udp connection config for %s: %w", c.Address, err)
+	}
+	b.SetRedialDelays(first, max)
+
+	wb, err := wrapPSK(b, c.PSK, nil, false)
+	if err != nil {
+		return nil, fmt.Errorf("invalid udp connection config for %s: %w", c.Address, err)
+	}
+
+	return wb, nil
+}
+
+func (c UDPDial) setup(nc *netceptor.Netceptor) error {
+	wb, err := c.newBackend()
+	if err != nil {
+		return err
 	}
 
 	cost, err := validateDialCost(c.Cost)
@@ -413,7 +558,12 @@ func (c UDPDial) setup(nc *netceptor.Netceptor) error {
 		return fmt.Errorf("invalid udp listener connection for %s: %w", c.Address, err)
 	}
 
-	if err := nc.AddBackend(b, cost, nil); err != nil {
+	lc, err := newLinkCost(c.LatencyCost, c.BandwidthCost)
+	if err != nil {
+		return fmt.Errorf("invalid udp connection config for %s: %w", c.Address, err)
+	}
+
+	if err := nc.AddBackendWithOptions(wb, cost, nil, netceptor.BackendOptions{LinkCost: lc}); err != nil {
 		return fmt.Errorf("error creating backend for udp connection %s: %w", c.Address, err)
 	}
 
@@ -2,6 +2,9 @@ package backends
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"net"
 	"sync"
 	"time"
 
@@ -10,14 +13,113 @@ import (
 	"github.com/ansible/receptor/pkg/utils"
 )
 
+// Default redial delays of dialers.
 const (
-	maxRedialDelay = 20 * time.Second
+	DefaultFirstRetryDelay = 100 * time.Millisecond
+	DefaultMaxRetryDelay   = 20 * time.Second
 )
 
+// redialBackoffStart is the delay before redialing after the first retry has also failed, which then grows
+// by half with each further failure.
+const redialBackoffStart = 5 * time.Second
+
+// stableSessionTime is how long a session must last for its loss to be treated as a blip, redialed after
+// the first retry delay, rather than as another failure to back off from.
+const stableSessionTime = 10 * time.Second
+
+// redialDelays is how long a dialer waits before redialing.
+type redialDelays struct {
+	first   time.Duration
+	backoff time.Duration
+	max     time.Duration
+}
+
+// newRedialDelays returns redial delays with the given first and maximum delays.  The backoff starts at
+// redialBackoffStart, or the maximum if that is less.
+func newRedialDelays(first time.Duration, max time.Duration) redialDelays {
+	backoff := redialBackoffStart
+	if backoff > max {
+		backoff = max
+	}
+	if first > max {
+		first = max
+	}
+
+	return redialDelays{
+		first:   first,
+		backoff: backoff,
+		max:     max,
+	}
+}
+
+// defaultRedialDelays returns the redial delays dialers use unless configured otherwise.
+func defaultRedialDelays() redialDelays {
+	return newRedialDelays(DefaultFirstRetryDelay, DefaultMaxRetryDelay)
+}
+
+// parseRedialDelays parses configured first and maximum redial delays.  Empty delays are the defaults.
+func parseRedialDelays(first string, max string) (time.Duration, time.Duration, error) {
+	firstDelay, maxDelay := DefaultFirstRetryDelay, DefaultMaxRetryDelay
+	var err error
+	if first != "" {
+		firstDelay, err = time.ParseDuration(first)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid first retry delay %s: %s", first, err)
+		}
+	}
+	if max != "" {
+		maxDelay, err = time.ParseDuration(max)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid max retry delay %s: %s", max, err)
+		}
+	}
+
+	return firstDelay, maxDelay, validateRedialDelays(firstDelay, maxDelay)
+}
+
+// redialDelaysOrDefault returns configured first and maximum redial delays.  Unset delays are the defaults.
+func redialDelaysOrDefault(first *time.Duration, max *time.Duration) (time.Duration, time.Duration, error) {
+	firstDelay, maxDelay := DefaultFirstRetryDelay, DefaultMaxRetryDelay
+	if first != nil {
+		firstDelay = *first
+	}
+	if max != nil {
+		maxDelay = *max
+	}
+
+	return firstDelay, maxDelay, validateRedialDelays(firstDelay, maxDelay)
+}
+
+func validateRedialDelays(first time.Duration, max time.Duration) error {
+	if first < 0 || max <= 0 {
+		return fmt.Errorf("first retry delay must not be negative, and max retry delay must be positive")
+	}
+
+	return nil
+}
+
+// dialErrorKey is the context key of a function that is told why a dial attempt failed.
+type dialErrorKey struct{}
+
+// withDialErrorFunc returns a context that makes backends started with it call f each time a dial fails.
+func withDialErrorFunc(ctx context.Context, f func(error)) context.Context {
+	return context.WithValue(ctx, dialErrorKey{}, f)
+}
+
+// reportDialError tells the function in ctx, if there is one, why a dial attempt failed.
+func reportDialError(ctx context.Context, err error) {
+	if f, ok := ctx.Value(dialErrorKey{}).(func(error)); ok {
+		f(err)
+	}
+}
+
 type dialerFunc func(chan struct{}) (netceptor.BackendSession, error)
 
-// dialerSession is a convenience function for backends that use dial/retry logic.
-func dialerSession(ctx context.Context, wg *sync.WaitGroup, redial bool, redialDelay time.Duration,
+// dialerSession is a convenience function for backends that use dial/retry logic.  After a session is lost
+// or a dial fails, the first retry comes after the first delay, so a brief blip is recovered from quickly,
+// and further failures back off up to the maximum delay.  A session that lasts stableSessionTime starts
+// the delays over.
+func dialerSession(ctx context.Context, wg *sync.WaitGroup, redial bool, delays redialDelays,
 	df dialerFunc) (chan netceptor.BackendSession, error) {
 	sessChan := make(chan netceptor.BackendSession)
 	wg.Add(1)
@@ -26,12 +128,17 @@ func dialerSession(ctx context.Context, wg *sync.WaitGroup, redial bool, redialD
 			wg.Done()
 			close(sessChan)
 		}()
-		redialDelayInc := utils.NewIncrementalDuration(redialDelay, maxRedialDelay, 1.5)
+		redialDelayInc := utils.NewIncrementalDuration(delays.backoff, delays.max, 1.5)
+		retried := false
 		for {
 			closeChan := make(chan struct{})
 			sess, err := df(closeChan)
+			if err != nil {
+				reportDialError(ctx, err)
+				netceptor.ReportDialFailure(ctx)
+			}
 			if err == nil {
-				redialDelayInc.Reset()
+				connected := time.Now()
 				select {
 				case sessChan <- sess:
 					// continue
@@ -46,6 +153,10 @@ func dialerSession(ctx context.Context, wg *sync.WaitGroup, redial bool, redialD
 
 					return
 				}
+				if time.Since(connected) >= stableSessionTime {
+					redialDelayInc.Reset()
+					retried = false
+				}
 			}
 			if redial && ctx.Err() == nil {
 				if err != nil {
@@ -53,8 +164,15 @@ func dialerSession(ctx context.Context, wg *sync.WaitGroup, redial bool, redialD
 				} else {
 					logger.Warning("Backend connection exited (will retry)\n")
 				}
+				next := redialDelayInc.NextTimeout
+				if !retried {
+					retried = true
+					next = func() <-chan time.Time {
+						return time.After(delays.first)
+					}
+				}
 				select {
-				case <-redialDelayInc.NextTimeout():
+				case <-next():
 					continue
 				case <-ctx.Done():
 					return
@@ -115,3 +233,13 @@ func listenerSession(ctx context.Context, wg *sync.WaitGroup, lf listenFunc, af
 
 	return sessChan, nil
 }
+
+// sendError marks an error from writing to a connection as a send timeout, if it is one.
+func sendError(err error) error {
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() {
+		return fmt.Errorf("%w: %s", netceptor.ErrSendTimeout, err)
+	}
+
+	return err
+}
@@ -0,0 +1,113 @@
+package backends
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// keepAliveIdle returns the TCP keepalive idle time of the connection underlying a websocket session.
+func keepAliveIdle(t *testing.T, ws *WebsocketSession) time.Duration {
+	tcpConn, ok := ws.conn.UnderlyingConn().(*net.TCPConn)
+	if !ok {
+		t.Fatalf("underlying connection is %T, not TCP", ws.conn.UnderlyingConn())
+	}
+	rawConn, err := tcpConn.SyscallConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var enabled, idle int
+	var sockErr error
+	err = rawConn.Control(func(fd uintptr) {
+		enabled, sockErr = syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_KEEPALIVE)
+		if sockErr == nil {
+			idle, sockErr = syscall.GetsockoptInt(int(fd), syscall.IPPROTO_TCP, syscall.TCP_KEEPIDLE)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sockErr != nil {
+		t.Fatal(sockErr)
+	}
+	if enabled == 0 {
+		return 0
+	}
+
+	return time.Duration(idle) * time.Second
+}
+
+func TestWebsocketTCPKeepAlive(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	li, err := NewWebsocketListener("127.0.0.1:0", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	li.SetTCPKeepAlive(37 * time.Second)
+	lsc, err := li.Start(ctx, &sync.WaitGroup{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := NewWebsocketDialer(fmt.Sprintf("ws://%s/", li.Addr()), nil, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.SetTCPKeepAlive(43 * time.Second)
+	dsc, err := d.Start(ctx, &sync.WaitGroup{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var dialerSess, listenerSess *WebsocketSession
+	for dialerSess == nil || listenerSess == nil {
+		select {
+		case s := <-dsc:
+			dialerSess = s.(*WebsocketSession)
+		case s := <-lsc:
+			listenerSess = s.(*WebsocketSession)
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out connecting websocket")
+		}
+	}
+	if idle := keepAliveIdle(t, dialerSess); idle != 43*time.Second {
+		t.Fatalf("expected dialer keepalive of 43s, got %s", idle)
+	}
+	if idle := keepAliveIdle(t, listenerSess); idle != 37*time.Second {
+		t.Fatalf("expected listener keepalive of 37s, got %s", idle)
+	}
+}
+
+func TestWebsocketTCPKeepAliveDisabled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	li, err := NewWebsocketListener("127.0.0.1:0", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	li.SetTCPKeepAlive(-1)
+	lsc, err := li.Start(ctx, &sync.WaitGroup{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := NewWebsocketDialer(fmt.Sprintf("ws://%s/", li.Addr()), nil, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = d.Start(ctx, &sync.WaitGroup{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case s := <-lsc:
+		if idle := keepAliveIdle(t, s.(*WebsocketSession)); idle != 0 {
+			t.Fatalf("expected keepalive to be disabled, got %s", idle)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out connecting websocket")
+	}
+}
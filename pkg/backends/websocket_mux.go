@@ -0,0 +1,381 @@
+//go:build !no_websocket_backend && !no_backends
+// +build !no_websocket_backend,!no_backends
+
+package backends
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/ansible/receptor/pkg/logger"
+	"github.com/ansible/receptor/pkg/netceptor"
+	"github.com/gorilla/websocket"
+)
+
+// websocketMuxProtocol is the websocket subprotocol used to negotiate multiplexing.  Peers that do
+// not offer or accept it get one session per websocket connection, as before.
+const websocketMuxProtocol = "receptor-mux"
+
+// Multiplexed frames are a one byte frame type and a four byte stream ID, followed by the payload.
+const (
+	muxFrameData   = 0
+	muxFrameClose  = 1
+	muxFrameHeader = 5
+)
+
+// ErrMuxClosed is returned when opening a stream on a multiplexed connection that has closed.
+var ErrMuxClosed = errors.New("multiplexed websocket connection is closed")
+
+// websocketMux carries several logical sessions over a single websocket connection.  Streams are
+// only opened by the dialing side; the listening side learns of a new stream from its first frame.
+type websocketMux struct {
+	conn       *websocket.Conn
+	compressed bool
+	listenPath string
+	writeLock  sync.Mutex
+	lock       sync.Mutex
+	streams    map[uint32]*muxStream
+	lastID     uint32
+	closed     bool
+	done       chan struct{}
+	acceptFunc func(*muxStream)
+	onClose    func()
+}
+
+// newWebsocketMux starts multiplexing over conn.  If acceptFunc is non-nil, this is the listening side,
+// and acceptFunc is called with each stream opened by the remote side.  compressed is whether conn
+// negotiated compression, and listenPath is the listener path it was accepted on.
+func newWebsocketMux(conn *websocket.Conn, compressed bool, listenPath string, acceptFunc func(*muxStream)) *websocketMux {
+	m := &websocketMux{
+		conn:       conn,
+		compressed: compressed,
+		listenPath: listenPath,
+		streams:    make(map[uint32]*muxStream),
+		done:       make(chan struct{}),
+		acceptFunc: acceptFunc,
+	}
+	go m.readLoop()
+
+	return m
+}
+
+// readLoop demultiplexes incoming frames to their streams.
+func (m *websocketMux) readLoop() {
+	for {
+		_, data, err := m.conn.ReadMessage()
+		if err != nil {
+			m.shutdown()
+
+			return
+		}
+		if len(data) < muxFrameHeader {
+			logger.Warning("Discarding short multiplexed websocket frame\n")
+
+			continue
+		}
+		id := binary.BigEndian.Uint32(data[1:muxFrameHeader])
+		m.lock.Lock()
+		st, ok := m.streams[id]
+		accepted := false
+		if !ok && data[0] == muxFrameData && m.acceptFunc != nil && id > m.lastID {
+			m.lastID = id
+			st = newMuxStream(m, id, nil)
+			m.streams[id] = st
+			ok = true
+			accepted = true
+		}
+		m.lock.Unlock()
+		if !ok {
+			continue
+		}
+		if accepted {
+			m.acceptFunc(st)
+		}
+		switch data[0] {
+		case muxFrameData:
+			st.push(data[muxFrameHeader:])
+		case muxFrameClose:
+			m.removeStream(id)
+			st.remoteClose(io.EOF)
+		default:
+			logger.Warning("Discarding multiplexed websocket frame of unknown type %d\n", data[0])
+		}
+	}
+}
+
+// OpenStream opens a new logical session.  closeChan, if non-nil, is closed when the stream is closed.
+func (m *websocketMux) OpenStream(closeChan chan struct{}) (*muxStream, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.closed {
+		return nil, ErrMuxClosed
+	}
+	m.lastID++
+	st := newMuxStream(m, m.lastID, closeChan)
+	m.streams[st.id] = st
+
+	return st, nil
+}
+
+// writeFrame sends a single frame to the remote side.
+func (m *websocketMux) writeFrame(frameType byte, id uint32, payload []byte) error {
+	frame := make([]byte, muxFrameHeader+len(payload))
+	frame[0] = frameType
+	binary.BigEndian.PutUint32(frame[1:muxFrameHeader], id)
+	copy(frame[muxFrameHeader:], payload)
+	m.writeLock.Lock()
+	defer m.writeLock.Unlock()
+
+	return m.conn.WriteMessage(websocket.BinaryMessage, frame)
+}
+
+// removeStream forgets a stream.  On the dialing side, the connection is closed once no streams remain.
+func (m *websocketMux) removeStream(id uint32) {
+	m.lock.Lock()
+	delete(m.streams, id)
+	idle := len(m.streams) == 0 && m.acceptFunc == nil
+	m.lock.Unlock()
+	if idle {
+		m.shutdown()
+	}
+}
+
+// shutdown closes the underlying connection and all streams.
+func (m *websocketMux) shutdown() {
+	m.lock.Lock()
+	if m.closed {
+		m.lock.Unlock()
+
+		return
+	}
+	m.closed = true
+	close(m.done)
+	streams := m.streams
+	m.streams = make(map[uint32]*muxStream)
+	onClose := m.onClose
+	m.lock.Unlock()
+	if onClose != nil {
+		onClose()
+	}
+	for _, st := range streams {
+		st.remoteClose(ErrMuxClosed)
+	}
+	_ = m.conn.Close()
+}
+
+// muxStream implements BackendSession for one logical session of a multiplexed websocket.
+type muxStream struct {
+	mux             *websocketMux
+	id              uint32
+	lock            sync.Mutex
+	queue           [][]byte
+	queuedBytes     int64
+	discard         bool
+	notify          chan struct{}
+	err             error
+	closeChan       chan struct{}
+	closeChanCloser sync.Once
+}
+
+func newMuxStream(mux *websocketMux, id uint32, closeChan chan struct{}) *muxStream {
+	return &muxStream{
+		mux:       mux,
+		id:        id,
+		notify:    make(chan struct{}, 1),
+		closeChan: closeChan,
+	}
+}
+
+// push queues received data for Recv.  If the session buffer budget has no room for it, the stream is
+// closed rather than holding back the whole connection, since the stream is not reading fast enough.  Data
+// for a stream that has been closed locally is discarded.
+func (st *muxStream) push(data []byte) {
+	size := int64(len(data))
+	st.lock.Lock()
+	if st.discard {
+		st.lock.Unlock()
+
+		return
+	}
+	if !netceptor.SessionBufferBudget.TryAcquire(size) {
+		st.lock.Unlock()
+		logger.Warning("Closing multiplexed websocket session %d: %s\n", st.id, netceptor.ErrSessionBufferFull)
+		st.shed()
+
+		return
+	}
+	st.queue = append(st.queue, data)
+	st.queuedBytes += size
+	st.lock.Unlock()
+	select {
+	case st.notify <- struct{}{}:
+	default:
+	}
+}
+
+// shed closes a stream whose data does not fit in the session buffer budget.  Its queued data is
+// discarded, and Recv returns ErrSessionBufferFull so that netceptor ends the session.
+func (st *muxStream) shed() {
+	st.lock.Lock()
+	alreadyClosed := st.err != nil
+	if !alreadyClosed {
+		st.err = netceptor.ErrSessionBufferFull
+	}
+	st.discard = true
+	queued := st.queuedBytes
+	st.queue = nil
+	st.queuedBytes = 0
+	st.lock.Unlock()
+	netceptor.SessionBufferBudget.Release(queued)
+	select {
+	case st.notify <- struct{}{}:
+	default:
+	}
+	if !alreadyClosed {
+		// The read loop calls this, so the other end is told without waiting on the connection here
+		go func() {
+			_ = st.mux.writeFrame(muxFrameClose, st.id, nil)
+		}()
+	}
+}
+
+// remoteClose marks the stream as closed, so that Recv returns err once queued data is consumed.
+func (st *muxStream) remoteClose(err error) {
+	st.lock.Lock()
+	if st.err == nil {
+		st.err = err
+	}
+	st.lock.Unlock()
+	select {
+	case st.notify <- struct{}{}:
+	default:
+	}
+}
+
+// Send sends data over the session.
+func (st *muxStream) Send(data []byte) error {
+	st.lock.Lock()
+	err := st.err
+	st.lock.Unlock()
+	if err != nil {
+		return err
+	}
+
+	return st.mux.writeFrame(muxFrameData, st.id, data)
+}
+
+// Recv receives data via the session.
+func (st *muxStream) Recv(timeout time.Duration) ([]byte, error) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		st.lock.Lock()
+		if len(st.queue) > 0 {
+			data := st.queue[0]
+			st.queue = st.queue[1:]
+			st.queuedBytes -= int64(len(data))
+			st.lock.Unlock()
+			netceptor.SessionBufferBudget.Release(int64(len(data)))
+
+			return data, nil
+		}
+		err := st.err
+		st.lock.Unlock()
+		if err != nil {
+			return nil, err
+		}
+		select {
+		case <-st.notify:
+		case <-timer.C:
+			return nil, netceptor.ErrTimeout
+		}
+	}
+}
+
+// CompressionNegotiated returns whether the shared connection negotiated permessage-deflate compression.
+func (st *muxStream) CompressionNegotiated() bool {
+	return st.mux.compressed
+}
+
+// ListenPath returns the listener path the shared connection was accepted on, or an empty string for a
+// dialer's session.
+func (st *muxStream) ListenPath() string {
+	return st.mux.listenPath
+}
+
+// Close closes the session, without affecting other sessions on the same connection.
+func (st *muxStream) Close() error {
+	st.lock.Lock()
+	alreadyClosed := st.err != nil
+	if !alreadyClosed {
+		st.err = ErrMuxClosed
+	}
+	st.discard = true
+	queued := st.queuedBytes
+	st.queue = nil
+	st.queuedBytes = 0
+	st.lock.Unlock()
+	netceptor.SessionBufferBudget.Release(queued)
+	var err error
+	if !alreadyClosed {
+		err = st.mux.writeFrame(muxFrameClose, st.id, nil)
+	}
+	st.mux.removeStream(st.id)
+	if st.closeChan != nil {
+		st.closeChanCloser.Do(func() {
+			close(st.closeChan)
+		})
+	}
+
+	return err
+}
+
+// websocketMuxPool holds the multiplexed connections of dialers, keyed by address and headers,
+// so that dialers to the same listener share a connection.
+var websocketMuxPool = struct {
+	lock  sync.Mutex
+	muxes map[string]*websocketMux
+}{
+	muxes: make(map[string]*websocketMux),
+}
+
+// pooledMuxStream opens a stream on the pooled connection for key, calling dial to create the
+// connection if there is none.  If the remote side does not support multiplexing, dial's connection
+// is returned as an ordinary session.
+func pooledMuxStream(key string, closeChan chan struct{},
+	dial func() (*websocket.Conn, bool, error)) (netceptor.BackendSession, error) {
+	websocketMuxPool.lock.Lock()
+	defer websocketMuxPool.lock.Unlock()
+	if m, ok := websocketMuxPool.muxes[key]; ok {
+		st, err := m.OpenStream(closeChan)
+		if err == nil {
+			return st, nil
+		}
+		delete(websocketMuxPool.muxes, key)
+	}
+	conn, compressed, err := dial()
+	if err != nil {
+		return nil, err
+	}
+	if conn.Subprotocol() != websocketMuxProtocol {
+		logger.Debug("Websocket peer does not support multiplexing; using one connection per session\n")
+		ws := newWebsocketSession(conn, closeChan)
+		ws.compressed = compressed
+
+		return ws, nil
+	}
+	m := newWebsocketMux(conn, compressed, "", nil)
+	m.onClose = func() {
+		websocketMuxPool.lock.Lock()
+		if websocketMuxPool.muxes[key] == m {
+			delete(websocketMuxPool.muxes, key)
+		}
+		websocketMuxPool.lock.Unlock()
+	}
+	websocketMuxPool.muxes[key] = m
+
+	return m.OpenStream(closeChan)
+}
@@ -0,0 +1,186 @@
+package backends
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ansible/receptor/pkg/netceptor"
+)
+
+// startMuxListener starts a websocket listener and returns its URL and session channel.
+func startMuxListener(ctx context.Context, t *testing.T, multiplex bool) (string, chan netceptor.BackendSession) {
+	li, err := NewWebsocketListener("127.0.0.1:0", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	li.SetMultiplex(multiplex)
+	sessChan, err := li.Start(ctx, &sync.WaitGroup{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return fmt.Sprintf("ws://%s/", li.Addr().String()), sessChan
+}
+
+// startMuxDialer starts a multiplexing websocket dialer and returns its first session.
+func startMuxDialer(ctx context.Context, t *testing.T, url string) netceptor.BackendSession {
+	d, err := NewWebsocketDialer(url, nil, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.SetMultiplex(true)
+	sessChan, err := d.Start(ctx, &sync.WaitGroup{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case sess := <-sessChan:
+		return sess
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out dialing websocket")
+	}
+
+	return nil
+}
+
+// acceptSession sends data on a dialer session and returns the listener session that received it.
+func acceptSession(t *testing.T, dialerSess netceptor.BackendSession, sessChan chan netceptor.BackendSession,
+	data string) netceptor.BackendSession {
+	if err := dialerSess.Send([]byte(data)); err != nil {
+		t.Fatal(err)
+	}
+	var sess netceptor.BackendSession
+	select {
+	case sess = <-sessChan:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out accepting websocket session")
+	}
+	expectRecv(t, sess, data)
+
+	return sess
+}
+
+func expectRecv(t *testing.T, sess netceptor.BackendSession, expected string) {
+	data, err := sess.Recv(5 * time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != expected {
+		t.Fatalf("expected %q, got %q", expected, data)
+	}
+}
+
+func TestWebsocketMultiplex(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	url, sessChan := startMuxListener(ctx, t, true)
+
+	d1 := startMuxDialer(ctx, t, url)
+	d2 := startMuxDialer(ctx, t, url)
+	s1, ok1 := d1.(*muxStream)
+	s2, ok2 := d2.(*muxStream)
+	if !ok1 || !ok2 {
+		t.Fatal("dialer sessions were not multiplexed")
+	}
+	if s1.mux != s2.mux {
+		t.Fatal("dialer sessions do not share a connection")
+	}
+
+	l1 := acceptSession(t, d1, sessChan, "hello from one")
+	l2 := acceptSession(t, d2, sessChan, "hello from two")
+	for i := 0; i < 10; i++ {
+		for _, pair := range []struct {
+			from netceptor.BackendSession
+			to   netceptor.BackendSession
+		}{{d1, l1}, {d2, l2}, {l1, d1}, {l2, d2}} {
+			msg := fmt.Sprintf("message %d to %p", i, pair.to)
+			if err := pair.from.Send([]byte(msg)); err != nil {
+				t.Fatal(err)
+			}
+			expectRecv(t, pair.to, msg)
+		}
+	}
+
+	// Closing one stream does not affect the other
+	if err := d1.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l1.Recv(5 * time.Second); err == nil || err == netceptor.ErrTimeout {
+		t.Fatalf("expected closed stream error, got %v", err)
+	}
+	if err := d2.Send([]byte("still here")); err != nil {
+		t.Fatal(err)
+	}
+	expectRecv(t, l2, "still here")
+
+	// Closing the last stream closes the connection
+	if err := l2.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d2.Recv(5 * time.Second); err == nil || err == netceptor.ErrTimeout {
+		t.Fatalf("expected closed stream error, got %v", err)
+	}
+	_ = d2.Close()
+	s1.mux.lock.Lock()
+	closed := s1.mux.closed
+	s1.mux.lock.Unlock()
+	if !closed {
+		t.Fatal("idle multiplexed connection was not closed")
+	}
+}
+
+func TestWebsocketMultiplexFallback(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	url, sessChan := startMuxListener(ctx, t, false)
+
+	d := startMuxDialer(ctx, t, url)
+	if _, ok := d.(*WebsocketSession); !ok {
+		t.Fatalf("expected an ordinary websocket session, got %T", d)
+	}
+	l := acceptSession(t, d, sessChan, "hello")
+	if err := l.Send([]byte("reply")); err != nil {
+		t.Fatal(err)
+	}
+	expectRecv(t, d, "reply")
+}
+
+func TestWebsocketMultiplexBufferFull(t *testing.T) {
+	budget := netceptor.SessionBufferBudget
+	if err := budget.SetLimit(1000); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = budget.SetLimit(netceptor.DefaultSessionBufferLimit)
+	}()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	url, sessChan := startMuxListener(ctx, t, true)
+	d1 := startMuxDialer(ctx, t, url)
+	d2 := startMuxDialer(ctx, t, url)
+	l1 := acceptSession(t, d1, sessChan, "slow")
+	l2 := acceptSession(t, d2, sessChan, "fast")
+
+	// l1 stops reading, so its data fills the budget and it is closed instead of stalling the connection
+	for i := 0; i < 5; i++ {
+		if err := d1.Send(make([]byte, 400)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := d2.Send([]byte("still here")); err != nil {
+		t.Fatal(err)
+	}
+	expectRecv(t, l2, "still here")
+	if _, err := l1.Recv(5 * time.Second); err != netceptor.ErrSessionBufferFull {
+		t.Fatalf("expected the slow stream to be closed, got %v", err)
+	}
+	if _, err := d1.Recv(5 * time.Second); err == nil || err == netceptor.ErrTimeout {
+		t.Fatalf("expected the other end of the slow stream to be closed, got %v", err)
+	}
+	_ = l1.Close()
+	_ = l2.Close()
+	_ = d2.Close()
+}
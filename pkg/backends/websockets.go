@@ -10,24 +10,35 @@ import (
 	"net"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/ansible/receptor/pkg/logger"
 	"github.com/ansible/receptor/pkg/netceptor"
 	"github.com/ansible/receptor/pkg/tls"
+	"github.com/ansible/receptor/pkg/utils"
 	"github.com/ghjm/cmdline"
 	"github.com/gorilla/websocket"
 )
 
 // WebsocketDialer implements Backend for outbound Websocket.
 type WebsocketDialer struct {
-	address     string
-	origin      string
-	redial      bool
-	tlscfg      *tls.Config
-	extraHeader string
+	address      string
+	origin       string
+	redial       bool
+	redialDelays redialDelays
+	tlscfg       *tls.Config
+	extraHeader  string
+	multiplex    bool
+	keepAlive    time.Duration
+	proxyURL     *url.URL
+	chunkSize    int
+	readTimeout  time.Duration
+	compression  bool
+	dscp         int
 }
 
 // NewWebsocketDialer instantiates a new WebsocketDialer backend.
@@ -41,38 +52,275 @@ func NewWebsocketDialer(address string, tlscfg *tls.Config, extraHeader string,
 		httpScheme = "https"
 	}
 	wd := WebsocketDialer{
-		address:     address,
-		origin:      fmt.Sprintf("%s://%s", httpScheme, addrURL.Host),
-		redial:      redial,
-		tlscfg:      tlscfg,
-		extraHeader: extraHeader,
+		address:      address,
+		origin:       fmt.Sprintf("%s://%s", httpScheme, addrURL.Host),
+		redial:       redial,
+		redialDelays: defaultRedialDelays(),
+		tlscfg:       tlscfg,
+		extraHeader:  extraHeader,
+		chunkSize:    DefaultWebsocketWriteChunkSize,
 	}
 
 	return &wd, nil
 }
 
+// SetRedialDelays sets how long the dialer waits to redial after a connection is lost or a dial fails: first
+// for the first retry, then backing off up to max.  It is only effective if used prior to calling Start.
+func (b *WebsocketDialer) SetRedialDelays(first time.Duration, max time.Duration) {
+	b.redialDelays = newRedialDelays(first, max)
+}
+
+// SetMultiplex sets whether the dialer offers to share a single websocket connection between all
+// dialers to the same address.  It is only effective if used prior to calling Start.
+func (b *WebsocketDialer) SetMultiplex(multiplex bool) {
+	b.multiplex = multiplex
+}
+
+// SetTCPKeepAlive sets the keepalive period of the underlying TCP connection.  Zero leaves the
+// system default in place, and a negative value disables keepalive.
+// It is only effective if used prior to calling Start.
+func (b *WebsocketDialer) SetTCPKeepAlive(period time.Duration) {
+	b.keepAlive = period
+}
+
+// SetWriteChunkSize sets the size above which messages are written through a message writer in chunks of
+// that size, so that no single write to the connection is larger.  Zero or less writes every message at once.
+// It is only effective if used prior to calling Start.
+func (b *WebsocketDialer) SetWriteChunkSize(size int) {
+	b.chunkSize = size
+}
+
+// SetReadDeadline sets how long a session may go without receiving anything before it is closed.  While
+// it is set, sessions ping the peer, and the pongs count as received.  Zero disables the deadline.
+// It is only effective if used prior to calling Start.
+func (b *WebsocketDialer) SetReadDeadline(timeout time.Duration) {
+	b.readTimeout = timeout
+}
+
+// SetCompression sets whether the dialer offers permessage-deflate compression.  Whether it is used
+// depends on the listener, and is reported by each session's CompressionNegotiated.
+// It is only effective if used prior to calling Start.
+func (b *WebsocketDialer) SetCompression(compression bool) {
+	b.compression = compression
+}
+
+// SetDSCP sets the Differentiated Services Code Point that the packets of the dialer's connections are
+// marked with, so the network can prioritize them.  Zero leaves them unmarked.
+// It is only effective if used prior to calling Start.
+func (b *WebsocketDialer) SetDSCP(dscp int) {
+	b.dscp = dscp
+}
+
+// SetOrigin sets the Origin header sent with the handshake, replacing the one derived from the address.
+// It is only effective if used prior to calling Start.
+func (b *WebsocketDialer) SetOrigin(origin string) {
+	b.origin = origin
+}
+
+// ParseOrigin validates a websocket Origin header value, which must be a scheme and host with an optional
+// port, such as https://example.com:8443.
+func ParseOrigin(origin string) (string, error) {
+	u, err := url.Parse(origin)
+	if err != nil {
+		return "", fmt.Errorf("origin %s is not valid: %s", origin, err)
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return "", fmt.Errorf("origin %s must include a scheme and host", origin)
+	}
+	if u.User != nil || (u.Path != "" && u.Path != "/") || u.RawQuery != "" || u.Fragment != "" {
+		return "", fmt.Errorf("origin %s must only have a scheme, host and port", origin)
+	}
+
+	return fmt.Sprintf("%s://%s", u.Scheme, u.Host), nil
+}
+
+// SetProxy sets a forward proxy that the dialer connects through, overriding the proxy environment variables.
+// It is only effective if used prior to calling Start.
+func (b *WebsocketDialer) SetProxy(proxyURL *url.URL) {
+	b.proxyURL = proxyURL
+}
+
+// ParseProxyURL validates a forward proxy URL, which must use the http (HTTP CONNECT) or socks5 scheme.
+// Credentials are given as username and password, and not in the URL, which is not treated as a secret.
+func ParseProxyURL(proxyURL string, username string, password string) (*url.URL, error) {
+	u, err := url.Parse(proxyURL)
+	if err != nil {
+		// The error of url.Parse quotes the whole URL, including any credentials
+		var ue *url.Error
+		if errors.As(err, &ue) {
+			err = ue.Err
+		}
+
+		return nil, fmt.Errorf("proxy URL is not valid: %s", err)
+	}
+	if u.User != nil {
+		return nil, fmt.Errorf("proxy URL %s must not include credentials: set the proxy user and password instead",
+			u.Redacted())
+	}
+	if u.Scheme != "http" && u.Scheme != "socks5" {
+		return nil, fmt.Errorf("proxy URL %s must use the http or socks5 scheme", proxyURL)
+	}
+	if u.Hostname() == "" || u.Port() == "" {
+		return nil, fmt.Errorf("proxy URL %s must include a host and port", proxyURL)
+	}
+	if username != "" {
+		u.User = url.UserPassword(username, password)
+	} else if password != "" {
+		return nil, fmt.Errorf("proxy password given without a username")
+	}
+
+	return u, nil
+}
+
+// dial opens a new websocket connection, and returns whether it negotiated compression.
+func (b *WebsocketDialer) dial(ctx context.Context) (*websocket.Conn, bool, error) {
+	dialer := websocket.Dialer{
+		TLSClientConfig:   b.tlscfg,
+		Proxy:             http.ProxyFromEnvironment,
+		EnableCompression: b.compression,
+	}
+	if b.proxyURL != nil {
+		dialer.Proxy = http.ProxyURL(b.proxyURL)
+	}
+	if b.multiplex {
+		dialer.Subprotocols = []string{websocketMuxProtocol}
+	}
+	handshake := newHandshakeWatch(ctx)
+	dialer.NetDialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := (&net.Dialer{Control: utils.DSCPControl(b.dscp)}).DialContext(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		if b.keepAlive != 0 {
+			if err := setTCPKeepAlive(conn, b.keepAlive); err != nil {
+				_ = conn.Close()
+
+				return nil, err
+			}
+		}
+		if err := handshake.watch(conn); err != nil {
+			_ = conn.Close()
+
+			return nil, err
+		}
+
+		return conn, nil
+	}
+	header := make(http.Header)
+	if b.extraHeader != "" {
+		extraHeaderParts := strings.SplitN(b.extraHeader, ":", 2)
+		header.Add(extraHeaderParts[0], extraHeaderParts[1])
+	}
+	header.Add("origin", b.origin)
+	conn, resp, err := dialer.DialContext(ctx, b.address, header)
+	handshake.stop()
+	if resp != nil && resp.Body != nil {
+		closeErr := resp.Body.Close()
+		if err == nil {
+			err = closeErr
+		}
+	}
+	if err == nil {
+		// The context may have been canceled just as the handshake completed
+		err = ctx.Err()
+	}
+	if err != nil {
+		if conn != nil {
+			_ = conn.Close()
+		}
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, false, ctxErr
+		}
+
+		return nil, false, err
+	}
+	compressed := b.compression && offersDeflate(resp.Header)
+	if b.compression {
+		logger.Debug("Websocket connection to %s %s\n", b.address, compressionDescription(compressed))
+	}
+
+	return conn, compressed, nil
+}
+
+// handshakeWatch aborts a websocket handshake when its context is canceled.  The websocket library only
+// applies the context's deadline once the connection is made, so without this a canceled dial would wait
+// for a peer that accepted the connection but never answers.
+type handshakeWatch struct {
+	ctx  context.Context
+	lock sync.Mutex
+	conn net.Conn
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// newHandshakeWatch starts watching ctx for the duration of a handshake.
+func newHandshakeWatch(ctx context.Context) *handshakeWatch {
+	h := &handshakeWatch{
+		ctx:  ctx,
+		done: make(chan struct{}),
+	}
+	h.wg.Add(1)
+	go func() {
+		defer h.wg.Done()
+		select {
+		case <-h.done:
+		case <-ctx.Done():
+			h.lock.Lock()
+			defer h.lock.Unlock()
+			if h.conn != nil {
+				// Fail any read or write in progress.  The websocket library closes the connection.
+				_ = h.conn.SetDeadline(time.Now())
+			}
+		}
+	}()
+
+	return h
+}
+
+// watch records the connection the handshake is made over, or returns an error if ctx is already canceled.
+func (h *handshakeWatch) watch(conn net.Conn) error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if err := h.ctx.Err(); err != nil {
+		return err
+	}
+	h.conn = conn
+
+	return nil
+}
+
+// stop ends the watch once the handshake is over, so that a later cancellation no longer affects the connection.
+func (h *handshakeWatch) stop() {
+	close(h.done)
+	h.wg.Wait()
+}
+
+// String returns a description of the dialer.
+func (b *WebsocketDialer) String() string {
+	return "ws-peer " + b.address
+}
+
 // Start runs the given session function over this backend service.
 func (b *WebsocketDialer) Start(ctx context.Context, wg *sync.WaitGroup) (chan netceptor.BackendSession, error) {
-	return dialerSession(ctx, wg, b.redial, 5*time.Second,
+	return dialerSession(ctx, wg, b.redial, b.redialDelays,
 		func(closeChan chan struct{}) (netceptor.BackendSession, error) {
-			dialer := websocket.Dialer{
-				TLSClientConfig: b.tlscfg,
-				Proxy:           http.ProxyFromEnvironment,
-			}
-			header := make(http.Header)
-			if b.extraHeader != "" {
-				extraHeaderParts := strings.SplitN(b.extraHeader, ":", 2)
-				header.Add(extraHeaderParts[0], extraHeaderParts[1])
+			if b.multiplex {
+				// Only dialers with identical settings may share a connection
+				key := fmt.Sprintf("%s|%s|%s|%p|%s|%t|%d", b.address, b.origin, b.extraHeader, b.tlscfg, b.proxyURL,
+					b.compression, b.dscp)
+
+				return pooledMuxStream(key, closeChan, func() (*websocket.Conn, bool, error) {
+					return b.dial(ctx)
+				})
 			}
-			header.Add("origin", b.origin)
-			conn, resp, err := dialer.DialContext(ctx, b.address, header)
+			conn, compressed, err := b.dial(ctx)
 			if err != nil {
 				return nil, err
 			}
-			if resp.Body.Close(); err != nil {
-				return nil, err
-			}
 			ns := newWebsocketSession(conn, closeChan)
+			ns.compressed = compressed
+			ns.writeChunkSize = b.chunkSize
+			ns.SetReadDeadline(b.readTimeout)
 
 			return ns, nil
 		})
@@ -80,20 +328,31 @@ func (b *WebsocketDialer) Start(ctx context.Context, wg *sync.WaitGroup) (chan n
 
 // WebsocketListener implements Backend for inbound Websocket.
 type WebsocketListener struct {
-	address string
-	path    string
-	tlscfg  *tls.Config
-	li      net.Listener
-	server  *http.Server
+	address      string
+	network      string
+	path         string
+	extraPaths   []string
+	tlscfg       *tls.Config
+	li           net.Listener
+	server       *http.Server
+	multiplex    bool
+	keepAlive    time.Duration
+	readTimeout  time.Duration
+	chunkSize    int
+	compression  bool
+	sockOpts     listenSocketOptions
+	alpnHandlers map[string]ALPNHandler
 }
 
 // NewWebsocketListener instantiates a new WebsocketListener backend.
 func NewWebsocketListener(address string, tlscfg *tls.Config) (*WebsocketListener, error) {
 	ul := WebsocketListener{
-		address: address,
-		path:    "/",
-		tlscfg:  tlscfg,
-		li:      nil,
+		address:   address,
+		network:   "tcp",
+		path:      "/",
+		tlscfg:    tlscfg,
+		li:        nil,
+		chunkSize: DefaultWebsocketWriteChunkSize,
 	}
 
 	return &ul, nil
@@ -105,6 +364,65 @@ func (b *WebsocketListener) SetPath(path string) {
 	b.path = path
 }
 
+// AddPath adds another URI path that the listener will be hosted on, sharing the same port.  Sessions
+// report the path they were accepted on with ListenPath.
+// It is only effective if used prior to calling Start.
+func (b *WebsocketListener) AddPath(path string) {
+	b.extraPaths = append(b.extraPaths, path)
+}
+
+// SetNetwork sets the network (tcp, tcp4 or tcp6) that the listener will bind on.
+// It is only effective if used prior to calling Start.
+func (b *WebsocketListener) SetNetwork(network string) {
+	b.network = network
+}
+
+// SetMultiplex sets whether the listener accepts multiplexed connections from dialers that offer them.
+// It is only effective if used prior to calling Start.
+func (b *WebsocketListener) SetMultiplex(multiplex bool) {
+	b.multiplex = multiplex
+}
+
+// SetTCPKeepAlive sets the keepalive period of accepted TCP connections.  Zero leaves the
+// system default in place, and a negative value disables keepalive.
+// It is only effective if used prior to calling Start.
+func (b *WebsocketListener) SetTCPKeepAlive(period time.Duration) {
+	b.keepAlive = period
+}
+
+// SetWriteChunkSize sets the size above which messages are written through a message writer in chunks of
+// that size, so that no single write to the connection is larger.  Zero or less writes every message at once.
+// It is only effective if used prior to calling Start.
+func (b *WebsocketListener) SetWriteChunkSize(size int) {
+	b.chunkSize = size
+}
+
+// SetReadDeadline sets how long a session may go without receiving anything before it is closed.  While
+// it is set, sessions ping the peer, and the pongs count as received.  Zero disables the deadline.
+// It is only effective if used prior to calling Start.
+func (b *WebsocketListener) SetReadDeadline(timeout time.Duration) {
+	b.readTimeout = timeout
+}
+
+// SetCompression sets whether the listener accepts permessage-deflate compression from dialers that offer
+// it.  Whether it is used is reported by each session's CompressionNegotiated.
+// It is only effective if used prior to calling Start.
+func (b *WebsocketListener) SetCompression(compression bool) {
+	b.compression = compression
+}
+
+// SetBacklog sets the most connections that may wait to be accepted.  Zero leaves the system default.
+// It is only effective if used prior to calling Start.
+func (b *WebsocketListener) SetBacklog(backlog int) {
+	b.sockOpts.backlog = backlog
+}
+
+// SetReusePort sets whether the listener sets SO_REUSEPORT, so that other processes can listen on the
+// same port and share its connections.  It is only effective if used prior to calling Start.
+func (b *WebsocketListener) SetReusePort(reusePort bool) {
+	b.sockOpts.reusePort = reusePort
+}
+
 // Addr returns the network address the listener is listening on.
 func (b *WebsocketListener) Addr() net.Addr {
 	if b.li == nil {
@@ -119,59 +437,218 @@ func (b *WebsocketListener) Path() string {
 	return b.path
 }
 
+// Paths returns all the URI paths the websocket is configured on, starting with Path.
+func (b *WebsocketListener) Paths() []string {
+	return append([]string{b.path}, b.extraPaths...)
+}
+
+// String returns a description of the listener.
+func (b *WebsocketListener) String() string {
+	return "ws-listener " + b.address + b.path
+}
+
 // Start runs the given session function over the WebsocketListener backend.
 func (b *WebsocketListener) Start(ctx context.Context, wg *sync.WaitGroup) (chan netceptor.BackendSession, error) {
-	var err error
 	sessChan := make(chan netceptor.BackendSession)
 	mux := http.NewServeMux()
-	mux.HandleFunc(b.path, func(w http.ResponseWriter, r *http.Request) {
-		upgrader := websocket.Upgrader{}
-		conn, err := upgrader.Upgrade(w, r, nil)
+	registered := make(map[string]bool)
+	for _, path := range b.Paths() {
+		// The mux panics on a duplicate path, so refuse it here
+		if registered[path] {
+			return nil, fmt.Errorf("path %s is configured more than once on %s", path, b.address)
+		}
+		registered[path] = true
+		mux.HandleFunc(path, b.upgradeHandler(ctx, sessChan, path))
+	}
+	if len(b.alpnHandlers) > 0 && b.tlscfg == nil {
+		return nil, fmt.Errorf("ALPN protocols on %s need TLS", b.address)
+	}
+	// Only record the listener once it is bound, so a failed bind leaves the backend as it was.  Listeners
+	// on privileged ports are retained when the backend is stopped, so a reload can reuse them.
+	var li net.Listener
+	var release func(retain bool)
+	if inherited := takeInheritedListener(b.address); inherited != nil {
+		li = inherited
+		release = func(bool) { _ = inherited.Close() }
+	} else {
+		var err error
+		li, release, err = listenRetained(b.network, b.address, b.sockOpts)
 		if err != nil {
-			logger.Error("Error upgrading websocket connection: %s\n", err)
-
-			return
+			return nil, fmt.Errorf("could not listen on %s: %w", b.address, err)
 		}
-		ws := newWebsocketSession(conn, nil)
-		sessChan <- ws
-	})
-	b.li, err = net.Listen("tcp", b.address)
-	if err != nil {
-		return nil, err
+	}
+	var tli *net.TCPListener
+	switch l := li.(type) {
+	case *net.TCPListener:
+		tli = l
+	case *retainedListener:
+		tli = l.TCPListener
+	}
+	if tli != nil {
+		registerGracefulListener(b.address, tli)
+	}
+	if b.keepAlive != 0 {
+		li = &keepAliveListener{
+			Listener: li,
+			period:   b.keepAlive,
+		}
+	}
+	b.li = li
+	// The server is created before it runs, so that a backend canceled straight away can close it
+	b.server = &http.Server{
+		Addr:    b.address,
+		Handler: mux,
 	}
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
 		var err error
-		b.server = &http.Server{
-			Addr:    b.address,
-			Handler: mux,
-		}
 		if b.tlscfg == nil {
 			err = b.server.Serve(b.li)
 		} else {
 			b.server.TLSConfig = b.tlscfg
+			if len(b.alpnHandlers) > 0 {
+				b.server.TLSConfig, b.server.TLSNextProto = b.alpnServerConfig()
+			}
 			err = b.server.ServeTLS(b.li, "", "")
 		}
+		if tli != nil {
+			unregisterGracefulListener(b.address, tli)
+			if wasHandedOff(tli) {
+				err = nil
+			}
+		}
 		if err != nil && err != http.ErrServerClosed {
 			logger.Error("HTTP server error: %s\n", err)
 		}
+		release(ctx.Err() != nil)
 	}()
 	go func() {
 		<-ctx.Done()
 		_ = b.server.Close()
 	}()
-	logger.Debug("Listening on Websocket %s path %s\n", b.Addr().String(), b.Path())
+	logger.Debug("Listening on Websocket %s path %s\n", b.Addr().String(), strings.Join(b.Paths(), ", "))
 
 	return sessChan, nil
 }
 
+// upgradeHandler returns the HTTP handler that accepts websocket connections on one of the listener's
+// paths, and sends their sessions, tagged with the path, to sessChan.
+func (b *WebsocketListener) upgradeHandler(ctx context.Context, sessChan chan netceptor.BackendSession,
+	listenPath string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		upgrader := websocket.Upgrader{
+			EnableCompression: b.compression,
+		}
+		if b.multiplex {
+			upgrader.Subprotocols = []string{websocketMuxProtocol}
+		}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			logger.Error("Error upgrading websocket connection: %s\n", err)
+
+			return
+		}
+		if len(b.extraPaths) > 0 {
+			logger.Debug("Websocket connection from %s on path %s\n", r.RemoteAddr, listenPath)
+		}
+		// The upgrader accepts compression whenever it is enabled and the dialer offers it
+		compressed := b.compression && offersDeflate(r.Header)
+		if b.compression {
+			logger.Debug("Websocket connection from %s %s\n", r.RemoteAddr, compressionDescription(compressed))
+		}
+		if conn.Subprotocol() == websocketMuxProtocol {
+			newWebsocketMux(conn, compressed, listenPath, func(st *muxStream) {
+				select {
+				case sessChan <- st:
+				case <-ctx.Done():
+					_ = st.Close()
+				}
+			})
+
+			return
+		}
+		ws := newWebsocketSession(conn, nil)
+		ws.compressed = compressed
+		ws.listenPath = listenPath
+		ws.writeChunkSize = b.chunkSize
+		ws.SetReadDeadline(b.readTimeout)
+		sessChan <- ws
+	}
+}
+
+// keepAliveListener sets the TCP keepalive period of accepted connections.
+type keepAliveListener struct {
+	net.Listener
+	period time.Duration
+}
+
+// Accept waits for and returns the next connection to the listener.
+func (li *keepAliveListener) Accept() (net.Conn, error) {
+	conn, err := li.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if err := setTCPKeepAlive(conn, li.period); err != nil {
+		logger.Warning("Could not set TCP keepalive on connection from %s: %s\n", conn.RemoteAddr(), err)
+	}
+
+	return conn, nil
+}
+
+// setTCPKeepAlive enables keepalive with the given period on a TCP connection, or disables it if the period is negative.
+func setTCPKeepAlive(conn net.Conn, period time.Duration) error {
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		return nil
+	}
+	if period < 0 {
+		return tcpConn.SetKeepAlive(false)
+	}
+	if err := tcpConn.SetKeepAlive(true); err != nil {
+		return err
+	}
+
+	return tcpConn.SetKeepAlivePeriod(period)
+}
+
+// DefaultWebsocketWriteChunkSize is the size above which websocket messages are written in chunks.
+const DefaultWebsocketWriteChunkSize = 64 * 1024
+
+// websocketCloseTimeout is how long to wait for a close frame to be sent before closing the connection.
+const websocketCloseTimeout = time.Second
+
+// WebsocketCloseError is returned by WebsocketSession.Recv when the peer closed the session.
+type WebsocketCloseError struct {
+	Code   int
+	Reason string
+}
+
+func (e *WebsocketCloseError) Error() string {
+	if e.Reason == "" {
+		return fmt.Sprintf("websocket closed by peer with code %d", e.Code)
+	}
+
+	return fmt.Sprintf("websocket closed by peer with code %d: %s", e.Code, e.Reason)
+}
+
+// Graceful returns true if the peer closed the session on purpose, rather than because of an error.
+func (e *WebsocketCloseError) Graceful() bool {
+	return e.Code == websocket.CloseNormalClosure || e.Code == websocket.CloseGoingAway
+}
+
 // WebsocketSession implements BackendSession for WebsocketDialer and WebsocketListener.
 type WebsocketSession struct {
 	conn            *websocket.Conn
 	recvChan        chan *recvResult
 	closeChan       chan struct{}
 	closeChanCloser sync.Once
+	closeFrameOnce  sync.Once
+	writeChunkSize  int
+	readDeadline    int64
+	pingerOnce      sync.Once
+	compressed      bool
+	listenPath      string
 }
 
 type recvResult struct {
@@ -185,16 +662,75 @@ func newWebsocketSession(conn *websocket.Conn, closeChan chan struct{}) *Websock
 		recvChan:        make(chan *recvResult),
 		closeChan:       closeChan,
 		closeChanCloser: sync.Once{},
+		writeChunkSize:  DefaultWebsocketWriteChunkSize,
 	}
+	conn.SetPongHandler(func(string) error {
+		ws.extendReadDeadline()
+
+		return nil
+	})
+	conn.SetPingHandler(func(appData string) error {
+		ws.extendReadDeadline()
+		// As in the default handler, a failure to answer is left for the next read or write to report
+		_ = conn.WriteControl(websocket.PongMessage, []byte(appData), time.Now().Add(websocketCloseTimeout))
+
+		return nil
+	})
 	go ws.recvChannelizer()
 
 	return ws
 }
 
+// CompressionNegotiated returns whether the session's connection negotiated permessage-deflate compression.
+func (ns *WebsocketSession) CompressionNegotiated() bool {
+	return ns.compressed
+}
+
+// ListenPath returns the listener path the session was accepted on, or an empty string for a dialer's session.
+func (ns *WebsocketSession) ListenPath() string {
+	return ns.listenPath
+}
+
+// offersDeflate returns whether the Sec-WebSocket-Extensions of a handshake include permessage-deflate.
+func offersDeflate(header http.Header) bool {
+	for _, value := range header.Values("Sec-WebSocket-Extensions") {
+		for _, ext := range strings.Split(value, ",") {
+			name := strings.SplitN(ext, ";", 2)[0]
+			if strings.EqualFold(strings.TrimSpace(name), "permessage-deflate") {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// compressionDescription describes whether compression was negotiated, for logging.
+func compressionDescription(compressed bool) string {
+	if compressed {
+		return "negotiated permessage-deflate compression"
+	}
+
+	return "did not negotiate compression"
+}
+
 // recvChannelizer receives messages and pushes them to a channel.
 func (ns *WebsocketSession) recvChannelizer() {
 	for {
-		_, data, err := ns.conn.ReadMessage()
+		msgType, data, err := ns.conn.ReadMessage()
+		var ce *websocket.CloseError
+		var ne net.Error
+		if errors.As(err, &ce) {
+			err = &WebsocketCloseError{Code: ce.Code, Reason: ce.Text}
+		} else if errors.As(err, &ne) && ne.Timeout() {
+			timeout := time.Duration(atomic.LoadInt64(&ns.readDeadline))
+			_ = ns.CloseWithReason(websocket.CloseGoingAway, "read deadline exceeded")
+			err = fmt.Errorf("nothing received from websocket peer for %s", timeout)
+		} else if err == nil && msgType != websocket.BinaryMessage {
+			_ = ns.CloseWithReason(websocket.CloseUnsupportedData, "expected a binary message")
+			data = nil
+			err = fmt.Errorf("received unexpected websocket message type %d", msgType)
+		}
 		ns.recvChan <- &recvResult{
 			data: data,
 			err:  err,
@@ -202,20 +738,94 @@ func (ns *WebsocketSession) recvChannelizer() {
 		if err != nil {
 			return
 		}
+		ns.extendReadDeadline()
+	}
+}
+
+// SetReadDeadline sets how long the session may go without receiving anything before it is closed.  This is
+// separate from the timeout given to Recv, which only bounds a single wait for data.  While the deadline is
+// set, the session pings the peer at a third of the deadline, and the pongs, like any other message,
+// restart it.  Zero disables the deadline.
+func (ns *WebsocketSession) SetReadDeadline(timeout time.Duration) {
+	if timeout < 0 {
+		timeout = 0
+	}
+	atomic.StoreInt64(&ns.readDeadline, int64(timeout))
+	ns.extendReadDeadline()
+	if timeout > 0 {
+		ns.pingerOnce.Do(func() {
+			go ns.pinger()
+		})
+	}
+}
+
+// extendReadDeadline restarts the read deadline, after something has been received from the peer.
+func (ns *WebsocketSession) extendReadDeadline() {
+	var deadline time.Time
+	if timeout := time.Duration(atomic.LoadInt64(&ns.readDeadline)); timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	_ = ns.conn.SetReadDeadline(deadline)
+}
+
+// pinger pings the peer while the read deadline is set, so an idle but live link is not closed.
+func (ns *WebsocketSession) pinger() {
+	for {
+		timeout := time.Duration(atomic.LoadInt64(&ns.readDeadline))
+		if timeout <= 0 {
+			return
+		}
+		time.Sleep(timeout / 3)
+		err := ns.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(websocketCloseTimeout))
+		if err != nil {
+			return
+		}
 	}
 }
 
-// Send sends data over the session.
+// SetWriteDeadline sets the time by which sends must complete, after which Send fails with an error wrapping
+// netceptor.ErrSendTimeout.  A timed out send may have written part of a message, so the session cannot be
+// used after one.  The zero time disables the deadline.
+func (ns *WebsocketSession) SetWriteDeadline(t time.Time) error {
+	return ns.conn.SetWriteDeadline(t)
+}
+
+// Send sends data over the session.  The peer receives it as a single message, even if it is written in chunks.
 func (ns *WebsocketSession) Send(data []byte) error {
+	if ns.writeChunkSize > 0 && len(data) > ns.writeChunkSize {
+		return sendError(ns.sendChunked(data))
+	}
 	err := ns.conn.WriteMessage(websocket.BinaryMessage, data)
 	if err != nil {
-		return err
+		return sendError(err)
 	}
 
 	return nil
 }
 
-// Recv receives data via the session.
+// sendChunked writes a message in pieces of at most writeChunkSize bytes.
+func (ns *WebsocketSession) sendChunked(data []byte) error {
+	w, err := ns.conn.NextWriter(websocket.BinaryMessage)
+	if err != nil {
+		return err
+	}
+	for len(data) > 0 {
+		n := ns.writeChunkSize
+		if n > len(data) {
+			n = len(data)
+		}
+		if _, err := w.Write(data[:n]); err != nil {
+			_ = w.Close()
+
+			return err
+		}
+		data = data[n:]
+	}
+
+	return w.Close()
+}
+
+// Recv receives data via the session.  If the peer closed the session, the error is a *WebsocketCloseError.
 func (ns *WebsocketSession) Recv(timeout time.Duration) ([]byte, error) {
 	select {
 	case rr := <-ns.recvChan:
@@ -225,14 +835,25 @@ func (ns *WebsocketSession) Recv(timeout time.Duration) ([]byte, error) {
 	}
 }
 
-// Close closes the session.
+// Close closes the session, telling the peer it was a normal closure.
 func (ns *WebsocketSession) Close() error {
+	return ns.CloseWithReason(websocket.CloseNormalClosure, "session closed")
+}
+
+// CloseWithReason sends a close frame with the given code and reason, then closes the session.  Only the
+// first close frame is sent, so later calls just close the connection.
+func (ns *WebsocketSession) CloseWithReason(code int, reason string) error {
 	if ns.closeChan != nil {
 		ns.closeChanCloser.Do(func() {
 			close(ns.closeChan)
 			ns.closeChan = nil
 		})
 	}
+	ns.closeFrameOnce.Do(func() {
+		// The peer may already be gone, in which case there is nobody to tell
+		_ = ns.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason),
+			time.Now().Add(websocketCloseTimeout))
+	})
 
 	return ns.conn.Close()
 }
@@ -243,12 +864,29 @@ func (ns *WebsocketSession) Close() error {
 
 // websocketListenerCfg is the cmdline configuration object for a websocket listener.
 type websocketListenerCfg struct {
-	BindAddr string             `description:"Local address to bind to" default:"0.0.0.0"`
-	Port     int                `description:"Local TCP port to run http server on" barevalue:"yes" required:"yes"`
-	Path     string             `description:"URI path to the websocket server" default:"/"`
-	TLS      string             `description:"Name of TLS server config"`
-	Cost     float64            `description:"Connection cost (weight)" default:"1.0"`
-	NodeCost map[string]float64 `description:"Per-node costs"`
+	BindAddr      string             `description:"Local address to bind to" default:"0.0.0.0"`
+	Interface     string             `description:"Network interface to bind to, overriding BindAddr"`
+	Port          int                `description:"Local TCP port to run http server on" barevalue:"yes" required:"yes"`
+	Network       string             `description:"Network to listen on (tcp, tcp4 or tcp6)" default:"tcp"`
+	Path          string             `description:"URI path to the websocket server" default:"/"`
+	ExtraPaths    []string           `description:"Additional URI paths to the websocket server, on the same port"`
+	PathPeers     map[string]string  `description:"URI paths mapped to comma-separated node IDs that are the only ones allowed to connect on them"`
+	TLS           string             `description:"Name of TLS server config"`
+	SNI           map[string]string  `description:"Names of TLS server configs to use for specific SNI host names, falling back to TLS"`
+	Cost          float64            `description:"Connection cost (weight)" default:"1.0"`
+	LatencyCost   float64            `description:"Latency component of a composite connection cost, used to route interactive traffic"`
+	BandwidthCost float64            `description:"Bandwidth component of a composite connection cost, used to route bulk traffic"`
+	NodeCost      map[string]float64 `description:"Per-node costs"`
+	PSK           string             `description:"Pre-shared key that dialers must prove knowledge of" redact:"true"`
+	PreviousPSKs  []string           `description:"Previous pre-shared keys that dialers may still use while the key is rotated" redact:"true"`
+	Multiplex     bool               `description:"Accept multiple sessions over one connection from dialers that offer it" default:"false"`
+	TCPKeepAlive  string             `description:"TCP keepalive period of accepted connections (0 for system default, negative to disable)" default:"0"`
+	WriteChunk    int                `description:"Write messages larger than this many bytes in chunks of this size (0 to disable)" default:"65536"`
+	ReadDeadline  string             `description:"Close a session after receiving nothing, not even a pong to a ping, for this long (0 to disable)" default:"0"`
+	Compression   bool               `description:"Accept permessage-deflate compression from dialers that offer it" default:"false"`
+	Backlog       int                `description:"Most connections that may wait to be accepted (0 for system default, Linux only)" default:"0"`
+	ReusePort     bool               `description:"Set SO_REUSEPORT so that other processes can listen on the same port and share its connections (Linux only)" default:"false"`
+	ALPN          map[string]string  `description:"TLS ALPN protocols of other services sharing the port, mapped to where their connections are forwarded: tcp:host:port or unix:path"`
 }
 
 // Prepare verifies the parameters are correct.
@@ -256,22 +894,93 @@ func (cfg websocketListenerCfg) Prepare() error {
 	if cfg.Cost <= 0.0 {
 		return fmt.Errorf("connection cost must be positive")
 	}
+	if _, err := newLinkCost(cfg.LatencyCost, cfg.BandwidthCost); err != nil {
+		return err
+	}
 	for node, cost := range cfg.NodeCost {
 		if cost <= 0.0 {
 			return fmt.Errorf("connection cost must be positive for %s", node)
 		}
 	}
 
+	if _, err := time.ParseDuration(cfg.TCPKeepAlive); err != nil {
+		return fmt.Errorf("invalid TCP keepalive period %s: %s", cfg.TCPKeepAlive, err)
+	}
+	if _, err := time.ParseDuration(cfg.ReadDeadline); err != nil {
+		return fmt.Errorf("invalid read deadline %s: %s", cfg.ReadDeadline, err)
+	}
+	if err := validateListenSocketOptions(cfg.Backlog, cfg.ReusePort); err != nil {
+		return err
+	}
+	if len(cfg.ALPN) > 0 && cfg.TLS == "" {
+		return fmt.Errorf("ALPN protocols need a TLS config")
+	}
+	for proto, target := range cfg.ALPN {
+		if err := validateALPNProtocol(proto); err != nil {
+			return err
+		}
+		if _, _, err := parseALPNTarget(target); err != nil {
+			return err
+		}
+	}
+	if _, err := cfg.pathAllowedPeers(); err != nil {
+		return err
+	}
+
+	bindAddr := cfg.BindAddr
+	if cfg.Interface != "" {
+		if err := validateListenNetwork(cfg.Network, ""); err != nil {
+			return err
+		}
+		var err error
+		bindAddr, err = interfaceAddr(cfg.Interface, cfg.Network)
+		if err != nil {
+			// The interface may not be up yet, so this is reported when the listener is started
+			return nil
+		}
+	} else if err := validateListenNetwork(cfg.Network, cfg.BindAddr); err != nil {
+		return err
+	}
+	claimListenAddressWithOptions("ws-listener", "tcp", net.JoinHostPort(bindAddr, strconv.Itoa(cfg.Port)), cfg.ReusePort)
+
 	return nil
 }
 
 // Run runs the action.
 func (cfg websocketListenerCfg) Run() error {
-	address := fmt.Sprintf("%s:%d", cfg.BindAddr, cfg.Port)
+	if err := checkListenerConflicts(true); err != nil {
+		return err
+	}
+	utils.RecordEffectiveConfig("ws-listener", cfg)
+	bindAddr := cfg.BindAddr
+	if cfg.Interface != "" {
+		var err error
+		bindAddr, err = interfaceAddr(cfg.Interface, cfg.Network)
+		if err != nil {
+			return err
+		}
+	}
+	address := net.JoinHostPort(bindAddr, strconv.Itoa(cfg.Port))
 	tlscfg, err := netceptor.MainInstance.GetServerTLSConfig(cfg.TLS)
 	if err != nil {
 		return err
 	}
+	if len(cfg.SNI) > 0 {
+		sniConfigs := make(map[string]*tls.Config)
+		for hostname, tlsName := range cfg.SNI {
+			sniConfigs[hostname], err = netceptor.MainInstance.GetServerTLSConfig(tlsName)
+			if err != nil {
+				return err
+			}
+			if sniConfigs[hostname] == nil {
+				return fmt.Errorf("no TLS server config for SNI host name %s", hostname)
+			}
+		}
+		tlscfg, err = tls.NewSNIConfig(tlscfg, sniConfigs)
+		if err != nil {
+			return err
+		}
+	}
 	b, err := NewWebsocketListener(address, tlscfg)
 	if err != nil {
 		logger.Error("Error creating listener %s: %s\n", address, err)
@@ -279,7 +988,48 @@ func (cfg websocketListenerCfg) Run() error {
 		return err
 	}
 	b.SetPath(cfg.Path)
-	err = netceptor.MainInstance.AddBackend(b, cfg.Cost, cfg.NodeCost)
+	for _, path := range cfg.ExtraPaths {
+		b.AddPath(path)
+	}
+	b.SetNetwork(cfg.Network)
+	b.SetMultiplex(cfg.Multiplex)
+	keepAlive, err := time.ParseDuration(cfg.TCPKeepAlive)
+	if err != nil {
+		return err
+	}
+	b.SetTCPKeepAlive(keepAlive)
+	b.SetWriteChunkSize(cfg.WriteChunk)
+	readDeadline, err := time.ParseDuration(cfg.ReadDeadline)
+	if err != nil {
+		return err
+	}
+	b.SetReadDeadline(readDeadline)
+	b.SetCompression(cfg.Compression)
+	b.SetBacklog(cfg.Backlog)
+	b.SetReusePort(cfg.ReusePort)
+	for proto, target := range cfg.ALPN {
+		handler, err := ALPNForwardHandler(proto, target)
+		if err != nil {
+			return err
+		}
+		if err := b.SetALPNHandler(proto, handler); err != nil {
+			return err
+		}
+	}
+	wb, err := wrapPSK(b, cfg.PSK, cfg.PreviousPSKs, true)
+	if err != nil {
+		return err
+	}
+	lc, err := newLinkCost(cfg.LatencyCost, cfg.BandwidthCost)
+	if err != nil {
+		return err
+	}
+	pathPeers, err := cfg.pathAllowedPeers()
+	if err != nil {
+		return err
+	}
+	err = netceptor.MainInstance.AddBackendWithOptions(wb, cfg.Cost, cfg.NodeCost,
+		netceptor.BackendOptions{LinkCost: lc, PathAllowedPeers: pathPeers})
 	if err != nil {
 		return err
 	}
@@ -287,13 +1037,63 @@ func (cfg websocketListenerCfg) Run() error {
 	return nil
 }
 
+// pathAllowedPeers parses PathPeers, checking that each path is one the listener serves.
+func (cfg websocketListenerCfg) pathAllowedPeers() (map[string][]string, error) {
+	pathPeers := make(map[string][]string)
+	for path, nodes := range cfg.PathPeers {
+		peers := make([]string, 0)
+		for _, node := range strings.Split(nodes, ",") {
+			if node = strings.TrimSpace(node); node != "" {
+				peers = append(peers, node)
+			}
+		}
+		pathPeers[path] = peers
+	}
+	if err := validatePathPeers(cfg.Path, cfg.ExtraPaths, pathPeers); err != nil {
+		return nil, err
+	}
+
+	return pathPeers, nil
+}
+
+// validatePathPeers checks that every path with allowed peers is served by the listener.
+func validatePathPeers(path string, extraPaths []string, pathPeers map[string][]string) error {
+	served := map[string]bool{path: true}
+	for _, extra := range extraPaths {
+		served[extra] = true
+	}
+	for p := range pathPeers {
+		if !served[p] {
+			return fmt.Errorf("allowed peers given for path %s, which the listener does not serve", p)
+		}
+	}
+
+	return nil
+}
+
 // websocketDialerCfg is the cmdline configuration object for a Websocket listener.
 type websocketDialerCfg struct {
-	Address     string  `description:"URL to connect to" barevalue:"yes" required:"yes"`
-	Redial      bool    `description:"Keep redialing on lost connection" default:"true"`
-	ExtraHeader string  `description:"Sends extra HTTP header on initial connection"`
-	TLS         string  `description:"Name of TLS client config"`
-	Cost        float64 `description:"Connection cost (weight)" default:"1.0"`
+	Address         string             `description:"URL to connect to" barevalue:"yes" required:"yes"`
+	Redial          bool               `description:"Keep redialing on lost connection" default:"true"`
+	FirstRetryDelay string             `description:"Delay before the first redial after a lost connection or failed dial" default:"100ms"`
+	MaxRetryDelay   string             `description:"Longest delay between redials as failures continue" default:"20s"`
+	ExtraHeader     string             `description:"Sends extra HTTP header on initial connection" redact:"true"`
+	Origin          string             `description:"Origin header to send on initial connection, such as https://example.com (defaults to the scheme and host of the address)"`
+	TLS             string             `description:"Name of TLS client config"`
+	Cost            float64            `description:"Connection cost (weight)" default:"1.0"`
+	LatencyCost     float64            `description:"Latency component of a composite connection cost, used to route interactive traffic"`
+	BandwidthCost   float64            `description:"Bandwidth component of a composite connection cost, used to route bulk traffic"`
+	NodeCost        map[string]float64 `description:"Per-node costs, overriding Cost for those nodes"`
+	PSK             string             `description:"Pre-shared key to authenticate to the listener with" redact:"true"`
+	Multiplex       bool               `description:"Share one connection with other dialers to the same address, if the listener supports it" default:"false"`
+	TCPKeepAlive    string             `description:"TCP keepalive period (0 for system default, negative to disable)" default:"0"`
+	ProxyURL        string             `description:"Forward proxy to connect through, as http://host:port or socks5://host:port"`
+	ProxyUser       string             `description:"User name to authenticate to the proxy with"`
+	ProxyPass       string             `description:"Password to authenticate to the proxy with" redact:"true"`
+	WriteChunk      int                `description:"Write messages larger than this many bytes in chunks of this size (0 to disable)" default:"65536"`
+	ReadDeadline    string             `description:"Close the session after receiving nothing, not even a pong to a ping, for this long (0 to disable)" default:"0"`
+	Compression     bool               `description:"Offer permessage-deflate compression to the listener" default:"false"`
+	DSCP            int                `description:"DSCP value (0-63) to mark the connection's packets with for network QoS (0 to leave unmarked)" default:"0"`
 }
 
 // Prepare verifies that we are reasonably ready to go.
@@ -301,18 +1101,51 @@ func (cfg websocketDialerCfg) Prepare() error {
 	if cfg.Cost <= 0.0 {
 		return fmt.Errorf("connection cost must be positive")
 	}
+	if _, err := newLinkCost(cfg.LatencyCost, cfg.BandwidthCost); err != nil {
+		return err
+	}
+	for node, cost := range cfg.NodeCost {
+		if cost <= 0.0 {
+			return fmt.Errorf("connection cost must be positive for %s", node)
+		}
+	}
 	if _, err := url.Parse(cfg.Address); err != nil {
 		return fmt.Errorf("address %s is not a valid URL: %s", cfg.Address, err)
 	}
 	if cfg.ExtraHeader != "" && !strings.Contains(cfg.ExtraHeader, ":") {
 		return fmt.Errorf("extra header must be in the form key:value")
 	}
+	if cfg.Origin != "" {
+		if _, err := ParseOrigin(cfg.Origin); err != nil {
+			return err
+		}
+	}
+	if _, err := time.ParseDuration(cfg.TCPKeepAlive); err != nil {
+		return fmt.Errorf("invalid TCP keepalive period %s: %s", cfg.TCPKeepAlive, err)
+	}
+	if _, err := time.ParseDuration(cfg.ReadDeadline); err != nil {
+		return fmt.Errorf("invalid read deadline %s: %s", cfg.ReadDeadline, err)
+	}
+	if cfg.ProxyURL != "" {
+		if _, err := ParseProxyURL(cfg.ProxyURL, cfg.ProxyUser, cfg.ProxyPass); err != nil {
+			return err
+		}
+	} else if cfg.ProxyUser != "" || cfg.ProxyPass != "" {
+		return fmt.Errorf("proxy credentials given without a proxy URL")
+	}
+	if _, _, err := parseRedialDelays(cfg.FirstRetryDelay, cfg.MaxRetryDelay); err != nil {
+		return err
+	}
+	if err := utils.ValidateDSCP(cfg.DSCP); err != nil {
+		return err
+	}
 
 	return nil
 }
 
 // Run runs the action.
 func (cfg websocketDialerCfg) Run() error {
+	utils.RecordEffectiveConfig("ws-peer", cfg)
 	logger.Debug("Running Websocket peer connection %s\n", cfg.Address)
 	u, err := url.Parse(cfg.Address)
 	if err != nil {
@@ -332,7 +1165,48 @@ func (cfg websocketDialerCfg) Run() error {
 
 		return err
 	}
-	err = netceptor.MainInstance.AddBackend(b, cfg.Cost, nil)
+	first, max, err := parseRedialDelays(cfg.FirstRetryDelay, cfg.MaxRetryDelay)
+	if err != nil {
+		return err
+	}
+	b.SetRedialDelays(first, max)
+	b.SetMultiplex(cfg.Multiplex)
+	keepAlive, err := time.ParseDuration(cfg.TCPKeepAlive)
+	if err != nil {
+		return err
+	}
+	b.SetTCPKeepAlive(keepAlive)
+	b.SetWriteChunkSize(cfg.WriteChunk)
+	readDeadline, err := time.ParseDuration(cfg.ReadDeadline)
+	if err != nil {
+		return err
+	}
+	b.SetReadDeadline(readDeadline)
+	b.SetCompression(cfg.Compression)
+	b.SetDSCP(cfg.DSCP)
+	if cfg.Origin != "" {
+		origin, err := ParseOrigin(cfg.Origin)
+		if err != nil {
+			return err
+		}
+		b.SetOrigin(origin)
+	}
+	if cfg.ProxyURL != "" {
+		proxyURL, err := ParseProxyURL(cfg.ProxyURL, cfg.ProxyUser, cfg.ProxyPass)
+		if err != nil {
+			return err
+		}
+		b.SetProxy(proxyURL)
+	}
+	wb, err := wrapPSK(b, cfg.PSK, nil, false)
+	if err != nil {
+		return err
+	}
+	lc, err := newLinkCost(cfg.LatencyCost, cfg.BandwidthCost)
+	if err != nil {
+		return err
+	}
+	err = netceptor.MainInstance.AddBackendWithOptions(wb, cfg.Cost, cfg.NodeCost, netceptor.BackendOptions{LinkCost: lc})
 	if err != nil {
 		return err
 	}
@@ -356,11 +1230,32 @@ func (cfg websocketListenerCfg) Reload() error {
 	return cfg.Run()
 }
 
+func (cfg websocketListenerCfg) Init() error {
+	resetListenerClaims()
+
+	return nil
+}
+
+func (cfg websocketListenerCfg) InitReload() error {
+	resetListenerClaims()
+
+	return nil
+}
+
+func (cfg websocketListenerCfg) ValidateReload() error {
+	if err := checkListenerConflicts(false); err != nil {
+		return err
+	}
+
+	return probeListenAddresses()
+}
+
 func init() {
 	cmdline.RegisterConfigTypeForApp("receptor-backends",
 		"ws-listener", "Run an http server that accepts websocket connections", websocketListenerCfg{}, cmdline.Section(backendSection))
 	cmdline.RegisterConfigTypeForApp("receptor-backends",
 		"ws-peer", "Connect outbound to a websocket peer", websocketDialerCfg{}, cmdline.Section(backendSection))
+	registerDialConfig("ws", func() dialConfig { return &WSDial{} })
 }
 
 var ErrInvalidHTTPHeader = errors.New("invalid http header")
@@ -368,14 +1263,49 @@ var ErrInvalidHTTPHeader = errors.New("invalid http header")
 type WSListen struct {
 	// TLS configuration for listening. Leave empty for no TLS at all.
 	TLS *tls.ServerConf `mapstructure:"tls"`
+	// TLS configurations to use for specific SNI host names, falling back to TLS.
+	SNI map[string]*tls.ServerConf `mapstructure:"sni"`
 	// Address to listen on ("host:port" from net package).
 	Address string `mapstructure:"address"`
 	// Path cost for this connection. Defaults to 1.0, may not be <= 0.0.`
 	Cost *float64 `mapstructure:"cost"`
+	// Latency component of a composite cost for this connection, used to route interactive traffic.
+	LatencyCost float64 `mapstructure:"latency-cost"`
+	// Bandwidth component of a composite cost for this connection, used to route bulk traffic.
+	BandwidthCost float64 `mapstructure:"bandwidth-cost"`
 	// Extra costs for specific nodes connecting.
 	NodeCosts map[string]float64 `mapstructure:"node-costs"`
 	// URI path to the websocket server. Default to /.
 	Path *string `mapstructure:"path" `
+	// Additional URI paths to the websocket server, on the same port.
+	ExtraPaths []string `mapstructure:"extra-paths"`
+	// URI paths mapped to the node IDs that are the only ones allowed to connect on them.
+	PathPeers map[string][]string `mapstructure:"path-peers"`
+	// Network to listen on: tcp, tcp4 or tcp6. Defaults to tcp.
+	Network string `mapstructure:"network"`
+	// Network interface to bind to. If set, its current address replaces the host part of Address.
+	Interface string `mapstructure:"interface"`
+	// Pre-shared key that dialers must prove knowledge of. Leave empty for none.
+	PSK string `mapstructure:"psk"`
+	// Previous pre-shared keys that dialers may still use while the key is rotated.
+	PreviousPSKs []string `mapstructure:"previous-psks"`
+	// Accept multiple sessions over one connection from dialers that offer it.
+	Multiplex bool `mapstructure:"multiplex"`
+	// TCP keepalive period of accepted connections. Leave unset for the system default, negative disables.
+	TCPKeepAlive *time.Duration `mapstructure:"tcp-keepalive"`
+	// Write messages larger than this many bytes in chunks of this size. Defaults to 65536, 0 disables.
+	WriteChunkSize *int `mapstructure:"write-chunk-size"`
+	// Close a session after receiving nothing, not even a pong to a ping, for this long. Leave unset to disable.
+	ReadDeadline time.Duration `mapstructure:"read-deadline"`
+	// Accept permessage-deflate compression from dialers that offer it.
+	Compression bool `mapstructure:"compression"`
+	// Most connections that may wait to be accepted. Defaults to 0, the system default. Linux only.
+	Backlog int `mapstructure:"backlog"`
+	// Set SO_REUSEPORT so that other processes can listen on the same port and share its connections. Linux only.
+	ReusePort bool `mapstructure:"reuse-port"`
+	// TLS ALPN protocols of other services sharing the port, mapped to where their connections are
+	// forwarded: tcp:host:port or unix:path. Requires TLS.
+	ALPN map[string]string `mapstructure:"alpn"`
 }
 
 func (c WSListen) setup(nc *netceptor.Netceptor) error {
@@ -387,21 +1317,106 @@ func (c WSListen) setup(nc *netceptor.Netceptor) error {
 			return fmt.Errorf("could not create tls config for ws listener %s: %w", c.Address, err)
 		}
 	}
+	if len(c.SNI) > 0 {
+		sniConfs := make(map[string]*tls.Config)
+		for hostname, sniTLS := range c.SNI {
+			sniConfs[hostname], err = sniTLS.TLSConfig()
+			if err != nil {
+				return fmt.Errorf("could not create tls config for ws listener %s, SNI host name %s: %w", c.Address, hostname, err)
+			}
+		}
+		tlsConf, err = tls.NewSNIConfig(tlsConf, sniConfs)
+		if err != nil {
+			return fmt.Errorf("could not create SNI tls config for ws listener %s: %w", c.Address, err)
+		}
+	}
 
-	b, err := NewWebsocketListener(c.Address, tlsConf)
-	if c.Path != nil {
-		b.SetPath(*c.Path)
+	address := c.Address
+	if c.Interface != "" {
+		_, port, err := net.SplitHostPort(c.Address)
+		if err != nil {
+			return fmt.Errorf("invalid ws listener address %s: %w", c.Address, err)
+		}
+		network := c.Network
+		if network == "" {
+			network = "tcp"
+		}
+		host, err := interfaceAddr(c.Interface, network)
+		if err != nil {
+			return fmt.Errorf("invalid ws listener config for %s: %w", c.Address, err)
+		}
+		address = net.JoinHostPort(host, port)
 	}
+
+	b, err := NewWebsocketListener(address, tlsConf)
 	if err != nil {
 		return fmt.Errorf("could not create ws listener for %s from config: %w", c.Address, err)
 	}
+	path := "/"
+	if c.Path != nil {
+		path = *c.Path
+		b.SetPath(path)
+	}
+	for _, path := range c.ExtraPaths {
+		b.AddPath(path)
+	}
+	if err := validatePathPeers(path, c.ExtraPaths, c.PathPeers); err != nil {
+		return fmt.Errorf("invalid ws listener config for %s: %w", c.Address, err)
+	}
+	if c.Network != "" {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			return fmt.Errorf("invalid ws listener address %s: %w", c.Address, err)
+		}
+		if err := validateListenNetwork(c.Network, host); err != nil {
+			return fmt.Errorf("invalid ws listener config for %s: %w", c.Address, err)
+		}
+		b.SetNetwork(c.Network)
+	}
+	b.SetMultiplex(c.Multiplex)
+	if c.TCPKeepAlive != nil {
+		b.SetTCPKeepAlive(*c.TCPKeepAlive)
+	}
+	if c.WriteChunkSize != nil {
+		b.SetWriteChunkSize(*c.WriteChunkSize)
+	}
+	b.SetReadDeadline(c.ReadDeadline)
+	b.SetCompression(c.Compression)
+	if err := validateListenSocketOptions(c.Backlog, c.ReusePort); err != nil {
+		return fmt.Errorf("invalid ws listener config for %s: %w", c.Address, err)
+	}
+	b.SetBacklog(c.Backlog)
+	b.SetReusePort(c.ReusePort)
+	if len(c.ALPN) > 0 && tlsConf == nil {
+		return fmt.Errorf("invalid ws listener config for %s: ALPN protocols need TLS", c.Address)
+	}
+	for proto, target := range c.ALPN {
+		handler, err := ALPNForwardHandler(proto, target)
+		if err != nil {
+			return fmt.Errorf("invalid ws listener config for %s: %w", c.Address, err)
+		}
+		if err := b.SetALPNHandler(proto, handler); err != nil {
+			return fmt.Errorf("invalid ws listener config for %s: %w", c.Address, err)
+		}
+	}
+
+	cost, nodeCosts, err := validateNodeCosts(c.Cost, c.NodeCosts)
+	if err != nil {
+		return fmt.Errorf("invalid ws listener config for %s: %w", c.Address, err)
+	}
+
+	wb, err := wrapPSK(b, c.PSK, c.PreviousPSKs, true)
+	if err != nil {
+		return fmt.Errorf("invalid ws listener config for %s: %w", c.Address, err)
+	}
 
-	cost, nodeCosts, err := validateListenerCost(c.Cost, c.NodeCosts)
+	lc, err := newLinkCost(c.LatencyCost, c.BandwidthCost)
 	if err != nil {
 		return fmt.Errorf("invalid ws listener config for %s: %w", c.Address, err)
 	}
 
-	if err := nc.AddBackend(b, cost, nodeCosts); err != nil {
+	opts := netceptor.BackendOptions{LinkCost: lc, PathAllowedPeers: c.PathPeers}
+	if err := nc.AddBackendWithOptions(wb, cost, nodeCosts, opts); err != nil {
 		return fmt.Errorf("error creating backend for ws listener %s: %w", c.Address, err)
 	}
 
@@ -416,17 +1431,50 @@ type WSDial struct {
 	Address string `mapstructure:"address"`
 	// Path cost for this connection. Defaults to 1.0, may not be <= 0.0.`
 	Cost *float64 `mapstructure:"cost"`
+	// Latency component of a composite cost for this connection, used to route interactive traffic.
+	LatencyCost float64 `mapstructure:"latency-cost"`
+	// Bandwidth component of a composite cost for this connection, used to route bulk traffic.
+	BandwidthCost float64 `mapstructure:"bandwidth-cost"`
+	// Per-node costs, overriding Cost when the listener turns out to be one of these nodes. May not be <= 0.0.
+	NodeCosts map[string]float64 `mapstructure:"node-costs"`
 	// Do not keep redialing on lost connection.
 	NoRedial bool `mapstructure:"no-redial"`
+	// Delay before the first redial after a lost connection or failed dial. Defaults to 100ms.
+	FirstRetryDelay *time.Duration `mapstructure:"first-retry-delay"`
+	// Longest delay between redials as failures continue. Defaults to 20s.
+	MaxRetryDelay *time.Duration `mapstructure:"max-retry-delay"`
 	// Sends extra HTTP header on initial connection.
 	ExtraHeader *string `mapstructure:"extra-header"`
+	// Origin header to send on initial connection. Defaults to the scheme and host of the address.
+	Origin string `mapstructure:"origin"`
+	// Pre-shared key to authenticate to the listener with. Leave empty for none.
+	PSK string `mapstructure:"psk"`
+	// Share one connection with other dialers to the same address, if the listener supports it.
+	Multiplex bool `mapstructure:"multiplex"`
+	// TCP keepalive period. Leave unset for the system default, negative disables.
+	TCPKeepAlive *time.Duration `mapstructure:"tcp-keepalive"`
+	// Forward proxy to connect through, as http://host:port or socks5://host:port. Overrides the environment.
+	ProxyURL string `mapstructure:"proxy-url"`
+	// User name to authenticate to the proxy with.
+	ProxyUser string `mapstructure:"proxy-user"`
+	// Password to authenticate to the proxy with.
+	ProxyPass string `mapstructure:"proxy-pass"`
+	// Write messages larger than this many bytes in chunks of this size. Defaults to 65536, 0 disables.
+	WriteChunkSize *int `mapstructure:"write-chunk-size"`
+	// Close a session after receiving nothing, not even a pong to a ping, for this long. Leave unset to disable.
+	ReadDeadline time.Duration `mapstructure:"read-deadline"`
+	// Offer permessage-deflate compression to the listener.
+	Compression bool `mapstructure:"compression"`
+	// DSCP value (0-63) to mark the connection's packets with for network QoS. Defaults to 0, unmarked.
+	DSCP int `mapstructure:"dscp"`
 }
 
-func (c WSDial) setup(nc *netceptor.Netceptor) error {
+// newBackend creates the dialer, without adding it to a netceptor.
+func (c WSDial) newBackend() (netceptor.Backend, error) {
 	extraHeader := ""
 	if c.ExtraHeader != nil {
 		if *c.ExtraHeader == "" || !strings.Contains(*c.ExtraHeader, ":") {
-			return fmt.Errorf("invalid ws parameters for ws listener %s: %w", c.Address, ErrInvalidHTTPHeader)
+			return nil, fmt.Errorf("invalid ws parameters for ws listener %s: %w", c.Address, ErrInvalidHTTPHeader)
 		}
 		extraHeader = *c.ExtraHeader
 	}
@@ -436,20 +1484,71 @@ func (c WSDial) setup(nc *netceptor.Netceptor) error {
 	if c.TLS != nil {
 		tlsConf, err = c.TLS.TLSConfig()
 		if err != nil {
-			return fmt.Errorf("could not create tls config for ws dialer %s: %w", c.Address, err)
+			return nil, fmt.Errorf("could not create tls config for ws dialer %s: %w", c.Address, err)
 		}
 	}
 	b, err := NewWebsocketDialer(c.Address, tlsConf, extraHeader, !c.NoRedial)
 	if err != nil {
-		return fmt.Errorf("could not create ws dialer for %s from config: %w", c.Address, err)
+		return nil, fmt.Errorf("could not create ws dialer for %s from config: %w", c.Address, err)
+	}
+	first, max, err := redialDelaysOrDefault(c.FirstRetryDelay, c.MaxRetryDelay)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ws parameters for ws dialer %s: %w", c.Address, err)
+	}
+	b.SetRedialDelays(first, max)
+	b.SetMultiplex(c.Multiplex)
+	if c.TCPKeepAlive != nil {
+		b.SetTCPKeepAlive(*c.TCPKeepAlive)
+	}
+	if c.WriteChunkSize != nil {
+		b.SetWriteChunkSize(*c.WriteChunkSize)
+	}
+	b.SetReadDeadline(c.ReadDeadline)
+	b.SetCompression(c.Compression)
+	if err := utils.ValidateDSCP(c.DSCP); err != nil {
+		return nil, fmt.Errorf("invalid ws dialer config for %s: %w", c.Address, err)
+	}
+	b.SetDSCP(c.DSCP)
+	if c.Origin != "" {
+		origin, err := ParseOrigin(c.Origin)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ws dialer config for %s: %w", c.Address, err)
+		}
+		b.SetOrigin(origin)
+	}
+	if c.ProxyURL != "" {
+		proxyURL, err := ParseProxyURL(c.ProxyURL, c.ProxyUser, c.ProxyPass)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ws dialer config for %s: %w", c.Address, err)
+		}
+		b.SetProxy(proxyURL)
+	}
+
+	wb, err := wrapPSK(b, c.PSK, nil, false)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ws dialer config for %s: %w", c.Address, err)
 	}
 
-	cost, err := validateDialCost(c.Cost)
+	return wb, nil
+}
+
+func (c WSDial) setup(nc *netceptor.Netceptor) error {
+	wb, err := c.newBackend()
+	if err != nil {
+		return err
+	}
+
+	cost, nodeCosts, err := validateNodeCosts(c.Cost, c.NodeCosts)
 	if err != nil {
 		return fmt.Errorf("invalid ws listener dialer for %s: %w", c.Address, err)
 	}
 
-	if err := nc.AddBackend(b, cost, nil); err != nil {
+	lc, err := newLinkCost(c.LatencyCost, c.BandwidthCost)
+	if err != nil {
+		return fmt.Errorf("invalid ws dialer config for %s: %w", c.Address, err)
+	}
+
+	if err := nc.AddBackendWithOptions(wb, cost, nodeCosts, netceptor.BackendOptions{LinkCost: lc}); err != nil {
 		return fmt.Errorf("error creating backend for ws dialer %s: %w", c.Address, err)
 	}
 
@@ -0,0 +1,809 @@
+package backends
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ansible/receptor/pkg/netceptor"
+	"github.com/gorilla/websocket"
+)
+
+// startWebsocketListener starts a websocket listener on the given network and returns its port.
+func startWebsocketListener(ctx context.Context, t *testing.T, network string, bindAddr string) int {
+	b, err := NewWebsocketListener(net.JoinHostPort(bindAddr, "0"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.SetNetwork(network)
+	_, err = b.Start(ctx, &sync.WaitGroup{})
+	if err != nil {
+		t.Skipf("cannot listen on %s: %s", network, err)
+	}
+
+	return b.Addr().(*net.TCPAddr).Port
+}
+
+func canConnect(network string, host string, port int) bool {
+	conn, err := net.DialTimeout(network, net.JoinHostPort(host, strconv.Itoa(port)), 2*time.Second)
+	if err != nil {
+		return false
+	}
+	_ = conn.Close()
+
+	return true
+}
+
+func TestWebsocketListenerTCP4(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	port := startWebsocketListener(ctx, t, "tcp4", "")
+	if !canConnect("tcp4", "127.0.0.1", port) {
+		t.Fatal("could not connect over IPv4 to tcp4 listener")
+	}
+	if canConnect("tcp6", "::1", port) {
+		t.Fatal("connected over IPv6 to tcp4 listener")
+	}
+}
+
+func TestWebsocketListenerTCP6(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	port := startWebsocketListener(ctx, t, "tcp6", "")
+	if !canConnect("tcp6", "::1", port) {
+		t.Fatal("could not connect over IPv6 to tcp6 listener")
+	}
+	if canConnect("tcp4", "127.0.0.1", port) {
+		t.Fatal("connected over IPv4 to tcp6 listener")
+	}
+}
+
+func TestValidateListenNetwork(t *testing.T) {
+	valid := [][2]string{
+		{"tcp", "0.0.0.0"},
+		{"tcp", "::"},
+		{"tcp4", "0.0.0.0"},
+		{"tcp6", "::"},
+		{"tcp6", "localhost"},
+	}
+	for _, v := range valid {
+		if err := validateListenNetwork(v[0], v[1]); err != nil {
+			t.Errorf("%s with %s: unexpected error %s", v[0], v[1], err)
+		}
+	}
+	invalid := [][2]string{
+		{"udp", "0.0.0.0"},
+		{"tcp4", "::"},
+		{"tcp6", "0.0.0.0"},
+	}
+	for _, v := range invalid {
+		if err := validateListenNetwork(v[0], v[1]); !errors.Is(err, ErrInvalidNetwork) {
+			t.Errorf("%s with %s: expected ErrInvalidNetwork, got %v", v[0], v[1], err)
+		}
+	}
+}
+
+// loopbackInterface returns the name of the host's loopback interface.
+func loopbackInterface(t *testing.T) string {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagLoopback != 0 {
+			return iface.Name
+		}
+	}
+	t.Skip("no loopback interface found")
+
+	return ""
+}
+
+func TestWebsocketListenerInterface(t *testing.T) {
+	iface := loopbackInterface(t)
+	host, err := interfaceAddr(iface, "tcp4")
+	if err != nil {
+		t.Skipf("loopback interface has no IPv4 address: %s", err)
+	}
+	if !net.ParseIP(host).IsLoopback() {
+		t.Fatalf("interface %s resolved to non-loopback address %s", iface, host)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	b, err := NewWebsocketListener(net.JoinHostPort(host, "0"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = b.Start(ctx, &sync.WaitGroup{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := b.Addr().(*net.TCPAddr)
+	if addr.IP.String() != host {
+		t.Fatalf("listener bound to %s, expected %s", addr.IP, host)
+	}
+	if !canConnect("tcp4", host, addr.Port) {
+		t.Fatal("could not connect to listener bound by interface")
+	}
+}
+
+func TestInterfaceAddrErrors(t *testing.T) {
+	_, err := interfaceAddr("receptor-no-such-interface", "tcp")
+	if err == nil {
+		t.Fatal("expected error for nonexistent interface")
+	}
+}
+
+// websocketSessionPair returns the server and client ends of a websocket session over loopback.
+func websocketSessionPair(t *testing.T) (*WebsocketSession, *WebsocketSession) {
+	server, conn := websocketServerSession(t)
+
+	return server, newWebsocketSession(conn, nil)
+}
+
+// websocketServerSession returns the server end of a websocket session over loopback, and the bare
+// client connection, which does nothing unless the test reads from or writes to it.
+func websocketServerSession(t *testing.T) (*WebsocketSession, *websocket.Conn) {
+	serverChan := make(chan *WebsocketSession, 1)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		upgrader := websocket.Upgrader{}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		serverChan <- newWebsocketSession(conn, nil)
+	})
+	li, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &http.Server{Handler: mux}
+	go func() {
+		_ = server.Serve(li)
+	}()
+	t.Cleanup(func() {
+		_ = server.Close()
+	})
+	conn, _, err := websocket.DefaultDialer.Dial("ws://"+li.Addr().String()+"/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case server := <-serverChan:
+		return server, conn
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the websocket session")
+	}
+
+	return nil, nil
+}
+
+// recvCloseError waits for the session to fail and returns the close error it reported.
+func recvCloseError(t *testing.T, ws *WebsocketSession) *WebsocketCloseError {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		_, err := ws.Recv(time.Second)
+		if errors.Is(err, netceptor.ErrTimeout) {
+			continue
+		}
+		var ce *WebsocketCloseError
+		if !errors.As(err, &ce) {
+			t.Fatalf("expected a websocket close error, got %v", err)
+		}
+
+		return ce
+	}
+	t.Fatal("timed out waiting for the session to close")
+
+	return nil
+}
+
+func TestWebsocketCloseWithReason(t *testing.T) {
+	server, client := websocketSessionPair(t)
+	if err := server.CloseWithReason(websocket.CloseGoingAway, "node shutting down"); err != nil {
+		t.Fatal(err)
+	}
+	ce := recvCloseError(t, client)
+	if ce.Code != websocket.CloseGoingAway || ce.Reason != "node shutting down" || !ce.Graceful() {
+		t.Fatalf("expected a graceful going-away close, got %+v", ce)
+	}
+	_ = client.Close()
+}
+
+func TestWebsocketClose(t *testing.T) {
+	server, client := websocketSessionPair(t)
+	if err := client.Close(); err != nil {
+		t.Fatal(err)
+	}
+	ce := recvCloseError(t, server)
+	if ce.Code != websocket.CloseNormalClosure || !ce.Graceful() {
+		t.Fatalf("expected a normal close, got %+v", ce)
+	}
+	_ = server.Close()
+}
+
+func TestWebsocketCloseProtocolError(t *testing.T) {
+	server, client := websocketSessionPair(t)
+	if err := client.conn.WriteMessage(websocket.TextMessage, []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := server.Recv(5 * time.Second); err == nil {
+		t.Fatal("expected a text message to be rejected")
+	}
+	ce := recvCloseError(t, client)
+	if ce.Code != websocket.CloseUnsupportedData || ce.Graceful() {
+		t.Fatalf("expected an unsupported data close, got %+v", ce)
+	}
+	_ = client.Close()
+}
+
+func TestWebsocketSendChunked(t *testing.T) {
+	for _, chunkSize := range []int{0, 1000, DefaultWebsocketWriteChunkSize} {
+		t.Run(strconv.Itoa(chunkSize), func(t *testing.T) {
+			server, client := websocketSessionPair(t)
+			defer server.Close()
+			defer client.Close()
+			client.writeChunkSize = chunkSize
+			data := make([]byte, 1024*1024)
+			for i := range data {
+				data[i] = byte(i % 251)
+			}
+			sendErr := make(chan error, 1)
+			go func() {
+				sendErr <- client.Send(data)
+			}()
+			msg, err := server.Recv(5 * time.Second)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(msg, data) {
+				t.Fatalf("received %d bytes, not the %d bytes sent", len(msg), len(data))
+			}
+			if err := <-sendErr; err != nil {
+				t.Fatal(err)
+			}
+			if err := client.Send([]byte("next")); err != nil {
+				t.Fatal(err)
+			}
+			msg, err = server.Recv(5 * time.Second)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(msg) != "next" {
+				t.Fatalf("expected the next message, got %d bytes", len(msg))
+			}
+		})
+	}
+}
+
+func TestWebsocketReadDeadlineIdle(t *testing.T) {
+	server, conn := websocketServerSession(t)
+	defer conn.Close()
+	// The client connection is not read from until the session is closed, and then ignores the pings
+	conn.SetPingHandler(func(string) error { return nil })
+	server.SetReadDeadline(300 * time.Millisecond)
+	start := time.Now()
+	_, err := server.Recv(5 * time.Second)
+	if err == nil || errors.Is(err, netceptor.ErrTimeout) {
+		t.Fatalf("expected the idle session to be closed, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 300*time.Millisecond {
+		t.Fatalf("session closed after %s, before the read deadline", elapsed)
+	}
+	// Past the unanswered pings, the client finds the session was closed
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, _, err = conn.ReadMessage()
+	if !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+		t.Fatalf("expected a going-away close, got %v", err)
+	}
+}
+
+func TestWebsocketReadDeadlinePongs(t *testing.T) {
+	server, client := websocketSessionPair(t)
+	defer server.Close()
+	defer client.Close()
+	// The client answers pings, so the session stays up though no data is sent
+	server.SetReadDeadline(300 * time.Millisecond)
+	if _, err := server.Recv(time.Second); !errors.Is(err, netceptor.ErrTimeout) {
+		t.Fatalf("expected the session to stay up, got %v", err)
+	}
+	if err := client.Send([]byte("still here")); err != nil {
+		t.Fatal(err)
+	}
+	msg, err := server.Recv(5 * time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(msg) != "still here" {
+		t.Fatalf("unexpected message %q", msg)
+	}
+}
+
+// compressionSessions connects a websocket dialer to a listener, each with compression set as given, and
+// returns the dialer and listener sessions.
+func compressionSessions(ctx context.Context, t *testing.T, listenerCompression bool,
+	dialerCompression bool) (netceptor.BackendSession, netceptor.BackendSession) {
+	li, err := NewWebsocketListener("127.0.0.1:0", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	li.SetCompression(listenerCompression)
+	listenerChan, err := li.Start(ctx, &sync.WaitGroup{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := NewWebsocketDialer("ws://"+li.Addr().String()+"/", nil, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.SetCompression(dialerCompression)
+	dialerChan, err := d.Start(ctx, &sync.WaitGroup{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var dialerSess netceptor.BackendSession
+	select {
+	case dialerSess = <-dialerChan:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out dialing websocket")
+	}
+
+	return dialerSess, acceptSession(t, dialerSess, listenerChan, "hello")
+}
+
+func TestWebsocketCompressionNegotiated(t *testing.T) {
+	for _, tc := range []struct {
+		listener bool
+		dialer   bool
+	}{{false, false}, {true, false}, {false, true}, {true, true}} {
+		t.Run(fmt.Sprintf("listener=%t,dialer=%t", tc.listener, tc.dialer), func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			dialerSess, listenerSess := compressionSessions(ctx, t, tc.listener, tc.dialer)
+			defer dialerSess.Close()
+			defer listenerSess.Close()
+			expected := tc.listener && tc.dialer
+			for name, sess := range map[string]netceptor.BackendSession{"dialer": dialerSess, "listener": listenerSess} {
+				cr, ok := sess.(netceptor.CompressionReporter)
+				if !ok {
+					t.Fatalf("%s session does not report compression", name)
+				}
+				if cr.CompressionNegotiated() != expected {
+					t.Fatalf("expected %s session compression to be %t", name, expected)
+				}
+			}
+			// Compressed or not, data gets through both ways
+			data := bytes.Repeat([]byte("compressible "), 1000)
+			if err := listenerSess.Send(data); err != nil {
+				t.Fatal(err)
+			}
+			expectRecv(t, dialerSess, string(data))
+		})
+	}
+}
+
+func TestWebsocketCompressionIncapablePeer(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	li, err := NewWebsocketListener("127.0.0.1:0", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	li.SetCompression(true)
+	sessChan, err := li.Start(ctx, &sync.WaitGroup{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The default gorilla dialer does not offer compression
+	conn, resp, err := websocket.DefaultDialer.Dial("ws://"+li.Addr().String()+"/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if offersDeflate(resp.Header) {
+		t.Fatal("listener accepted compression that was not offered")
+	}
+	if err := conn.WriteMessage(websocket.BinaryMessage, []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case sess := <-sessChan:
+		defer sess.Close()
+		expectRecv(t, sess, "hello")
+		if sess.(netceptor.CompressionReporter).CompressionNegotiated() {
+			t.Fatal("expected no compression with a peer that does not offer it")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out accepting websocket session")
+	}
+}
+
+func TestOffersDeflate(t *testing.T) {
+	for _, tc := range []struct {
+		values   []string
+		expected bool
+	}{
+		{nil, false},
+		{[]string{"permessage-deflate"}, true},
+		{[]string{"permessage-deflate; server_no_context_takeover; client_no_context_takeover"}, true},
+		{[]string{"x-webkit-deflate-frame, Permessage-Deflate"}, true},
+		{[]string{"x-webkit-deflate-frame", "permessage-deflate; client_max_window_bits"}, true},
+		{[]string{"x-webkit-deflate-frame"}, false},
+		{[]string{"permessage-deflate-extra"}, false},
+	} {
+		header := http.Header{}
+		for _, value := range tc.values {
+			header.Add("Sec-WebSocket-Extensions", value)
+		}
+		if offersDeflate(header) != tc.expected {
+			t.Errorf("expected offersDeflate(%q) to be %t", tc.values, tc.expected)
+		}
+	}
+}
+
+// listenPather is implemented by listener sessions that report the path they were accepted on.
+type listenPather interface {
+	ListenPath() string
+}
+
+func TestWebsocketListenerPaths(t *testing.T) {
+	for _, multiplex := range []bool{false, true} {
+		t.Run(fmt.Sprintf("multiplex=%t", multiplex), func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			li, err := NewWebsocketListener("127.0.0.1:0", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			li.SetPath("/mesh")
+			li.AddPath("/edge")
+			li.SetMultiplex(multiplex)
+			sessChan, err := li.Start(ctx, &sync.WaitGroup{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, path := range []string{"/mesh", "/edge"} {
+				d, err := NewWebsocketDialer("ws://"+li.Addr().String()+path, nil, "", false)
+				if err != nil {
+					t.Fatal(err)
+				}
+				d.SetMultiplex(multiplex)
+				dialerChan, err := d.Start(ctx, &sync.WaitGroup{})
+				if err != nil {
+					t.Fatal(err)
+				}
+				var dialerSess netceptor.BackendSession
+				select {
+				case dialerSess = <-dialerChan:
+				case <-time.After(5 * time.Second):
+					t.Fatalf("timed out dialing %s", path)
+				}
+				sess := acceptSession(t, dialerSess, sessChan, "hello "+path)
+				if lp := sess.(listenPather).ListenPath(); lp != path {
+					t.Fatalf("expected session to be tagged with %s, got %q", path, lp)
+				}
+				if lp := dialerSess.(listenPather).ListenPath(); lp != "" {
+					t.Fatalf("expected dialer session to have no listen path, got %q", lp)
+				}
+				_ = dialerSess.Close()
+			}
+			// Other paths are not served
+			_, resp, err := websocket.DefaultDialer.Dial("ws://"+li.Addr().String()+"/other", nil)
+			if err == nil || resp == nil || resp.StatusCode != http.StatusNotFound {
+				t.Fatalf("expected other paths to be refused, got %v", err)
+			}
+		})
+	}
+}
+
+func TestWebsocketListenerDuplicatePath(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	li, err := NewWebsocketListener("127.0.0.1:0", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	li.AddPath("/edge")
+	li.AddPath("/edge")
+	if _, err := li.Start(ctx, &sync.WaitGroup{}); err == nil {
+		t.Fatal("expected a duplicate path to be refused")
+	}
+	if li.Addr() != nil {
+		t.Fatal("expected the listener not to be bound")
+	}
+}
+
+func TestWebsocketListenerPathPeers(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	listener := netceptor.New(ctx, "listener", nil)
+	defer listener.Shutdown()
+	li, err := NewWebsocketListener("127.0.0.1:0", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	li.AddPath("/ops")
+	opts := netceptor.BackendOptions{PathAllowedPeers: map[string][]string{"/ops": {"ops"}}}
+	if err := listener.AddBackendWithOptions(li, 1.0, nil, opts); err != nil {
+		t.Fatal(err)
+	}
+	for _, nodeID := range []string{"ops", "other"} {
+		n := netceptor.New(ctx, nodeID, nil)
+		defer n.Shutdown()
+		wd := WSDial{Address: fmt.Sprintf("ws://%s/ops", li.Addr())}
+		if err := wd.setup(n); err != nil {
+			t.Fatal(err)
+		}
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	for !listener.Diagnose("ops").Direct || listener.Diagnose("other").Rejection == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for ops to connect and other to be rejected on /ops")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if reason := listener.Diagnose("other").Rejection.Reason; !strings.Contains(reason, "for path /ops") {
+		t.Errorf("unexpected rejection reason %q", reason)
+	}
+
+	cfg := websocketListenerCfg{Path: "/", PathPeers: map[string]string{"/ops": "ops, admin"}}
+	if _, err := cfg.pathAllowedPeers(); err == nil {
+		t.Error("expected allowed peers for a path that is not served to be refused")
+	}
+	cfg.ExtraPaths = []string{"/ops"}
+	pathPeers, err := cfg.pathAllowedPeers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if peers := pathPeers["/ops"]; len(peers) != 2 || peers[0] != "ops" || peers[1] != "admin" {
+		t.Errorf("unexpected allowed peers %v", peers)
+	}
+}
+
+// waitForPathCost waits for a node to find a route to another node with the expected cost.
+func waitForPathCost(t *testing.T, s *netceptor.Netceptor, node string, expected float64) {
+	deadline := time.Now().Add(10 * time.Second)
+	var cost float64
+	var err error
+	for time.Now().Before(deadline) {
+		cost, err = s.PathCost(node)
+		if err == nil && cost == expected {
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatalf("expected %s to reach %s with cost %f, got %f (%v)", s.NodeID(), node, expected, cost, err)
+}
+
+func TestWebsocketDialerNodeCosts(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	dialer := netceptor.New(ctx, "dialer", nil)
+	defer dialer.Shutdown()
+	cost := 2.0
+	// Both ends of a connection must agree on its cost, so the listeners are given the costs the dialer expects
+	for nodeID, listenerCost := range map[string]float64{"expensive": 7.5, "default": 2.0} {
+		n := netceptor.New(ctx, nodeID, nil)
+		defer n.Shutdown()
+		li, err := NewWebsocketListener("127.0.0.1:0", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := n.AddBackend(li, listenerCost, nil); err != nil {
+			t.Fatal(err)
+		}
+		wd := WSDial{
+			Address:   fmt.Sprintf("ws://%s/", li.Addr()),
+			Cost:      &cost,
+			NodeCosts: map[string]float64{"expensive": 7.5},
+		}
+		if err := wd.setup(dialer); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitForPathCost(t, dialer, "expensive", 7.5)
+	waitForPathCost(t, dialer, "default", 2.0)
+
+	bad := WSDial{Address: "ws://127.0.0.1:1/", NodeCosts: map[string]float64{"expensive": 0}}
+	if err := bad.setup(dialer); !errors.Is(err, ErrInvalidCost) {
+		t.Fatalf("expected an invalid cost error, got %v", err)
+	}
+	cfg := websocketDialerCfg{Address: "ws://127.0.0.1:1/", Cost: 1.0, NodeCost: map[string]float64{"expensive": -1}}
+	if err := cfg.Prepare(); err == nil {
+		t.Fatal("expected a negative node cost to be refused")
+	}
+}
+
+func TestWebsocketSendTimeout(t *testing.T) {
+	server, conn := websocketServerSession(t)
+	defer server.Close()
+	// The client connection is never read from, so sends block once the socket buffers fill
+	defer conn.Close()
+	if err := server.SetWriteDeadline(time.Now().Add(300 * time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+	data := make([]byte, 64*1024)
+	start := time.Now()
+	var err error
+	for time.Since(start) < 10*time.Second {
+		if err = server.Send(data); err != nil {
+			break
+		}
+	}
+	if !errors.Is(err, netceptor.ErrSendTimeout) {
+		t.Fatalf("expected a send timeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 300*time.Millisecond {
+		t.Fatalf("send timed out after %s, before the deadline", elapsed)
+	}
+}
+
+// silentListener accepts connections but never answers, and reports when each connection is closed by the peer.
+func silentListener(t *testing.T) (string, chan error) {
+	li, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = li.Close() })
+	closed := make(chan error, 1)
+	go func() {
+		conn, err := li.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_ = conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+		buf := make([]byte, 4096)
+		for {
+			if _, err := conn.Read(buf); err != nil {
+				closed <- err
+
+				return
+			}
+		}
+	}()
+
+	return li.Addr().String(), closed
+}
+
+func TestWebsocketDialHandshakeCanceled(t *testing.T) {
+	for _, scheme := range []string{"ws", "wss"} {
+		for _, withDeadline := range []bool{false, true} {
+			addr, closed := silentListener(t)
+			wd, err := NewWebsocketDialer(fmt.Sprintf("%s://%s/", scheme, addr), nil, "", false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var ctx context.Context
+			var cancel context.CancelFunc
+			expected := context.Canceled
+			if withDeadline {
+				ctx, cancel = context.WithTimeout(context.Background(), 200*time.Millisecond)
+				expected = context.DeadlineExceeded
+			} else {
+				ctx, cancel = context.WithCancel(context.Background())
+				time.AfterFunc(200*time.Millisecond, cancel)
+			}
+			start := time.Now()
+			conn, _, err := wd.dial(ctx)
+			cancel()
+			if !errors.Is(err, expected) {
+				t.Fatalf("%s: expected %v from a handshake that was not answered, got conn %v and error %v",
+					scheme, expected, conn, err)
+			}
+			if elapsed := time.Since(start); elapsed > 5*time.Second {
+				t.Fatalf("%s: handshake took %s to be aborted", scheme, elapsed)
+			}
+			// The connection must not be left open
+			select {
+			case err := <-closed:
+				var netErr net.Error
+				if errors.As(err, &netErr) && netErr.Timeout() {
+					t.Fatalf("%s: connection was not closed after the handshake was aborted", scheme)
+				}
+			case <-time.After(15 * time.Second):
+				t.Fatalf("%s: connection was not closed after the handshake was aborted", scheme)
+			}
+		}
+	}
+}
+
+func TestWebsocketDialHandshakeRefused(t *testing.T) {
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "not a websocket", http.StatusNotFound)
+	})}
+	li, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() { _ = server.Serve(li) }()
+	defer server.Close()
+	wd, err := NewWebsocketDialer(fmt.Sprintf("ws://%s/", li.Addr()), nil, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = wd.dial(context.Background())
+	if !errors.Is(err, websocket.ErrBadHandshake) {
+		t.Fatalf("expected a bad handshake error, got %v", err)
+	}
+}
+
+func TestParseOrigin(t *testing.T) {
+	valid := map[string]string{
+		"https://example.com":       "https://example.com",
+		"https://example.com/":      "https://example.com",
+		"http://gateway.local:8080": "http://gateway.local:8080",
+	}
+	for origin, expected := range valid {
+		parsed, err := ParseOrigin(origin)
+		if err != nil {
+			t.Errorf("expected origin %s to be valid, got %s", origin, err)
+		} else if parsed != expected {
+			t.Errorf("expected origin %s to be parsed as %s, got %s", origin, expected, parsed)
+		}
+	}
+	for _, origin := range []string{"example.com", "https://", "https://example.com/path", "https://user@example.com",
+		"https://example.com?x=1", "%zz"} {
+		if _, err := ParseOrigin(origin); err == nil {
+			t.Errorf("expected origin %s to be refused", origin)
+		}
+	}
+}
+
+func TestWebsocketDialerOrigin(t *testing.T) {
+	origins := make(chan string, 1)
+	upgrader := websocket.Upgrader{CheckOrigin: func(r *http.Request) bool { return true }}
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origins <- r.Header.Get("Origin")
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err == nil {
+			_ = conn.Close()
+		}
+	})}
+	li, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() { _ = server.Serve(li) }()
+	defer server.Close()
+	address := fmt.Sprintf("ws://%s/", li.Addr())
+
+	for origin, expected := range map[string]string{
+		"":                         fmt.Sprintf("http://%s", li.Addr()),
+		"https://gateway.example/": "https://gateway.example",
+	} {
+		wd := WSDial{Address: address, Origin: origin, NoRedial: true}
+		b, err := wd.newBackend()
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn, _, err := b.(*WebsocketDialer).dial(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = conn.Close()
+		if sent := <-origins; sent != expected {
+			t.Errorf("expected origin %s to be sent, got %s", expected, sent)
+		}
+	}
+
+	bad := WSDial{Address: address, Origin: "gateway.example"}
+	if _, err := bad.newBackend(); err == nil {
+		t.Error("expected an invalid origin to be refused")
+	}
+	cfg := websocketDialerCfg{Address: address, Cost: 1.0, Origin: "https://gateway.example/path", TCPKeepAlive: "0",
+		ReadDeadline: "0", FirstRetryDelay: "100ms", MaxRetryDelay: "20s"}
+	if err := cfg.Prepare(); err == nil {
+		t.Error("expected an origin with a path to be refused")
+	}
+}
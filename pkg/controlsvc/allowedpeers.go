@@ -0,0 +1,119 @@
+package controlsvc
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ansible/receptor/pkg/netceptor"
+)
+
+type (
+	allowedPeersCommandType struct{}
+	allowedPeersCommand     struct {
+		subcommand string
+		peers      []string
+		drop       bool
+	}
+)
+
+// parsePeerList splits a comma separated list of node IDs.
+func parsePeerList(list string) []string {
+	peers := make([]string, 0)
+	for _, peer := range strings.Split(list, ",") {
+		peer = strings.TrimSpace(peer)
+		if peer != "" {
+			peers = append(peers, peer)
+		}
+	}
+
+	return peers
+}
+
+func (t *allowedPeersCommandType) InitFromString(params string) (ControlCommand, error) {
+	tokens := strings.Fields(params)
+	c := &allowedPeersCommand{
+		subcommand: "show",
+	}
+	if len(tokens) > 0 {
+		c.subcommand = strings.ToLower(tokens[0])
+	}
+	switch c.subcommand {
+	case "show", "all":
+		if len(tokens) > 1 {
+			return nil, fmt.Errorf("allowedpeers %s does not take parameters", c.subcommand)
+		}
+	case "set":
+		if len(tokens) < 2 || len(tokens) > 3 {
+			return nil, fmt.Errorf("allowedpeers set requires a comma separated list of node IDs and optional --drop")
+		}
+		c.peers = parsePeerList(tokens[1])
+		if len(tokens) == 3 {
+			if tokens[2] != "--drop" {
+				return nil, fmt.Errorf("unknown allowedpeers set option %s", tokens[2])
+			}
+			c.drop = true
+		}
+	default:
+		return nil, fmt.Errorf("unknown allowedpeers subcommand %s", c.subcommand)
+	}
+
+	return c, nil
+}
+
+func (t *allowedPeersCommandType) InitFromJSON(config map[string]interface{}) (ControlCommand, error) {
+	c := &allowedPeersCommand{
+		subcommand: "show",
+	}
+	if subcommand, ok := config["subcommand"]; ok {
+		subcommandStr, ok := subcommand.(string)
+		if !ok {
+			return nil, fmt.Errorf("allowedpeers subcommand must be string")
+		}
+		c.subcommand = strings.ToLower(subcommandStr)
+	}
+	switch c.subcommand {
+	case "show", "all":
+	case "set":
+		peers, ok := config["peers"].([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("allowedpeers set requires a list of peers")
+		}
+		c.peers = make([]string, 0, len(peers))
+		for _, peer := range peers {
+			peerStr, ok := peer.(string)
+			if !ok {
+				return nil, fmt.Errorf("allowedpeers peers must be strings")
+			}
+			c.peers = append(c.peers, peerStr)
+		}
+		if drop, ok := config["drop"]; ok {
+			c.drop, ok = drop.(bool)
+			if !ok {
+				return nil, fmt.Errorf("allowedpeers drop must be boolean")
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unknown allowedpeers subcommand %s", c.subcommand)
+	}
+
+	return c, nil
+}
+
+// ReadOnly reports whether the command only shows the list of peers.
+func (c *allowedPeersCommand) ReadOnly() bool {
+	return c.subcommand != "set"
+}
+
+// ControlFunc shows or changes the list of peers that are allowed to connect to this node.
+func (c *allowedPeersCommand) ControlFunc(nc *netceptor.Netceptor, cfo ControlFuncOperations) (map[string]interface{}, error) {
+	cfr := make(map[string]interface{})
+	switch c.subcommand {
+	case "set":
+		cfr["Dropped"] = nc.SetAllowedPeers(c.peers, c.drop)
+	case "all":
+		nc.SetAllowedPeers(nil, false)
+	}
+	cfr["AllowedPeers"] = nc.AllowedPeers()
+
+	return cfr, nil
+}
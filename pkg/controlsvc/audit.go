@@ -0,0 +1,221 @@
+//go:build !no_controlsvc
+// +build !no_controlsvc
+
+package controlsvc
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ansible/receptor/pkg/logger"
+	"github.com/ansible/receptor/pkg/tls"
+	"github.com/ansible/receptor/pkg/utils"
+	"github.com/ghjm/cmdline"
+)
+
+// Audit status values.
+const (
+	AuditStatusSuccess = "success"
+	AuditStatusFailed  = "failed"
+	AuditStatusDenied  = "denied"
+)
+
+// auditRedacted replaces secret values in audit records.
+const auditRedacted = "REDACTED"
+
+// AuditRecord describes a single control command invocation.
+type AuditRecord struct {
+	Time    time.Time
+	Caller  string
+	Command string
+	Args    interface{}
+	Status  string
+	Error   string `json:",omitempty"`
+}
+
+// AuditSink receives audit records.
+type AuditSink interface {
+	WriteAudit(rec *AuditRecord) error
+}
+
+// auditFileSink writes audit records to a file as JSON lines.
+type auditFileSink struct {
+	lock sync.Mutex
+	file *os.File
+}
+
+// NewAuditFileSink returns an AuditSink that appends JSON records to the named file.
+func NewAuditFileSink(filename string) (AuditSink, error) {
+	f, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("could not open audit file: %w", err)
+	}
+
+	return &auditFileSink{file: f}, nil
+}
+
+// WriteAudit writes an audit record to the file.
+func (s *auditFileSink) WriteAudit(rec *AuditRecord) error {
+	rbytes, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	rbytes = append(rbytes, '\n')
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	_, err = s.file.Write(rbytes)
+
+	return err
+}
+
+// auditLogSink writes audit records to the receptor logger.
+type auditLogSink struct{}
+
+// NewAuditLogSink returns an AuditSink that writes records to the receptor logger.
+func NewAuditLogSink() AuditSink {
+	return &auditLogSink{}
+}
+
+// WriteAudit writes an audit record to the logger.
+func (s *auditLogSink) WriteAudit(rec *AuditRecord) error {
+	rbytes, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	logger.Info("AUDIT %s\n", rbytes)
+
+	return nil
+}
+
+// SetAuditSink sets where records of control command invocations are sent.  Set to nil to disable auditing.
+func (s *Server) SetAuditSink(sink AuditSink) {
+	s.auditLock.Lock()
+	defer s.auditLock.Unlock()
+	s.audit = sink
+}
+
+// auditCommand records a control command invocation, if auditing is enabled.
+func (s *Server) auditCommand(caller string, cmd string, params string, jsonData map[string]interface{}, status string, err error) {
+	s.auditLock.RLock()
+	sink := s.audit
+	s.auditLock.RUnlock()
+	if sink == nil {
+		return
+	}
+	rec := &AuditRecord{
+		Time:    time.Now(),
+		Caller:  caller,
+		Command: cmd,
+		Status:  status,
+	}
+	if jsonData != nil {
+		rec.Args = redactJSON(jsonData)
+	} else {
+		rec.Args = redactString(params)
+	}
+	if err != nil {
+		rec.Error = err.Error()
+	}
+	if werr := sink.WriteAudit(rec); werr != nil {
+		logger.Error("Error writing audit record: %s\n", werr)
+	}
+}
+
+// callerIdentity returns a description of the remote end of a control connection.  For a TLS connection
+// with a client certificate, this includes the certificate's common name and any receptor node IDs in it.
+func callerIdentity(conn net.Conn) string {
+	caller := fmt.Sprintf("%s:%s", conn.RemoteAddr().Network(), conn.RemoteAddr().String())
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		certs := tlsConn.ConnectionState().PeerCertificates
+		if len(certs) > 0 {
+			identity := certs[0].Subject.CommonName
+			nodeIDs, err := utils.ReceptorNames(certs[0].Extensions)
+			if err == nil && len(nodeIDs) > 0 {
+				identity = fmt.Sprintf("%s, node %s", identity, strings.Join(nodeIDs, ","))
+			}
+			caller = fmt.Sprintf("%s (%s)", caller, identity)
+		}
+	}
+
+	return caller
+}
+
+var secretKeyRegex = regexp.MustCompile(`(?i)(password|passwd|secret|token|psk|signature|key)`)
+
+var secretParamRegex = regexp.MustCompile(
+	`(?i)(\S*(?:password|passwd|secret|token|psk|signature|key)\S*?\s*[=:]\s*)(\S+)`)
+
+// redactString removes secret values from key=value or key:value pairs in a string command.
+func redactString(params string) string {
+	return secretParamRegex.ReplaceAllString(params, "${1}"+auditRedacted)
+}
+
+// redactJSON returns a copy of a JSON command with the values of secret-looking keys removed.
+func redactJSON(data map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{}, len(data))
+	for k, v := range data {
+		if k != "command" && secretKeyRegex.MatchString(k) {
+			out[k] = auditRedacted
+
+			continue
+		}
+		switch vt := v.(type) {
+		case map[string]interface{}:
+			out[k] = redactJSON(vt)
+		case string:
+			out[k] = redactString(vt)
+		default:
+			out[k] = v
+		}
+	}
+
+	return out
+}
+
+// **************************************************************************
+// Command line
+// **************************************************************************
+
+// auditCfg is the cmdline configuration object for control service auditing.
+type auditCfg struct {
+	Filename string `description:"File to append audit records to. If not set, records are written to the log." barevalue:"yes"`
+}
+
+// Prepare sets up the audit sink.
+func (cfg auditCfg) Prepare() error {
+	return setupAudit(MainInstance, cfg.Filename)
+}
+
+// setupAudit sets the server to append audit records to filename, or to write them to the log if filename is
+// empty.
+func setupAudit(s *Server, filename string) error {
+	if filename == "" {
+		s.SetAuditSink(NewAuditLogSink())
+
+		return nil
+	}
+	sink, err := NewAuditFileSink(filename)
+	if err != nil {
+		return err
+	}
+	s.SetAuditSink(sink)
+
+	return nil
+}
+
+// Audit records an audit trail of control service commands.
+type Audit struct {
+	// File to append audit records to. Leave empty to write records to the log.
+	Filename string `mapstructure:"filename"`
+}
+
+func init() {
+	cmdline.RegisterConfigTypeForApp("receptor-control-service",
+		"control-audit", "Record an audit trail of control service commands", auditCfg{}, cmdline.Singleton)
+}
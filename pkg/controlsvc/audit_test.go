@@ -0,0 +1,163 @@
+package controlsvc
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ansible/receptor/pkg/certificates"
+	"github.com/ansible/receptor/pkg/netceptor"
+	"github.com/stretchr/testify/assert"
+)
+
+type memoryAuditSink struct {
+	lock    sync.Mutex
+	records []*AuditRecord
+}
+
+func (s *memoryAuditSink) WriteAudit(rec *AuditRecord) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.records = append(s.records, rec)
+
+	return nil
+}
+
+type (
+	failCommandType struct{}
+	failCommand     struct{}
+)
+
+func (t *failCommandType) InitFromString(params string) (ControlCommand, error) {
+	return &failCommand{}, nil
+}
+
+func (t *failCommandType) InitFromJSON(config map[string]interface{}) (ControlCommand, error) {
+	return &failCommand{}, nil
+}
+
+func (c *failCommand) ControlFunc(nc *netceptor.Netceptor, cfo ControlFuncOperations) (map[string]interface{}, error) {
+	return nil, fmt.Errorf("command failed")
+}
+
+func TestAudit(t *testing.T) {
+	nc := netceptor.New(context.Background(), "test", nil)
+	s := New(true, nc)
+	sink := &memoryAuditSink{}
+	s.SetAuditSink(sink)
+	assert.NoError(t, s.AddControlFunc("fail", &failCommandType{}))
+
+	client, server := net.Pipe()
+	go s.RunControlSession(server)
+	reader := bufio.NewReader(client)
+	_, err := reader.ReadString('\n')
+	assert.NoError(t, err)
+	for _, cmd := range []string{
+		`status`,
+		`{"command":"fail","password":"hunter2","params":{"token":"abc123"}}`,
+		`fail secret=hunter2 other=visible`,
+		`bogus`,
+	} {
+		_, err = client.Write([]byte(cmd + "\n"))
+		assert.NoError(t, err)
+		_, err = reader.ReadString('\n')
+		assert.NoError(t, err)
+	}
+	_ = client.Close()
+
+	sink.lock.Lock()
+	defer sink.lock.Unlock()
+	assert.Len(t, sink.records, 4)
+	for _, rec := range sink.records {
+		assert.False(t, rec.Time.IsZero())
+		assert.NotEmpty(t, rec.Caller)
+	}
+
+	assert.Equal(t, "status", sink.records[0].Command)
+	assert.Equal(t, AuditStatusSuccess, sink.records[0].Status)
+
+	assert.Equal(t, "fail", sink.records[1].Command)
+	assert.Equal(t, AuditStatusFailed, sink.records[1].Status)
+	assert.Equal(t, "command failed", sink.records[1].Error)
+	args, ok := sink.records[1].Args.(map[string]interface{})
+	assert.True(t, ok)
+	assert.Equal(t, auditRedacted, args["password"])
+	assert.Equal(t, map[string]interface{}{"token": auditRedacted}, args["params"])
+
+	assert.Equal(t, AuditStatusFailed, sink.records[2].Status)
+	argStr, ok := sink.records[2].Args.(string)
+	assert.True(t, ok)
+	assert.False(t, strings.Contains(argStr, "hunter2"))
+	assert.True(t, strings.Contains(argStr, "other=visible"))
+
+	assert.Equal(t, "bogus", sink.records[3].Command)
+	assert.Equal(t, AuditStatusDenied, sink.records[3].Status)
+}
+
+func TestControllersAudit(t *testing.T) {
+	nc := netceptor.New(context.Background(), "test", nil)
+	s := New(true, nc)
+	filename := filepath.Join(t.TempDir(), "audit.log")
+	assert.NoError(t, Controllers{Audit: &Audit{Filename: filename}}.Setup(context.Background(), s))
+
+	client, server := net.Pipe()
+	go s.RunControlSession(server)
+	reader := bufio.NewReader(client)
+	_, err := reader.ReadString('\n')
+	assert.NoError(t, err)
+	_, err = client.Write([]byte("status\n"))
+	assert.NoError(t, err)
+	_, err = reader.ReadString('\n')
+	assert.NoError(t, err)
+	_ = client.Close()
+
+	data, err := ioutil.ReadFile(filename)
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), `"Command":"status"`)
+
+	// The audit file must be one that can be opened
+	err = Controllers{Audit: &Audit{Filename: filepath.Join(filename, "missing", "audit.log")}}.Setup(context.Background(), s)
+	assert.Error(t, err)
+}
+
+func TestCallerIdentity(t *testing.T) {
+	ca, err := certificates.CreateCA(&certificates.CertOptions{CommonName: "test CA", Bits: 1024})
+	assert.NoError(t, err)
+	opts := &certificates.CertOptions{
+		CommonName: "node2 cert",
+		Bits:       1024,
+		CertNames:  certificates.CertNames{NodeIDs: []string{"node2"}},
+	}
+	req, key, err := certificates.CreateCertReqWithKey(opts)
+	assert.NoError(t, err)
+	cert, err := certificates.SignCertReq(req, ca, opts)
+	assert.NoError(t, err)
+	tlsCert := tls.Certificate{Certificate: [][]byte{cert.Raw}, PrivateKey: key}
+
+	client, server := net.Pipe()
+	defer client.Close()
+	serverConn := tls.Server(server, &tls.Config{
+		Certificates: []tls.Certificate{tlsCert},
+		ClientAuth:   tls.RequireAnyClientCert,
+	})
+	clientConn := tls.Client(client, &tls.Config{
+		Certificates:       []tls.Certificate{tlsCert},
+		InsecureSkipVerify: true,
+	})
+	go func() {
+		if clientConn.Handshake() == nil {
+			_, _ = io.Copy(ioutil.Discard, clientConn)
+		}
+	}()
+	assert.NoError(t, serverConn.Handshake())
+	assert.True(t, strings.HasSuffix(callerIdentity(serverConn), "(node2 cert, node node2)"), callerIdentity(serverConn))
+	assert.False(t, strings.Contains(callerIdentity(server), "node2"))
+}
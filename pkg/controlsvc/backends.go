@@ -0,0 +1,54 @@
+package controlsvc
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/ansible/receptor/pkg/netceptor"
+)
+
+type (
+	backendsCommandType struct{}
+	backendsCommand     struct{}
+)
+
+func (t *backendsCommandType) InitFromString(params string) (ControlCommand, error) {
+	if params != "" {
+		return nil, fmt.Errorf("backends does not take parameters")
+	}
+	c := &backendsCommand{}
+
+	return c, nil
+}
+
+func (t *backendsCommandType) InitFromJSON(config map[string]interface{}) (ControlCommand, error) {
+	c := &backendsCommand{}
+
+	return c, nil
+}
+
+// ControlFunc lists the backends of this node with their health.
+func (c *backendsCommand) ControlFunc(nc *netceptor.Netceptor, cfo ControlFuncOperations) (map[string]interface{}, error) {
+	cfr := make(map[string]interface{})
+	for _, bh := range nc.BackendHealth() {
+		status := map[string]interface{}{
+			"Name":        bh.Name,
+			"Connections": bh.Connections,
+			"Sessions":    bh.Sessions,
+			"Reconnects":  bh.Reconnects,
+			"Errors":      bh.Errors,
+			"Score":       bh.Score,
+			"State":       bh.State,
+			"CostFactor":  bh.CostFactor,
+		}
+		if len(bh.Compression) > 0 {
+			status["Compression"] = bh.Compression
+		}
+		if len(bh.MessageSizes) > 0 {
+			status["MessageSizes"] = bh.MessageSizes
+		}
+		cfr[strconv.Itoa(bh.ID)] = status
+	}
+
+	return cfr, nil
+}
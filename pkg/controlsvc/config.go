@@ -0,0 +1,83 @@
+package controlsvc
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ansible/receptor/pkg/netceptor"
+	"github.com/ansible/receptor/pkg/utils"
+	"gopkg.in/yaml.v2"
+)
+
+type (
+	configCommandType struct{}
+	configCommand     struct {
+		format string
+	}
+)
+
+// validateConfigCommand checks the parameters of a config command.
+func validateConfigCommand(subcommand string, view string, format string) (*configCommand, error) {
+	if subcommand != "show" {
+		return nil, fmt.Errorf("unknown config subcommand %s", subcommand)
+	}
+	if view != "effective" {
+		return nil, fmt.Errorf("config show only supports the effective configuration")
+	}
+	switch format {
+	case "json", "yaml":
+	default:
+		return nil, fmt.Errorf("unknown config format %s, must be json or yaml", format)
+	}
+
+	return &configCommand{format: format}, nil
+}
+
+func (t *configCommandType) InitFromString(params string) (ControlCommand, error) {
+	tokens := strings.Fields(strings.ToLower(params))
+	if len(tokens) < 2 || len(tokens) > 3 {
+		return nil, fmt.Errorf("usage: config show effective [json|yaml]")
+	}
+	format := "json"
+	if len(tokens) == 3 {
+		format = tokens[2]
+	}
+
+	return validateConfigCommand(tokens[0], tokens[1], format)
+}
+
+func (t *configCommandType) InitFromJSON(config map[string]interface{}) (ControlCommand, error) {
+	params := map[string]string{
+		"subcommand": "show",
+		"view":       "effective",
+		"format":     "json",
+	}
+	for name := range params {
+		if value, ok := config[name]; ok {
+			valueStr, ok := value.(string)
+			if !ok {
+				return nil, fmt.Errorf("config %s must be string", name)
+			}
+			params[name] = strings.ToLower(valueStr)
+		}
+	}
+
+	return validateConfigCommand(params["subcommand"], params["view"], params["format"])
+}
+
+// ControlFunc returns the effective running configuration, with secrets redacted.
+func (c *configCommand) ControlFunc(nc *netceptor.Netceptor, cfo ControlFuncOperations) (map[string]interface{}, error) {
+	cfr := make(map[string]interface{})
+	effective := utils.EffectiveConfig()
+	if c.format == "yaml" {
+		data, err := yaml.Marshal(effective)
+		if err != nil {
+			return nil, err
+		}
+		cfr["YAML"] = string(data)
+	} else {
+		cfr["EffectiveConfig"] = effective
+	}
+
+	return cfr, nil
+}
@@ -0,0 +1,102 @@
+package controlsvc
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ansible/receptor/pkg/netceptor"
+	"github.com/ansible/receptor/pkg/utils"
+)
+
+type (
+	connectionsCommandType struct{}
+	connectionsCommand     struct{}
+)
+
+func (t *connectionsCommandType) InitFromString(params string) (ControlCommand, error) {
+	if params != "" {
+		return nil, fmt.Errorf("connections does not take parameters")
+	}
+	c := &connectionsCommand{}
+
+	return c, nil
+}
+
+func (t *connectionsCommandType) InitFromJSON(config map[string]interface{}) (ControlCommand, error) {
+	c := &connectionsCommand{}
+
+	return c, nil
+}
+
+// ControlFunc lists the connections currently bridged by proxy services.
+func (c *connectionsCommand) ControlFunc(nc *netceptor.Netceptor, cfo ControlFuncOperations) (map[string]interface{}, error) {
+	cfr := make(map[string]interface{})
+	for _, b := range utils.ActiveBridges() {
+		cfr[b.ID] = map[string]interface{}{
+			"Source":      b.Source,
+			"Destination": b.Destination,
+			"BytesIn":     b.BytesIn,
+			"BytesOut":    b.BytesOut,
+			"Age":         time.Since(b.Started).Round(time.Second).String(),
+		}
+	}
+
+	return cfr, nil
+}
+
+type (
+	connectionCommandType struct{}
+	connectionCommand     struct {
+		id string
+	}
+)
+
+func (t *connectionCommandType) InitFromString(params string) (ControlCommand, error) {
+	tokens := strings.Fields(params)
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("no connection subcommand")
+	}
+	if strings.ToLower(tokens[0]) != "kill" {
+		return nil, fmt.Errorf("unknown connection subcommand %s", tokens[0])
+	}
+	if len(tokens) != 2 {
+		return nil, fmt.Errorf("connection kill requires a connection ID")
+	}
+	c := &connectionCommand{
+		id: tokens[1],
+	}
+
+	return c, nil
+}
+
+func (t *connectionCommandType) InitFromJSON(config map[string]interface{}) (ControlCommand, error) {
+	subcommand, ok := config["subcommand"].(string)
+	if !ok || strings.ToLower(subcommand) != "kill" {
+		return nil, fmt.Errorf("connection subcommand must be kill")
+	}
+	id, ok := config["id"].(string)
+	if !ok {
+		return nil, fmt.Errorf("connection kill requires a connection ID")
+	}
+	c := &connectionCommand{
+		id: id,
+	}
+
+	return c, nil
+}
+
+// ControlFunc closes both ends of a bridged connection.
+func (c *connectionCommand) ControlFunc(nc *netceptor.Netceptor, cfo ControlFuncOperations) (map[string]interface{}, error) {
+	cfr := make(map[string]interface{})
+	err := utils.KillBridge(c.id)
+	if err != nil {
+		cfr["Success"] = false
+		cfr["Error"] = err.Error()
+
+		return cfr, nil
+	}
+	cfr["Success"] = true
+
+	return cfr, nil
+}
@@ -0,0 +1,78 @@
+//go:build !no_controlsvc
+// +build !no_controlsvc
+
+package controlsvc
+
+import (
+	"sync"
+)
+
+// controlConnLimiter counts the connections accepted by a control service listener, and optionally limits them.
+type controlConnLimiter struct {
+	lock     sync.Mutex
+	limit    int
+	active   int
+	total    uint64
+	rejected uint64
+}
+
+// acquire takes a connection slot, and returns false if the listener already has as many connections as
+// it allows.  A connection keeps its slot until it closes, however long the commands it runs.
+func (cl *controlConnLimiter) acquire() bool {
+	cl.lock.Lock()
+	defer cl.lock.Unlock()
+	if cl.limit > 0 && cl.active >= cl.limit {
+		cl.rejected++
+
+		return false
+	}
+	cl.active++
+	cl.total++
+
+	return true
+}
+
+// release frees a connection slot taken by acquire.
+func (cl *controlConnLimiter) release() {
+	cl.lock.Lock()
+	defer cl.lock.Unlock()
+	cl.active--
+}
+
+// status returns the current connection count.
+func (cl *controlConnLimiter) status() ControlConnStatus {
+	cl.lock.Lock()
+	defer cl.lock.Unlock()
+
+	return ControlConnStatus{
+		Active:   cl.active,
+		Limit:    cl.limit,
+		Total:    cl.total,
+		Rejected: cl.rejected,
+	}
+}
+
+// newConnLimiter registers the connection count of a listener, replacing any earlier listener of the same name.
+func (s *Server) newConnLimiter(name string, limit int) *controlConnLimiter {
+	cl := &controlConnLimiter{limit: limit}
+	s.connLimitersLock.Lock()
+	defer s.connLimitersLock.Unlock()
+	if s.connLimiters == nil {
+		s.connLimiters = make(map[string]*controlConnLimiter)
+	}
+	s.connLimiters[name] = cl
+
+	return cl
+}
+
+// ConnectionStatus returns the connection counts of the control service's listeners, keyed by listener.
+func (s *Server) ConnectionStatus() map[string]ControlConnStatus {
+	s.connLimitersLock.Lock()
+	defer s.connLimitersLock.Unlock()
+	conns := make(map[string]ControlConnStatus)
+	for name, cl := range s.connLimiters {
+		conns[name] = cl.status()
+	}
+
+	return conns
+}
@@ -0,0 +1,88 @@
+package controlsvc
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ansible/receptor/pkg/netceptor"
+	"github.com/stretchr/testify/assert"
+)
+
+// dialControl connects to a control service over TCP and returns the connection and its first line.
+func dialControl(t *testing.T, addr string) (net.Conn, *bufio.Reader, string) {
+	conn, err := net.Dial("tcp", addr)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	reader := bufio.NewReader(conn)
+	line, err := reader.ReadString('\n')
+	assert.NoError(t, err)
+
+	return conn, reader, line
+}
+
+func TestMaxConnections(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	nc := netceptor.New(ctx, "node1", nil)
+	s := New(true, nc)
+
+	// Find a free port for the TCP listener
+	li, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	addr := li.Addr().String()
+	assert.NoError(t, li.Close())
+	assert.NoError(t, s.RunControlSvcWithOptions(ctx, "control", nil, "", 0, addr, nil, ListenerOptions{MaxConnections: 2}))
+
+	first, _, line := dialControl(t, addr)
+	assert.True(t, strings.HasPrefix(line, "Receptor Control"), line)
+	second, reader, line := dialControl(t, addr)
+	assert.True(t, strings.HasPrefix(line, "Receptor Control"), line)
+
+	// Connections over the limit are told why and closed
+	overflow, overflowReader, line := dialControl(t, addr)
+	assert.Contains(t, line, "ERROR: Control service connection limit of 2 reached")
+	_, err = overflowReader.ReadString('\n')
+	assert.Error(t, err)
+	_ = overflow.Close()
+
+	// A connection holds its slot for as long as it is open, whatever commands it runs
+	_, err = second.Write([]byte("ping node1\n"))
+	assert.NoError(t, err)
+	_, err = reader.ReadString('\n')
+	assert.NoError(t, err)
+	_, _, line = dialControl(t, addr)
+	assert.Contains(t, line, "connection limit")
+
+	// Once a connection closes, its slot is free again
+	_ = first.Close()
+	assert.Eventually(t, func() bool {
+		return s.ConnectionStatus()["tcp:"+addr].Active == 1
+	}, 10*time.Second, 10*time.Millisecond)
+	third, _, line := dialControl(t, addr)
+	assert.True(t, strings.HasPrefix(line, "Receptor Control"), line)
+	_ = third.Close()
+	_ = second.Close()
+
+	status := s.ConnectionStatus()["tcp:"+addr]
+	assert.Equal(t, 2, status.Limit)
+	assert.Equal(t, uint64(3), status.Total)
+	assert.Equal(t, uint64(2), status.Rejected)
+	assert.Contains(t, s.ConnectionStatus(), "service:control")
+}
+
+func TestStatusControlConnections(t *testing.T) {
+	nc := netceptor.New(context.Background(), "node1", nil)
+	s := New(true, nc)
+	s.newConnLimiter("tcp:127.0.0.1:1234", 5)
+	cc, err := (&statusCommandType{server: s}).InitFromString("")
+	assert.NoError(t, err)
+	cfr, err := cc.ControlFunc(nc, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]ControlConnStatus{"tcp:127.0.0.1:1234": {Limit: 5}}, cfr["ControlConnections"])
+}
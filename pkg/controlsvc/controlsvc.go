@@ -17,14 +17,19 @@ import (
 
 	"github.com/ansible/receptor/pkg/logger"
 	"github.com/ansible/receptor/pkg/netceptor"
+	"github.com/ansible/receptor/pkg/telemetry"
 	"github.com/ansible/receptor/pkg/tls"
 	"github.com/ansible/receptor/pkg/utils"
 	"github.com/ghjm/cmdline"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // sockControl implements the ControlFuncOperations interface that is passed back to control functions.
 type sockControl struct {
 	conn net.Conn
+	ctx  context.Context
 }
 
 // BridgeConn bridges the socket to another socket.
@@ -78,11 +83,23 @@ func (s *sockControl) Close() error {
 	return s.conn.Close()
 }
 
+// Context returns the context of the command being run, which carries its trace span.
+func (s *sockControl) Context() context.Context {
+	return s.ctx
+}
+
 // Server is an instance of a control service.
 type Server struct {
-	nc              *netceptor.Netceptor
-	controlFuncLock sync.RWMutex
-	controlTypes    map[string]ControlCommandType
+	nc               *netceptor.Netceptor
+	controlFuncLock  sync.RWMutex
+	controlTypes     map[string]ControlCommandType
+	auditLock        sync.RWMutex
+	audit            AuditSink
+	allowedNodes     map[string]bool
+	connLimitersLock sync.Mutex
+	connLimiters     map[string]*controlConnLimiter
+	eventSourcesLock sync.RWMutex
+	eventSources     []EventSource
 }
 
 // New returns a new instance of a control service.
@@ -94,10 +111,24 @@ func New(stdServices bool, nc *netceptor.Netceptor) *Server {
 	}
 	if stdServices {
 		s.controlTypes["ping"] = &pingCommandType{}
-		s.controlTypes["status"] = &statusCommandType{}
+		s.controlTypes["status"] = &statusCommandType{server: s}
 		s.controlTypes["connect"] = &connectCommandType{}
 		s.controlTypes["traceroute"] = &tracerouteCommandType{}
+		s.controlTypes["diagnose"] = &diagnoseCommandType{}
+		s.controlTypes["reachability"] = &reachabilityCommandType{}
 		s.controlTypes["reload"] = &reloadCommandType{}
+		s.controlTypes["logrotate"] = &logrotateCommandType{}
+		s.controlTypes["connections"] = &connectionsCommandType{}
+		s.controlTypes["connection"] = &connectionCommandType{}
+		s.controlTypes["locks"] = &locksCommandType{}
+		s.controlTypes["backends"] = &backendsCommandType{}
+		s.controlTypes["traffic"] = &trafficCommandType{}
+		s.controlTypes["events"] = &eventsCommandType{server: s}
+		s.controlTypes["allowedpeers"] = &allowedPeersCommandType{}
+		s.controlTypes["reconverge"] = &reconvergeCommandType{}
+		s.controlTypes["displayname"] = &displayNameCommandType{}
+		s.controlTypes["service"] = &serviceCommandType{}
+		s.controlTypes["config"] = &configCommandType{}
 	}
 
 	return s
@@ -106,6 +137,34 @@ func New(stdServices bool, nc *netceptor.Netceptor) *Server {
 // MainInstance is the global instance of the control service instantiated by the command-line main() function.
 var MainInstance *Server
 
+// readOnlyCommands are the standard commands that never change the node, and so are allowed on a read-only
+// listener.  Any other command is refused there, unless it implements ReadOnlyCommand and reports that the
+// instance being run is read-only.
+var readOnlyCommands = map[string]bool{
+	"ping":         true,
+	"status":       true,
+	"traceroute":   true,
+	"diagnose":     true,
+	"reachability": true,
+	"connections":  true,
+	"locks":        true,
+	"backends":     true,
+	"events":       true,
+	"config":       true,
+}
+
+// commandIsReadOnly reports whether a command leaves the node unchanged.
+func commandIsReadOnly(name string, cc ControlCommand) bool {
+	if readOnlyCommands[name] {
+		return true
+	}
+	if roc, ok := cc.(ReadOnlyCommand); ok {
+		return roc.ReadOnly()
+	}
+
+	return false
+}
+
 // AddControlFunc registers a function that can be used from a control socket.
 func (s *Server) AddControlFunc(name string, cType ControlCommandType) error {
 	s.controlFuncLock.Lock()
@@ -119,8 +178,98 @@ func (s *Server) AddControlFunc(name string, cType ControlCommandType) error {
 	return nil
 }
 
+// AddEventSource adds a source of events to the events command, such as the progress of work units.
+func (s *Server) AddEventSource(src EventSource) {
+	s.eventSourcesLock.Lock()
+	defer s.eventSourcesLock.Unlock()
+	s.eventSources = append(s.eventSources, src)
+}
+
+// getEventSources returns the event sources added to the server, if any.
+func (s *Server) getEventSources() []EventSource {
+	if s == nil {
+		return nil
+	}
+	s.eventSourcesLock.RLock()
+	defer s.eventSourcesLock.RUnlock()
+
+	return append([]EventSource(nil), s.eventSources...)
+}
+
+// EnableProfiling adds the profile command, which captures goroutine, heap and CPU profiles of the node.
+// It is off by default, since profiles reveal details of the node's internals and CPU profiling slows it down.
+func (s *Server) EnableProfiling() {
+	s.controlFuncLock.Lock()
+	defer s.controlFuncLock.Unlock()
+	s.controlTypes["profile"] = &profileCommandType{}
+}
+
+// EnableMemStats adds the memstats command, which reports the node's memory statistics and can force a
+// garbage collection.  It is off by default, since forcing a collection pauses the node.
+func (s *Server) EnableMemStats() {
+	s.controlFuncLock.Lock()
+	defer s.controlFuncLock.Unlock()
+	s.controlTypes["memstats"] = &memStatsCommandType{}
+}
+
+// SetAllowedNodes restricts which remote nodes may use the control service over the Receptor network.
+// A nil list allows all nodes.  Local connections (Unix socket and TCP) are not affected.
+func (s *Server) SetAllowedNodes(nodes []string) {
+	s.controlFuncLock.Lock()
+	defer s.controlFuncLock.Unlock()
+	if nodes == nil {
+		s.allowedNodes = nil
+
+		return
+	}
+	s.allowedNodes = make(map[string]bool)
+	for _, node := range nodes {
+		s.allowedNodes[node] = true
+	}
+}
+
+// remoteCallerAllowed reports whether a connection is permitted to run control commands.
+func (s *Server) remoteCallerAllowed(conn net.Conn) bool {
+	addr, ok := conn.RemoteAddr().(netceptor.Addr)
+	if !ok {
+		return true
+	}
+	s.controlFuncLock.RLock()
+	defer s.controlFuncLock.RUnlock()
+
+	return s.allowedNodes == nil || s.allowedNodes[addr.Node()]
+}
+
+// isLocalConn reports whether a connection comes from the node's own host: a Unix socket, or TCP from a
+// loopback address.
+func isLocalConn(conn net.Conn) bool {
+	switch addr := conn.RemoteAddr().(type) {
+	case *net.UnixAddr:
+		return true
+	case *net.TCPAddr:
+		return addr.IP.IsLoopback()
+	}
+
+	return false
+}
+
+// commandIsLocalOnly reports whether a command may only be run from the node's own host.
+func commandIsLocalOnly(cc ControlCommand) bool {
+	if loc, ok := cc.(LocalOnlyCommand); ok {
+		return loc.LocalOnly()
+	}
+
+	return false
+}
+
 // RunControlSession runs the server protocol on the given connection.
 func (s *Server) RunControlSession(conn net.Conn) {
+	s.runControlSession(conn, false)
+}
+
+// runControlSession runs the server protocol on the given connection, refusing commands that change the
+// node if readOnly is set.
+func (s *Server) runControlSession(conn net.Conn, readOnly bool) {
 	logger.Info("Client connected to control service\n")
 	defer func() {
 		logger.Info("Client disconnected from control service\n")
@@ -129,6 +278,14 @@ func (s *Server) RunControlSession(conn net.Conn) {
 			logger.Error("Error closing connection: %s\n", err)
 		}
 	}()
+	caller := callerIdentity(conn)
+	if !s.remoteCallerAllowed(conn) {
+		logger.Warning("Rejected control service connection from %s\n", caller)
+		s.auditCommand(caller, "", "", nil, AuditStatusDenied, fmt.Errorf("node not allowed"))
+		_, _ = conn.Write([]byte("ERROR: Node not allowed to use this control service\n"))
+
+		return
+	}
 	_, err := conn.Write([]byte(fmt.Sprintf("Receptor Control, node %s\n", s.nc.NodeID())))
 	if err != nil {
 		logger.Error("Write error in control service: %s\n", err)
@@ -182,12 +339,15 @@ func (s *Server) RunControlSession(conn net.Conn) {
 				}
 			}
 			if err != nil {
+				s.auditCommand(caller, cmd, "", jsonData, AuditStatusDenied, err)
 				_, err = conn.Write([]byte(fmt.Sprintf("ERROR: %s\n", err)))
 				if err != nil {
 					logger.Error("Write error in control service: %s\n", err)
 
 					return
 				}
+
+				continue
 			}
 		} else {
 			tokens := strings.SplitN(string(cmdBytes), " ", 2)
@@ -209,8 +369,19 @@ func (s *Server) RunControlSession(conn net.Conn) {
 		}
 		s.controlFuncLock.RUnlock()
 		if ct != nil {
+			ctx := context.Background()
+			if traceparent, ok := jsonData[telemetry.TraceparentKey].(string); ok {
+				ctx = telemetry.ContextWithTraceparent(ctx, traceparent)
+			}
+			ctx, span := telemetry.Tracer().Start(ctx, "control "+cmd,
+				trace.WithSpanKind(trace.SpanKindServer),
+				trace.WithAttributes(
+					attribute.String("receptor.node", s.nc.NodeID()),
+					attribute.String("receptor.command", cmd),
+				))
 			cfo := &sockControl{
 				conn: conn,
+				ctx:  ctx,
 			}
 			var cfr map[string]interface{}
 			var cc ControlCommand
@@ -219,9 +390,27 @@ func (s *Server) RunControlSession(conn net.Conn) {
 			} else {
 				cc, err = ct.InitFromJSON(jsonData)
 			}
+			if err == nil && readOnly && !commandIsReadOnly(cmd, cc) {
+				err = fmt.Errorf("%s is not allowed on a read-only control service", cmd)
+			}
+			if err == nil && commandIsLocalOnly(cc) && !isLocalConn(conn) {
+				err = fmt.Errorf("%s is only allowed from the node's own host", cmd)
+			}
 			if err == nil {
 				cfr, err = cc.ControlFunc(s.nc, cfo)
+				if err == nil {
+					s.auditCommand(caller, cmd, params, jsonData, AuditStatusSuccess, nil)
+				} else {
+					s.auditCommand(caller, cmd, params, jsonData, AuditStatusFailed, err)
+				}
+			} else {
+				s.auditCommand(caller, cmd, params, jsonData, AuditStatusDenied, err)
+			}
+			if err != nil {
+				span.RecordError(err)
+				span.SetStatus(codes.Error, err.Error())
 			}
+			span.End()
 			if err != nil {
 				_, err = conn.Write([]byte(fmt.Sprintf("ERROR: %s\n", err)))
 				if err != nil {
@@ -248,6 +437,7 @@ func (s *Server) RunControlSession(conn net.Conn) {
 				}
 			}
 		} else {
+			s.auditCommand(caller, cmd, params, jsonData, AuditStatusDenied, fmt.Errorf("unknown command"))
 			_, err = conn.Write([]byte("ERROR: Unknown command\n"))
 			if err != nil {
 				logger.Error("Write error in control service: %s\n", err)
@@ -258,9 +448,28 @@ func (s *Server) RunControlSession(conn net.Conn) {
 	}
 }
 
+// rejectConnection tells a client that the listener it connected to already has as many connections as it
+// allows, and closes the connection.
+func (s *Server) rejectConnection(conn net.Conn, limit int) {
+	caller := callerIdentity(conn)
+	logger.Warning("Rejected control service connection from %s: limit of %d connections reached\n", caller, limit)
+	s.auditCommand(caller, "", "", nil, AuditStatusDenied, fmt.Errorf("connection limit reached"))
+	_ = conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	_, _ = conn.Write([]byte(fmt.Sprintf("ERROR: Control service connection limit of %d reached, try again later\n", limit)))
+	_ = conn.Close()
+}
+
 // RunControlSvc runs the main accept loop of the control service.
 func (s *Server) RunControlSvc(ctx context.Context, service string, tlscfg *tls.Config,
 	unixSocket string, unixSocketPermissions os.FileMode, tcpListen string, tcptls *tls.Config) error {
+	return s.RunControlSvcWithOptions(ctx, service, tlscfg, unixSocket, unixSocketPermissions, tcpListen, tcptls,
+		ListenerOptions{})
+}
+
+// RunControlSvcWithOptions runs the main accept loop of the control service, applying opts to each of its
+// listeners.
+func (s *Server) RunControlSvcWithOptions(ctx context.Context, service string, tlscfg *tls.Config,
+	unixSocket string, unixSocketPermissions os.FileMode, tcpListen string, tcptls *tls.Config, opts ListenerOptions) error {
 	var uli net.Listener
 	var lock *utils.FLock
 	var err error
@@ -273,8 +482,8 @@ func (s *Server) RunControlSvc(ctx context.Context, service string, tlscfg *tls.
 		uli = nil
 	}
 	var tli net.Listener
+	var listenAddr string
 	if tcpListen != "" {
-		var listenAddr string
 		if strings.Contains(tcpListen, ":") {
 			listenAddr = tcpListen
 		} else {
@@ -318,51 +527,67 @@ func (s *Server) RunControlSvc(ctx context.Context, service string, tlscfg *tls.
 			_ = tli.Close()
 		}
 	}()
-	for _, listener := range []net.Listener{uli, tli, li} {
-		if listener != nil {
-			go func(listener net.Listener) {
-				for {
-					conn, err := listener.Accept()
-					if ctx.Err() != nil {
-						return
+	listeners := make(map[string]net.Listener)
+	if uli != nil {
+		listeners["unix:"+unixSocket] = uli
+	}
+	if tli != nil {
+		listeners["tcp:"+listenAddr] = tli
+	}
+	if li != nil {
+		listeners["service:"+service] = li
+	}
+	for name, listener := range listeners {
+		cl := s.newConnLimiter(name, opts.MaxConnections)
+		go func(listener net.Listener, cl *controlConnLimiter) {
+			for {
+				conn, err := listener.Accept()
+				if ctx.Err() != nil {
+					return
+				}
+				if err != nil {
+					logger.Error("Error accepting connection: %s. Closing listener.\n", err)
+					_ = listener.Close()
+
+					return
+				}
+				go func() {
+					tlsConn, ok := conn.(*tls.Conn)
+					if ok {
+						// Explicitly run server TLS handshake so we can deal with timeout and errors here
+						err := conn.SetDeadline(time.Now().Add(10 * time.Second))
+						if err != nil {
+							logger.Error("Error setting timeout: %s. Closing socket.\n", err)
+							_ = conn.Close()
+
+							return
+						}
+						err = tlsConn.Handshake()
+						if err != nil {
+							logger.Error("TLS handshake error: %s. Closing socket.\n", err)
+							s.auditCommand(callerIdentity(conn), "", "", nil, AuditStatusDenied, err)
+							_ = conn.Close()
+
+							return
+						}
+						err = conn.SetDeadline(time.Time{})
+						if err != nil {
+							logger.Error("Error clearing timeout: %s. Closing socket.\n", err)
+							_ = conn.Close()
+
+							return
+						}
 					}
-					if err != nil {
-						logger.Error("Error accepting connection: %s. Closing listener.\n", err)
-						_ = listener.Close()
+					if !cl.acquire() {
+						s.rejectConnection(conn, cl.limit)
 
 						return
 					}
-					go func() {
-						tlsConn, ok := conn.(*tls.Conn)
-						if ok {
-							// Explicitly run server TLS handshake so we can deal with timeout and errors here
-							err = conn.SetDeadline(time.Now().Add(10 * time.Second))
-							if err != nil {
-								logger.Error("Error setting timeout: %s. Closing socket.\n", err)
-								_ = conn.Close()
-
-								return
-							}
-							err = tlsConn.Handshake()
-							if err != nil {
-								logger.Error("TLS handshake error: %s. Closing socket.\n", err)
-								_ = conn.Close()
-
-								return
-							}
-							err = conn.SetDeadline(time.Time{})
-							if err != nil {
-								logger.Error("Error clearing timeout: %s. Closing socket.\n", err)
-								_ = conn.Close()
-
-								return
-							}
-						}
-						s.RunControlSession(conn)
-					}()
-				}
-			}(listener)
-		}
+					defer cl.release()
+					s.runControlSession(conn, opts.ReadOnly)
+				}()
+			}
+		}(listener, cl)
 	}
 
 	return nil
@@ -374,24 +599,38 @@ func (s *Server) RunControlSvc(ctx context.Context, service string, tlscfg *tls.
 
 // cmdlineConfigWindows is the cmdline configuration object for a control service on Windows.
 type cmdlineConfigWindows struct {
-	Service   string `description:"Receptor service name to listen on" default:"control"`
-	TLS       string `description:"Name of TLS server config for the Receptor listener"`
-	TCPListen string `description:"Local TCP port or host:port to bind to the control service"`
-	TCPTLS    string `description:"Name of TLS server config for the TCP listener"`
+	Service        string `description:"Receptor service name to listen on" default:"control"`
+	TLS            string `description:"Name of TLS server config for the Receptor listener"`
+	TCPListen      string `description:"Local TCP port or host:port to bind to the control service"`
+	TCPTLS         string `description:"Name of TLS server config for the TCP listener"`
+	MaxConnections int    `description:"Maximum concurrent connections to each of the service's listeners (0 for no limit)" default:"0"`
+	ReadOnly       bool   `description:"Only allow commands that do not change the node, such as status and work list" default:"false"`
 }
 
 // cmdlineConfigUnix is the cmdline configuration object for a control service on Unix.
 type cmdlineConfigUnix struct {
-	Service     string `description:"Receptor service name to listen on" default:"control"`
-	Filename    string `description:"Filename of local Unix socket to bind to the service"`
-	Permissions int    `description:"Socket file permissions" default:"0600"`
-	TLS         string `description:"Name of TLS server config for the Receptor listener"`
-	TCPListen   string `description:"Local TCP port or host:port to bind to the control service"`
-	TCPTLS      string `description:"Name of TLS server config for the TCP listener"`
+	Service        string `description:"Receptor service name to listen on" default:"control"`
+	Filename       string `description:"Filename of local Unix socket to bind to the service"`
+	Permissions    int    `description:"Socket file permissions" default:"0600"`
+	TLS            string `description:"Name of TLS server config for the Receptor listener"`
+	TCPListen      string `description:"Local TCP port or host:port to bind to the control service"`
+	TCPTLS         string `description:"Name of TLS server config for the TCP listener"`
+	MaxConnections int    `description:"Maximum concurrent connections to each of the service's listeners (0 for no limit)" default:"0"`
+	ReadOnly       bool   `description:"Only allow commands that do not change the node, such as status and work list" default:"false"`
+}
+
+// Prepare verifies the parameters are correct.
+func (cfg cmdlineConfigUnix) Prepare() error {
+	if cfg.MaxConnections < 0 {
+		return fmt.Errorf("max connections must not be negative")
+	}
+
+	return nil
 }
 
 // Run runs the action.
 func (cfg cmdlineConfigUnix) Run() error {
+	utils.RecordEffectiveConfig("control-service", cfg)
 	if cfg.TLS != "" && cfg.TCPListen != "" && cfg.TCPTLS == "" {
 		logger.Warning("Control service %s has TLS configured on the Receptor listener but not the TCP listener.", cfg.Service)
 	}
@@ -406,8 +645,11 @@ func (cfg cmdlineConfigUnix) Run() error {
 			return err
 		}
 	}
-	err = MainInstance.RunControlSvc(context.Background(), cfg.Service, tlscfg, cfg.Filename,
-		os.FileMode(cfg.Permissions), cfg.TCPListen, tcptls)
+	err = MainInstance.RunControlSvcWithOptions(context.Background(), cfg.Service, tlscfg, cfg.Filename,
+		os.FileMode(cfg.Permissions), cfg.TCPListen, tcptls, ListenerOptions{
+			MaxConnections: cfg.MaxConnections,
+			ReadOnly:       cfg.ReadOnly,
+		})
 	if err != nil {
 		return err
 	}
@@ -415,17 +657,75 @@ func (cfg cmdlineConfigUnix) Run() error {
 	return nil
 }
 
+// Prepare verifies the parameters are correct.
+func (cfg cmdlineConfigWindows) Prepare() error {
+	if cfg.MaxConnections < 0 {
+		return fmt.Errorf("max connections must not be negative")
+	}
+
+	return nil
+}
+
 // Run runs the action.
 func (cfg cmdlineConfigWindows) Run() error {
+	utils.RecordEffectiveConfig("control-service", cfg)
 	return cmdlineConfigUnix{
-		Service:   cfg.Service,
-		TLS:       cfg.TLS,
-		TCPListen: cfg.TCPListen,
-		TCPTLS:    cfg.TCPTLS,
+		Service:        cfg.Service,
+		TLS:            cfg.TLS,
+		TCPListen:      cfg.TCPListen,
+		TCPTLS:         cfg.TCPTLS,
+		MaxConnections: cfg.MaxConnections,
+		ReadOnly:       cfg.ReadOnly,
 	}.Run()
 }
 
+// remoteAccessCfg is the cmdline configuration object for access to the control service over the Receptor network.
+type remoteAccessCfg struct {
+	AllowedNodes []string `description:"Nodes allowed to run control commands over the Receptor network" required:"yes"`
+}
+
+// Prepare applies the access restriction.
+func (cfg remoteAccessCfg) Prepare() error {
+	MainInstance.SetAllowedNodes(cfg.AllowedNodes)
+
+	return nil
+}
+
+// profilingCfg is the cmdline configuration object for the profile control command.
+type profilingCfg struct {
+	Enable bool `description:"Allow profiles of the node to be captured with the profile command" default:"true"`
+}
+
+// Prepare enables the profile command.
+func (cfg profilingCfg) Prepare() error {
+	if cfg.Enable {
+		MainInstance.EnableProfiling()
+	}
+
+	return nil
+}
+
+// memStatsCfg is the cmdline configuration object for the memstats control command.
+type memStatsCfg struct {
+	Enable bool `description:"Allow memory statistics to be read, and garbage collection forced, with the memstats command" default:"true"`
+}
+
+// Prepare enables the memstats command.
+func (cfg memStatsCfg) Prepare() error {
+	if cfg.Enable {
+		MainInstance.EnableMemStats()
+	}
+
+	return nil
+}
+
 func init() {
+	cmdline.RegisterConfigTypeForApp("receptor-control-service",
+		"control-memstats", "Allow memory statistics of the node to be read through the control service", memStatsCfg{}, cmdline.Singleton)
+	cmdline.RegisterConfigTypeForApp("receptor-control-service",
+		"control-profiling", "Allow profiles of the node to be captured through the control service", profilingCfg{}, cmdline.Singleton)
+	cmdline.RegisterConfigTypeForApp("receptor-control-service",
+		"control-remote-access", "Restrict which nodes can use the control service remotely", remoteAccessCfg{}, cmdline.Singleton)
 	if runtime.GOOS == "windows" {
 		cmdline.RegisterConfigTypeForApp("receptor-control-service",
 			"control-service", "Run a control service", cmdlineConfigWindows{})
@@ -438,9 +738,37 @@ func init() {
 type Controllers struct {
 	UnixControl []UnixControl `mapstructure:"unix"`
 	TCPControl  []TCPControl  `mapstructure:"tcp"`
+	// Nodes allowed to run control commands over the Receptor network. Leave empty to allow all.
+	AllowedNodes []string `mapstructure:"allowed-nodes"`
+	// Allow profiles of the node to be captured with the profile command.
+	EnableProfiling bool `mapstructure:"enable-profiling"`
+	// Allow memory statistics to be read, and garbage collection forced, with the memstats command.
+	EnableMemStats bool `mapstructure:"enable-memstats"`
+	// Allow connections to be tried with the test-backend command.
+	EnableTestBackend bool `mapstructure:"enable-test-backend"`
+	// Record an audit trail of control service commands. Leave unset for no audit trail.
+	Audit *Audit `mapstructure:"audit"`
 }
 
 func (c Controllers) Setup(ctx context.Context, cv *Server) error {
+	if len(c.AllowedNodes) > 0 {
+		cv.SetAllowedNodes(c.AllowedNodes)
+	}
+	if c.EnableProfiling {
+		cv.EnableProfiling()
+	}
+	if c.EnableMemStats {
+		cv.EnableMemStats()
+	}
+	if c.EnableTestBackend {
+		cv.EnableTestBackend()
+	}
+	if c.Audit != nil {
+		if err := setupAudit(cv, c.Audit.Filename); err != nil {
+			return fmt.Errorf("could not setup audit from controllers config: %w", err)
+		}
+	}
+
 	for _, c := range c.UnixControl {
 		if err := c.setup(ctx, cv); err != nil {
 			return fmt.Errorf("could not setup unix controller from controllers config: %w", err)
@@ -467,6 +795,10 @@ type UnixControl struct {
 	// TLS config to use for the transport within receptor.
 	// Leave empty for no TLS.
 	ReceptorTLS *tls.ServerConf `mapstructure:"receptor-tls"`
+	// Maximum concurrent connections to each listener. Leave unset for no limit.
+	MaxConnections int `mapstructure:"max-connections"`
+	// Only allow commands that do not change the node.
+	ReadOnly bool `mapstructure:"read-only"`
 }
 
 func (s *UnixControl) setup(ctx context.Context, cv *Server) error {
@@ -488,7 +820,7 @@ func (s *UnixControl) setup(ctx context.Context, cv *Server) error {
 		}
 	}
 
-	return cv.RunControlSvc(
+	return cv.RunControlSvcWithOptions(
 		ctx,
 		service,
 		tlsReceptor,
@@ -496,6 +828,7 @@ func (s *UnixControl) setup(ctx context.Context, cv *Server) error {
 		os.FileMode(perms),
 		"",
 		nil,
+		ListenerOptions{MaxConnections: s.MaxConnections, ReadOnly: s.ReadOnly},
 	)
 }
 
@@ -511,6 +844,10 @@ type TCPControl struct {
 	TCPTLS *tls.ServerConf `mapstructure:"tcp-tls"`
 	// Address to listen on ("host:port" from net package).
 	Address string `mapstructure:"address"`
+	// Maximum concurrent connections to each listener. Leave unset for no limit.
+	MaxConnections int `mapstructure:"max-connections"`
+	// Only allow commands that do not change the node.
+	ReadOnly bool `mapstructure:"read-only"`
 }
 
 func (s *TCPControl) setup(ctx context.Context, cv *Server) error {
@@ -536,7 +873,7 @@ func (s *TCPControl) setup(ctx context.Context, cv *Server) error {
 		}
 	}
 
-	return cv.RunControlSvc(
+	return cv.RunControlSvcWithOptions(
 		ctx,
 		service,
 		tlsReceptor,
@@ -544,5 +881,6 @@ func (s *TCPControl) setup(ctx context.Context, cv *Server) error {
 		0,
 		s.Address,
 		tcptls,
+		ListenerOptions{MaxConnections: s.MaxConnections, ReadOnly: s.ReadOnly},
 	)
 }
@@ -34,6 +34,14 @@ func (s *Server) AddControlFunc(name string, cType ControlCommandType) error {
 	return nil
 }
 
+// AddEventSource adds a source of events to the events command
+func (s *Server) AddEventSource(src EventSource) {
+}
+
+func (s *Server) getEventSources() []EventSource {
+	return nil
+}
+
 // RunControlSession runs the server protocol on the given connection
 func (s *Server) RunControlSession(conn net.Conn) {
 }
@@ -43,3 +51,14 @@ func (s *Server) RunControlSvc(ctx context.Context, service string, tlscfg *tls.
 	unixSocket string, unixSocketPermissions os.FileMode, tcpListen string, tcptls *tls.Config) error {
 	return ErrNotImplemented
 }
+
+// RunControlSvcWithOptions runs the main accept loop of the control service
+func (s *Server) RunControlSvcWithOptions(ctx context.Context, service string, tlscfg *tls.Config,
+	unixSocket string, unixSocketPermissions os.FileMode, tcpListen string, tcptls *tls.Config, opts ListenerOptions) error {
+	return ErrNotImplemented
+}
+
+// ConnectionStatus returns the connection counts of the control service's listeners, keyed by listener
+func (s *Server) ConnectionStatus() map[string]ControlConnStatus {
+	return nil
+}
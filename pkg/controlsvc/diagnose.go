@@ -0,0 +1,66 @@
+package controlsvc
+
+import (
+	"fmt"
+
+	"github.com/ansible/receptor/pkg/netceptor"
+)
+
+type (
+	diagnoseCommandType struct{}
+	diagnoseCommand     struct {
+		target string
+	}
+)
+
+func (t *diagnoseCommandType) InitFromString(params string) (ControlCommand, error) {
+	if params == "" {
+		return nil, fmt.Errorf("no diagnose target")
+	}
+	c := &diagnoseCommand{
+		target: params,
+	}
+
+	return c, nil
+}
+
+func (t *diagnoseCommandType) InitFromJSON(config map[string]interface{}) (ControlCommand, error) {
+	target, ok := config["target"]
+	if !ok {
+		return nil, fmt.Errorf("no diagnose target")
+	}
+	targetStr, ok := target.(string)
+	if !ok {
+		return nil, fmt.Errorf("diagnose target must be string")
+	}
+	c := &diagnoseCommand{
+		target: targetStr,
+	}
+
+	return c, nil
+}
+
+func (c *diagnoseCommand) ControlFunc(nc *netceptor.Netceptor, cfo ControlFuncOperations) (map[string]interface{}, error) {
+	target := c.target
+	if target == "localhost" {
+		target = nc.NodeID()
+	}
+	d := nc.Diagnose(target)
+	cfr := make(map[string]interface{})
+	cfr["NodeID"] = d.NodeID
+	cfr["Known"] = d.Known
+	cfr["LastSeen"] = d.LastSeen
+	cfr["Reachable"] = d.Reachable
+	cfr["Path"] = d.Path
+	cfr["PathCost"] = d.PathCost
+	cfr["Direct"] = d.Direct
+	cfr["Links"] = d.Links
+	cfr["AllowedPeer"] = d.AllowedPeer
+	cfr["Rejection"] = d.Rejection
+	cfr["SignaturesEnforced"] = d.SignaturesEnforced
+	cfr["VerificationKey"] = d.VerificationKey
+	cfr["UpdateRejection"] = d.UpdateRejection
+	cfr["Problems"] = d.Problems
+
+	return cfr, nil
+}
@@ -0,0 +1,103 @@
+package controlsvc
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ansible/receptor/pkg/netceptor"
+)
+
+type (
+	displayNameCommandType struct{}
+	displayNameCommand     struct {
+		subcommand string
+		name       string
+	}
+)
+
+func (t *displayNameCommandType) InitFromString(params string) (ControlCommand, error) {
+	params = strings.TrimSpace(params)
+	c := &displayNameCommand{
+		subcommand: "show",
+	}
+	if params != "" {
+		fields := strings.SplitN(params, " ", 2)
+		c.subcommand = strings.ToLower(fields[0])
+		if len(fields) > 1 {
+			c.name = strings.TrimSpace(fields[1])
+		}
+	}
+	switch c.subcommand {
+	case "show", "clear":
+		if c.name != "" {
+			return nil, fmt.Errorf("displayname %s does not take parameters", c.subcommand)
+		}
+	case "set":
+		if c.name == "" {
+			return nil, fmt.Errorf("displayname set requires a name")
+		}
+	default:
+		return nil, fmt.Errorf("unknown displayname subcommand %s", c.subcommand)
+	}
+
+	return c, c.validate()
+}
+
+func (t *displayNameCommandType) InitFromJSON(config map[string]interface{}) (ControlCommand, error) {
+	c := &displayNameCommand{
+		subcommand: "show",
+	}
+	if subcommand, ok := config["subcommand"]; ok {
+		subcommandStr, ok := subcommand.(string)
+		if !ok {
+			return nil, fmt.Errorf("displayname subcommand must be string")
+		}
+		c.subcommand = strings.ToLower(subcommandStr)
+	}
+	switch c.subcommand {
+	case "show", "clear":
+	case "set":
+		name, ok := config["name"].(string)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("displayname set requires a name")
+		}
+		c.name = name
+	default:
+		return nil, fmt.Errorf("unknown displayname subcommand %s", c.subcommand)
+	}
+
+	return c, c.validate()
+}
+
+// validate checks the name being set, so that a bad one is refused before the command runs.
+func (c *displayNameCommand) validate() error {
+	if c.subcommand != "set" {
+		return nil
+	}
+
+	return netceptor.ValidateNodeDisplayName(c.name)
+}
+
+// ReadOnly reports whether the command only shows the display name.
+func (c *displayNameCommand) ReadOnly() bool {
+	return c.subcommand == "show"
+}
+
+// ControlFunc shows or changes the display name of the node.  The node ID is never changed.
+func (c *displayNameCommand) ControlFunc(nc *netceptor.Netceptor, cfo ControlFuncOperations) (map[string]interface{}, error) {
+	switch c.subcommand {
+	case "set":
+		if err := nc.SetNodeDisplayName(c.name); err != nil {
+			return nil, err
+		}
+	case "clear":
+		if err := nc.SetNodeDisplayName(""); err != nil {
+			return nil, err
+		}
+	}
+	cfr := make(map[string]interface{})
+	cfr["NodeID"] = nc.NodeID()
+	cfr["NodeDisplayName"] = nc.NodeDisplayName()
+
+	return cfr, nil
+}
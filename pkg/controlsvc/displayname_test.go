@@ -0,0 +1,54 @@
+package controlsvc
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/ansible/receptor/pkg/netceptor"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDisplayName(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	nc := netceptor.New(ctx, "node1", nil)
+	defer nc.Shutdown()
+	s := New(true, nc)
+
+	run := func(command string) map[string]interface{} {
+		result := make(map[string]interface{})
+		response := localCommand(t, s, command)
+		if !assert.NoError(t, json.Unmarshal([]byte(response), &result), response) {
+			t.FailNow()
+		}
+
+		return result
+	}
+	result := run("displayname")
+	assert.Equal(t, "node1", result["NodeID"])
+	assert.Equal(t, "", result["NodeDisplayName"])
+
+	result = run("displayname set Build server (rack 4)")
+	assert.Equal(t, "Build server (rack 4)", result["NodeDisplayName"])
+
+	// The display name is shown in the status, and the routing node ID is unchanged
+	status := run("status")
+	assert.Equal(t, "Build server (rack 4)", status["NodeDisplayName"])
+	assert.Equal(t, "node1", status["NodeID"])
+	assert.Equal(t, "node1", nc.NodeID())
+
+	result = run(`{"command": "displayname", "subcommand": "set", "name": "Build server"}`)
+	assert.Equal(t, "Build server", result["NodeDisplayName"])
+	assert.Equal(t, "Build server", nc.NodeDisplayName())
+
+	result = run("displayname clear")
+	assert.Equal(t, "", result["NodeDisplayName"])
+	assert.Equal(t, "node1", result["NodeID"])
+
+	assert.Contains(t, localCommand(t, s, "displayname set"), "requires a name")
+	assert.Contains(t, localCommand(t, s, "displayname rename x"), "unknown displayname subcommand")
+	assert.Contains(t, localCommand(t, s, `{"command": "displayname", "subcommand": "set", "name": "a\nb"}`),
+		"control characters")
+	assert.Equal(t, "", nc.NodeDisplayName())
+}
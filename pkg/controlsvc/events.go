@@ -0,0 +1,110 @@
+package controlsvc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ansible/receptor/pkg/netceptor"
+)
+
+type (
+	eventsCommandType struct {
+		server *Server
+	}
+	eventsCommand struct {
+		server *Server
+	}
+)
+
+func (t *eventsCommandType) InitFromString(params string) (ControlCommand, error) {
+	if strings.TrimSpace(params) != "" {
+		return nil, fmt.Errorf("events does not take parameters")
+	}
+
+	return &eventsCommand{server: t.server}, nil
+}
+
+func (t *eventsCommandType) InitFromJSON(config map[string]interface{}) (ControlCommand, error) {
+	return &eventsCommand{server: t.server}, nil
+}
+
+// reconnectEvent returns the event stream form of a backend reconnect.
+func reconnectEvent(ev netceptor.BackendReconnect) map[string]interface{} {
+	return map[string]interface{}{
+		"Type":     "reconnect",
+		"Time":     ev.Time,
+		"ID":       ev.ID,
+		"Backend":  ev.Backend,
+		"Node":     ev.Node,
+		"Downtime": ev.Downtime.Round(time.Millisecond).String(),
+		"Attempts": ev.Attempts,
+	}
+}
+
+// ControlFunc streams events from the node, one JSON object per line after a header line, until the client
+// closes the connection or its side of it.
+func (c *eventsCommand) ControlFunc(nc *netceptor.Netceptor, cfo ControlFuncOperations) (map[string]interface{}, error) {
+	ctx, cancel := context.WithCancel(cfo.Context())
+	defer cancel()
+	reconnects := nc.SubscribeBackendReconnects(ctx)
+	go func() {
+		// The client sends nothing more, so the read only ends when it goes away
+		_ = cfo.ReadFromConn("", ioutil.Discard)
+		cancel()
+	}()
+	lines := make(chan []byte)
+	send := func(ev map[string]interface{}) bool {
+		line, err := json.Marshal(ev)
+		if err != nil {
+			return true
+		}
+		select {
+		case lines <- append(line, '\n'):
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for ev := range reconnects {
+			if !send(reconnectEvent(ev)) {
+				return
+			}
+		}
+	}()
+	for _, src := range c.server.getEventSources() {
+		events := src(ctx)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ev := range events {
+				if !send(ev) {
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(lines)
+	}()
+	err := cfo.WriteToConn("Streaming events\n", lines)
+	cancel()
+	if err != nil {
+		return nil, err
+	}
+	err = cfo.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
@@ -0,0 +1,63 @@
+package controlsvc
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/ansible/receptor/pkg/netceptor"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEventsCommand(t *testing.T) {
+	ct := &eventsCommandType{}
+	_, err := ct.InitFromString("")
+	assert.NoError(t, err)
+	_, err = ct.InitFromString("all")
+	assert.Error(t, err)
+	_, err = ct.InitFromJSON(map[string]interface{}{"command": "events"})
+	assert.NoError(t, err)
+
+	ev := reconnectEvent(netceptor.BackendReconnect{
+		Time:     time.Now(),
+		ID:       2,
+		Backend:  "tcp-peer 10.0.0.1:2222",
+		Node:     "node2",
+		Downtime: 1500 * time.Millisecond,
+		Attempts: 4,
+	})
+	line, err := json.Marshal(ev)
+	assert.NoError(t, err)
+	parsed := make(map[string]interface{})
+	assert.NoError(t, json.Unmarshal(line, &parsed))
+	assert.Equal(t, "reconnect", parsed["Type"])
+	assert.Equal(t, "node2", parsed["Node"])
+	assert.Equal(t, "1.5s", parsed["Downtime"])
+	assert.Equal(t, 4.0, parsed["Attempts"])
+
+	// The stream runs until the client goes away
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	nc := netceptor.New(ctx, "node1", nil)
+	client, server := net.Pipe()
+	cmd, err := ct.InitFromString("")
+	assert.NoError(t, err)
+	done := make(chan error, 1)
+	go func() {
+		_, err := cmd.ControlFunc(nc, &sockControl{conn: server, ctx: ctx})
+		done <- err
+	}()
+	header, err := bufio.NewReader(client).ReadString('\n')
+	assert.NoError(t, err)
+	assert.Equal(t, "Streaming events\n", header)
+	_ = client.Close()
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the event stream to end")
+	}
+}
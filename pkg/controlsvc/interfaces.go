@@ -1,6 +1,7 @@
 package controlsvc
 
 import (
+	"context"
 	"io"
 
 	"github.com/ansible/receptor/pkg/netceptor"
@@ -17,10 +18,44 @@ type ControlCommand interface {
 	ControlFunc(*netceptor.Netceptor, ControlFuncOperations) (map[string]interface{}, error)
 }
 
+// ReadOnlyCommand is implemented by control commands that change the node for only some of their
+// subcommands.  ReadOnly reports whether this instance of the command leaves the node unchanged, so that
+// it may be run on a read-only listener.
+type ReadOnlyCommand interface {
+	ReadOnly() bool
+}
+
+// LocalOnlyCommand is implemented by control commands that act on files of the node's host, and so may only
+// be run over a Unix socket or a TCP connection from the same host, not from another node.
+type LocalOnlyCommand interface {
+	LocalOnly() bool
+}
+
+// ListenerOptions are the settings of each listener of a control service.
+type ListenerOptions struct {
+	// MaxConnections is the most concurrent connections each listener allows, or any number if zero or less.
+	MaxConnections int
+	// ReadOnly restricts the listeners to commands that do not change the node.
+	ReadOnly bool
+}
+
+// ControlConnStatus is the connection count of a control service listener.
+type ControlConnStatus struct {
+	Active   int
+	Limit    int
+	Total    uint64
+	Rejected uint64
+}
+
 // ControlFuncOperations provides callbacks for control services to take actions.
 type ControlFuncOperations interface {
 	BridgeConn(message string, bc io.ReadWriteCloser, bcName string) error
 	ReadFromConn(message string, out io.Writer) error
 	WriteToConn(message string, in chan []byte) error
 	Close() error
+	Context() context.Context
 }
+
+// EventSource is a source of events for the events command.  It returns a channel of events, each of which
+// is streamed as a JSON object and should have a Type field, and closes the channel once ctx is done.
+type EventSource func(ctx context.Context) <-chan map[string]interface{}
@@ -0,0 +1,54 @@
+package controlsvc
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+
+	"github.com/ansible/receptor/pkg/netceptor"
+	"github.com/stretchr/testify/assert"
+)
+
+type localOnlyCommand struct{}
+
+func (c *localOnlyCommand) ControlFunc(nc *netceptor.Netceptor, cfo ControlFuncOperations) (map[string]interface{}, error) {
+	return nil, nil
+}
+
+func (c *localOnlyCommand) LocalOnly() bool {
+	return true
+}
+
+func TestLocalOnlyCommand(t *testing.T) {
+	assert.True(t, commandIsLocalOnly(&localOnlyCommand{}))
+	assert.False(t, commandIsLocalOnly(&eventsCommand{}))
+
+	// Unix sockets and loopback TCP connections are local
+	li, err := net.Listen("unix", filepath.Join(t.TempDir(), "local.sock"))
+	assert.NoError(t, err)
+	defer li.Close()
+	conn, err := net.Dial("unix", li.Addr().String())
+	assert.NoError(t, err)
+	defer conn.Close()
+	server, err := li.Accept()
+	assert.NoError(t, err)
+	defer server.Close()
+	assert.True(t, isLocalConn(server))
+
+	tli, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer tli.Close()
+	tconn, err := net.Dial("tcp", tli.Addr().String())
+	assert.NoError(t, err)
+	defer tconn.Close()
+	tserver, err := tli.Accept()
+	assert.NoError(t, err)
+	defer tserver.Close()
+	assert.True(t, isLocalConn(tserver))
+
+	// Anything else, such as a connection over the Receptor network, is not
+	client, pipe := net.Pipe()
+	defer client.Close()
+	defer pipe.Close()
+	assert.False(t, isLocalConn(pipe))
+}
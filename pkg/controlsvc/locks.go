@@ -0,0 +1,42 @@
+package controlsvc
+
+import (
+	"fmt"
+
+	"github.com/ansible/receptor/pkg/netceptor"
+	"github.com/ansible/receptor/pkg/utils"
+)
+
+type (
+	locksCommandType struct{}
+	locksCommand     struct{}
+)
+
+func (t *locksCommandType) InitFromString(params string) (ControlCommand, error) {
+	if params != "" {
+		return nil, fmt.Errorf("locks does not take parameters")
+	}
+	c := &locksCommand{}
+
+	return c, nil
+}
+
+func (t *locksCommandType) InitFromJSON(config map[string]interface{}) (ControlCommand, error) {
+	c := &locksCommand{}
+
+	return c, nil
+}
+
+// ControlFunc lists the lock files of the Unix sockets of proxy and control services, and who holds them.
+func (c *locksCommand) ControlFunc(nc *netceptor.Netceptor, cfo ControlFuncOperations) (map[string]interface{}, error) {
+	cfr := make(map[string]interface{})
+	for _, lock := range utils.UnixSocketLocks() {
+		cfr[lock.Filename] = map[string]interface{}{
+			"PID":   lock.PID,
+			"Held":  lock.Held,
+			"Stale": lock.Stale,
+		}
+	}
+
+	return cfr, nil
+}
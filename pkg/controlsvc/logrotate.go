@@ -0,0 +1,39 @@
+package controlsvc
+
+import (
+	"github.com/ansible/receptor/pkg/logger"
+	"github.com/ansible/receptor/pkg/netceptor"
+)
+
+type (
+	logrotateCommandType struct{}
+	logrotateCommand     struct{}
+)
+
+func (t *logrotateCommandType) InitFromString(params string) (ControlCommand, error) {
+	c := &logrotateCommand{}
+
+	return c, nil
+}
+
+func (t *logrotateCommandType) InitFromJSON(config map[string]interface{}) (ControlCommand, error) {
+	c := &logrotateCommand{}
+
+	return c, nil
+}
+
+// ControlFunc reopens the log file, so that logging continues to a new file after external rotation.
+func (c *logrotateCommand) ControlFunc(nc *netceptor.Netceptor, cfo ControlFuncOperations) (map[string]interface{}, error) {
+	cfr := make(map[string]interface{})
+	err := logger.ReopenLogFile()
+	if err != nil {
+		cfr["Success"] = false
+		cfr["Error"] = err.Error()
+
+		return cfr, nil
+	}
+	cfr["Success"] = true
+	cfr["Filename"] = logger.LogFileName()
+
+	return cfr, nil
+}
@@ -0,0 +1,77 @@
+package controlsvc
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/ansible/receptor/pkg/netceptor"
+)
+
+type (
+	memStatsCommandType struct{}
+	memStatsCommand     struct {
+		gc bool
+	}
+)
+
+func (t *memStatsCommandType) InitFromString(params string) (ControlCommand, error) {
+	tokens := strings.Fields(params)
+	c := &memStatsCommand{}
+	if len(tokens) > 1 {
+		return nil, fmt.Errorf("memstats takes at most one parameter")
+	}
+	if len(tokens) == 1 {
+		if strings.ToLower(tokens[0]) != "--gc" && strings.ToLower(tokens[0]) != "gc" {
+			return nil, fmt.Errorf("unknown memstats option %s", tokens[0])
+		}
+		c.gc = true
+	}
+
+	return c, nil
+}
+
+func (t *memStatsCommandType) InitFromJSON(config map[string]interface{}) (ControlCommand, error) {
+	c := &memStatsCommand{}
+	if gc, ok := config["gc"]; ok {
+		c.gc, ok = gc.(bool)
+		if !ok {
+			return nil, fmt.Errorf("memstats gc must be boolean")
+		}
+	}
+
+	return c, nil
+}
+
+// ReadOnly reports whether the command only reads the statistics, without forcing a garbage collection.
+func (c *memStatsCommand) ReadOnly() bool {
+	return !c.gc
+}
+
+// ControlFunc returns the memory statistics of the node, optionally after forcing a garbage collection.
+func (c *memStatsCommand) ControlFunc(nc *netceptor.Netceptor, cfo ControlFuncOperations) (map[string]interface{}, error) {
+	if c.gc {
+		runtime.GC()
+	}
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	cfr := make(map[string]interface{})
+	cfr["HeapAlloc"] = ms.HeapAlloc
+	cfr["HeapInuse"] = ms.HeapInuse
+	cfr["HeapObjects"] = ms.HeapObjects
+	cfr["HeapSys"] = ms.HeapSys
+	cfr["Sys"] = ms.Sys
+	cfr["TotalAlloc"] = ms.TotalAlloc
+	cfr["Mallocs"] = ms.Mallocs
+	cfr["Frees"] = ms.Frees
+	cfr["NumGC"] = ms.NumGC
+	cfr["PauseTotalNs"] = ms.PauseTotalNs
+	if ms.LastGC != 0 {
+		cfr["LastGC"] = time.Unix(0, int64(ms.LastGC)).UTC()
+	}
+	cfr["Goroutines"] = runtime.NumGoroutine()
+	cfr["GCForced"] = c.gc
+
+	return cfr, nil
+}
@@ -0,0 +1,50 @@
+package controlsvc
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/ansible/receptor/pkg/netceptor"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemStats(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	nc := netceptor.New(ctx, "node1", nil)
+	defer nc.Shutdown()
+	s := New(true, nc)
+	assert.Equal(t, "ERROR: Unknown command\n", localCommand(t, s, "memstats"))
+	s.EnableMemStats()
+
+	memStats := func(command string) map[string]interface{} {
+		result := make(map[string]interface{})
+		response := localCommand(t, s, command)
+		if !assert.NoError(t, json.Unmarshal([]byte(response), &result), response) {
+			t.FailNow()
+		}
+
+		return result
+	}
+	result := memStats("memstats")
+	for _, field := range []string{"HeapAlloc", "HeapSys", "Sys", "NumGC", "Goroutines"} {
+		value, ok := result[field].(float64)
+		assert.True(t, ok, "%s should be a number, got %v", field, result[field])
+		if field != "NumGC" {
+			assert.Greater(t, value, 0.0, field)
+		}
+	}
+	assert.Equal(t, false, result["GCForced"])
+
+	// Forcing a collection shows up in the count
+	before := result["NumGC"].(float64)
+	result = memStats("memstats --gc")
+	assert.Equal(t, true, result["GCForced"])
+	assert.Greater(t, result["NumGC"].(float64), before)
+	assert.Contains(t, result, "LastGC")
+	result = memStats(`{"command": "memstats", "gc": true}`)
+	assert.Equal(t, true, result["GCForced"])
+
+	assert.Contains(t, localCommand(t, s, "memstats now"), "unknown memstats option")
+}
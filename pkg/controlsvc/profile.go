@@ -0,0 +1,127 @@
+package controlsvc
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"runtime/pprof"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/ansible/receptor/pkg/netceptor"
+)
+
+// MaxCPUProfileDuration is the longest CPU profile the profile command will capture.
+const MaxCPUProfileDuration = 5 * time.Minute
+
+// cpuProfiling is set while a CPU profile is being captured, since only one can run at a time.
+var cpuProfiling int32
+
+type (
+	profileCommandType struct{}
+	profileCommand     struct {
+		kind     string
+		duration time.Duration
+	}
+)
+
+// validateProfileCommand checks the parameters of a profile command.
+func validateProfileCommand(kind string, duration string) (*profileCommand, error) {
+	c := &profileCommand{kind: kind}
+	switch kind {
+	case "goroutine", "heap":
+		if duration != "" {
+			return nil, fmt.Errorf("%s profile does not take a duration", kind)
+		}
+	case "cpu":
+		if duration == "" {
+			return nil, fmt.Errorf("cpu profile requires a duration")
+		}
+		var err error
+		c.duration, err = time.ParseDuration(duration)
+		if err != nil {
+			return nil, fmt.Errorf("invalid duration %s: %s", duration, err)
+		}
+		if c.duration <= 0 || c.duration > MaxCPUProfileDuration {
+			return nil, fmt.Errorf("cpu profile duration must be between 0 and %s", MaxCPUProfileDuration)
+		}
+	default:
+		return nil, fmt.Errorf("unknown profile %s, must be goroutine, heap or cpu", kind)
+	}
+
+	return c, nil
+}
+
+func (t *profileCommandType) InitFromString(params string) (ControlCommand, error) {
+	tokens := strings.Fields(params)
+	if len(tokens) < 1 || len(tokens) > 2 {
+		return nil, fmt.Errorf("usage: profile goroutine|heap|cpu <duration>")
+	}
+	duration := ""
+	if len(tokens) == 2 {
+		duration = tokens[1]
+	}
+
+	return validateProfileCommand(strings.ToLower(tokens[0]), duration)
+}
+
+func (t *profileCommandType) InitFromJSON(config map[string]interface{}) (ControlCommand, error) {
+	kindIf, ok := config["profile"]
+	if !ok {
+		return nil, fmt.Errorf("no profile specified")
+	}
+	kind, ok := kindIf.(string)
+	if !ok {
+		return nil, fmt.Errorf("profile must be string")
+	}
+	duration := ""
+	if durationIf, ok := config["duration"]; ok {
+		duration, ok = durationIf.(string)
+		if !ok {
+			return nil, fmt.Errorf("duration must be string")
+		}
+	}
+
+	return validateProfileCommand(strings.ToLower(kind), duration)
+}
+
+// captureProfile returns a profile in the gzipped protobuf format read by go tool pprof.
+func captureProfile(kind string, duration time.Duration) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	if kind != "cpu" {
+		if err := pprof.Lookup(kind).WriteTo(buf, 0); err != nil {
+			return nil, err
+		}
+
+		return buf.Bytes(), nil
+	}
+	if !atomic.CompareAndSwapInt32(&cpuProfiling, 0, 1) {
+		return nil, fmt.Errorf("a cpu profile is already being captured")
+	}
+	defer atomic.StoreInt32(&cpuProfiling, 0)
+	if err := pprof.StartCPUProfile(buf); err != nil {
+		return nil, err
+	}
+	time.Sleep(duration)
+	pprof.StopCPUProfile()
+
+	return buf.Bytes(), nil
+}
+
+// ControlFunc captures a profile of the node and returns it base64 encoded.
+func (c *profileCommand) ControlFunc(nc *netceptor.Netceptor, cfo ControlFuncOperations) (map[string]interface{}, error) {
+	data, err := captureProfile(c.kind, c.duration)
+	if err != nil {
+		return nil, err
+	}
+	cfr := make(map[string]interface{})
+	cfr["Profile"] = c.kind
+	cfr["Encoding"] = "base64"
+	cfr["Data"] = base64.StdEncoding.EncodeToString(data)
+	if c.kind == "cpu" {
+		cfr["Duration"] = c.duration.String()
+	}
+
+	return cfr, nil
+}
@@ -0,0 +1,95 @@
+package controlsvc
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ansible/receptor/pkg/netceptor"
+	"github.com/stretchr/testify/assert"
+)
+
+// localCommand runs a command on a control service and returns the response line.
+func localCommand(t *testing.T, s *Server, command string) string {
+	client, server := net.Pipe()
+	defer client.Close()
+	go s.RunControlSession(server)
+	reader := bufio.NewReader(client)
+	_, err := reader.ReadString('\n')
+	assert.NoError(t, err)
+	_, err = client.Write([]byte(command + "\n"))
+	assert.NoError(t, err)
+	response, _ := reader.ReadString('\n')
+
+	return response
+}
+
+func TestProfileGoroutine(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	nc := netceptor.New(ctx, "node1", nil)
+	defer nc.Shutdown()
+	s := New(true, nc)
+	s.EnableProfiling()
+
+	response := localCommand(t, s, "profile goroutine")
+	result := make(map[string]interface{})
+	assert.NoError(t, json.Unmarshal([]byte(response), &result))
+	assert.Equal(t, "goroutine", result["Profile"])
+	assert.Equal(t, "base64", result["Encoding"])
+	data, err := base64.StdEncoding.DecodeString(result["Data"].(string))
+	assert.NoError(t, err)
+
+	// pprof profiles are gzipped protobuf messages
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	assert.NoError(t, err)
+	raw, err := ioutil.ReadAll(gz)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, raw)
+}
+
+func TestProfileNotEnabled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	nc := netceptor.New(ctx, "node1", nil)
+	defer nc.Shutdown()
+	s := New(true, nc)
+
+	assert.Equal(t, "ERROR: Unknown command\n", localCommand(t, s, "profile goroutine"))
+}
+
+func TestProfileCommandValidation(t *testing.T) {
+	ct := &profileCommandType{}
+	for _, params := range []string{"", "threads", "goroutine 5s", "cpu", "cpu soon", "cpu 0s", "cpu 1h"} {
+		_, err := ct.InitFromString(params)
+		assert.Error(t, err, params)
+	}
+	cc, err := ct.InitFromString("CPU 10s")
+	assert.NoError(t, err)
+	assert.Equal(t, &profileCommand{kind: "cpu", duration: 10 * time.Second}, cc)
+	cc, err = ct.InitFromJSON(map[string]interface{}{"profile": "heap"})
+	assert.NoError(t, err)
+	assert.Equal(t, &profileCommand{kind: "heap"}, cc)
+}
+
+func TestProfileCPUNotConcurrent(t *testing.T) {
+	done := make(chan error, 1)
+	go func() {
+		_, err := captureProfile("cpu", 500*time.Millisecond)
+		done <- err
+	}()
+	for atomic.LoadInt32(&cpuProfiling) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	_, err := captureProfile("cpu", time.Second)
+	assert.EqualError(t, err, "a cpu profile is already being captured")
+	assert.NoError(t, <-done)
+}
@@ -0,0 +1,80 @@
+package controlsvc
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/ansible/receptor/pkg/netceptor"
+)
+
+type (
+	reachabilityCommandType struct{}
+	reachabilityCommand     struct {
+		probe bool
+	}
+)
+
+func (t *reachabilityCommandType) InitFromString(params string) (ControlCommand, error) {
+	c := &reachabilityCommand{}
+	switch params {
+	case "":
+	case "--probe", "probe":
+		c.probe = true
+	default:
+		return nil, fmt.Errorf("unknown reachability option %s", params)
+	}
+
+	return c, nil
+}
+
+func (t *reachabilityCommandType) InitFromJSON(config map[string]interface{}) (ControlCommand, error) {
+	c := &reachabilityCommand{}
+	probe, ok := config["probe"]
+	if ok {
+		c.probe, ok = probe.(bool)
+		if !ok {
+			return nil, fmt.Errorf("reachability probe must be boolean")
+		}
+	}
+
+	return c, nil
+}
+
+func (c *reachabilityCommand) ControlFunc(nc *netceptor.Netceptor, cfo ControlFuncOperations) (map[string]interface{}, error) {
+	cfr := make(map[string]interface{})
+	results := make(map[string]map[string]interface{})
+	for _, nr := range nc.Reachability() {
+		results[nr.NodeID] = map[string]interface{}{
+			"Reachable": nr.Reachable,
+			"PathCost":  nr.PathCost,
+			"NextHop":   nr.NextHop,
+			"LastSeen":  nr.LastSeen,
+		}
+	}
+	if c.probe {
+		wg := sync.WaitGroup{}
+		for nodeID, result := range results {
+			wg.Add(1)
+			go func(nodeID string, result map[string]interface{}) {
+				defer wg.Done()
+				pingTime, _, err := ping(nc, nodeID, nc.MaxForwardingHops())
+				probe := make(map[string]interface{})
+				if err == nil {
+					probe["Success"] = true
+					probe["Time"] = pingTime
+					probe["TimeStr"] = fmt.Sprint(pingTime)
+				} else {
+					probe["Success"] = false
+					probe["Error"] = err.Error()
+				}
+				result["Probe"] = probe
+			}(nodeID, result)
+		}
+		wg.Wait()
+	}
+	for nodeID, result := range results {
+		cfr[nodeID] = result
+	}
+
+	return cfr, nil
+}
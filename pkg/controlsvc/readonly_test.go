@@ -0,0 +1,62 @@
+package controlsvc
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ansible/receptor/pkg/netceptor"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadOnlyListener(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	nc := netceptor.New(ctx, "node1", nil)
+	s := New(true, nc)
+	s.EnableProfiling()
+
+	// A read-only listener and a normal one, on Unix sockets
+	dir := t.TempDir()
+	sockets := make([]string, 0)
+	for i, readOnly := range []bool{true, false} {
+		socket := filepath.Join(dir, fmt.Sprintf("control%d.sock", i))
+		assert.NoError(t, s.RunControlSvcWithOptions(ctx, "", nil, socket, 0o600, "", nil, ListenerOptions{ReadOnly: readOnly}))
+		sockets = append(sockets, socket)
+	}
+	run := func(socket string, command string) string {
+		conn, err := net.Dial("unix", socket)
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+		defer conn.Close()
+		_ = conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+		reader := bufio.NewReader(conn)
+		_, err = reader.ReadString('\n')
+		assert.NoError(t, err)
+		_, err = conn.Write([]byte(command + "\n"))
+		assert.NoError(t, err)
+		line, err := reader.ReadString('\n')
+		assert.NoError(t, err)
+
+		return line
+	}
+
+	for _, command := range []string{"status", "connections", "traffic", "allowedpeers show", `{"command": "traffic"}`} {
+		line := run(sockets[0], command)
+		assert.NotContains(t, line, "ERROR", command)
+	}
+	for _, command := range []string{"traffic reset", "allowedpeers set node2", "reconverge", "logrotate",
+		"profile goroutine", `{"command": "traffic", "reset": true}`} {
+		line := run(sockets[0], command)
+		assert.Contains(t, line, "not allowed on a read-only control service", command)
+	}
+
+	// The same commands are allowed on the normal listener
+	line := run(sockets[1], "traffic reset")
+	assert.NotContains(t, line, "ERROR")
+}
@@ -0,0 +1,56 @@
+package controlsvc
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ansible/receptor/pkg/netceptor"
+)
+
+type (
+	reconvergeCommandType struct{}
+	reconvergeCommand     struct {
+		neighbors bool
+	}
+)
+
+func (t *reconvergeCommandType) InitFromString(params string) (ControlCommand, error) {
+	tokens := strings.Fields(params)
+	c := &reconvergeCommand{}
+	if len(tokens) > 1 {
+		return nil, fmt.Errorf("reconverge takes at most one parameter")
+	}
+	if len(tokens) == 1 {
+		if strings.ToLower(tokens[0]) != "neighbors" {
+			return nil, fmt.Errorf("unknown reconverge option %s", tokens[0])
+		}
+		c.neighbors = true
+	}
+
+	return c, nil
+}
+
+func (t *reconvergeCommandType) InitFromJSON(config map[string]interface{}) (ControlCommand, error) {
+	c := &reconvergeCommand{}
+	if neighbors, ok := config["neighbors"]; ok {
+		c.neighbors, ok = neighbors.(bool)
+		if !ok {
+			return nil, fmt.Errorf("reconverge neighbors must be boolean")
+		}
+	}
+
+	return c, nil
+}
+
+// ControlFunc sends a routing update right away, and optionally asks the neighbors to do the same.
+func (c *reconvergeCommand) ControlFunc(nc *netceptor.Netceptor, cfo ControlFuncOperations) (map[string]interface{}, error) {
+	neighbors, err := nc.Reconverge(c.neighbors)
+	if err != nil {
+		return nil, err
+	}
+	cfr := make(map[string]interface{})
+	cfr["Reconverged"] = true
+	cfr["Neighbors"] = neighbors
+
+	return cfr, nil
+}
@@ -2,11 +2,11 @@ package controlsvc
 
 import (
 	"fmt"
-	"io/ioutil"
 	"strings"
 
 	"github.com/ansible/receptor/pkg/logger"
 	"github.com/ansible/receptor/pkg/netceptor"
+	"github.com/ansible/receptor/pkg/utils"
 	"gopkg.in/yaml.v2"
 )
 
@@ -72,12 +72,12 @@ func parseConfigForReload(filename string, checkReload bool) error {
 	// Finally, cfgAbsent() will loop through the map and check for any remaining
 	// items that are still false. This means the original item is missing from
 	// the config, and an error will be thrown
-	data, err := ioutil.ReadFile(filename)
+	lc, err := utils.LoadConfig(filename)
 	if err != nil {
 		return err
 	}
 	m := make([]interface{}, 0)
-	err = yaml.Unmarshal(data, &m)
+	err = yaml.Unmarshal(lc.Data, &m)
 	if err != nil {
 		return err
 	}
@@ -163,7 +163,7 @@ func (c *reloadCommand) ControlFunc(nc *netceptor.Netceptor, cfo ControlFuncOper
 	logger.Debug("Reloading")
 
 	// Do a quick check to catch any yaml errors before canceling backends
-	err := reloadParseAndRun([]string{"PreReload"})
+	err := reloadParseAndRun([]string{"InitReload", "PreReload", "ValidateReload"})
 	if err != nil {
 		return handleError(err, 4)
 	}
@@ -181,8 +181,10 @@ func (c *reloadCommand) ControlFunc(nc *netceptor.Netceptor, cfo ControlFuncOper
 	}
 
 	nc.CancelBackends()
+	// The reloaded actions record their new config as they run again
+	utils.ForgetEffectiveConfig(reloadableActions)
 	// reloadParseAndRun is a ParseAndRun closure, set in receptor.go/main()
-	err = reloadParseAndRun([]string{"PreReload", "Reload"})
+	err = reloadParseAndRun([]string{"InitReload", "PreReload", "Reload"})
 	if err != nil {
 		return handleError(err, 4)
 	}
@@ -1,6 +1,9 @@
 package controlsvc
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -41,3 +44,35 @@ func TestReload(t *testing.T) {
 		}
 	}
 }
+
+func TestReloadDirectory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "receptor-test-*")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	writeFragment := func(name string, content string) {
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0o600))
+	}
+	writeFragment("10-node.yml", "- node:\n    id: foo\n- log-level: debug\n")
+	writeFragment("20-listener.yml", "- tcp-listener:\n    port: 2222\n")
+
+	cfgNotReloadable = make(map[string]bool)
+	err = parseConfigForReload(dir, false)
+	assert.NoError(t, err)
+	assert.Len(t, cfgNotReloadable, 2)
+
+	// Adding a fragment with a reloadable backend is allowed
+	writeFragment("30-peer.yml", "- tcp-peer:\n    address: localhost:2223\n")
+	assert.NoError(t, parseConfigForReload(dir, true))
+	assert.NoError(t, cfgAbsent())
+
+	// Adding a fragment with a non-reloadable item is not
+	writeFragment("40-work.yml", "- work-command:\n    worktype: echo\n    command: echo\n")
+	assert.Error(t, parseConfigForReload(dir, true))
+	_ = cfgAbsent()
+	assert.NoError(t, os.Remove(filepath.Join(dir, "40-work.yml")))
+
+	// Removing a fragment with a non-reloadable item is not allowed either
+	assert.NoError(t, os.Remove(filepath.Join(dir, "10-node.yml")))
+	assert.NoError(t, parseConfigForReload(dir, true))
+	assert.Error(t, cfgAbsent())
+}
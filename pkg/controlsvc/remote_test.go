@@ -0,0 +1,67 @@
+package controlsvc
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/ansible/receptor/pkg/backends/backendstest"
+	"github.com/stretchr/testify/assert"
+)
+
+// remoteCommand runs a command on node2's control service by tunnelling through node1's.
+func remoteCommand(t *testing.T, local *Server, command string) (string, string) {
+	client, server := net.Pipe()
+	defer client.Close()
+	go local.RunControlSession(server)
+	reader := bufio.NewReader(client)
+	_, err := reader.ReadString('\n')
+	assert.NoError(t, err)
+	_, err = client.Write([]byte("connect node2 control\n"))
+	assert.NoError(t, err)
+	line, err := reader.ReadString('\n')
+	assert.NoError(t, err)
+	assert.Equal(t, "Connecting\n", line)
+	banner, err := reader.ReadString('\n')
+	assert.NoError(t, err)
+	_, err = client.Write([]byte(command + "\n"))
+	assert.NoError(t, err)
+	response, _ := reader.ReadString('\n')
+
+	return banner, response
+}
+
+func TestRemoteControl(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	n1, n2 := backendstest.ConnectedNodes(ctx, t)
+	defer n1.Shutdown()
+	defer n2.Shutdown()
+
+	remote := New(true, n2)
+	sink := &memoryAuditSink{}
+	remote.SetAuditSink(sink)
+	assert.NoError(t, remote.RunControlSvc(ctx, "control", nil, "", 0, "", nil))
+	local := New(true, n1)
+
+	banner, response := remoteCommand(t, local, "status")
+	assert.Equal(t, "Receptor Control, node node2\n", banner)
+	status := make(map[string]interface{})
+	assert.NoError(t, json.Unmarshal([]byte(response), &status))
+	assert.Equal(t, "node2", status["NodeID"])
+	sink.lock.Lock()
+	assert.Len(t, sink.records, 1)
+	assert.Contains(t, sink.records[0].Caller, ":node1:")
+	sink.lock.Unlock()
+
+	// Remote callers that are not allowed are refused before any command runs
+	remote.SetAllowedNodes([]string{"some-other-node"})
+	_, response = remoteCommand(t, local, "status")
+	assert.True(t, strings.HasPrefix(response, "ERROR") || response == "")
+	remote.SetAllowedNodes([]string{"node1"})
+	_, response = remoteCommand(t, local, "status")
+	assert.NoError(t, json.Unmarshal([]byte(response), &status))
+}
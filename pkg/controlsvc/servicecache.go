@@ -0,0 +1,104 @@
+package controlsvc
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ansible/receptor/pkg/netceptor"
+)
+
+type (
+	serviceCommandType struct{}
+	serviceCommand     struct {
+		action  string
+		service string
+	}
+)
+
+func (t *serviceCommandType) InitFromString(params string) (ControlCommand, error) {
+	tokens := strings.Fields(params)
+	if len(tokens) == 0 || strings.ToLower(tokens[0]) != "cache" {
+		return nil, fmt.Errorf("service requires the subcommand cache")
+	}
+	c := &serviceCommand{
+		action: "show",
+	}
+	if len(tokens) > 1 {
+		c.action = strings.ToLower(tokens[1])
+	}
+	switch c.action {
+	case "show":
+		if len(tokens) > 2 {
+			return nil, fmt.Errorf("service cache does not take parameters")
+		}
+	case "clear":
+		if len(tokens) > 3 {
+			return nil, fmt.Errorf("service cache clear takes at most one service")
+		}
+		if len(tokens) == 3 {
+			c.service = tokens[2]
+		}
+	default:
+		return nil, fmt.Errorf("unknown service cache action %s", c.action)
+	}
+
+	return c, nil
+}
+
+func (t *serviceCommandType) InitFromJSON(config map[string]interface{}) (ControlCommand, error) {
+	if subcommand, ok := config["subcommand"].(string); !ok || strings.ToLower(subcommand) != "cache" {
+		return nil, fmt.Errorf("service requires the subcommand cache")
+	}
+	c := &serviceCommand{
+		action: "show",
+	}
+	if action, ok := config["action"]; ok {
+		actionStr, ok := action.(string)
+		if !ok {
+			return nil, fmt.Errorf("service cache action must be string")
+		}
+		c.action = strings.ToLower(actionStr)
+	}
+	switch c.action {
+	case "show":
+	case "clear":
+		if service, ok := config["service"]; ok {
+			c.service, ok = service.(string)
+			if !ok {
+				return nil, fmt.Errorf("service cache service must be string")
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unknown service cache action %s", c.action)
+	}
+
+	return c, nil
+}
+
+// ReadOnly reports whether the command only shows the service cache.
+func (c *serviceCommand) ReadOnly() bool {
+	return c.action == "show"
+}
+
+// ControlFunc shows the nodes that services were last reached on when dialed by name alone, or clears
+// them so that the services are resolved again on the next dial.
+func (c *serviceCommand) ControlFunc(nc *netceptor.Netceptor, cfo ControlFuncOperations) (map[string]interface{}, error) {
+	cfr := make(map[string]interface{})
+	if c.action == "clear" {
+		cfr["Cleared"] = nc.ClearServiceCache(c.service)
+
+		return cfr, nil
+	}
+	services := make(map[string]interface{})
+	for _, entry := range nc.ServiceCache() {
+		services[entry.Service] = map[string]interface{}{
+			"NodeID":   entry.NodeID,
+			"Resolved": entry.Resolved.Format(time.RFC3339),
+			"Age":      time.Since(entry.Resolved).Round(time.Second).String(),
+		}
+	}
+	cfr["Services"] = services
+
+	return cfr, nil
+}
@@ -0,0 +1,43 @@
+package controlsvc
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/ansible/receptor/pkg/netceptor"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServiceCacheCommand(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	nc := netceptor.New(ctx, "node1", nil)
+	defer nc.Shutdown()
+	s := New(true, nc)
+
+	run := func(command string) map[string]interface{} {
+		result := make(map[string]interface{})
+		response := localCommand(t, s, command)
+		if !assert.NoError(t, json.Unmarshal([]byte(response), &result), response) {
+			t.FailNow()
+		}
+
+		return result
+	}
+	assert.Equal(t, map[string]interface{}{}, run("service cache")["Services"])
+	assert.Equal(t, []interface{}{}, run("service cache clear")["Cleared"])
+	assert.Equal(t, []interface{}{}, run(`{"command": "service", "subcommand": "cache", "action": "clear", "service": "web"}`)["Cleared"])
+
+	assert.Contains(t, localCommand(t, s, "service"), "requires the subcommand cache")
+	assert.Contains(t, localCommand(t, s, "service cache flush"), "unknown service cache action")
+	assert.Contains(t, localCommand(t, s, "service cache clear web api"), "at most one service")
+
+	ct := &serviceCommandType{}
+	for command, readOnly := range map[string]bool{"cache": true, "cache clear": false, "cache clear web": false} {
+		cmd, err := ct.InitFromString(command)
+		if assert.NoError(t, err) {
+			assert.Equal(t, readOnly, cmd.(*serviceCommand).ReadOnly(), command)
+		}
+	}
+}
@@ -9,9 +9,12 @@ import (
 )
 
 type (
-	statusCommandType struct{}
-	statusCommand     struct {
+	statusCommandType struct {
+		server *Server
+	}
+	statusCommand struct {
 		requestedFields []string
+		server          *Server
 	}
 )
 
@@ -19,7 +22,9 @@ func (t *statusCommandType) InitFromString(params string) (ControlCommand, error
 	if params != "" {
 		return nil, fmt.Errorf("status command does not take parameters")
 	}
-	c := &statusCommand{}
+	c := &statusCommand{
+		server: t.server,
+	}
 
 	return c, nil
 }
@@ -41,6 +46,7 @@ func (t *statusCommandType) InitFromJSON(config map[string]interface{}) (Control
 	}
 	c := &statusCommand{
 		requestedFields: requestedFieldsStr,
+		server:          t.server,
 	}
 
 	return c, nil
@@ -53,10 +59,19 @@ func (c *statusCommand) ControlFunc(nc *netceptor.Netceptor, cfo ControlFuncOper
 	statusGetters["SystemCPUCount"] = func() interface{} { return utils.GetSysCPUCount() }
 	statusGetters["SystemMemoryMiB"] = func() interface{} { return utils.GetSysMemoryMiB() }
 	statusGetters["NodeID"] = func() interface{} { return status.NodeID }
+	statusGetters["NodeDisplayName"] = func() interface{} { return status.NodeDisplayName }
 	statusGetters["Connections"] = func() interface{} { return status.Connections }
 	statusGetters["RoutingTable"] = func() interface{} { return status.RoutingTable }
 	statusGetters["Advertisements"] = func() interface{} { return status.Advertisements }
 	statusGetters["KnownConnectionCosts"] = func() interface{} { return status.KnownConnectionCosts }
+	statusGetters["ClockSkew"] = func() interface{} { return status.ClockSkew }
+	statusGetters["NodeRoles"] = func() interface{} { return status.NodeRoles }
+	statusGetters["ExpiredMessages"] = func() interface{} { return status.ExpiredMessages }
+	statusGetters["ListenerConnections"] = func() interface{} { return status.ListenerConnections }
+	statusGetters["SessionBuffers"] = func() interface{} { return status.SessionBuffers }
+	if c.server != nil {
+		statusGetters["ControlConnections"] = func() interface{} { return c.server.ConnectionStatus() }
+	}
 	cfr := make(map[string]interface{})
 	if c.requestedFields == nil { // if nil, fill it with the keys in statusGetters
 		for field := range statusGetters {
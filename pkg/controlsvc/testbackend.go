@@ -0,0 +1,132 @@
+//go:build !no_backends && !no_controlsvc
+// +build !no_backends,!no_controlsvc
+
+package controlsvc
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/ansible/receptor/pkg/backends"
+	"github.com/ansible/receptor/pkg/netceptor"
+	"github.com/ghjm/cmdline"
+)
+
+// defaultTestBackendTimeout is how long test-backend waits for a connection if no timeout is given.
+const defaultTestBackendTimeout = 10 * time.Second
+
+// MaxTestBackendTimeout is the longest test-backend will wait for a connection.
+const MaxTestBackendTimeout = time.Minute
+
+// EnableTestBackend adds the test-backend command, which makes a connection with a dialer config that is not
+// part of the node's config.  It is off by default, since it lets control service users, including remote
+// nodes, make the node connect to any address.
+func (s *Server) EnableTestBackend() {
+	s.controlFuncLock.Lock()
+	defer s.controlFuncLock.Unlock()
+	s.controlTypes["test-backend"] = &testBackendCommandType{}
+}
+
+type (
+	testBackendCommandType struct{}
+	testBackendCommand     struct {
+		backend string
+		config  map[string]interface{}
+		timeout time.Duration
+	}
+)
+
+func (t *testBackendCommandType) InitFromString(params string) (ControlCommand, error) {
+	if params == "" {
+		return nil, fmt.Errorf("no backend config")
+	}
+	config := make(map[string]interface{})
+	if err := json.Unmarshal([]byte(params), &config); err != nil {
+		return nil, fmt.Errorf("backend config must be JSON: %s", err)
+	}
+
+	return t.InitFromJSON(config)
+}
+
+func (t *testBackendCommandType) InitFromJSON(config map[string]interface{}) (ControlCommand, error) {
+	backend, ok := config["backend"]
+	if !ok {
+		return nil, fmt.Errorf("no backend type, must be one of %v", backends.DialConfigKinds())
+	}
+	backendStr, ok := backend.(string)
+	if !ok {
+		return nil, fmt.Errorf("backend type must be string")
+	}
+	backendConfig, ok := config["config"]
+	if !ok {
+		return nil, fmt.Errorf("no backend config")
+	}
+	backendConfigMap, ok := backendConfig.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("backend config must be an object")
+	}
+	timeout := defaultTestBackendTimeout
+	if timeoutVal, ok := config["timeout"]; ok {
+		timeoutStr, ok := timeoutVal.(string)
+		if !ok {
+			return nil, fmt.Errorf("timeout must be string")
+		}
+		var err error
+		timeout, err = time.ParseDuration(timeoutStr)
+		if err != nil || timeout <= 0 {
+			return nil, fmt.Errorf("invalid timeout %s", timeoutStr)
+		}
+		if timeout > MaxTestBackendTimeout {
+			return nil, fmt.Errorf("timeout must be at most %s", MaxTestBackendTimeout)
+		}
+	}
+	c := &testBackendCommand{
+		backend: backendStr,
+		config:  backendConfigMap,
+		timeout: timeout,
+	}
+
+	return c, nil
+}
+
+// ControlFunc makes a single connection with a backend that is not part of the config, and reports how it went.
+func (c *testBackendCommand) ControlFunc(nc *netceptor.Netceptor, cfo ControlFuncOperations) (map[string]interface{}, error) {
+	check, err := backends.CheckDial(c.backend, c.config, c.timeout)
+	if err != nil {
+		return nil, err
+	}
+	cfr := make(map[string]interface{})
+	cfr["Backend"] = c.backend
+	cfr["Connected"] = check.Connected
+	cfr["Time"] = check.Elapsed
+	cfr["TimeStr"] = fmt.Sprint(check.Elapsed)
+	if check.Error != "" {
+		cfr["Error"] = check.Error
+	}
+	if len(check.Details) > 0 {
+		cfr["Details"] = check.Details
+	}
+
+	return cfr, nil
+}
+
+// testBackendCfg is the cmdline configuration object for the test-backend control command.
+type testBackendCfg struct {
+	Enable bool `description:"Allow connections to be tried with the test-backend command" default:"true"`
+}
+
+// Prepare enables the test-backend command.
+func (cfg testBackendCfg) Prepare() error {
+	if cfg.Enable {
+		MainInstance.EnableTestBackend()
+	}
+
+	return nil
+}
+
+func init() {
+	cmdline.RegisterConfigTypeForApp("receptor-control-service",
+		"control-test-backend", "Allow backend dialer configs to be tried through the control service", testBackendCfg{},
+		cmdline.Singleton)
+}
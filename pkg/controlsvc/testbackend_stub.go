@@ -0,0 +1,8 @@
+//go:build no_backends
+// +build no_backends
+
+package controlsvc
+
+// EnableTestBackend would add the test-backend command, but there are no backends to try in this build.
+func (s *Server) EnableTestBackend() {
+}
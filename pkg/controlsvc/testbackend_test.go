@@ -0,0 +1,92 @@
+//go:build !no_backends && !no_controlsvc
+// +build !no_backends,!no_controlsvc
+
+package controlsvc
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/ansible/receptor/pkg/backends"
+	"github.com/stretchr/testify/assert"
+)
+
+// runTestBackend runs a test-backend command given as a string, as from the control socket.
+func runTestBackend(params string) (map[string]interface{}, error) {
+	cc, err := (&testBackendCommandType{}).InitFromString(params)
+	if err != nil {
+		return nil, err
+	}
+
+	return cc.ControlFunc(nil, nil)
+}
+
+func TestTestBackendReachable(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	li, err := backends.NewTCPListener("127.0.0.1:0", nil)
+	assert.NoError(t, err)
+	_, err = li.Start(ctx, &sync.WaitGroup{})
+	assert.NoError(t, err)
+
+	cfr, err := runTestBackend(fmt.Sprintf(`{"backend":"tcp","config":{"address":"%s"},"timeout":"5s"}`, li.Addr()))
+	assert.NoError(t, err)
+	assert.Equal(t, true, cfr["Connected"])
+	assert.Equal(t, "tcp", cfr["Backend"])
+	assert.NotContains(t, cfr, "Error")
+}
+
+func TestTestBackendUnreachable(t *testing.T) {
+	// Find a port that nothing is listening on
+	li, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	addr := li.Addr().String()
+	assert.NoError(t, li.Close())
+
+	cfr, err := runTestBackend(fmt.Sprintf(`{"backend":"tcp","config":{"address":"%s"},"timeout":"5s"}`, addr))
+	assert.NoError(t, err)
+	assert.Equal(t, false, cfr["Connected"])
+	assert.NotEmpty(t, cfr["Error"])
+}
+
+func TestTestBackendWebsocketDetails(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	li, err := backends.NewWebsocketListener("127.0.0.1:0", nil)
+	assert.NoError(t, err)
+	li.SetCompression(true)
+	_, err = li.Start(ctx, &sync.WaitGroup{})
+	assert.NoError(t, err)
+
+	cfr, err := runTestBackend(fmt.Sprintf(
+		`{"backend":"ws","config":{"address":"ws://%s/","compression":true,"read-deadline":"1m"}}`, li.Addr()))
+	assert.NoError(t, err)
+	assert.Equal(t, true, cfr["Connected"])
+	assert.Equal(t, map[string]interface{}{"Compression": true}, cfr["Details"])
+}
+
+func TestTestBackendInvalid(t *testing.T) {
+	for _, params := range []string{
+		``,
+		`tcp`,
+		`{"config":{"address":"127.0.0.1:1"}}`,
+		`{"backend":"tcp"}`,
+		`{"backend":"carrier-pigeon","config":{"address":"127.0.0.1:1"}}`,
+		`{"backend":"tcp","config":{"address":"127.0.0.1:1","colour":"blue"}}`,
+		`{"backend":"tcp","config":{"address":"127.0.0.1:1"},"timeout":"soon"}`,
+		`{"backend":"tcp","config":{"address":"127.0.0.1:1"},"timeout":"2h"}`,
+	} {
+		_, err := runTestBackend(params)
+		assert.Error(t, err, params)
+	}
+}
+
+func TestTestBackendOptIn(t *testing.T) {
+	s := New(true, nil)
+	assert.NotContains(t, s.controlTypes, "test-backend")
+	s.EnableTestBackend()
+	assert.Contains(t, s.controlTypes, "test-backend")
+}
@@ -0,0 +1,75 @@
+package controlsvc
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ansible/receptor/pkg/netceptor"
+)
+
+type (
+	trafficCommandType struct{}
+	trafficCommand     struct {
+		reset bool
+	}
+)
+
+func (t *trafficCommandType) InitFromString(params string) (ControlCommand, error) {
+	tokens := strings.Fields(params)
+	c := &trafficCommand{}
+	if len(tokens) > 1 {
+		return nil, fmt.Errorf("traffic takes at most one parameter")
+	}
+	if len(tokens) == 1 {
+		if strings.ToLower(tokens[0]) != "reset" {
+			return nil, fmt.Errorf("unknown traffic option %s", tokens[0])
+		}
+		c.reset = true
+	}
+
+	return c, nil
+}
+
+func (t *trafficCommandType) InitFromJSON(config map[string]interface{}) (ControlCommand, error) {
+	c := &trafficCommand{}
+	if reset, ok := config["reset"]; ok {
+		c.reset, ok = reset.(bool)
+		if !ok {
+			return nil, fmt.Errorf("traffic reset must be boolean")
+		}
+	}
+
+	return c, nil
+}
+
+// ReadOnly reports whether the command leaves the counters as they are.
+func (c *trafficCommand) ReadOnly() bool {
+	return !c.reset
+}
+
+// ControlFunc returns the traffic counters for each node and service, and optionally clears them.
+func (c *trafficCommand) ControlFunc(nc *netceptor.Netceptor, cfo ControlFuncOperations) (map[string]interface{}, error) {
+	var counters []netceptor.TrafficCounter
+	if c.reset {
+		counters = nc.ResetTrafficCounters()
+	} else {
+		counters = nc.TrafficCounters()
+	}
+	traffic := make([]map[string]interface{}, 0, len(counters))
+	for _, tc := range counters {
+		traffic = append(traffic, map[string]interface{}{
+			"Node":        tc.Node,
+			"Service":     tc.Service,
+			"BytesIn":     tc.BytesIn,
+			"BytesOut":    tc.BytesOut,
+			"PacketsIn":   tc.PacketsIn,
+			"PacketsOut":  tc.PacketsOut,
+			"Connections": tc.Connections,
+		})
+	}
+	cfr := make(map[string]interface{})
+	cfr["Traffic"] = traffic
+	cfr["Reset"] = c.reset
+
+	return cfr, nil
+}
@@ -0,0 +1,160 @@
+package logger
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/ghjm/cmdline"
+)
+
+// logFile is a log destination that can be reopened after external log rotation.
+type logFile struct {
+	lock     sync.Mutex
+	filename string
+	file     *os.File
+}
+
+var (
+	currentLogFile     *logFile
+	currentLogFileLock sync.Mutex
+	sighupOnce         sync.Once
+	stdoutReserved     bool
+)
+
+func openLogFile(filename string) (*os.File, error) {
+	return os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o640)
+}
+
+// Write writes a log entry to the current file.
+func (lf *logFile) Write(p []byte) (int, error) {
+	lf.lock.Lock()
+	defer lf.lock.Unlock()
+
+	return lf.file.Write(p)
+}
+
+// reopen opens the file by name again, so that entries go to a new file if the old one was rotated.
+// The new file is opened before the old one is closed, and writes wait for the swap, so no entries are lost.
+func (lf *logFile) reopen() error {
+	file, err := openLogFile(lf.filename)
+	if err != nil {
+		return err
+	}
+	lf.lock.Lock()
+	old := lf.file
+	lf.file = file
+	lf.lock.Unlock()
+
+	return old.Close()
+}
+
+// SetLogFile sends log output to a file, which can later be reopened by ReopenLogFile.
+func SetLogFile(filename string) error {
+	file, err := openLogFile(filename)
+	if err != nil {
+		return err
+	}
+	lf := &logFile{
+		filename: filename,
+		file:     file,
+	}
+	currentLogFileLock.Lock()
+	old := currentLogFile
+	currentLogFile = lf
+	log.SetOutput(lf)
+	currentLogFileLock.Unlock()
+	if old != nil {
+		old.lock.Lock()
+		_ = old.file.Close()
+		old.lock.Unlock()
+	}
+
+	return nil
+}
+
+// LogToStderr sends log output to stderr rather than stdout, for when stdout carries something else.  It has
+// no effect on log output while logging to a file, but stdout stays reserved either way.
+func LogToStderr() {
+	currentLogFileLock.Lock()
+	defer currentLogFileLock.Unlock()
+	stdoutReserved = true
+	if currentLogFile == nil {
+		log.SetOutput(os.Stderr)
+	}
+}
+
+// StdoutReserved returns true if stdout carries something other than output, since LogToStderr was called.
+// Anything that would write to stdout, such as a child process, should write to stderr instead.
+func StdoutReserved() bool {
+	currentLogFileLock.Lock()
+	defer currentLogFileLock.Unlock()
+
+	return stdoutReserved
+}
+
+// LogFileName returns the name of the file log output is going to, or an empty string if it is not going to a file.
+func LogFileName() string {
+	currentLogFileLock.Lock()
+	defer currentLogFileLock.Unlock()
+	if currentLogFile == nil {
+		return ""
+	}
+
+	return currentLogFile.filename
+}
+
+// ReopenLogFile reopens the log file, for use after it has been rotated.
+func ReopenLogFile() error {
+	currentLogFileLock.Lock()
+	lf := currentLogFile
+	currentLogFileLock.Unlock()
+	if lf == nil {
+		return fmt.Errorf("not logging to a file")
+	}
+	err := lf.reopen()
+	if err != nil {
+		return err
+	}
+	Info("Reopened log file %s\n", lf.filename)
+
+	return nil
+}
+
+// ReopenLogFileOnSIGHUP reopens the log file whenever receptor receives a SIGHUP.
+func ReopenLogFileOnSIGHUP() {
+	sighupOnce.Do(func() {
+		sigChan := make(chan os.Signal, 1)
+		signal.Notify(sigChan, syscall.SIGHUP)
+		go func() {
+			for range sigChan {
+				err := ReopenLogFile()
+				if err != nil {
+					Error("Error reopening log file: %s\n", err)
+				}
+			}
+		}()
+	})
+}
+
+type logFileCfg struct {
+	Filename string `description:"File to write log output to. Reopened on SIGHUP or a logrotate control command." barevalue:"yes" required:"true"`
+}
+
+func (cfg logFileCfg) Init() error {
+	err := SetLogFile(cfg.Filename)
+	if err != nil {
+		return err
+	}
+	ReopenLogFileOnSIGHUP()
+
+	return nil
+}
+
+func init() {
+	cmdline.RegisterConfigTypeForApp("receptor-logging",
+		"log-file", "Write log output to a file", logFileCfg{}, cmdline.Singleton)
+}
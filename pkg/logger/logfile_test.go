@@ -0,0 +1,72 @@
+package logger
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReopenLogFile(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "receptor-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	t.Cleanup(func() {
+		currentLogFileLock.Lock()
+		if currentLogFile != nil {
+			_ = currentLogFile.file.Close()
+			currentLogFile = nil
+		}
+		currentLogFileLock.Unlock()
+		log.SetOutput(os.Stdout)
+	})
+	err = ReopenLogFile()
+	if err == nil {
+		t.Fatal("expected an error reopening without a log file")
+	}
+	filename := filepath.Join(tmpdir, "receptor.log")
+	err = SetLogFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	Info("before rotation\n")
+	rotated := filename + ".1"
+	err = os.Rename(filename, rotated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Entries still go to the renamed file until it is reopened
+	Info("during rotation\n")
+	err = ReopenLogFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	Info("after rotation\n")
+
+	oldData, err := ioutil.ReadFile(rotated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newData, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"before rotation", "during rotation"} {
+		if !strings.Contains(string(oldData), line) {
+			t.Errorf("rotated log file is missing %q: %q", line, oldData)
+		}
+	}
+	if strings.Contains(string(oldData), "after rotation") {
+		t.Errorf("rotated log file has entries written after reopening: %q", oldData)
+	}
+	if !strings.Contains(string(newData), "after rotation") {
+		t.Errorf("new log file is missing entries written after reopening: %q", newData)
+	}
+	if strings.Contains(string(newData), "before rotation") {
+		t.Errorf("new log file has entries written before rotation: %q", newData)
+	}
+}
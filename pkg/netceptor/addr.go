@@ -14,6 +14,11 @@ func (a Addr) Network() string {
 	return a.network
 }
 
+// Node returns the node ID of this address.
+func (a Addr) Node() string {
+	return a.node
+}
+
 // String formats this address as a string.
 func (a Addr) String() string {
 	return fmt.Sprintf("%s:%s", a.node, a.service)
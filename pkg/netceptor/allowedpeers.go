@@ -0,0 +1,81 @@
+package netceptor
+
+import (
+	"context"
+
+	"github.com/ansible/receptor/pkg/logger"
+)
+
+// SetAllowedPeers replaces the list of node IDs that are allowed to connect to this node.  A nil list allows
+// all nodes.  The new list applies to connections made from now on.  If drop is true, existing connections
+// to nodes that are no longer allowed are closed, and the IDs of those nodes are returned.
+func (s *Netceptor) SetAllowedPeers(peers []string, drop bool) []string {
+	if peers != nil {
+		peers = append([]string{}, peers...)
+	}
+	s.connLock.Lock()
+	s.allowedPeers = peers
+	dropped := make([]string, 0)
+	cancels := make([]context.CancelFunc, 0)
+	if drop {
+		for nodeID, ci := range s.connections {
+			if !s.isAllowedPeer(nodeID) {
+				dropped = append(dropped, nodeID)
+				cancels = append(cancels, ci.CancelFunc)
+			}
+		}
+	}
+	s.connLock.Unlock()
+	if peers == nil {
+		logger.Info("Allowing connections from all peers\n")
+	} else {
+		logger.Info("Allowing connections from peers %v\n", peers)
+	}
+	for i := range cancels {
+		logger.Info("Closing connection with %s because it is no longer an allowed peer\n", dropped[i])
+		cancels[i]()
+	}
+
+	return dropped
+}
+
+// AllowedPeers returns the node IDs that are allowed to connect to this node, or nil if all nodes are allowed.
+func (s *Netceptor) AllowedPeers() []string {
+	s.connLock.RLock()
+	defer s.connLock.RUnlock()
+	if s.allowedPeers == nil {
+		return nil
+	}
+
+	return append([]string{}, s.allowedPeers...)
+}
+
+// isAllowedPeer returns true if a node is allowed to connect.  Must be called with connLock held.
+func (s *Netceptor) isAllowedPeer(nodeID string) bool {
+	if s.allowedPeers == nil {
+		return true
+	}
+	for _, peer := range s.allowedPeers {
+		if peer == nodeID {
+			return true
+		}
+	}
+
+	return false
+}
+
+// pathAllowsPeer returns true if a node may connect on a listener path.  Paths without an entry in
+// pathPeers allow every node.
+func pathAllowsPeer(pathPeers map[string][]string, path string, nodeID string) bool {
+	peers, ok := pathPeers[path]
+	if !ok {
+		return true
+	}
+	for _, peer := range peers {
+		if peer == nodeID {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,124 @@
+package netceptor
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSetAllowedPeers(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	nA := New(ctx, "A", nil)
+	nB := New(ctx, "B", nil)
+	connect := func() {
+		sa, sb := newPipeSessions()
+		if err := nA.AddBackend(&pipeBackend{sess: sa}, 1.0, nil); err != nil {
+			t.Fatal(err)
+		}
+		if err := nB.AddBackend(&pipeBackend{sess: sb}, 1.0, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitFor := func(desc string, cond func() bool) {
+		deadline := time.Now().Add(10 * time.Second)
+		for !cond() {
+			if time.Now().After(deadline) {
+				t.Fatalf("timed out waiting for %s", desc)
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+	}
+	connect()
+	waitFor("A to connect to B", func() bool { return nA.Diagnose("B").Direct })
+
+	// Tightening the list without dropping leaves the existing connection alone
+	if dropped := nA.SetAllowedPeers([]string{"C"}, false); len(dropped) != 0 {
+		t.Errorf("expected no connections to be dropped, got %v", dropped)
+	}
+	if !nA.Diagnose("B").Direct {
+		t.Error("connection to B was dropped")
+	}
+	if peers := nA.AllowedPeers(); len(peers) != 1 || peers[0] != "C" {
+		t.Errorf("unexpected allowed peers %v", peers)
+	}
+
+	// Dropping closes the connection, and B's reconnection is rejected
+	dropped := nA.SetAllowedPeers([]string{"C"}, true)
+	if len(dropped) != 1 || dropped[0] != "B" {
+		t.Errorf("expected B to be dropped, got %v", dropped)
+	}
+	waitFor("A to drop B", func() bool { return !nA.Diagnose("B").Direct })
+	connect()
+	waitFor("A to reject B", func() bool {
+		rej := nA.Diagnose("B").Rejection
+
+		return rej != nil && strings.Contains(rej.Reason, "accepted connections list")
+	})
+	if nA.Diagnose("B").Direct {
+		t.Error("B reconnected after being disallowed")
+	}
+
+	// Allowing all peers again lets B reconnect
+	nA.SetAllowedPeers(nil, false)
+	if nA.AllowedPeers() != nil {
+		t.Error("expected all peers to be allowed")
+	}
+	connect()
+	waitFor("B to reconnect", func() bool { return nA.Diagnose("B").Direct })
+}
+
+type pathSession struct {
+	*pipeSession
+	path string
+}
+
+func (ps *pathSession) ListenPath() string {
+	return ps.path
+}
+
+func TestPathAllowedPeers(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	nA := New(ctx, "A", nil)
+	nB := New(ctx, "B", nil)
+	nC := New(ctx, "C", nil)
+	opts := BackendOptions{PathAllowedPeers: map[string][]string{"/ops": {"C"}}}
+	connect := func(n *Netceptor, path string) {
+		sa, sb := newPipeSessions()
+		if err := nA.AddBackendWithOptions(&pipeBackend{sess: &pathSession{pipeSession: sa, path: path}}, 1.0, nil, opts); err != nil {
+			t.Fatal(err)
+		}
+		if err := n.AddBackend(&pipeBackend{sess: sb}, 1.0, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitFor := func(desc string, cond func() bool) {
+		deadline := time.Now().Add(10 * time.Second)
+		for !cond() {
+			if time.Now().After(deadline) {
+				t.Fatalf("timed out waiting for %s", desc)
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+	}
+
+	// B is not allowed on /ops, but paths without an entry accept anyone
+	connect(nB, "/ops")
+	waitFor("A to reject B", func() bool {
+		rej := nA.Diagnose("B").Rejection
+
+		return rej != nil && strings.Contains(rej.Reason, "accepted connections list for path /ops")
+	})
+	connect(nB, "/")
+	waitFor("A to connect to B", func() bool { return nA.Diagnose("B").Direct })
+	connect(nC, "/ops")
+	waitFor("A to connect to C", func() bool { return nA.Diagnose("C").Direct })
+
+	// The recording wrapper must not hide the path
+	rs := &RecordingSession{BackendSession: &pathSession{path: "/ops"}}
+	if rs.ListenPath() != "/ops" {
+		t.Error("recorded session lost its listen path")
+	}
+}
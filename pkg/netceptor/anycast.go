@@ -0,0 +1,141 @@
+package netceptor
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"sort"
+)
+
+// ErrNoAdvertisers is returned when no reachable node advertises a service.
+var ErrNoAdvertisers = fmt.Errorf("no reachable node advertises the service")
+
+// ServiceAdvertiser is a node advertising a service, and the cost of reaching it from this node.
+type ServiceAdvertiser struct {
+	NodeID   string
+	PathCost float64
+	ConnType byte
+}
+
+// ServiceAdvertisers returns the reachable nodes advertising a service, nearest first.  This node is
+// included, at a cost of zero, if it advertises the service itself.  Nodes at the same cost are sorted by ID.
+func (s *Netceptor) ServiceAdvertisers(service string) []ServiceAdvertiser {
+	s.serviceAdsLock.RLock()
+	ads := make(map[string]byte)
+	for nodeID, nodeAds := range s.serviceAdsReceived {
+		if ad, ok := nodeAds[service]; ok {
+			ads[nodeID] = ad.ConnType
+		}
+	}
+	s.serviceAdsLock.RUnlock()
+	s.routingTableLock.RLock()
+	advertisers := make([]ServiceAdvertiser, 0, len(ads))
+	for nodeID, connType := range ads {
+		var cost float64
+		if nodeID != s.nodeID {
+			var ok bool
+			cost, ok = s.routingPathCosts[nodeID]
+			if !ok {
+				continue
+			}
+		}
+		advertisers = append(advertisers, ServiceAdvertiser{
+			NodeID:   nodeID,
+			PathCost: cost,
+			ConnType: connType,
+		})
+	}
+	s.routingTableLock.RUnlock()
+	sort.Slice(advertisers, func(i, j int) bool {
+		if advertisers[i].PathCost != advertisers[j].PathCost {
+			return advertisers[i].PathCost < advertisers[j].PathCost
+		}
+
+		return advertisers[i].NodeID < advertisers[j].NodeID
+	})
+
+	return advertisers
+}
+
+// DialAny connects to a stream service on whichever node advertising it is nearest.
+func (s *Netceptor) DialAny(service string, tlscfg *tls.Config) (*Conn, error) {
+	return s.DialAnyContext(context.Background(), service, tlscfg)
+}
+
+// DialAnyContext is like DialAny but uses a context to allow timeout or cancellation.  If the nearest
+// advertiser cannot be reached, the next nearest is tried, until one connects or none are left.  The node
+// that is reached is cached, and dialed first by later calls while it advertises the service and no nearer
+// node does, for up to five minutes, so that repeated dials keep reaching the same node among equally near
+// ones.  ClearServiceCache makes the next call resolve again.
+func (s *Netceptor) DialAnyContext(ctx context.Context, service string, tlscfg *tls.Config) (*Conn, error) {
+	return s.dialAny(ctx, service, func(nodeID string) (*Conn, error) {
+		return s.DialContext(ctx, nodeID, service, tlscfg)
+	})
+}
+
+// dialAny calls dial with the cached node for a service, then each node advertising it, nearest first,
+// until one connects.
+func (s *Netceptor) dialAny(ctx context.Context, service string, dial func(nodeID string) (*Conn, error)) (*Conn, error) {
+	nodes := make([]string, 0)
+	advertisers := s.ServiceAdvertisers(service)
+	cached, ok := s.cachedServiceNode(service, advertisers)
+	if ok {
+		nodes = append(nodes, cached)
+	}
+	for _, adv := range advertisers {
+		if adv.ConnType != ConnTypeDatagram && adv.NodeID != cached {
+			nodes = append(nodes, adv.NodeID)
+		}
+	}
+	var lastErr error
+	for _, nodeID := range nodes {
+		conn, err := dial(nodeID)
+		if err == nil {
+			s.cacheServiceNode(service, nodeID)
+
+			return conn, nil
+		}
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		s.uncacheServiceNode(service, nodeID)
+		lastErr = err
+	}
+	if lastErr != nil {
+		return nil, lastErr
+	}
+
+	return nil, fmt.Errorf("%w: %s", ErrNoAdvertisers, service)
+}
+
+// Broadcast sends a datagram to a service on every reachable node advertising it, and returns the
+// nodes it was sent to.  Delivery is not confirmed, as with any datagram.
+func (pc *PacketConn) Broadcast(p []byte, service string) ([]string, error) {
+	sent := make([]string, 0)
+	for _, adv := range pc.s.ServiceAdvertisers(service) {
+		if adv.ConnType != ConnTypeDatagram {
+			continue
+		}
+		if _, err := pc.WriteTo(p, pc.s.NewAddr(adv.NodeID, service)); err != nil {
+			return sent, fmt.Errorf("error sending to %s: %w", adv.NodeID, err)
+		}
+		sent = append(sent, adv.NodeID)
+	}
+	if len(sent) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrNoAdvertisers, service)
+	}
+
+	return sent, nil
+}
+
+// Broadcast sends a datagram to a service on every reachable node advertising it, from an ephemeral
+// service, and returns the nodes it was sent to.  Use PacketConn.Broadcast to receive replies.
+func (s *Netceptor) Broadcast(service string, data []byte) ([]string, error) {
+	pc, err := s.ListenPacket("")
+	if err != nil {
+		return nil, err
+	}
+	defer pc.Close()
+
+	return pc.Broadcast(data, service)
+}
@@ -0,0 +1,145 @@
+package netceptor
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"testing"
+	"time"
+)
+
+// anycastMesh builds a mesh where C reaches A directly at cost 1 and B via D at cost 2.
+func anycastMesh(ctx context.Context, t *testing.T) (*Netceptor, *Netceptor, *Netceptor) {
+	a := New(ctx, "A", nil)
+	b := New(ctx, "B", nil)
+	c := New(ctx, "C", nil)
+	d := New(ctx, "D", nil)
+	linkNodes(t, c, a, nil)
+	linkNodes(t, c, d, nil)
+	linkNodes(t, d, b, nil)
+	waitForPathCost(t, c, "A", 1.0)
+	waitForPathCost(t, c, "B", 2.0)
+
+	return a, b, c
+}
+
+func TestServiceAdvertisers(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	a, b, c := anycastMesh(ctx, t)
+	if _, err := c.Broadcast("anysvc", []byte("hello")); !errors.Is(err, ErrNoAdvertisers) {
+		t.Fatalf("expected ErrNoAdvertisers, got %v", err)
+	}
+	for _, n := range []*Netceptor{b, a} {
+		if _, err := n.ListenPacketAndAdvertise("anysvc", nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitForAdvertisement(t, c, "A", "anysvc")
+	waitForAdvertisement(t, c, "B", "anysvc")
+
+	// The nearer advertiser comes first, whatever order they advertised in
+	advertisers := c.ServiceAdvertisers("anysvc")
+	if len(advertisers) != 2 || advertisers[0].NodeID != "A" || advertisers[1].NodeID != "B" {
+		t.Fatalf("expected A then B, got %v", advertisers)
+	}
+	if advertisers[0].PathCost != 1.0 || advertisers[1].PathCost != 2.0 {
+		t.Fatalf("unexpected path costs %v", advertisers)
+	}
+
+	// A node advertising the service itself is the nearest of all
+	if _, err := c.ListenPacketAndAdvertise("anysvc", nil); err != nil {
+		t.Fatal(err)
+	}
+	advertisers = c.ServiceAdvertisers("anysvc")
+	if len(advertisers) != 3 || advertisers[0].NodeID != "C" || advertisers[0].PathCost != 0 {
+		t.Fatalf("expected C first, got %v", advertisers)
+	}
+}
+
+func TestBroadcast(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	a, b, c := anycastMesh(ctx, t)
+	received := make(chan string, 2)
+	for _, n := range []*Netceptor{a, b} {
+		pc, err := n.ListenPacketAndAdvertise("bcast", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		go func(n *Netceptor, pc *PacketConn) {
+			buf := make([]byte, 64)
+			_ = pc.SetReadDeadline(time.Now().Add(10 * time.Second))
+			count, _, err := pc.ReadFrom(buf)
+			if err == nil && string(buf[:count]) == "hello" {
+				received <- n.NodeID()
+			}
+		}(n, pc)
+	}
+	waitForAdvertisement(t, c, "A", "bcast")
+	waitForAdvertisement(t, c, "B", "bcast")
+
+	sent, err := c.Broadcast("bcast", []byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sent) != 2 || sent[0] != "A" || sent[1] != "B" {
+		t.Fatalf("expected to send to A and B, sent to %v", sent)
+	}
+	got := make([]string, 0, 2)
+	for len(got) < 2 {
+		select {
+		case nodeID := <-received:
+			got = append(got, nodeID)
+		case <-time.After(10 * time.Second):
+			t.Fatalf("timed out waiting for the broadcast, received by %v", got)
+		}
+	}
+	sort.Strings(got)
+	if got[0] != "A" || got[1] != "B" {
+		t.Fatalf("expected A and B to receive the broadcast, got %v", got)
+	}
+}
+
+func TestDialAny(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	a, b, c := anycastMesh(ctx, t)
+	if _, err := c.DialAny("anydial", nil); !errors.Is(err, ErrNoAdvertisers) {
+		t.Fatalf("expected ErrNoAdvertisers, got %v", err)
+	}
+	accepted := make(chan string, 2)
+	for _, n := range []*Netceptor{a, b} {
+		li, err := n.ListenAndAdvertise("anydial", nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		go func(n *Netceptor, li *Listener) {
+			conn, err := li.Accept()
+			if err == nil {
+				accepted <- n.NodeID()
+				_ = conn.Close()
+			}
+		}(n, li)
+	}
+	waitForAdvertisement(t, c, "A", "anydial")
+	waitForAdvertisement(t, c, "B", "anydial")
+
+	conn, err := c.DialAny("anydial", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// The listener only sees the connection once data is sent
+	if _, err := conn.Write([]byte("hello\n")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case nodeID := <-accepted:
+		if nodeID != "A" {
+			t.Fatalf("expected A to accept the connection, got %s", nodeID)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for the connection to be accepted")
+	}
+}
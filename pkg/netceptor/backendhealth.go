@@ -0,0 +1,464 @@
+package netceptor
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/ansible/receptor/pkg/logger"
+	"github.com/ansible/receptor/pkg/utils"
+	"github.com/ghjm/cmdline"
+)
+
+// Backend health states.
+const (
+	// BackendHealthy is the state of a stable backend.
+	BackendHealthy = "healthy"
+	// BackendDegraded is the state of a backend whose connections cost more, so routing avoids them.
+	BackendDegraded = "degraded"
+	// BackendDown is the state of a backend whose connections are not used for routing.
+	BackendDown = "down"
+)
+
+// costFactorDown is the cost factor advertised for the connections of a backend that is down.
+const costFactorDown = -1.0
+
+// BackendHealthConfig holds the thresholds used to score the health of backends.  A backend's score is
+// the number of times its sessions reconnected or failed, each counting for less as time passes.
+type BackendHealthConfig struct {
+	// HalfLife is how long it takes for a reconnect or error to count for half as much.
+	HalfLife time.Duration
+	// DegradedScore is the score at which the cost of a backend's connections is multiplied by CostFactor.
+	DegradedScore float64
+	// DownScore is the score at which a backend's connections are no longer used for routing.  Zero
+	// disables it.
+	DownScore float64
+	// CostFactor multiplies the cost of the connections of a degraded backend.
+	CostFactor float64
+	// ReconnectNotifyDowntime is the shortest outage after which a backend's reconnect is logged and sent to
+	// subscribers.  Zero reports every reconnect.
+	ReconnectNotifyDowntime time.Duration
+}
+
+// DefaultBackendHealthConfig is the backend health configuration of a new Netceptor instance.
+var DefaultBackendHealthConfig = BackendHealthConfig{
+	HalfLife:      5 * time.Minute,
+	DegradedScore: 3,
+	DownScore:     0,
+	CostFactor:    4,
+}
+
+// Validate returns an error if the thresholds are inconsistent.
+func (cfg BackendHealthConfig) Validate() error {
+	if cfg.HalfLife <= 0 {
+		return fmt.Errorf("backend health half-life must be positive")
+	}
+	if cfg.DegradedScore <= 0 {
+		return fmt.Errorf("backend degraded score must be positive")
+	}
+	if cfg.DownScore < 0 || (cfg.DownScore > 0 && cfg.DownScore <= cfg.DegradedScore) {
+		return fmt.Errorf("backend down score must be zero or above the degraded score")
+	}
+	if cfg.CostFactor < 1 {
+		return fmt.Errorf("backend cost factor must be at least 1")
+	}
+	if cfg.ReconnectNotifyDowntime < 0 {
+		return fmt.Errorf("backend reconnect notify downtime must not be negative")
+	}
+
+	return nil
+}
+
+// BackendHealthStatus describes the health of a backend.
+type BackendHealthStatus struct {
+	ID          int
+	Name        string
+	Connections []string
+	Sessions    uint64
+	Reconnects  uint64
+	Errors      uint64
+	Score       float64
+	State       string
+	CostFactor  float64
+	// Compression is whether each connection negotiated compression, for backends that report it.
+	Compression map[string]bool
+	// MessageSizes is the histogram of the sizes of messages sent and received on each connection.
+	MessageSizes map[string]SessionMessageSizes
+}
+
+// backendHealth tracks the stability of a backend.  Its fields are protected by the Netceptor's healthLock.
+type backendHealth struct {
+	id         int
+	name       string
+	sessions   uint64
+	reconnects uint64
+	errors     uint64
+	score      float64
+	scoredAt   time.Time
+	state      string
+	// live is the number of established sessions.  Once it falls to zero, downSince is when, and attempts
+	// counts the tries to connect again.
+	live      int
+	downSince time.Time
+	attempts  uint64
+	// lost is the remote nodes whose sessions with this backend ended, so that a new session with one of
+	// them counts as a reconnect.
+	lost map[string]bool
+}
+
+// backendName returns a description of a backend for status output.
+func backendName(backend Backend) string {
+	if s, ok := backend.(fmt.Stringer); ok {
+		return s.String()
+	}
+
+	return fmt.Sprintf("%T", backend)
+}
+
+// decay reduces the score by the time that has passed since it was last updated.
+func (bh *backendHealth) decay(now time.Time, cfg BackendHealthConfig) {
+	if !bh.scoredAt.IsZero() && now.After(bh.scoredAt) {
+		bh.score *= math.Pow(0.5, float64(now.Sub(bh.scoredAt))/float64(cfg.HalfLife))
+	}
+	bh.scoredAt = now
+}
+
+// addEvent adds a reconnect or error to the score, and returns true if the state changed.
+func (bh *backendHealth) addEvent(now time.Time, cfg BackendHealthConfig) bool {
+	bh.decay(now, cfg)
+	bh.score++
+
+	return bh.evaluate(cfg)
+}
+
+// evaluate updates the state from the score, and returns true if it changed.  A backend only recovers once
+// its score falls to half of the threshold it crossed, so that it does not keep switching between states.
+func (bh *backendHealth) evaluate(cfg BackendHealthConfig) bool {
+	state := BackendHealthy
+	switch {
+	case cfg.DownScore > 0 && (bh.score >= cfg.DownScore || (bh.state == BackendDown && bh.score >= cfg.DownScore/2)):
+		state = BackendDown
+	case bh.score >= cfg.DegradedScore || (bh.state != BackendHealthy && bh.score >= cfg.DegradedScore/2):
+		state = BackendDegraded
+	}
+	if state == bh.state {
+		return false
+	}
+	logger.Info("Backend %s is now %s (score %.2f)\n", bh.name, state, bh.score)
+	bh.state = state
+
+	return true
+}
+
+// costFactor returns the factor applied to the cost of the backend's connections.
+func (bh *backendHealth) costFactor(cfg BackendHealthConfig) float64 {
+	switch bh.state {
+	case BackendDegraded:
+		return cfg.CostFactor
+	case BackendDown:
+		return costFactorDown
+	default:
+		return 1.0
+	}
+}
+
+// newBackendHealth starts tracking the health of a backend.
+func (s *Netceptor) newBackendHealth(backend Backend) *backendHealth {
+	s.healthLock.Lock()
+	defer s.healthLock.Unlock()
+	s.lastBackendID++
+	bh := &backendHealth{
+		id:    s.lastBackendID,
+		name:  backendName(backend),
+		state: BackendHealthy,
+	}
+	s.backendHealth = append(s.backendHealth, bh)
+
+	return bh
+}
+
+// removeBackendHealth stops tracking the health of a backend that failed to start.
+func (s *Netceptor) removeBackendHealth(bh *backendHealth) {
+	s.healthLock.Lock()
+	defer s.healthLock.Unlock()
+	for i, other := range s.backendHealth {
+		if other == bh {
+			s.backendHealth = append(s.backendHealth[:i], s.backendHealth[i+1:]...)
+
+			return
+		}
+	}
+}
+
+// recordBackendSession records that a backend established a session with a remote node.  A session with a
+// node whose earlier session with the backend ended is a reconnect, while a listener's sessions with other
+// nodes are not.  It is reported if the backend had no other connections.
+func (s *Netceptor) recordBackendSession(bh *backendHealth, remoteNodeID string) {
+	s.healthLock.Lock()
+	now := s.now()
+	bh.sessions++
+	changed := false
+	if bh.lost[remoteNodeID] {
+		delete(bh.lost, remoteNodeID)
+		bh.reconnects++
+		changed = bh.addEvent(now, s.healthConfig)
+	}
+	ev := s.backendReconnected(bh, remoteNodeID, now)
+	s.healthLock.Unlock()
+	if ev != nil {
+		s.notifyReconnect(ev)
+	}
+	if changed {
+		s.costFactorsChanged()
+	}
+}
+
+// recordBackendError records that a session of a backend failed.
+func (s *Netceptor) recordBackendError(bh *backendHealth) {
+	s.healthLock.Lock()
+	bh.errors++
+	if !bh.downSince.IsZero() {
+		bh.attempts++
+	}
+	changed := bh.addEvent(s.now(), s.healthConfig)
+	s.healthLock.Unlock()
+	if changed {
+		s.costFactorsChanged()
+	}
+}
+
+// connectionCostFactors returns the cost factors of this node's connections whose backends are not healthy,
+// or nil if there are none.  The caller must hold connLock.
+func (s *Netceptor) connectionCostFactors() map[string]float64 {
+	var factors map[string]float64
+	s.healthLock.Lock()
+	defer s.healthLock.Unlock()
+	for remoteNodeID, ci := range s.connections {
+		if ci.health == nil {
+			continue
+		}
+		if factor := ci.health.costFactor(s.healthConfig); factor != 1.0 {
+			if factors == nil {
+				factors = make(map[string]float64)
+			}
+			factors[remoteNodeID] = factor
+		}
+	}
+
+	return factors
+}
+
+// applyCostFactors updates the cost factors of this node's connections from the health of their backends,
+// and returns true if any of them changed.
+func (s *Netceptor) applyCostFactors() bool {
+	s.connLock.RLock()
+	factors := s.connectionCostFactors()
+	s.connLock.RUnlock()
+	s.knownNodeLock.Lock()
+	defer s.knownNodeLock.Unlock()
+	if len(factors) == 0 && len(s.knownCostFactors[s.nodeID]) == 0 {
+		return false
+	}
+	if reflect.DeepEqual(factors, s.knownCostFactors[s.nodeID]) {
+		return false
+	}
+	if len(factors) == 0 {
+		delete(s.knownCostFactors, s.nodeID)
+	} else {
+		s.knownCostFactors[s.nodeID] = factors
+	}
+
+	return true
+}
+
+// costFactorsChanged recalculates routes and tells the rest of the mesh after a backend changed state.
+func (s *Netceptor) costFactorsChanged() {
+	if !s.applyCostFactors() {
+		return
+	}
+	select {
+	case s.updateRoutingTableChan <- 0:
+	case <-s.context.Done():
+		return
+	}
+	select {
+	case s.sendRouteFloodChan <- 0:
+	case <-s.context.Done():
+	}
+}
+
+// knownCostFactor returns the factor applied to the cost of the connection between two nodes, as advertised
+// by either of them, or costFactorDown if either considers it down.  The caller must hold knownNodeLock.
+func (s *Netceptor) knownCostFactor(node string, neighbor string) float64 {
+	factor := 1.0
+	for _, f := range []float64{s.knownCostFactors[node][neighbor], s.knownCostFactors[neighbor][node]} {
+		if f < 0 {
+			return costFactorDown
+		}
+		if f > factor {
+			factor = f
+		}
+	}
+
+	return factor
+}
+
+// monitorBackendHealth lets the scores of backends recover over time.
+func (s *Netceptor) monitorBackendHealth() {
+	for {
+		select {
+		case <-time.After(time.Second):
+			s.healthLock.Lock()
+			changed := false
+			now := s.now()
+			for _, bh := range s.backendHealth {
+				bh.decay(now, s.healthConfig)
+				if bh.evaluate(s.healthConfig) {
+					changed = true
+				}
+			}
+			s.healthLock.Unlock()
+			if changed {
+				s.costFactorsChanged()
+			}
+		case <-s.context.Done():
+			return
+		}
+	}
+}
+
+// SetBackendHealthConfig changes the thresholds used to score the health of backends.
+func (s *Netceptor) SetBackendHealthConfig(cfg BackendHealthConfig) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	s.healthLock.Lock()
+	s.healthConfig = cfg
+	changed := false
+	for _, bh := range s.backendHealth {
+		if bh.evaluate(cfg) {
+			changed = true
+		}
+	}
+	s.healthLock.Unlock()
+	if changed {
+		s.costFactorsChanged()
+	}
+
+	return nil
+}
+
+// BackendHealth returns the health of each backend.
+func (s *Netceptor) BackendHealth() []BackendHealthStatus {
+	s.connLock.RLock()
+	defer s.connLock.RUnlock()
+	s.healthLock.Lock()
+	defer s.healthLock.Unlock()
+	now := s.now()
+	statuses := make([]BackendHealthStatus, 0, len(s.backendHealth))
+	for _, bh := range s.backendHealth {
+		bh.decay(now, s.healthConfig)
+		conns := make([]string, 0)
+		var compression map[string]bool
+		var messageSizes map[string]SessionMessageSizes
+		for remoteNodeID, ci := range s.connections {
+			if ci.health != bh {
+				continue
+			}
+			conns = append(conns, remoteNodeID)
+			if ci.sentSizes != nil {
+				if messageSizes == nil {
+					messageSizes = make(map[string]SessionMessageSizes)
+				}
+				messageSizes[remoteNodeID] = SessionMessageSizes{
+					Sent:     ci.sentSizes.snapshot(),
+					Received: ci.receivedSizes.snapshot(),
+				}
+			}
+			if cr, ok := ci.session.(CompressionReporter); ok {
+				if compression == nil {
+					compression = make(map[string]bool)
+				}
+				compression[remoteNodeID] = cr.CompressionNegotiated()
+			}
+		}
+		sort.Strings(conns)
+		factor := bh.costFactor(s.healthConfig)
+		if factor < 0 {
+			factor = 0
+		}
+		statuses = append(statuses, BackendHealthStatus{
+			ID:           bh.id,
+			Name:         bh.name,
+			Connections:  conns,
+			Sessions:     bh.sessions,
+			Reconnects:   bh.reconnects,
+			Errors:       bh.errors,
+			Score:        math.Round(bh.score*100) / 100,
+			State:        bh.state,
+			CostFactor:   factor,
+			Compression:  compression,
+			MessageSizes: messageSizes,
+		})
+	}
+
+	return statuses
+}
+
+// **************************************************************************
+// Command line
+// **************************************************************************
+
+// backendHealthCfg is the cmdline configuration object for backend health scoring.
+type backendHealthCfg struct {
+	HalfLife        string  `description:"How long it takes for a reconnect or error to count for half as much in a backend's score" default:"5m"`
+	DegradedScore   float64 `description:"Score at which the cost of a backend's connections is multiplied by costfactor" default:"3"`
+	DownScore       float64 `description:"Score at which a backend's connections are no longer used for routing (0 to disable)" default:"0"`
+	CostFactor      float64 `description:"Factor applied to the cost of the connections of a degraded backend" default:"4"`
+	ReconnectNotify string  `description:"Shortest outage after which a backend reconnect is logged and sent to control service events (0 for all)" default:"0s"`
+}
+
+func (cfg backendHealthCfg) config() (BackendHealthConfig, error) {
+	halfLife, err := time.ParseDuration(cfg.HalfLife)
+	if err != nil {
+		return BackendHealthConfig{}, err
+	}
+	reconnectNotify, err := time.ParseDuration(cfg.ReconnectNotify)
+	if err != nil {
+		return BackendHealthConfig{}, err
+	}
+	hc := BackendHealthConfig{
+		HalfLife:                halfLife,
+		DegradedScore:           cfg.DegradedScore,
+		DownScore:               cfg.DownScore,
+		CostFactor:              cfg.CostFactor,
+		ReconnectNotifyDowntime: reconnectNotify,
+	}
+
+	return hc, hc.Validate()
+}
+
+// Prepare verifies the parameters are correct.
+func (cfg backendHealthCfg) Prepare() error {
+	_, err := cfg.config()
+
+	return err
+}
+
+// Run runs the action.
+func (cfg backendHealthCfg) Run() error {
+	utils.RecordEffectiveConfig("backend-health", cfg)
+	hc, err := cfg.config()
+	if err != nil {
+		return err
+	}
+
+	return MainInstance.SetBackendHealthConfig(hc)
+}
+
+func init() {
+	cmdline.RegisterConfigTypeForApp("receptor-backends",
+		"backend-health", "Thresholds for deprioritizing unstable backends", backendHealthCfg{}, cmdline.Singleton)
+}
@@ -0,0 +1,197 @@
+package netceptor
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBackendHealthScore(t *testing.T) {
+	cfg := BackendHealthConfig{HalfLife: time.Minute, DegradedScore: 3, DownScore: 6, CostFactor: 4}
+	bh := &backendHealth{name: "test", state: BackendHealthy}
+	now := time.Now()
+	for i := 0; i < 3; i++ {
+		bh.addEvent(now, cfg)
+	}
+	if bh.state != BackendDegraded || bh.costFactor(cfg) != 4 {
+		t.Fatalf("expected the backend to be degraded after 3 events, got %s", bh.state)
+	}
+	for i := 0; i < 3; i++ {
+		bh.addEvent(now, cfg)
+	}
+	if bh.state != BackendDown || bh.costFactor(cfg) != costFactorDown {
+		t.Fatalf("expected the backend to be down after 6 events, got %s", bh.state)
+	}
+
+	// One half-life later the score is 3, which keeps a down backend down
+	now = now.Add(time.Minute)
+	bh.decay(now, cfg)
+	bh.evaluate(cfg)
+	if bh.state != BackendDown {
+		t.Fatalf("expected the backend to stay down at score %.2f, got %s", bh.score, bh.state)
+	}
+	now = now.Add(30 * time.Second)
+	bh.decay(now, cfg)
+	bh.evaluate(cfg)
+	if bh.state != BackendDegraded {
+		t.Fatalf("expected the backend to be degraded at score %.2f, got %s", bh.score, bh.state)
+	}
+	now = now.Add(2 * time.Minute)
+	bh.decay(now, cfg)
+	bh.evaluate(cfg)
+	if bh.state != BackendHealthy || bh.costFactor(cfg) != 1 {
+		t.Fatalf("expected the backend to recover at score %.2f, got %s", bh.score, bh.state)
+	}
+}
+
+func TestBackendHealthConfigValidate(t *testing.T) {
+	if err := DefaultBackendHealthConfig.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	for _, cfg := range []BackendHealthConfig{
+		{HalfLife: 0, DegradedScore: 3, CostFactor: 4},
+		{HalfLife: time.Minute, DegradedScore: 0, CostFactor: 4},
+		{HalfLife: time.Minute, DegradedScore: 3, DownScore: 2, CostFactor: 4},
+		{HalfLife: time.Minute, DegradedScore: 3, CostFactor: 0.5},
+		{HalfLife: time.Minute, DegradedScore: 3, CostFactor: 4, ReconnectNotifyDowntime: -time.Second},
+	} {
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("expected %+v to be invalid", cfg)
+		}
+	}
+}
+
+// flapBackend is a backend whose sessions are supplied by the test, so it can reconnect on demand.
+type flapBackend struct {
+	sessChan chan BackendSession
+}
+
+func (fb *flapBackend) Start(ctx context.Context, wg *sync.WaitGroup) (chan BackendSession, error) {
+	return fb.sessChan, nil
+}
+
+func waitForPathCost(t *testing.T, s *Netceptor, node string, expected float64) {
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		cost, err := s.PathCost(node)
+		if err == nil && cost == expected {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s's path cost to %s to be %.1f, got %.1f (%v)",
+				s.NodeID(), node, expected, cost, err)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestBackendHealthFlapping(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	a := New(ctx, "A", nil)
+	b := New(ctx, "B", nil)
+	c := New(ctx, "C", nil)
+	cfg := BackendHealthConfig{HalfLife: time.Second, DegradedScore: 2, CostFactor: 5}
+	for _, n := range []*Netceptor{a, b, c} {
+		if err := n.SetBackendHealthConfig(cfg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fbA := &flapBackend{sessChan: make(chan BackendSession)}
+	fbB := &flapBackend{sessChan: make(chan BackendSession)}
+	if err := a.AddBackend(fbA, 1.0, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.AddBackend(fbB, 1.0, nil); err != nil {
+		t.Fatal(err)
+	}
+	linkNodes(t, b, c, nil)
+
+	var sess *pipeSession
+	connect := func(sessions uint64) {
+		sa, sb := newPipeSessions()
+		fbA.sessChan <- sa
+		fbB.sessChan <- sb
+		sess = sa
+		deadline := time.Now().Add(10 * time.Second)
+		for a.BackendHealth()[0].Sessions < sessions || len(a.BackendHealth()[0].Connections) == 0 {
+			if time.Now().After(deadline) {
+				t.Fatal("timed out waiting for the session to be established")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	connect(1)
+	waitForPathCost(t, c, "A", 2)
+
+	// The A-B link drops and comes back repeatedly
+	for i := uint64(2); i <= 4; i++ {
+		_ = sess.Close()
+		for len(a.BackendHealth()[0].Connections) > 0 || len(b.BackendHealth()[0].Connections) > 1 {
+			time.Sleep(10 * time.Millisecond)
+		}
+		connect(i)
+	}
+	health := a.BackendHealth()[0]
+	if health.Reconnects != 3 || health.State != BackendDegraded || health.CostFactor != 5 {
+		t.Fatalf("expected the flapping backend to be degraded, got %+v", health)
+	}
+	waitForPathCost(t, a, "B", 5)
+	// C learns the inflated cost from the routing updates of A and B
+	waitForPathCost(t, c, "A", 6)
+
+	// After a while without reconnects, the link is trusted again
+	waitForPathCost(t, a, "B", 1)
+	waitForPathCost(t, c, "A", 2)
+	if health := a.BackendHealth()[0]; health.State != BackendHealthy || health.Reconnects != 3 {
+		t.Fatalf("expected the backend to recover, got %+v", health)
+	}
+}
+
+func TestBackendHealthListenerPeers(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	a := New(ctx, "A", nil)
+	b := New(ctx, "B", nil)
+	c := New(ctx, "C", nil)
+	// A's backend is a listener that both B and C connect to
+	fbA := &flapBackend{sessChan: make(chan BackendSession)}
+	fbB := &flapBackend{sessChan: make(chan BackendSession)}
+	fbC := &flapBackend{sessChan: make(chan BackendSession)}
+	for n, fb := range map[*Netceptor]*flapBackend{a: fbA, b: fbB, c: fbC} {
+		if err := n.AddBackend(fb, 1.0, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	connect := func(peer *flapBackend, sessions uint64, conns int) *pipeSession {
+		sa, sp := newPipeSessions()
+		fbA.sessChan <- sa
+		peer.sessChan <- sp
+		deadline := time.Now().Add(10 * time.Second)
+		for a.BackendHealth()[0].Sessions < sessions || len(a.BackendHealth()[0].Connections) < conns {
+			if time.Now().After(deadline) {
+				t.Fatal("timed out waiting for the session to be established")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		return sa
+	}
+	sessB := connect(fbB, 1, 1)
+	connect(fbC, 2, 2)
+	if health := a.BackendHealth()[0]; health.Reconnects != 0 {
+		t.Fatalf("expected sessions with different nodes not to be reconnects, got %+v", health)
+	}
+
+	// B drops and comes back, which is a reconnect
+	_ = sessB.Close()
+	for len(a.BackendHealth()[0].Connections) > 1 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	connect(fbB, 3, 2)
+	if health := a.BackendHealth()[0]; health.Reconnects != 1 {
+		t.Fatalf("expected B coming back to be one reconnect, got %+v", health)
+	}
+}
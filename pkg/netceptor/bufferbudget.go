@@ -0,0 +1,104 @@
+package netceptor
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultSessionBufferLimit is the default limit on the bytes of session data buffered by backends.
+const DefaultSessionBufferLimit = 64 * 1024 * 1024
+
+// ErrSessionBufferFull is returned by backends that close a session because the session buffer budget is
+// used up.
+var ErrSessionBufferFull = errors.New("session buffer limit reached")
+
+// BufferBudget limits the total bytes of data that backend sessions hold in memory, such as messages
+// waiting to be acknowledged or to be received.  Reserving space never blocks, since a backend waiting for
+// room would hold back every other session that shares its connection.  When the budget is used up, the
+// session that wants more is closed instead, and netceptor connects it again once the backend redials.
+type BufferBudget struct {
+	lock    sync.Mutex
+	limit   int64
+	used    int64
+	refused uint64
+}
+
+// BufferBudgetStatus is the usage of a buffer budget.
+type BufferBudgetStatus struct {
+	// Used is the number of bytes currently buffered.
+	Used int64
+	// Limit is the most bytes that may be buffered, or 0 for no limit.
+	Limit int64
+	// Refused is how many times a session was closed because there was no room for its data.
+	Refused uint64
+}
+
+// SessionBufferBudget is the budget shared by the buffers of all backend sessions in the process.
+var SessionBufferBudget = NewBufferBudget(DefaultSessionBufferLimit)
+
+// NewBufferBudget returns a budget of limit bytes, or no limit if it is 0.
+func NewBufferBudget(limit int64) *BufferBudget {
+	return &BufferBudget{
+		limit: limit,
+	}
+}
+
+// SetLimit changes the most bytes that may be buffered, or removes the limit if it is 0.  Lowering the
+// limit below what is already buffered does not discard anything, but refuses new data until usage falls
+// below the new limit.
+func (bb *BufferBudget) SetLimit(limit int64) error {
+	if limit < 0 {
+		return fmt.Errorf("session buffer limit must not be negative")
+	}
+	bb.lock.Lock()
+	defer bb.lock.Unlock()
+	bb.limit = limit
+
+	return nil
+}
+
+// TryAcquire reserves n bytes of the budget, and returns false without reserving anything if there is no
+// room.  A single reservation larger than the whole budget is allowed once nothing else is buffered, so
+// that a large message can always get through eventually.
+func (bb *BufferBudget) TryAcquire(n int64) bool {
+	bb.lock.Lock()
+	defer bb.lock.Unlock()
+	if bb.limit > 0 && bb.used > 0 && bb.used+n > bb.limit {
+		atomic.AddUint64(&bb.refused, 1)
+
+		return false
+	}
+	atomic.AddInt64(&bb.used, n)
+
+	return true
+}
+
+// Release returns n bytes to the budget.
+func (bb *BufferBudget) Release(n int64) {
+	if n == 0 {
+		return
+	}
+	bb.lock.Lock()
+	defer bb.lock.Unlock()
+	atomic.AddInt64(&bb.used, -n)
+}
+
+// Used returns the number of bytes currently buffered.
+func (bb *BufferBudget) Used() int64 {
+	return atomic.LoadInt64(&bb.used)
+}
+
+// Status returns the usage of the budget.
+func (bb *BufferBudget) Status() BufferBudgetStatus {
+	bb.lock.Lock()
+	limit := bb.limit
+	bb.lock.Unlock()
+
+	return BufferBudgetStatus{
+		Used:    bb.Used(),
+		Limit:   limit,
+		Refused: atomic.LoadUint64(&bb.refused),
+	}
+}
@@ -0,0 +1,51 @@
+package netceptor
+
+import (
+	"testing"
+)
+
+func TestBufferBudget(t *testing.T) {
+	bb := NewBufferBudget(100)
+	if err := bb.SetLimit(-1); err == nil {
+		t.Error("expected a negative limit to be refused")
+	}
+	if !bb.TryAcquire(60) {
+		t.Fatal("expected room for 60 bytes")
+	}
+
+	// A reservation that does not fit is refused without blocking, until enough is released
+	if bb.TryAcquire(60) {
+		t.Fatal("expected a reservation over the limit to be refused")
+	}
+	bb.Release(60)
+	if !bb.TryAcquire(60) {
+		t.Fatal("expected room for 60 bytes after release")
+	}
+	if bb.TryAcquire(41) {
+		t.Fatal("expected a reservation over the limit to be refused")
+	}
+	status := bb.Status()
+	if status.Used != 60 || status.Limit != 100 || status.Refused != 2 {
+		t.Errorf("expected 60 of 100 bytes used after 2 refusals, got %+v", status)
+	}
+
+	// A reservation larger than the whole budget is allowed once nothing else is buffered
+	bb.Release(60)
+	if !bb.TryAcquire(500) {
+		t.Fatal("expected a large reservation to be allowed with nothing buffered")
+	}
+	bb.Release(500)
+
+	// With no limit, nothing is refused
+	if err := bb.SetLimit(0); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		if !bb.TryAcquire(60) {
+			t.Fatal("expected no limit")
+		}
+	}
+	if used := bb.Used(); used != 600 {
+		t.Errorf("expected 600 bytes used, got %d", used)
+	}
+}
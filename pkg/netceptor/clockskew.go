@@ -0,0 +1,131 @@
+package netceptor
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ansible/receptor/pkg/logger"
+)
+
+// DefaultClockSkewThreshold is how far a neighbor's clock may differ from ours before it is reported.
+const DefaultClockSkewThreshold = 5 * time.Second
+
+// timeEcho returns the timestamp of a neighbor's last routing update to it, along with the time we
+// received it.  This lets the neighbor compute the round trip time and clock offset, in the manner of NTP.
+type timeEcho struct {
+	Sent     time.Time
+	Received time.Time
+}
+
+// clockSkewInfo tracks the measured clock offset of a directly connected neighbor.
+type clockSkewInfo struct {
+	lock         sync.Mutex
+	lastSent     time.Time
+	lastReceived time.Time
+	offset       time.Duration
+	rtt          time.Duration
+	exceeded     bool
+}
+
+// computeClockSkew returns the offset of the remote clock from the local clock, and the network round
+// trip time, given the local send time t1, remote receive time t2, remote send time t3 and local receive time t4.
+func computeClockSkew(t1, t2, t3, t4 time.Time) (time.Duration, time.Duration) {
+	offset := (t2.Sub(t1) + t3.Sub(t4)) / 2
+	rtt := t4.Sub(t1) - t3.Sub(t2)
+	if rtt < 0 {
+		rtt = 0
+	}
+
+	return offset, rtt
+}
+
+// clockSkewExceeds reports whether an offset is beyond the threshold even after allowing for the
+// measurement error, which is at most half the round trip time.
+func clockSkewExceeds(offset time.Duration, rtt time.Duration, threshold time.Duration) bool {
+	if threshold <= 0 {
+		return false
+	}
+	if offset < 0 {
+		offset = -offset
+	}
+
+	return offset-rtt/2 > threshold
+}
+
+// SetClockSkewThreshold sets how far a neighbor's clock may differ from ours before a warning is
+// logged and the skew is shown in the status.  Zero disables clock skew reporting.
+func (s *Netceptor) SetClockSkewThreshold(threshold time.Duration) error {
+	if threshold < 0 {
+		return fmt.Errorf("clock skew threshold must not be negative")
+	}
+	s.connLock.Lock()
+	defer s.connLock.Unlock()
+	s.clockSkewThreshold = threshold
+
+	return nil
+}
+
+// makeTimeEchoes returns the timestamps to echo back to each of our neighbors.
+func (s *Netceptor) makeTimeEchoes() map[string]timeEcho {
+	echoes := make(map[string]timeEcho)
+	s.connLock.RLock()
+	defer s.connLock.RUnlock()
+	for conn, ci := range s.connections {
+		ci.clockSkew.lock.Lock()
+		if !ci.clockSkew.lastSent.IsZero() {
+			echoes[conn] = timeEcho{
+				Sent:     ci.clockSkew.lastSent,
+				Received: ci.clockSkew.lastReceived,
+			}
+		}
+		ci.clockSkew.lock.Unlock()
+	}
+
+	return echoes
+}
+
+// handleClockSkew updates the measured clock skew of a neighbor from a routing update it sent us directly.
+func (s *Netceptor) handleClockSkew(ci *connInfo, remoteNodeID string, ri *routingUpdate) {
+	if ri.Timestamp.IsZero() {
+		// Older nodes do not send timestamps
+		return
+	}
+	now := s.now()
+	s.connLock.RLock()
+	threshold := s.clockSkewThreshold
+	s.connLock.RUnlock()
+	ci.clockSkew.lock.Lock()
+	defer ci.clockSkew.lock.Unlock()
+	ci.clockSkew.lastSent = ri.Timestamp
+	ci.clockSkew.lastReceived = now
+	echo, ok := ri.TimeEcho[s.nodeID]
+	if !ok || echo.Sent.IsZero() {
+		return
+	}
+	offset, rtt := computeClockSkew(echo.Sent, echo.Received, ri.Timestamp, now)
+	ci.clockSkew.offset = offset
+	ci.clockSkew.rtt = rtt
+	exceeded := clockSkewExceeds(offset, rtt, threshold)
+	if exceeded && !ci.clockSkew.exceeded {
+		logger.Warning("Clock on node %s differs from ours by %s (round trip time %s)\n", remoteNodeID, offset, rtt)
+	} else if !exceeded && ci.clockSkew.exceeded {
+		logger.Info("Clock on node %s is back in sync with ours\n", remoteNodeID)
+	}
+	ci.clockSkew.exceeded = exceeded
+}
+
+// clockSkewStatus returns the clock offset in seconds of each neighbor whose skew exceeds the threshold.
+// Must be called with connLock held.
+func (s *Netceptor) clockSkewStatus() map[string]float64 {
+	skews := make(map[string]float64)
+	for conn, ci := range s.connections {
+		ci.clockSkew.lock.Lock()
+		if ci.clockSkew.exceeded {
+			skews[conn] = ci.clockSkew.offset.Seconds()
+		}
+		ci.clockSkew.lock.Unlock()
+	}
+
+	return skews
+}
@@ -0,0 +1,104 @@
+package netceptor
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestComputeClockSkew(t *testing.T) {
+	t1 := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	// Remote clock is 10s ahead, one-way latency is 50ms and the remote holds the update for 2s
+	t2 := t1.Add(10*time.Second + 50*time.Millisecond)
+	t3 := t2.Add(2 * time.Second)
+	t4 := t1.Add(2*time.Second + 100*time.Millisecond)
+	offset, rtt := computeClockSkew(t1, t2, t3, t4)
+	if offset != 10*time.Second {
+		t.Fatalf("expected offset of 10s, got %s", offset)
+	}
+	if rtt != 100*time.Millisecond {
+		t.Fatalf("expected round trip time of 100ms, got %s", rtt)
+	}
+}
+
+func TestClockSkewExceeds(t *testing.T) {
+	if !clockSkewExceeds(-10*time.Second, 0, 5*time.Second) {
+		t.Fatal("negative offset beyond threshold was not detected")
+	}
+	if clockSkewExceeds(6*time.Second, 4*time.Second, 5*time.Second) {
+		t.Fatal("offset within measurement error was reported")
+	}
+	if clockSkewExceeds(time.Hour, 0, 0) {
+		t.Fatal("offset was reported with the check disabled")
+	}
+}
+
+// exchangeTimestamps simulates two directly connected nodes, with node2's clock running offset ahead
+// of node1's, exchanging routing updates over a link with the given one-way latency.  node1 reports
+// skew beyond threshold.
+func exchangeTimestamps(ctx context.Context, offset time.Duration, latency time.Duration,
+	threshold time.Duration) *Netceptor {
+	clock := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	n1 := New(ctx, "node1", nil)
+	_ = n1.SetClockSkewThreshold(threshold)
+	n1.now = func() time.Time { return clock }
+	n2 := New(ctx, "node2", nil)
+	n2.now = func() time.Time { return clock.Add(offset) }
+	ci1 := &connInfo{Cost: 1.0}
+	ci2 := &connInfo{Cost: 1.0}
+	n1.connLock.Lock()
+	n1.connections["node2"] = ci1
+	n1.connLock.Unlock()
+	n2.connLock.Lock()
+	n2.connections["node1"] = ci2
+	n2.connLock.Unlock()
+	for i := 0; i < 2; i++ {
+		update := n1.makeRoutingUpdate(0)
+		clock = clock.Add(latency)
+		n2.handleClockSkew(ci2, "node1", update)
+		clock = clock.Add(time.Second)
+		update = n2.makeRoutingUpdate(0)
+		clock = clock.Add(latency)
+		n1.handleClockSkew(ci1, "node2", update)
+		clock = clock.Add(time.Second)
+	}
+
+	return n1
+}
+
+func TestClockSkewDetected(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	n1 := exchangeTimestamps(ctx, 30*time.Second, 200*time.Millisecond, DefaultClockSkewThreshold)
+	defer n1.Shutdown()
+	skew, ok := n1.Status().ClockSkew["node2"]
+	if !ok {
+		t.Fatal("clock skew was not reported")
+	}
+	if skew != 30 {
+		t.Fatalf("expected clock skew of 30s, got %fs", skew)
+	}
+}
+
+func TestClockSkewBelowThreshold(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	n1 := exchangeTimestamps(ctx, 2*time.Second, 3*time.Second, DefaultClockSkewThreshold)
+	defer n1.Shutdown()
+	if _, ok := n1.Status().ClockSkew["node2"]; ok {
+		t.Fatal("clock skew below threshold was reported")
+	}
+}
+
+func TestSetClockSkewThreshold(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	n1 := exchangeTimestamps(ctx, 30*time.Second, 200*time.Millisecond, time.Minute)
+	defer n1.Shutdown()
+	if _, ok := n1.Status().ClockSkew["node2"]; ok {
+		t.Fatal("clock skew below a raised threshold was reported")
+	}
+	if err := n1.SetClockSkewThreshold(-time.Second); err == nil {
+		t.Fatal("expected a negative threshold to be refused")
+	}
+}
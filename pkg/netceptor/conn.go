@@ -16,6 +16,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/ansible/receptor/pkg/logger"
 	"github.com/ansible/receptor/pkg/utils"
 	"github.com/lucas-clemente/quic-go"
 )
@@ -37,7 +38,7 @@ type Listener struct {
 
 // Internal implementation of Listen and ListenAndAdvertise.
 func (s *Netceptor) listen(ctx context.Context, service string, tlscfg *tls.Config, advertise bool, adTags map[string]string) (*Listener, error) {
-	if len(service) > 8 {
+	if len(service) > MaxServiceNameLength {
 		return nil, fmt.Errorf("service name %s too long", service)
 	}
 	if service == "" {
@@ -48,7 +49,7 @@ func (s *Netceptor) listen(ctx context.Context, service string, tlscfg *tls.Conf
 	_, isReserved := s.reservedServices[service]
 	_, isListening := s.listenerRegistry[service]
 	if isReserved || isListening {
-		return nil, fmt.Errorf("service %s is already listening", service)
+		return nil, fmt.Errorf("service %s is %w", service, ErrAlreadyListening)
 	}
 	_ = s.addNameHash(service)
 	var connType byte
@@ -76,6 +77,7 @@ func (s *Netceptor) listen(ctx context.Context, service string, tlscfg *tls.Conf
 		adTags:       adTags,
 		connType:     connType,
 		hopsToLive:   s.maxForwardingHops,
+		connLimiter:  newConnLimiter(),
 	}
 	pc.startUnreachable()
 	s.listenerRegistry[service] = pc
@@ -83,7 +85,7 @@ func (s *Netceptor) listen(ctx context.Context, service string, tlscfg *tls.Conf
 	if err != nil {
 		return nil, err
 	}
-	if advertise {
+	if advertise && !s.holdAdvertisement(pc) {
 		s.addLocalServiceAdvertisement(service, connType, adTags)
 	}
 	doneChan := make(chan struct{})
@@ -133,6 +135,33 @@ func (s *Netceptor) ListenContextAndAdvertise(ctx context.Context, service strin
 	return s.listen(ctx, service, tlscfg, true, tags)
 }
 
+// SetConnectionLimit limits the number of connections accepted by the listener that may be open at once.
+// Connections beyond the limit wait until another connection closes if queue is true, and are rejected
+// otherwise, which the dialer sees as ErrConnectionLimit.  A limit of zero or less removes the limit.
+func (li *Listener) SetConnectionLimit(limit int, queue bool) {
+	li.pc.connLimiter.setLimit(limit, queue)
+}
+
+// SetTrafficClass sets the traffic class of the data sent on connections accepted by the listener.
+func (li *Listener) SetTrafficClass(class byte) {
+	li.pc.SetTrafficClass(class)
+}
+
+// rejectConnection tells the dialer of a connection that the listener is at its connection limit, and closes it.
+func (li *Listener) rejectConnection(qc quic.Session, rAddr Addr) {
+	logger.Warning("Rejecting connection from %s to service %s: %s\n", rAddr.node, li.pc.localService, ProblemConnectionLimit)
+	_ = li.s.sendUnreachable(rAddr.node, &UnreachableMessage{
+		FromNode:    rAddr.node,
+		ToNode:      li.s.nodeID,
+		FromService: rAddr.service,
+		ToService:   li.pc.localService,
+		Problem:     ProblemConnectionLimit,
+	})
+	// Give the notification a head start, so the dialer knows why the connection closed
+	time.Sleep(time.Second)
+	_ = qc.CloseWithError(503, ProblemConnectionLimit)
+}
+
 func (li *Listener) sendResult(conn net.Conn, err error) {
 	select {
 	case li.acceptChan <- &acceptResult{
@@ -196,6 +225,17 @@ func (li *Listener) acceptLoop() {
 
 				return
 			}
+			rAddr, ok := qc.RemoteAddr().(Addr)
+			err = li.pc.connLimiter.acquire(li.doneChan)
+			if err == ErrConnectionLimit && ok {
+				li.rejectConnection(qc, rAddr)
+
+				return
+			} else if err != nil {
+				_ = qc.CloseWithError(500, err.Error())
+
+				return
+			}
 			doneChan := make(chan struct{}, 1)
 			cctx, ccancel := utils.ContextWithCancelWithErr(li.s.context)
 			conn := &Conn{
@@ -206,9 +246,10 @@ func (li *Listener) acceptLoop() {
 				doneChan: doneChan,
 				doneOnce: &sync.Once{},
 				ctx:      cctx,
+				release:  li.pc.connLimiter.release,
 			}
-			rAddr, ok := conn.RemoteAddr().(Addr)
 			if ok {
+				li.s.countConnection(rAddr.node, li.pc.localService)
 				go monitorUnreachable(li.pc, doneChan, rAddr, ccancel)
 			}
 			go func() {
@@ -217,6 +258,8 @@ func (li *Listener) acceptLoop() {
 					_ = conn.Close()
 				case <-cctx.Done():
 					_ = conn.Close()
+				case <-qc.Context().Done():
+					_ = conn.Close()
 				case <-doneChan:
 					return
 				}
@@ -263,6 +306,7 @@ type Conn struct {
 	doneChan chan struct{}
 	doneOnce *sync.Once
 	ctx      context.Context
+	release  func()
 }
 
 // Dial returns a stream connection compatible with Go's net.Conn.
@@ -308,7 +352,14 @@ func (s *Netceptor) DialContext(ctx context.Context, node string, service string
 		}
 	}()
 	doneChan := make(chan struct{}, 1)
-	go monitorUnreachable(pc, doneChan, rAddr, ccancel)
+	rejectChan := make(chan struct{})
+	rejectOnce := sync.Once{}
+	go monitorUnreachable(pc, doneChan, rAddr, func(err error) {
+		ccancel(err)
+		rejectOnce.Do(func() {
+			close(rejectChan)
+		})
+	})
 	qc, err := quic.DialContext(cctx, pc, rAddr, s.nodeID, tlscfg, cfg)
 	if err != nil {
 		close(okChan)
@@ -343,6 +394,7 @@ func (s *Netceptor) DialContext(ctx context.Context, node string, service string
 		return nil, err
 	}
 	close(okChan)
+	s.countConnection(node, service)
 	go func() {
 		select {
 		case <-qc.Context().Done():
@@ -351,6 +403,9 @@ func (s *Netceptor) DialContext(ctx context.Context, node string, service string
 		case <-s.context.Done():
 			_ = qs.Close()
 			_ = pc.Close()
+		case <-rejectChan:
+			_ = qc.CloseWithError(500, cctx.Err().Error())
+			_ = pc.Close()
 		case <-doneChan:
 			return
 		}
@@ -379,16 +434,37 @@ func monitorUnreachable(pc *PacketConn, doneChan chan struct{}, remoteAddr Addr,
 		case <-doneChan:
 			return
 		case msg := <-msgCh:
-			if msg.Problem == ProblemServiceUnknown && msg.ToNode == remoteAddr.node && msg.ToService == remoteAddr.service {
+			if msg.ToNode != remoteAddr.node || msg.ToService != remoteAddr.service {
+				continue
+			}
+			switch msg.Problem {
+			case ProblemServiceUnknown:
 				cancel(fmt.Errorf("remote service unreachable"))
+			case ProblemExpiredInTransit:
+				cancel(fmt.Errorf("remote node is beyond the hop limit"))
+			case ProblemTransitNode:
+				cancel(fmt.Errorf("remote node is a transit node and offers no services"))
+			case ProblemConnectionLimit:
+				cancel(ErrConnectionLimit)
 			}
 		}
 	}
 }
 
+// connError returns the reason the remote end gave for ending the connection, if any, or else err.
+func (c *Conn) connError(err error) error {
+	if err != nil && c.ctx.Err() == ErrConnectionLimit {
+		return ErrConnectionLimit
+	}
+
+	return err
+}
+
 // Read reads data from the connection.
 func (c *Conn) Read(b []byte) (n int, err error) {
-	return c.qs.Read(b)
+	n, err = c.qs.Read(b)
+
+	return n, c.connError(err)
 }
 
 // CancelRead cancels a pending read operation.
@@ -398,13 +474,18 @@ func (c *Conn) CancelRead() {
 
 // Write writes data to the connection.
 func (c *Conn) Write(b []byte) (n int, err error) {
-	return c.qs.Write(b)
+	n, err = c.qs.Write(b)
+
+	return n, c.connError(err)
 }
 
 // Close closes the writer side of the connection.
 func (c *Conn) Close() error {
 	c.doneOnce.Do(func() {
 		close(c.doneChan)
+		if c.release != nil {
+			c.release()
+		}
 	})
 
 	return c.qs.Close()
@@ -420,6 +501,12 @@ func (c *Conn) RemoteAddr() net.Addr {
 	return c.qc.RemoteAddr()
 }
 
+// SetTrafficClass sets the traffic class of the data sent on a dialed connection.  Connections accepted by a
+// Listener share its traffic class, which is set with Listener.SetTrafficClass.
+func (c *Conn) SetTrafficClass(class byte) {
+	c.pc.SetTrafficClass(class)
+}
+
 // SetDeadline sets both read and write deadlines.
 func (c *Conn) SetDeadline(t time.Time) error {
 	return c.qs.SetDeadline(t)
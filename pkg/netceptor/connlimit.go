@@ -0,0 +1,118 @@
+package netceptor
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ProblemConnectionLimit occurs when a listener is already handling as many connections as it allows.
+const ProblemConnectionLimit = "connection limit reached"
+
+// ErrConnectionLimit is returned from reads and writes on a connection that the remote listener rejected
+// because it was already handling as many connections as it allows.
+var ErrConnectionLimit = errors.New("remote service connection limit reached")
+
+// ListenerConnStatus is the connection count of a stream listener.
+type ListenerConnStatus struct {
+	Active  int
+	Waiting int
+	Limit   int
+}
+
+// connLimiter counts the connections accepted by a listener, and optionally limits them.
+type connLimiter struct {
+	lock    sync.Mutex
+	limit   int
+	queue   bool
+	active  int
+	waiting int
+	freed   chan struct{}
+}
+
+func newConnLimiter() *connLimiter {
+	return &connLimiter{
+		freed: make(chan struct{}),
+	}
+}
+
+// setLimit sets the maximum number of concurrent connections, or no maximum if limit is zero or less.
+// Connections beyond the limit wait for a free slot if queue is true, or are rejected otherwise.
+func (cl *connLimiter) setLimit(limit int, queue bool) {
+	cl.lock.Lock()
+	defer cl.lock.Unlock()
+	cl.limit = limit
+	cl.queue = queue
+	// Waiting connections re-check the new limit
+	close(cl.freed)
+	cl.freed = make(chan struct{})
+}
+
+// acquire takes a connection slot.  It returns ErrConnectionLimit if no slot is free and connections are
+// not queued, or an error if done is closed while waiting for a slot.
+func (cl *connLimiter) acquire(done <-chan struct{}) error {
+	for {
+		cl.lock.Lock()
+		if cl.limit <= 0 || cl.active < cl.limit {
+			cl.active++
+			cl.lock.Unlock()
+
+			return nil
+		}
+		if !cl.queue {
+			cl.lock.Unlock()
+
+			return ErrConnectionLimit
+		}
+		freed := cl.freed
+		cl.waiting++
+		cl.lock.Unlock()
+		var err error
+		select {
+		case <-freed:
+		case <-done:
+			err = fmt.Errorf("listener closed")
+		}
+		cl.lock.Lock()
+		cl.waiting--
+		cl.lock.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// release frees a connection slot taken by acquire.
+func (cl *connLimiter) release() {
+	cl.lock.Lock()
+	defer cl.lock.Unlock()
+	cl.active--
+	close(cl.freed)
+	cl.freed = make(chan struct{})
+}
+
+// status returns the current connection count.
+func (cl *connLimiter) status() ListenerConnStatus {
+	cl.lock.Lock()
+	defer cl.lock.Unlock()
+
+	return ListenerConnStatus{
+		Active:  cl.active,
+		Waiting: cl.waiting,
+		Limit:   cl.limit,
+	}
+}
+
+// listenerConnections returns the connection counts of all stream listeners, keyed by service.
+func (s *Netceptor) listenerConnections() map[string]ListenerConnStatus {
+	s.listenerLock.RLock()
+	defer s.listenerLock.RUnlock()
+	conns := make(map[string]ListenerConnStatus)
+	for service, pc := range s.listenerRegistry {
+		if pc.connLimiter != nil {
+			conns[service] = pc.connLimiter.status()
+		}
+	}
+
+	return conns
+}
@@ -0,0 +1,95 @@
+package netceptor
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestConnLimiterReject(t *testing.T) {
+	cl := newConnLimiter()
+	done := make(chan struct{})
+	for i := 0; i < 3; i++ {
+		if err := cl.acquire(done); err != nil {
+			t.Fatalf("unlimited acquire failed: %s", err)
+		}
+	}
+	cl.setLimit(3, false)
+	if err := cl.acquire(done); err != ErrConnectionLimit {
+		t.Fatalf("expected ErrConnectionLimit, got %v", err)
+	}
+	cl.release()
+	if err := cl.acquire(done); err != nil {
+		t.Fatalf("acquire after release failed: %s", err)
+	}
+	if st := cl.status(); st.Active != 3 || st.Waiting != 0 || st.Limit != 3 {
+		t.Errorf("unexpected status %+v", st)
+	}
+}
+
+func TestConnLimiterQueue(t *testing.T) {
+	cl := newConnLimiter()
+	cl.setLimit(1, true)
+	done := make(chan struct{})
+	if err := cl.acquire(done); err != nil {
+		t.Fatal(err)
+	}
+	acquired := make(chan error)
+	go func() {
+		acquired <- cl.acquire(done)
+	}()
+	waitFor := func(desc string, cond func() bool) {
+		deadline := time.Now().Add(5 * time.Second)
+		for !cond() {
+			if time.Now().After(deadline) {
+				t.Fatalf("timed out waiting for %s", desc)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	waitFor("connection to queue", func() bool { return cl.status().Waiting == 1 })
+	select {
+	case err := <-acquired:
+		t.Fatalf("queued connection proceeded early: %v", err)
+	default:
+	}
+	cl.release()
+	if err := <-acquired; err != nil {
+		t.Fatalf("queued acquire failed: %s", err)
+	}
+	if st := cl.status(); st.Active != 1 || st.Waiting != 0 {
+		t.Errorf("unexpected status %+v", st)
+	}
+
+	// Raising the limit lets a waiting connection through
+	go func() {
+		acquired <- cl.acquire(done)
+	}()
+	waitFor("connection to queue", func() bool { return cl.status().Waiting == 1 })
+	cl.setLimit(2, true)
+	if err := <-acquired; err != nil {
+		t.Fatalf("acquire after raising limit failed: %s", err)
+	}
+
+	// Closing the listener ends the wait
+	go func() {
+		acquired <- cl.acquire(done)
+	}()
+	waitFor("connection to queue", func() bool { return cl.status().Waiting == 1 })
+	close(done)
+	if err := <-acquired; err == nil {
+		t.Fatal("expected error after listener closed")
+	}
+	if st := cl.status(); st.Active != 2 || st.Waiting != 0 {
+		t.Errorf("unexpected status %+v", st)
+	}
+}
+
+func TestListenerConnectionsStatus(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	n := New(ctx, "A", nil)
+	if conns := n.Status().ListenerConnections; len(conns) != 0 {
+		t.Errorf("expected no listener connections, got %v", conns)
+	}
+}
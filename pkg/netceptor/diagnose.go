@@ -0,0 +1,200 @@
+package netceptor
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// DiagnosisLink is a connection last reported by a diagnosed node.
+type DiagnosisLink struct {
+	NodeID    string
+	Cost      float64
+	Reachable bool
+}
+
+// ConnectionRejection records the most recent time a connection with a node was refused.
+type ConnectionRejection struct {
+	Time     time.Time
+	Reason   string
+	ByRemote bool
+}
+
+// UpdateRejection records the most recent time a routing update from a node was rejected.
+type UpdateRejection struct {
+	Time   time.Time
+	Reason string
+}
+
+// Diagnosis explains whether a remote node can be reached, and if not, why not.
+type Diagnosis struct {
+	NodeID             string
+	Known              bool
+	LastSeen           *time.Time
+	Reachable          bool
+	Path               []string
+	PathCost           float64
+	Direct             bool
+	Links              []DiagnosisLink
+	AllowedPeer        bool
+	Rejection          *ConnectionRejection
+	SignaturesEnforced bool
+	VerificationKey    bool
+	UpdateRejection    *UpdateRejection
+	Problems           []string
+}
+
+// recordRejection remembers that a connection with a node was refused, by us or by the remote node.
+func (s *Netceptor) recordRejection(remoteNodeID string, reason string, byRemote bool) {
+	if remoteNodeID == "" {
+		return
+	}
+	s.connLock.Lock()
+	defer s.connLock.Unlock()
+	s.rejections[remoteNodeID] = &ConnectionRejection{
+		Time:     time.Now(),
+		Reason:   reason,
+		ByRemote: byRemote,
+	}
+}
+
+// recordUpdateRejection remembers that a routing update from a node was rejected.
+func (s *Netceptor) recordUpdateRejection(nodeID string, reason string) {
+	s.routeSigningLock.Lock()
+	defer s.routeSigningLock.Unlock()
+	s.updateRejections[nodeID] = &UpdateRejection{
+		Time:   time.Now(),
+		Reason: reason,
+	}
+}
+
+// routePath returns the nodes along the current best path to a node, starting with this node.
+// Must be called with knownNodeLock and routingTableLock held.
+func (s *Netceptor) routePath(nodeID string) []string {
+	path := []string{nodeID}
+	node := nodeID
+	for node != s.nodeID {
+		if len(path) > len(s.knownConnectionCosts) {
+			return nil
+		}
+		found := false
+		for prev, edges := range s.knownConnectionCosts {
+			prevCost, ok := s.routingPathCosts[prev]
+			if !ok {
+				continue
+			}
+			edgeCost, ok := edges[node]
+			if ok && prevCost+edgeCost == s.routingPathCosts[node] {
+				path = append([]string{prev}, path...)
+				node = prev
+				found = true
+
+				break
+			}
+		}
+		if !found {
+			return nil
+		}
+	}
+
+	return path
+}
+
+// Diagnose reports what this node knows about reaching another node.
+func (s *Netceptor) Diagnose(nodeID string) *Diagnosis {
+	d := &Diagnosis{
+		NodeID:      nodeID,
+		AllowedPeer: true,
+		Links:       make([]DiagnosisLink, 0),
+		Problems:    make([]string, 0),
+	}
+	if nodeID == s.nodeID {
+		d.Known = true
+		d.Reachable = true
+		d.Path = []string{s.nodeID}
+
+		return d
+	}
+
+	s.connLock.RLock()
+	_, d.Direct = s.connections[nodeID]
+	if rej, ok := s.rejections[nodeID]; ok {
+		rejCopy := *rej
+		d.Rejection = &rejCopy
+	}
+	d.AllowedPeer = s.isAllowedPeer(nodeID)
+	s.connLock.RUnlock()
+
+	s.routeSigningLock.RLock()
+	d.SignaturesEnforced = s.enforceRouteSignatures
+	_, d.VerificationKey = s.routeVerifyKeys[nodeID]
+	if rej, ok := s.updateRejections[nodeID]; ok {
+		rejCopy := *rej
+		d.UpdateRejection = &rejCopy
+	}
+	s.routeSigningLock.RUnlock()
+
+	s.knownNodeLock.RLock()
+	ni, ok := s.knownNodeInfo[nodeID]
+	if ok {
+		d.Known = true
+		if !ni.LastSeen.IsZero() {
+			lastSeen := ni.LastSeen
+			d.LastSeen = &lastSeen
+		}
+	}
+	s.routingTableLock.RLock()
+	_, d.Reachable = s.routingTable[nodeID]
+	if d.Reachable {
+		d.PathCost = s.routingPathCosts[nodeID]
+		d.Path = s.routePath(nodeID)
+	}
+	for neighbor, cost := range s.knownConnectionCosts[nodeID] {
+		_, reachable := s.routingTable[neighbor]
+		d.Links = append(d.Links, DiagnosisLink{
+			NodeID:    neighbor,
+			Cost:      cost,
+			Reachable: reachable || neighbor == s.nodeID,
+		})
+	}
+	s.routingTableLock.RUnlock()
+	s.knownNodeLock.RUnlock()
+	sort.Slice(d.Links, func(i, j int) bool {
+		return d.Links[i].NodeID < d.Links[j].NodeID
+	})
+
+	switch {
+	case !d.Known:
+		d.Problems = append(d.Problems, "node has never been seen in a routing update")
+	case !d.Reachable:
+		problem := "no route to node"
+		if d.LastSeen != nil {
+			problem = fmt.Sprintf("no route to node, last seen %s ago", time.Since(*d.LastSeen).Round(time.Second))
+		}
+		d.Problems = append(d.Problems, problem)
+		if len(d.Links) == 0 {
+			d.Problems = append(d.Problems, "node has no known connections to the rest of the mesh")
+		}
+	}
+	if !d.AllowedPeer {
+		d.Problems = append(d.Problems, "node is not in this node's allowed peers list, so direct connections are refused")
+	}
+	if d.SignaturesEnforced && !d.VerificationKey {
+		d.Problems = append(d.Problems,
+			"route signatures are enforced and there is no verification key for node, so its routing updates are rejected")
+	}
+	if d.UpdateRejection != nil && (d.LastSeen == nil || d.UpdateRejection.Time.After(*d.LastSeen)) {
+		d.Problems = append(d.Problems, fmt.Sprintf("a routing update from node was rejected at %s: %s",
+			d.UpdateRejection.Time.Format(time.RFC3339), d.UpdateRejection.Reason))
+	}
+	if d.Rejection != nil && !d.Direct {
+		by := "we"
+		if d.Rejection.ByRemote {
+			by = "the remote node"
+		}
+		d.Problems = append(d.Problems, fmt.Sprintf("%s rejected a direct connection at %s: %s",
+			by, d.Rejection.Time.Format(time.RFC3339), d.Rejection.Reason))
+	}
+
+	return d
+}
@@ -0,0 +1,129 @@
+package netceptor
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// receiveUpdate simulates receiving a routing update from nodeID, forwarded by node B.
+func receiveUpdate(s *Netceptor, nodeID string, sequence uint64, connections map[string]float64) {
+	s.handleRoutingUpdate(&routingUpdate{
+		NodeID:         nodeID,
+		UpdateID:       nodeID + string(rune('0'+sequence)),
+		UpdateEpoch:    1,
+		UpdateSequence: sequence,
+		Connections:    connections,
+		ForwardingNode: "B",
+	}, "B")
+	s.updateRoutingTable()
+}
+
+func hasProblem(d *Diagnosis, substr string) bool {
+	for _, p := range d.Problems {
+		if strings.Contains(p, substr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func TestDiagnosePartitioned(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := New(ctx, "A", nil)
+	defer s.Shutdown()
+
+	// A - B - C
+	s.knownNodeLock.Lock()
+	s.knownConnectionCosts["A"] = map[string]float64{"B": 1.0}
+	s.knownNodeLock.Unlock()
+	receiveUpdate(s, "B", 1, map[string]float64{"A": 1.0, "C": 1.0})
+	receiveUpdate(s, "C", 1, map[string]float64{"B": 1.0})
+	seenC := time.Now()
+
+	d := s.Diagnose("C")
+	if !d.Known || !d.Reachable {
+		t.Fatalf("expected C to be reachable, got %+v", d)
+	}
+	if !reflect.DeepEqual(d.Path, []string{"A", "B", "C"}) {
+		t.Fatalf("unexpected path %v", d.Path)
+	}
+	if d.PathCost != 2.0 {
+		t.Fatalf("expected path cost 2, got %f", d.PathCost)
+	}
+	if len(d.Problems) != 0 {
+		t.Fatalf("unexpected problems %v", d.Problems)
+	}
+
+	// B loses its connection to C
+	receiveUpdate(s, "B", 2, map[string]float64{"A": 1.0})
+	d = s.Diagnose("C")
+	if !d.Known || d.Reachable {
+		t.Fatalf("expected C to be known but unreachable, got %+v", d)
+	}
+	if d.Path != nil {
+		t.Fatalf("unexpected path %v", d.Path)
+	}
+	if d.LastSeen == nil || d.LastSeen.After(seenC) || seenC.Sub(*d.LastSeen) > time.Second {
+		t.Fatalf("unexpected last seen time %v", d.LastSeen)
+	}
+	if !hasProblem(d, "no route to node, last seen") {
+		t.Fatalf("expected no route problem, got %v", d.Problems)
+	}
+	if !hasProblem(d, "no known connections") {
+		t.Fatalf("expected no connections problem, got %v", d.Problems)
+	}
+
+	d = s.Diagnose("Z")
+	if d.Known || d.Reachable || !hasProblem(d, "never been seen") {
+		t.Fatalf("unexpected diagnosis of unknown node %+v", d)
+	}
+}
+
+func TestDiagnoseDenied(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := New(ctx, "A", nil)
+	defer s.Shutdown()
+	s.allowedPeers = []string{"B"}
+	s.recordRejection("C", "it is not in the accepted connections list", false)
+
+	d := s.Diagnose("C")
+	if d.AllowedPeer {
+		t.Fatal("expected C not to be an allowed peer")
+	}
+	if d.Rejection == nil || d.Rejection.ByRemote {
+		t.Fatalf("expected a local rejection, got %+v", d.Rejection)
+	}
+	if !hasProblem(d, "allowed peers") || !hasProblem(d, "we rejected a direct connection") {
+		t.Fatalf("expected denial problems, got %v", d.Problems)
+	}
+}
+
+func TestDiagnoseSignatureDenied(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := New(ctx, "A", nil)
+	defer s.Shutdown()
+	s.SetRouteSignatureEnforcement(true)
+	s.knownNodeLock.Lock()
+	s.knownConnectionCosts["A"] = map[string]float64{"B": 1.0}
+	s.knownNodeLock.Unlock()
+
+	// C's updates are unsigned, so they are rejected and C is never seen
+	receiveUpdate(s, "C", 1, map[string]float64{"B": 1.0})
+	d := s.Diagnose("C")
+	if d.Known || !d.SignaturesEnforced || d.VerificationKey {
+		t.Fatalf("expected C to be unknown with no verification key, got %+v", d)
+	}
+	if d.UpdateRejection == nil {
+		t.Fatal("expected the rejected routing update to be recorded")
+	}
+	if !hasProblem(d, "no verification key") || !hasProblem(d, "routing update from node was rejected") {
+		t.Fatalf("expected signature denial problems, got %v", d.Problems)
+	}
+}
@@ -0,0 +1,56 @@
+package netceptor
+
+import (
+	"fmt"
+	"unicode"
+
+	"github.com/ansible/receptor/pkg/logger"
+)
+
+// maxDisplayNameLength is the longest display name a node can be given.
+const maxDisplayNameLength = 128
+
+// ValidateNodeDisplayName checks that a display name is short enough and has no control characters.
+func ValidateNodeDisplayName(name string) error {
+	if len(name) > maxDisplayNameLength {
+		return fmt.Errorf("display name is longer than %d bytes", maxDisplayNameLength)
+	}
+	for _, c := range name {
+		if unicode.IsControl(c) {
+			return fmt.Errorf("display name must not contain control characters")
+		}
+	}
+
+	return nil
+}
+
+// SetNodeDisplayName sets a human-friendly name for this node, shown in its status and added to its trace
+// spans.  The display name is only a label: the node ID used for routing is unchanged, so it can be changed
+// at any time.  An empty name clears it.
+func (s *Netceptor) SetNodeDisplayName(name string) error {
+	err := ValidateNodeDisplayName(name)
+	if err != nil {
+		return err
+	}
+	s.displayNameLock.Lock()
+	changed := s.displayName != name
+	s.displayName = name
+	s.displayNameLock.Unlock()
+	if changed {
+		if name == "" {
+			logger.Info("Node display name cleared\n")
+		} else {
+			logger.Info("Node display name is %s\n", name)
+		}
+	}
+
+	return nil
+}
+
+// NodeDisplayName returns the display name of this node, or an empty string if it has none.
+func (s *Netceptor) NodeDisplayName() string {
+	s.displayNameLock.RLock()
+	defer s.displayNameLock.RUnlock()
+
+	return s.displayName
+}
@@ -0,0 +1,69 @@
+package netceptor
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// MessageHeaderLength is the length of the binary header of a data message.  Other message types have a
+// header of just the message type byte.
+const MessageHeaderLength = 36
+
+// serviceNameLength is the length of the fixed size service name fields in a data message header.
+const serviceNameLength = 8
+
+// ErrEmptyFrame is returned by ParseHeader for a frame with no bytes at all.
+var ErrEmptyFrame = errors.New("empty frame")
+
+// MessageHeader is the header of a frame received from a backend.  The node fields are hashes of the node IDs,
+// and only the Type is set for frames other than data messages.
+type MessageHeader struct {
+	Type         byte
+	HopsToLive   byte
+	TrafficClass byte
+	FromNodeHash uint64
+	ToNodeHash   uint64
+	FromService  string
+	ToService    string
+}
+
+// ParseHeader parses the header of a frame received from a backend, and returns the header and the payload
+// that follows it.  The payload shares the frame's memory.  Frames from peers are untrusted, so any frame
+// too short for its header is rejected rather than read past its end.
+func ParseHeader(frame []byte) (*MessageHeader, []byte, error) {
+	if len(frame) == 0 {
+		return nil, nil, ErrEmptyFrame
+	}
+	hdr := &MessageHeader{Type: frame[0]}
+	if hdr.Type != MsgTypeData {
+		return hdr, frame[1:], nil
+	}
+	if len(frame) < MessageHeaderLength {
+		return nil, nil, fmt.Errorf("data message of %d bytes is shorter than its %d byte header",
+			len(frame), MessageHeaderLength)
+	}
+	hdr.HopsToLive = frame[1]
+	hdr.TrafficClass = frame[2]
+	hdr.FromNodeHash = binary.BigEndian.Uint64(frame[4:12])
+	hdr.ToNodeHash = binary.BigEndian.Uint64(frame[12:20])
+	hdr.FromService = stringFromFixedLenBytes(frame[20 : 20+serviceNameLength])
+	hdr.ToService = stringFromFixedLenBytes(frame[28 : 28+serviceNameLength])
+
+	return hdr, frame[MessageHeaderLength:], nil
+}
+
+// AppendHeader appends the binary header of a data message to buf.  Service names longer than the header's
+// fixed size fields are truncated.
+func AppendHeader(buf []byte, hdr *MessageHeader) []byte {
+	var header [MessageHeaderLength]byte
+	header[0] = MsgTypeData
+	header[1] = hdr.HopsToLive
+	header[2] = hdr.TrafficClass
+	binary.BigEndian.PutUint64(header[4:12], hdr.FromNodeHash)
+	binary.BigEndian.PutUint64(header[12:20], hdr.ToNodeHash)
+	copy(header[20:20+serviceNameLength], hdr.FromService)
+	copy(header[28:28+serviceNameLength], hdr.ToService)
+
+	return append(buf, header[:]...)
+}
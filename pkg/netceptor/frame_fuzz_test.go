@@ -0,0 +1,73 @@
+//go:build go1.18
+// +build go1.18
+
+package netceptor
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func FuzzParseHeader(f *testing.F) {
+	valid := AppendHeader(nil, &MessageHeader{
+		HopsToLive:   30,
+		TrafficClass: 2,
+		FromNodeHash: 0x0123456789abcdef,
+		ToNodeHash:   0xfedcba9876543210,
+		FromService:  "ping",
+		ToService:    "control",
+	})
+	f.Add(valid)
+	f.Add(append(append([]byte{}, valid...), []byte("payload")...))
+	for _, n := range []int{1, 4, 12, 20, 28, MessageHeaderLength - 1} {
+		f.Add(valid[:n])
+	}
+	f.Add([]byte{})
+	f.Add([]byte{MsgTypeRoute})
+	f.Add(append([]byte{MsgTypeRoute}, []byte(`{"NodeID":"A"}`)...))
+	f.Add(append([]byte{MsgTypeServiceAdvertisement}, []byte(`{"Service":"control"}`)...))
+	f.Add([]byte{MsgTypeReject, 0, 1})
+	f.Add([]byte{0xff, 0xff})
+
+	f.Fuzz(func(t *testing.T, frame []byte) {
+		hdr, payload, err := ParseHeader(frame)
+		if err != nil {
+			if hdr != nil || payload != nil {
+				t.Fatalf("expected no header or payload with error %s", err)
+			}
+			if len(frame) == 0 && !errors.Is(err, ErrEmptyFrame) {
+				t.Fatalf("expected ErrEmptyFrame for an empty frame, got %s", err)
+			}
+			if len(frame) > 0 && (frame[0] != MsgTypeData || len(frame) >= MessageHeaderLength) {
+				t.Fatalf("unexpected error parsing %d byte frame of type %d: %s", len(frame), frame[0], err)
+			}
+
+			return
+		}
+		if hdr.Type != frame[0] {
+			t.Fatalf("expected type %d, got %d", frame[0], hdr.Type)
+		}
+		if hdr.Type != MsgTypeData {
+			if !bytes.Equal(payload, frame[1:]) {
+				t.Fatal("expected payload to follow the type byte")
+			}
+
+			return
+		}
+		if !bytes.Equal(payload, frame[MessageHeaderLength:]) {
+			t.Fatal("expected payload to follow the header")
+		}
+		if len(hdr.FromService) > serviceNameLength || len(hdr.ToService) > serviceNameLength {
+			t.Fatalf("service names longer than %d bytes in %+v", serviceNameLength, hdr)
+		}
+		// Re-encoding the header reproduces it, since only the reserved byte is discarded
+		again, rest, err := ParseHeader(AppendHeader(nil, hdr))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(rest) != 0 || *again != *hdr {
+			t.Fatalf("header %+v did not round trip, got %+v", hdr, again)
+		}
+	})
+}
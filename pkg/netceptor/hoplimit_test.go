@@ -0,0 +1,127 @@
+package netceptor
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHopLimitOnLongPath(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Build a chain A-B-C-D-E, where A only allows its messages to be forwarded twice
+	names := []string{"A", "B", "C", "D", "E"}
+	nodes := make(map[string]*Netceptor)
+	for _, name := range names {
+		nodes[name] = New(ctx, name, nil)
+	}
+	if err := nodes["A"].SetMaxForwardingHops(2); err != nil {
+		t.Fatal(err)
+	}
+	if err := nodes["A"].SetMaxForwardingHops(0); err == nil {
+		t.Error("expected error setting zero hops")
+	}
+	for i := 0; i < len(names)-1; i++ {
+		s1, s2 := newPipeSessions()
+		if err := nodes[names[i]].AddBackend(&pipeBackend{sess: s1}, 1.0, nil); err != nil {
+			t.Fatal(err)
+		}
+		if err := nodes[names[i+1]].AddBackend(&pipeBackend{sess: s2}, 1.0, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		if _, ok := nodes["A"].Status().RoutingTable["E"]; ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for A to learn a route to E")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	pcA, err := nodes["A"].ListenPacket("sender")
+	if err != nil {
+		t.Fatal(err)
+	}
+	unreachCh := pcA.SubscribeUnreachable()
+	receivers := make(map[string]*PacketConn)
+	for _, name := range []string{"C", "E"} {
+		receivers[name], err = nodes[name].ListenPacket("echo")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// C is two hops away, so messages reach it
+	if _, err := pcA.WriteTo([]byte("near"), nodes["A"].NewAddr("C", "echo")); err != nil {
+		t.Fatal(err)
+	}
+	_ = receivers["C"].SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 16)
+	n, _, err := receivers["C"].ReadFrom(buf)
+	if err != nil || string(buf[:n]) != "near" {
+		t.Fatalf("expected C to receive message, got %q (%v)", buf[:n], err)
+	}
+
+	// E is four hops away, so messages are dropped at C and A is told why
+	if _, err := pcA.WriteTo([]byte("far"), nodes["A"].NewAddr("E", "echo")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-unreachCh:
+		if msg.Problem != ProblemExpiredInTransit || msg.ToNode != "E" || msg.ReceivedFromNode != "C" {
+			t.Errorf("unexpected unreachable message %+v", msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for hop limit notification")
+	}
+	_ = receivers["E"].SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	if _, _, err := receivers["E"].ReadFrom(buf); err == nil {
+		t.Error("E received a message beyond the hop limit")
+	}
+	if expired := nodes["C"].ExpiredMessages(); expired != 1 {
+		t.Errorf("expected C to count one expired message, got %d", expired)
+	}
+	if expired := nodes["C"].Status().ExpiredMessages; expired != 1 {
+		t.Errorf("expected C's status to show one expired message, got %d", expired)
+	}
+}
+
+func TestHopLimitWarningsAggregated(t *testing.T) {
+	buf := &bytes.Buffer{}
+	log.SetOutput(buf)
+	defer log.SetOutput(os.Stdout)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := New(ctx, "A", nil)
+	now := time.Now()
+	s.now = func() time.Time { return now }
+	expire := func() {
+		_ = s.forwardMessage(&messageData{FromNode: "B", FromService: "unreach", ToNode: "C", ToService: "svc"})
+	}
+
+	// Only the first of a burst of drops is logged
+	for i := 0; i < 5; i++ {
+		expire()
+	}
+	if n := strings.Count(buf.String(), "exceeded the hop limit"); n != 1 {
+		t.Fatalf("expected 1 warning for a burst of drops, got %d: %s", n, buf.String())
+	}
+
+	// The next warning, once the interval has passed, counts the ones that were not logged
+	now = now.Add(expiredWarningInterval)
+	expire()
+	if !strings.Contains(buf.String(), "(4 more dropped since the last warning)") {
+		t.Fatalf("expected the unlogged drops to be counted, got %s", buf.String())
+	}
+	if s.ExpiredMessages() != 6 {
+		t.Fatalf("expected 6 expired messages, got %d", s.ExpiredMessages())
+	}
+}
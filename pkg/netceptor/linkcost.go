@@ -0,0 +1,108 @@
+package netceptor
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Traffic classes select which component of a composite connection cost a message is routed by.
+const (
+	// TrafficClassDefault is routed by the scalar connection cost.
+	TrafficClassDefault byte = 0
+	// TrafficClassInteractive is routed by the latency component of composite connection costs.
+	TrafficClassInteractive byte = 1
+	// TrafficClassBulk is routed by the bandwidth component of composite connection costs.
+	TrafficClassBulk byte = 2
+)
+
+// trafficClassNames maps the traffic classes to their names.
+var trafficClassNames = map[byte]string{
+	TrafficClassDefault:     "default",
+	TrafficClassInteractive: "interactive",
+	TrafficClassBulk:        "bulk",
+}
+
+// TrafficClassName returns the name of a traffic class.
+func TrafficClassName(class byte) string {
+	name, ok := trafficClassNames[class]
+	if !ok {
+		return fmt.Sprintf("unknown(%d)", class)
+	}
+
+	return name
+}
+
+// ParseTrafficClass returns the traffic class with the given name.
+func ParseTrafficClass(name string) (byte, error) {
+	for class, className := range trafficClassNames {
+		if strings.EqualFold(name, className) {
+			return class, nil
+		}
+	}
+
+	return 0, fmt.Errorf("unknown traffic class %s: must be default, interactive or bulk", name)
+}
+
+// LinkCost is the composite cost of a connection, which lets routing tell apart links that are fast to
+// respond from links that move a lot of data.  Higher values are worse, as with scalar costs, so a
+// high-bandwidth link has a low Bandwidth cost.
+type LinkCost struct {
+	// Latency is the cost used to route interactive traffic.
+	Latency float64
+	// Bandwidth is the cost used to route bulk traffic.
+	Bandwidth float64
+}
+
+// Validate returns an error if the components of the cost are not positive.
+func (lc LinkCost) Validate() error {
+	if lc.Latency <= 0.0 || lc.Bandwidth <= 0.0 {
+		return fmt.Errorf("latency and bandwidth costs must both be positive")
+	}
+
+	return nil
+}
+
+// costFor returns the effective cost of a connection for a traffic class.  Connections without a
+// composite cost have the same scalar cost for every class.
+func (lc *LinkCost) costFor(class byte, scalar float64) float64 {
+	if lc == nil {
+		return scalar
+	}
+	switch class {
+	case TrafficClassInteractive:
+		return lc.Latency
+	case TrafficClassBulk:
+		return lc.Bandwidth
+	default:
+		return scalar
+	}
+}
+
+// knownLinkCost returns the composite cost of the connection between two nodes, as advertised by either
+// of them, or nil if neither advertises one.  The caller must hold knownNodeLock.
+func (s *Netceptor) knownLinkCost(node string, neighbor string) *LinkCost {
+	if lc, ok := s.knownLinkCosts[node][neighbor]; ok {
+		return &lc
+	}
+	if lc, ok := s.knownLinkCosts[neighbor][node]; ok {
+		return &lc
+	}
+
+	return nil
+}
+
+// RouteFor returns the next hop this node uses to send traffic of a given class to a node.
+func (s *Netceptor) RouteFor(nodeID string, class byte) (string, error) {
+	s.routingTableLock.RLock()
+	defer s.routingTableLock.RUnlock()
+	table := s.routingTable
+	if classTable, ok := s.classRoutingTables[class]; ok {
+		table = classTable
+	}
+	nextHop, ok := table[nodeID]
+	if !ok {
+		return "", fmt.Errorf("no route to node")
+	}
+
+	return nextHop, nil
+}
@@ -0,0 +1,142 @@
+package netceptor
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+)
+
+// linkNodes connects two nodes with an in-memory session pair, giving the first node's side a composite
+// cost, and returns the second node's session.
+func linkNodes(t *testing.T, n1 *Netceptor, n2 *Netceptor, linkCost *LinkCost) *pipeSession {
+	s1, s2 := newPipeSessions()
+	if err := n1.AddBackendWithOptions(&pipeBackend{sess: s1}, 1.0, nil, BackendOptions{LinkCost: linkCost}); err != nil {
+		t.Fatal(err)
+	}
+	if err := n2.AddBackend(&pipeBackend{sess: s2}, 1.0, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	return s2
+}
+
+func waitForRoute(t *testing.T, s *Netceptor, node string, class byte, nextHop string) {
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		hop, err := s.RouteFor(node, class)
+		if err == nil && hop == nextHop {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s to route %s traffic to %s via %s, got %q (%v)",
+				s.NodeID(), TrafficClassName(class), node, nextHop, hop, err)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func sessionCarried(sess *pipeSession, payload []byte) bool {
+	for _, frame := range sess.received() {
+		if bytes.Contains(frame, payload) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func TestTrafficClassRouting(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// A diamond where the path through B is fast to respond but slow to move data, and the path
+	// through C is the opposite.  The scalar costs are all equal.
+	nodes := make(map[string]*Netceptor)
+	for _, name := range []string{"A", "B", "C", "D"} {
+		nodes[name] = New(ctx, name, nil)
+	}
+	lowLatency := &LinkCost{Latency: 1, Bandwidth: 10}
+	highBandwidth := &LinkCost{Latency: 10, Bandwidth: 1}
+	linkNodes(t, nodes["A"], nodes["B"], lowLatency)
+	linkNodes(t, nodes["A"], nodes["C"], highBandwidth)
+	viaB := linkNodes(t, nodes["B"], nodes["D"], lowLatency)
+	viaC := linkNodes(t, nodes["C"], nodes["D"], highBandwidth)
+
+	waitForRoute(t, nodes["A"], "D", TrafficClassInteractive, "B")
+	waitForRoute(t, nodes["A"], "D", TrafficClassBulk, "C")
+	// D learned the composite costs from the other nodes' routing updates
+	waitForRoute(t, nodes["D"], "A", TrafficClassInteractive, "B")
+	waitForRoute(t, nodes["D"], "A", TrafficClassBulk, "C")
+	if lc := nodes["D"].Status().KnownLinkCosts["A"]["C"]; lc != *highBandwidth {
+		t.Errorf("expected D to know the A-C link cost %v, got %v", *highBandwidth, lc)
+	}
+
+	receiver, err := nodes["D"].ListenPacket("sink")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		class   byte
+		payload []byte
+		via     *pipeSession
+		notVia  *pipeSession
+	}{
+		{TrafficClassInteractive, []byte("interactive-payload"), viaB, viaC},
+		{TrafficClassBulk, []byte("bulk-payload"), viaC, viaB},
+	} {
+		sender, err := nodes["A"].ListenPacket("")
+		if err != nil {
+			t.Fatal(err)
+		}
+		sender.SetTrafficClass(tc.class)
+		if _, err := sender.WriteTo(tc.payload, nodes["A"].NewAddr("D", "sink")); err != nil {
+			t.Fatal(err)
+		}
+		_ = receiver.SetReadDeadline(time.Now().Add(5 * time.Second))
+		buf := make([]byte, 64)
+		n, _, err := receiver.ReadFrom(buf)
+		if err != nil || !bytes.Equal(buf[:n], tc.payload) {
+			t.Fatalf("expected D to receive %q, got %q (%v)", tc.payload, buf[:n], err)
+		}
+		if !sessionCarried(tc.via, tc.payload) || sessionCarried(tc.notVia, tc.payload) {
+			t.Errorf("%s traffic did not take the expected path", TrafficClassName(tc.class))
+		}
+		_ = sender.Close()
+	}
+}
+
+func TestTrafficClassWithScalarCosts(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	a := New(ctx, "A", nil)
+	b := New(ctx, "B", nil)
+	linkNodes(t, a, b, nil)
+	waitForRoute(t, a, "B", TrafficClassDefault, "B")
+	for _, class := range []byte{TrafficClassInteractive, TrafficClassBulk} {
+		if hop, err := a.RouteFor("B", class); err != nil || hop != "B" {
+			t.Errorf("expected %s traffic to use the scalar route, got %q (%v)", TrafficClassName(class), hop, err)
+		}
+	}
+	if len(a.Status().KnownLinkCosts) != 0 {
+		t.Errorf("expected no composite costs, got %v", a.Status().KnownLinkCosts)
+	}
+	if err := a.AddBackendWithOptions(&pipeBackend{}, 1.0, nil, BackendOptions{LinkCost: &LinkCost{Latency: 1}}); err == nil {
+		t.Error("expected an error for a composite cost without a bandwidth component")
+	}
+}
+
+func TestParseTrafficClass(t *testing.T) {
+	for _, name := range []string{"default", "interactive", "Bulk"} {
+		class, err := ParseTrafficClass(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if TrafficClassName(class) != map[string]string{"default": "default", "interactive": "interactive", "Bulk": "bulk"}[name] {
+			t.Errorf("unexpected class %d for %s", class, name)
+		}
+	}
+	if _, err := ParseTrafficClass("realtime"); err == nil {
+		t.Error("expected an error for an unknown traffic class")
+	}
+}
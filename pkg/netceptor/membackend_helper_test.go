@@ -0,0 +1,278 @@
+package netceptor
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// memRedialDelay is how long an in-memory link waits to reconnect after its session ends.
+const memRedialDelay = 100 * time.Millisecond
+
+// memQueueSize is how many messages can be in flight in each direction of an in-memory link before
+// Send blocks.
+const memQueueSize = 1000
+
+// MemLinkConditions are the simulated network conditions of an in-memory link.
+type MemLinkConditions struct {
+	// Latency is how long each message takes to arrive.
+	Latency time.Duration
+	// Jitter is the most that is randomly added to the latency of each message.  Messages still arrive
+	// in the order they were sent.
+	Jitter time.Duration
+	// DropRate is the fraction of messages, from 0 to 1, that are silently lost.
+	DropRate float64
+}
+
+// Validate returns an error if the conditions are not valid.
+func (mc MemLinkConditions) Validate() error {
+	if mc.Latency < 0 || mc.Jitter < 0 {
+		return fmt.Errorf("latency and jitter must not be negative")
+	}
+	if mc.DropRate < 0 || mc.DropRate > 1 {
+		return fmt.Errorf("drop rate must be between 0 and 1")
+	}
+
+	return nil
+}
+
+// MemBackend is one end of an in-memory link between two Netceptor instances in the same process,
+// for testing without sockets.  Once both ends of the link have been started, each gets a session
+// connected to the other, and the link reconnects after a session is closed, like a dialer would.
+// It cannot be configured from a receptor config file.
+type MemBackend struct {
+	link     *memLink
+	end      int
+	ctx      context.Context
+	sessChan chan BackendSession
+}
+
+// memLink is the state shared by the two ends of an in-memory link.
+type memLink struct {
+	lock     sync.Mutex
+	cond     MemLinkConditions
+	rand     *rand.Rand
+	ends     [2]*MemBackend
+	sessions [2]*memSession
+	down     bool
+}
+
+// NewMemBackendPair returns the two ends of an in-memory link with the given conditions.  Each end
+// should be added to a different Netceptor.
+func NewMemBackendPair(cond MemLinkConditions) (*MemBackend, *MemBackend, error) {
+	if err := cond.Validate(); err != nil {
+		return nil, nil, err
+	}
+	ml := &memLink{
+		cond: cond,
+		rand: rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	for i := range ml.ends {
+		ml.ends[i] = &MemBackend{
+			link:     ml,
+			end:      i,
+			sessChan: make(chan BackendSession),
+		}
+	}
+
+	return ml.ends[0], ml.ends[1], nil
+}
+
+// Start launches this end of the link, connecting it to the other end once that has also started.
+func (b *MemBackend) Start(ctx context.Context, wg *sync.WaitGroup) (chan BackendSession, error) {
+	ml := b.link
+	ml.lock.Lock()
+	defer ml.lock.Unlock()
+	if b.ctx != nil {
+		return nil, fmt.Errorf("in-memory backend already started")
+	}
+	b.ctx = ctx
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		<-ctx.Done()
+		ml.lock.Lock()
+		defer ml.lock.Unlock()
+		ml.closeSessions()
+	}()
+	ml.connect()
+
+	return b.sessChan, nil
+}
+
+// SetConditions changes the simulated conditions of the link.  Messages already in flight are not affected.
+func (b *MemBackend) SetConditions(cond MemLinkConditions) error {
+	if err := cond.Validate(); err != nil {
+		return err
+	}
+	b.link.lock.Lock()
+	defer b.link.lock.Unlock()
+	b.link.cond = cond
+
+	return nil
+}
+
+// Disconnect closes the session of the link and keeps it from reconnecting until Reconnect is called.
+func (b *MemBackend) Disconnect() {
+	ml := b.link
+	ml.lock.Lock()
+	defer ml.lock.Unlock()
+	ml.down = true
+	ml.closeSessions()
+}
+
+// Reconnect lets a link closed by Disconnect connect again.
+func (b *MemBackend) Reconnect() {
+	ml := b.link
+	ml.lock.Lock()
+	defer ml.lock.Unlock()
+	ml.down = false
+	ml.connect()
+}
+
+// connect gives each end a new session connected to the other, if both ends are running and there is not
+// a session already.  Must be called with lock held.
+func (ml *memLink) connect() {
+	if ml.down || ml.sessions[0] != nil {
+		return
+	}
+	for _, b := range ml.ends {
+		if b.ctx == nil || b.ctx.Err() != nil {
+			return
+		}
+	}
+	closed := make(chan struct{})
+	once := &sync.Once{}
+	queues := [2]chan memMessage{make(chan memMessage, memQueueSize), make(chan memMessage, memQueueSize)}
+	for i := range ml.sessions {
+		ml.sessions[i] = &memSession{
+			link:   ml,
+			in:     queues[i],
+			out:    queues[1-i],
+			closed: closed,
+			once:   once,
+		}
+	}
+	sessions := ml.sessions
+	for i, b := range ml.ends {
+		go func(b *MemBackend, sess *memSession) {
+			select {
+			case b.sessChan <- sess:
+			case <-b.ctx.Done():
+				_ = sess.Close()
+			}
+		}(b, sessions[i])
+	}
+	go ml.redialAfterClose(sessions[0])
+}
+
+// redialAfterClose waits for a session to be closed, and then reconnects the link.
+func (ml *memLink) redialAfterClose(sess *memSession) {
+	<-sess.closed
+	ml.lock.Lock()
+	if ml.sessions[0] == sess {
+		ml.sessions = [2]*memSession{}
+	}
+	ml.lock.Unlock()
+	time.Sleep(memRedialDelay)
+	ml.lock.Lock()
+	defer ml.lock.Unlock()
+	ml.connect()
+}
+
+// closeSessions closes the current session of the link, if there is one.  Must be called with lock held.
+func (ml *memLink) closeSessions() {
+	if ml.sessions[0] != nil {
+		_ = ml.sessions[0].Close()
+		ml.sessions = [2]*memSession{}
+	}
+}
+
+// memMessage is a message in flight on an in-memory link, which arrives at a given time.
+type memMessage struct {
+	data    []byte
+	arrival time.Time
+}
+
+// memSession implements BackendSession for one end of an in-memory link.
+type memSession struct {
+	link        *memLink
+	in          chan memMessage
+	out         chan memMessage
+	closed      chan struct{}
+	once        *sync.Once
+	lastArrival time.Time
+	pending     *memMessage
+}
+
+// Send sends data to the other end of the link, after the link's latency, unless it is dropped.
+func (ms *memSession) Send(data []byte) error {
+	ms.link.lock.Lock()
+	cond := ms.link.cond
+	drop := cond.DropRate > 0 && ms.link.rand.Float64() < cond.DropRate
+	delay := cond.Latency
+	if cond.Jitter > 0 {
+		delay += time.Duration(ms.link.rand.Int63n(int64(cond.Jitter)))
+	}
+	arrival := time.Now().Add(delay)
+	if arrival.Before(ms.lastArrival) {
+		arrival = ms.lastArrival
+	}
+	ms.lastArrival = arrival
+	ms.link.lock.Unlock()
+	select {
+	case <-ms.closed:
+		return io.EOF
+	default:
+	}
+	if drop {
+		return nil
+	}
+	select {
+	case ms.out <- memMessage{data: append([]byte{}, data...), arrival: arrival}:
+		return nil
+	case <-ms.closed:
+		return io.EOF
+	}
+}
+
+// Recv receives data from the other end of the link.  It must not be called concurrently.
+func (ms *memSession) Recv(timeout time.Duration) ([]byte, error) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	if ms.pending == nil {
+		select {
+		case msg := <-ms.in:
+			ms.pending = &msg
+		case <-ms.closed:
+			return nil, io.EOF
+		case <-timer.C:
+			return nil, ErrTimeout
+		}
+	}
+	if wait := time.Until(ms.pending.arrival); wait > 0 {
+		select {
+		case <-time.After(wait):
+		case <-ms.closed:
+			return nil, io.EOF
+		case <-timer.C:
+			return nil, ErrTimeout
+		}
+	}
+	data := ms.pending.data
+	ms.pending = nil
+
+	return data, nil
+}
+
+// Close closes the session at both ends of the link.
+func (ms *memSession) Close() error {
+	ms.once.Do(func() {
+		close(ms.closed)
+	})
+
+	return nil
+}
@@ -0,0 +1,256 @@
+package netceptor
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+	"time"
+)
+
+// startMemSessions starts both ends of an in-memory link and returns their sessions.
+func startMemSessions(ctx context.Context, t *testing.T, cond MemLinkConditions) (BackendSession, BackendSession) {
+	b1, b2, err := NewMemBackendPair(cond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wg := &sync.WaitGroup{}
+	sessChans := make([]chan BackendSession, 0)
+	for _, b := range []*MemBackend{b1, b2} {
+		sessChan, err := b.Start(ctx, wg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sessChans = append(sessChans, sessChan)
+	}
+	sessions := make([]BackendSession, 0)
+	for _, sessChan := range sessChans {
+		select {
+		case sess := <-sessChan:
+			sessions = append(sessions, sess)
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for in-memory session")
+		}
+	}
+
+	return sessions[0], sessions[1]
+}
+
+func TestMemBackendConditions(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if _, _, err := NewMemBackendPair(MemLinkConditions{DropRate: 1.5}); err == nil {
+		t.Error("expected a drop rate above 1 to be refused")
+	}
+	if _, _, err := NewMemBackendPair(MemLinkConditions{Latency: -time.Second}); err == nil {
+		t.Error("expected a negative latency to be refused")
+	}
+
+	// Messages arrive after the latency, in order despite jitter
+	s1, s2 := startMemSessions(ctx, t, MemLinkConditions{Latency: 100 * time.Millisecond, Jitter: 50 * time.Millisecond})
+	sent := time.Now()
+	for i := 0; i < 10; i++ {
+		if err := s1.Send([]byte(fmt.Sprintf("msg%d", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := s2.Recv(50 * time.Millisecond); err != ErrTimeout {
+		t.Fatalf("expected a timeout before the latency had passed, got %v", err)
+	}
+	for i := 0; i < 10; i++ {
+		data, err := s2.Recv(time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != fmt.Sprintf("msg%d", i) {
+			t.Fatalf("expected msg%d, got %s", i, data)
+		}
+	}
+	if elapsed := time.Since(sent); elapsed < 100*time.Millisecond {
+		t.Errorf("expected messages to take at least 100ms, took %s", elapsed)
+	}
+
+	// Closing one end closes the other
+	if err := s1.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s2.Recv(time.Second); err != io.EOF {
+		t.Errorf("expected EOF after the session closed, got %v", err)
+	}
+	if err := s2.Send([]byte("late")); err != io.EOF {
+		t.Errorf("expected EOF sending on a closed session, got %v", err)
+	}
+
+	// A fraction of messages is dropped
+	s1, s2 = startMemSessions(ctx, t, MemLinkConditions{DropRate: 0.5})
+	const count = 1000
+	for i := 0; i < count; i++ {
+		if err := s1.Send([]byte("x")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	received := 0
+	for {
+		if _, err := s2.Recv(100 * time.Millisecond); err != nil {
+			break
+		}
+		received++
+	}
+	if received < count/4 || received > count*3/4 {
+		t.Errorf("expected about half of %d messages to arrive, got %d", count, received)
+	}
+}
+
+// newMemMesh connects nodes by in-memory links, given as pairs of node names, and waits for every node
+// to have a route to every other.
+func newMemMesh(ctx context.Context, t *testing.T, names []string, links [][2]string,
+	cond MemLinkConditions) (map[string]*Netceptor, map[[2]string]*MemBackend) {
+	nodes := make(map[string]*Netceptor)
+	for _, name := range names {
+		nodes[name] = New(ctx, name, nil)
+	}
+	backends := make(map[[2]string]*MemBackend)
+	for _, link := range links {
+		b1, b2, err := NewMemBackendPair(cond)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := nodes[link[0]].AddBackend(b1, 1.0, nil); err != nil {
+			t.Fatal(err)
+		}
+		if err := nodes[link[1]].AddBackend(b2, 1.0, nil); err != nil {
+			t.Fatal(err)
+		}
+		backends[link] = b1
+	}
+	waitForRoutes(t, nodes)
+
+	return nodes, backends
+}
+
+// waitForRoutes waits for every node to have a route to every other.
+func waitForRoutes(t *testing.T, nodes map[string]*Netceptor) {
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		converged := true
+		for name, n := range nodes {
+			routes := n.Status().RoutingTable
+			for other := range nodes {
+				if _, ok := routes[other]; !ok && other != name {
+					converged = false
+				}
+			}
+		}
+		if converged {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for routes to converge")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestMemBackendMesh(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// A ring A-B-C-D-E-A, so losing any one link leaves another path
+	names := []string{"A", "B", "C", "D", "E"}
+	links := [][2]string{{"A", "B"}, {"B", "C"}, {"C", "D"}, {"D", "E"}, {"E", "A"}}
+	nodes, backends := newMemMesh(ctx, t, names, links, MemLinkConditions{Latency: 5 * time.Millisecond})
+	defer func() {
+		for _, n := range nodes {
+			n.Shutdown()
+		}
+	}()
+	pcA, err := nodes["A"].ListenPacket("sender")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pcC, err := nodes["C"].ListenPacket("echo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	exchange := func(msg string) {
+		if _, err := pcA.WriteTo([]byte(msg), nodes["A"].NewAddr("C", "echo")); err != nil {
+			t.Fatal(err)
+		}
+		_ = pcC.SetReadDeadline(time.Now().Add(5 * time.Second))
+		buf := make([]byte, 16)
+		n, _, err := pcC.ReadFrom(buf)
+		if err != nil || string(buf[:n]) != msg {
+			t.Fatalf("expected C to receive %q, got %q (%v)", msg, buf[:n], err)
+		}
+	}
+	exchange("via B")
+
+	// With A-B down, A reaches C the long way round
+	backends[[2]string{"A", "B"}].Disconnect()
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		if route, ok := nodes["A"].Status().RoutingTable["C"]; ok && route == "E" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for A to route to C via E, routes are %v", nodes["A"].Status().RoutingTable)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	exchange("via E")
+
+	backends[[2]string{"A", "B"}].Reconnect()
+	deadline = time.Now().Add(10 * time.Second)
+	for {
+		if route := nodes["A"].Status().RoutingTable["C"]; route == "B" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for A to route to C via B again")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestMemBackendDial(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	names := []string{"A", "B", "C", "D"}
+	links := [][2]string{{"A", "B"}, {"B", "C"}, {"C", "D"}}
+	nodes, _ := newMemMesh(ctx, t, names, links, MemLinkConditions{Latency: 2 * time.Millisecond, Jitter: 2 * time.Millisecond})
+	defer func() {
+		for _, n := range nodes {
+			n.Shutdown()
+		}
+	}()
+	li, err := nodes["D"].Listen("echo", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer li.Close()
+	go func() {
+		conn, err := li.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, _ = io.Copy(conn, conn)
+	}()
+	conn, err := nodes["A"].Dial("D", "echo", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.SetDeadline(time.Now().Add(10 * time.Second))
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "hello" {
+		t.Errorf("expected the echo service on D to return hello, got %q", buf)
+	}
+}
@@ -0,0 +1,107 @@
+package netceptor
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// DefaultMessageSizeBuckets are the upper bounds, in bytes, of the buckets of a new Netceptor instance's
+// message size histograms.
+var DefaultMessageSizeBuckets = []int{64, 256, 1024, 4096, 16384, 65536}
+
+// MessageSizeBucket is the number of messages whose size fell in a histogram bucket.
+type MessageSizeBucket struct {
+	// Max is the largest size in bytes counted by the bucket, or 0 for the last bucket, which has no limit.
+	Max   int
+	Count uint64
+}
+
+// MessageSizeHistogram summarizes the sizes of the messages sent or received on a backend session.  Each
+// message is counted in the first bucket whose Max is at least its size.
+type MessageSizeHistogram struct {
+	Count   uint64
+	Bytes   uint64
+	Buckets []MessageSizeBucket
+}
+
+// SessionMessageSizes holds the message size histograms of a backend session.
+type SessionMessageSizes struct {
+	Sent     MessageSizeHistogram
+	Received MessageSizeHistogram
+}
+
+// messageSizeHistogram counts message sizes into fixed buckets.  It is updated with atomic operations so
+// that recording a message costs no more than a few additions.
+type messageSizeHistogram struct {
+	bounds []int
+	counts []uint64
+	count  uint64
+	bytes  uint64
+}
+
+func newMessageSizeHistogram(bounds []int) *messageSizeHistogram {
+	return &messageSizeHistogram{
+		bounds: bounds,
+		counts: make([]uint64, len(bounds)+1),
+	}
+}
+
+// record counts a message of n bytes.
+func (h *messageSizeHistogram) record(n int) {
+	i := 0
+	for i < len(h.bounds) && n > h.bounds[i] {
+		i++
+	}
+	atomic.AddUint64(&h.counts[i], 1)
+	atomic.AddUint64(&h.count, 1)
+	atomic.AddUint64(&h.bytes, uint64(n))
+}
+
+// snapshot returns the current counts.  Messages recorded while it runs may be counted in some totals
+// and not others.
+func (h *messageSizeHistogram) snapshot() MessageSizeHistogram {
+	mh := MessageSizeHistogram{
+		Count:   atomic.LoadUint64(&h.count),
+		Bytes:   atomic.LoadUint64(&h.bytes),
+		Buckets: make([]MessageSizeBucket, len(h.counts)),
+	}
+	for i := range h.counts {
+		mh.Buckets[i].Count = atomic.LoadUint64(&h.counts[i])
+		if i < len(h.bounds) {
+			mh.Buckets[i].Max = h.bounds[i]
+		}
+	}
+
+	return mh
+}
+
+// newMessageSizeHistograms returns the sent and received histograms for a new backend session.
+func (s *Netceptor) newMessageSizeHistograms() (*messageSizeHistogram, *messageSizeHistogram) {
+	return newMessageSizeHistogram(s.messageSizeBuckets), newMessageSizeHistogram(s.messageSizeBuckets)
+}
+
+// MessageSizeBuckets returns the upper bounds, in bytes, of the buckets of message size histograms.
+func (s *Netceptor) MessageSizeBuckets() []int {
+	return append([]int(nil), s.messageSizeBuckets...)
+}
+
+// SetMessageSizeBuckets sets the upper bounds, in bytes, of the buckets into which the sizes of the
+// messages sent and received on each backend session are counted.  Messages larger than the last bound
+// are counted in a final bucket of their own.  The bounds must be positive and increasing.  It is only
+// effective if used prior to adding backends.
+func (s *Netceptor) SetMessageSizeBuckets(bounds []int) error {
+	if len(bounds) == 0 {
+		return fmt.Errorf("message size buckets must not be empty")
+	}
+	for i, b := range bounds {
+		if b <= 0 {
+			return fmt.Errorf("message size bucket %d must be positive", b)
+		}
+		if i > 0 && b <= bounds[i-1] {
+			return fmt.Errorf("message size buckets must be in increasing order")
+		}
+	}
+	s.messageSizeBuckets = append([]int(nil), bounds...)
+
+	return nil
+}
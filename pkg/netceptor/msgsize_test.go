@@ -0,0 +1,103 @@
+package netceptor
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestMessageSizeHistogram(t *testing.T) {
+	h := newMessageSizeHistogram([]int{10, 100, 1000})
+	for _, n := range []int{0, 10, 11, 50, 100, 101, 1000, 1001, 5000} {
+		h.record(n)
+	}
+	mh := h.snapshot()
+	if mh.Count != 9 || mh.Bytes != 7273 {
+		t.Fatalf("expected 9 messages of 7273 bytes, got %d of %d", mh.Count, mh.Bytes)
+	}
+	expected := []MessageSizeBucket{{Max: 10, Count: 2}, {Max: 100, Count: 3}, {Max: 1000, Count: 2}, {Max: 0, Count: 2}}
+	if fmt.Sprint(mh.Buckets) != fmt.Sprint(expected) {
+		t.Fatalf("expected buckets %v, got %v", expected, mh.Buckets)
+	}
+}
+
+func TestSetMessageSizeBuckets(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	n := New(ctx, "node1", nil)
+	if fmt.Sprint(n.MessageSizeBuckets()) != fmt.Sprint(DefaultMessageSizeBuckets) {
+		t.Fatalf("expected the default buckets, got %v", n.MessageSizeBuckets())
+	}
+	for _, bounds := range [][]int{nil, {0, 10}, {-1}, {100, 10}, {10, 10}} {
+		if err := n.SetMessageSizeBuckets(bounds); err == nil {
+			t.Fatalf("expected buckets %v to be refused", bounds)
+		}
+	}
+	if err := n.SetMessageSizeBuckets([]int{10, 20}); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(n.MessageSizeBuckets()) != "[10 20]" {
+		t.Fatalf("expected the buckets to be changed, got %v", n.MessageSizeBuckets())
+	}
+}
+
+func TestBackendMessageSizes(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	a := New(ctx, "A", nil)
+	b := New(ctx, "B", nil)
+	// Routing and service advertisements are smaller than the first bucket, so only the test's messages
+	// are counted in the others
+	for _, n := range []*Netceptor{a, b} {
+		if err := n.SetMessageSizeBuckets([]int{2000, 4000}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	linkNodes(t, a, b, nil)
+	waitForPathCost(t, a, "B", 1)
+	pcB, err := b.ListenPacket("sizes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pcB.Close()
+	pcA, err := a.ListenPacket("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pcA.Close()
+	for _, size := range []int{3000, 3500, 5000} {
+		if _, err := pcA.WriteTo(make([]byte, size), b.NewAddr("B", "sizes")); err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, 8192)
+		_ = pcB.SetReadDeadline(time.Now().Add(5 * time.Second))
+		if n, _, err := pcB.ReadFrom(buf); err != nil || n != size {
+			t.Fatalf("expected to receive %d bytes, got %d (%v)", size, n, err)
+		}
+	}
+
+	sizes := func(n *Netceptor, remote string) SessionMessageSizes {
+		health := n.BackendHealth()
+		if len(health) != 1 {
+			t.Fatalf("expected one backend, got %d", len(health))
+		}
+		ms, ok := health[0].MessageSizes[remote]
+		if !ok {
+			t.Fatalf("expected message sizes for the connection to %s, got %v", remote, health[0].MessageSizes)
+		}
+
+		return ms
+	}
+	for _, mh := range []MessageSizeHistogram{sizes(a, "B").Sent, sizes(b, "A").Received} {
+		if len(mh.Buckets) != 3 || mh.Buckets[1].Max != 4000 || mh.Buckets[1].Count != 2 || mh.Buckets[2].Count != 1 {
+			t.Fatalf("expected two messages of up to 4000 bytes and one larger, got %+v", mh.Buckets)
+		}
+		if mh.Count != mh.Buckets[0].Count+3 || mh.Bytes < 11500 {
+			t.Fatalf("expected the totals to include the test messages, got %+v", mh)
+		}
+	}
+	if ms := sizes(b, "A").Sent; ms.Buckets[1].Count != 0 || ms.Buckets[2].Count != 0 {
+		t.Fatalf("expected nothing large to be sent back, got %+v", ms.Buckets)
+	}
+}
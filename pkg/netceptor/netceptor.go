@@ -3,10 +3,11 @@ package netceptor
 
 import (
 	"context"
+	"crypto"
 	"crypto/tls"
 	"crypto/x509"
-	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"math"
@@ -14,11 +15,13 @@ import (
 	"reflect"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/ansible/receptor/pkg/logger"
 	"github.com/ansible/receptor/pkg/randstr"
 	"github.com/ansible/receptor/pkg/tickrunner"
+	receptortls "github.com/ansible/receptor/pkg/tls"
 	"github.com/ansible/receptor/pkg/utils"
 	priorityQueue "github.com/jupp0r/go-priority-queue"
 	"github.com/minio/highwayhash"
@@ -42,6 +45,9 @@ const defaultMaxForwardingHops = 30
 // defaultMaxConnectionIdleTime is the maximum time a connection can go without data before we consider it failed.
 const defaultMaxConnectionIdleTime = 2*defaultRouteUpdateTime + 1*time.Second
 
+// defaultSendTimeout is the longest a backend session may block sending a message before the connection is closed.
+const defaultSendTimeout = 60 * time.Second
+
 // MainInstance is the global instance of Netceptor instantiated by the command-line main() function.
 var MainInstance *Netceptor
 
@@ -52,6 +58,15 @@ type ErrorFunc func(error, bool)
 // ErrTimeout is returned for an expired deadline.
 var ErrTimeout error = &TimeoutError{}
 
+// ErrSendTimeout is returned by a backend session's Send when its write deadline passes before the message is sent.
+var ErrSendTimeout = errors.New("backend send deadline exceeded")
+
+// ErrAlreadyListening is wrapped in the error returned when listening on a service that is already in use.
+var ErrAlreadyListening = errors.New("already listening")
+
+// MaxServiceNameLength is the longest service name, in bytes.
+const MaxServiceNameLength = 8
+
 // TimeoutError is returned for an expired deadline.
 type TimeoutError struct{}
 
@@ -64,6 +79,13 @@ func (e *TimeoutError) Timeout() bool { return true }
 // Temporary returns true if a retry is likely a good idea.
 func (e *TimeoutError) Temporary() bool { return true }
 
+// gracefulCloseError is implemented by errors that a BackendSession returns from Recv when the peer
+// closed the session, saying whether it was closed on purpose.
+type gracefulCloseError interface {
+	error
+	Graceful() bool
+}
+
 // Backend is the interface for back-ends that the Receptor network can run over.
 type Backend interface {
 	Start(context.Context, *sync.WaitGroup) (chan BackendSession, error)
@@ -79,6 +101,25 @@ type BackendSession interface {
 	Close() error
 }
 
+// CompressionReporter is implemented by backend sessions that can report whether their connection
+// negotiated compression.
+type CompressionReporter interface {
+	CompressionNegotiated() bool
+}
+
+// WriteDeadlineSession is implemented by backend sessions that can bound how long a Send blocks.  Once the
+// deadline passes, Send fails with an error wrapping ErrSendTimeout.  Reads need no deadline, because Recv
+// already takes a timeout.
+type WriteDeadlineSession interface {
+	SetWriteDeadline(time.Time) error
+}
+
+// ListenPathSession is implemented by backend sessions that know which listener path the peer connected to,
+// such as the sessions of a websocket listener serving several paths.
+type ListenPathSession interface {
+	ListenPath() string
+}
+
 // Netceptor is the main object of the Receptor mesh network protocol.
 type Netceptor struct {
 	nodeID                 string
@@ -87,9 +128,20 @@ type Netceptor struct {
 	serviceAdTime          time.Duration
 	seenUpdateExpireTime   time.Duration
 	maxForwardingHops      byte
+	expiredMessages        uint64
+	expiredWarnLock        *sync.Mutex
+	expiredWarnedAt        time.Time
+	expiredUnwarned        uint64
 	maxConnectionIdleTime  time.Duration
+	sendTimeout            time.Duration
+	messageSizeBuckets     []int
 	allowedPeers           []string
+	roleLock               *sync.RWMutex
+	role                   string
+	displayNameLock        *sync.RWMutex
+	displayName            string
 	workCommands           []string
+	workCommandInfoFunc    func() map[string]WorkCommandInfo
 	epoch                  uint64
 	sequence               uint64
 	connLock               *sync.RWMutex
@@ -99,11 +151,23 @@ type Netceptor struct {
 	seenUpdatesLock        *sync.RWMutex
 	seenUpdates            map[string]time.Time
 	knownConnectionCosts   map[string]map[string]float64
+	knownLinkCosts         map[string]map[string]LinkCost
+	knownCostFactors       map[string]map[string]float64
+	snapshotNodes          map[string]time.Time
+	snapshotTTL            time.Duration
+	routeMaxAge            time.Duration
+	routeMaxAgeRunning     bool
 	routingTableLock       *sync.RWMutex
 	routingTable           map[string]string
 	routingPathCosts       map[string]float64
+	classRoutingTables     map[byte]map[string]string
 	listenerLock           *sync.RWMutex
 	listenerRegistry       map[string]*PacketConn
+	readinessGates         map[string]time.Duration
+	backendReady           chan struct{}
+	backendReadyOnce       *sync.Once
+	serviceAdsSent         chan struct{}
+	serviceAdsSentOnce     *sync.Once
 	sendRouteFloodChan     chan time.Duration
 	updateRoutingTableChan chan time.Duration
 	context                context.Context
@@ -113,6 +177,8 @@ type Netceptor struct {
 	reservedServices       map[string]func(*messageData) error
 	serviceAdsLock         *sync.RWMutex
 	serviceAdsReceived     map[string]map[string]*ServiceAdvertisement
+	serviceCacheLock       *sync.Mutex
+	serviceCache           map[string]ServiceCacheEntry
 	sendServiceAdsChan     chan time.Duration
 	backendWaitGroup       sync.WaitGroup
 	backendCount           int
@@ -122,6 +188,29 @@ type Netceptor struct {
 	clientTLSConfigs       map[string]*tls.Config
 	unreachableBroker      *utils.Broker
 	routingUpdateBroker    *utils.Broker
+	reconnectSubsLock      *sync.Mutex
+	reconnectSubs          map[chan BackendReconnect]struct{}
+	clockSkewThreshold     time.Duration
+	reconvergeLock         *sync.Mutex
+	healthLock             *sync.Mutex
+	trafficLock            *sync.Mutex
+	traffic                map[trafficKey]*TrafficCounter
+	maxTrafficCounters     int
+	healthConfig           BackendHealthConfig
+	backendHealth          []*backendHealth
+	lastBackendID          int
+	reconvergeInterval     time.Duration
+	lastReconverge         time.Time
+	rejections             map[string]*ConnectionRejection
+	sessionTraceLock       *sync.RWMutex
+	sessionTraceDir        string
+	sessionTraceMaxSize    int64
+	routeSigningLock       *sync.RWMutex
+	routeSigningKey        crypto.Signer
+	routeVerifyKeys        map[string]crypto.PublicKey
+	updateRejections       map[string]*UpdateRejection
+	enforceRouteSignatures bool
+	now                    func() time.Time
 }
 
 // ConnStatus holds information about a single connection in the Status struct.
@@ -134,10 +223,17 @@ type ConnStatus struct {
 // view of the internal status of the Netceptor object.
 type Status struct {
 	NodeID               string
+	NodeDisplayName      string
 	Connections          []*ConnStatus
 	RoutingTable         map[string]string
 	Advertisements       []*ServiceAdvertisement
 	KnownConnectionCosts map[string]map[string]float64
+	KnownLinkCosts       map[string]map[string]LinkCost
+	ClockSkew            map[string]float64
+	NodeRoles            map[string]string
+	ExpiredMessages      uint64
+	ListenerConnections  map[string]ListenerConnStatus
+	SessionBuffers       BufferBudgetStatus
 }
 
 const (
@@ -149,6 +245,8 @@ const (
 	MsgTypeServiceAdvertisement = 2
 	// MsgTypeReject indicates a rejection (closure) of a backend connection.
 	MsgTypeReject = 3
+	// MsgTypeReconverge asks a neighbor to send a routing update right away.
+	MsgTypeReconverge = 4
 )
 
 const (
@@ -156,15 +254,18 @@ const (
 	ProblemServiceUnknown = "service unknown"
 	// ProblemExpiredInTransit occurs when a message's HopsToLive expires in transit.
 	ProblemExpiredInTransit = "message expired"
+	// ProblemTransitNode occurs when a message from another node arrives for a service on a transit node.
+	ProblemTransitNode = "service not offered by a transit node"
 )
 
 type messageData struct {
-	FromNode    string
-	FromService string
-	ToNode      string
-	ToService   string
-	HopsToLive  byte
-	Data        []byte
+	FromNode     string
+	FromService  string
+	ToNode       string
+	ToService    string
+	HopsToLive   byte
+	TrafficClass byte
+	Data         []byte
 }
 
 type connInfo struct {
@@ -173,12 +274,21 @@ type connInfo struct {
 	Context          context.Context
 	CancelFunc       context.CancelFunc
 	Cost             float64
+	LinkCost         *LinkCost
+	health           *backendHealth
+	session          BackendSession
 	lastReceivedData time.Time
+	clockSkew        clockSkewInfo
+	sendTimeout      time.Duration
+	sentSizes        *messageSizeHistogram
+	receivedSizes    *messageSizeHistogram
 }
 
 type nodeInfo struct {
 	Epoch    uint64
 	Sequence uint64
+	LastSeen time.Time
+	Role     string
 }
 
 type routingUpdate struct {
@@ -189,6 +299,14 @@ type routingUpdate struct {
 	Connections        map[string]float64
 	ForwardingNode     string
 	SuspectedDuplicate uint64
+	Timestamp          time.Time
+	TimeEcho           map[string]timeEcho `json:",omitempty"`
+	Role               string              `json:",omitempty"`
+	LinkCosts          map[string]LinkCost `json:",omitempty"`
+	CostFactors        map[string]float64  `json:",omitempty"`
+	Signature          []byte              `json:",omitempty"`
+	// SignedData is the marshaled update that Signature covers.
+	SignedData []byte `json:",omitempty"`
 }
 
 const (
@@ -208,12 +326,39 @@ type ServiceAdvertisement struct {
 	ConnType     byte
 	Tags         map[string]string
 	WorkCommands []string
+	// WorkCommandInfo describes the work commands, for nodes that provide it.
+	WorkCommandInfo map[string]WorkCommandInfo `json:",omitempty"`
+}
+
+// WorkCommandInfo describes a work command that a node's control service can run.
+type WorkCommandInfo struct {
+	// Params are the params that can be given when submitting work of this type.  Nil if not declared.
+	Params       []string
+	Reassignable bool
+	// Drained is true if the node is refusing new units of this type.
+	Drained bool
+	// ActiveUnits is the number of pending and running units of this type, as of the advertisement.
+	ActiveUnits int
+	// WaitTime summarizes how long recently started units of this type waited to start.  Nil if none did.
+	WaitTime *WaitTimeStats `json:",omitempty"`
+}
+
+// WaitTimeStats summarizes how long work units waited between being submitted and starting.
+type WaitTimeStats struct {
+	// Units is the number of units the summary covers.
+	Units int
+	// P50 and P95 are the median and 95th percentile waits, in seconds.
+	P50 float64
+	P95 float64
 }
 
 // serviceAdvertisementFull is the whole message from the network.
 type serviceAdvertisementFull struct {
 	*ServiceAdvertisement
-	Cancel bool
+	Cancel    bool
+	Signature []byte `json:",omitempty"`
+	// SignedData is the marshaled advertisement that Signature covers.
+	SignedData []byte `json:",omitempty"`
 }
 
 // UnreachableMessage is the on-the-wire data associated with an unreachable message.
@@ -273,7 +418,13 @@ func NewWithConsts(ctx context.Context, nodeID string, allowedPeers []string,
 		seenUpdateExpireTime:   seenUpdateExpireTime,
 		maxForwardingHops:      maxForwardingHops,
 		maxConnectionIdleTime:  maxConnectionIdleTime,
+		sendTimeout:            defaultSendTimeout,
+		expiredWarnLock:        &sync.Mutex{},
+		messageSizeBuckets:     DefaultMessageSizeBuckets,
 		allowedPeers:           allowedPeers,
+		roleLock:               &sync.RWMutex{},
+		role:                   NodeRoleFull,
+		displayNameLock:        &sync.RWMutex{},
 		epoch:                  uint64(time.Now().Unix()*(1<<24)) + uint64(rand.Intn(1<<24)),
 		sequence:               0,
 		connLock:               &sync.RWMutex{},
@@ -283,17 +434,27 @@ func NewWithConsts(ctx context.Context, nodeID string, allowedPeers []string,
 		seenUpdatesLock:        &sync.RWMutex{},
 		seenUpdates:            make(map[string]time.Time),
 		knownConnectionCosts:   make(map[string]map[string]float64),
+		knownLinkCosts:         make(map[string]map[string]LinkCost),
+		knownCostFactors:       make(map[string]map[string]float64),
+		snapshotNodes:          make(map[string]time.Time),
 		routingTableLock:       &sync.RWMutex{},
 		routingTable:           make(map[string]string),
 		routingPathCosts:       make(map[string]float64),
 		listenerLock:           &sync.RWMutex{},
 		listenerRegistry:       make(map[string]*PacketConn),
+		readinessGates:         make(map[string]time.Duration),
+		backendReady:           make(chan struct{}),
+		backendReadyOnce:       &sync.Once{},
+		serviceAdsSent:         make(chan struct{}),
+		serviceAdsSentOnce:     &sync.Once{},
 		sendRouteFloodChan:     nil,
 		updateRoutingTableChan: nil,
 		hashLock:               &sync.RWMutex{},
 		nameHashes:             make(map[uint64]string),
 		serviceAdsLock:         &sync.RWMutex{},
 		serviceAdsReceived:     make(map[string]map[string]*ServiceAdvertisement),
+		serviceCacheLock:       &sync.Mutex{},
+		serviceCache:           make(map[string]ServiceCacheEntry),
 		sendServiceAdsChan:     nil,
 		backendWaitGroup:       sync.WaitGroup{},
 		backendCount:           0,
@@ -301,6 +462,22 @@ func NewWithConsts(ctx context.Context, nodeID string, allowedPeers []string,
 		networkName:            makeNetworkName(nodeID),
 		clientTLSConfigs:       make(map[string]*tls.Config),
 		serverTLSConfigs:       make(map[string]*tls.Config),
+		clockSkewThreshold:     DefaultClockSkewThreshold,
+		reconvergeLock:         &sync.Mutex{},
+		healthLock:             &sync.Mutex{},
+		reconnectSubsLock:      &sync.Mutex{},
+		reconnectSubs:          make(map[chan BackendReconnect]struct{}),
+		trafficLock:            &sync.Mutex{},
+		traffic:                make(map[trafficKey]*TrafficCounter),
+		maxTrafficCounters:     DefaultMaxTrafficCounters,
+		healthConfig:           DefaultBackendHealthConfig,
+		reconvergeInterval:     DefaultReconvergeInterval,
+		rejections:             make(map[string]*ConnectionRejection),
+		sessionTraceLock:       &sync.RWMutex{},
+		routeSigningLock:       &sync.RWMutex{},
+		routeVerifyKeys:        make(map[string]crypto.PublicKey),
+		updateRejections:       make(map[string]*UpdateRejection),
+		now:                    time.Now,
 	}
 	s.reservedServices = map[string]func(*messageData) error{
 		"ping":    s.handlePing,
@@ -332,6 +509,7 @@ func NewWithConsts(ctx context.Context, nodeID string, allowedPeers []string,
 		}()
 	}
 	go s.monitorConnectionAging()
+	go s.monitorBackendHealth()
 	go s.expireSeenUpdates()
 
 	return &s
@@ -397,22 +575,84 @@ func (s *Netceptor) MaxForwardingHops() byte {
 	return s.maxForwardingHops
 }
 
+// SetMaxForwardingHops sets the maximum number of times a message sent by this node may be forwarded.
+// Messages are dropped when they reach the limit, and the sender is notified.
+// It is only effective if used prior to adding backends.
+func (s *Netceptor) SetMaxForwardingHops(hops int) error {
+	if hops < 1 || hops > 255 {
+		return fmt.Errorf("maximum forwarding hops must be between 1 and 255")
+	}
+	s.maxForwardingHops = byte(hops)
+
+	return nil
+}
+
+// ExpiredMessages returns the number of messages this node has dropped because they reached their hop limit.
+func (s *Netceptor) ExpiredMessages() uint64 {
+	return atomic.LoadUint64(&s.expiredMessages)
+}
+
 // MaxConnectionIdleTime returns the configured MaxConnectionIdleTime of this Netceptor instance.
 func (s *Netceptor) MaxConnectionIdleTime() time.Duration {
 	return s.maxConnectionIdleTime
 }
 
+// SendTimeout returns how long a backend session may block sending a message before its connection is closed.
+func (s *Netceptor) SendTimeout() time.Duration {
+	return s.sendTimeout
+}
+
+// SetSendTimeout sets how long a backend session may block sending a message before its connection is
+// closed, so a peer that stops reading cannot stall the connection forever.  Zero disables the timeout.
+// It only applies to sessions that implement WriteDeadlineSession, which the TCP, UDP, websocket, long-poll
+// and stdio sessions do, though stdio only when its output supports deadlines, as pipes do.  It is only
+// effective if used prior to adding backends.
+func (s *Netceptor) SetSendTimeout(timeout time.Duration) error {
+	if timeout < 0 {
+		return fmt.Errorf("send timeout must not be negative")
+	}
+	s.sendTimeout = timeout
+
+	return nil
+}
+
 // AddBackend adds a backend to the Netceptor system.
 func (s *Netceptor) AddBackend(backend Backend, connectionCost float64, nodeCost map[string]float64) error {
+	return s.AddBackendWithOptions(backend, connectionCost, nodeCost, BackendOptions{})
+}
+
+// BackendOptions are the optional settings of a backend.
+type BackendOptions struct {
+	// LinkCost is a composite cost for the backend's connections, so that interactive and bulk traffic can
+	// be routed differently from other traffic.  If nil, only the connection cost is used.
+	LinkCost *LinkCost
+	// PathAllowedPeers maps a listener path to the node IDs that may connect on it.  Sessions that arrive on
+	// a path with no entry, or that do not implement ListenPathSession, are only subject to the node-wide
+	// allowed peers.
+	PathAllowedPeers map[string][]string
+}
+
+// AddBackendWithOptions is AddBackend with the settings in opts.
+func (s *Netceptor) AddBackendWithOptions(backend Backend, connectionCost float64, nodeCost map[string]float64,
+	opts BackendOptions) error {
+	if opts.LinkCost != nil {
+		if err := opts.LinkCost.Validate(); err != nil {
+			return err
+		}
+	}
 	ctxBackend, cancel := context.WithCancel(s.context)
-	s.backendCancel = append(s.backendCancel, cancel)
+	health := s.newBackendHealth(backend)
 	// Start() runs a go routine that attempts establish a session over this
 	// backend. For listeners, each time a peer dials this backend, sessChan is
 	// written to, resulting in multiple ongoing sessions at once.
-	sessChan, err := backend.Start(ctxBackend, &s.backendWaitGroup)
+	sessChan, err := backend.Start(s.withDialFailureFunc(ctxBackend, health), &s.backendWaitGroup)
 	if err != nil {
+		cancel()
+		s.removeBackendHealth(health)
+
 		return err
 	}
+	s.backendCancel = append(s.backendCancel, cancel)
 	s.backendWaitGroup.Add(1)
 	s.backendCount++
 	// Outer go routine -- this go routine waits for new sessions to be written to the sessChan and
@@ -437,9 +677,11 @@ func (s *Netceptor) AddBackend(backend Backend, connectionCost float64, nodeCost
 					// Start() method above)
 					go func() {
 						defer runProtocolWg.Done()
-						err := s.runProtocol(ctxBackend, sess, connectionCost, nodeCost)
+						err := s.runProtocol(ctxBackend, s.traceSession(sess), connectionCost, nodeCost, opts.LinkCost,
+							opts.PathAllowedPeers, health)
 						if err != nil {
 							logger.Error("Backend error: %s\n", err)
+							s.recordBackendError(health)
 						}
 					}()
 				} else {
@@ -479,6 +721,9 @@ func (s *Netceptor) CancelBackends() {
 	s.BackendWait()
 	s.backendCancel = nil
 	s.backendCount = 0
+	s.healthLock.Lock()
+	s.backendHealth = nil
+	s.healthLock.Unlock()
 }
 
 // Status returns the current state of the Netceptor object.
@@ -491,6 +736,7 @@ func (s *Netceptor) Status() Status {
 			Cost:   s.connections[conn].Cost,
 		})
 	}
+	clockSkew := s.clockSkewStatus()
 	s.connLock.RUnlock()
 	s.routingTableLock.RLock()
 	routes := make(map[string]string)
@@ -498,6 +744,7 @@ func (s *Netceptor) Status() Status {
 		routes[k] = v
 	}
 	s.routingTableLock.RUnlock()
+	workCommandInfo := s.workCommandInfo()
 	s.serviceAdsLock.RLock()
 	serviceAds := make([]*ServiceAdvertisement, 0)
 	for n := range s.serviceAdsReceived {
@@ -506,6 +753,7 @@ func (s *Netceptor) Status() Status {
 			if adCopy.NodeID == s.nodeID {
 				adCopy.Time = time.Now()
 				adCopy.WorkCommands = s.workCommands
+				adCopy.WorkCommandInfo = workCommandInfo
 			}
 			serviceAds = append(serviceAds, &adCopy)
 		}
@@ -519,14 +767,31 @@ func (s *Netceptor) Status() Status {
 			knownConnectionCosts[k1][k2] = v2
 		}
 	}
+	knownLinkCosts := make(map[string]map[string]LinkCost)
+	for k1, v1 := range s.knownLinkCosts {
+		if len(v1) == 0 {
+			continue
+		}
+		knownLinkCosts[k1] = make(map[string]LinkCost)
+		for k2, v2 := range v1 {
+			knownLinkCosts[k1][k2] = v2
+		}
+	}
 	s.knownNodeLock.RUnlock()
 
 	return Status{
 		NodeID:               s.nodeID,
+		NodeDisplayName:      s.NodeDisplayName(),
 		Connections:          conns,
 		RoutingTable:         routes,
 		Advertisements:       serviceAds,
 		KnownConnectionCosts: knownConnectionCosts,
+		KnownLinkCosts:       knownLinkCosts,
+		ClockSkew:            clockSkew,
+		NodeRoles:            s.NodeRoles(),
+		ExpiredMessages:      s.ExpiredMessages(),
+		ListenerConnections:  s.listenerConnections(),
+		SessionBuffers:       SessionBufferBudget.Status(),
 	}
 }
 
@@ -578,6 +843,10 @@ func (s *Netceptor) removeLocalServiceAdvertisement(service string) error {
 		},
 		Cancel: true,
 	}
+	err := s.signServiceAd(sa)
+	if err != nil {
+		return err
+	}
 	data, err := s.translateStructToNetwork(MsgTypeServiceAdvertisement, sa)
 	if err != nil {
 		return err
@@ -594,6 +863,10 @@ func (s *Netceptor) sendServiceAd(si *ServiceAdvertisement) error {
 		ServiceAdvertisement: si,
 		Cancel:               false,
 	}
+	err := s.signServiceAd(&sf)
+	if err != nil {
+		return err
+	}
 	data, err := s.translateStructToNetwork(MsgTypeServiceAdvertisement, sf)
 	if err != nil {
 		return err
@@ -605,10 +878,17 @@ func (s *Netceptor) sendServiceAd(si *ServiceAdvertisement) error {
 
 // Send advertisements for all advertised services.
 func (s *Netceptor) sendServiceAds() {
+	if s.backendIsReady() {
+		defer s.markServiceAdsSent()
+	}
+	if s.Role() == NodeRoleTransit {
+		return
+	}
 	ads := make([]ServiceAdvertisement, 0)
+	workCommandInfo := s.workCommandInfo()
 	s.listenerLock.RLock()
 	for sn := range s.listenerRegistry {
-		if s.listenerRegistry[sn].advertise {
+		if s.listenerRegistry[sn].advertise && !s.listenerRegistry[sn].adHeld {
 			sa := ServiceAdvertisement{
 				NodeID:   s.nodeID,
 				Service:  sn,
@@ -619,6 +899,7 @@ func (s *Netceptor) sendServiceAds() {
 			if svcType, ok := sa.Tags["type"]; ok {
 				if svcType == "Control Service" {
 					sa.WorkCommands = s.workCommands
+					sa.WorkCommandInfo = workCommandInfo
 				}
 			}
 			ads = append(ads, sa)
@@ -681,6 +962,35 @@ func (s *Netceptor) updateRoutingTable() {
 	defer s.knownNodeLock.RUnlock()
 	logger.Debug("Re-calculating routing table\n")
 
+	routingTable, cost := s.computeRoutes(TrafficClassDefault)
+	// Traffic classes only route differently once some connection has a composite cost
+	var classRoutingTables map[byte]map[string]string
+	for node := range s.knownLinkCosts {
+		if len(s.knownLinkCosts[node]) > 0 {
+			classRoutingTables = make(map[byte]map[string]string)
+			for _, class := range []byte{TrafficClassInteractive, TrafficClassBulk} {
+				classRoutingTables[class], _ = s.computeRoutes(class)
+			}
+
+			break
+		}
+	}
+	s.routingTableLock.Lock()
+	defer s.routingTableLock.Unlock()
+	s.routingTable = routingTable
+	s.routingPathCosts = cost
+	s.classRoutingTables = classRoutingTables
+	routingTableCopy := make(map[string]string)
+	for k, v := range s.routingTable {
+		routingTableCopy[k] = v
+	}
+	go s.routingUpdateBroker.Publish(routingTableCopy)
+	s.printRoutingTable()
+}
+
+// computeRoutes returns the next-hop table and path costs for a traffic class.
+// The caller must already hold at least a read lock on known connections.
+func (s *Netceptor) computeRoutes(class byte) (map[string]string, map[string]float64) {
 	// Dijkstra's algorithm
 	Q := priorityQueue.New()
 	Q.Insert(s.nodeID, 0.0)
@@ -698,7 +1008,20 @@ func (s *Netceptor) updateRoutingTable() {
 	for Q.Len() > 0 {
 		nodeIf, _ := Q.Pop()
 		node := fmt.Sprintf("%v", nodeIf)
+		if node != s.nodeID && s.knownNodeInfo[node] != nil && s.knownNodeInfo[node].Role == NodeRoleEdge {
+			// Edge nodes can be reached, but are never used as an intermediate hop
+			continue
+		}
 		for neighbor, edgeCost := range s.knownConnectionCosts[node] {
+			if class != TrafficClassDefault {
+				edgeCost = s.knownLinkCost(node, neighbor).costFor(class, edgeCost)
+			}
+			factor := s.knownCostFactor(node, neighbor)
+			if factor < 0 {
+				// The connection belongs to a backend that is down
+				continue
+			}
+			edgeCost *= factor
 			pathCost := cost[node] + edgeCost
 			if pathCost < cost[neighbor] {
 				cost[neighbor] = pathCost
@@ -707,14 +1030,12 @@ func (s *Netceptor) updateRoutingTable() {
 			}
 		}
 	}
-	s.routingTableLock.Lock()
-	defer s.routingTableLock.Unlock()
-	s.routingTable = make(map[string]string)
+	routingTable := make(map[string]string)
 	for dest := range s.knownConnectionCosts {
 		p := dest
 		for {
 			if prev[p] == s.nodeID {
-				s.routingTable[dest] = p
+				routingTable[dest] = p
 
 				break
 			} else if prev[p] == "" {
@@ -723,13 +1044,8 @@ func (s *Netceptor) updateRoutingTable() {
 			p = prev[p]
 		}
 	}
-	s.routingPathCosts = cost
-	routingTableCopy := make(map[string]string)
-	for k, v := range s.routingTable {
-		routingTableCopy[k] = v
-	}
-	go s.routingUpdateBroker.Publish(routingTableCopy)
-	s.printRoutingTable()
+
+	return routingTable, cost
 }
 
 // SubscribeRoutingUpdates subscribes for messages when the routing table is changed.
@@ -806,6 +1122,21 @@ func (s *Netceptor) AddWorkCommand(command string) error {
 	return nil
 }
 
+// SetWorkCommandInfoFunc sets a function that describes the work commands, to be included in service
+// announcements along with their names.  It is called each time the announcements are sent.
+func (s *Netceptor) SetWorkCommandInfoFunc(f func() map[string]WorkCommandInfo) {
+	s.workCommandInfoFunc = f
+}
+
+// workCommandInfo returns the description of the work commands, or nil if there is none.
+func (s *Netceptor) workCommandInfo() map[string]WorkCommandInfo {
+	if s.workCommandInfoFunc == nil {
+		return nil
+	}
+
+	return s.workCommandInfoFunc()
+}
+
 // SetServerTLSConfig stores a server TLS config by name.
 func (s *Netceptor) SetServerTLSConfig(name string, config *tls.Config) error {
 	if name == "" {
@@ -929,6 +1260,7 @@ func (s *Netceptor) GetClientTLSConfig(name string, expectedHostName string, exp
 	case expectedHostNameType == "receptor":
 		tlscfg.InsecureSkipVerify = true
 		tlscfg.VerifyPeerCertificate = s.receptorVerifyFunc(tlscfg, expectedHostName, VerifyServer)
+		receptortls.VerifyResumedSessions(tlscfg)
 	default:
 		tlscfg.ServerName = expectedHostName
 	}
@@ -992,36 +1324,31 @@ func stringFromFixedLenBytes(bytes []byte) string {
 	return string(bytes[:p+1])
 }
 
-// Given a fixed-length buffer, returns a string excluding any null (0) bytes on the right.
-func fixedLenBytesFromString(s string, l int) []byte {
-	bytes := make([]byte, l)
-	copy(bytes, s)
-
-	return bytes
-}
-
 // Translates an incoming message from wire protocol to messageData object.
 func (s *Netceptor) translateDataToMessage(data []byte) (*messageData, error) {
-	if len(data) < 36 {
-		return nil, fmt.Errorf("data too short to be a valid message")
+	hdr, payload, err := ParseHeader(data)
+	if err != nil {
+		return nil, err
 	}
-	fromNode, err := s.getNameFromHash(binary.BigEndian.Uint64(data[4:12]))
+	if hdr.Type != MsgTypeData {
+		return nil, fmt.Errorf("message type %d is not a data message", hdr.Type)
+	}
+	fromNode, err := s.getNameFromHash(hdr.FromNodeHash)
 	if err != nil {
 		return nil, err
 	}
-	toNode, err := s.getNameFromHash(binary.BigEndian.Uint64(data[12:20]))
+	toNode, err := s.getNameFromHash(hdr.ToNodeHash)
 	if err != nil {
 		return nil, err
 	}
-	fromService := stringFromFixedLenBytes(data[20:28])
-	toService := stringFromFixedLenBytes(data[28:36])
 	md := &messageData{
-		FromNode:    fromNode,
-		FromService: fromService,
-		ToNode:      toNode,
-		ToService:   toService,
-		HopsToLive:  data[1],
-		Data:        data[36:],
+		FromNode:     fromNode,
+		FromService:  hdr.FromService,
+		ToNode:       toNode,
+		ToService:    hdr.ToService,
+		HopsToLive:   hdr.HopsToLive,
+		TrafficClass: hdr.TrafficClass,
+		Data:         payload,
 	}
 
 	return md, nil
@@ -1029,21 +1356,53 @@ func (s *Netceptor) translateDataToMessage(data []byte) (*messageData, error) {
 
 // Translates an outgoing message from a messageData object to wire protocol.
 func (s *Netceptor) translateDataFromMessage(msg *messageData) ([]byte, error) {
-	data := make([]byte, 36+len(msg.Data))
-	data[0] = MsgTypeData
-	data[1] = msg.HopsToLive
-	binary.BigEndian.PutUint64(data[4:12], s.addNameHash(msg.FromNode))
-	binary.BigEndian.PutUint64(data[12:20], s.addNameHash(msg.ToNode))
-	copy(data[20:28], fixedLenBytesFromString(msg.FromService, 8))
-	copy(data[28:36], fixedLenBytesFromString(msg.ToService, 8))
-	copy(data[36:], msg.Data)
+	data := make([]byte, 0, MessageHeaderLength+len(msg.Data))
+	data = AppendHeader(data, &MessageHeader{
+		HopsToLive:   msg.HopsToLive,
+		TrafficClass: msg.TrafficClass,
+		FromNodeHash: s.addNameHash(msg.FromNode),
+		ToNodeHash:   s.addNameHash(msg.ToNode),
+		FromService:  msg.FromService,
+		ToService:    msg.ToService,
+	})
+
+	return append(data, msg.Data...), nil
+}
+
+// expiredWarningInterval is the shortest time between warnings about messages dropped at the hop limit.
+// A routing loop can drop many messages a second, so the ones in between are only counted.
+const expiredWarningInterval = 10 * time.Second
+
+// warnExpired logs that a message was dropped because it exceeded the hop limit, unless a warning was
+// logged less than expiredWarningInterval ago.  The next warning says how many drops went unlogged.
+func (s *Netceptor) warnExpired(md *messageData) {
+	s.expiredWarnLock.Lock()
+	now := s.now()
+	if !s.expiredWarnedAt.IsZero() && now.Sub(s.expiredWarnedAt) < expiredWarningInterval {
+		s.expiredUnwarned++
+		s.expiredWarnLock.Unlock()
 
-	return data, nil
+		return
+	}
+	unwarned := s.expiredUnwarned
+	s.expiredWarnedAt = now
+	s.expiredUnwarned = 0
+	s.expiredWarnLock.Unlock()
+	if unwarned == 0 {
+		logger.Warning("Dropping message from %s:%s to %s:%s because it exceeded the hop limit\n",
+			md.FromNode, md.FromService, md.ToNode, md.ToService)
+
+		return
+	}
+	logger.Warning("Dropping message from %s:%s to %s:%s because it exceeded the hop limit "+
+		"(%d more dropped since the last warning)\n", md.FromNode, md.FromService, md.ToNode, md.ToService, unwarned)
 }
 
 // Forwards a message to its next hop.
 func (s *Netceptor) forwardMessage(md *messageData) error {
 	if md.HopsToLive <= 0 {
+		atomic.AddUint64(&s.expiredMessages, 1)
+		s.warnExpired(md)
 		if md.FromService != "unreach" {
 			_ = s.sendUnreachable(md.FromNode, &UnreachableMessage{
 				FromNode:    md.FromNode,
@@ -1056,11 +1415,9 @@ func (s *Netceptor) forwardMessage(md *messageData) error {
 
 		return nil
 	}
-	s.routingTableLock.RLock()
-	nextHop, ok := s.routingTable[md.ToNode]
-	s.routingTableLock.RUnlock()
-	if !ok {
-		return fmt.Errorf("no route to node")
+	nextHop, err := s.RouteFor(md.ToNode, md.TrafficClass)
+	if err != nil {
+		return err
 	}
 	s.connLock.RLock()
 	c, ok := s.connections[nextHop]
@@ -1082,19 +1439,26 @@ func (s *Netceptor) forwardMessage(md *messageData) error {
 
 // Generates and sends a message over the Receptor network, specifying HopsToLive.
 func (s *Netceptor) sendMessageWithHopsToLive(fromService string, toNode string, toService string, data []byte, hopsToLive byte) error {
-	if len(fromService) > 8 || len(toService) > 8 {
+	return s.sendMessageWithClass(fromService, toNode, toService, data, hopsToLive, TrafficClassDefault)
+}
+
+// Generates and sends a message over the Receptor network, specifying HopsToLive and the traffic class.
+func (s *Netceptor) sendMessageWithClass(fromService string, toNode string, toService string, data []byte, hopsToLive byte,
+	trafficClass byte) error {
+	if len(fromService) > MaxServiceNameLength || len(toService) > MaxServiceNameLength {
 		return fmt.Errorf("service name too long")
 	}
 	if strings.EqualFold(toNode, "localhost") {
 		toNode = s.nodeID
 	}
 	md := &messageData{
-		FromNode:    s.nodeID,
-		FromService: fromService,
-		ToNode:      toNode,
-		ToService:   toService,
-		HopsToLive:  hopsToLive,
-		Data:        data,
+		FromNode:     s.nodeID,
+		FromService:  fromService,
+		ToNode:       toNode,
+		ToService:    toService,
+		HopsToLive:   hopsToLive,
+		TrafficClass: trafficClass,
+		Data:         data,
 	}
 	logger.Trace("--- Sending data length %d from %s:%s to %s:%s\n", len(md.Data),
 		md.FromNode, md.FromService, md.ToNode, md.ToService)
@@ -1152,11 +1516,20 @@ func (s *Netceptor) printRoutingTable() {
 // Constructs a routing update message.
 func (s *Netceptor) makeRoutingUpdate(suspectedDuplicate uint64) *routingUpdate {
 	s.sequence++
+	echoes := s.makeTimeEchoes()
 	s.connLock.RLock()
 	conns := make(map[string]float64)
+	var linkCosts map[string]LinkCost
 	for conn := range s.connections {
 		conns[conn] = s.connections[conn].Cost
+		if lc := s.connections[conn].LinkCost; lc != nil {
+			if linkCosts == nil {
+				linkCosts = make(map[string]LinkCost)
+			}
+			linkCosts[conn] = *lc
+		}
 	}
+	costFactors := s.connectionCostFactors()
 	s.connLock.RUnlock()
 	update := &routingUpdate{
 		NodeID:             s.nodeID,
@@ -1166,6 +1539,17 @@ func (s *Netceptor) makeRoutingUpdate(suspectedDuplicate uint64) *routingUpdate
 		Connections:        conns,
 		ForwardingNode:     s.nodeID,
 		SuspectedDuplicate: suspectedDuplicate,
+		Timestamp:          s.now(),
+		TimeEcho:           echoes,
+		LinkCosts:          linkCosts,
+		CostFactors:        costFactors,
+	}
+	if role := s.Role(); role != NodeRoleFull {
+		update.Role = role
+	}
+	err := s.signRoutingUpdate(update)
+	if err != nil {
+		logger.Error("Error signing routing update: %s\n", err)
 	}
 
 	return update
@@ -1234,6 +1618,18 @@ func (s *Netceptor) handleRoutingUpdate(ri *routingUpdate, recvConn string) {
 
 		return
 	}
+	err := s.verifyRoutingUpdate(ri)
+	if err != nil {
+		if s.routeSignaturesEnforced() {
+			logger.Warning("Rejecting routing update %s from %s via %s: %s\n", ri.UpdateID, ri.NodeID, recvConn, err)
+			s.recordUpdateRejection(ri.NodeID, err.Error())
+
+			return
+		}
+		if errors.Is(err, errInvalidRouteSignature) {
+			logger.Warning("Routing update %s from %s via %s has an invalid signature\n", ri.UpdateID, ri.NodeID, recvConn)
+		}
+	}
 	s.seenUpdatesLock.Lock()
 	_, ok := s.seenUpdates[ri.UpdateID]
 	if ok {
@@ -1275,10 +1671,28 @@ func (s *Netceptor) handleRoutingUpdate(ri *routingUpdate, recvConn string) {
 		}
 		ni.Epoch = ri.UpdateEpoch
 		ni.Sequence = ri.UpdateSequence
+		ni.LastSeen = s.now()
 		changed := false
 		if !reflect.DeepEqual(ri.Connections, s.knownConnectionCosts[ri.NodeID]) {
 			changed = true
 		}
+		if len(ri.LinkCosts) > 0 || len(s.knownLinkCosts[ri.NodeID]) > 0 {
+			if !reflect.DeepEqual(ri.LinkCosts, s.knownLinkCosts[ri.NodeID]) {
+				changed = true
+			}
+		}
+		if len(ri.CostFactors) > 0 || len(s.knownCostFactors[ri.NodeID]) > 0 {
+			if !reflect.DeepEqual(ri.CostFactors, s.knownCostFactors[ri.NodeID]) {
+				changed = true
+			}
+		}
+		if _, ok := s.snapshotNodes[ri.NodeID]; ok {
+			// Live data replaces what was loaded from the routing snapshot
+			delete(s.snapshotNodes, ri.NodeID)
+			changed = true
+		}
+		roleChanged := ni.Role != ri.Role
+		ni.Role = ri.Role
 		_, ok = s.knownNodeInfo[ri.NodeID]
 		if !ok {
 			_ = s.addNameHash(ri.NodeID)
@@ -1289,6 +1703,20 @@ func (s *Netceptor) handleRoutingUpdate(ri *routingUpdate, recvConn string) {
 			for k, v := range ri.Connections {
 				s.knownConnectionCosts[ri.NodeID][k] = v
 			}
+			delete(s.knownLinkCosts, ri.NodeID)
+			if len(ri.LinkCosts) > 0 {
+				s.knownLinkCosts[ri.NodeID] = make(map[string]LinkCost)
+				for k, v := range ri.LinkCosts {
+					s.knownLinkCosts[ri.NodeID][k] = v
+				}
+			}
+			delete(s.knownCostFactors, ri.NodeID)
+			if len(ri.CostFactors) > 0 {
+				s.knownCostFactors[ri.NodeID] = make(map[string]float64)
+				for k, v := range ri.CostFactors {
+					s.knownCostFactors[ri.NodeID][k] = v
+				}
+			}
 			for conn := range s.knownConnectionCosts {
 				if conn == s.nodeID {
 					continue
@@ -1296,11 +1724,13 @@ func (s *Netceptor) handleRoutingUpdate(ri *routingUpdate, recvConn string) {
 				_, ok = ri.Connections[conn]
 				if !ok {
 					delete(s.knownConnectionCosts[conn], ri.NodeID)
+					delete(s.knownLinkCosts[conn], ri.NodeID)
+					delete(s.knownCostFactors[conn], ri.NodeID)
 				}
 			}
 		}
 		s.knownNodeLock.Unlock()
-		if changed {
+		if changed || roleChanged {
 			s.updateRoutingTableChan <- 100 * time.Millisecond
 		}
 	}
@@ -1384,6 +1814,20 @@ func (s *Netceptor) handleMessageData(md *messageData) error {
 
 			return nil
 		}
+		// A transit node only relays traffic, so other nodes cannot reach its services.  Ephemeral services
+		// still receive the replies to connections the node makes itself.
+		if !pc.ephemeral && md.FromNode != s.nodeID && s.Role() == NodeRoleTransit {
+			s.listenerLock.RUnlock()
+			_ = s.sendUnreachable(md.FromNode, &UnreachableMessage{
+				FromNode:    md.FromNode,
+				ToNode:      md.ToNode,
+				FromService: md.FromService,
+				ToService:   md.ToService,
+				Problem:     ProblemTransitNode,
+			})
+
+			return nil
+		}
 		pc.recvChan <- md
 		s.listenerLock.RUnlock()
 
@@ -1420,6 +1864,20 @@ func (s *Netceptor) handleServiceAdvertisement(data []byte, receivedFrom string)
 	if err != nil {
 		return err
 	}
+	if si.ServiceAdvertisement == nil {
+		return fmt.Errorf("service advertisement is empty")
+	}
+	err = s.verifyServiceAd(si)
+	if err != nil {
+		if s.routeSignaturesEnforced() {
+			logger.Warning("Rejecting service advertisement for %s on %s via %s: %s\n", si.Service, si.NodeID, receivedFrom, err)
+
+			return nil
+		}
+		if errors.Is(err, errInvalidRouteSignature) {
+			logger.Warning("Service advertisement for %s on %s via %s has an invalid signature\n", si.Service, si.NodeID, receivedFrom)
+		}
+	}
 	logger.Debug("Received service advertisement %v\n", si)
 	s.serviceAdsLock.Lock()
 	defer s.serviceAdsLock.Unlock()
@@ -1463,7 +1921,12 @@ func (ci *connInfo) protoReader(sess BackendSession) {
 			continue
 		}
 		if err != nil {
-			if err != io.EOF && ci.Context.Err() == nil {
+			var ge gracefulCloseError
+			switch {
+			case err == io.EOF || ci.Context.Err() != nil:
+			case errors.As(err, &ge) && ge.Graceful():
+				logger.Info("Backend session closed: %s\n", err)
+			default:
 				logger.Error("Backend receiving error %s\n", err)
 			}
 			ci.CancelFunc()
@@ -1471,6 +1934,7 @@ func (ci *connInfo) protoReader(sess BackendSession) {
 			return
 		}
 		ci.lastReceivedData = time.Now()
+		ci.receivedSizes.record(len(buf))
 		ci.ReadChan <- buf
 	}
 }
@@ -1485,15 +1949,23 @@ func (ci *connInfo) protoWriter(sess BackendSession) {
 			if !more {
 				return
 			}
+			if wd, ok := sess.(WriteDeadlineSession); ok && ci.sendTimeout > 0 {
+				_ = wd.SetWriteDeadline(time.Now().Add(ci.sendTimeout))
+			}
 			err := sess.Send(message)
 			if err != nil {
-				if ci.Context.Err() == nil {
+				switch {
+				case ci.Context.Err() != nil:
+				case errors.Is(err, ErrSendTimeout):
+					logger.Error("Backend send blocked for more than %s, closing connection\n", ci.sendTimeout)
+				default:
 					logger.Error("Backend sending error %s\n", err)
 				}
 				ci.CancelFunc()
 
 				return
 			}
+			ci.sentSizes.record(len(message))
 		}
 	}
 }
@@ -1539,15 +2011,21 @@ func (s *Netceptor) sendRejectMessage(writeChan chan []byte) {
 
 func (s *Netceptor) sendAndLogConnectionRejection(remoteNodeID string, ci *connInfo, reason string) error {
 	s.sendRejectMessage(ci.WriteChan)
+	s.recordRejection(remoteNodeID, reason, false)
 
 	return fmt.Errorf("rejected connection with node %s because %s", remoteNodeID, reason)
 }
 
 // Main Netceptor protocol loop.
-func (s *Netceptor) runProtocol(ctx context.Context, sess BackendSession, connectionCost float64, nodeCost map[string]float64) error {
+func (s *Netceptor) runProtocol(ctx context.Context, sess BackendSession, connectionCost float64, nodeCost map[string]float64,
+	linkCost *LinkCost, pathAllowedPeers map[string][]string, health *backendHealth) error {
 	if connectionCost <= 0.0 {
 		return fmt.Errorf("connection cost must be positive")
 	}
+	listenPath := ""
+	if lp, ok := sess.(ListenPathSession); ok {
+		listenPath = lp.ListenPath()
+	}
 	established := false
 	remoteEstablished := false
 	remoteNodeID := ""
@@ -1557,9 +2035,14 @@ func (s *Netceptor) runProtocol(ctx context.Context, sess BackendSession, connec
 			s.connLock.Lock()
 			delete(s.connections, remoteNodeID)
 			s.connLock.Unlock()
+			s.recordSessionLost(health, remoteNodeID)
 			s.knownNodeLock.Lock()
 			delete(s.knownConnectionCosts[remoteNodeID], s.nodeID)
 			delete(s.knownConnectionCosts[s.nodeID], remoteNodeID)
+			delete(s.knownLinkCosts[remoteNodeID], s.nodeID)
+			delete(s.knownLinkCosts[s.nodeID], remoteNodeID)
+			delete(s.knownCostFactors[remoteNodeID], s.nodeID)
+			delete(s.knownCostFactors[s.nodeID], remoteNodeID)
 			s.knownNodeLock.Unlock()
 			done := false
 			select {
@@ -1574,10 +2057,15 @@ func (s *Netceptor) runProtocol(ctx context.Context, sess BackendSession, connec
 		}
 	}()
 	ci := &connInfo{
-		ReadChan:  make(chan []byte),
-		WriteChan: make(chan []byte),
-		Cost:      connectionCost,
-	}
+		ReadChan:    make(chan []byte),
+		WriteChan:   make(chan []byte),
+		Cost:        connectionCost,
+		LinkCost:    linkCost,
+		health:      health,
+		session:     sess,
+		sendTimeout: s.sendTimeout,
+	}
+	ci.sentSizes, ci.receivedSizes = s.newMessageSizeHistograms()
 	ci.Context, ci.CancelFunc = context.WithCancel(ctx)
 	go ci.protoReader(sess)
 	go ci.protoWriter(sess)
@@ -1586,6 +2074,11 @@ func (s *Netceptor) runProtocol(ctx context.Context, sess BackendSession, connec
 	for {
 		select {
 		case data := <-ci.ReadChan:
+			if len(data) == 0 {
+				logger.Warning("Ignoring empty message from backend\n")
+
+				continue
+			}
 			msgType := data[0]
 			if established {
 				switch msgType {
@@ -1617,6 +2110,7 @@ func (s *Netceptor) runProtocol(ctx context.Context, sess BackendSession, connec
 					}
 					if ri.NodeID == remoteNodeID {
 						// This is an update from our direct connection, so do some extra verification
+						s.handleClockSkew(ci, remoteNodeID, ri)
 						remoteCost, ok := ri.Connections[s.nodeID]
 						if !ok {
 							if remoteEstablished {
@@ -1641,8 +2135,11 @@ func (s *Netceptor) runProtocol(ctx context.Context, sess BackendSession, connec
 					}
 				case MsgTypeReject:
 					logger.Warning("Received a rejection message from peer.")
+					s.recordRejection(remoteNodeID, "remote node rejected the connection", true)
 
 					return fmt.Errorf("remote node rejected the connection")
+				case MsgTypeReconverge:
+					s.handleReconvergeRequest(remoteNodeID)
 				default:
 					logger.Warning("Unknown message type %d\n", msgType)
 				}
@@ -1670,23 +2167,18 @@ func (s *Netceptor) runProtocol(ctx context.Context, sess BackendSession, connec
 							break
 						}
 					}
+					allowedPeer := s.isAllowedPeer(remoteNodeID)
 					s.connLock.RUnlock()
 					if !remoteNodeAccepted {
 						return s.sendAndLogConnectionRejection(remoteNodeID, ci, "it connected using a node ID we are already connected to")
 					}
-					if s.allowedPeers != nil {
-						remoteNodeAccepted = false
-						for i := range s.allowedPeers {
-							if s.allowedPeers[i] == remoteNodeID {
-								remoteNodeAccepted = true
-
-								break
-							}
-						}
-					}
-					if !remoteNodeAccepted {
+					if !allowedPeer {
 						return s.sendAndLogConnectionRejection(remoteNodeID, ci, "it is not in the accepted connections list")
 					}
+					if !pathAllowsPeer(pathAllowedPeers, listenPath, remoteNodeID) {
+						return s.sendAndLogConnectionRejection(remoteNodeID, ci,
+							"it is not in the accepted connections list for path "+listenPath)
+					}
 
 					remoteNodeCost, ok := nodeCost[remoteNodeID]
 					if ok {
@@ -1700,7 +2192,11 @@ func (s *Netceptor) runProtocol(ctx context.Context, sess BackendSession, connec
 					case <-ctx.Done():
 						return nil
 					}
-					logger.Info("Connection established with %s\n", remoteNodeID)
+					if listenPath != "" {
+						logger.Info("Connection established with %s on path %s\n", remoteNodeID, listenPath)
+					} else {
+						logger.Info("Connection established with %s\n", remoteNodeID)
+					}
 					s.addNameHash(remoteNodeID)
 					s.connLock.Lock()
 					s.connections[remoteNodeID] = ci
@@ -1716,7 +2212,16 @@ func (s *Netceptor) runProtocol(ctx context.Context, sess BackendSession, connec
 						s.knownConnectionCosts[remoteNodeID] = make(map[string]float64)
 					}
 					s.knownConnectionCosts[remoteNodeID][s.nodeID] = connectionCost
+					if linkCost != nil {
+						if _, ok = s.knownLinkCosts[s.nodeID]; !ok {
+							s.knownLinkCosts[s.nodeID] = make(map[string]LinkCost)
+						}
+						s.knownLinkCosts[s.nodeID][remoteNodeID] = *linkCost
+					}
 					s.knownNodeLock.Unlock()
+					s.recordBackendSession(health, remoteNodeID)
+					s.markBackendReady()
+					s.applyCostFactors()
 					select {
 					case s.sendRouteFloodChan <- 0:
 					case <-ctx.Done():
@@ -1730,6 +2235,7 @@ func (s *Netceptor) runProtocol(ctx context.Context, sess BackendSession, connec
 					established = true
 				} else if msgType == MsgTypeReject {
 					logger.Warning("Received a rejection message from peer.")
+					s.recordRejection(remoteNodeID, "remote node rejected the connection", true)
 
 					return fmt.Errorf("remote node rejected the connection")
 				}
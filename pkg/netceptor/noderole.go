@@ -0,0 +1,80 @@
+package netceptor
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ansible/receptor/pkg/logger"
+)
+
+// Node roles, which control how a node takes part in the mesh.
+const (
+	// NodeRoleFull is a node that both relays traffic and offers services.  This is the default.
+	NodeRoleFull = "full"
+	// NodeRoleTransit is a node that only relays traffic, and does not offer any services to other nodes.
+	NodeRoleTransit = "transit"
+	// NodeRoleEdge is a node that offers services, but is never used to relay traffic between other nodes.
+	NodeRoleEdge = "edge"
+)
+
+// ValidateNodeRole checks that a node role is one of the known roles.
+func ValidateNodeRole(role string) error {
+	switch role {
+	case NodeRoleFull, NodeRoleTransit, NodeRoleEdge:
+		return nil
+	}
+
+	return fmt.Errorf("invalid node role %q: must be one of %s", role,
+		strings.Join([]string{NodeRoleFull, NodeRoleTransit, NodeRoleEdge}, ", "))
+}
+
+// SetRole sets the role of this node, which is included in its routing updates.  Other nodes will
+// not route through an edge node, and a transit node neither advertises its services nor accepts
+// traffic for them from other nodes.
+func (s *Netceptor) SetRole(role string) error {
+	if role == "" {
+		role = NodeRoleFull
+	}
+	err := ValidateNodeRole(role)
+	if err != nil {
+		return err
+	}
+	s.roleLock.Lock()
+	changed := s.role != role
+	s.role = role
+	s.roleLock.Unlock()
+	if changed {
+		logger.Info("Node role is %s\n", role)
+		s.sendRouteFloodChan <- 0
+		if role != NodeRoleTransit {
+			s.sendServiceAdsChan <- 0
+		}
+	}
+
+	return nil
+}
+
+// Role returns the role of this node.
+func (s *Netceptor) Role() string {
+	s.roleLock.RLock()
+	defer s.roleLock.RUnlock()
+
+	return s.role
+}
+
+// NodeRoles returns the roles of all known nodes other than full nodes.
+func (s *Netceptor) NodeRoles() map[string]string {
+	roles := make(map[string]string)
+	if role := s.Role(); role != NodeRoleFull {
+		roles[s.nodeID] = role
+	}
+	s.knownNodeLock.RLock()
+	defer s.knownNodeLock.RUnlock()
+	for node, ni := range s.knownNodeInfo {
+		if ni.Role != "" && ni.Role != NodeRoleFull {
+			roles[node] = ni.Role
+		}
+	}
+
+	return roles
+}
@@ -0,0 +1,135 @@
+package netceptor
+
+import (
+	"context"
+	"testing"
+)
+
+func receiveRoleUpdate(s *Netceptor, nodeID string, sequence uint64, role string, connections map[string]float64) {
+	s.handleRoutingUpdate(&routingUpdate{
+		NodeID:         nodeID,
+		UpdateID:       nodeID + string(rune('0'+sequence)),
+		UpdateEpoch:    1,
+		UpdateSequence: sequence,
+		Connections:    connections,
+		ForwardingNode: "E",
+		Role:           role,
+	}, "E")
+	s.updateRoutingTable()
+}
+
+func TestEdgeNodeNotUsedAsTransit(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := New(ctx, "A", nil)
+	defer s.Shutdown()
+
+	// A - E - B is the cheapest path to B, with a more expensive path A - C - D - B
+	s.knownNodeLock.Lock()
+	s.knownConnectionCosts["A"] = map[string]float64{"E": 1.0, "C": 2.0}
+	s.knownNodeLock.Unlock()
+	receiveRoleUpdate(s, "C", 1, "", map[string]float64{"A": 2.0, "D": 2.0})
+	receiveRoleUpdate(s, "D", 1, "", map[string]float64{"C": 2.0, "B": 2.0})
+	receiveRoleUpdate(s, "B", 1, "", map[string]float64{"D": 2.0, "E": 1.0})
+	receiveRoleUpdate(s, "E", 1, "", map[string]float64{"A": 1.0, "B": 1.0})
+	routes := s.Status().RoutingTable
+	if routes["B"] != "E" {
+		t.Fatalf("expected B to be routed via E, got %v", routes)
+	}
+
+	// Once E says it is an edge node, traffic to B goes the long way around
+	receiveRoleUpdate(s, "E", 2, NodeRoleEdge, map[string]float64{"A": 1.0, "B": 1.0})
+	status := s.Status()
+	if status.RoutingTable["B"] != "C" {
+		t.Fatalf("expected B to be routed via C, avoiding edge node E, got %v", status.RoutingTable)
+	}
+	cost, err := s.PathCost("B")
+	if err != nil || cost != 6.0 {
+		t.Fatalf("expected path cost 6 to B, got %f, %v", cost, err)
+	}
+	// The edge node itself is still reachable
+	if status.RoutingTable["E"] != "E" {
+		t.Fatalf("expected edge node E to be reachable directly, got %v", status.RoutingTable)
+	}
+	if status.NodeRoles["E"] != NodeRoleEdge || len(status.NodeRoles) != 1 {
+		t.Fatalf("expected E to be reported as an edge node, got %v", status.NodeRoles)
+	}
+
+	// And E can go back to being a full node
+	receiveRoleUpdate(s, "E", 3, "", map[string]float64{"A": 1.0, "B": 1.0})
+	if routes := s.Status().RoutingTable; routes["B"] != "E" {
+		t.Fatalf("expected B to be routed via E again, got %v", routes)
+	}
+}
+
+func TestSetRole(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := New(ctx, "A", nil)
+	defer s.Shutdown()
+
+	if s.Role() != NodeRoleFull || s.makeRoutingUpdate(0).Role != "" {
+		t.Fatalf("expected a new node to be a full node")
+	}
+	if err := s.SetRole("relay"); err == nil {
+		t.Fatal("expected an error setting an unknown role")
+	}
+	for _, role := range []string{NodeRoleTransit, NodeRoleEdge} {
+		if err := s.SetRole(role); err != nil {
+			t.Fatal(err)
+		}
+		if ru := s.makeRoutingUpdate(0); ru.Role != role {
+			t.Fatalf("expected routing update to have role %s, got %q", role, ru.Role)
+		}
+	}
+	if err := s.SetRole(""); err != nil || s.Role() != NodeRoleFull {
+		t.Fatalf("expected an empty role to mean a full node, got %s, %v", s.Role(), err)
+	}
+}
+
+func TestTransitNodeRefusesServices(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := New(ctx, "A", nil)
+	defer s.Shutdown()
+	pc, err := s.ListenPacket("svc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	if err := s.SetRole(NodeRoleTransit); err != nil {
+		t.Fatal(err)
+	}
+
+	// Messages from other nodes do not reach the service
+	err = s.handleMessageData(&messageData{FromNode: "B", FromService: "x", ToNode: "A", ToService: "svc", Data: []byte("hi")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case md := <-pc.recvChan:
+		t.Fatalf("transit node delivered a message from another node: %v", md)
+	default:
+	}
+
+	// But the node can still use its own services
+	go func() {
+		_ = s.handleMessageData(&messageData{FromNode: "A", FromService: "x", ToNode: "A", ToService: "svc", Data: []byte("hi")})
+	}()
+	md := <-pc.recvChan
+	if string(md.Data) != "hi" {
+		t.Fatalf("unexpected message %v", md)
+	}
+
+	// And a full node delivers messages from other nodes as usual
+	if err := s.SetRole(NodeRoleFull); err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		_ = s.handleMessageData(&messageData{FromNode: "B", FromService: "x", ToNode: "A", ToService: "svc", Data: []byte("hi")})
+	}()
+	md = <-pc.recvChan
+	if md.FromNode != "B" {
+		t.Fatalf("unexpected message %v", md)
+	}
+}
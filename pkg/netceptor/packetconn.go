@@ -14,25 +14,30 @@ import (
 type PacketConn struct {
 	s                  *Netceptor
 	localService       string
+	ephemeral          bool
 	recvChan           chan *messageData
 	readDeadline       time.Time
 	advertise          bool
+	adHeld             bool
 	adTags             map[string]string
 	connType           byte
 	hopsToLive         byte
+	trafficClass       byte
 	unreachableMsgChan chan interface{}
 	unreachableSubs    *utils.Broker
 	context            context.Context
 	cancel             context.CancelFunc
+	connLimiter        *connLimiter
 }
 
 // ListenPacket returns a datagram connection compatible with Go's net.PacketConn.
 // If service is blank, generates and uses an ephemeral service name.
 func (s *Netceptor) ListenPacket(service string) (*PacketConn, error) {
-	if len(service) > 8 {
+	if len(service) > MaxServiceNameLength {
 		return nil, fmt.Errorf("service name %s too long", service)
 	}
-	if service == "" {
+	ephemeral := service == ""
+	if ephemeral {
 		service = s.getEphemeralService()
 	}
 	s.listenerLock.Lock()
@@ -40,12 +45,13 @@ func (s *Netceptor) ListenPacket(service string) (*PacketConn, error) {
 	_, isReserved := s.reservedServices[service]
 	_, isListening := s.listenerRegistry[service]
 	if isReserved || isListening {
-		return nil, fmt.Errorf("service %s is already listening", service)
+		return nil, fmt.Errorf("service %s is %w", service, ErrAlreadyListening)
 	}
 	_ = s.addNameHash(service)
 	pc := &PacketConn{
 		s:            s,
 		localService: service,
+		ephemeral:    ephemeral,
 		recvChan:     make(chan *messageData),
 		advertise:    false,
 		adTags:       nil,
@@ -65,9 +71,14 @@ func (s *Netceptor) ListenPacketAndAdvertise(service string, tags map[string]str
 	if err != nil {
 		return nil, err
 	}
+	s.listenerLock.Lock()
 	pc.advertise = true
 	pc.adTags = tags
-	s.addLocalServiceAdvertisement(service, ConnTypeDatagram, tags)
+	held := s.holdAdvertisement(pc)
+	s.listenerLock.Unlock()
+	if !held {
+		s.addLocalServiceAdvertisement(service, ConnTypeDatagram, tags)
+	}
 
 	return pc, nil
 }
@@ -142,6 +153,7 @@ func (pc *PacketConn) ReadFrom(p []byte) (n int, addr net.Addr, err error) {
 		return 0, nil, fmt.Errorf("connection closed")
 	}
 	nCopied := copy(p, m.Data)
+	pc.s.countTraffic(m.FromNode, pc.trafficService(m.FromService), true, len(m.Data))
 	fromAddr := Addr{
 		network: pc.s.networkName,
 		node:    m.FromNode,
@@ -157,19 +169,36 @@ func (pc *PacketConn) WriteTo(p []byte, addr net.Addr) (n int, err error) {
 	if !ok {
 		return 0, fmt.Errorf("attempt to write to non-netceptor address")
 	}
-	err = pc.s.sendMessageWithHopsToLive(pc.localService, ncaddr.node, ncaddr.service, p, pc.hopsToLive)
+	err = pc.s.sendMessageWithClass(pc.localService, ncaddr.node, ncaddr.service, p, pc.hopsToLive, pc.trafficClass)
 	if err != nil {
 		return 0, err
 	}
+	pc.s.countTraffic(ncaddr.node, pc.trafficService(ncaddr.service), false, len(p))
 
 	return len(p), nil
 }
 
+// trafficService returns the service that traffic with a remote service is counted under.  Traffic on an
+// ephemeral service is counted under the remote service, so a client's traffic is grouped by what it talks to.
+func (pc *PacketConn) trafficService(remoteService string) string {
+	if pc.ephemeral {
+		return remoteService
+	}
+
+	return pc.localService
+}
+
 // SetHopsToLive sets the HopsToLive value for future outgoing packets on this connection.
 func (pc *PacketConn) SetHopsToLive(hopsToLive byte) {
 	pc.hopsToLive = hopsToLive
 }
 
+// SetTrafficClass sets the traffic class of future outgoing packets on this connection, which selects
+// the routes they take when connections have composite costs.
+func (pc *PacketConn) SetTrafficClass(class byte) {
+	pc.trafficClass = class
+}
+
 // LocalService returns the local service name of the connection.
 func (pc *PacketConn) LocalService() string {
 	return pc.localService
@@ -193,7 +222,7 @@ func (pc *PacketConn) Close() error {
 		pc.cancel()
 	}
 	close(pc.recvChan)
-	if pc.advertise {
+	if pc.advertise && !pc.adHeld {
 		err := pc.s.removeLocalServiceAdvertisement(pc.localService)
 		if err != nil {
 			return err
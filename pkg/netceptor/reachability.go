@@ -0,0 +1,70 @@
+package netceptor
+
+import (
+	"sort"
+	"time"
+)
+
+// NodeReachability is whether a known node can currently be reached from this node.
+type NodeReachability struct {
+	NodeID    string
+	Reachable bool
+	PathCost  float64
+	NextHop   string
+	LastSeen  *time.Time
+}
+
+// Reachability reports, for every node known from routing updates, whether it is reachable from
+// this node and at what cost.  It is built from the routing table, without probing the nodes.
+// The result is sorted by node ID.
+func (s *Netceptor) Reachability() []NodeReachability {
+	s.knownNodeLock.RLock()
+	defer s.knownNodeLock.RUnlock()
+	s.routingTableLock.RLock()
+	defer s.routingTableLock.RUnlock()
+	nodes := make(map[string]*NodeReachability)
+	addNode := func(nodeID string) *NodeReachability {
+		nr, ok := nodes[nodeID]
+		if !ok {
+			nr = &NodeReachability{NodeID: nodeID}
+			nodes[nodeID] = nr
+		}
+
+		return nr
+	}
+	for nodeID, ni := range s.knownNodeInfo {
+		nr := addNode(nodeID)
+		if !ni.LastSeen.IsZero() {
+			lastSeen := ni.LastSeen
+			nr.LastSeen = &lastSeen
+		}
+	}
+	for nodeID, conns := range s.knownConnectionCosts {
+		addNode(nodeID)
+		for neighbor := range conns {
+			addNode(neighbor)
+		}
+	}
+	self := addNode(s.nodeID)
+	self.Reachable = true
+	self.NextHop = s.nodeID
+	self.LastSeen = nil
+	for nodeID, nr := range nodes {
+		if nodeID == s.nodeID {
+			continue
+		}
+		nr.NextHop, nr.Reachable = s.routingTable[nodeID]
+		if nr.Reachable {
+			nr.PathCost = s.routingPathCosts[nodeID]
+		}
+	}
+	result := make([]NodeReachability, 0, len(nodes))
+	for _, nr := range nodes {
+		result = append(result, *nr)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].NodeID < result[j].NodeID
+	})
+
+	return result
+}
@@ -0,0 +1,60 @@
+package netceptor
+
+import (
+	"context"
+	"testing"
+)
+
+func TestReachability(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := New(ctx, "A", nil)
+	defer s.Shutdown()
+
+	// A - B - C, with D and E connected to each other but partitioned from the rest of the mesh
+	s.knownNodeLock.Lock()
+	s.knownConnectionCosts["A"] = map[string]float64{"B": 1.0}
+	s.knownNodeLock.Unlock()
+	receiveUpdate(s, "B", 1, map[string]float64{"A": 1.0, "C": 2.0})
+	receiveUpdate(s, "C", 1, map[string]float64{"B": 2.0})
+	receiveUpdate(s, "D", 1, map[string]float64{"E": 1.0})
+
+	expected := map[string]struct {
+		reachable bool
+		cost      float64
+	}{
+		"A": {true, 0},
+		"B": {true, 1},
+		"C": {true, 3},
+		"D": {false, 0},
+		"E": {false, 0},
+	}
+	r := s.Reachability()
+	if len(r) != len(expected) {
+		t.Fatalf("expected %d nodes, got %+v", len(expected), r)
+	}
+	for i, nr := range r {
+		if i > 0 && r[i-1].NodeID >= nr.NodeID {
+			t.Fatalf("nodes are not sorted: %+v", r)
+		}
+		exp, ok := expected[nr.NodeID]
+		if !ok {
+			t.Fatalf("unexpected node %s", nr.NodeID)
+		}
+		if nr.Reachable != exp.reachable || nr.PathCost != exp.cost {
+			t.Fatalf("expected %s to be reachable=%v with cost %f, got %+v", nr.NodeID, exp.reachable, exp.cost, nr)
+		}
+	}
+	for _, nr := range r {
+		switch nr.NodeID {
+		case "C":
+			if nr.NextHop != "B" || nr.LastSeen == nil {
+				t.Fatalf("unexpected reachability of C: %+v", nr)
+			}
+		case "E":
+			if nr.LastSeen != nil {
+				t.Fatalf("E was never seen in a routing update, got %+v", nr)
+			}
+		}
+	}
+}
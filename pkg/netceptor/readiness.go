@@ -0,0 +1,111 @@
+package netceptor
+
+import (
+	"time"
+
+	"github.com/ansible/receptor/pkg/logger"
+)
+
+// SetReadinessGate holds the advertisement of a service that is listened on later, until this node has
+// established a backend session.  If grace is positive, the service is advertised anyway once grace has
+// passed.  This keeps other nodes from routing to a service on a node that cannot be reached yet.
+func (s *Netceptor) SetReadinessGate(service string, grace time.Duration) {
+	s.listenerLock.Lock()
+	defer s.listenerLock.Unlock()
+	s.readinessGates[service] = grace
+}
+
+// serviceAdReadyDelay is how long after the first backend session the service advertisements are sent,
+// giving held advertisements time to be released first.
+const serviceAdReadyDelay = 100 * time.Millisecond
+
+// markBackendReady records that a backend session has been established, and sends the service
+// advertisements over it without waiting for the next regular broadcast.
+func (s *Netceptor) markBackendReady() {
+	s.backendReadyOnce.Do(func() {
+		close(s.backendReady)
+		go func() {
+			select {
+			case s.sendServiceAdsChan <- serviceAdReadyDelay:
+			case <-s.context.Done():
+			}
+		}()
+	})
+}
+
+// markServiceAdsSent records that the service advertisements have been sent since a backend session was
+// established.
+func (s *Netceptor) markServiceAdsSent() {
+	s.serviceAdsSentOnce.Do(func() {
+		close(s.serviceAdsSent)
+	})
+}
+
+// backendIsReady returns true if a backend session has ever been established.
+func (s *Netceptor) backendIsReady() bool {
+	select {
+	case <-s.backendReady:
+		return true
+	default:
+		return false
+	}
+}
+
+// holdAdvertisement holds the advertisement of a listener's service if the service has a readiness gate
+// and no backend session is up yet, and returns true if it did.  The caller must hold listenerLock.
+func (s *Netceptor) holdAdvertisement(pc *PacketConn) bool {
+	grace, gated := s.readinessGates[pc.localService]
+	if !gated || s.backendIsReady() {
+		return false
+	}
+	logger.Debug("Holding advertisement of service %s until a backend is up\n", pc.localService)
+	pc.adHeld = true
+	go s.releaseAdvertisement(pc, grace)
+
+	return true
+}
+
+// releaseAdvertisement advertises a held service once a backend session is up or grace has passed.
+func (s *Netceptor) releaseAdvertisement(pc *PacketConn, grace time.Duration) {
+	var graceChan <-chan time.Time
+	if grace > 0 {
+		timer := time.NewTimer(grace)
+		defer timer.Stop()
+		graceChan = timer.C
+	}
+	select {
+	case <-s.backendReady:
+	case <-graceChan:
+		logger.Warning("No backend is up after %s, advertising service %s anyway\n", grace, pc.localService)
+	case <-pc.context.Done():
+		return
+	}
+	s.listenerLock.Lock()
+	defer s.listenerLock.Unlock()
+	if s.listenerRegistry[pc.localService] != pc {
+		return
+	}
+	pc.adHeld = false
+	s.addLocalServiceAdvertisement(pc.localService, pc.connType, pc.adTags)
+}
+
+// NotifyWhenReady waits until a backend session has been established and, unless service advertisements
+// are disabled, the service advertisements have been sent over it, and then calls notify, so that a
+// supervisor can be told the node is up.  It returns the error from notify, or returns nil without
+// calling notify if the node is shut down first.
+func (s *Netceptor) NotifyWhenReady(notify func() error) error {
+	select {
+	case <-s.backendReady:
+	case <-s.context.Done():
+		return nil
+	}
+	if s.serviceAdTime > 0 {
+		select {
+		case <-s.serviceAdsSent:
+		case <-s.context.Done():
+			return nil
+		}
+	}
+
+	return notify()
+}
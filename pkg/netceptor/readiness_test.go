@@ -0,0 +1,194 @@
+package netceptor
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ansible/receptor/pkg/utils"
+)
+
+func hasAdvertisement(s *Netceptor, node string, service string) bool {
+	for _, ad := range s.Status().Advertisements {
+		if ad.NodeID == node && ad.Service == service {
+			return true
+		}
+	}
+
+	return false
+}
+
+func waitForAdvertisement(t *testing.T, s *Netceptor, node string, service string) {
+	deadline := time.Now().Add(10 * time.Second)
+	for !hasAdvertisement(s, node, service) {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s to see service %s on %s", s.NodeID(), service, node)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestReadinessGate(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	a := New(ctx, "A", nil)
+	b := New(ctx, "B", nil)
+	a.SetReadinessGate("gated", 0)
+	gated, err := a.ListenPacketAndAdvertise("gated", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer gated.Close()
+	open, err := a.ListenPacketAndAdvertise("open", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer open.Close()
+
+	// Without a backend, only the ungated service is advertised
+	if !hasAdvertisement(a, "A", "open") {
+		t.Fatal("expected the ungated service to be advertised")
+	}
+	time.Sleep(200 * time.Millisecond)
+	if hasAdvertisement(a, "A", "gated") {
+		t.Fatal("expected the gated service not to be advertised before a backend is up")
+	}
+
+	linkNodes(t, a, b, nil)
+	waitForAdvertisement(t, a, "A", "gated")
+	waitForAdvertisement(t, b, "A", "gated")
+
+	// Once a backend has been up, later listeners are advertised right away
+	a.SetReadinessGate("later", 0)
+	later, err := a.ListenPacketAndAdvertise("later", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer later.Close()
+	if !hasAdvertisement(a, "A", "later") {
+		t.Fatal("expected the service to be advertised once a backend is up")
+	}
+}
+
+func TestReadinessGateGrace(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	a := New(ctx, "A", nil)
+	a.SetReadinessGate("gated", 300*time.Millisecond)
+	gated, err := a.ListenPacketAndAdvertise("gated", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer gated.Close()
+	if hasAdvertisement(a, "A", "gated") {
+		t.Fatal("expected the gated service not to be advertised before the grace period")
+	}
+	waitForAdvertisement(t, a, "A", "gated")
+}
+
+func TestReadinessGateClosed(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	a := New(ctx, "A", nil)
+	b := New(ctx, "B", nil)
+	a.SetReadinessGate("gated", 0)
+	gated, err := a.ListenPacketAndAdvertise("gated", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := gated.Close(); err != nil {
+		t.Fatal(err)
+	}
+	linkNodes(t, a, b, nil)
+	waitForRoute(t, a, "B", TrafficClassDefault, "B")
+	time.Sleep(200 * time.Millisecond)
+	if hasAdvertisement(a, "A", "gated") {
+		t.Fatal("expected a service closed while held never to be advertised")
+	}
+}
+
+func TestNotifyWhenReady(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "receptor-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	filename := filepath.Join(tmpdir, "ready")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	a := New(ctx, "A", nil)
+	b := New(ctx, "B", nil)
+	svc, err := a.ListenPacketAndAdvertise("svc", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer svc.Close()
+	notified := make(chan error, 1)
+	go func() {
+		notified <- a.NotifyWhenReady(func() error {
+			return utils.WriteReadinessFile(filename)
+		})
+	}()
+
+	// A backend that has been added but has no session yet does not make the node ready
+	b1, b2, err := NewMemBackendPair(MemLinkConditions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.AddBackend(b1, 1.0, nil); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	if _, err := os.Stat(filename); !os.IsNotExist(err) {
+		t.Fatalf("expected no readiness file before a backend session is established, got %v", err)
+	}
+
+	// Once the other end starts, the session is established and the file is written
+	if err := b.AddBackend(b2, 1.0, nil); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-notified:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for the node to become ready")
+	}
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) == 0 {
+		t.Fatal("expected the readiness file to hold the process ID")
+	}
+
+	// The node's services were advertised before it became ready, without waiting for the next regular
+	// broadcast
+	waitForAdvertisement(t, b, "A", "svc")
+}
+
+func TestNotifyWhenReadyShutdown(t *testing.T) {
+	a := New(context.Background(), "A", nil)
+	notified := make(chan error, 1)
+	called := false
+	go func() {
+		notified <- a.NotifyWhenReady(func() error {
+			called = true
+
+			return nil
+		})
+	}()
+	a.Shutdown()
+	select {
+	case err := <-notified:
+		if err != nil || called {
+			t.Fatalf("expected a node shut down before it was ready not to notify, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for NotifyWhenReady to return after shutdown")
+	}
+}
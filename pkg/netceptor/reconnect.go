@@ -0,0 +1,126 @@
+package netceptor
+
+import (
+	"context"
+	"time"
+
+	"github.com/ansible/receptor/pkg/logger"
+)
+
+// BackendReconnect describes a backend that connected again after all of its connections were lost.
+type BackendReconnect struct {
+	Time time.Time
+	// ID and Backend identify the backend, as in BackendHealthStatus.
+	ID      int
+	Backend string
+	// Node is the remote node of the new connection.
+	Node string
+	// Downtime is how long the backend had no connections.
+	Downtime time.Duration
+	// Attempts is the number of tries it took to connect again, including the one that succeeded.
+	Attempts uint64
+}
+
+// reconnectSubscriberBuffer is how many reconnect events are held for a subscriber that is not reading.
+// Further events are dropped until it catches up.
+const reconnectSubscriberBuffer = 16
+
+// dialFailureKey is the context key of the function that counts a failed connection attempt of a backend.
+type dialFailureKey struct{}
+
+// ReportDialFailure records that the backend started with ctx failed to connect, so that the attempt is
+// counted when it reconnects.  Backends that redial call it each time a dial fails.
+func ReportDialFailure(ctx context.Context) {
+	if f, ok := ctx.Value(dialFailureKey{}).(func()); ok {
+		f()
+	}
+}
+
+// withDialFailureFunc returns the context a backend is started with, which counts its failed dials in bh.
+func (s *Netceptor) withDialFailureFunc(ctx context.Context, bh *backendHealth) context.Context {
+	return context.WithValue(ctx, dialFailureKey{}, func() {
+		s.healthLock.Lock()
+		defer s.healthLock.Unlock()
+		if !bh.downSince.IsZero() {
+			bh.attempts++
+		}
+	})
+}
+
+// recordSessionLost records that an established session of a backend with a remote node ended.  When it was
+// the backend's last connection, the backend is down until one is established again.
+func (s *Netceptor) recordSessionLost(bh *backendHealth, remoteNodeID string) {
+	s.healthLock.Lock()
+	defer s.healthLock.Unlock()
+	if bh.lost == nil {
+		bh.lost = make(map[string]bool)
+	}
+	bh.lost[remoteNodeID] = true
+	if bh.live > 0 {
+		bh.live--
+	}
+	if bh.live == 0 {
+		bh.downSince = s.now()
+		bh.attempts = 0
+	}
+}
+
+// backendReconnected returns the reconnect event of a backend that has just established a session, or nil
+// if it was not down or the outage was too short to report.  The caller must hold healthLock.
+func (s *Netceptor) backendReconnected(bh *backendHealth, remoteNodeID string, now time.Time) *BackendReconnect {
+	bh.live++
+	if bh.downSince.IsZero() {
+		return nil
+	}
+	ev := &BackendReconnect{
+		Time:     now,
+		ID:       bh.id,
+		Backend:  bh.name,
+		Node:     remoteNodeID,
+		Downtime: now.Sub(bh.downSince),
+		Attempts: bh.attempts + 1,
+	}
+	bh.downSince = time.Time{}
+	bh.attempts = 0
+	if ev.Downtime < s.healthConfig.ReconnectNotifyDowntime {
+		return nil
+	}
+
+	return ev
+}
+
+// notifyReconnect logs a reconnect event and sends it to the subscribers.
+func (s *Netceptor) notifyReconnect(ev *BackendReconnect) {
+	logger.Info("Backend %s reconnected to %s after %s down (%d attempts)\n", ev.Backend, ev.Node,
+		ev.Downtime.Round(time.Millisecond), ev.Attempts)
+	s.reconnectSubsLock.Lock()
+	defer s.reconnectSubsLock.Unlock()
+	for ch := range s.reconnectSubs {
+		select {
+		case ch <- *ev:
+		default:
+			logger.Debug("Dropping reconnect event for a slow subscriber\n")
+		}
+	}
+}
+
+// SubscribeBackendReconnects returns a channel that receives an event each time a backend reconnects after
+// being down, until ctx is done.  The channel is closed when the subscription ends.
+func (s *Netceptor) SubscribeBackendReconnects(ctx context.Context) chan BackendReconnect {
+	ch := make(chan BackendReconnect, reconnectSubscriberBuffer)
+	s.reconnectSubsLock.Lock()
+	s.reconnectSubs[ch] = struct{}{}
+	s.reconnectSubsLock.Unlock()
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-s.context.Done():
+		}
+		s.reconnectSubsLock.Lock()
+		delete(s.reconnectSubs, ch)
+		s.reconnectSubsLock.Unlock()
+		close(ch)
+	}()
+
+	return ch
+}
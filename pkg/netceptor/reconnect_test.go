@@ -0,0 +1,110 @@
+package netceptor
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// ctxFlapBackend is a flapBackend that keeps the context it was started with, so the test can report dial
+// failures on its behalf.
+type ctxFlapBackend struct {
+	flapBackend
+	ctx context.Context
+}
+
+func (fb *ctxFlapBackend) Start(ctx context.Context, wg *sync.WaitGroup) (chan BackendSession, error) {
+	fb.ctx = ctx
+
+	return fb.sessChan, nil
+}
+
+func TestBackendReconnectEvents(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	a := New(ctx, "A", nil)
+	b := New(ctx, "B", nil)
+	fbA := &ctxFlapBackend{flapBackend: flapBackend{sessChan: make(chan BackendSession)}}
+	fbB := &flapBackend{sessChan: make(chan BackendSession)}
+	if err := a.AddBackend(fbA, 1.0, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.AddBackend(fbB, 1.0, nil); err != nil {
+		t.Fatal(err)
+	}
+	events := a.SubscribeBackendReconnects(ctx)
+
+	var sess *pipeSession
+	connect := func(sessions uint64) {
+		sa, sb := newPipeSessions()
+		fbA.sessChan <- sa
+		fbB.sessChan <- sb
+		sess = sa
+		deadline := time.Now().Add(10 * time.Second)
+		for a.BackendHealth()[0].Sessions < sessions || len(a.BackendHealth()[0].Connections) == 0 {
+			if time.Now().After(deadline) {
+				t.Fatal("timed out waiting for the session to be established")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	disconnect := func() {
+		_ = sess.Close()
+		for len(a.BackendHealth()[0].Connections) > 0 || len(b.BackendHealth()[0].Connections) > 0 {
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	connect(1)
+	select {
+	case ev := <-events:
+		t.Fatalf("expected no event for the first connection, got %+v", ev)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// The link is down for a while, and two dials fail before it comes back
+	disconnect()
+	outage := 300 * time.Millisecond
+	time.Sleep(outage)
+	ReportDialFailure(fbA.ctx)
+	ReportDialFailure(fbA.ctx)
+	connect(2)
+	select {
+	case ev := <-events:
+		if ev.Backend != a.BackendHealth()[0].Name || ev.Node != "B" || ev.Attempts != 3 {
+			t.Fatalf("expected a reconnect to B after 3 attempts, got %+v", ev)
+		}
+		if ev.Downtime < outage || ev.Downtime > 10*time.Second {
+			t.Fatalf("expected a downtime of at least %s, got %s", outage, ev.Downtime)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the reconnect event")
+	}
+
+	// Outages shorter than the configured downtime are not reported
+	cfg := DefaultBackendHealthConfig
+	cfg.ReconnectNotifyDowntime = time.Hour
+	if err := a.SetBackendHealthConfig(cfg); err != nil {
+		t.Fatal(err)
+	}
+	disconnect()
+	connect(3)
+	select {
+	case ev := <-events:
+		t.Fatalf("expected a short outage not to be reported, got %+v", ev)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	// The subscription ends with its context
+	subCtx, subCancel := context.WithCancel(ctx)
+	sub := a.SubscribeBackendReconnects(subCtx)
+	subCancel()
+	select {
+	case _, ok := <-sub:
+		if ok {
+			t.Fatal("expected no events on a cancelled subscription")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the subscription to close")
+	}
+}
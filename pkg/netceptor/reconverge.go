@@ -0,0 +1,73 @@
+package netceptor
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/ansible/receptor/pkg/logger"
+)
+
+// DefaultReconvergeInterval is the minimum time between two routing updates triggered by Reconverge.
+const DefaultReconvergeInterval = 10 * time.Second
+
+// allowReconverge returns an error if a reconverge was triggered less than the rate limit interval ago, and
+// otherwise records that one is being triggered now.
+func (s *Netceptor) allowReconverge() error {
+	s.reconvergeLock.Lock()
+	defer s.reconvergeLock.Unlock()
+	now := s.now()
+	if !s.lastReconverge.IsZero() {
+		wait := s.reconvergeInterval - now.Sub(s.lastReconverge)
+		if wait > 0 {
+			return fmt.Errorf("reconverge is rate limited, try again in %s", wait.Round(time.Millisecond))
+		}
+	}
+	s.lastReconverge = now
+
+	return nil
+}
+
+// Reconverge sends a routing update to all neighbors right away, rather than waiting for the periodic
+// update, so that changes made to this node reach the rest of the mesh quickly.  If askNeighbors is true,
+// the neighbors are also asked to send their own routing updates, and their node IDs are returned.
+// Reconverge can be called at most once per DefaultReconvergeInterval.
+func (s *Netceptor) Reconverge(askNeighbors bool) ([]string, error) {
+	if err := s.allowReconverge(); err != nil {
+		return nil, err
+	}
+	logger.Info("Sending routing update to reconverge the mesh\n")
+	select {
+	case s.sendRouteFloodChan <- 0:
+	case <-s.context.Done():
+		return nil, fmt.Errorf("netceptor is shutting down")
+	}
+	neighbors := make([]string, 0)
+	if !askNeighbors {
+		return neighbors, nil
+	}
+	s.connLock.RLock()
+	for conn := range s.connections {
+		neighbors = append(neighbors, conn)
+	}
+	s.connLock.RUnlock()
+	sort.Strings(neighbors)
+	s.flood([]byte{MsgTypeReconverge}, "")
+
+	return neighbors, nil
+}
+
+// handleReconvergeRequest sends a routing update when a neighbor asks for one.  Requests are subject to
+// the same rate limit as local calls to Reconverge, so a neighbor cannot make this node flood the mesh.
+func (s *Netceptor) handleReconvergeRequest(remoteNodeID string) {
+	if err := s.allowReconverge(); err != nil {
+		logger.Debug("Ignoring reconverge request from %s: %s\n", remoteNodeID, err)
+
+		return
+	}
+	logger.Info("Sending routing update requested by %s\n", remoteNodeID)
+	select {
+	case s.sendRouteFloodChan <- 0:
+	case <-s.context.Done():
+	}
+}
@@ -0,0 +1,83 @@
+package netceptor
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// reconvergeMesh builds a diamond where A reaches D through B or C.  The composite costs of the links to
+// D are set on D's side, so A only learns about changes to them from D's routing updates, which are
+// otherwise sent much less often than the tests wait for.
+func reconvergeMesh(ctx context.Context, t *testing.T) map[string]*Netceptor {
+	nodes := make(map[string]*Netceptor)
+	for _, name := range []string{"A", "B", "C", "D"} {
+		nodes[name] = NewWithConsts(ctx, name, nil, defaultMTU, time.Hour, defaultServiceAdTime,
+			defaultSeenUpdateExpireTime, defaultMaxForwardingHops, defaultMaxConnectionIdleTime)
+	}
+	linkNodes(t, nodes["A"], nodes["B"], nil)
+	linkNodes(t, nodes["A"], nodes["C"], nil)
+	linkNodes(t, nodes["D"], nodes["B"], &LinkCost{Latency: 1, Bandwidth: 1})
+	linkNodes(t, nodes["D"], nodes["C"], &LinkCost{Latency: 5, Bandwidth: 1})
+	waitForRoute(t, nodes["A"], "D", TrafficClassInteractive, "B")
+
+	return nodes
+}
+
+// setLinkCost changes the composite cost of a connection without telling the rest of the mesh.
+func setLinkCost(s *Netceptor, remoteNodeID string, lc LinkCost) {
+	s.connLock.Lock()
+	s.connections[remoteNodeID].LinkCost = &lc
+	s.connLock.Unlock()
+	s.knownNodeLock.Lock()
+	s.knownLinkCosts[s.nodeID][remoteNodeID] = lc
+	s.knownNodeLock.Unlock()
+}
+
+func TestReconverge(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	nodes := reconvergeMesh(ctx, t)
+
+	setLinkCost(nodes["D"], "C", LinkCost{Latency: 0.1, Bandwidth: 1})
+	neighbors, err := nodes["D"].Reconverge(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(neighbors) != 0 {
+		t.Errorf("expected no neighbors to be asked, got %v", neighbors)
+	}
+	waitForRoute(t, nodes["A"], "D", TrafficClassInteractive, "C")
+
+	if _, err := nodes["D"].Reconverge(false); err == nil {
+		t.Error("expected a second reconverge to be rate limited")
+	}
+}
+
+func TestReconvergeNeighbors(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	nodes := reconvergeMesh(ctx, t)
+
+	setLinkCost(nodes["D"], "C", LinkCost{Latency: 0.1, Bandwidth: 1})
+	neighbors, err := nodes["B"].Reconverge(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(neighbors) != 2 || neighbors[0] != "A" || neighbors[1] != "D" {
+		t.Errorf("expected A and D to be asked, got %v", neighbors)
+	}
+	// B's request makes D send the routing update that carries the new cost
+	waitForRoute(t, nodes["A"], "D", TrafficClassInteractive, "C")
+
+	// D's rate limit also applies to requests from its neighbors
+	nodes["D"].reconvergeLock.Lock()
+	lastReconverge := nodes["D"].lastReconverge
+	nodes["D"].reconvergeLock.Unlock()
+	if lastReconverge.IsZero() {
+		t.Error("expected D to record the requested reconverge")
+	}
+	if _, err := nodes["D"].Reconverge(false); err == nil {
+		t.Error("expected D to be rate limited after reconverging for a neighbor")
+	}
+}
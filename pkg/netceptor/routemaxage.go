@@ -0,0 +1,105 @@
+package netceptor
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ansible/receptor/pkg/logger"
+)
+
+// RouteMaxAge returns how long a node may go without sending a routing update before it is evicted,
+// or 0 if nodes are never evicted for being silent.
+func (s *Netceptor) RouteMaxAge() time.Duration {
+	s.knownNodeLock.RLock()
+	defer s.knownNodeLock.RUnlock()
+
+	return s.routeMaxAge
+}
+
+// SetRouteMaxAge sets how long a node may go without sending a routing update before it is treated as
+// unreachable and evicted from the routing table, so that dials to a node that disappeared without its
+// connections being closed fail straight away.  Directly connected nodes are never evicted, as their
+// connections are aged separately.  The age must be longer than the route update time, since every node
+// sends an update at least that often.  Zero disables eviction.
+func (s *Netceptor) SetRouteMaxAge(maxAge time.Duration) error {
+	if maxAge < 0 {
+		return fmt.Errorf("route max age must not be negative")
+	}
+	if maxAge > 0 && maxAge <= s.routeUpdateTime {
+		return fmt.Errorf("route max age must be longer than the route update time of %s", s.routeUpdateTime)
+	}
+	s.knownNodeLock.Lock()
+	defer s.knownNodeLock.Unlock()
+	s.routeMaxAge = maxAge
+	if maxAge > 0 && !s.routeMaxAgeRunning {
+		s.routeMaxAgeRunning = true
+		go s.monitorRouteAge()
+	}
+
+	return nil
+}
+
+// monitorRouteAge periodically evicts nodes that have not sent a routing update within the route max age.
+func (s *Netceptor) monitorRouteAge() {
+	for {
+		interval := s.RouteMaxAge() / 4
+		if interval <= 0 {
+			interval = s.routeUpdateTime
+		}
+		select {
+		case <-time.After(interval):
+			s.evictStaleNodes()
+		case <-s.context.Done():
+			return
+		}
+	}
+}
+
+// evictStaleNodes removes what is known about nodes whose last routing update is older than the route
+// max age, and returns how many were evicted.  Other nodes' connections to an evicted node are left as
+// they advertised them, but cannot be routed over without the node's own entry.  A node that is evicted
+// is added again as new when its next routing update arrives.
+func (s *Netceptor) evictStaleNodes() int {
+	connected := make(map[string]bool)
+	s.connLock.RLock()
+	for node := range s.connections {
+		connected[node] = true
+	}
+	s.connLock.RUnlock()
+	now := s.now()
+	evicted := make([]string, 0)
+	s.knownNodeLock.Lock()
+	if s.routeMaxAge <= 0 {
+		s.knownNodeLock.Unlock()
+
+		return 0
+	}
+	for node, ni := range s.knownNodeInfo {
+		if node == s.nodeID || connected[node] || now.Sub(ni.LastSeen) <= s.routeMaxAge {
+			continue
+		}
+		if _, ok := s.snapshotNodes[node]; ok {
+			// Snapshot entries are expired by their own TTL
+			continue
+		}
+		logger.Warning("Evicting node %s, which has not sent a routing update since %s\n",
+			node, ni.LastSeen.Format(time.RFC3339))
+		delete(s.knownNodeInfo, node)
+		delete(s.knownConnectionCosts, node)
+		delete(s.knownLinkCosts, node)
+		delete(s.knownCostFactors, node)
+		evicted = append(evicted, node)
+	}
+	s.knownNodeLock.Unlock()
+	if len(evicted) == 0 {
+		return 0
+	}
+	s.serviceAdsLock.Lock()
+	for _, node := range evicted {
+		delete(s.serviceAdsReceived, node)
+	}
+	s.serviceAdsLock.Unlock()
+	s.updateRoutingTableChan <- 0
+
+	return len(evicted)
+}
@@ -0,0 +1,148 @@
+package netceptor
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestSetRouteMaxAge(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := New(ctx, "A", nil)
+	defer s.Shutdown()
+	if err := s.SetRouteMaxAge(-time.Second); err == nil {
+		t.Error("expected a negative route max age to be rejected")
+	}
+	if err := s.SetRouteMaxAge(s.RouteUpdateTime()); err == nil {
+		t.Error("expected a route max age no longer than the route update time to be rejected")
+	}
+	if err := s.SetRouteMaxAge(time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if s.RouteMaxAge() != time.Minute {
+		t.Errorf("expected route max age of 1m, got %s", s.RouteMaxAge())
+	}
+	if err := s.SetRouteMaxAge(0); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEvictStaleNodes(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := New(ctx, "A", nil)
+	defer s.Shutdown()
+	now := time.Now()
+	s.now = func() time.Time { return now }
+
+	// A - B - C, where B keeps sending updates but C goes silent
+	s.knownNodeLock.Lock()
+	s.knownConnectionCosts["A"] = map[string]float64{"B": 1.0}
+	s.knownNodeLock.Unlock()
+	receiveUpdate(s, "B", 1, map[string]float64{"A": 1.0, "C": 1.0})
+	receiveUpdate(s, "C", 1, map[string]float64{"B": 1.0})
+	if routes := s.Status().RoutingTable; routes["C"] != "B" {
+		t.Fatalf("expected C to be routed via B, got %v", routes)
+	}
+
+	// Nothing is evicted while eviction is disabled
+	now = now.Add(time.Hour)
+	if evicted := s.evictStaleNodes(); evicted != 0 {
+		t.Fatalf("expected no nodes to be evicted with no route max age, got %d", evicted)
+	}
+	if err := s.SetRouteMaxAge(time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(-time.Hour)
+	receiveUpdate(s, "B", 2, map[string]float64{"A": 1.0, "C": 1.0})
+	now = now.Add(30 * time.Second)
+	if evicted := s.evictStaleNodes(); evicted != 0 {
+		t.Fatalf("expected no nodes to be evicted within the route max age, got %d", evicted)
+	}
+
+	// B still advertises its connection to C, but C itself has not been heard from
+	now = now.Add(time.Minute)
+	receiveUpdate(s, "B", 3, map[string]float64{"A": 1.0, "C": 1.0})
+	if evicted := s.evictStaleNodes(); evicted != 1 {
+		t.Fatalf("expected only C to be evicted, got %d nodes", evicted)
+	}
+	s.updateRoutingTable()
+	if routes := s.Status().RoutingTable; routes["C"] != "" || routes["B"] != "B" {
+		t.Fatalf("expected C to be unreachable after it was evicted, got %v", routes)
+	}
+	s.knownNodeLock.RLock()
+	_, cKnown := s.knownNodeInfo["C"]
+	s.knownNodeLock.RUnlock()
+	if cKnown {
+		t.Fatal("expected C to be forgotten after it was evicted")
+	}
+
+	// C is added again as new when it is heard from
+	receiveUpdate(s, "C", 4, map[string]float64{"B": 1.0})
+	if routes := s.Status().RoutingTable; routes["C"] != "B" {
+		t.Fatalf("expected C to be routed via B again after it reappeared, got %v", routes)
+	}
+}
+
+func TestRouteMaxAgeSilentNode(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// A - B - C, with routing updates every 100ms and connections that are slow to time out
+	routeUpdateTime := 100 * time.Millisecond
+	nodes := make(map[string]*Netceptor)
+	for _, name := range []string{"A", "B", "C"} {
+		nodes[name] = NewWithConsts(ctx, name, nil, defaultMTU, routeUpdateTime, defaultServiceAdTime,
+			defaultSeenUpdateExpireTime, defaultMaxForwardingHops, time.Minute)
+		defer nodes[name].Shutdown()
+	}
+	maxAge := time.Second
+	if err := nodes["A"].SetRouteMaxAge(maxAge); err != nil {
+		t.Fatal(err)
+	}
+	backends := make(map[[2]string]*MemBackend)
+	for _, link := range [][2]string{{"A", "B"}, {"B", "C"}} {
+		b1, b2, err := NewMemBackendPair(MemLinkConditions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := nodes[link[0]].AddBackend(b1, 1.0, nil); err != nil {
+			t.Fatal(err)
+		}
+		if err := nodes[link[1]].AddBackend(b2, 1.0, nil); err != nil {
+			t.Fatal(err)
+		}
+		backends[link] = b1
+	}
+	waitForRoutes(t, nodes)
+
+	// C goes silent without its connection to B being closed, so B goes on advertising it
+	silent := time.Now()
+	if err := backends[[2]string{"B", "C"}].SetConditions(MemLinkConditions{DropRate: 1}); err != nil {
+		t.Fatal(err)
+	}
+	deadline := silent.Add(maxAge + 2*time.Second)
+	for {
+		if _, ok := nodes["A"].Status().RoutingTable["C"]; !ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected C to be evicted within %s of going silent", maxAge)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	// C's last update may have arrived up to one route update time before it went silent
+	if since := time.Since(silent); since < maxAge-routeUpdateTime {
+		t.Fatalf("expected C to be evicted no sooner than %s after going silent, was %s", maxAge, since)
+	}
+	if _, ok := nodes["A"].Status().RoutingTable["B"]; !ok {
+		t.Fatal("expected B to stay reachable while C was evicted")
+	}
+
+	// C is re-added when it is heard from again
+	if err := backends[[2]string{"B", "C"}].SetConditions(MemLinkConditions{}); err != nil {
+		t.Fatal(err)
+	}
+	waitForRoutes(t, nodes)
+}
@@ -0,0 +1,323 @@
+package netceptor
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/ansible/receptor/pkg/utils"
+	"github.com/ghjm/cmdline"
+)
+
+// errInvalidRouteSignature is returned when a routing update or service advertisement is signed, but not by
+// the key trusted for its node.
+var errInvalidRouteSignature = errors.New("invalid signature")
+
+// SetRouteSigningKey sets the private key this node signs its routing updates and service advertisements
+// with.  RSA, ECDSA and Ed25519 keys are supported.  A nil key stops the signing.
+func (s *Netceptor) SetRouteSigningKey(key crypto.Signer) error {
+	if key != nil {
+		switch key.Public().(type) {
+		case *rsa.PublicKey, *ecdsa.PublicKey, ed25519.PublicKey:
+		default:
+			return fmt.Errorf("unsupported route signing key type %T", key)
+		}
+	}
+	s.routeSigningLock.Lock()
+	defer s.routeSigningLock.Unlock()
+	s.routeSigningKey = key
+
+	return nil
+}
+
+// AddRouteVerificationKey sets the public key that routing updates and service advertisements from a node
+// must be signed with.
+func (s *Netceptor) AddRouteVerificationKey(nodeID string, key crypto.PublicKey) error {
+	switch key.(type) {
+	case *rsa.PublicKey, *ecdsa.PublicKey, ed25519.PublicKey:
+	default:
+		return fmt.Errorf("unsupported route verification key type %T for node %s", key, nodeID)
+	}
+	s.routeSigningLock.Lock()
+	defer s.routeSigningLock.Unlock()
+	s.routeVerifyKeys[nodeID] = key
+
+	return nil
+}
+
+// SetRouteSignatureEnforcement sets whether routing updates and service advertisements are rejected unless
+// they are signed by the key trusted for their node.  When not enforced, updates that fail verification are still accepted, so signing
+// can be rolled out one node at a time.
+func (s *Netceptor) SetRouteSignatureEnforcement(enforce bool) {
+	s.routeSigningLock.Lock()
+	defer s.routeSigningLock.Unlock()
+	s.enforceRouteSignatures = enforce
+}
+
+// routeSignaturesEnforced returns whether routing updates and service advertisements that fail verification
+// are rejected.
+func (s *Netceptor) routeSignaturesEnforced() bool {
+	s.routeSigningLock.RLock()
+	defer s.routeSigningLock.RUnlock()
+
+	return s.enforceRouteSignatures
+}
+
+// signData signs data with this node's signing key.  Returns a nil signature if the node has no signing key.
+func (s *Netceptor) signData(data []byte) ([]byte, error) {
+	s.routeSigningLock.RLock()
+	key := s.routeSigningKey
+	s.routeSigningLock.RUnlock()
+	if key == nil {
+		return nil, nil
+	}
+	if _, ok := key.Public().(ed25519.PublicKey); ok {
+		// Ed25519 does its own hashing, so it signs the message itself
+		return key.Sign(rand.Reader, data, crypto.Hash(0))
+	}
+	digest := sha256.Sum256(data)
+
+	return key.Sign(rand.Reader, digest[:], crypto.SHA256)
+}
+
+// verifyData checks that data is signed by the key trusted for a node.
+func (s *Netceptor) verifyData(nodeID string, data []byte, signature []byte) error {
+	s.routeSigningLock.RLock()
+	key, ok := s.routeVerifyKeys[nodeID]
+	s.routeSigningLock.RUnlock()
+	if !ok {
+		return fmt.Errorf("no route verification key for node %s", nodeID)
+	}
+	if len(signature) == 0 || len(data) == 0 {
+		return fmt.Errorf("message is not signed")
+	}
+	digest := sha256.Sum256(data)
+	valid := false
+	switch pub := key.(type) {
+	case *rsa.PublicKey:
+		valid = rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], signature) == nil
+	case *ecdsa.PublicKey:
+		valid = ecdsa.VerifyASN1(pub, digest[:], signature)
+	case ed25519.PublicKey:
+		valid = ed25519.Verify(pub, data, signature)
+	}
+	if !valid {
+		return errInvalidRouteSignature
+	}
+
+	return nil
+}
+
+// signRoutingUpdate signs a routing update originated by this node, if it has a signing key.  The signed
+// bytes are carried in the update, so receivers verify exactly what was signed.  Nodes that forward the
+// update change ForwardingNode, and TimeEcho is only meaningful to direct neighbors, so neither is signed.
+func (s *Netceptor) signRoutingUpdate(ri *routingUpdate) error {
+	signed := *ri
+	signed.ForwardingNode = ""
+	signed.TimeEcho = nil
+	signed.Signature = nil
+	signed.SignedData = nil
+	data, err := json.Marshal(&signed)
+	if err != nil {
+		return err
+	}
+	sig, err := s.signData(data)
+	if err != nil || sig == nil {
+		return err
+	}
+	ri.SignedData = data
+	ri.Signature = sig
+
+	return nil
+}
+
+// verifyRoutingUpdate checks that a routing update is signed by the key trusted for the node it came from,
+// and replaces its signed fields with the ones decoded from the signed bytes.
+func (s *Netceptor) verifyRoutingUpdate(ri *routingUpdate) error {
+	err := s.verifyData(ri.NodeID, ri.SignedData, ri.Signature)
+	if err != nil {
+		return err
+	}
+	signed := routingUpdate{}
+	err = json.Unmarshal(ri.SignedData, &signed)
+	if err != nil {
+		return fmt.Errorf("error unpacking signed routing update: %s", err)
+	}
+	if signed.NodeID != ri.NodeID {
+		return errInvalidRouteSignature
+	}
+	signed.ForwardingNode = ri.ForwardingNode
+	signed.TimeEcho = ri.TimeEcho
+	signed.Signature = ri.Signature
+	signed.SignedData = ri.SignedData
+	*ri = signed
+
+	return nil
+}
+
+// signServiceAd signs a service advertisement originated by this node, if it has a signing key.
+func (s *Netceptor) signServiceAd(sa *serviceAdvertisementFull) error {
+	signed := *sa
+	signed.Signature = nil
+	signed.SignedData = nil
+	data, err := json.Marshal(&signed)
+	if err != nil {
+		return err
+	}
+	sig, err := s.signData(data)
+	if err != nil || sig == nil {
+		return err
+	}
+	sa.SignedData = data
+	sa.Signature = sig
+
+	return nil
+}
+
+// verifyServiceAd checks that a service advertisement is signed by the key trusted for the node it
+// advertises, and replaces its contents with the ones decoded from the signed bytes.
+func (s *Netceptor) verifyServiceAd(sa *serviceAdvertisementFull) error {
+	err := s.verifyData(sa.NodeID, sa.SignedData, sa.Signature)
+	if err != nil {
+		return err
+	}
+	signed := serviceAdvertisementFull{}
+	err = json.Unmarshal(sa.SignedData, &signed)
+	if err != nil {
+		return fmt.Errorf("error unpacking signed service advertisement: %s", err)
+	}
+	if signed.ServiceAdvertisement == nil || signed.NodeID != sa.NodeID {
+		return errInvalidRouteSignature
+	}
+	signed.Signature = sa.Signature
+	signed.SignedData = sa.SignedData
+	*sa = signed
+
+	return nil
+}
+
+// ParseRouteSigningKey reads a PEM encoded private key, in PKCS #1, PKCS #8 or SEC 1 form.
+func ParseRouteSigningKey(data []byte) (crypto.Signer, error) {
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return nil, fmt.Errorf("no private key found")
+		}
+		switch block.Type {
+		case "RSA PRIVATE KEY":
+			return x509.ParsePKCS1PrivateKey(block.Bytes)
+		case "EC PRIVATE KEY":
+			return x509.ParseECPrivateKey(block.Bytes)
+		case "PRIVATE KEY":
+			key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+			if err != nil {
+				return nil, err
+			}
+			signer, ok := key.(crypto.Signer)
+			if !ok {
+				return nil, fmt.Errorf("unsupported private key type %T", key)
+			}
+
+			return signer, nil
+		}
+	}
+}
+
+// ParseRouteVerificationCerts reads PEM encoded certificates, and returns their public keys by the
+// Receptor node IDs in their subjectAltNames.
+func ParseRouteVerificationCerts(data []byte) (map[string]crypto.PublicKey, error) {
+	keys := make(map[string]crypto.PublicKey)
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		names, err := utils.ReceptorNames(cert.Extensions)
+		if err != nil {
+			return nil, err
+		}
+		if len(names) == 0 {
+			return nil, fmt.Errorf("certificate %s has no Receptor node ID", cert.Subject)
+		}
+		for _, name := range names {
+			keys[name] = cert.PublicKey
+		}
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no certificates found")
+	}
+
+	return keys, nil
+}
+
+// **************************************************************************
+// Command line
+// **************************************************************************
+
+// routeSigningCfg is the cmdline configuration object for signed routing updates.
+type routeSigningCfg struct {
+	Key          string   `description:"Private key file to sign this node's routing updates and service advertisements with, such as its TLS key"`
+	TrustedCerts []string `description:"Certificate files of the nodes whose routing updates and service advertisements can be verified, by the node IDs in them"`
+	Enforce      bool     `description:"Reject routing updates and service advertisements that are not signed by a trusted certificate's key" default:"false"`
+}
+
+// Prepare loads the keys and configures routing update signing.
+func (cfg routeSigningCfg) Prepare() error {
+	if cfg.Key != "" {
+		data, err := ioutil.ReadFile(cfg.Key)
+		if err != nil {
+			return err
+		}
+		key, err := ParseRouteSigningKey(data)
+		if err != nil {
+			return fmt.Errorf("error reading route signing key %s: %s", cfg.Key, err)
+		}
+		err = MainInstance.SetRouteSigningKey(key)
+		if err != nil {
+			return err
+		}
+	}
+	for _, filename := range cfg.TrustedCerts {
+		data, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return err
+		}
+		keys, err := ParseRouteVerificationCerts(data)
+		if err != nil {
+			return fmt.Errorf("error reading trusted certificates %s: %s", filename, err)
+		}
+		for nodeID, key := range keys {
+			err = MainInstance.AddRouteVerificationKey(nodeID, key)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	MainInstance.SetRouteSignatureEnforcement(cfg.Enforce)
+	utils.RecordEffectiveConfig("route-signing", cfg)
+
+	return nil
+}
+
+func init() {
+	cmdline.RegisterConfigTypeForApp("receptor-tls",
+		"route-signing", "Sign routing updates and service advertisements, and verify the signatures of other nodes", routeSigningCfg{}, cmdline.Singleton)
+}
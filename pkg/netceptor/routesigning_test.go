@@ -0,0 +1,266 @@
+package netceptor
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ansible/receptor/pkg/utils"
+)
+
+// signedUpdateFrom returns a routing update made by a node, as it would arrive at another node.
+func signedUpdateFrom(ctx context.Context, t *testing.T, nodeID string, key crypto.Signer) *routingUpdate {
+	s := New(ctx, nodeID, nil)
+	defer s.Shutdown()
+	if err := s.SetRouteSigningKey(key); err != nil {
+		t.Fatal(err)
+	}
+	s.connections["A"] = &connInfo{Cost: 1.0}
+	data, err := json.Marshal(s.makeRoutingUpdate(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ri := &routingUpdate{}
+	if err := json.Unmarshal(data, ri); err != nil {
+		t.Fatal(err)
+	}
+	ri.ForwardingNode = "E"
+
+	return ri
+}
+
+// knowsNode returns whether a node has accepted a routing update from another node.
+func knowsNode(s *Netceptor, nodeID string) bool {
+	s.knownNodeLock.RLock()
+	defer s.knownNodeLock.RUnlock()
+	_, ok := s.knownNodeInfo[nodeID]
+
+	return ok
+}
+
+func TestRouteSigningEnforced(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := New(ctx, "A", nil)
+	defer s.Shutdown()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, otherKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for nodeID, key := range map[string]crypto.Signer{"B": rsaKey, "C": ecKey, "D": edKey, "F": edKey} {
+		if err := s.AddRouteVerificationKey(nodeID, key.Public()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.SetRouteSignatureEnforcement(true)
+
+	// Properly signed updates are accepted, whatever the key type
+	s.handleRoutingUpdate(signedUpdateFrom(ctx, t, "B", rsaKey), "E")
+	s.handleRoutingUpdate(signedUpdateFrom(ctx, t, "C", ecKey), "E")
+	s.handleRoutingUpdate(signedUpdateFrom(ctx, t, "D", edKey), "E")
+	for _, nodeID := range []string{"B", "C", "D"} {
+		if !knowsNode(s, nodeID) {
+			t.Errorf("expected signed update from %s to be accepted", nodeID)
+		}
+	}
+
+	// F signs with a key other than the one trusted for it
+	s.handleRoutingUpdate(signedUpdateFrom(ctx, t, "F", otherKey), "E")
+	// G has no trusted key, and H does not sign its updates
+	s.handleRoutingUpdate(signedUpdateFrom(ctx, t, "G", otherKey), "E")
+	s.handleRoutingUpdate(signedUpdateFrom(ctx, t, "H", nil), "E")
+	// An intermediate node tampers with D's connections
+	tampered := signedUpdateFrom(ctx, t, "D", edKey)
+	tampered.UpdateSequence++
+	tampered.Connections["G"] = 1.0
+	s.handleRoutingUpdate(tampered, "E")
+	// An intermediate node tampers with the signed bytes of D's update
+	tampered = signedUpdateFrom(ctx, t, "D", edKey)
+	tampered.SignedData = []byte(strings.Replace(string(tampered.SignedData), `"A":1`, `"A":1,"G":1`, 1))
+	tampered.UpdateSequence++
+	tampered.Connections["G"] = 1.0
+	s.handleRoutingUpdate(tampered, "E")
+	for _, nodeID := range []string{"F", "G", "H"} {
+		if knowsNode(s, nodeID) {
+			t.Errorf("expected update from %s to be rejected", nodeID)
+		}
+	}
+	s.knownNodeLock.RLock()
+	_, ok := s.knownConnectionCosts["D"]["G"]
+	s.knownNodeLock.RUnlock()
+	if ok {
+		t.Error("expected tampered update from D to be rejected")
+	}
+}
+
+// signedAdFrom returns a service advertisement made by a node, as it would arrive at another node.
+func signedAdFrom(ctx context.Context, t *testing.T, nodeID string, key crypto.Signer) []byte {
+	s := New(ctx, nodeID, nil)
+	defer s.Shutdown()
+	if err := s.SetRouteSigningKey(key); err != nil {
+		t.Fatal(err)
+	}
+	sa := &serviceAdvertisementFull{
+		ServiceAdvertisement: &ServiceAdvertisement{
+			NodeID:   nodeID,
+			Service:  "control",
+			Time:     time.Now(),
+			ConnType: ConnTypeStream,
+			Tags:     map[string]string{"type": "Control Service"},
+		},
+	}
+	if err := s.signServiceAd(sa); err != nil {
+		t.Fatal(err)
+	}
+	data, err := s.translateStructToNetwork(MsgTypeServiceAdvertisement, sa)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return data
+}
+
+func TestServiceAdSigning(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := New(ctx, "A", nil)
+	defer s.Shutdown()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, otherKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, nodeID := range []string{"B", "C", "F"} {
+		if err := s.AddRouteVerificationKey(nodeID, key.Public()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.SetRouteSignatureEnforcement(true)
+
+	// B's properly signed advertisement is accepted
+	if err := s.handleServiceAdvertisement(signedAdFrom(ctx, t, "B", key), "E"); err != nil {
+		t.Fatal(err)
+	}
+	// C signs with the wrong key, D does not sign, and an intermediate node changes the tags of F's ad
+	_ = s.handleServiceAdvertisement(signedAdFrom(ctx, t, "C", otherKey), "E")
+	_ = s.handleServiceAdvertisement(signedAdFrom(ctx, t, "D", nil), "E")
+	ad := signedAdFrom(ctx, t, "F", key)
+	sa := &serviceAdvertisementFull{}
+	if err := json.Unmarshal(ad[1:], sa); err != nil {
+		t.Fatal(err)
+	}
+	sa.SignedData = []byte(strings.Replace(string(sa.SignedData), "Control Service", "Other Service", 1))
+	ad, err = s.translateStructToNetwork(MsgTypeServiceAdvertisement, sa)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = s.handleServiceAdvertisement(ad, "E")
+
+	if _, ok := s.GetServiceInfo("B", "control"); !ok {
+		t.Error("expected signed advertisement from B to be accepted")
+	}
+	for _, nodeID := range []string{"C", "D", "F"} {
+		if _, ok := s.GetServiceInfo(nodeID, "control"); ok {
+			t.Errorf("expected advertisement from %s to be rejected", nodeID)
+		}
+	}
+}
+
+func TestRouteSigningNotEnforced(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := New(ctx, "A", nil)
+	defer s.Shutdown()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, otherKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddRouteVerificationKey("B", key.Public()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Without enforcement, updates that fail verification are still accepted
+	s.handleRoutingUpdate(signedUpdateFrom(ctx, t, "B", otherKey), "E")
+	s.handleRoutingUpdate(signedUpdateFrom(ctx, t, "C", nil), "E")
+	for _, nodeID := range []string{"B", "C"} {
+		if !knowsNode(s, nodeID) {
+			t.Errorf("expected update from %s to be accepted", nodeID)
+		}
+	}
+}
+
+func TestParseRouteSigningKeys(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ParseRouteSigningKey(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !key.PublicKey.Equal(signer.Public()) {
+		t.Fatal("expected parsed key to match")
+	}
+	if _, err := ParseRouteSigningKey([]byte("not a key")); err == nil {
+		t.Fatal("expected an error parsing an invalid key")
+	}
+
+	san, err := utils.MakeReceptorSAN(nil, nil, []string{"B"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:    big.NewInt(1),
+		Subject:         pkix.Name{CommonName: "B"},
+		NotBefore:       time.Now(),
+		NotAfter:        time.Now().Add(time.Hour),
+		ExtraExtensions: []pkix.Extension{*san},
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := ParseRouteVerificationCerts(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || !key.PublicKey.Equal(keys["B"]) {
+		t.Fatalf("expected the certificate's key for node B, got %v", keys)
+	}
+}
@@ -0,0 +1,213 @@
+package netceptor
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/ansible/receptor/pkg/logger"
+	"github.com/ghjm/cmdline"
+)
+
+// routingSnapshotNode is the last known routing state of a single node.
+type routingSnapshotNode struct {
+	Connections map[string]float64
+	LastSeen    time.Time
+}
+
+// routingSnapshot is the routing state persisted to disk.
+type routingSnapshot struct {
+	NodeID string
+	Time   time.Time
+	Nodes  map[string]*routingSnapshotNode
+}
+
+// SaveRoutingSnapshot writes the routing state learned from live updates to a file.  The file is
+// replaced atomically, so a crash while saving does not lose the previous snapshot.
+func (s *Netceptor) SaveRoutingSnapshot(filename string) error {
+	snap := routingSnapshot{
+		NodeID: s.nodeID,
+		Time:   s.now(),
+		Nodes:  make(map[string]*routingSnapshotNode),
+	}
+	s.knownNodeLock.RLock()
+	for node, ni := range s.knownNodeInfo {
+		conns, ok := s.knownConnectionCosts[node]
+		if !ok {
+			continue
+		}
+		sn := &routingSnapshotNode{
+			Connections: make(map[string]float64),
+			LastSeen:    ni.LastSeen,
+		}
+		for k, v := range conns {
+			sn.Connections[k] = v
+		}
+		snap.Nodes[node] = sn
+	}
+	s.knownNodeLock.RUnlock()
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+	tmpFile, err := ioutil.TempFile(filepath.Dir(filename), filepath.Base(filename)+".*")
+	if err != nil {
+		return err
+	}
+	_, err = tmpFile.Write(data)
+	if cerr := tmpFile.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmpFile.Name(), filename)
+	}
+	if err != nil {
+		_ = os.Remove(tmpFile.Name())
+
+		return err
+	}
+
+	return nil
+}
+
+// LoadRoutingSnapshot pre-populates the routing state from a snapshot, so that routes are available
+// before live routing updates arrive.  Nodes last seen more than ttl ago are skipped, and nodes that
+// are already known from live updates are left alone.  Snapshot entries are replaced as soon as a
+// live update arrives for the node, and are dropped once they are older than ttl.  Returns the
+// number of nodes loaded.
+func (s *Netceptor) LoadRoutingSnapshot(filename string, ttl time.Duration) (int, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return 0, err
+	}
+	snap := routingSnapshot{}
+	err = json.Unmarshal(data, &snap)
+	if err != nil {
+		return 0, fmt.Errorf("error parsing routing snapshot %s: %s", filename, err)
+	}
+	if snap.NodeID != s.nodeID {
+		return 0, fmt.Errorf("routing snapshot %s is for node %s, not %s", filename, snap.NodeID, s.nodeID)
+	}
+	now := s.now()
+	loaded := 0
+	s.knownNodeLock.Lock()
+	s.snapshotTTL = ttl
+	for node, sn := range snap.Nodes {
+		if node == s.nodeID || sn == nil || now.Sub(sn.LastSeen) > ttl {
+			continue
+		}
+		if _, ok := s.knownNodeInfo[node]; ok {
+			continue
+		}
+		conns := make(map[string]float64)
+		for k, v := range sn.Connections {
+			conns[k] = v
+		}
+		s.knownConnectionCosts[node] = conns
+		s.snapshotNodes[node] = sn.LastSeen
+		_ = s.addNameHash(node)
+		loaded++
+	}
+	s.knownNodeLock.Unlock()
+	if loaded > 0 {
+		s.updateRoutingTableChan <- 0
+	}
+
+	return loaded, nil
+}
+
+// expireRoutingSnapshot drops snapshot entries that have not been confirmed by a live update within the TTL.
+func (s *Netceptor) expireRoutingSnapshot() {
+	now := s.now()
+	expired := 0
+	s.knownNodeLock.Lock()
+	for node, lastSeen := range s.snapshotNodes {
+		if now.Sub(lastSeen) > s.snapshotTTL {
+			delete(s.knownConnectionCosts, node)
+			delete(s.knownLinkCosts, node)
+			delete(s.knownCostFactors, node)
+			delete(s.snapshotNodes, node)
+			expired++
+		}
+	}
+	s.knownNodeLock.Unlock()
+	if expired > 0 {
+		logger.Debug("Expired %d stale routing snapshot entries\n", expired)
+		s.updateRoutingTableChan <- 0
+	}
+}
+
+// RunRoutingSnapshot loads a routing snapshot if one exists, and then saves a new one every interval.
+func (s *Netceptor) RunRoutingSnapshot(filename string, interval time.Duration, ttl time.Duration) error {
+	loaded, err := s.LoadRoutingSnapshot(filename, ttl)
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		logger.Warning("Not using routing snapshot: %s\n", err)
+	case loaded > 0:
+		logger.Info("Loaded %d nodes from routing snapshot %s\n", loaded, filename)
+	}
+	go func() {
+		for {
+			select {
+			case <-time.After(interval):
+				s.expireRoutingSnapshot()
+				err := s.SaveRoutingSnapshot(filename)
+				if err != nil {
+					logger.Error("Error saving routing snapshot %s: %s\n", filename, err)
+				}
+			case <-s.context.Done():
+				return
+			}
+		}
+	}()
+
+	return nil
+}
+
+// **************************************************************************
+// Command line
+// **************************************************************************
+
+// routingSnapshotCfg is the cmdline configuration object for routing snapshots.
+type routingSnapshotCfg struct {
+	Filename string `description:"File to persist the routing state to, such as a file in the data dir" required:"true"`
+	Interval string `description:"How often to save the routing state" default:"30s"`
+	TTL      string `description:"How long saved routing state is trusted without a live update" default:"10m"`
+}
+
+// Prepare verifies the parameters are correct.
+func (cfg routingSnapshotCfg) Prepare() error {
+	interval, err := time.ParseDuration(cfg.Interval)
+	if err != nil {
+		return err
+	}
+	if interval <= 0 {
+		return fmt.Errorf("routing snapshot interval must be positive")
+	}
+	_, err = time.ParseDuration(cfg.TTL)
+
+	return err
+}
+
+// Run runs the action.
+func (cfg routingSnapshotCfg) Run() error {
+	interval, err := time.ParseDuration(cfg.Interval)
+	if err != nil {
+		return err
+	}
+	ttl, err := time.ParseDuration(cfg.TTL)
+	if err != nil {
+		return err
+	}
+
+	return MainInstance.RunRoutingSnapshot(cfg.Filename, interval, ttl)
+}
+
+func init() {
+	cmdline.RegisterConfigTypeForApp("receptor-backends",
+		"routing-snapshot", "Persist routing state for faster reconvergence after a restart", routingSnapshotCfg{}, cmdline.Singleton)
+}
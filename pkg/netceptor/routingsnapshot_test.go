@@ -0,0 +1,104 @@
+package netceptor
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRoutingSnapshot(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "receptor-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	filename := filepath.Join(tmpdir, "routing.json")
+
+	// A - B - C, learned from live updates
+	ctx, cancel := context.WithCancel(context.Background())
+	s := New(ctx, "A", nil)
+	s.knownNodeLock.Lock()
+	s.knownConnectionCosts["A"] = map[string]float64{"B": 1.0}
+	s.knownNodeLock.Unlock()
+	receiveUpdate(s, "B", 1, map[string]float64{"A": 1.0, "C": 2.0})
+	receiveUpdate(s, "C", 1, map[string]float64{"B": 2.0})
+	err = s.SaveRoutingSnapshot(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Shutdown()
+	cancel()
+
+	// Restart, and load the snapshot before any routing updates arrive
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	s = New(ctx, "A", nil)
+	defer s.Shutdown()
+	now := time.Now()
+	s.now = func() time.Time { return now }
+	s.knownNodeLock.Lock()
+	s.knownConnectionCosts["A"] = map[string]float64{"B": 1.0}
+	s.knownNodeLock.Unlock()
+	loaded, err := s.LoadRoutingSnapshot(filename, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded != 2 {
+		t.Fatalf("expected 2 nodes to be loaded from the snapshot, got %d", loaded)
+	}
+	s.updateRoutingTable()
+	if routes := s.Status().RoutingTable; routes["C"] != "B" {
+		t.Fatalf("expected C to be routed via B from the snapshot, got %v", routes)
+	}
+
+	// A live update replaces the snapshot entry: B is now connected to D instead of C
+	receiveUpdate(s, "B", 1, map[string]float64{"A": 1.0, "D": 1.0})
+	s.knownNodeLock.RLock()
+	_, bFromSnapshot := s.snapshotNodes["B"]
+	_, bToC := s.knownConnectionCosts["B"]["C"]
+	s.knownNodeLock.RUnlock()
+	if bFromSnapshot || bToC {
+		t.Fatalf("expected the live update from B to replace the snapshot entry")
+	}
+	if routes := s.Status().RoutingTable; routes["C"] != "" || routes["B"] != "B" {
+		t.Fatalf("expected C to be unreachable after the live update from B, got %v", routes)
+	}
+
+	// C never sent a live update, so it ages out after the TTL
+	s.expireRoutingSnapshot()
+	s.knownNodeLock.RLock()
+	_, cKnown := s.knownConnectionCosts["C"]
+	s.knownNodeLock.RUnlock()
+	if !cKnown {
+		t.Fatal("expected C to still be known before the TTL")
+	}
+	now = now.Add(2 * time.Minute)
+	s.expireRoutingSnapshot()
+	s.knownNodeLock.RLock()
+	_, cKnown = s.knownConnectionCosts["C"]
+	_, bKnown := s.knownConnectionCosts["B"]
+	s.knownNodeLock.RUnlock()
+	if cKnown || !bKnown {
+		t.Fatal("expected only the stale snapshot entry for C to be expired")
+	}
+
+	// A stale snapshot is not loaded at all
+	s2 := New(ctx, "A", nil)
+	defer s2.Shutdown()
+	s2.now = func() time.Time { return now.Add(time.Hour) }
+	loaded, err = s2.LoadRoutingSnapshot(filename, time.Minute)
+	if err != nil || loaded != 0 {
+		t.Fatalf("expected a stale snapshot to load no nodes, got %d, %v", loaded, err)
+	}
+
+	// A snapshot from another node is rejected
+	s3 := New(ctx, "B", nil)
+	defer s3.Shutdown()
+	_, err = s3.LoadRoutingSnapshot(filename, time.Minute)
+	if err == nil {
+		t.Fatal("expected an error loading another node's snapshot")
+	}
+}
@@ -0,0 +1,91 @@
+package netceptor
+
+import (
+	"context"
+	"io"
+	"sync"
+	"testing"
+	"time"
+)
+
+// stalledSession is a BackendSession whose peer never reads, so every Send blocks until its write
+// deadline passes.
+type stalledSession struct {
+	lock     sync.Mutex
+	deadline time.Time
+	closed   chan struct{}
+}
+
+func (ss *stalledSession) SetWriteDeadline(t time.Time) error {
+	ss.lock.Lock()
+	defer ss.lock.Unlock()
+	ss.deadline = t
+
+	return nil
+}
+
+func (ss *stalledSession) Send(data []byte) error {
+	ss.lock.Lock()
+	deadline := ss.deadline
+	ss.lock.Unlock()
+	if deadline.IsZero() {
+		<-ss.closed
+
+		return io.EOF
+	}
+	select {
+	case <-time.After(time.Until(deadline)):
+		return ErrSendTimeout
+	case <-ss.closed:
+		return io.EOF
+	}
+}
+
+func (ss *stalledSession) Recv(timeout time.Duration) ([]byte, error) {
+	time.Sleep(timeout)
+
+	return nil, ErrTimeout
+}
+
+func (ss *stalledSession) Close() error {
+	close(ss.closed)
+
+	return nil
+}
+
+func TestProtoWriterSendTimeout(t *testing.T) {
+	sess := &stalledSession{closed: make(chan struct{})}
+	defer sess.Close()
+	ci := &connInfo{
+		WriteChan:   make(chan []byte),
+		sendTimeout: 200 * time.Millisecond,
+	}
+	ci.Context, ci.CancelFunc = context.WithCancel(context.Background())
+	defer ci.CancelFunc()
+	go ci.protoWriter(sess)
+	start := time.Now()
+	ci.WriteChan <- []byte("hello")
+	select {
+	case <-ci.Context.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the stalled connection to be closed")
+	}
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Fatalf("connection closed after %s, before the send timeout", elapsed)
+	}
+}
+
+func TestSetSendTimeout(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	n := New(ctx, "node1", nil)
+	if n.SendTimeout() != defaultSendTimeout {
+		t.Fatalf("expected the default send timeout, got %s", n.SendTimeout())
+	}
+	if err := n.SetSendTimeout(-time.Second); err == nil {
+		t.Fatal("expected a negative send timeout to be refused")
+	}
+	if err := n.SetSendTimeout(0); err != nil || n.SendTimeout() != 0 {
+		t.Fatalf("expected the send timeout to be disabled, got %s (%v)", n.SendTimeout(), err)
+	}
+}
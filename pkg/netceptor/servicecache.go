@@ -0,0 +1,121 @@
+package netceptor
+
+import (
+	"sort"
+	"time"
+
+	"github.com/ansible/receptor/pkg/logger"
+)
+
+// ServiceCacheEntry is the node that DialAny last reached a service on, and when it was resolved.
+type ServiceCacheEntry struct {
+	Service  string
+	NodeID   string
+	Resolved time.Time
+}
+
+// serviceCacheTTL is how long DialAny keeps dialing the node it cached for a service before resolving the
+// service again.
+const serviceCacheTTL = 5 * time.Minute
+
+// cachedServiceNode returns the node a service was last reached on, if it still advertises the service, is
+// still among the nearest nodes that do, and was resolved less than serviceCacheTTL ago.  Otherwise the entry
+// is dropped, so that the service is resolved again.
+func (s *Netceptor) cachedServiceNode(service string, advertisers []ServiceAdvertiser) (string, bool) {
+	s.serviceCacheLock.Lock()
+	defer s.serviceCacheLock.Unlock()
+	entry, ok := s.serviceCache[service]
+	if !ok {
+		return "", false
+	}
+	if s.now().Sub(entry.Resolved) >= serviceCacheTTL {
+		logger.Debug("Cached node %s for service %s has expired, resolving it again\n", entry.NodeID, service)
+		delete(s.serviceCache, service)
+
+		return "", false
+	}
+	nearest := -1.0
+	for _, adv := range advertisers {
+		if adv.ConnType == ConnTypeDatagram {
+			continue
+		}
+		if nearest < 0 {
+			// Advertisers are sorted nearest first
+			nearest = adv.PathCost
+		}
+		if adv.NodeID != entry.NodeID {
+			continue
+		}
+		if adv.PathCost > nearest {
+			logger.Debug("A node nearer than %s advertises service %s, resolving it again\n", entry.NodeID, service)
+			delete(s.serviceCache, service)
+
+			return "", false
+		}
+
+		return entry.NodeID, true
+	}
+	logger.Debug("Node %s no longer advertises service %s, resolving it again\n", entry.NodeID, service)
+	delete(s.serviceCache, service)
+
+	return "", false
+}
+
+// cacheServiceNode records the node a service was reached on.  The resolution time of an existing entry
+// for the same node is kept.
+func (s *Netceptor) cacheServiceNode(service string, nodeID string) {
+	s.serviceCacheLock.Lock()
+	defer s.serviceCacheLock.Unlock()
+	if entry, ok := s.serviceCache[service]; ok && entry.NodeID == nodeID {
+		return
+	}
+	s.serviceCache[service] = ServiceCacheEntry{
+		Service:  service,
+		NodeID:   nodeID,
+		Resolved: s.now(),
+	}
+}
+
+// uncacheServiceNode drops the entry for a service if it is for nodeID, after a dial to it failed.
+func (s *Netceptor) uncacheServiceNode(service string, nodeID string) {
+	s.serviceCacheLock.Lock()
+	defer s.serviceCacheLock.Unlock()
+	if entry, ok := s.serviceCache[service]; ok && entry.NodeID == nodeID {
+		delete(s.serviceCache, service)
+	}
+}
+
+// ServiceCache returns the nodes that DialAny last reached each service on, sorted by service.
+func (s *Netceptor) ServiceCache() []ServiceCacheEntry {
+	s.serviceCacheLock.Lock()
+	entries := make([]ServiceCacheEntry, 0, len(s.serviceCache))
+	for _, entry := range s.serviceCache {
+		entries = append(entries, entry)
+	}
+	s.serviceCacheLock.Unlock()
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Service < entries[j].Service
+	})
+
+	return entries
+}
+
+// ClearServiceCache forgets the node that DialAny last reached a service on, or every service if service
+// is empty, so that the next dial resolves the service again.  It returns the services that were cleared.
+func (s *Netceptor) ClearServiceCache(service string) []string {
+	s.serviceCacheLock.Lock()
+	defer s.serviceCacheLock.Unlock()
+	cleared := make([]string, 0)
+	for svc := range s.serviceCache {
+		if service == "" || svc == service {
+			cleared = append(cleared, svc)
+			delete(s.serviceCache, svc)
+		}
+	}
+	sort.Strings(cleared)
+	if len(cleared) > 0 {
+		logger.Info("Cleared service cache for %v\n", cleared)
+	}
+
+	return cleared
+}
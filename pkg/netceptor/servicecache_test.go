@@ -0,0 +1,136 @@
+package netceptor
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// advertiseService records an advertisement of a stream service from a node, as if it had been received.
+func advertiseService(s *Netceptor, nodeID string, service string) {
+	s.serviceAdsLock.Lock()
+	defer s.serviceAdsLock.Unlock()
+	if s.serviceAdsReceived[nodeID] == nil {
+		s.serviceAdsReceived[nodeID] = make(map[string]*ServiceAdvertisement)
+	}
+	s.serviceAdsReceived[nodeID][service] = &ServiceAdvertisement{
+		NodeID:   nodeID,
+		Service:  service,
+		Time:     time.Now(),
+		ConnType: ConnTypeStream,
+	}
+}
+
+// setCosts sets the cost of A's connections to B and C.
+func setCosts(s *Netceptor, sequence uint64, costB float64, costC float64) {
+	s.knownNodeLock.Lock()
+	s.knownConnectionCosts["A"] = map[string]float64{"B": costB, "C": costC}
+	s.knownNodeLock.Unlock()
+	receiveUpdate(s, "B", sequence, map[string]float64{"A": costB})
+	receiveUpdate(s, "C", sequence, map[string]float64{"A": costC})
+}
+
+func TestServiceCache(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := New(ctx, "A", nil)
+	defer s.Shutdown()
+	now := time.Now()
+	s.now = func() time.Time { return now }
+	setCosts(s, 1, 2.0, 1.0)
+	advertiseService(s, "B", "svc")
+	advertiseService(s, "C", "svc")
+	failing := make(map[string]bool)
+	dialed := make([]string, 0)
+	dial := func() string {
+		dialed = dialed[:0]
+		_, err := s.dialAny(ctx, "svc", func(nodeID string) (*Conn, error) {
+			dialed = append(dialed, nodeID)
+			if failing[nodeID] {
+				return nil, fmt.Errorf("connection to %s refused", nodeID)
+			}
+
+			return &Conn{}, nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return dialed[len(dialed)-1]
+	}
+
+	// The first dial resolves the nearest node and caches it
+	if node := dial(); node != "C" {
+		t.Fatalf("expected the nearest node C to be dialed, got %s", node)
+	}
+	entries := s.ServiceCache()
+	if len(entries) != 1 || entries[0].Service != "svc" || entries[0].NodeID != "C" || !entries[0].Resolved.Equal(now) {
+		t.Fatalf("expected svc to be cached on C, got %v", entries)
+	}
+
+	// B becomes as near as C, which would be dialed first, but the cached node keeps being dialed
+	now = now.Add(time.Minute)
+	setCosts(s, 2, 1.0, 1.0)
+	if node := dial(); node != "C" {
+		t.Fatalf("expected the cached node C to be dialed, got %s", node)
+	}
+	if entries := s.ServiceCache(); !entries[0].Resolved.Equal(now.Add(-time.Minute)) {
+		t.Fatalf("expected the resolution time to be kept, got %s", entries[0].Resolved)
+	}
+
+	// Clearing the cache forces the service to be resolved again
+	if cleared := s.ClearServiceCache("other"); len(cleared) != 0 {
+		t.Fatalf("expected nothing to be cleared for an uncached service, got %v", cleared)
+	}
+	if cleared := s.ClearServiceCache("svc"); len(cleared) != 1 || cleared[0] != "svc" {
+		t.Fatalf("expected svc to be cleared, got %v", cleared)
+	}
+	if entries := s.ServiceCache(); len(entries) != 0 {
+		t.Fatalf("expected the cache to be empty, got %v", entries)
+	}
+	if node := dial(); node != "B" {
+		t.Fatalf("expected the service to be resolved again to B, got %s", node)
+	}
+
+	// Once C is nearer, the service is resolved again to it
+	now = now.Add(time.Minute)
+	setCosts(s, 3, 2.0, 1.0)
+	if node := dial(); node != "C" || len(dialed) != 1 {
+		t.Fatalf("expected only the nearer node C to be dialed, got %v", dialed)
+	}
+	if entries := s.ServiceCache(); len(entries) != 1 || entries[0].NodeID != "C" || !entries[0].Resolved.Equal(now) {
+		t.Fatalf("expected svc to be cached on C, got %v", entries)
+	}
+
+	// An entry is resolved again once it expires
+	now = now.Add(serviceCacheTTL)
+	if node := dial(); node != "C" {
+		t.Fatalf("expected the nearest node C to be dialed, got %s", node)
+	}
+	if entries := s.ServiceCache(); !entries[0].Resolved.Equal(now) {
+		t.Fatalf("expected svc to be resolved again after %s, got %s", serviceCacheTTL, entries[0].Resolved)
+	}
+
+	// A cached node that cannot be reached is dropped in favor of the next one
+	failing["C"] = true
+	if node := dial(); node != "B" || len(dialed) != 2 {
+		t.Fatalf("expected C then B to be dialed, got %v", dialed)
+	}
+	if entries := s.ServiceCache(); entries[0].NodeID != "B" {
+		t.Fatalf("expected svc to be cached on B, got %v", entries)
+	}
+
+	// A cached node that stops advertising the service is not dialed
+	failing["C"] = false
+	s.serviceAdsLock.Lock()
+	delete(s.serviceAdsReceived["B"], "svc")
+	s.serviceAdsLock.Unlock()
+	if node := dial(); node != "C" || len(dialed) != 1 {
+		t.Fatalf("expected only C to be dialed, got %v", dialed)
+	}
+
+	if cleared := s.ClearServiceCache(""); len(cleared) != 1 {
+		t.Fatalf("expected every service to be cleared, got %v", cleared)
+	}
+}
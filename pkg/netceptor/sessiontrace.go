@@ -0,0 +1,386 @@
+package netceptor
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ansible/receptor/pkg/logger"
+	"github.com/ghjm/cmdline"
+)
+
+// SessionTraceMagic is written at the start of every session trace file.
+const SessionTraceMagic = "RCPTRC01"
+
+// DefaultSessionTraceMaxSize is the size at which a session trace file is rotated.
+const DefaultSessionTraceMaxSize = 10 * 1024 * 1024
+
+// Directions of a traced frame.
+const (
+	TraceSent     byte = 'S'
+	TraceReceived byte = 'R'
+)
+
+// TraceRecord is a single frame recorded in a session trace.  On disk, a record is the direction
+// byte, the time as 8 bytes of Unix nanoseconds, the length of the frame as 4 bytes, and then
+// the frame itself, with all integers big-endian.
+type TraceRecord struct {
+	Direction byte
+	Time      time.Time
+	Data      []byte
+}
+
+const traceRecordHeaderLen = 13
+
+// sessionTraceCounter distinguishes trace files of sessions started at the same time.
+var sessionTraceCounter uint64
+
+// SetSessionTrace enables recording of all frames on new backend sessions to files in dir.
+// Each file is rotated when it reaches maxSize bytes, keeping one previous file, so a session
+// uses at most twice maxSize bytes.  An empty dir disables recording.
+func (s *Netceptor) SetSessionTrace(dir string, maxSize int64) error {
+	if dir != "" {
+		err := os.MkdirAll(dir, 0o700)
+		if err != nil {
+			return err
+		}
+	}
+	if maxSize <= 0 {
+		maxSize = DefaultSessionTraceMaxSize
+	}
+	s.sessionTraceLock.Lock()
+	defer s.sessionTraceLock.Unlock()
+	s.sessionTraceDir = dir
+	s.sessionTraceMaxSize = maxSize
+
+	return nil
+}
+
+// traceSession wraps a backend session in a recorder, if session tracing is enabled.
+func (s *Netceptor) traceSession(sess BackendSession) BackendSession {
+	s.sessionTraceLock.RLock()
+	dir := s.sessionTraceDir
+	maxSize := s.sessionTraceMaxSize
+	s.sessionTraceLock.RUnlock()
+	if dir == "" {
+		return sess
+	}
+	filename := filepath.Join(dir, fmt.Sprintf("%s-%d-%d.rtrace", s.nodeID, time.Now().UnixNano(),
+		atomic.AddUint64(&sessionTraceCounter, 1)))
+	rs, err := NewRecordingSession(sess, filename, maxSize)
+	if err != nil {
+		logger.Error("Error starting session trace %s: %s\n", filename, err)
+
+		return sess
+	}
+	logger.Debug("Recording session trace to %s\n", filename)
+
+	return rs
+}
+
+// RecordingSession is a BackendSession that records all frames sent and received to a trace file.
+type RecordingSession struct {
+	BackendSession
+	lock     sync.Mutex
+	filename string
+	maxSize  int64
+	file     *os.File
+	writer   *bufio.Writer
+	size     int64
+	err      error
+}
+
+// NewRecordingSession wraps a BackendSession, recording its frames to filename.  When the file
+// reaches maxSize bytes, it is renamed with a .1 suffix and a new file is started.
+func NewRecordingSession(sess BackendSession, filename string, maxSize int64) (*RecordingSession, error) {
+	rs := &RecordingSession{
+		BackendSession: sess,
+		filename:       filename,
+		maxSize:        maxSize,
+	}
+	err := rs.openFile()
+	if err != nil {
+		return nil, err
+	}
+
+	return rs, nil
+}
+
+// openFile starts a new trace file.  Must be called with the lock held, or before the session is in use.
+func (rs *RecordingSession) openFile() error {
+	file, err := os.OpenFile(rs.filename, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	rs.file = file
+	rs.writer = bufio.NewWriter(file)
+	n, err := rs.writer.WriteString(SessionTraceMagic)
+	rs.size = int64(n)
+
+	return err
+}
+
+// closeFile flushes and closes the current trace file.  Must be called with the lock held.
+func (rs *RecordingSession) closeFile() error {
+	if rs.file == nil {
+		return nil
+	}
+	err := rs.writer.Flush()
+	if cerr := rs.file.Close(); err == nil {
+		err = cerr
+	}
+	rs.file = nil
+
+	return err
+}
+
+// record writes a frame to the trace file.  Errors stop the recording, but not the session.
+func (rs *RecordingSession) record(direction byte, data []byte) {
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+	if rs.err != nil || rs.file == nil {
+		return
+	}
+	recLen := int64(traceRecordHeaderLen + len(data))
+	if rs.size > int64(len(SessionTraceMagic)) && rs.size+recLen > rs.maxSize {
+		rs.err = rs.closeFile()
+		if rs.err == nil {
+			rs.err = os.Rename(rs.filename, rs.filename+".1")
+		}
+		if rs.err == nil {
+			rs.err = rs.openFile()
+		}
+		if rs.err != nil {
+			logger.Error("Error rotating session trace %s: %s\n", rs.filename, rs.err)
+
+			return
+		}
+	}
+	hdr := make([]byte, traceRecordHeaderLen)
+	hdr[0] = direction
+	binary.BigEndian.PutUint64(hdr[1:9], uint64(time.Now().UnixNano()))
+	binary.BigEndian.PutUint32(hdr[9:13], uint32(len(data)))
+	_, rs.err = rs.writer.Write(hdr)
+	if rs.err == nil {
+		_, rs.err = rs.writer.Write(data)
+	}
+	if rs.err != nil {
+		logger.Error("Error writing session trace %s: %s\n", rs.filename, rs.err)
+
+		return
+	}
+	rs.size += recLen
+}
+
+// Send records and sends a frame.
+func (rs *RecordingSession) Send(data []byte) error {
+	rs.record(TraceSent, data)
+
+	return rs.BackendSession.Send(data)
+}
+
+// SetWriteDeadline sets the write deadline of the recorded session, if it supports one.
+func (rs *RecordingSession) SetWriteDeadline(t time.Time) error {
+	if wd, ok := rs.BackendSession.(WriteDeadlineSession); ok {
+		return wd.SetWriteDeadline(t)
+	}
+
+	return nil
+}
+
+// ListenPath returns the listener path of the recorded session, if it has one.
+func (rs *RecordingSession) ListenPath() string {
+	if lp, ok := rs.BackendSession.(ListenPathSession); ok {
+		return lp.ListenPath()
+	}
+
+	return ""
+}
+
+// Recv receives and records a frame.
+func (rs *RecordingSession) Recv(timeout time.Duration) ([]byte, error) {
+	data, err := rs.BackendSession.Recv(timeout)
+	if err == nil {
+		rs.record(TraceReceived, data)
+	}
+
+	return data, err
+}
+
+// Close closes the session and the trace file.
+func (rs *RecordingSession) Close() error {
+	rs.lock.Lock()
+	err := rs.closeFile()
+	rs.lock.Unlock()
+	if err != nil {
+		logger.Error("Error closing session trace %s: %s\n", rs.filename, err)
+	}
+
+	return rs.BackendSession.Close()
+}
+
+// ReadSessionTrace reads all the records from a session trace.
+func ReadSessionTrace(r io.Reader) ([]TraceRecord, error) {
+	br := bufio.NewReader(r)
+	magic := make([]byte, len(SessionTraceMagic))
+	_, err := io.ReadFull(br, magic)
+	if err != nil || string(magic) != SessionTraceMagic {
+		return nil, fmt.Errorf("not a session trace")
+	}
+	records := make([]TraceRecord, 0)
+	hdr := make([]byte, traceRecordHeaderLen)
+	for {
+		_, err := io.ReadFull(br, hdr)
+		if err == io.EOF {
+			return records, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("truncated session trace: %s", err)
+		}
+		rec := TraceRecord{
+			Direction: hdr[0],
+			Time:      time.Unix(0, int64(binary.BigEndian.Uint64(hdr[1:9]))),
+			Data:      make([]byte, binary.BigEndian.Uint32(hdr[9:13])),
+		}
+		_, err = io.ReadFull(br, rec.Data)
+		if err != nil {
+			return nil, fmt.Errorf("truncated session trace: %s", err)
+		}
+		records = append(records, rec)
+	}
+}
+
+// ReplaySession is a BackendSession that plays back the frames received in a session trace.
+// Frames sent to it are kept, so they can be compared to the frames sent in the trace.
+type ReplaySession struct {
+	lock     sync.Mutex
+	received [][]byte
+	sent     [][]byte
+	closed   chan struct{}
+	once     sync.Once
+}
+
+// NewReplaySession creates a session that will receive the frames recorded as received in a trace.
+func NewReplaySession(records []TraceRecord) *ReplaySession {
+	rs := &ReplaySession{
+		received: make([][]byte, 0),
+		sent:     make([][]byte, 0),
+		closed:   make(chan struct{}),
+	}
+	for _, rec := range records {
+		if rec.Direction == TraceReceived {
+			rs.received = append(rs.received, rec.Data)
+		}
+	}
+
+	return rs
+}
+
+// Send keeps a frame sent to the replay session.
+func (rs *ReplaySession) Send(data []byte) error {
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+	rs.sent = append(rs.sent, append([]byte{}, data...))
+
+	return nil
+}
+
+// Recv returns the next recorded frame.  Once all the frames have been replayed, it waits for the timeout.
+func (rs *ReplaySession) Recv(timeout time.Duration) ([]byte, error) {
+	rs.lock.Lock()
+	if len(rs.received) > 0 {
+		data := rs.received[0]
+		rs.received = rs.received[1:]
+		rs.lock.Unlock()
+
+		return data, nil
+	}
+	rs.lock.Unlock()
+	select {
+	case <-rs.closed:
+		return nil, io.EOF
+	case <-time.After(timeout):
+		return nil, ErrTimeout
+	}
+}
+
+// Close closes the replay session.
+func (rs *ReplaySession) Close() error {
+	rs.once.Do(func() {
+		close(rs.closed)
+	})
+
+	return nil
+}
+
+// Sent returns the frames that have been sent to the replay session.
+func (rs *ReplaySession) Sent() [][]byte {
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+
+	return append([][]byte{}, rs.sent...)
+}
+
+// ReplayBackend is a Backend that provides a single ReplaySession, for replaying a trace into a Netceptor.
+type ReplayBackend struct {
+	Session *ReplaySession
+}
+
+// NewReplayBackend creates a backend that replays the frames received in a session trace.
+func NewReplayBackend(records []TraceRecord) *ReplayBackend {
+	return &ReplayBackend{
+		Session: NewReplaySession(records),
+	}
+}
+
+// Start provides the replay session to the Netceptor.
+func (rb *ReplayBackend) Start(ctx context.Context, wg *sync.WaitGroup) (chan BackendSession, error) {
+	sessChan := make(chan BackendSession, 1)
+	sessChan <- rb.Session
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		<-ctx.Done()
+		_ = rb.Session.Close()
+		close(sessChan)
+	}()
+
+	return sessChan, nil
+}
+
+// **************************************************************************
+// Command line
+// **************************************************************************
+
+// sessionTraceCfg is the cmdline configuration object for session tracing.
+type sessionTraceCfg struct {
+	Dir     string `description:"Directory to record backend session traces in" required:"true"`
+	MaxSize int64  `description:"Size in bytes at which a session trace file is rotated" default:"10485760"`
+}
+
+// Prepare verifies the parameters are correct.
+func (cfg sessionTraceCfg) Prepare() error {
+	if cfg.MaxSize < 0 {
+		return fmt.Errorf("session trace max size must not be negative")
+	}
+
+	return nil
+}
+
+// Run runs the action.
+func (cfg sessionTraceCfg) Run() error {
+	logger.Warning("Recording all backend sessions to %s. This is for debugging only.\n", cfg.Dir)
+
+	return MainInstance.SetSessionTrace(cfg.Dir, cfg.MaxSize)
+}
+
+func init() {
+	cmdline.RegisterConfigTypeForApp("receptor-logging",
+		"session-trace", "Record all backend session frames for replay (debugging only)", sessionTraceCfg{}, cmdline.Singleton)
+}
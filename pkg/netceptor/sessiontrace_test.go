@@ -0,0 +1,278 @@
+package netceptor
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// pipeSession is one end of an in-memory BackendSession pair.  Frames it receives are also
+// copied to a tap, so tests can see what went over the wire.
+type pipeSession struct {
+	in     chan []byte
+	out    chan []byte
+	closed chan struct{}
+	once   *sync.Once
+	tap    *[][]byte
+	tapMu  *sync.Mutex
+}
+
+func newPipeSessions() (*pipeSession, *pipeSession) {
+	a2b := make(chan []byte, 100)
+	b2a := make(chan []byte, 100)
+	closed := make(chan struct{})
+	once := &sync.Once{}
+	aTap := make([][]byte, 0)
+	bTap := make([][]byte, 0)
+	a := &pipeSession{in: b2a, out: a2b, closed: closed, once: once, tap: &aTap, tapMu: &sync.Mutex{}}
+	b := &pipeSession{in: a2b, out: b2a, closed: closed, once: once, tap: &bTap, tapMu: &sync.Mutex{}}
+
+	return a, b
+}
+
+func (p *pipeSession) Send(data []byte) error {
+	select {
+	case p.out <- append([]byte{}, data...):
+		return nil
+	case <-p.closed:
+		return io.EOF
+	}
+}
+
+func (p *pipeSession) Recv(timeout time.Duration) ([]byte, error) {
+	select {
+	case data := <-p.in:
+		p.tapMu.Lock()
+		*p.tap = append(*p.tap, data)
+		p.tapMu.Unlock()
+
+		return data, nil
+	case <-p.closed:
+		return nil, io.EOF
+	case <-time.After(timeout):
+		return nil, ErrTimeout
+	}
+}
+
+func (p *pipeSession) Close() error {
+	p.once.Do(func() { close(p.closed) })
+
+	return nil
+}
+
+func (p *pipeSession) received() [][]byte {
+	p.tapMu.Lock()
+	defer p.tapMu.Unlock()
+
+	return append([][]byte{}, *p.tap...)
+}
+
+// pipeBackend provides a single pipeSession.
+type pipeBackend struct {
+	sess BackendSession
+}
+
+func (pb *pipeBackend) Start(ctx context.Context, wg *sync.WaitGroup) (chan BackendSession, error) {
+	sessChan := make(chan BackendSession, 1)
+	sessChan <- pb.sess
+
+	return sessChan, nil
+}
+
+func TestRecordingSession(t *testing.T) {
+	dir, err := ioutil.TempDir("", "receptor-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	a, b := newPipeSessions()
+	filename := filepath.Join(dir, "test.rtrace")
+	rs, err := NewRecordingSession(a, filename, DefaultSessionTraceMaxSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exchange := []TraceRecord{
+		{Direction: TraceSent, Data: []byte("hello")},
+		{Direction: TraceReceived, Data: []byte("world")},
+		{Direction: TraceSent, Data: []byte{0, 1, 2, 255}},
+		{Direction: TraceReceived, Data: []byte{}},
+	}
+	for _, rec := range exchange {
+		if rec.Direction == TraceSent {
+			err = rs.Send(rec.Data)
+		} else {
+			err = b.Send(rec.Data)
+			if err == nil {
+				_, err = rs.Recv(time.Second)
+			}
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = rs.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	records, err := ReadSessionTrace(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != len(exchange) {
+		t.Fatalf("expected %d records, got %d", len(exchange), len(records))
+	}
+	for i := range records {
+		if records[i].Direction != exchange[i].Direction || !bytes.Equal(records[i].Data, exchange[i].Data) {
+			t.Fatalf("record %d: expected %c %v, got %c %v", i, exchange[i].Direction, exchange[i].Data,
+				records[i].Direction, records[i].Data)
+		}
+	}
+
+	replay := NewReplaySession(records)
+	for _, expected := range [][]byte{[]byte("world"), {}} {
+		data, err := replay.Recv(time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, expected) {
+			t.Fatalf("expected replayed frame %v, got %v", expected, data)
+		}
+	}
+	if _, err := replay.Recv(10 * time.Millisecond); err != ErrTimeout {
+		t.Fatalf("expected timeout after replay, got %v", err)
+	}
+}
+
+func TestRecordingSessionRotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "receptor-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	a, _ := newPipeSessions()
+	filename := filepath.Join(dir, "test.rtrace")
+	rs, err := NewRecordingSession(a, filename, 200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	frame := bytes.Repeat([]byte("x"), 50)
+	for i := 0; i < 20; i++ {
+		if err := rs.Send(frame); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := rs.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for _, fn := range []string{filename, filename + ".1"} {
+		fi, err := os.Stat(fn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fi.Size() > 200 {
+			t.Fatalf("trace file %s is %d bytes, over the limit", fn, fi.Size())
+		}
+	}
+}
+
+func TestSessionTraceReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "receptor-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Record a real session between two nodes
+	nA := New(ctx, "A", nil)
+	nB := New(ctx, "B", nil)
+	err = nA.SetSessionTrace(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sa, sb := newPipeSessions()
+	if err := nA.AddBackend(&pipeBackend{sess: sa}, 1.0, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := nB.AddBackend(&pipeBackend{sess: sb}, 1.0, nil); err != nil {
+		t.Fatal(err)
+	}
+	knowsB := func(n *Netceptor) bool {
+		_, ok := n.Status().RoutingTable["B"]
+
+		return ok
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	for !knowsB(nA) {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for A to connect to B")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	nA.Shutdown()
+	nB.Shutdown()
+	nA.BackendWait()
+
+	files, err := filepath.Glob(filepath.Join(dir, "A-*.rtrace"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Fatalf("expected one trace file, got %v", files)
+	}
+	f, err := os.Open(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	records, err := ReadSessionTrace(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	received := make([][]byte, 0)
+	for _, rec := range records {
+		if rec.Direction == TraceReceived {
+			received = append(received, rec.Data)
+		}
+	}
+	wire := sa.received()
+	if len(received) != len(wire) {
+		t.Fatalf("trace has %d received frames, but %d went over the wire", len(received), len(wire))
+	}
+	for i := range wire {
+		if !bytes.Equal(received[i], wire[i]) {
+			t.Fatalf("received frame %d differs from the wire", i)
+		}
+	}
+
+	// Replaying the frames A received into a new node teaches it about B
+	rb := NewReplayBackend(records)
+	nReplay := New(ctx, "A", nil)
+	defer nReplay.Shutdown()
+	if err := nReplay.AddBackend(rb, 1.0, nil); err != nil {
+		t.Fatal(err)
+	}
+	deadline = time.Now().Add(10 * time.Second)
+	for !knowsB(nReplay) {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the replayed session to connect to B")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if len(rb.Session.Sent()) == 0 {
+		t.Fatal("replaying node did not send anything")
+	}
+}
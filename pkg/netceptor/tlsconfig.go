@@ -1,3 +1,4 @@
+//go:build !no_tls_config
 // +build !no_tls_config
 
 package netceptor
@@ -8,6 +9,8 @@ import (
 	"fmt"
 	"io/ioutil"
 
+	receptortls "github.com/ansible/receptor/pkg/tls"
+	"github.com/ansible/receptor/pkg/utils"
 	"github.com/ghjm/cmdline"
 )
 
@@ -22,11 +25,17 @@ var configSection = &cmdline.ConfigSection{
 
 // tlsServerCfg stores the configuration options for a TLS server.
 type tlsServerCfg struct {
-	Name              string `required:"true" description:"Name of this TLS server configuration"`
-	Cert              string `required:"true" description:"Server certificate filename"`
-	Key               string `required:"true" description:"Server private key filename"`
-	RequireClientCert bool   `description:"Require client certificates" default:"false"`
-	ClientCAs         string `description:"Filename of CA bundle to verify client certs with"`
+	Name              string   `required:"true" description:"Name of this TLS server configuration"`
+	Cert              string   `required:"true" description:"Server certificate filename"`
+	Key               string   `required:"true" description:"Server private key filename"`
+	RequireClientCert bool     `description:"Require client certificates" default:"false"`
+	ClientCAs         string   `description:"Filename of CA bundle to verify client certs with"`
+	CipherSuites      []string `description:"Cipher suites to allow for TLS 1.2 and below (default: ECDHE with AEAD only)"`
+	Curves            []string `description:"Elliptic curves to allow for key exchange (X25519, P256, P384, P521)"`
+	MinVersion        string   `description:"Minimum TLS version (1.2 or 1.3)" default:"1.2"`
+	MaxVersion        string   `description:"Maximum TLS version (1.2 or 1.3, default: highest supported)"`
+	SessionTickets    bool     `description:"Issue session tickets so clients can resume sessions" default:"true"`
+	SessionTicketKeys []string `description:"Session ticket keys as 64 hex digits, the first encrypting new tickets (default: generated)" redact:"true"`
 }
 
 // Prepare creates the tls.config and stores it in the global map.
@@ -67,16 +76,38 @@ func (cfg tlsServerCfg) Prepare() error {
 		tlscfg.ClientAuth = tls.NoClientCert
 	}
 
+	err = receptortls.ApplyCipherConfig(tlscfg, cfg.CipherSuites, cfg.Curves)
+	if err != nil {
+		return err
+	}
+
+	err = receptortls.ApplyVersionConfig(tlscfg, cfg.MinVersion, cfg.MaxVersion)
+	if err != nil {
+		return err
+	}
+
+	err = receptortls.ApplySessionTicketConfig(tlscfg, !cfg.SessionTickets, cfg.SessionTicketKeys)
+	if err != nil {
+		return err
+	}
+
+	utils.RecordEffectiveConfig("tls-server", cfg)
+
 	return MainInstance.SetServerTLSConfig(cfg.Name, tlscfg)
 }
 
 // tlsClientConfig stores the configuration options for a TLS client.
 type tlsClientConfig struct {
-	Name               string `required:"true" description:"Name of this TLS client configuration"`
-	Cert               string `required:"false" description:"Client certificate filename"`
-	Key                string `required:"false" description:"Client private key filename"`
-	RootCAs            string `required:"false" description:"Root CA bundle to use instead of system trust"`
-	InsecureSkipVerify bool   `required:"false" description:"Accept any server cert" default:"false"`
+	Name               string   `required:"true" description:"Name of this TLS client configuration"`
+	Cert               string   `required:"false" description:"Client certificate filename"`
+	Key                string   `required:"false" description:"Client private key filename"`
+	RootCAs            string   `required:"false" description:"Root CA bundle to use instead of system trust"`
+	InsecureSkipVerify bool     `required:"false" description:"Accept any server cert" default:"false"`
+	CipherSuites       []string `required:"false" description:"Cipher suites to allow for TLS 1.2 and below (default: ECDHE with AEAD only)"`
+	Curves             []string `required:"false" description:"Elliptic curves to allow for key exchange (X25519, P256, P384, P521)"`
+	MinVersion         string   `required:"false" description:"Minimum TLS version (1.2 or 1.3)" default:"1.2"`
+	MaxVersion         string   `required:"false" description:"Maximum TLS version (1.2 or 1.3, default: highest supported)"`
+	SessionResumption  bool     `required:"false" description:"Cache sessions so reconnections can skip the full handshake" default:"false"`
 }
 
 // Prepare creates the tls.config and stores it in the global map.
@@ -115,6 +146,20 @@ func (cfg tlsClientConfig) Prepare() error {
 
 	tlscfg.InsecureSkipVerify = cfg.InsecureSkipVerify
 
+	err := receptortls.ApplyCipherConfig(tlscfg, cfg.CipherSuites, cfg.Curves)
+	if err != nil {
+		return err
+	}
+
+	err = receptortls.ApplyVersionConfig(tlscfg, cfg.MinVersion, cfg.MaxVersion)
+	if err != nil {
+		return err
+	}
+
+	receptortls.ApplySessionResumptionConfig(tlscfg, cfg.SessionResumption)
+
+	utils.RecordEffectiveConfig("tls-client", cfg)
+
 	return MainInstance.SetClientTLSConfig(cfg.Name, tlscfg)
 }
 
@@ -0,0 +1,113 @@
+package netceptor
+
+import (
+	"sort"
+)
+
+// DefaultMaxTrafficCounters is the number of node and service pairs that traffic is counted for separately.
+const DefaultMaxTrafficCounters = 1000
+
+// TrafficOverflow is the node and service name of the counter that collects traffic for pairs beyond the limit.
+const TrafficOverflow = "*"
+
+// TrafficCounter holds the traffic between this node and a service on another node, or between another node
+// and a service on this node.
+type TrafficCounter struct {
+	Node        string
+	Service     string
+	BytesIn     uint64
+	BytesOut    uint64
+	PacketsIn   uint64
+	PacketsOut  uint64
+	Connections uint64
+}
+
+type trafficKey struct {
+	node    string
+	service string
+}
+
+// trafficCounter returns the counter for a node and service, creating it if there is room.  The caller must
+// hold trafficLock.
+func (s *Netceptor) trafficCounter(node string, service string) *TrafficCounter {
+	key := trafficKey{node: node, service: service}
+	tc, ok := s.traffic[key]
+	if ok {
+		return tc
+	}
+	if len(s.traffic) >= s.maxTrafficCounters {
+		key = trafficKey{node: TrafficOverflow, service: TrafficOverflow}
+		if tc, ok = s.traffic[key]; ok {
+			return tc
+		}
+	}
+	tc = &TrafficCounter{Node: key.node, Service: key.service}
+	s.traffic[key] = tc
+
+	return tc
+}
+
+// countTraffic records a packet exchanged with a node, for a service.
+func (s *Netceptor) countTraffic(node string, service string, inbound bool, length int) {
+	s.trafficLock.Lock()
+	defer s.trafficLock.Unlock()
+	tc := s.trafficCounter(node, service)
+	if inbound {
+		tc.BytesIn += uint64(length)
+		tc.PacketsIn++
+	} else {
+		tc.BytesOut += uint64(length)
+		tc.PacketsOut++
+	}
+}
+
+// countConnection records a stream connection made with a node, for a service.
+func (s *Netceptor) countConnection(node string, service string) {
+	s.trafficLock.Lock()
+	defer s.trafficLock.Unlock()
+	s.trafficCounter(node, service).Connections++
+}
+
+// SetMaxTrafficCounters sets the number of node and service pairs that traffic is counted for separately.
+// Traffic for further pairs is added to a single overflow counter.
+func (s *Netceptor) SetMaxTrafficCounters(limit int) {
+	s.trafficLock.Lock()
+	defer s.trafficLock.Unlock()
+	s.maxTrafficCounters = limit
+}
+
+// TrafficCounters returns the traffic counters, sorted by node and service.
+func (s *Netceptor) TrafficCounters() []TrafficCounter {
+	s.trafficLock.Lock()
+	defer s.trafficLock.Unlock()
+
+	return s.sortedTrafficCounters()
+}
+
+// ResetTrafficCounters clears the traffic counters, and returns their values from before they were cleared.
+func (s *Netceptor) ResetTrafficCounters() []TrafficCounter {
+	s.trafficLock.Lock()
+	defer s.trafficLock.Unlock()
+	counters := s.sortedTrafficCounters()
+	s.traffic = make(map[trafficKey]*TrafficCounter)
+
+	return counters
+}
+
+// sortedTrafficCounters returns a copy of the traffic counters, sorted by node and service.  The caller
+// must hold trafficLock.
+func (s *Netceptor) sortedTrafficCounters() []TrafficCounter {
+	counters := make([]TrafficCounter, 0, len(s.traffic))
+	for _, tc := range s.traffic {
+		counters = append(counters, *tc)
+	}
+	sort.Slice(counters, func(i, j int) bool {
+		if counters[i].Node != counters[j].Node {
+			return counters[i].Node < counters[j].Node
+		}
+
+		return counters[i].Service < counters[j].Service
+	})
+
+	return counters
+}
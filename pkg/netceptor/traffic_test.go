@@ -0,0 +1,101 @@
+package netceptor
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+)
+
+func findTrafficCounter(counters []TrafficCounter, node string, service string) TrafficCounter {
+	for _, tc := range counters {
+		if tc.Node == node && tc.Service == service {
+			return tc
+		}
+	}
+
+	return TrafficCounter{}
+}
+
+func TestTrafficCounters(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	a := New(ctx, "A", nil)
+	b := New(ctx, "B", nil)
+	linkNodes(t, a, b, nil)
+	waitForRoute(t, b, "A", TrafficClassDefault, "A")
+
+	server, err := a.ListenPacket("echo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	client, err := b.ListenPacket("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	payload := bytes.Repeat([]byte("x"), 100)
+	buf := make([]byte, 1024)
+	for i := 0; i < 3; i++ {
+		if _, err := client.WriteTo(payload, a.NewAddr("A", "echo")); err != nil {
+			t.Fatal(err)
+		}
+		_ = server.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, addr, err := server.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := server.WriteTo(buf[:n/2], addr); err != nil {
+			t.Fatal(err)
+		}
+		_ = client.SetReadDeadline(time.Now().Add(5 * time.Second))
+		if _, _, err := client.ReadFrom(buf); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Both sides count the traffic under the named service, not the client's ephemeral one
+	expected := TrafficCounter{Node: "A", Service: "echo", BytesIn: 150, BytesOut: 300, PacketsIn: 3, PacketsOut: 3}
+	if tc := findTrafficCounter(b.TrafficCounters(), "A", "echo"); tc != expected {
+		t.Fatalf("expected B's counter %+v, got %+v", expected, tc)
+	}
+	expected = TrafficCounter{Node: "B", Service: "echo", BytesIn: 300, BytesOut: 150, PacketsIn: 3, PacketsOut: 3}
+	if tc := findTrafficCounter(a.TrafficCounters(), "B", "echo"); tc != expected {
+		t.Fatalf("expected A's counter %+v, got %+v", expected, tc)
+	}
+
+	// Resetting returns the counters and starts again from zero
+	if counters := a.ResetTrafficCounters(); findTrafficCounter(counters, "B", "echo").BytesIn != 300 {
+		t.Fatalf("expected reset to return the counters, got %+v", counters)
+	}
+	if counters := a.TrafficCounters(); len(counters) != 0 {
+		t.Fatalf("expected no counters after reset, got %+v", counters)
+	}
+}
+
+func TestTrafficCounterLimit(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := New(ctx, "A", nil)
+	s.SetMaxTrafficCounters(2)
+	s.countTraffic("B", "svc1", false, 10)
+	s.countTraffic("B", "svc2", false, 10)
+	s.countTraffic("C", "svc1", false, 10)
+	s.countConnection("D", "svc1")
+	s.countTraffic("B", "svc1", true, 5)
+	counters := s.TrafficCounters()
+	if len(counters) != 3 {
+		t.Fatalf("expected two counters and the overflow counter, got %+v", counters)
+	}
+	overflow := findTrafficCounter(counters, TrafficOverflow, TrafficOverflow)
+	if overflow.BytesOut != 10 || overflow.Connections != 1 {
+		t.Fatalf("expected traffic beyond the limit in the overflow counter, got %+v", overflow)
+	}
+	if tc := findTrafficCounter(counters, "B", "svc1"); tc.BytesOut != 10 || tc.BytesIn != 5 {
+		t.Fatalf("expected existing counters to keep counting, got %+v", tc)
+	}
+	if counters[0].Node != TrafficOverflow || counters[1].Service != "svc1" || counters[2].Service != "svc2" {
+		t.Fatalf("expected counters sorted by node and service, got %+v", counters)
+	}
+}
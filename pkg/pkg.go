@@ -6,12 +6,14 @@ import (
 	"fmt"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/ansible/receptor/pkg/backends"
 	"github.com/ansible/receptor/pkg/controlsvc"
 	"github.com/ansible/receptor/pkg/logger"
 	"github.com/ansible/receptor/pkg/netceptor"
 	"github.com/ansible/receptor/pkg/services"
+	"github.com/ansible/receptor/pkg/utils"
 	"github.com/ansible/receptor/pkg/workceptor"
 )
 
@@ -22,12 +24,34 @@ var ErrNoBackends = errors.New("no backends were specified in serve config")
 type Receptor struct {
 	// Overrides the default loglevel on the root logger.
 	LogLevel *string `mapstructure:"log-level"`
+	// File to write log output to, instead of stdout. Reopened on SIGHUP or a logrotate control command.
+	LogFile string `mapstructure:"log-file"`
 	// Enable receptor packet tracing.
 	EnableTracing bool `mapstructure:"enable-tracing"`
 	// Node ID. Defaults to local hostname.
 	ID *string `mapstructure:"id"`
 	// List of peer node-IDs to allow.
 	AllowedPeers []string `mapstructure:"allowed-peers"`
+	// Human-friendly name for this node, shown in status. Unlike the node ID, it does not affect routing.
+	DisplayName string `mapstructure:"display-name"`
+	// Role of this node in the mesh: full, transit or edge.
+	Role string `mapstructure:"role"`
+	// Maximum number of times a message sent by this node may be forwarded. Defaults to 30.
+	MaxHops *int `mapstructure:"max-hops"`
+	// Close a backend connection when sending to it blocks for this long, or 0 to disable. Defaults to 60s.
+	SendTimeout *string `mapstructure:"send-timeout"`
+	// Most bytes of session data that backends may buffer in memory, or 0 for no limit. Defaults to 64MiB.
+	SessionBufferLimit *int64 `mapstructure:"session-buffer-limit"`
+	// Evict a node from the routing table when it has sent no routing update for this long, or 0 to disable.
+	RouteMaxAge *string `mapstructure:"route-max-age"`
+	// Warn when a neighbor's clock differs from ours by more than this, or 0 to disable. Defaults to 5s.
+	ClockSkewThreshold *string `mapstructure:"clock-skew-threshold"`
+	// Upper bounds in bytes of the buckets of each backend session's message size histograms.
+	MessageSizeBuckets []int `mapstructure:"message-size-buckets"`
+	// File to write, holding the process ID, once a backend connection is up.
+	ReadinessFile string `mapstructure:"readiness-file"`
+	// Notify systemd with sd_notify READY=1 once a backend connection is up.
+	ReadinessSystemd bool `mapstructure:"readiness-systemd"`
 	// Directory in which to store node data.
 	DataDir     string                  `mapstructure:"data-dir"`
 	Backends    *backends.Backends      `mapstructure:"backends"`
@@ -48,6 +72,13 @@ func (r Receptor) Serve(ctx context.Context) error {
 		logger.SetLogLevel(val)
 	}
 
+	if r.LogFile != "" {
+		if err := logger.SetLogFile(r.LogFile); err != nil {
+			return fmt.Errorf("log file in serve config is invalid: %w", err)
+		}
+		logger.ReopenLogFileOnSIGHUP()
+	}
+
 	var id string
 	var err error
 	if r.ID == nil {
@@ -64,6 +95,54 @@ func (r Receptor) Serve(ctx context.Context) error {
 	}
 
 	nc := netceptor.New(ctx, id, r.AllowedPeers)
+	if err := nc.SetRole(r.Role); err != nil {
+		return fmt.Errorf("node role in serve config is invalid: %w", err)
+	}
+	if err := nc.SetNodeDisplayName(r.DisplayName); err != nil {
+		return fmt.Errorf("display name in serve config is invalid: %w", err)
+	}
+	if r.MaxHops != nil {
+		if err := nc.SetMaxForwardingHops(*r.MaxHops); err != nil {
+			return fmt.Errorf("max hops in serve config is invalid: %w", err)
+		}
+	}
+	if r.SendTimeout != nil {
+		sendTimeout, err := time.ParseDuration(*r.SendTimeout)
+		if err != nil {
+			return fmt.Errorf("send timeout in serve config is invalid: %w", err)
+		}
+		if err := nc.SetSendTimeout(sendTimeout); err != nil {
+			return fmt.Errorf("send timeout in serve config is invalid: %w", err)
+		}
+	}
+	if r.SessionBufferLimit != nil {
+		if err := netceptor.SessionBufferBudget.SetLimit(*r.SessionBufferLimit); err != nil {
+			return fmt.Errorf("session buffer limit in serve config is invalid: %w", err)
+		}
+	}
+	if r.RouteMaxAge != nil {
+		routeMaxAge, err := time.ParseDuration(*r.RouteMaxAge)
+		if err != nil {
+			return fmt.Errorf("route max age in serve config is invalid: %w", err)
+		}
+		if err := nc.SetRouteMaxAge(routeMaxAge); err != nil {
+			return fmt.Errorf("route max age in serve config is invalid: %w", err)
+		}
+	}
+	if r.ClockSkewThreshold != nil {
+		clockSkewThreshold, err := time.ParseDuration(*r.ClockSkewThreshold)
+		if err != nil {
+			return fmt.Errorf("clock skew threshold in serve config is invalid: %w", err)
+		}
+		if err := nc.SetClockSkewThreshold(clockSkewThreshold); err != nil {
+			return fmt.Errorf("clock skew threshold in serve config is invalid: %w", err)
+		}
+	}
+	if r.MessageSizeBuckets != nil {
+		if err := nc.SetMessageSizeBuckets(r.MessageSizeBuckets); err != nil {
+			return fmt.Errorf("message size buckets in serve config are invalid: %w", err)
+		}
+	}
 	wc, err := workceptor.New(ctx, nc, r.DataDir)
 	if err != nil {
 		return fmt.Errorf("could not setup workceptor from serve config: %w", err)
@@ -102,6 +181,25 @@ func (r Receptor) Serve(ctx context.Context) error {
 		return ErrNoBackends
 	}
 
+	if r.ReadinessFile != "" {
+		if err := utils.RemoveReadinessFile(r.ReadinessFile); err != nil {
+			return fmt.Errorf("could not remove readiness file from serve config: %w", err)
+		}
+		defer func() {
+			_ = utils.RemoveReadinessFile(r.ReadinessFile)
+		}()
+	}
+	if r.ReadinessFile != "" || r.ReadinessSystemd {
+		go func() {
+			err := nc.NotifyWhenReady(func() error {
+				return utils.NotifyReady(r.ReadinessFile, r.ReadinessSystemd)
+			})
+			if err != nil {
+				logger.Error("Error signalling readiness: %s\n", err)
+			}
+		}()
+	}
+
 	nc.BackendWait()
 
 	return nil
@@ -0,0 +1,86 @@
+//go:build !no_proxies && !no_services
+// +build !no_proxies,!no_services
+
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ansible/receptor/pkg/logger"
+	"github.com/ansible/receptor/pkg/netceptor"
+	"github.com/ansible/receptor/pkg/tls"
+)
+
+// Delays between attempts to listen on and advertise a service: first for the first retry, then
+// doubling up to max.
+var (
+	advertiseRetryFirstDelay = 500 * time.Millisecond
+	advertiseRetryMaxDelay   = 30 * time.Second
+)
+
+// listenAndAdvertiseWithRetry listens on and advertises a service, then calls serve with the listener.
+// If the first attempt fails, it keeps trying in the background with backoff until it succeeds or the
+// node shuts down, so that a transient failure at startup does not leave the service permanently down.
+// A service name that can never be listened on, or a service that something else on this node is already
+// listening on, is returned as an error, since retrying would not help.
+func listenAndAdvertiseWithRetry(s *netceptor.Netceptor, service string, tlscfg *tls.Config, tags map[string]string,
+	serve func(*netceptor.Listener)) error {
+	return listenWithRetry(s.Context(), service, func() (*netceptor.Listener, error) {
+		return s.ListenAndAdvertise(service, tlscfg, tags)
+	}, serve)
+}
+
+// listenWithRetry calls listen until it succeeds or ctx is done, then calls serve with the listener.  The
+// first attempt is made before returning.
+func listenWithRetry(ctx context.Context, service string, listen func() (*netceptor.Listener, error),
+	serve func(*netceptor.Listener)) error {
+	if len(service) > netceptor.MaxServiceNameLength {
+		return fmt.Errorf("error listening on Receptor network: service name %s too long", service)
+	}
+	li, err := listen()
+	if err == nil {
+		serve(li)
+
+		return nil
+	}
+	if errors.Is(err, netceptor.ErrAlreadyListening) {
+		return fmt.Errorf("error listening on Receptor network: %w", err)
+	}
+	delay, maxDelay := advertiseRetryFirstDelay, advertiseRetryMaxDelay
+	logger.Warning("Error listening on Receptor network for service %s (attempt 1), retrying in %s: %s\n",
+		service, delay, err)
+	go func() {
+		for attempt := 2; ; attempt++ {
+			select {
+			case <-ctx.Done():
+				logger.Info("Stopped trying to listen on Receptor network for service %s\n", service)
+
+				return
+			case <-time.After(delay):
+			}
+			li, err := listen()
+			if err == nil {
+				logger.Info("Listening on Receptor network for service %s after %d attempts\n", service, attempt)
+				serve(li)
+
+				return
+			}
+			if errors.Is(err, netceptor.ErrAlreadyListening) {
+				logger.Error("Stopped trying to listen on Receptor network for service %s: %s\n", service, err)
+
+				return
+			}
+			delay *= 2
+			if delay > maxDelay {
+				delay = maxDelay
+			}
+			logger.Warning("Error listening on Receptor network for service %s (attempt %d), retrying in %s: %s\n",
+				service, attempt, delay, err)
+		}
+	}()
+
+	return nil
+}
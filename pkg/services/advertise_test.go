@@ -0,0 +1,107 @@
+//go:build !no_proxies && !no_services
+// +build !no_proxies,!no_services
+
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ansible/receptor/pkg/netceptor"
+)
+
+// setAdvertiseRetryDelays shortens the delays between listen attempts for the duration of a test.
+func setAdvertiseRetryDelays(t *testing.T, first time.Duration, max time.Duration) {
+	oldFirst, oldMax := advertiseRetryFirstDelay, advertiseRetryMaxDelay
+	advertiseRetryFirstDelay, advertiseRetryMaxDelay = first, max
+	t.Cleanup(func() {
+		advertiseRetryFirstDelay, advertiseRetryMaxDelay = oldFirst, oldMax
+	})
+}
+
+func TestListenWithRetry(t *testing.T) {
+	setAdvertiseRetryDelays(t, 10*time.Millisecond, 40*time.Millisecond)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// The first attempts fail, as if the node were not yet ready
+	attempts := int32(0)
+	served := make(chan *netceptor.Listener, 1)
+	expected := &netceptor.Listener{}
+	err := listenWithRetry(ctx, "proxy", func() (*netceptor.Listener, error) {
+		if atomic.AddInt32(&attempts, 1) <= 3 {
+			return nil, fmt.Errorf("temporary failure")
+		}
+
+		return expected, nil
+	}, func(li *netceptor.Listener) {
+		served <- li
+	})
+	if err != nil {
+		t.Fatalf("expected a failed first attempt to be retried, got %s", err)
+	}
+	select {
+	case li := <-served:
+		if li != expected {
+			t.Fatal("expected the listener from the successful attempt to be served")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("service was not listened on after %d attempts", atomic.LoadInt32(&attempts))
+	}
+	if n := atomic.LoadInt32(&attempts); n != 4 {
+		t.Fatalf("expected 4 attempts, got %d", n)
+	}
+
+	// Retrying stops when the context is done
+	stopped := int32(0)
+	stopCtx, stop := context.WithCancel(context.Background())
+	err = listenWithRetry(stopCtx, "proxy", func() (*netceptor.Listener, error) {
+		atomic.AddInt32(&stopped, 1)
+
+		return nil, fmt.Errorf("temporary failure")
+	}, func(li *netceptor.Listener) {
+		t.Error("expected no listener to be served")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	stop()
+	time.Sleep(100 * time.Millisecond)
+	after := atomic.LoadInt32(&stopped)
+	time.Sleep(100 * time.Millisecond)
+	if n := atomic.LoadInt32(&stopped); n != after || n < 2 {
+		t.Fatalf("expected retries until the context was done and none after, got %d then %d attempts", after, n)
+	}
+
+	// A service that is already in use is not retried
+	inUse := int32(0)
+	err = listenWithRetry(ctx, "proxy", func() (*netceptor.Listener, error) {
+		atomic.AddInt32(&inUse, 1)
+
+		return nil, fmt.Errorf("service proxy is %w", netceptor.ErrAlreadyListening)
+	}, func(li *netceptor.Listener) {
+		t.Error("expected no listener to be served")
+	})
+	if !errors.Is(err, netceptor.ErrAlreadyListening) {
+		t.Fatalf("expected a service in use to be refused, got %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if n := atomic.LoadInt32(&inUse); n != 1 {
+		t.Fatalf("expected a single attempt for a service in use, got %d", n)
+	}
+
+	// A service name that can never be listened on is not retried
+	err = listenWithRetry(ctx, "toolongname", func() (*netceptor.Listener, error) {
+		t.Error("expected no attempt to listen on an invalid service name")
+
+		return nil, fmt.Errorf("service name too long")
+	}, func(li *netceptor.Listener) {})
+	if err == nil {
+		t.Fatal("expected a service name that is too long to be refused")
+	}
+}
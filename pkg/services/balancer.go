@@ -0,0 +1,173 @@
+//go:build !no_proxies && !no_services
+// +build !no_proxies,!no_services
+
+package services
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/ansible/receptor/pkg/logger"
+	"github.com/ansible/receptor/pkg/tls"
+)
+
+// Target selection policies for proxies that forward to more than one remote service.
+const (
+	// BalanceRoundRobin tries the targets in turn.
+	BalanceRoundRobin = "round-robin"
+	// BalanceRandom tries the targets in a random order.
+	BalanceRandom = "random"
+	// BalanceLeastConnections tries the target with the fewest active connections first.
+	BalanceLeastConnections = "least-connections"
+)
+
+// ErrNoTargets is returned when a balanced dial has no target to try.
+var ErrNoTargets = errors.New("no targets")
+
+// ProxyTarget is a remote node and service that a proxy forwards connections to.
+type ProxyTarget struct {
+	Node    string
+	Service string
+	TLS     *tls.Config
+}
+
+// String returns the target in node:service form.
+func (t ProxyTarget) String() string {
+	return fmt.Sprintf("%s:%s", t.Node, t.Service)
+}
+
+// ParseProxyTarget parses a target given in node:service form.
+func ParseProxyTarget(s string) (ProxyTarget, error) {
+	parts := strings.SplitN(s, ":", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return ProxyTarget{}, fmt.Errorf("invalid target %s: must be node:service", s)
+	}
+
+	return ProxyTarget{Node: parts[0], Service: parts[1]}, nil
+}
+
+// ValidBalancePolicy returns an error if policy is not a known target selection policy.
+func ValidBalancePolicy(policy string) error {
+	switch policy {
+	case BalanceRoundRobin, BalanceRandom, BalanceLeastConnections:
+		return nil
+	}
+
+	return fmt.Errorf("invalid balance policy %s: must be %s, %s or %s", policy,
+		BalanceRoundRobin, BalanceRandom, BalanceLeastConnections)
+}
+
+// balancerTarget is a target along with its dial breaker and active connection count.
+type balancerTarget struct {
+	ProxyTarget
+	breaker *dialBreaker
+	active  int
+}
+
+// targetBalancer spreads connections across several targets according to a policy.  Each target has
+// its own dial breaker, so a target that is down fails fast and the next one is tried.
+type targetBalancer struct {
+	policy  string
+	targets []*balancerTarget
+	lock    sync.Mutex
+	next    int
+}
+
+// newTargetBalancer creates a new targetBalancer.
+func newTargetBalancer(targets []ProxyTarget, policy string, bcfg DialBreakerConfig) (*targetBalancer, error) {
+	if len(targets) == 0 {
+		return nil, ErrNoTargets
+	}
+	if err := ValidBalancePolicy(policy); err != nil {
+		return nil, err
+	}
+	tb := &targetBalancer{
+		policy: policy,
+	}
+	for _, t := range targets {
+		tb.targets = append(tb.targets, &balancerTarget{
+			ProxyTarget: t,
+			breaker:     newDialBreaker(t.String(), bcfg),
+		})
+	}
+
+	return tb, nil
+}
+
+// String returns the targets of the balancer.
+func (tb *targetBalancer) String() string {
+	names := make([]string, 0, len(tb.targets))
+	for _, t := range tb.targets {
+		names = append(names, t.String())
+	}
+
+	return strings.Join(names, ",")
+}
+
+// order returns the targets in the order they should be tried for the next connection.
+func (tb *targetBalancer) order() []*balancerTarget {
+	tb.lock.Lock()
+	defer tb.lock.Unlock()
+	order := make([]*balancerTarget, 0, len(tb.targets))
+	switch tb.policy {
+	case BalanceRandom:
+		for _, i := range rand.Perm(len(tb.targets)) {
+			order = append(order, tb.targets[i])
+		}
+	case BalanceLeastConnections:
+		// Ties are broken round-robin, so idle targets share the load
+		start := tb.next
+		tb.next = (tb.next + 1) % len(tb.targets)
+		for i := range tb.targets {
+			order = append(order, tb.targets[(start+i)%len(tb.targets)])
+		}
+		for i := 1; i < len(order); i++ {
+			for j := i; j > 0 && order[j].active < order[j-1].active; j-- {
+				order[j], order[j-1] = order[j-1], order[j]
+			}
+		}
+	default:
+		for i := range tb.targets {
+			order = append(order, tb.targets[(tb.next+i)%len(tb.targets)])
+		}
+		tb.next = (tb.next + 1) % len(tb.targets)
+	}
+
+	return order
+}
+
+// Dial connects to the first target in policy order that accepts the connection.  On success it
+// returns the chosen target and a function that must be called when the connection is finished.
+func (tb *targetBalancer) Dial(dialFunc func(ProxyTarget) (net.Conn, error)) (net.Conn, ProxyTarget, func(), error) {
+	var err error
+	for _, t := range tb.order() {
+		t := t
+		var conn net.Conn
+		conn, err = t.breaker.Dial(func() (net.Conn, error) {
+			return dialFunc(t.ProxyTarget)
+		})
+		if err != nil {
+			if len(tb.targets) > 1 {
+				logger.Warning("Could not connect to target %s: %s\n", t, err)
+			}
+
+			continue
+		}
+		tb.lock.Lock()
+		t.active++
+		tb.lock.Unlock()
+		done := func() {
+			tb.lock.Lock()
+			t.active--
+			tb.lock.Unlock()
+		}
+
+		return conn, t.ProxyTarget, done, nil
+	}
+
+	return nil, ProxyTarget{}, nil, err
+}
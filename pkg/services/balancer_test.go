@@ -0,0 +1,172 @@
+//go:build !no_proxies && !no_services
+// +build !no_proxies,!no_services
+
+package services
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+// loopbackTargets returns targets whose dials succeed with an in-memory connection, except for
+// the nodes listed as down.
+func loopbackTargets(nodes []string, down ...string) ([]ProxyTarget, func(ProxyTarget) (net.Conn, error)) {
+	targets := make([]ProxyTarget, 0, len(nodes))
+	for _, node := range nodes {
+		targets = append(targets, ProxyTarget{Node: node, Service: "echo"})
+	}
+	dial := func(t ProxyTarget) (net.Conn, error) {
+		for _, d := range down {
+			if t.Node == d {
+				return nil, fmt.Errorf("node %s is down", t.Node)
+			}
+		}
+		c1, c2 := net.Pipe()
+		_ = c2.Close()
+
+		return c1, nil
+	}
+
+	return targets, dial
+}
+
+var balancerTestConfig = DialBreakerConfig{
+	Retries:          0,
+	RetryDelay:       time.Millisecond,
+	FailureThreshold: 2,
+	Cooldown:         time.Minute,
+}
+
+func TestBalancerRoundRobin(t *testing.T) {
+	targets, dial := loopbackTargets([]string{"A", "B", "C"})
+	tb, err := newTargetBalancer(targets, BalanceRoundRobin, balancerTestConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var chosen []string
+	for i := 0; i < 6; i++ {
+		conn, target, done, err := tb.Dial(dial)
+		if err != nil {
+			t.Fatal(err)
+		}
+		chosen = append(chosen, target.Node)
+		_ = conn.Close()
+		done()
+	}
+	if fmt.Sprint(chosen) != "[A B C A B C]" {
+		t.Fatalf("expected connections in turn, got %v", chosen)
+	}
+}
+
+func TestBalancerRandom(t *testing.T) {
+	targets, dial := loopbackTargets([]string{"A", "B", "C"})
+	tb, err := newTargetBalancer(targets, BalanceRandom, balancerTestConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	counts := make(map[string]int)
+	for i := 0; i < 300; i++ {
+		conn, target, done, err := tb.Dial(dial)
+		if err != nil {
+			t.Fatal(err)
+		}
+		counts[target.Node]++
+		_ = conn.Close()
+		done()
+	}
+	for _, node := range []string{"A", "B", "C"} {
+		if counts[node] < 50 {
+			t.Fatalf("expected connections to be spread across all targets, got %v", counts)
+		}
+	}
+}
+
+func TestBalancerLeastConnections(t *testing.T) {
+	targets, dial := loopbackTargets([]string{"A", "B", "C"})
+	tb, err := newTargetBalancer(targets, BalanceLeastConnections, balancerTestConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	counts := make(map[string]int)
+	dones := make(map[string][]func())
+	for i := 0; i < 6; i++ {
+		_, target, done, err := tb.Dial(dial)
+		if err != nil {
+			t.Fatal(err)
+		}
+		counts[target.Node]++
+		dones[target.Node] = append(dones[target.Node], done)
+	}
+	if counts["A"] != 2 || counts["B"] != 2 || counts["C"] != 2 {
+		t.Fatalf("expected open connections to be spread evenly, got %v", counts)
+	}
+
+	// Once B's connections finish, it is the least busy target
+	for _, done := range dones["B"] {
+		done()
+	}
+	for i := 0; i < 2; i++ {
+		_, target, _, err := tb.Dial(dial)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if target.Node != "B" {
+			t.Fatalf("expected the idle target B to be chosen, got %s", target.Node)
+		}
+	}
+}
+
+func TestBalancerSkipsDownTarget(t *testing.T) {
+	for _, policy := range []string{BalanceRoundRobin, BalanceRandom, BalanceLeastConnections} {
+		targets, dial := loopbackTargets([]string{"A", "B", "C"}, "B")
+		tb, err := newTargetBalancer(targets, policy, balancerTestConfig)
+		if err != nil {
+			t.Fatal(err)
+		}
+		counts := make(map[string]int)
+		for i := 0; i < 30; i++ {
+			conn, target, done, err := tb.Dial(dial)
+			if err != nil {
+				t.Fatalf("%s: %s", policy, err)
+			}
+			counts[target.Node]++
+			_ = conn.Close()
+			done()
+		}
+		if counts["B"] != 0 || counts["A"] == 0 || counts["C"] == 0 {
+			t.Fatalf("%s: expected the down target to be skipped, got %v", policy, counts)
+		}
+	}
+
+	// With every target down, the last error is returned
+	targets, dial := loopbackTargets([]string{"A", "B"}, "A", "B")
+	tb, err := newTargetBalancer(targets, BalanceRoundRobin, balancerTestConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, err := tb.Dial(dial); err == nil {
+		t.Fatal("expected an error when every target is down")
+	}
+}
+
+func TestBalancerConfig(t *testing.T) {
+	if _, err := newTargetBalancer(nil, BalanceRoundRobin, balancerTestConfig); !errors.Is(err, ErrNoTargets) {
+		t.Fatalf("expected ErrNoTargets, got %v", err)
+	}
+	targets, _ := loopbackTargets([]string{"A"})
+	if _, err := newTargetBalancer(targets, "fastest", balancerTestConfig); err == nil {
+		t.Fatal("expected an invalid policy to be rejected")
+	}
+	target, err := ParseProxyTarget("node1:echo")
+	if err != nil || target.Node != "node1" || target.Service != "echo" {
+		t.Fatalf("unexpected parse result %+v (%v)", target, err)
+	}
+	for _, s := range []string{"node1", ":echo", "node1:"} {
+		if _, err := ParseProxyTarget(s); err == nil {
+			t.Errorf("expected %q to be rejected", s)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+//go:build !no_proxies && !no_services
+// +build !no_proxies,!no_services
+
+package services
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/ansible/receptor/pkg/logger"
+	"github.com/ansible/receptor/pkg/utils"
+)
+
+// ErrBreakerOpen is returned when a dial is not attempted because the circuit breaker is open.
+var ErrBreakerOpen = errors.New("circuit breaker is open")
+
+// Circuit breaker states.
+const (
+	BreakerClosed = iota
+	BreakerOpen
+	BreakerHalfOpen
+)
+
+// DialBreakerConfig controls retries and circuit breaking of outbound dials from a proxy.
+type DialBreakerConfig struct {
+	// Number of retries after a failed dial, before giving up on a connection.
+	Retries int
+	// Delay before the first retry.  Doubles on each subsequent retry.
+	RetryDelay time.Duration
+	// Number of consecutive failed connections that opens the breaker.  Zero disables the breaker.
+	FailureThreshold int
+	// How long the breaker stays open before allowing a trial connection.
+	Cooldown time.Duration
+}
+
+// DefaultDialBreakerConfig is used by proxies that are not given an explicit configuration.
+var DefaultDialBreakerConfig = DialBreakerConfig{
+	Retries:          2,
+	RetryDelay:       500 * time.Millisecond,
+	FailureThreshold: 5,
+	Cooldown:         30 * time.Second,
+}
+
+// dialBreaker retries failed dials with backoff, and after repeated failures fast-fails all dials
+// for a cooldown period.  Once the cooldown expires, one trial dial is allowed through (half-open);
+// if it succeeds the breaker closes, and if it fails the breaker opens again.
+type dialBreaker struct {
+	name     string
+	cfg      DialBreakerConfig
+	lock     sync.Mutex
+	state    int
+	failures int
+	openedAt time.Time
+	trial    bool
+	now      func() time.Time
+}
+
+// newDialBreaker creates a new dialBreaker.  The name is used in log messages.
+func newDialBreaker(name string, cfg DialBreakerConfig) *dialBreaker {
+	return &dialBreaker{
+		name:  name,
+		cfg:   cfg,
+		state: BreakerClosed,
+		now:   time.Now,
+	}
+}
+
+// State returns the current state of the breaker.
+func (b *dialBreaker) State() int {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.updateState()
+
+	return b.state
+}
+
+// updateState moves an open breaker to half-open once the cooldown has expired.  Must be called with the lock held.
+func (b *dialBreaker) updateState() {
+	if b.state == BreakerOpen && b.now().Sub(b.openedAt) >= b.cfg.Cooldown {
+		b.state = BreakerHalfOpen
+		b.trial = false
+	}
+}
+
+// allow reports whether a dial may be attempted.
+func (b *dialBreaker) allow() bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.updateState()
+	switch b.state {
+	case BreakerOpen:
+		return false
+	case BreakerHalfOpen:
+		if b.trial {
+			return false
+		}
+		b.trial = true
+	}
+
+	return true
+}
+
+// recordSuccess closes the breaker.
+func (b *dialBreaker) recordSuccess() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.state != BreakerClosed {
+		logger.Info("Circuit breaker for %s closed\n", b.name)
+	}
+	b.state = BreakerClosed
+	b.failures = 0
+	b.trial = false
+}
+
+// recordFailure counts a failed connection, opening the breaker if the threshold is reached.
+func (b *dialBreaker) recordFailure() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.failures++
+	if b.cfg.FailureThreshold <= 0 {
+		return
+	}
+	if b.state == BreakerHalfOpen || b.failures >= b.cfg.FailureThreshold {
+		if b.state != BreakerOpen {
+			logger.Warning("Circuit breaker for %s opened after %d failures; failing fast for %s\n",
+				b.name, b.failures, b.cfg.Cooldown)
+		}
+		b.state = BreakerOpen
+		b.openedAt = b.now()
+		b.trial = false
+	}
+}
+
+// Dial calls dialFunc, retrying with backoff on failure, unless the breaker is open.
+func (b *dialBreaker) Dial(dialFunc func() (net.Conn, error)) (net.Conn, error) {
+	if !b.allow() {
+		return nil, fmt.Errorf("%w for %s", ErrBreakerOpen, b.name)
+	}
+	retryDelay := utils.NewIncrementalDuration(b.cfg.RetryDelay, 16*b.cfg.RetryDelay, 2)
+	var err error
+	for attempt := 0; ; attempt++ {
+		var conn net.Conn
+		conn, err = dialFunc()
+		if err == nil {
+			b.recordSuccess()
+
+			return conn, nil
+		}
+		if attempt >= b.cfg.Retries || b.State() == BreakerHalfOpen {
+			break
+		}
+		logger.Debug("Dial to %s failed (will retry): %s\n", b.name, err)
+		<-retryDelay.NextTimeout()
+	}
+	b.recordFailure()
+
+	return nil, err
+}
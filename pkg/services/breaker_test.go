@@ -0,0 +1,86 @@
+//go:build !no_proxies && !no_services
+// +build !no_proxies,!no_services
+
+package services
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestDialBreaker(t *testing.T) {
+	now := time.Now()
+	b := newDialBreaker("test", DialBreakerConfig{
+		Retries:          1,
+		RetryDelay:       time.Millisecond,
+		FailureThreshold: 3,
+		Cooldown:         time.Minute,
+	})
+	b.now = func() time.Time { return now }
+	attempts := 0
+	failingDial := func() (net.Conn, error) {
+		attempts++
+
+		return nil, fmt.Errorf("dial failed")
+	}
+
+	// Each connection is retried once, and the breaker opens after three failed connections
+	for i := 0; i < 3; i++ {
+		if b.State() != BreakerClosed {
+			t.Fatalf("breaker opened after only %d failures", i)
+		}
+		_, err := b.Dial(failingDial)
+		if err == nil || errors.Is(err, ErrBreakerOpen) {
+			t.Fatalf("expected dial error, got %v", err)
+		}
+	}
+	if attempts != 6 {
+		t.Fatalf("expected 6 dial attempts, got %d", attempts)
+	}
+	if b.State() != BreakerOpen {
+		t.Fatal("breaker did not open after repeated failures")
+	}
+
+	// While open, dials fail fast without being attempted
+	_, err := b.Dial(failingDial)
+	if !errors.Is(err, ErrBreakerOpen) {
+		t.Fatalf("expected ErrBreakerOpen, got %v", err)
+	}
+	if attempts != 6 {
+		t.Fatal("dial was attempted while breaker was open")
+	}
+
+	// After the cooldown, a single trial is allowed, and failure re-opens the breaker
+	now = now.Add(time.Minute)
+	if b.State() != BreakerHalfOpen {
+		t.Fatal("breaker did not half-open after cooldown")
+	}
+	_, err = b.Dial(failingDial)
+	if err == nil || errors.Is(err, ErrBreakerOpen) {
+		t.Fatalf("expected trial dial error, got %v", err)
+	}
+	if attempts != 7 {
+		t.Fatalf("expected a single trial attempt, got %d", attempts-6)
+	}
+	if b.State() != BreakerOpen {
+		t.Fatal("breaker did not re-open after failed trial")
+	}
+
+	// A successful trial closes the breaker
+	now = now.Add(time.Minute)
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+	conn, err := b.Dial(func() (net.Conn, error) {
+		return c1, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.Close()
+	if b.State() != BreakerClosed {
+		t.Fatal("breaker did not close after successful trial")
+	}
+}
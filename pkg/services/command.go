@@ -65,6 +65,7 @@ type commandSvcCfg struct {
 
 // Run runs the action.
 func (cfg commandSvcCfg) Run() error {
+	utils.RecordEffectiveConfig("command-service", cfg)
 	logger.Info("Running command service %s\n", cfg)
 	tlscfg, err := netceptor.MainInstance.GetServerTLSConfig(cfg.TLS)
 	if err != nil {
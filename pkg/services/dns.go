@@ -0,0 +1,329 @@
+//go:build linux && !no_ip_router && !no_dns_service && !no_services
+// +build linux,!no_ip_router,!no_dns_service,!no_services
+
+package services
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ansible/receptor/pkg/logger"
+	"github.com/ansible/receptor/pkg/netceptor"
+	"github.com/ansible/receptor/pkg/utils"
+	"github.com/ghjm/cmdline"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// dnsTTL is the time to live of DNS answers, in seconds.  It is short because the mesh changes often.
+const dnsTTL = 10
+
+// DNSService answers DNS queries for the mesh-internal addresses of nodes and services.
+// Within its zone, <node>.<zone> and <service>.<node>.<zone> resolve to the address of the
+// node's IP router, and _<service>._tcp.<zone> or _<service>._udp.<zone> resolve to SRV
+// records for every node advertising the service.
+type DNSService struct {
+	zone    string
+	network string
+	ads     func() ([]*netceptor.ServiceAdvertisement, map[string]string)
+}
+
+// NewDNSService creates a DNS service for a zone, answering with the addresses of IP routers on the
+// given network, or on any network if network is empty.
+func NewDNSService(nc *netceptor.Netceptor, zone string, network string) *DNSService {
+	return newDNSService(zone, network, func() ([]*netceptor.ServiceAdvertisement, map[string]string) {
+		status := nc.Status()
+
+		return status.Advertisements, status.RoutingTable
+	})
+}
+
+func newDNSService(zone string, network string,
+	ads func() ([]*netceptor.ServiceAdvertisement, map[string]string)) *DNSService {
+	zone = strings.ToLower(strings.Trim(zone, "."))
+
+	return &DNSService{
+		zone:    zone,
+		network: network,
+		ads:     ads,
+	}
+}
+
+// dnsNode is what the DNS service knows about a reachable node.
+type dnsNode struct {
+	addrs    []net.IP
+	services map[string]*netceptor.ServiceAdvertisement
+}
+
+// nodes collects the addresses and services of all reachable nodes, keyed by lower case node ID.
+func (d *DNSService) nodes() map[string]*dnsNode {
+	ads, routes := d.ads()
+	nodes := make(map[string]*dnsNode)
+	for _, ad := range ads {
+		if _, ok := routes[ad.NodeID]; !ok {
+			continue
+		}
+		nodeName := strings.ToLower(ad.NodeID)
+		node, ok := nodes[nodeName]
+		if !ok {
+			node = &dnsNode{services: make(map[string]*netceptor.ServiceAdvertisement)}
+			nodes[nodeName] = node
+		}
+		node.services[strings.ToLower(ad.Service)] = ad
+		if ad.Tags["type"] != adTypeIPRouter || (d.network != "" && ad.Tags["network"] != d.network) {
+			continue
+		}
+		_, localNet, err := net.ParseCIDR(ad.Tags["route_local"])
+		if err != nil {
+			continue
+		}
+		// The IP router's own address is the first host address of its local network
+		addr := make(net.IP, len(localNet.IP))
+		copy(addr, localNet.IP)
+		addr[len(addr)-1]++
+		node.addrs = append(node.addrs, addr)
+	}
+
+	return nodes
+}
+
+// dnsAnswer is a single answer record.
+type dnsAnswer struct {
+	ip     net.IP
+	target string
+	port   uint16
+}
+
+// lookup finds the answers for a name within the zone.  The returned bool is false if the name does not exist.
+func (d *DNSService) lookup(name string, qtype dnsmessage.Type) ([]dnsAnswer, bool) {
+	labels := strings.Split(name, ".")
+	nodes := d.nodes()
+	if len(labels) == 2 && strings.HasPrefix(labels[0], "_") && (labels[1] == "_tcp" || labels[1] == "_udp") {
+		service := strings.TrimPrefix(labels[0], "_")
+		answers := make([]dnsAnswer, 0)
+		nodeNames := make([]string, 0, len(nodes))
+		for nodeName := range nodes {
+			nodeNames = append(nodeNames, nodeName)
+		}
+		sort.Strings(nodeNames)
+		for _, nodeName := range nodeNames {
+			ad, ok := nodes[nodeName].services[service]
+			if !ok {
+				continue
+			}
+			var port uint16
+			if _, portStr, err := net.SplitHostPort(ad.Tags["address"]); err == nil {
+				p, _ := strconv.ParseUint(portStr, 10, 16)
+				port = uint16(p)
+			}
+			answers = append(answers, dnsAnswer{target: fmt.Sprintf("%s.%s.", nodeName, d.zone), port: port})
+		}
+		if len(answers) == 0 {
+			return nil, false
+		}
+		if qtype != dnsmessage.TypeSRV {
+			return nil, true
+		}
+
+		return answers, true
+	}
+	var node *dnsNode
+	switch len(labels) {
+	case 1:
+		node = nodes[labels[0]]
+	case 2:
+		node = nodes[labels[1]]
+		if node != nil && node.services[labels[0]] == nil {
+			node = nil
+		}
+	}
+	if node == nil {
+		return nil, false
+	}
+	answers := make([]dnsAnswer, 0)
+	for _, addr := range node.addrs {
+		if (qtype == dnsmessage.TypeA && addr.To4() != nil) || (qtype == dnsmessage.TypeAAAA && addr.To4() == nil) {
+			answers = append(answers, dnsAnswer{ip: addr})
+		}
+	}
+
+	return answers, true
+}
+
+// answer builds the response to a DNS query.
+func (d *DNSService) answer(query []byte) ([]byte, error) {
+	var parser dnsmessage.Parser
+	hdr, err := parser.Start(query)
+	if err != nil {
+		return nil, err
+	}
+	question, err := parser.Question()
+	if err != nil {
+		return nil, err
+	}
+	respHdr := dnsmessage.Header{
+		ID:            hdr.ID,
+		Response:      true,
+		Authoritative: true,
+		RCode:         dnsmessage.RCodeSuccess,
+	}
+	name := strings.ToLower(strings.TrimSuffix(question.Name.String(), "."))
+	var answers []dnsAnswer
+	switch {
+	case question.Class != dnsmessage.ClassINET:
+		respHdr.RCode = dnsmessage.RCodeNotImplemented
+	case name == d.zone:
+	case !strings.HasSuffix(name, "."+d.zone):
+		respHdr.Authoritative = false
+		respHdr.RCode = dnsmessage.RCodeRefused
+	default:
+		var ok bool
+		answers, ok = d.lookup(strings.TrimSuffix(name, "."+d.zone), question.Type)
+		if !ok {
+			respHdr.RCode = dnsmessage.RCodeNameError
+		}
+	}
+	builder := dnsmessage.NewBuilder(make([]byte, 0, 512), respHdr)
+	builder.EnableCompression()
+	err = builder.StartQuestions()
+	if err == nil {
+		err = builder.Question(question)
+	}
+	if err == nil {
+		err = builder.StartAnswers()
+	}
+	rh := dnsmessage.ResourceHeader{Name: question.Name, Class: dnsmessage.ClassINET, TTL: dnsTTL}
+	for _, a := range answers {
+		if err != nil {
+			break
+		}
+		switch {
+		case a.target != "":
+			var target dnsmessage.Name
+			target, err = dnsmessage.NewName(a.target)
+			if err == nil {
+				err = builder.SRVResource(rh, dnsmessage.SRVResource{Target: target, Port: a.port})
+			}
+		case a.ip.To4() != nil:
+			r := dnsmessage.AResource{}
+			copy(r.A[:], a.ip.To4())
+			err = builder.AResource(rh, r)
+		default:
+			r := dnsmessage.AAAAResource{}
+			copy(r.AAAA[:], a.ip.To16())
+			err = builder.AAAAResource(rh, r)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return builder.Finish()
+}
+
+// Serve answers DNS queries on a packet connection until it is closed.
+func (d *DNSService) Serve(pc net.PacketConn) {
+	buf := make([]byte, 512)
+	for {
+		n, addr, err := pc.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		resp, err := d.answer(buf[:n])
+		if err != nil {
+			logger.Debug("Ignoring invalid DNS query from %s: %s\n", addr, err)
+
+			continue
+		}
+		_, err = pc.WriteTo(resp, addr)
+		if err != nil {
+			logger.Warning("Error sending DNS response to %s: %s\n", addr, err)
+		}
+	}
+}
+
+// DNSServiceListen runs a DNS service on a local UDP address.
+func DNSServiceListen(nc *netceptor.Netceptor, address string, zone string, network string) error {
+	pc, err := net.ListenPacket("udp", address)
+	if err != nil {
+		return fmt.Errorf("error listening for DNS queries on %s: %s", address, err)
+	}
+	go func() {
+		<-nc.Context().Done()
+		_ = pc.Close()
+	}()
+	go NewDNSService(nc, zone, network).Serve(pc)
+
+	return nil
+}
+
+// dnsServiceCfg is the cmdline configuration object for a DNS service.
+type dnsServiceCfg struct {
+	Address string `description:"Local UDP address to answer DNS queries on" default:"127.0.0.1:5353"`
+	Zone    string `description:"DNS zone that mesh names are served under" default:"receptor"`
+	Network string `description:"Name of the IP router network to resolve addresses on (default all)"`
+}
+
+// Prepare verifies the parameters are correct.
+func (cfg dnsServiceCfg) Prepare() error {
+	if strings.Trim(cfg.Zone, ".") == "" {
+		return fmt.Errorf("DNS zone must not be empty")
+	}
+	_, _, err := net.SplitHostPort(cfg.Address)
+
+	return err
+}
+
+// Run runs the action.
+func (cfg dnsServiceCfg) Run() error {
+	utils.RecordEffectiveConfig("dns-service", cfg)
+	logger.Info("Running DNS service for zone %s on %s\n", cfg.Zone, cfg.Address)
+
+	return DNSServiceListen(netceptor.MainInstance, cfg.Address, cfg.Zone, cfg.Network)
+}
+
+func init() {
+	cmdline.RegisterConfigTypeForApp("receptor-ip-router",
+		"dns-service", "Answer DNS queries for mesh-internal node and service addresses", dnsServiceCfg{}, cmdline.Section(servicesSection))
+}
+
+// DNS answers DNS queries for mesh-internal node and service addresses.
+type DNS struct {
+	// Local UDP address to answer DNS queries on. Defaults to 127.0.0.1:5353.
+	Address string `mapstructure:"address"`
+	// DNS zone that mesh names are served under. Defaults to receptor.
+	Zone string `mapstructure:"zone"`
+	// Name of the IP router network to resolve addresses on. Defaults to all.
+	Network string `mapstructure:"network"`
+}
+
+func (s *DNS) setup(nc *netceptor.Netceptor) error {
+	address := s.Address
+	if address == "" {
+		address = "127.0.0.1:5353"
+	}
+	zone := s.Zone
+	if zone == "" {
+		zone = "receptor"
+	}
+
+	return DNSServiceListen(nc, address, zone, s.Network)
+}
+
+// DNSServices holds the DNS services of a services config.
+type DNSServices struct {
+	// Answer DNS queries for mesh names.
+	DNS []DNS `mapstructure:"dns"`
+}
+
+func (d DNSServices) setup(nc *netceptor.Netceptor) error {
+	for _, s := range d.DNS {
+		if err := s.setup(nc); err != nil {
+			return fmt.Errorf("could not setup dns service from service config: %w", err)
+		}
+	}
+
+	return nil
+}
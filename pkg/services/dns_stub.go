@@ -0,0 +1,15 @@
+//go:build linux && !no_ip_router && no_dns_service && !no_services
+// +build linux,!no_ip_router,no_dns_service,!no_services
+
+package services
+
+import (
+	"github.com/ansible/receptor/pkg/netceptor"
+)
+
+// DNSServices holds the DNS services of a services config.  The DNS service is not built in, so there are none.
+type DNSServices struct{}
+
+func (d DNSServices) setup(nc *netceptor.Netceptor) error {
+	return nil
+}
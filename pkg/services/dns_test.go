@@ -0,0 +1,132 @@
+//go:build linux && !no_ip_router && !no_dns_service && !no_services
+// +build linux,!no_ip_router,!no_dns_service,!no_services
+
+package services
+
+import (
+	"testing"
+
+	"github.com/ansible/receptor/pkg/netceptor"
+	"github.com/mitchellh/mapstructure"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func testDNSService() *DNSService {
+	ads := []*netceptor.ServiceAdvertisement{
+		{NodeID: "node1", Service: "iprouter", Tags: map[string]string{
+			"type": adTypeIPRouter, "network": "mesh", "route_local": "10.0.1.0/24",
+		}},
+		{NodeID: "node1", Service: "web", Tags: map[string]string{"address": "0.0.0.0:8080"}},
+		{NodeID: "node2", Service: "iprouter", Tags: map[string]string{
+			"type": adTypeIPRouter, "network": "mesh", "route_local": "10.0.2.0/24",
+		}},
+		{NodeID: "node2", Service: "web", Tags: map[string]string{"address": "0.0.0.0:8081"}},
+		{NodeID: "gone", Service: "web", Tags: map[string]string{}},
+	}
+	routes := map[string]string{"node1": "node1", "node2": "node1"}
+
+	return newDNSService("receptor.", "mesh", func() ([]*netceptor.ServiceAdvertisement, map[string]string) {
+		return ads, routes
+	})
+}
+
+func dnsQuery(t *testing.T, d *DNSService, name string, qtype dnsmessage.Type) *dnsmessage.Message {
+	builder := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: 42, RecursionDesired: true})
+	if err := builder.StartQuestions(); err != nil {
+		t.Fatal(err)
+	}
+	err := builder.Question(dnsmessage.Question{
+		Name:  dnsmessage.MustNewName(name),
+		Type:  qtype,
+		Class: dnsmessage.ClassINET,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	query, err := builder.Finish()
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := d.answer(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := &dnsmessage.Message{}
+	if err := msg.Unpack(resp); err != nil {
+		t.Fatal(err)
+	}
+	if msg.ID != 42 || !msg.Response {
+		t.Fatalf("unexpected response header %+v", msg.Header)
+	}
+
+	return msg
+}
+
+func TestDNSServiceA(t *testing.T) {
+	d := testDNSService()
+	for name, want := range map[string][4]byte{
+		"node1.receptor.":     {10, 0, 1, 1},
+		"web.node2.receptor.": {10, 0, 2, 1},
+		"NODE2.Receptor.":     {10, 0, 2, 1},
+	} {
+		msg := dnsQuery(t, d, name, dnsmessage.TypeA)
+		if msg.RCode != dnsmessage.RCodeSuccess || len(msg.Answers) != 1 {
+			t.Fatalf("%s: expected one answer, got %v", name, msg)
+		}
+		a, ok := msg.Answers[0].Body.(*dnsmessage.AResource)
+		if !ok || a.A != want {
+			t.Errorf("%s: expected %v, got %v", name, want, msg.Answers[0].Body)
+		}
+	}
+}
+
+func TestDNSServiceSRV(t *testing.T) {
+	msg := dnsQuery(t, testDNSService(), "_web._tcp.receptor.", dnsmessage.TypeSRV)
+	if msg.RCode != dnsmessage.RCodeSuccess || len(msg.Answers) != 2 {
+		t.Fatalf("expected two answers, got %v", msg)
+	}
+	for i, want := range []struct {
+		target string
+		port   uint16
+	}{{"node1.receptor.", 8080}, {"node2.receptor.", 8081}} {
+		srv, ok := msg.Answers[i].Body.(*dnsmessage.SRVResource)
+		if !ok || srv.Target.String() != want.target || srv.Port != want.port {
+			t.Errorf("expected %s:%d, got %v", want.target, want.port, msg.Answers[i].Body)
+		}
+	}
+}
+
+func TestDNSServiceErrors(t *testing.T) {
+	d := testDNSService()
+	tests := []struct {
+		name  string
+		qtype dnsmessage.Type
+		rcode dnsmessage.RCode
+	}{
+		{"node1.receptor.", dnsmessage.TypeAAAA, dnsmessage.RCodeSuccess},
+		{"node3.receptor.", dnsmessage.TypeA, dnsmessage.RCodeNameError},
+		{"gone.receptor.", dnsmessage.TypeA, dnsmessage.RCodeNameError},
+		{"ssh.node1.receptor.", dnsmessage.TypeA, dnsmessage.RCodeNameError},
+		{"_ssh._tcp.receptor.", dnsmessage.TypeSRV, dnsmessage.RCodeNameError},
+		{"example.com.", dnsmessage.TypeA, dnsmessage.RCodeRefused},
+	}
+	for _, tc := range tests {
+		msg := dnsQuery(t, d, tc.name, tc.qtype)
+		if msg.RCode != tc.rcode || len(msg.Answers) != 0 {
+			t.Errorf("%s: expected %v with no answers, got %v", tc.name, tc.rcode, msg)
+		}
+	}
+}
+
+func TestDNSServicesConfig(t *testing.T) {
+	s := &Services{}
+	err := mapstructure.Decode(map[string]interface{}{
+		"dns": []map[string]interface{}{{"zone": "mesh", "network": "lab"}},
+	}, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.DNS) != 1 || s.DNS[0].Zone != "mesh" || s.DNS[0].Network != "lab" {
+		t.Fatalf("expected the dns service to be read from the services config, got %+v", s.DNS)
+	}
+}
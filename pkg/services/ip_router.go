@@ -369,6 +369,7 @@ type ipRouterCfg struct {
 
 // Run runs the action.
 func (cfg ipRouterCfg) Run() error {
+	utils.RecordEffectiveConfig("ip-router", cfg)
 	logger.Debug("Running tun router service %s\n", cfg)
 	_, err := NewIPRouter(netceptor.MainInstance, cfg.NetworkName, cfg.Interface, cfg.LocalNet, cfg.Routes)
 	if err != nil {
@@ -0,0 +1,152 @@
+//go:build windows && !no_proxies && !no_services
+// +build windows,!no_proxies,!no_services
+
+package services
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/Microsoft/go-winio"
+	"github.com/ansible/receptor/pkg/logger"
+	"github.com/ansible/receptor/pkg/netceptor"
+	"github.com/ansible/receptor/pkg/tls"
+	"github.com/ansible/receptor/pkg/utils"
+	"github.com/ghjm/cmdline"
+)
+
+// DefaultPipeSecurityDescriptor only allows the local system, administrators and the pipe's owner to connect.
+const DefaultPipeSecurityDescriptor = "D:P(A;;GA;;;SY)(A;;GA;;;BA)(A;;GA;;;OW)"
+
+// pipePath returns the full path of a named pipe, adding the \\.\pipe\ prefix if needed.
+func pipePath(name string) string {
+	if strings.HasPrefix(name, `\\`) {
+		return name
+	}
+
+	return `\\.\pipe\` + name
+}
+
+// NamedPipeProxyServiceInbound listens on a named pipe and forwards connections over the Receptor network.
+func NamedPipeProxyServiceInbound(s *netceptor.Netceptor, pipe string, securityDescriptor string,
+	node string, rservice string, tlscfg *tls.Config) error {
+	if securityDescriptor == "" {
+		securityDescriptor = DefaultPipeSecurityDescriptor
+	}
+	pli, err := winio.ListenPipe(pipePath(pipe), &winio.PipeConfig{
+		SecurityDescriptor: securityDescriptor,
+	})
+	if err != nil {
+		return fmt.Errorf("error opening named pipe: %s", err)
+	}
+	breaker := newDialBreaker(fmt.Sprintf("%s:%s", node, rservice), DefaultDialBreakerConfig)
+	go func() {
+		<-s.Context().Done()
+		_ = pli.Close()
+	}()
+	go func() {
+		for {
+			pc, err := pli.Accept()
+			if err != nil {
+				logger.Error("Error accepting named pipe connection: %s\n", err)
+
+				return
+			}
+			go func() {
+				qc, err := breaker.Dial(func() (net.Conn, error) {
+					return s.Dial(node, rservice, tlscfg)
+				})
+				if err != nil {
+					logger.Error("Error connecting on Receptor network: %s. Closing client connection.\n", err)
+					_ = pc.Close()
+
+					return
+				}
+				utils.TrackedBridgeConns("pipe "+pipe, fmt.Sprintf("%s:%s", node, rservice),
+					pc, "named pipe service", qc, "receptor connection")
+			}()
+		}
+	}()
+
+	return nil
+}
+
+// NamedPipeProxyServiceOutbound listens on the Receptor network and forwards the connection via a named pipe.
+func NamedPipeProxyServiceOutbound(s *netceptor.Netceptor, service string, tlscfg *tls.Config, pipe string) error {
+	qli, err := s.ListenAndAdvertise(service, tlscfg, map[string]string{
+		"type": "Named Pipe Proxy",
+		"pipe": pipe,
+	})
+	if err != nil {
+		return fmt.Errorf("error listening on Receptor network: %s", err)
+	}
+	go func() {
+		for {
+			qc, err := qli.Accept()
+			if err != nil {
+				logger.Error("Error accepting connection on Receptor network: %s\n", err)
+
+				return
+			}
+			pc, err := winio.DialPipe(pipePath(pipe), nil)
+			if err != nil {
+				logger.Error("Error connecting via named pipe: %s\n", err)
+				_ = qc.Close()
+
+				continue
+			}
+			go utils.TrackedBridgeConns(qc.RemoteAddr().String(), "pipe "+pipe, qc, "receptor service", pc, "named pipe connection")
+		}
+	}()
+
+	return nil
+}
+
+// namedPipeProxyInboundCfg is the cmdline configuration object for a named pipe inbound proxy.
+type namedPipeProxyInboundCfg struct {
+	Pipe               string `required:"true" description:"Pipe name, with or without the \\\\.\\pipe\\ prefix"`
+	SecurityDescriptor string `description:"SDDL security descriptor controlling who may connect to the pipe (default: SYSTEM, Administrators and owner)"`
+	RemoteNode         string `required:"true" description:"Receptor node to connect to"`
+	RemoteService      string `required:"true" description:"Receptor service name to connect to"`
+	TLS                string `description:"Name of TLS client config for the Receptor connection"`
+}
+
+// Run runs the action.
+func (cfg namedPipeProxyInboundCfg) Run() error {
+	utils.RecordEffectiveConfig("namedpipe-server", cfg)
+	logger.Debug("Running named pipe inbound proxy service %v\n", cfg)
+	tlscfg, err := netceptor.MainInstance.GetClientTLSConfig(cfg.TLS, cfg.RemoteNode, "receptor")
+	if err != nil {
+		return err
+	}
+
+	return NamedPipeProxyServiceInbound(netceptor.MainInstance, cfg.Pipe, cfg.SecurityDescriptor,
+		cfg.RemoteNode, cfg.RemoteService, tlscfg)
+}
+
+// namedPipeProxyOutboundCfg is the cmdline configuration object for a named pipe outbound proxy.
+type namedPipeProxyOutboundCfg struct {
+	Service string `required:"true" description:"Receptor service name to bind to"`
+	Pipe    string `required:"true" description:"Pipe name, which must already exist"`
+	TLS     string `description:"Name of TLS server config for the Receptor connection"`
+}
+
+// Run runs the action.
+func (cfg namedPipeProxyOutboundCfg) Run() error {
+	utils.RecordEffectiveConfig("namedpipe-client", cfg)
+	logger.Debug("Running named pipe outbound proxy service %v\n", cfg)
+	tlscfg, err := netceptor.MainInstance.GetServerTLSConfig(cfg.TLS)
+	if err != nil {
+		return err
+	}
+
+	return NamedPipeProxyServiceOutbound(netceptor.MainInstance, cfg.Service, tlscfg, cfg.Pipe)
+}
+
+func init() {
+	cmdline.RegisterConfigTypeForApp("receptor-proxies",
+		"namedpipe-server", "Listen on a named pipe and forward via Receptor", namedPipeProxyInboundCfg{}, cmdline.Section(servicesSection))
+	cmdline.RegisterConfigTypeForApp("receptor-proxies",
+		"namedpipe-client", "Listen via Receptor and forward to a named pipe", namedPipeProxyOutboundCfg{}, cmdline.Section(servicesSection))
+}
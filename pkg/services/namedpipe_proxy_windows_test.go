@@ -0,0 +1,84 @@
+//go:build windows && !no_proxies && !no_services
+// +build windows,!no_proxies,!no_services
+
+package services
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Microsoft/go-winio"
+	"github.com/ansible/receptor/pkg/netceptor"
+)
+
+// startEchoPipe starts a named pipe server that echoes back whatever is written to it.
+func startEchoPipe(t *testing.T, name string) {
+	li, err := winio.ListenPipe(pipePath(name), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = li.Close() })
+	go func() {
+		for {
+			conn, err := li.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_, _ = io.Copy(conn, conn)
+			}()
+		}
+	}()
+}
+
+func TestNamedPipeProxy(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	n := netceptor.New(ctx, "node1", nil)
+	defer n.Shutdown()
+
+	prefix := fmt.Sprintf("receptor-test-%d", os.Getpid())
+	startEchoPipe(t, prefix+"-echo")
+	err := NamedPipeProxyServiceOutbound(n, "echo", nil, prefix+"-echo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = NamedPipeProxyServiceInbound(n, prefix+"-in", "", "node1", "echo", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	timeout := 10 * time.Second
+	conn, err := winio.DialPipe(pipePath(prefix+"-in"), &timeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(timeout))
+	_, err = conn.Write([]byte{42})
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 1)
+	_, err = io.ReadFull(conn, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if buf[0] != 42 {
+		t.Fatalf("expected to read back 42, got %d", buf[0])
+	}
+}
+
+func TestPipePath(t *testing.T) {
+	if p := pipePath("receptor"); p != `\\.\pipe\receptor` {
+		t.Fatalf("unexpected pipe path %s", p)
+	}
+	if p := pipePath(`\\.\pipe\receptor`); p != `\\.\pipe\receptor` {
+		t.Fatalf("unexpected pipe path %s", p)
+	}
+}
@@ -0,0 +1,62 @@
+//go:build !no_proxies && !no_services
+// +build !no_proxies,!no_services
+
+package services
+
+import (
+	"context"
+	"time"
+
+	"github.com/ansible/receptor/pkg/logger"
+	"github.com/ansible/receptor/pkg/netceptor"
+)
+
+// targetReachable reports whether a target's node can be routed to and advertises the target's service.
+func targetReachable(s *netceptor.Netceptor, t ProxyTarget) bool {
+	if t.Node != s.NodeID() {
+		if _, err := s.PathCost(t.Node); err != nil {
+			return false
+		}
+	}
+	_, ok := s.GetServiceInfo(t.Node, t.Service)
+
+	return ok
+}
+
+// anyTargetReachable reports whether at least one of targets is reachable.
+func anyTargetReachable(s *netceptor.Netceptor, targets []ProxyTarget) bool {
+	for _, t := range targets {
+		if targetReachable(s, t) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// probeTargets calls reachable straight away and then every interval until ctx is done, and calls
+// setReachable each time the result changes.  The targets are assumed to be reachable to begin with.  If
+// setReachable fails, it is called again after the next probe.
+func probeTargets(ctx context.Context, name string, interval time.Duration, reachable func() bool,
+	setReachable func(bool) error) {
+	last := true
+	for {
+		if now := reachable(); now != last {
+			if err := setReachable(now); err != nil {
+				logger.Error("Error responding to a change in reachability of the targets of %s: %s\n", name, err)
+			} else {
+				last = now
+				if now {
+					logger.Info("Targets of %s are reachable again, accepting new connections\n", name)
+				} else {
+					logger.Warning("No target of %s is reachable, refusing new connections\n", name)
+				}
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
@@ -0,0 +1,231 @@
+//go:build !no_proxies && !no_services
+// +build !no_proxies,!no_services
+
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/ansible/receptor/pkg/netceptor"
+)
+
+func TestProbeTargets(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var lock sync.Mutex
+	results := []bool{true, false, false, false, true, true}
+	calls := make([]bool, 0)
+	failNext := true
+	done := make(chan struct{})
+	go func() {
+		probeTargets(ctx, "test", time.Millisecond, func() bool {
+			lock.Lock()
+			defer lock.Unlock()
+			if len(results) == 0 {
+				cancel()
+
+				return true
+			}
+			r := results[0]
+			results = results[1:]
+
+			return r
+		}, func(reachable bool) error {
+			lock.Lock()
+			defer lock.Unlock()
+			calls = append(calls, reachable)
+			if !reachable && failNext {
+				// The first attempt to refuse connections fails, so it is tried again
+				failNext = false
+
+				return fmt.Errorf("could not close socket")
+			}
+
+			return nil
+		})
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("probe did not stop when its context was done")
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	if fmt.Sprint(calls) != "[false false true]" {
+		t.Fatalf("expected to be told of each change, with a retry after a failure, got %v", calls)
+	}
+}
+
+func TestTargetReachable(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	nc := netceptor.New(ctx, "node1", nil)
+	defer nc.Shutdown()
+	pc, err := nc.ListenPacketAndAdvertise("svc", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	local := ProxyTarget{Node: "node1", Service: "svc"}
+	if !targetReachable(nc, local) {
+		t.Fatal("expected a service advertised by this node to be reachable")
+	}
+	for _, target := range []ProxyTarget{{Node: "node1", Service: "other"}, {Node: "node2", Service: "svc"}} {
+		if targetReachable(nc, target) {
+			t.Fatalf("expected %s to be unreachable", target)
+		}
+	}
+	if !anyTargetReachable(nc, []ProxyTarget{{Node: "node2", Service: "svc"}, local}) {
+		t.Fatal("expected the targets to be reachable while one of them is")
+	}
+}
+
+func TestUnixProxyProbe(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Unix sockets are not supported on Windows")
+	}
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "receptor-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	filename := filepath.Join(tmpdir, "proxy.sock")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	reachable := int32(1)
+	err = unixProxyServiceInbound(ctx, filename, 0o600, 10*time.Millisecond, func() bool {
+		return atomic.LoadInt32(&reachable) == 1
+	}, func(uc net.Conn) {
+		_, _ = uc.Write([]byte("ok"))
+		_ = uc.Close()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// connect dials the proxy, and returns nil if the connection was accepted and handled
+	connect := func() error {
+		uc, err := net.Dial("unix", filename)
+		if err != nil {
+			return err
+		}
+		defer uc.Close()
+		_ = uc.SetReadDeadline(time.Now().Add(5 * time.Second))
+		data, err := ioutil.ReadAll(uc)
+		if err != nil {
+			return err
+		}
+		if string(data) != "ok" {
+			return fmt.Errorf("expected ok, got %q", data)
+		}
+
+		return nil
+	}
+	// waitFor retries connect until check accepts its result
+	waitFor := func(what string, check func(error) bool) {
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			err := connect()
+			if check(err) {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("timed out waiting for %s, last result %v", what, err)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	refused := func(err error) bool {
+		return errors.Is(err, syscall.ECONNREFUSED)
+	}
+
+	if err := connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	// While the target is unreachable, connections are refused straight away
+	atomic.StoreInt32(&reachable, 0)
+	waitFor("connections to be refused", refused)
+	if _, err := os.Stat(filename); err != nil {
+		t.Fatalf("expected the socket file to be kept while connections are refused: %s", err)
+	}
+	start := time.Now()
+	if err := connect(); !refused(err) {
+		t.Fatalf("expected the connection to be refused, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("refusing the connection took %s", elapsed)
+	}
+
+	// Connections are accepted again once the target recovers
+	atomic.StoreInt32(&reachable, 1)
+	waitFor("connections to be accepted", func(err error) bool { return err == nil })
+	if err := connect(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestProbedUnixListenerClose(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Unix sockets are not supported on Windows")
+	}
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "receptor-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	filename := filepath.Join(tmpdir, "proxy.sock")
+	li, err := net.Listen("unix", filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pli := newProbedUnixListener(li, filename, 0o600)
+	if err := pli.setOpen(false); err != nil {
+		t.Fatal(err)
+	}
+
+	// Closing the listener wakes an Accept that is waiting for the probe to reopen it
+	acceptErr := make(chan error, 1)
+	go func() {
+		_, err := pli.Accept()
+		acceptErr <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	if err := pli.Close(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-acceptErr:
+		if err == nil {
+			t.Fatal("expected Accept to fail once the listener is closed")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Accept did not return when the listener was closed")
+	}
+	if _, err := os.Stat(filename); !os.IsNotExist(err) {
+		t.Fatalf("expected the socket file to be removed, got %v", err)
+	}
+
+	// The probe does not reopen a closed listener
+	if err := pli.setOpen(true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filename); !os.IsNotExist(err) {
+		t.Fatalf("expected the closed listener to stay closed, got %v", err)
+	}
+	if _, err := pli.Accept(); err == nil {
+		t.Fatal("expected Accept to fail on a closed listener")
+	}
+}
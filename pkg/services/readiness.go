@@ -0,0 +1,26 @@
+//go:build !no_proxies && !no_services
+// +build !no_proxies,!no_services
+
+package services
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ansible/receptor/pkg/netceptor"
+)
+
+// awaitBackend holds the advertisement of a service until a backend connection is up, if await is set.
+// The timeout is given as a duration string, and zero waits indefinitely.
+func awaitBackend(s *netceptor.Netceptor, service string, await bool, timeout string) error {
+	if !await {
+		return nil
+	}
+	d, err := time.ParseDuration(timeout)
+	if err != nil {
+		return fmt.Errorf("invalid await timeout %s: %s", timeout, err)
+	}
+	s.SetReadinessGate(service, d)
+
+	return nil
+}
@@ -17,6 +17,8 @@ type Services struct {
 	IPRouter []IPRouter `mapstructure:"ip-router"`
 	// Proxy sockets.
 	Proxies *Proxies `mapstructure:"proxies"`
+	// Answer DNS queries for mesh names.
+	DNSServices `mapstructure:",squash"`
 }
 
 // Services defines a set of receptor services that proxy sockets.
@@ -95,5 +97,9 @@ func (s *Services) Setup(nc *netceptor.Netceptor) error {
 		}
 	}
 
+	if err := s.DNSServices.setup(nc); err != nil {
+		return err
+	}
+
 	return nil
 }
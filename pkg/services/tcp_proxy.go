@@ -4,9 +4,11 @@
 package services
 
 import (
+	"context"
 	"fmt"
 	"net"
 	"strconv"
+	"time"
 
 	"github.com/ansible/receptor/pkg/logger"
 	"github.com/ansible/receptor/pkg/netceptor"
@@ -15,10 +17,26 @@ import (
 	"github.com/ghjm/cmdline"
 )
 
+// TCPProxyInboundOptions are the optional settings of an inbound TCP proxy.
+type TCPProxyInboundOptions struct {
+	// DSCP is the value the packets of accepted connections are marked with, or 0 to leave them unmarked.
+	DSCP int
+}
+
 // TCPProxyServiceInbound listens on a TCP port and forwards the connection over the Receptor network.
 func TCPProxyServiceInbound(s *netceptor.Netceptor, host string, port int, tlsServer *tls.Config,
 	node string, rservice string, tlsClient *tls.Config) error {
-	tli, err := net.Listen("tcp", net.JoinHostPort(host, strconv.Itoa(port)))
+	return TCPProxyServiceInboundWithOptions(s, host, port, tlsServer, node, rservice, tlsClient, TCPProxyInboundOptions{})
+}
+
+// TCPProxyServiceInboundWithOptions is TCPProxyServiceInbound with the settings in opts.
+func TCPProxyServiceInboundWithOptions(s *netceptor.Netceptor, host string, port int, tlsServer *tls.Config,
+	node string, rservice string, tlsClient *tls.Config, opts TCPProxyInboundOptions) error {
+	if err := utils.ValidateDSCP(opts.DSCP); err != nil {
+		return err
+	}
+	lc := &net.ListenConfig{Control: utils.DSCPControl(opts.DSCP)}
+	tli, err := lc.Listen(context.Background(), "tcp", net.JoinHostPort(host, strconv.Itoa(port)))
 	if tlsServer != nil {
 		tli = tls.NewListener(tli, tlsServer)
 	}
@@ -39,16 +57,37 @@ func TCPProxyServiceInbound(s *netceptor.Netceptor, host string, port int, tlsSe
 
 				continue
 			}
-			go utils.BridgeConns(tc, "tcp service", qc, "receptor connection")
+			go utils.TrackedBridgeConns("tcp "+tc.RemoteAddr().String(), fmt.Sprintf("%s:%s", node, rservice),
+				tc, "tcp service", qc, "receptor connection")
 		}
 	}()
 
 	return nil
 }
 
+// TCPProxyOutboundOptions are the optional settings of an outbound TCP proxy.
+type TCPProxyOutboundOptions struct {
+	// ConnLimit is the most concurrent connections to the service, or 0 for no limit.
+	ConnLimit int
+	// ConnQueue makes connections beyond ConnLimit wait for a free slot instead of being rejected.
+	ConnQueue bool
+	// DSCP is the value the packets of outbound TCP connections are marked with, or 0 to leave them unmarked.
+	DSCP int
+}
+
 // TCPProxyServiceOutbound listens on the Receptor network and forwards the connection via TCP.
 func TCPProxyServiceOutbound(s *netceptor.Netceptor, service string, tlsServer *tls.Config,
 	address string, tlsClient *tls.Config) error {
+	return TCPProxyServiceOutboundWithOptions(s, service, tlsServer, address, tlsClient, TCPProxyOutboundOptions{})
+}
+
+// TCPProxyServiceOutboundWithOptions is TCPProxyServiceOutbound with the settings in opts.
+func TCPProxyServiceOutboundWithOptions(s *netceptor.Netceptor, service string, tlsServer *tls.Config,
+	address string, tlsClient *tls.Config, opts TCPProxyOutboundOptions) error {
+	if err := utils.ValidateDSCP(opts.DSCP); err != nil {
+		return err
+	}
+	dialer := &net.Dialer{Control: utils.DSCPControl(opts.DSCP)}
 	qli, err := s.ListenAndAdvertise(service, tlsServer, map[string]string{
 		"type":    "TCP Proxy",
 		"address": address,
@@ -56,6 +95,7 @@ func TCPProxyServiceOutbound(s *netceptor.Netceptor, service string, tlsServer *
 	if err != nil {
 		return fmt.Errorf("error listening on Receptor network: %s", err)
 	}
+	qli.SetConnectionLimit(opts.ConnLimit, opts.ConnQueue)
 	go func() {
 		for {
 			qc, err := qli.Accept()
@@ -66,16 +106,16 @@ func TCPProxyServiceOutbound(s *netceptor.Netceptor, service string, tlsServer *
 			}
 			var tc net.Conn
 			if tlsClient == nil {
-				tc, err = net.Dial("tcp", address)
+				tc, err = dialer.Dial("tcp", address)
 			} else {
-				tc, err = tls.Dial("tcp", address, tlsClient)
+				tc, err = tls.DialWithDialer(dialer, "tcp", address, tlsClient)
 			}
 			if err != nil {
 				logger.Error("Error connecting via TCP: %s\n", err)
 
 				continue
 			}
-			go utils.BridgeConns(qc, "receptor service", tc, "tcp connection")
+			go utils.TrackedBridgeConns(qc.RemoteAddr().String(), "tcp "+address, qc, "receptor service", tc, "tcp connection")
 		}
 	}()
 
@@ -90,10 +130,12 @@ type tcpProxyInboundCfg struct {
 	RemoteService string `required:"true" description:"Receptor service name to connect to"`
 	TLSServer     string `description:"Name of TLS server config for the TCP listener"`
 	TLSClient     string `description:"Name of TLS client config for the Receptor connection"`
+	DSCP          int    `description:"DSCP value (0-63) to mark the packets of accepted connections with for network QoS (0 to leave unmarked)" default:"0"`
 }
 
 // Run runs the action.
 func (cfg tcpProxyInboundCfg) Run() error {
+	utils.RecordEffectiveConfig("tcp-server", cfg)
 	logger.Debug("Running TCP inbound proxy service %v\n", cfg)
 	tlsClientCfg, err := netceptor.MainInstance.GetClientTLSConfig(cfg.TLSClient, cfg.RemoteNode, "receptor")
 	if err != nil {
@@ -104,21 +146,30 @@ func (cfg tcpProxyInboundCfg) Run() error {
 		return err
 	}
 
-	return TCPProxyServiceInbound(netceptor.MainInstance, cfg.BindAddr, cfg.Port, tlsServerCfg,
-		cfg.RemoteNode, cfg.RemoteService, tlsClientCfg)
+	return TCPProxyServiceInboundWithOptions(netceptor.MainInstance, cfg.BindAddr, cfg.Port, tlsServerCfg,
+		cfg.RemoteNode, cfg.RemoteService, tlsClientCfg, TCPProxyInboundOptions{DSCP: cfg.DSCP})
 }
 
 // tcpProxyOutboundCfg is the cmdline configuration object for a TCP outbound proxy.
 type tcpProxyOutboundCfg struct {
-	Service   string `required:"true" description:"Receptor service name to bind to"`
-	Address   string `required:"true" description:"Address for outbound TCP connection"`
-	TLSServer string `description:"Name of TLS server config for the Receptor service"`
-	TLSClient string `description:"Name of TLS client config for the TCP connection"`
+	Service      string `required:"true" description:"Receptor service name to bind to"`
+	Address      string `required:"true" description:"Address for outbound TCP connection"`
+	TLSServer    string `description:"Name of TLS server config for the Receptor service"`
+	TLSClient    string `description:"Name of TLS client config for the TCP connection"`
+	ConnLimit    int    `description:"Maximum concurrent connections to the Receptor service (0 for no limit)" default:"0"`
+	ConnQueue    bool   `description:"Queue connections beyond the limit instead of rejecting them" default:"false"`
+	AwaitBackend bool   `description:"Do not advertise the service until a backend connection is up" default:"false"`
+	AwaitTimeout string `description:"Advertise the service anyway after this long without a backend (0 to wait indefinitely)" default:"0"`
+	DSCP         int    `description:"DSCP value (0-63) to mark the packets of outbound TCP connections with for network QoS (0 to leave unmarked)" default:"0"`
 }
 
 // Run runs the action.
 func (cfg tcpProxyOutboundCfg) Run() error {
-	logger.Debug("Running TCP inbound proxy service %s\n", cfg)
+	utils.RecordEffectiveConfig("tcp-client", cfg)
+	logger.Debug("Running TCP inbound proxy service %v\n", cfg)
+	if err := awaitBackend(netceptor.MainInstance, cfg.Service, cfg.AwaitBackend, cfg.AwaitTimeout); err != nil {
+		return err
+	}
 	tlsServerCfg, err := netceptor.MainInstance.GetServerTLSConfig(cfg.TLSServer)
 	if err != nil {
 		return err
@@ -132,7 +183,8 @@ func (cfg tcpProxyOutboundCfg) Run() error {
 		return err
 	}
 
-	return TCPProxyServiceOutbound(netceptor.MainInstance, cfg.Service, tlsServerCfg, cfg.Address, tlsClientCfg)
+	return TCPProxyServiceOutboundWithOptions(netceptor.MainInstance, cfg.Service, tlsServerCfg, cfg.Address, tlsClientCfg,
+		TCPProxyOutboundOptions{ConnLimit: cfg.ConnLimit, ConnQueue: cfg.ConnQueue, DSCP: cfg.DSCP})
 }
 
 func init() {
@@ -156,6 +208,12 @@ type TCPInProxy struct {
 	// TLS config to use for the transport within receptor.
 	// Leave empty for no TLS.
 	ReceptorTLS *tls.ServerConf `mapstructure:"receptor-tls"`
+	// Maximum concurrent connections to the Receptor service. Defaults to 0, no limit.
+	ConnLimit int `mapstructure:"conn-limit"`
+	// Queue connections beyond the limit instead of rejecting them.
+	ConnQueue bool `mapstructure:"conn-queue"`
+	// DSCP value (0-63) to mark the packets of accepted connections with for network QoS. Defaults to 0, unmarked.
+	DSCP int `mapstructure:"dscp"`
 }
 
 func (t TCPInProxy) setup(nc *netceptor.Netceptor) error {
@@ -182,7 +240,7 @@ func (t TCPInProxy) setup(nc *netceptor.Netceptor) error {
 		return fmt.Errorf("address %s for tls inbound proxy contains invalid port: %w", t.Address, err)
 	}
 
-	return TCPProxyServiceInbound(
+	return TCPProxyServiceInboundWithOptions(
 		nc,
 		host,
 		i,
@@ -190,6 +248,7 @@ func (t TCPInProxy) setup(nc *netceptor.Netceptor) error {
 		t.RemoteNode,
 		t.RemoteService,
 		tClient,
+		TCPProxyInboundOptions{DSCP: t.DSCP},
 	)
 }
 
@@ -205,6 +264,16 @@ type TCPOutProxy struct {
 	// TLS config to use for the transport within receptor.
 	// Leave empty for no TLS.
 	ReceptorTLS *tls.ServerConf `mapstructure:"receptor-tls"`
+	// Maximum concurrent connections to the Receptor service. Defaults to 0, no limit.
+	ConnLimit int `mapstructure:"conn-limit"`
+	// Queue connections beyond the limit instead of rejecting them.
+	ConnQueue bool `mapstructure:"conn-queue"`
+	// Do not advertise the service until a backend connection is up.
+	AwaitBackend bool `mapstructure:"await-backend"`
+	// Advertise the service anyway after this long without a backend. Defaults to 0, wait indefinitely.
+	AwaitTimeout time.Duration `mapstructure:"await-timeout"`
+	// DSCP value (0-63) to mark the packets of outbound TCP connections with for network QoS. Defaults to 0, unmarked.
+	DSCP int `mapstructure:"dscp"`
 }
 
 func (t TCPOutProxy) setup(nc *netceptor.Netceptor) error {
@@ -223,5 +292,10 @@ func (t TCPOutProxy) setup(nc *netceptor.Netceptor) error {
 		}
 	}
 
-	return TCPProxyServiceOutbound(nc, t.Service, tServer, t.Address, tClient)
+	if t.AwaitBackend {
+		nc.SetReadinessGate(t.Service, t.AwaitTimeout)
+	}
+
+	return TCPProxyServiceOutboundWithOptions(nc, t.Service, tServer, t.Address, tClient,
+		TCPProxyOutboundOptions{ConnLimit: t.ConnLimit, ConnQueue: t.ConnQueue, DSCP: t.DSCP})
 }
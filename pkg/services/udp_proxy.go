@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"net"
 	"strconv"
+	"time"
 
 	"github.com/ansible/receptor/pkg/logger"
 	"github.com/ansible/receptor/pkg/netceptor"
@@ -184,6 +185,7 @@ type udpProxyInboundCfg struct {
 
 // Run runs the action.
 func (cfg udpProxyInboundCfg) Run() error {
+	utils.RecordEffectiveConfig("udp-server", cfg)
 	logger.Debug("Running UDP inbound proxy service %v\n", cfg)
 
 	return UDPProxyServiceInbound(netceptor.MainInstance, cfg.BindAddr, cfg.Port, cfg.RemoteNode, cfg.RemoteService)
@@ -191,13 +193,19 @@ func (cfg udpProxyInboundCfg) Run() error {
 
 // udpProxyOutboundCfg is the cmdline configuration object for a UDP outbound proxy.
 type udpProxyOutboundCfg struct {
-	Service string `required:"true" description:"Receptor service name to bind to"`
-	Address string `required:"true" description:"Address for outbound UDP connection"`
+	Service      string `required:"true" description:"Receptor service name to bind to"`
+	Address      string `required:"true" description:"Address for outbound UDP connection"`
+	AwaitBackend bool   `description:"Do not advertise the service until a backend connection is up" default:"false"`
+	AwaitTimeout string `description:"Advertise the service anyway after this long without a backend (0 to wait indefinitely)" default:"0"`
 }
 
 // Run runs the action.
 func (cfg udpProxyOutboundCfg) Run() error {
-	logger.Debug("Running UDP outbound proxy service %s\n", cfg)
+	utils.RecordEffectiveConfig("udp-client", cfg)
+	logger.Debug("Running UDP outbound proxy service %v\n", cfg)
+	if err := awaitBackend(netceptor.MainInstance, cfg.Service, cfg.AwaitBackend, cfg.AwaitTimeout); err != nil {
+		return err
+	}
 
 	return UDPProxyServiceOutbound(netceptor.MainInstance, cfg.Service, cfg.Address)
 }
@@ -237,8 +245,16 @@ type UDPOutProxy struct {
 	Service string `mapstructure:"service"`
 	// Address for outbound UDP connection.
 	Address string `mapstructure:"address"`
+	// Do not advertise the service until a backend connection is up.
+	AwaitBackend bool `mapstructure:"await-backend"`
+	// Advertise the service anyway after this long without a backend. Defaults to 0, wait indefinitely.
+	AwaitTimeout time.Duration `mapstructure:"await-timeout"`
 }
 
 func (p *UDPOutProxy) setup(nc *netceptor.Netceptor) error {
+	if p.AwaitBackend {
+		nc.SetReadinessGate(p.Service, p.AwaitTimeout)
+	}
+
 	return UDPProxyServiceOutbound(nc, p.Service, p.Address)
 }
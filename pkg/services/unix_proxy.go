@@ -4,10 +4,14 @@
 package services
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net"
 	"os"
 	"runtime"
+	"sync"
+	"time"
 
 	"github.com/ansible/receptor/pkg/logger"
 	"github.com/ansible/receptor/pkg/netceptor"
@@ -16,13 +20,78 @@ import (
 	"github.com/ghjm/cmdline"
 )
 
+// UnixProxyInboundOptions are the optional settings of an inbound Unix socket proxy.
+type UnixProxyInboundOptions struct {
+	// Policy is the order in which each connection tries the targets, or round-robin if empty.
+	Policy string
+	// Breaker configures dial retries and circuit breaking, or DefaultDialBreakerConfig is used if nil.
+	Breaker *DialBreakerConfig
+	// ProbeInterval is how often to check whether any target's node is reachable and advertises the
+	// target's service.  While none is, the socket is closed so that clients are refused straight away
+	// instead of being accepted and then dropped, and it is opened again when a target recovers.  Zero
+	// disables the probe.
+	ProbeInterval time.Duration
+}
+
 // UnixProxyServiceInbound listens on a Unix socket and forwards connections over the Receptor network.
 func UnixProxyServiceInbound(s *netceptor.Netceptor, filename string, permissions os.FileMode,
 	node string, rservice string, tlscfg *tls.Config) error {
+	return UnixProxyServiceInboundWithOptions(s, filename, permissions,
+		[]ProxyTarget{{Node: node, Service: rservice, TLS: tlscfg}}, UnixProxyInboundOptions{})
+}
+
+// UnixProxyServiceInboundWithOptions is UnixProxyServiceInbound with several targets and the settings in opts.
+// Each connection goes to the first reachable target in the order chosen by the policy.
+func UnixProxyServiceInboundWithOptions(s *netceptor.Netceptor, filename string, permissions os.FileMode,
+	targets []ProxyTarget, opts UnixProxyInboundOptions) error {
+	policy := opts.Policy
+	if policy == "" {
+		policy = BalanceRoundRobin
+	}
+	bcfg := DefaultDialBreakerConfig
+	if opts.Breaker != nil {
+		bcfg = *opts.Breaker
+	}
+	balancer, err := newTargetBalancer(targets, policy, bcfg)
+	if err != nil {
+		return err
+	}
+	var reachable func() bool
+	if opts.ProbeInterval > 0 {
+		reachable = func() bool {
+			return anyTargetReachable(s, targets)
+		}
+	}
+
+	return unixProxyServiceInbound(s.Context(), filename, permissions, opts.ProbeInterval, reachable, func(uc net.Conn) {
+		qc, target, done, err := balancer.Dial(func(t ProxyTarget) (net.Conn, error) {
+			return s.Dial(t.Node, t.Service, t.TLS)
+		})
+		if err != nil {
+			logger.Error("Error connecting on Receptor network: %s. Closing client connection.\n", err)
+			_ = uc.Close()
+
+			return
+		}
+		defer done()
+		utils.TrackedBridgeConns("unix "+filename, target.String(),
+			uc, "unix socket service", qc, "receptor connection")
+	})
+}
+
+// unixProxyServiceInbound listens on a Unix socket and calls handle with each connection, in its own goroutine.
+// If probeInterval is not zero, the socket is only open while reachable returns true.
+func unixProxyServiceInbound(ctx context.Context, filename string, permissions os.FileMode,
+	probeInterval time.Duration, reachable func() bool, handle func(net.Conn)) error {
 	uli, lock, err := utils.UnixSocketListen(filename, permissions)
 	if err != nil {
 		return fmt.Errorf("error opening Unix socket: %s", err)
 	}
+	if probeInterval > 0 {
+		pli := newProbedUnixListener(uli, filename, permissions)
+		go probeTargets(ctx, "unix "+filename, probeInterval, reachable, pli.setOpen)
+		uli = pli
+	}
 	go func() {
 		defer lock.Unlock()
 		for {
@@ -32,88 +101,318 @@ func UnixProxyServiceInbound(s *netceptor.Netceptor, filename string, permission
 
 				return
 			}
-			go func() {
-				qc, err := s.Dial(node, rservice, tlscfg)
-				if err != nil {
-					logger.Error("Error connecting on Receptor network: %s", err)
-
-					return
-				}
-				utils.BridgeConns(uc, "unix socket service", qc, "receptor connection")
-			}()
+			go handle(uc)
 		}
 	}()
 
 	return nil
 }
 
+// probedUnixListener is a Unix socket listener that can be closed while the targets of its proxy are
+// unreachable, so that clients are refused, and opened again.  The socket file is kept while it is closed,
+// so clients get ECONNREFUSED rather than finding no socket.
+type probedUnixListener struct {
+	filename    string
+	permissions os.FileMode
+	lock        sync.Mutex
+	li          net.Listener
+	reopened    chan struct{}
+	closed      bool
+	done        chan struct{}
+}
+
+// errProbedListenerClosed is returned by Accept once the listener has been closed for good.
+var errProbedListenerClosed = errors.New("use of closed listener")
+
+// newProbedUnixListener creates a probedUnixListener that is open on li.
+func newProbedUnixListener(li net.Listener, filename string, permissions os.FileMode) *probedUnixListener {
+	return &probedUnixListener{
+		filename:    filename,
+		permissions: permissions,
+		li:          li,
+		done:        make(chan struct{}),
+	}
+}
+
+// Accept waits for and returns the next connection, waiting for the socket to be opened again if it is closed
+// by the probe.  Once Close is called, Accept returns an error, even while waiting.
+func (p *probedUnixListener) Accept() (net.Conn, error) {
+	for {
+		p.lock.Lock()
+		if p.closed {
+			p.lock.Unlock()
+
+			return nil, errProbedListenerClosed
+		}
+		li, reopened := p.li, p.reopened
+		p.lock.Unlock()
+		if li == nil {
+			select {
+			case <-reopened:
+			case <-p.done:
+			}
+
+			continue
+		}
+		conn, err := li.Accept()
+		if err == nil {
+			return conn, nil
+		}
+		p.lock.Lock()
+		closedByProbe := !p.closed && p.li != li
+		p.lock.Unlock()
+		if !closedByProbe {
+			return nil, err
+		}
+	}
+}
+
+// Close closes the socket for good.  The probe does not open it again afterwards.
+func (p *probedUnixListener) Close() error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.closed {
+		return nil
+	}
+	p.closed = true
+	close(p.done)
+	if p.li == nil {
+		// The probe closed the socket but left its file in place
+		if err := os.Remove(p.filename); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+
+		return nil
+	}
+	err := p.li.Close()
+	p.li = nil
+
+	return err
+}
+
+// Addr returns the address of the socket.
+func (p *probedUnixListener) Addr() net.Addr {
+	return &net.UnixAddr{Name: p.filename, Net: "unix"}
+}
+
+// setOpen opens or closes the socket.
+func (p *probedUnixListener) setOpen(open bool) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.closed || open == (p.li != nil) {
+		return nil
+	}
+	if !open {
+		if ul, ok := p.li.(*net.UnixListener); ok {
+			ul.SetUnlinkOnClose(false)
+		}
+		err := p.li.Close()
+		p.li = nil
+		p.reopened = make(chan struct{})
+
+		return err
+	}
+	if err := os.Remove(p.filename); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("could not overwrite socket file: %s", err)
+	}
+	li, err := net.Listen("unix", p.filename)
+	if err != nil {
+		return fmt.Errorf("could not listen on socket file: %s", err)
+	}
+	if err := os.Chmod(p.filename, p.permissions); err != nil {
+		_ = li.Close()
+
+		return fmt.Errorf("error setting socket file permissions: %s", err)
+	}
+	p.li = li
+	close(p.reopened)
+
+	return nil
+}
+
+// UnixProxyOutboundOptions are the optional settings of an outbound Unix socket proxy.
+type UnixProxyOutboundOptions struct {
+	// ConnLimit is the most concurrent connections to the service, or 0 for no limit.
+	ConnLimit int
+	// ConnQueue makes connections beyond ConnLimit wait for a free slot instead of being rejected.
+	ConnQueue bool
+}
+
 // UnixProxyServiceOutbound listens on the Receptor network and forwards the connection via a Unix socket.
+// If the service cannot be listened on at first, it keeps retrying in the background.
 func UnixProxyServiceOutbound(s *netceptor.Netceptor, service string, tlscfg *tls.Config, filename string) error {
-	qli, err := s.ListenAndAdvertise(service, tlscfg, map[string]string{
+	return UnixProxyServiceOutboundWithOptions(s, service, tlscfg, filename, UnixProxyOutboundOptions{})
+}
+
+// UnixProxyServiceOutboundWithOptions is UnixProxyServiceOutbound with the settings in opts.
+func UnixProxyServiceOutboundWithOptions(s *netceptor.Netceptor, service string, tlscfg *tls.Config, filename string,
+	opts UnixProxyOutboundOptions) error {
+	tags := map[string]string{
 		"type":     "Unix Proxy",
 		"filename": filename,
+	}
+
+	return listenAndAdvertiseWithRetry(s, service, tlscfg, tags, func(qli *netceptor.Listener) {
+		qli.SetConnectionLimit(opts.ConnLimit, opts.ConnQueue)
+		go func() {
+			for {
+				qc, err := qli.Accept()
+				if err != nil {
+					logger.Error("Error accepting connection on Receptor network: %s\n", err)
+
+					return
+				}
+				uc, err := net.Dial("unix", filename)
+				if err != nil {
+					logger.Error("Error connecting via Unix socket: %s\n", err)
+
+					continue
+				}
+				go utils.TrackedBridgeConns(qc.RemoteAddr().String(), "unix "+filename, qc, "receptor service", uc, "unix socket connection")
+			}
+		}()
 	})
+}
+
+// unixProxyInboundCfg is the cmdline configuration object for a Unix socket inbound proxy.
+type unixProxyInboundCfg struct {
+	Filename         string   `required:"true" description:"Socket filename, which will be overwritten"`
+	Permissions      int      `description:"Socket file permissions" default:"0600"`
+	RemoteNode       string   `description:"Receptor node to connect to"`
+	RemoteService    string   `description:"Receptor service name to connect to"`
+	Targets          []string `description:"Additional node:service targets to spread connections across"`
+	Policy           string   `description:"How to choose a target: round-robin, random or least-connections" default:"round-robin"`
+	TLS              string   `description:"Name of TLS client config for the Receptor connection"`
+	DialRetries      int      `description:"Number of times to retry a failed Receptor connection" default:"2"`
+	DialRetryDelay   string   `description:"Delay before the first retry of a failed connection" default:"500ms"`
+	BreakerThreshold int      `description:"Consecutive failed connections before failing fast (0 to disable)" default:"5"`
+	BreakerCooldown  string   `description:"How long to fail fast before trying again" default:"30s"`
+	ProbeInterval    string   `description:"How often to check that a target is reachable, refusing connections while none is (0 to disable)" default:"0"`
+}
+
+// breakerConfig builds the dial breaker configuration.
+func (cfg unixProxyInboundCfg) breakerConfig() (DialBreakerConfig, error) {
+	bcfg := DialBreakerConfig{
+		Retries:          cfg.DialRetries,
+		FailureThreshold: cfg.BreakerThreshold,
+	}
+	var err error
+	bcfg.RetryDelay, err = time.ParseDuration(cfg.DialRetryDelay)
 	if err != nil {
-		return fmt.Errorf("error listening on Receptor network: %s", err)
+		return bcfg, fmt.Errorf("invalid dial retry delay %s: %s", cfg.DialRetryDelay, err)
+	}
+	bcfg.Cooldown, err = time.ParseDuration(cfg.BreakerCooldown)
+	if err != nil {
+		return bcfg, fmt.Errorf("invalid breaker cooldown %s: %s", cfg.BreakerCooldown, err)
 	}
-	go func() {
-		for {
-			qc, err := qli.Accept()
-			if err != nil {
-				logger.Error("Error accepting connection on Receptor network: %s\n", err)
 
-				return
-			}
-			uc, err := net.Dial("unix", filename)
-			if err != nil {
-				logger.Error("Error connecting via Unix socket: %s\n", err)
+	return bcfg, nil
+}
 
-				continue
-			}
-			go utils.BridgeConns(qc, "receptor service", uc, "unix socket connection")
+// probeInterval parses the interval of the health probe.
+func (cfg unixProxyInboundCfg) probeInterval() (time.Duration, error) {
+	interval, err := time.ParseDuration(cfg.ProbeInterval)
+	if err != nil {
+		return 0, fmt.Errorf("invalid probe interval %s: %s", cfg.ProbeInterval, err)
+	}
+	if interval < 0 {
+		return 0, fmt.Errorf("probe interval must not be negative")
+	}
+
+	return interval, nil
+}
+
+// targets builds the list of targets, starting with RemoteNode and RemoteService if they are set.
+func (cfg unixProxyInboundCfg) targets() ([]ProxyTarget, error) {
+	targets := make([]ProxyTarget, 0, len(cfg.Targets)+1)
+	if cfg.RemoteNode != "" || cfg.RemoteService != "" {
+		if cfg.RemoteNode == "" || cfg.RemoteService == "" {
+			return nil, fmt.Errorf("remotenode and remoteservice must be given together")
 		}
-	}()
+		targets = append(targets, ProxyTarget{Node: cfg.RemoteNode, Service: cfg.RemoteService})
+	}
+	for _, ts := range cfg.Targets {
+		t, err := ParseProxyTarget(ts)
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, t)
+	}
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("either remotenode and remoteservice or targets must be given")
+	}
 
-	return nil
+	return targets, nil
 }
 
-// unixProxyInboundCfg is the cmdline configuration object for a Unix socket inbound proxy.
-type unixProxyInboundCfg struct {
-	Filename      string `required:"true" description:"Socket filename, which will be overwritten"`
-	Permissions   int    `description:"Socket file permissions" default:"0600"`
-	RemoteNode    string `required:"true" description:"Receptor node to connect to"`
-	RemoteService string `required:"true" description:"Receptor service name to connect to"`
-	TLS           string `description:"Name of TLS client config for the Receptor connection"`
+// Prepare verifies the parameters are correct.
+func (cfg unixProxyInboundCfg) Prepare() error {
+	if _, err := cfg.targets(); err != nil {
+		return err
+	}
+	if err := ValidBalancePolicy(cfg.Policy); err != nil {
+		return err
+	}
+	if _, err := cfg.probeInterval(); err != nil {
+		return err
+	}
+	_, err := cfg.breakerConfig()
+
+	return err
 }
 
 // Run runs the action.
 func (cfg unixProxyInboundCfg) Run() error {
+	utils.RecordEffectiveConfig("unix-socket-server", cfg)
 	logger.Debug("Running Unix socket inbound proxy service %v\n", cfg)
-	tlscfg, err := netceptor.MainInstance.GetClientTLSConfig(cfg.TLS, cfg.RemoteNode, "receptor")
+	targets, err := cfg.targets()
+	if err != nil {
+		return err
+	}
+	for i := range targets {
+		targets[i].TLS, err = netceptor.MainInstance.GetClientTLSConfig(cfg.TLS, targets[i].Node, "receptor")
+		if err != nil {
+			return err
+		}
+	}
+	bcfg, err := cfg.breakerConfig()
+	if err != nil {
+		return err
+	}
+	probeInterval, err := cfg.probeInterval()
 	if err != nil {
 		return err
 	}
 
-	return UnixProxyServiceInbound(netceptor.MainInstance, cfg.Filename, os.FileMode(cfg.Permissions),
-		cfg.RemoteNode, cfg.RemoteService, tlscfg)
+	return UnixProxyServiceInboundWithOptions(netceptor.MainInstance, cfg.Filename, os.FileMode(cfg.Permissions),
+		targets, UnixProxyInboundOptions{Policy: cfg.Policy, Breaker: &bcfg, ProbeInterval: probeInterval})
 }
 
 // unixProxyOutboundCfg is the cmdline configuration object for a Unix socket outbound proxy.
 type unixProxyOutboundCfg struct {
-	Service  string `required:"true" description:"Receptor service name to bind to"`
-	Filename string `required:"true" description:"Socket filename, which must already exist"`
-	TLS      string `description:"Name of TLS server config for the Receptor connection"`
+	Service      string `required:"true" description:"Receptor service name to bind to"`
+	Filename     string `required:"true" description:"Socket filename, which must already exist"`
+	TLS          string `description:"Name of TLS server config for the Receptor connection"`
+	ConnLimit    int    `description:"Maximum concurrent connections to the Receptor service (0 for no limit)" default:"0"`
+	ConnQueue    bool   `description:"Queue connections beyond the limit instead of rejecting them" default:"false"`
+	AwaitBackend bool   `description:"Do not advertise the service until a backend connection is up" default:"false"`
+	AwaitTimeout string `description:"Advertise the service anyway after this long without a backend (0 to wait indefinitely)" default:"0"`
 }
 
 // Run runs the action.
 func (cfg unixProxyOutboundCfg) Run() error {
-	logger.Debug("Running Unix socket inbound proxy service %s\n", cfg)
+	utils.RecordEffectiveConfig("unix-socket-client", cfg)
+	logger.Debug("Running Unix socket inbound proxy service %v\n", cfg)
+	if err := awaitBackend(netceptor.MainInstance, cfg.Service, cfg.AwaitBackend, cfg.AwaitTimeout); err != nil {
+		return err
+	}
 	tlscfg, err := netceptor.MainInstance.GetServerTLSConfig(cfg.TLS)
 	if err != nil {
 		return err
 	}
 
-	return UnixProxyServiceOutbound(netceptor.MainInstance, cfg.Service, tlscfg, cfg.Filename)
+	return UnixProxyServiceOutboundWithOptions(netceptor.MainInstance, cfg.Service, tlscfg, cfg.Filename,
+		UnixProxyOutboundOptions{ConnLimit: cfg.ConnLimit, ConnQueue: cfg.ConnQueue})
 }
 
 func init() {
@@ -135,9 +434,31 @@ type UnixInProxy struct {
 	RemoteNode string `mapstructure:"remote-node"`
 	// Receptor service name to connect to.
 	RemoteService string `mapstructure:"remote-service"`
+	// Additional targets to spread connections across.
+	Targets []UnixInProxyTarget `mapstructure:"targets"`
+	// How to choose a target: round-robin, random or least-connections. Defaults to round-robin.
+	Policy string `mapstructure:"policy"`
 	// TLS config to use for the transport within receptor.
 	// Leave empty for no TLS.
 	TLS tls.ClientConf `mapstructure:"tls"`
+	// Number of times to retry a failed Receptor connection. Defaults to 2.
+	DialRetries *int `mapstructure:"dial-retries"`
+	// Delay before the first retry of a failed connection. Defaults to 500ms.
+	DialRetryDelay *time.Duration `mapstructure:"dial-retry-delay"`
+	// Consecutive failed connections before failing fast. Defaults to 5, 0 disables.
+	BreakerThreshold *int `mapstructure:"breaker-threshold"`
+	// How long to fail fast before trying again. Defaults to 30s.
+	BreakerCooldown *time.Duration `mapstructure:"breaker-cooldown"`
+	// How often to check that a target is reachable, refusing connections while none is. Defaults to 0, disabled.
+	ProbeInterval time.Duration `mapstructure:"probe-interval"`
+}
+
+// UnixInProxyTarget is a remote service that an exported unix socket forwards to.
+type UnixInProxyTarget struct {
+	// Receptor node to connect to.
+	RemoteNode string `mapstructure:"remote-node"`
+	// Receptor service name to connect to.
+	RemoteService string `mapstructure:"remote-service"`
 }
 
 func (p *UnixInProxy) setup(nc *netceptor.Netceptor) error {
@@ -151,13 +472,42 @@ func (p *UnixInProxy) setup(nc *netceptor.Netceptor) error {
 		return fmt.Errorf("could not create tls config for unix inbound proxy %s: %w", p.File, err)
 	}
 
-	return UnixProxyServiceInbound(
+	bcfg := DefaultDialBreakerConfig
+	if p.DialRetries != nil {
+		bcfg.Retries = *p.DialRetries
+	}
+	if p.DialRetryDelay != nil {
+		bcfg.RetryDelay = *p.DialRetryDelay
+	}
+	if p.BreakerThreshold != nil {
+		bcfg.FailureThreshold = *p.BreakerThreshold
+	}
+	if p.BreakerCooldown != nil {
+		bcfg.Cooldown = *p.BreakerCooldown
+	}
+
+	var targets []ProxyTarget
+	if p.RemoteNode != "" || p.RemoteService != "" {
+		targets = append(targets, ProxyTarget{Node: p.RemoteNode, Service: p.RemoteService, TLS: t})
+	}
+	for _, pt := range p.Targets {
+		targets = append(targets, ProxyTarget{Node: pt.RemoteNode, Service: pt.RemoteService, TLS: t})
+	}
+	for _, target := range targets {
+		if target.Node == "" || target.Service == "" {
+			return fmt.Errorf("unix inbound proxy %s has a target without a remote node and service", p.File)
+		}
+	}
+	if p.ProbeInterval < 0 {
+		return fmt.Errorf("unix inbound proxy %s has a negative probe interval", p.File)
+	}
+
+	return UnixProxyServiceInboundWithOptions(
 		nc,
 		p.File,
 		os.FileMode(perms),
-		p.RemoteNode,
-		p.RemoteService,
-		t,
+		targets,
+		UnixProxyInboundOptions{Policy: p.Policy, Breaker: &bcfg, ProbeInterval: p.ProbeInterval},
 	)
 }
 
@@ -170,6 +520,14 @@ type UnixOutProxy struct {
 	// TLS config to use for the transport within receptor.
 	// Leave empty for no TLS.
 	TLS tls.ServerConf `mapstructure:"tls"`
+	// Maximum concurrent connections to the Receptor service. Defaults to 0, no limit.
+	ConnLimit int `mapstructure:"conn-limit"`
+	// Queue connections beyond the limit instead of rejecting them.
+	ConnQueue bool `mapstructure:"conn-queue"`
+	// Do not advertise the service until a backend connection is up.
+	AwaitBackend bool `mapstructure:"await-backend"`
+	// Advertise the service anyway after this long without a backend. Defaults to 0, wait indefinitely.
+	AwaitTimeout time.Duration `mapstructure:"await-timeout"`
 }
 
 func (p *UnixOutProxy) setup(nc *netceptor.Netceptor) error {
@@ -178,5 +536,10 @@ func (p *UnixOutProxy) setup(nc *netceptor.Netceptor) error {
 		return fmt.Errorf("could not create tls config for unix outbound proxy %s: %w", p.File, err)
 	}
 
-	return UnixProxyServiceOutbound(nc, p.Service, t, p.File)
+	if p.AwaitBackend {
+		nc.SetReadinessGate(p.Service, p.AwaitTimeout)
+	}
+
+	return UnixProxyServiceOutboundWithOptions(nc, p.Service, t, p.File,
+		UnixProxyOutboundOptions{ConnLimit: p.ConnLimit, ConnQueue: p.ConnQueue})
 }
@@ -0,0 +1,148 @@
+// Package telemetry emits OpenTelemetry traces of receptor operations.  Tracing is a no-op unless an
+// exporter is configured.
+package telemetry
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/ansible/receptor/pkg/logger"
+	"github.com/ansible/receptor/pkg/netceptor"
+	"github.com/ghjm/cmdline"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TraceparentKey is the name of the field that carries W3C trace context in JSON control commands.
+const TraceparentKey = "traceparent"
+
+// instrumentationName identifies receptor as the source of its spans.
+const instrumentationName = "github.com/ansible/receptor"
+
+var (
+	providerLock sync.Mutex
+	provider     *sdktrace.TracerProvider
+)
+
+// Tracer returns the tracer used to create receptor spans.
+func Tracer() trace.Tracer {
+	return otel.Tracer(instrumentationName)
+}
+
+// ContextWithTraceparent returns a context carrying the remote span described by a W3C traceparent
+// header value, so spans started from it continue the caller's trace.  An empty or invalid traceparent
+// returns ctx unchanged.
+func ContextWithTraceparent(ctx context.Context, traceparent string) context.Context {
+	if traceparent == "" {
+		return ctx
+	}
+
+	carrier := propagation.HeaderCarrier{}
+	carrier.Set(TraceparentKey, traceparent)
+
+	return propagation.TraceContext{}.Extract(ctx, carrier)
+}
+
+// Traceparent returns the W3C traceparent header value of the span in ctx, or an empty string if
+// ctx has no valid span.
+func Traceparent(ctx context.Context) string {
+	carrier := propagation.HeaderCarrier{}
+	propagation.TraceContext{}.Inject(ctx, carrier)
+
+	return carrier.Get(TraceparentKey)
+}
+
+// SetSpanProcessor starts sending receptor spans to a span processor, replacing any previous one.
+func SetSpanProcessor(sp sdktrace.SpanProcessor, serviceName string) {
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithSpanProcessor(sp),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL,
+			semconv.ServiceNameKey.String(serviceName))),
+	)
+	providerLock.Lock()
+	old := provider
+	provider = tp
+	providerLock.Unlock()
+	otel.SetTracerProvider(tp)
+	if old != nil {
+		_ = old.Shutdown(context.Background())
+	}
+}
+
+// Shutdown sends any spans that have not been exported yet and stops tracing.
+func Shutdown(ctx context.Context) error {
+	providerLock.Lock()
+	tp := provider
+	provider = nil
+	providerLock.Unlock()
+	if tp == nil {
+		return nil
+	}
+	otel.SetTracerProvider(trace.NewNoopTracerProvider())
+
+	return tp.Shutdown(ctx)
+}
+
+// **************************************************************************
+// Command line
+// **************************************************************************
+
+// otelTracingCfg is the cmdline configuration object for exporting traces.
+type otelTracingCfg struct {
+	Endpoint    string `description:"Host:port of the OTLP/HTTP collector to send traces to" barevalue:"yes" required:"true"`
+	URLPath     string `description:"URL path of the collector's trace endpoint" default:"/v1/traces"`
+	TLS         string `description:"Name of TLS client config for the collector connection"`
+	Insecure    bool   `description:"Connect to the collector over plain HTTP" default:"false"`
+	ServiceName string `description:"Service name to report spans under" default:"receptor"`
+}
+
+// Prepare verifies the parameters are correct.
+func (cfg otelTracingCfg) Prepare() error {
+	if _, _, err := net.SplitHostPort(cfg.Endpoint); err != nil {
+		return fmt.Errorf("invalid collector endpoint %s: %s", cfg.Endpoint, err)
+	}
+	if cfg.Insecure && cfg.TLS != "" {
+		return fmt.Errorf("cannot use a TLS config with an insecure collector connection")
+	}
+
+	return nil
+}
+
+// Run runs the action.
+func (cfg otelTracingCfg) Run() error {
+	opts := []otlptracehttp.Option{
+		otlptracehttp.WithEndpoint(cfg.Endpoint),
+		otlptracehttp.WithURLPath(cfg.URLPath),
+	}
+	if cfg.Insecure {
+		opts = append(opts, otlptracehttp.WithInsecure())
+	} else if cfg.TLS != "" {
+		host, _, _ := net.SplitHostPort(cfg.Endpoint)
+		tlscfg, err := netceptor.MainInstance.GetClientTLSConfig(cfg.TLS, host, "dns")
+		if err != nil {
+			return err
+		}
+		opts = append(opts, otlptracehttp.WithTLSClientConfig(tlscfg))
+	}
+	exp, err := otlptracehttp.New(context.Background(), opts...)
+	if err != nil {
+		return fmt.Errorf("could not create trace exporter: %s", err)
+	}
+	SetSpanProcessor(sdktrace.NewBatchSpanProcessor(exp), cfg.ServiceName)
+	logger.Info("Sending traces to %s%s\n", cfg.Endpoint, cfg.URLPath)
+
+	return nil
+}
+
+func init() {
+	cmdline.RegisterConfigTypeForApp("receptor-telemetry",
+		"otel-tracing", "Send OpenTelemetry traces of work units and control commands to a collector",
+		otelTracingCfg{}, cmdline.Singleton)
+}
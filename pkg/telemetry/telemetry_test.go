@@ -0,0 +1,76 @@
+package telemetry
+
+import (
+	"context"
+	"testing"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestTraceparentRoundTrip(t *testing.T) {
+	const traceparent = "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"
+	ctx := ContextWithTraceparent(context.Background(), traceparent)
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.IsValid() || !sc.IsRemote() {
+		t.Fatalf("expected a valid remote span context, got %v", sc)
+	}
+	if sc.TraceID().String() != "0af7651916cd43dd8448eb211c80319c" {
+		t.Errorf("unexpected trace ID %s", sc.TraceID())
+	}
+	if result := Traceparent(ctx); result != traceparent {
+		t.Errorf("expected traceparent %s, got %s", traceparent, result)
+	}
+
+	for _, invalid := range []string{"", "garbage"} {
+		ctx := ContextWithTraceparent(context.Background(), invalid)
+		if trace.SpanContextFromContext(ctx).IsValid() {
+			t.Errorf("expected no span context from traceparent %q", invalid)
+		}
+		if result := Traceparent(ctx); result != "" {
+			t.Errorf("expected empty traceparent from %q, got %s", invalid, result)
+		}
+	}
+}
+
+func TestSetSpanProcessor(t *testing.T) {
+	exp := tracetest.NewInMemoryExporter()
+	SetSpanProcessor(sdktrace.NewSimpleSpanProcessor(exp), "test-service")
+	t.Cleanup(func() {
+		_ = Shutdown(context.Background())
+	})
+
+	parent := ContextWithTraceparent(context.Background(),
+		"00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	_, span := Tracer().Start(parent, "test span")
+	span.End()
+
+	spans := exp.GetSpans()
+	if len(spans) != 1 {
+		t.Fatalf("expected 1 span, got %d", len(spans))
+	}
+	if spans[0].Name != "test span" {
+		t.Errorf("unexpected span name %s", spans[0].Name)
+	}
+	if spans[0].Parent.SpanID().String() != "b7ad6b7169203331" {
+		t.Errorf("expected span to continue the remote trace, got parent %s", spans[0].Parent.SpanID())
+	}
+	found := false
+	for _, attr := range spans[0].Resource.Attributes() {
+		if attr.Key == "service.name" && attr.Value.AsString() == "test-service" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("expected service.name test-service in resource %v", spans[0].Resource.Attributes())
+	}
+
+	if err := Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	_, span = Tracer().Start(context.Background(), "untraced span")
+	if span.IsRecording() {
+		t.Error("expected spans not to be recorded after shutdown")
+	}
+}
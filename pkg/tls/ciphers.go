@@ -0,0 +1,92 @@
+package tls
+
+import (
+	"crypto/tls"
+	"fmt"
+	"strings"
+)
+
+// DefaultCipherSuites are the cipher suites used when none are configured.  They are limited to
+// suites with forward secrecy and authenticated encryption.  Cipher suites only apply to TLS 1.2
+// and below; TLS 1.3 suites are always chosen by the Go runtime.
+var DefaultCipherSuites = []string{
+	"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256",
+	"TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384",
+	"TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256",
+	"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256",
+	"TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384",
+	"TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256",
+}
+
+// curveNames maps configurable curve names to their IDs.  If no curves are configured, the Go
+// runtime's default preference order is used.
+var curveNames = map[string]tls.CurveID{
+	"X25519": tls.X25519,
+	"P256":   tls.CurveP256,
+	"P384":   tls.CurveP384,
+	"P521":   tls.CurveP521,
+}
+
+// ParseCipherSuites converts cipher suite names, as used by IANA, to their IDs.  Unknown and
+// insecure cipher suites are rejected.  An empty list returns DefaultCipherSuites.
+func ParseCipherSuites(names []string) ([]uint16, error) {
+	if len(names) == 0 {
+		names = DefaultCipherSuites
+	}
+	known := make(map[string]uint16)
+	for _, cs := range tls.CipherSuites() {
+		known[cs.Name] = cs.ID
+	}
+	insecure := make(map[string]bool)
+	for _, cs := range tls.InsecureCipherSuites() {
+		insecure[cs.Name] = true
+	}
+	ids := make([]uint16, 0, len(names))
+	for _, name := range names {
+		name = strings.ToUpper(strings.TrimSpace(name))
+		id, ok := known[name]
+		if !ok {
+			if insecure[name] {
+				return nil, fmt.Errorf("cipher suite %s is insecure", name)
+			}
+
+			return nil, fmt.Errorf("unknown cipher suite %s", name)
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, nil
+}
+
+// ParseCurves converts elliptic curve names (X25519, P256, P384 or P521) to their IDs.  An empty
+// list returns nil, meaning the Go runtime defaults.
+func ParseCurves(names []string) ([]tls.CurveID, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+	ids := make([]tls.CurveID, 0, len(names))
+	for _, name := range names {
+		id, ok := curveNames[strings.ToUpper(strings.TrimSpace(name))]
+		if !ok {
+			return nil, fmt.Errorf("unknown curve %s", name)
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, nil
+}
+
+// ApplyCipherConfig sets the cipher suites and curves of a TLS config.
+func ApplyCipherConfig(tlscfg *tls.Config, cipherSuites []string, curves []string) error {
+	var err error
+	tlscfg.CipherSuites, err = ParseCipherSuites(cipherSuites)
+	if err != nil {
+		return err
+	}
+	tlscfg.CurvePreferences, err = ParseCurves(curves)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
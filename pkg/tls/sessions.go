@@ -0,0 +1,80 @@
+package tls
+
+import (
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// ParseSessionTicketKeys decodes session ticket keys, each given as 64 hex digits.
+func ParseSessionTicketKeys(keys []string) ([][32]byte, error) {
+	parsed := make([][32]byte, 0, len(keys))
+	for i, key := range keys {
+		b, err := hex.DecodeString(strings.TrimSpace(key))
+		if err != nil || len(b) != 32 {
+			return nil, fmt.Errorf("invalid session ticket key %d: must be 64 hex digits", i+1)
+		}
+		var k [32]byte
+		copy(k[:], b)
+		parsed = append(parsed, k)
+	}
+
+	return parsed, nil
+}
+
+// ApplySessionTicketConfig configures session tickets on a server TLS config.  New tickets are encrypted
+// with the first key and tickets encrypted with any of the keys are accepted, so servers sharing the keys
+// can resume each other's sessions, and a new key can be added before an old one is removed.  With no keys,
+// Go's automatically rotated keys are used.
+func ApplySessionTicketConfig(tlscfg *tls.Config, disable bool, keys []string) error {
+	parsed, err := ParseSessionTicketKeys(keys)
+	if err != nil {
+		return err
+	}
+	tlscfg.SessionTicketsDisabled = disable
+	if !disable && len(parsed) > 0 {
+		tlscfg.SetSessionTicketKeys(parsed)
+	}
+
+	return nil
+}
+
+// ApplySessionResumptionConfig gives a client TLS config a session cache, so reconnections to a server
+// can resume the previous session instead of doing a full handshake.  Configs that check the peer with
+// VerifyPeerCertificate must also be passed to VerifyResumedSessions.
+func ApplySessionResumptionConfig(tlscfg *tls.Config, enable bool) {
+	if enable {
+		tlscfg.ClientSessionCache = tls.NewLRUClientSessionCache(0)
+	} else {
+		tlscfg.ClientSessionCache = nil
+	}
+}
+
+// VerifyResumedSessions makes a client TLS config with a session cache run its VerifyPeerCertificate
+// check on resumed sessions as well.  Go only calls VerifyPeerCertificate during a full handshake, so
+// otherwise a session cached while connecting to one peer could be resumed with a peer that the check
+// would refuse.  It must be called after VerifyPeerCertificate is set, and only once for each config.
+func VerifyResumedSessions(tlscfg *tls.Config) {
+	verifyPeer := tlscfg.VerifyPeerCertificate
+	if verifyPeer == nil || tlscfg.ClientSessionCache == nil {
+		return
+	}
+	verifyConn := tlscfg.VerifyConnection
+	tlscfg.VerifyConnection = func(cs tls.ConnectionState) error {
+		if cs.DidResume {
+			rawCerts := make([][]byte, 0, len(cs.PeerCertificates))
+			for _, cert := range cs.PeerCertificates {
+				rawCerts = append(rawCerts, cert.Raw)
+			}
+			if err := verifyPeer(rawCerts, cs.VerifiedChains); err != nil {
+				return err
+			}
+		}
+		if verifyConn != nil {
+			return verifyConn(cs)
+		}
+
+		return nil
+	}
+}
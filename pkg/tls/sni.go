@@ -0,0 +1,62 @@
+package tls
+
+import (
+	"crypto/tls"
+	"fmt"
+	"strings"
+)
+
+// sniConfigs holds server TLS configs keyed by the server name clients request.
+type sniConfigs struct {
+	defaultConfig *tls.Config
+	configs       map[string]*tls.Config
+}
+
+// lookup finds the config for a server name, trying an exact match and then a wildcard match
+// of the form *.example.com, before falling back to the default.
+func (s *sniConfigs) lookup(serverName string) *tls.Config {
+	serverName = strings.ToLower(strings.TrimSuffix(serverName, "."))
+	if cfg, ok := s.configs[serverName]; ok {
+		return cfg
+	}
+	if i := strings.Index(serverName, "."); i > 0 {
+		if cfg, ok := s.configs["*"+serverName[i:]]; ok {
+			return cfg
+		}
+	}
+
+	return s.defaultConfig
+}
+
+// NewSNIConfig returns a server TLS config that uses one of configs, keyed by host name, depending on
+// the server name (SNI) requested by the client.  Keys may be wildcards of the form *.example.com.
+// Clients requesting any other name, or no name, get defaultConfig.  If defaultConfig is nil, their
+// handshakes fail.
+func NewSNIConfig(defaultConfig *tls.Config, configs map[string]*tls.Config) (*tls.Config, error) {
+	s := &sniConfigs{
+		defaultConfig: defaultConfig,
+		configs:       make(map[string]*tls.Config),
+	}
+	for name, cfg := range configs {
+		if name == "" || cfg == nil {
+			return nil, fmt.Errorf("invalid SNI config for %q", name)
+		}
+		s.configs[strings.ToLower(strings.TrimSuffix(name, "."))] = cfg
+	}
+	var tlscfg *tls.Config
+	if defaultConfig != nil {
+		tlscfg = defaultConfig.Clone()
+	} else {
+		tlscfg = &tls.Config{}
+	}
+	tlscfg.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		cfg := s.lookup(hello.ServerName)
+		if cfg == nil {
+			return nil, fmt.Errorf("no TLS config for server name %q", hello.ServerName)
+		}
+
+		return cfg, nil
+	}
+
+	return tlscfg, nil
+}
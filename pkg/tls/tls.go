@@ -16,6 +16,22 @@ type ServerConf struct {
 	CA string `mapstructure:"ca"`
 	// Do not verify clients.
 	SkipVerify bool `mapstructure:"insecure-no-verify"`
+	// Whether clients must present a certificate.  If false, a certificate is only verified if the client
+	// presents one.  Defaults to true unless SkipVerify is set.
+	RequireClientCert *bool `mapstructure:"require-client-cert"`
+	// Cipher suites to allow for TLS 1.2 and below.  Defaults to DefaultCipherSuites.
+	CipherSuites []string `mapstructure:"cipher-suites"`
+	// Elliptic curves to allow for key exchange (X25519, P256, P384, P521).  Defaults to the Go runtime's list.
+	Curves []string `mapstructure:"curves"`
+	// Minimum TLS version (1.2 or 1.3).  Defaults to DefaultMinVersion.
+	MinVersion string `mapstructure:"min-version"`
+	// Maximum TLS version (1.2 or 1.3).  Defaults to the highest supported version.
+	MaxVersion string `mapstructure:"max-version"`
+	// Do not issue session tickets, so every connection does a full handshake.
+	DisableSessionTickets bool `mapstructure:"disable-session-tickets"`
+	// Keys for session tickets, as 64 hex digits each.  The first key encrypts new tickets.  Defaults to
+	// keys generated and rotated by the Go runtime.
+	SessionTicketKeys []string `mapstructure:"session-ticket-keys"`
 }
 
 func (c ServerConf) TLSConfig() (*tls.Config, error) {
@@ -24,6 +40,9 @@ func (c ServerConf) TLSConfig() (*tls.Config, error) {
 	}
 
 	if c.SkipVerify {
+		if c.RequireClientCert != nil && *c.RequireClientCert {
+			return nil, fmt.Errorf("cannot require client certificates without verifying them")
+		}
 		tlscfg.ClientAuth = tls.NoClientCert
 	} else {
 		bytes, err := ioutil.ReadFile(c.CA)
@@ -33,6 +52,9 @@ func (c ServerConf) TLSConfig() (*tls.Config, error) {
 		clientCAs := x509.NewCertPool()
 		clientCAs.AppendCertsFromPEM(bytes)
 		tlscfg.ClientCAs = clientCAs
+		if c.RequireClientCert != nil && !*c.RequireClientCert {
+			tlscfg.ClientAuth = tls.VerifyClientCertIfGiven
+		}
 	}
 
 	certbytes, err := ioutil.ReadFile(c.Cert)
@@ -50,6 +72,21 @@ func (c ServerConf) TLSConfig() (*tls.Config, error) {
 
 	tlscfg.Certificates = []tls.Certificate{cert}
 
+	err = ApplyCipherConfig(tlscfg, c.CipherSuites, c.Curves)
+	if err != nil {
+		return nil, err
+	}
+
+	err = ApplyVersionConfig(tlscfg, c.MinVersion, c.MaxVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	err = ApplySessionTicketConfig(tlscfg, c.DisableSessionTickets, c.SessionTicketKeys)
+	if err != nil {
+		return nil, err
+	}
+
 	return tlscfg, nil
 }
 
@@ -62,6 +99,16 @@ type ClientConf struct {
 	CA string `mapstructure:"ca"`
 	// Do not verify server.
 	SkipVerify bool `mapstructure:"insecure-no-verify"`
+	// Cipher suites to allow for TLS 1.2 and below.  Defaults to DefaultCipherSuites.
+	CipherSuites []string `mapstructure:"cipher-suites"`
+	// Elliptic curves to allow for key exchange (X25519, P256, P384, P521).  Defaults to the Go runtime's list.
+	Curves []string `mapstructure:"curves"`
+	// Minimum TLS version (1.2 or 1.3).  Defaults to DefaultMinVersion.
+	MinVersion string `mapstructure:"min-version"`
+	// Maximum TLS version (1.2 or 1.3).  Defaults to the highest supported version.
+	MaxVersion string `mapstructure:"max-version"`
+	// Cache sessions so reconnections can resume them instead of doing a full handshake.  Defaults to false.
+	SessionResumption bool `mapstructure:"session-resumption"`
 }
 
 func (c ClientConf) TLSConfig() (*tls.Config, error) {
@@ -100,14 +147,27 @@ func (c ClientConf) TLSConfig() (*tls.Config, error) {
 		tlscfg.Certificates = []tls.Certificate{cert}
 	}
 
+	err := ApplyCipherConfig(tlscfg, c.CipherSuites, c.Curves)
+	if err != nil {
+		return nil, err
+	}
+
+	err = ApplyVersionConfig(tlscfg, c.MinVersion, c.MaxVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	ApplySessionResumptionConfig(tlscfg, c.SessionResumption)
+
 	return tlscfg, nil
 }
 
 // Alias some contents of crypto/tls to avoid import madness.
 
 type (
-	Config = tls.Config
-	Conn   = tls.Conn
+	Config          = tls.Config
+	Conn            = tls.Conn
+	ClientHelloInfo = tls.ClientHelloInfo
 )
 
 var (
@@ -0,0 +1,560 @@
+package tls
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeTestCert writes a self-signed ECDSA certificate and key for localhost, returning the file names.
+func writeTestCert(t *testing.T, dir string) (string, string) {
+	return writeTestCertFor(t, dir, "localhost")
+}
+
+// writeTestCertFor writes a self-signed ECDSA certificate and key for a host name, returning the file names.
+func writeTestCertFor(t *testing.T, dir string, name string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-1 * time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile := filepath.Join(dir, name+".crt")
+	keyFile := filepath.Join(dir, name+".key")
+	err = ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER}), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return certFile, keyFile
+}
+
+// handshake connects a client and server over an in-memory pipe, returning the client's connection state.
+func handshake(serverCfg *tls.Config, clientCfg *tls.Config) (tls.ConnectionState, error) {
+	sc, cc := net.Pipe()
+	defer sc.Close()
+	defer cc.Close()
+	server := tls.Server(sc, serverCfg)
+	client := tls.Client(cc, clientCfg)
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- server.Handshake()
+		server.Close()
+	}()
+	err := client.Handshake()
+	if err != nil {
+		cc.Close()
+		<-serverErr
+
+		return tls.ConnectionState{}, err
+	}
+	// In TLS 1.3 the server checks the client's certificate after the client's handshake is done, so
+	// keep reading for any alert it sends
+	go func() { _, _ = io.Copy(ioutil.Discard, client) }()
+	err = <-serverErr
+
+	return client.ConnectionState(), err
+}
+
+func newTestConfigs(t *testing.T, serverSuites []string, clientSuites []string) (*tls.Config, *tls.Config) {
+	dir, err := ioutil.TempDir(os.TempDir(), "receptor-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	certFile, keyFile := writeTestCert(t, dir)
+	serverCfg, err := ServerConf{
+		Cert:         certFile,
+		Key:          keyFile,
+		SkipVerify:   true,
+		CipherSuites: serverSuites,
+	}.TLSConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientCfg, err := ClientConf{
+		SkipVerify:   true,
+		CipherSuites: clientSuites,
+	}.TLSConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Cipher suites are not configurable in TLS 1.3
+	serverCfg.MaxVersion = tls.VersionTLS12
+	clientCfg.MaxVersion = tls.VersionTLS12
+
+	return serverCfg, clientCfg
+}
+
+func TestCipherSuiteNegotiation(t *testing.T) {
+	serverCfg, clientCfg := newTestConfigs(t,
+		[]string{"TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256"},
+		nil)
+	state, err := handshake(serverCfg, clientCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.CipherSuite != tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256 {
+		t.Fatalf("negotiated unexpected cipher suite %s", tls.CipherSuiteName(state.CipherSuite))
+	}
+}
+
+func TestCipherSuiteMismatch(t *testing.T) {
+	serverCfg, clientCfg := newTestConfigs(t,
+		[]string{"TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384"},
+		[]string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"})
+	_, err := handshake(serverCfg, clientCfg)
+	if err == nil {
+		t.Fatal("handshake succeeded with no cipher suites in common")
+	}
+}
+
+func TestParseCipherConfig(t *testing.T) {
+	suites, err := ParseCipherSuites(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(suites) != len(DefaultCipherSuites) {
+		t.Fatalf("expected %d default cipher suites, got %d", len(DefaultCipherSuites), len(suites))
+	}
+	_, err = ParseCipherSuites([]string{"TLS_NOT_A_REAL_SUITE"})
+	if err == nil {
+		t.Fatal("unknown cipher suite was accepted")
+	}
+	_, err = ParseCipherSuites([]string{"TLS_RSA_WITH_RC4_128_SHA"})
+	if err == nil {
+		t.Fatal("insecure cipher suite was accepted")
+	}
+	curves, err := ParseCurves([]string{"x25519", "P384"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(curves) != 2 || curves[0] != tls.X25519 || curves[1] != tls.CurveP384 {
+		t.Fatalf("unexpected curves %v", curves)
+	}
+	_, err = ParseCurves([]string{"P224"})
+	if err == nil {
+		t.Fatal("unknown curve was accepted")
+	}
+	_, err = ClientConf{SkipVerify: true, Curves: []string{"bogus"}}.TLSConfig()
+	if err == nil {
+		t.Fatal("client config with unknown curve was accepted")
+	}
+}
+
+func newVersionTestConfigs(t *testing.T, serverMin string, clientMax string) (*tls.Config, *tls.Config) {
+	dir, err := ioutil.TempDir(os.TempDir(), "receptor-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	certFile, keyFile := writeTestCert(t, dir)
+	serverCfg, err := ServerConf{
+		Cert:       certFile,
+		Key:        keyFile,
+		SkipVerify: true,
+		MinVersion: serverMin,
+	}.TLSConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientCfg, err := ClientConf{
+		SkipVerify: true,
+		MaxVersion: clientMax,
+	}.TLSConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return serverCfg, clientCfg
+}
+
+func TestMinVersionRejectsOlderClient(t *testing.T) {
+	serverCfg, clientCfg := newVersionTestConfigs(t, "1.3", "1.2")
+	_, err := handshake(serverCfg, clientCfg)
+	if err == nil {
+		t.Fatal("TLS 1.3-only server accepted a TLS 1.2 client")
+	}
+}
+
+func TestMinVersionAcceptsClient(t *testing.T) {
+	serverCfg, clientCfg := newVersionTestConfigs(t, "1.3", "1.3")
+	state, err := handshake(serverCfg, clientCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.Version != tls.VersionTLS13 {
+		t.Fatalf("expected TLS 1.3, negotiated %x", state.Version)
+	}
+}
+
+func TestParseVersionConfig(t *testing.T) {
+	tlscfg := &tls.Config{}
+	err := ApplyVersionConfig(tlscfg, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tlscfg.MinVersion != tls.VersionTLS12 || tlscfg.MaxVersion != 0 {
+		t.Fatalf("unexpected default versions %x-%x", tlscfg.MinVersion, tlscfg.MaxVersion)
+	}
+	err = ApplyVersionConfig(tlscfg, "1.3", "1.2")
+	if err == nil {
+		t.Fatal("minimum version greater than maximum version was accepted")
+	}
+	err = ApplyVersionConfig(tlscfg, "1.0", "")
+	if err == nil {
+		t.Fatal("unsupported TLS version was accepted")
+	}
+	_, err = ClientConf{SkipVerify: true, MinVersion: "1.3", MaxVersion: "1.2"}.TLSConfig()
+	if err == nil {
+		t.Fatal("client config with minimum version greater than maximum version was accepted")
+	}
+}
+
+// newClientAuthTestConfigs returns a server config verifying client certificates against a self-signed client
+// cert, and a client config presenting that cert if withCert is set.
+func newClientAuthTestConfigs(t *testing.T, require *bool, withCert bool) (*tls.Config, *tls.Config) {
+	dir, err := ioutil.TempDir(os.TempDir(), "receptor-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	certFile, keyFile := writeTestCert(t, dir)
+	clientCertFile, clientKeyFile := writeTestCertFor(t, dir, "client")
+	serverCfg, err := ServerConf{
+		Cert:              certFile,
+		Key:               keyFile,
+		CA:                clientCertFile,
+		RequireClientCert: require,
+	}.TLSConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientConf := ClientConf{SkipVerify: true}
+	if withCert {
+		clientConf.Cert = clientCertFile
+		clientConf.Key = clientKeyFile
+	}
+	clientCfg, err := clientConf.TLSConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return serverCfg, clientCfg
+}
+
+func TestRequireClientCert(t *testing.T) {
+	required := true
+	optional := false
+	for _, tc := range []struct {
+		name     string
+		require  *bool
+		withCert bool
+		accepted bool
+	}{
+		{"default without cert", nil, false, false},
+		{"default with cert", nil, true, true},
+		{"required without cert", &required, false, false},
+		{"required with cert", &required, true, true},
+		{"optional without cert", &optional, false, true},
+		{"optional with cert", &optional, true, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			serverCfg, clientCfg := newClientAuthTestConfigs(t, tc.require, tc.withCert)
+			_, err := handshake(serverCfg, clientCfg)
+			if tc.accepted && err != nil {
+				t.Fatalf("handshake failed: %s", err)
+			}
+			if !tc.accepted && err == nil {
+				t.Fatal("handshake succeeded without a client certificate")
+			}
+		})
+	}
+	_, err := ServerConf{SkipVerify: true, RequireClientCert: &required}.TLSConfig()
+	if err == nil {
+		t.Fatal("requiring client certificates without verifying them was accepted")
+	}
+}
+
+func newSNITestServerConfig(t *testing.T, dir string, name string) *tls.Config {
+	certFile, keyFile := writeTestCertFor(t, dir, name)
+	cfg, err := ServerConf{
+		Cert:       certFile,
+		Key:        keyFile,
+		SkipVerify: true,
+	}.TLSConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cfg
+}
+
+func TestSNIConfigSelection(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "receptor-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	serverCfg, err := NewSNIConfig(newSNITestServerConfig(t, dir, "default.example.com"), map[string]*tls.Config{
+		"mesh1.example.com":   newSNITestServerConfig(t, dir, "mesh1.example.com"),
+		"mesh2.example.com":   newSNITestServerConfig(t, dir, "mesh2.example.com"),
+		"*.mesh3.example.com": newSNITestServerConfig(t, dir, "wild.mesh3.example.com"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string]string{
+		"mesh1.example.com":      "mesh1.example.com",
+		"MESH2.example.com":      "mesh2.example.com",
+		"node.mesh3.example.com": "wild.mesh3.example.com",
+		"other.example.com":      "default.example.com",
+		"":                       "default.example.com",
+	}
+	for serverName, expected := range tests {
+		state, err := handshake(serverCfg, &tls.Config{
+			ServerName:         serverName,
+			InsecureSkipVerify: true,
+		})
+		if err != nil {
+			t.Fatalf("%s: %s", serverName, err)
+		}
+		got := state.PeerCertificates[0].Subject.CommonName
+		if got != expected {
+			t.Errorf("server name %q got certificate for %s, expected %s", serverName, got, expected)
+		}
+	}
+}
+
+func TestSNIConfigNoDefault(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "receptor-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	serverCfg, err := NewSNIConfig(nil, map[string]*tls.Config{
+		"mesh1.example.com": newSNITestServerConfig(t, dir, "mesh1.example.com"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = handshake(serverCfg, &tls.Config{ServerName: "mesh1.example.com", InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = handshake(serverCfg, &tls.Config{ServerName: "other.example.com", InsecureSkipVerify: true})
+	if err == nil {
+		t.Fatal("expected handshake for an unknown server name to fail without a default config")
+	}
+}
+
+// resumingHandshake connects a client and server, reading one byte from the server so that a TLS 1.3
+// session ticket is received, and returns whether the session was resumed.
+func resumingHandshake(serverCfg *tls.Config, clientCfg *tls.Config) (bool, error) {
+	sc, cc := net.Pipe()
+	defer sc.Close()
+	defer cc.Close()
+	server := tls.Server(sc, serverCfg)
+	client := tls.Client(cc, clientCfg)
+	serverErr := make(chan error, 1)
+	go func() {
+		_, err := server.Write([]byte{0})
+		serverErr <- err
+	}()
+	buf := make([]byte, 1)
+	if _, err := client.Read(buf); err != nil {
+		cc.Close()
+		<-serverErr
+
+		return false, err
+	}
+	if err := <-serverErr; err != nil {
+		return false, err
+	}
+
+	return client.ConnectionState().DidResume, nil
+}
+
+func newSessionTestConfigs(t *testing.T, version uint16, serverConf ServerConf, clientConf ClientConf) (*tls.Config, *tls.Config) {
+	dir, err := ioutil.TempDir(os.TempDir(), "receptor-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	serverConf.Cert, serverConf.Key = writeTestCert(t, dir)
+	serverConf.SkipVerify = true
+	serverCfg, err := serverConf.TLSConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientConf.SkipVerify = true
+	clientCfg, err := clientConf.TLSConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverCfg.MaxVersion = version
+	clientCfg.MaxVersion = version
+
+	return serverCfg, clientCfg
+}
+
+func TestSessionResumption(t *testing.T) {
+	for _, version := range []uint16{tls.VersionTLS12, tls.VersionTLS13} {
+		serverCfg, clientCfg := newSessionTestConfigs(t, version, ServerConf{}, ClientConf{SessionResumption: true})
+		for i, expected := range []bool{false, true, true} {
+			resumed, err := resumingHandshake(serverCfg, clientCfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resumed != expected {
+				t.Fatalf("TLS %x handshake %d: expected resumed to be %v", version, i+1, expected)
+			}
+		}
+	}
+}
+
+func TestSessionResumptionDisabled(t *testing.T) {
+	for _, confs := range []struct {
+		server ServerConf
+		client ClientConf
+	}{
+		{server: ServerConf{DisableSessionTickets: true}, client: ClientConf{SessionResumption: true}},
+		{client: ClientConf{}},
+	} {
+		serverCfg, clientCfg := newSessionTestConfigs(t, tls.VersionTLS13, confs.server, confs.client)
+		for i := 0; i < 2; i++ {
+			resumed, err := resumingHandshake(serverCfg, clientCfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resumed {
+				t.Fatalf("handshake %d resumed a session with resumption disabled (%+v)", i+1, confs)
+			}
+		}
+	}
+}
+
+func TestSessionTicketKeyRotation(t *testing.T) {
+	oldKey := strings.Repeat("01", 32)
+	newKey := strings.Repeat("02", 32)
+	serverCfg, clientCfg := newSessionTestConfigs(t, tls.VersionTLS13,
+		ServerConf{SessionTicketKeys: []string{oldKey}}, ClientConf{SessionResumption: true})
+	staleClientCfg := clientCfg.Clone()
+	staleClientCfg.ClientSessionCache = tls.NewLRUClientSessionCache(0)
+	for _, cfg := range []*tls.Config{clientCfg, staleClientCfg} {
+		if _, err := resumingHandshake(serverCfg, cfg); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A server that has rotated to a new key still accepts tickets encrypted with the old one
+	if err := ApplySessionTicketConfig(serverCfg, false, []string{newKey, oldKey}); err != nil {
+		t.Fatal(err)
+	}
+	resumed, err := resumingHandshake(serverCfg, clientCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !resumed {
+		t.Fatal("expected a ticket encrypted with the previous key to resume")
+	}
+
+	// Once the old key is removed, only tickets issued since the rotation can be resumed
+	if err := ApplySessionTicketConfig(serverCfg, false, []string{newKey}); err != nil {
+		t.Fatal(err)
+	}
+	resumed, err = resumingHandshake(serverCfg, clientCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !resumed {
+		t.Fatal("expected a ticket encrypted with the current key to resume")
+	}
+	resumed, err = resumingHandshake(serverCfg, staleClientCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resumed {
+		t.Fatal("expected a ticket encrypted with a removed key not to resume")
+	}
+}
+
+func TestVerifyResumedSessions(t *testing.T) {
+	for _, version := range []uint16{tls.VersionTLS12, tls.VersionTLS13} {
+		serverCfg, clientCfg := newSessionTestConfigs(t, version, ServerConf{}, ClientConf{SessionResumption: true})
+		accept := true
+		clientCfg.VerifyPeerCertificate = func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+			if len(rawCerts) == 0 {
+				return fmt.Errorf("no peer certificate")
+			}
+			if !accept {
+				return fmt.Errorf("peer refused")
+			}
+
+			return nil
+		}
+		VerifyResumedSessions(clientCfg)
+		if _, err := resumingHandshake(serverCfg, clientCfg); err != nil {
+			t.Fatal(err)
+		}
+		resumed, err := resumingHandshake(serverCfg, clientCfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !resumed {
+			t.Fatalf("TLS %x: expected the session to resume", version)
+		}
+
+		// A peer that is no longer accepted is refused even though its session could be resumed
+		accept = false
+		if _, err := resumingHandshake(serverCfg, clientCfg); err == nil {
+			t.Fatalf("TLS %x: expected a resumed session to be checked again", version)
+		}
+	}
+}
+
+func TestParseSessionTicketKeys(t *testing.T) {
+	keys, err := ParseSessionTicketKeys([]string{strings.Repeat("ab", 32)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || keys[0][0] != 0xab {
+		t.Fatalf("unexpected keys %v", keys)
+	}
+	for _, key := range []string{"", "abcd", strings.Repeat("zz", 32), strings.Repeat("ab", 33)} {
+		if _, err := ParseSessionTicketKeys([]string{key}); err == nil {
+			t.Errorf("expected key %q to be rejected", key)
+		}
+	}
+}
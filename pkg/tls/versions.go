@@ -0,0 +1,53 @@
+package tls
+
+import (
+	"crypto/tls"
+	"fmt"
+	"strings"
+)
+
+// DefaultMinVersion is the minimum TLS version used when none is configured.
+const DefaultMinVersion = "1.2"
+
+// versionNames maps configurable TLS version names to their IDs.
+var versionNames = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// ParseVersion converts a TLS version name ("1.2" or "1.3") to its ID.  An empty name returns 0.
+func ParseVersion(name string) (uint16, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return 0, nil
+	}
+	version, ok := versionNames[name]
+	if !ok {
+		return 0, fmt.Errorf("unsupported TLS version %s: must be 1.2 or 1.3", name)
+	}
+
+	return version, nil
+}
+
+// ApplyVersionConfig sets the minimum and maximum TLS versions of a TLS config.  An empty minimum
+// means DefaultMinVersion, and an empty maximum means the highest version supported.
+func ApplyVersionConfig(tlscfg *tls.Config, minVersion string, maxVersion string) error {
+	if strings.TrimSpace(minVersion) == "" {
+		minVersion = DefaultMinVersion
+	}
+	minID, err := ParseVersion(minVersion)
+	if err != nil {
+		return err
+	}
+	maxID, err := ParseVersion(maxVersion)
+	if err != nil {
+		return err
+	}
+	if maxID != 0 && minID > maxID {
+		return fmt.Errorf("minimum TLS version %s is greater than maximum TLS version %s", minVersion, maxVersion)
+	}
+	tlscfg.MinVersion = minID
+	tlscfg.MaxVersion = maxID
+
+	return nil
+}
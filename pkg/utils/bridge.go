@@ -3,6 +3,7 @@ package utils
 import (
 	"io"
 	"strings"
+	"sync/atomic"
 
 	"github.com/ansible/receptor/pkg/logger"
 )
@@ -12,14 +13,20 @@ const NormalBufferSize = 65536
 
 // BridgeConns bridges two connections, like netcat.
 func BridgeConns(c1 io.ReadWriteCloser, c1Name string, c2 io.ReadWriteCloser, c2Name string) {
+	bridgeConns(c1, c1Name, c2, c2Name, nil, nil)
+}
+
+// bridgeConns bridges two connections, optionally counting the bytes copied in each direction.
+func bridgeConns(c1 io.ReadWriteCloser, c1Name string, c2 io.ReadWriteCloser, c2Name string,
+	count1to2 *int64, count2to1 *int64) {
 	doneChan := make(chan bool)
-	go bridgeHalf(c1, c1Name, c2, c2Name, doneChan)
-	go bridgeHalf(c2, c2Name, c1, c1Name, doneChan)
+	go bridgeHalf(c1, c1Name, c2, c2Name, count1to2, doneChan)
+	go bridgeHalf(c2, c2Name, c1, c1Name, count2to1, doneChan)
 	<-doneChan
 }
 
 // BridgeHalf bridges the read side of c1 to the write side of c2.
-func bridgeHalf(c1 io.ReadWriteCloser, c1Name string, c2 io.ReadWriteCloser, c2Name string, done chan bool) {
+func bridgeHalf(c1 io.ReadWriteCloser, c1Name string, c2 io.ReadWriteCloser, c2Name string, count *int64, done chan bool) {
 	logger.Trace("    Bridging %s to %s\n", c1Name, c2Name)
 	defer func() {
 		done <- true
@@ -37,6 +44,9 @@ func bridgeHalf(c1 io.ReadWriteCloser, c1Name string, c2 io.ReadWriteCloser, c2N
 		if n > 0 {
 			logger.Trace("    Copied %d bytes from %s to %s\n", n, c1Name, c2Name)
 			wn, err := c2.Write(buf[:n])
+			if count != nil && wn > 0 {
+				atomic.AddInt64(count, int64(wn))
+			}
 			if err != nil {
 				logger.Error("Connection write error: %s\n", err)
 				shouldClose = true
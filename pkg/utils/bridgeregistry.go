@@ -0,0 +1,102 @@
+package utils
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// BridgeInfo describes an active bridged connection.
+type BridgeInfo struct {
+	ID          string
+	Source      string
+	Destination string
+	BytesIn     int64
+	BytesOut    int64
+	Started     time.Time
+}
+
+// trackedBridge is an entry in the bridge registry.  The counters are first to keep them 64-bit aligned.
+type trackedBridge struct {
+	bytesIn     int64
+	bytesOut    int64
+	id          string
+	source      string
+	destination string
+	started     time.Time
+	c1          io.Closer
+	c2          io.Closer
+}
+
+var (
+	bridgeLock   sync.RWMutex
+	bridges      = make(map[string]*trackedBridge)
+	bridgeNextID uint64
+)
+
+// TrackedBridgeConns bridges two connections, like BridgeConns, and registers the bridge so it
+// can be listed by ActiveBridges and closed by KillBridge.  Bytes read from c1 are counted as
+// incoming and bytes read from c2 as outgoing.
+func TrackedBridgeConns(source string, destination string,
+	c1 io.ReadWriteCloser, c1Name string, c2 io.ReadWriteCloser, c2Name string) {
+	tb := &trackedBridge{
+		id:          strconv.FormatUint(atomic.AddUint64(&bridgeNextID, 1), 10),
+		source:      source,
+		destination: destination,
+		started:     time.Now(),
+		c1:          c1,
+		c2:          c2,
+	}
+	bridgeLock.Lock()
+	bridges[tb.id] = tb
+	bridgeLock.Unlock()
+	defer func() {
+		bridgeLock.Lock()
+		delete(bridges, tb.id)
+		bridgeLock.Unlock()
+	}()
+	bridgeConns(c1, c1Name, c2, c2Name, &tb.bytesIn, &tb.bytesOut)
+}
+
+// ActiveBridges returns information about all active tracked bridges, ordered by ID.
+func ActiveBridges() []BridgeInfo {
+	bridgeLock.RLock()
+	infos := make([]BridgeInfo, 0, len(bridges))
+	for _, tb := range bridges {
+		infos = append(infos, BridgeInfo{
+			ID:          tb.id,
+			Source:      tb.source,
+			Destination: tb.destination,
+			BytesIn:     atomic.LoadInt64(&tb.bytesIn),
+			BytesOut:    atomic.LoadInt64(&tb.bytesOut),
+			Started:     tb.started,
+		})
+	}
+	bridgeLock.RUnlock()
+	sort.Slice(infos, func(i, j int) bool {
+		idI, _ := strconv.ParseUint(infos[i].ID, 10, 64)
+		idJ, _ := strconv.ParseUint(infos[j].ID, 10, 64)
+
+		return idI < idJ
+	})
+
+	return infos
+}
+
+// KillBridge closes both ends of an active tracked bridge.
+func KillBridge(id string) error {
+	bridgeLock.RLock()
+	tb, ok := bridges[id]
+	bridgeLock.RUnlock()
+	if !ok {
+		return fmt.Errorf("no active connection with ID %s", id)
+	}
+	_ = tb.c1.Close()
+	_ = tb.c2.Close()
+
+	return nil
+}
@@ -0,0 +1,77 @@
+package utils
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestTrackedBridgeConns(t *testing.T) {
+	client, clientSide := net.Pipe()
+	serviceSide, service := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		TrackedBridgeConns("tcp 127.0.0.1:1234", "node2/web", clientSide, "client", serviceSide, "service")
+		close(done)
+	}()
+
+	if _, err := client.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 16)
+	n, err := service.Read(buf)
+	if err != nil || string(buf[:n]) != "hello" {
+		t.Fatalf("expected hello, got %q (%v)", buf[:n], err)
+	}
+	go func() {
+		_, _ = service.Write([]byte("hi"))
+	}()
+	n, err = client.Read(buf)
+	if err != nil || string(buf[:n]) != "hi" {
+		t.Fatalf("expected hi, got %q (%v)", buf[:n], err)
+	}
+
+	// The byte counts are updated just after each write completes
+	var info BridgeInfo
+	for i := 0; i < 100; i++ {
+		for _, b := range ActiveBridges() {
+			if b.Source == "tcp 127.0.0.1:1234" {
+				info = b
+			}
+		}
+		if info.BytesOut == 2 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if info.ID == "" {
+		t.Fatal("bridge not listed")
+	}
+	if info.Destination != "node2/web" || info.BytesIn != 5 || info.BytesOut != 2 {
+		t.Errorf("unexpected bridge info %+v", info)
+	}
+
+	if err := KillBridge(info.ID); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []net.Conn{client, service} {
+		_ = c.SetReadDeadline(time.Now().Add(5 * time.Second))
+		if _, err := c.Read(buf); err != io.EOF {
+			t.Errorf("expected EOF after kill, got %v", err)
+		}
+	}
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("bridge did not finish after kill")
+	}
+	for _, b := range ActiveBridges() {
+		if b.ID == info.ID {
+			t.Error("killed bridge still listed")
+		}
+	}
+	if err := KillBridge(info.ID); err == nil {
+		t.Error("expected error killing finished bridge")
+	}
+}
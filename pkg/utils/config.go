@@ -0,0 +1,247 @@
+package utils
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// LoadedConfig is a YAML config loaded from a file or a directory of fragments.
+type LoadedConfig struct {
+	// Data is the merged YAML config.
+	Data []byte
+	// Files are the files the config was loaded from, in the order they were merged.
+	Files []string
+	// Sources maps each config type to the files that define it.
+	Sources map[string][]string
+	// Expanded is true if Data differs from the raw content of a single file, because the config
+	// was compressed or loaded from a directory.
+	Expanded bool
+}
+
+// isConfigFragment returns true if a file in a config directory should be loaded.
+func isConfigFragment(name string) bool {
+	name = strings.TrimSuffix(strings.TrimSuffix(strings.TrimSuffix(name, ".gz"), ".zz"), ".deflate")
+
+	return strings.HasSuffix(name, ".yml") || strings.HasSuffix(name, ".yaml")
+}
+
+// readConfigFile reads a config file, decompressing it if it is gzip or zlib (deflate) compressed.
+// Returns true if the file was compressed.
+func readConfigFile(filename string) ([]byte, bool, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, false, err
+	}
+	var r io.ReadCloser
+	switch {
+	case bytes.HasPrefix(data, []byte{0x1f, 0x8b}):
+		r, err = gzip.NewReader(bytes.NewReader(data))
+	case strings.HasSuffix(filename, ".zz") || strings.HasSuffix(filename, ".deflate"):
+		r, err = zlib.NewReader(bytes.NewReader(data))
+	default:
+		return data, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("error decompressing %s: %s", filename, err)
+	}
+	defer r.Close()
+	data, err = ioutil.ReadAll(r)
+	if err != nil {
+		return nil, false, fmt.Errorf("error decompressing %s: %s", filename, err)
+	}
+
+	return data, true, nil
+}
+
+// configItemType returns the config type of a top-level config item, such as "tcp-peer".
+func configItemType(item interface{}) string {
+	switch v := item.(type) {
+	case string:
+		return v
+	case map[interface{}]interface{}:
+		for k := range v {
+			return fmt.Sprint(k)
+		}
+	}
+
+	return ""
+}
+
+// configIdentityKeys are the parameters that identify what a config item sets up, such as the port of a
+// listener or the name of a work type.  Two items of the same type with the same values for these set up the
+// same thing, even if their other parameters differ.
+var configIdentityKeys = []string{
+	"port", "bindaddr", "address", "filename", "path", "service", "remotenode", "remoteservice",
+	"worktype", "work-type", "name",
+}
+
+// configItemIdentity returns a key that is equal for config items that set up the same thing: the type and
+// identifying parameters of the item, or the whole item if it has none.
+func configItemIdentity(item interface{}) (string, error) {
+	if m, ok := item.(map[interface{}]interface{}); ok && len(m) == 1 {
+		for k, v := range m {
+			params, ok := v.(map[interface{}]interface{})
+			if !ok {
+				break
+			}
+			lower := make(map[string]string)
+			for pk, pv := range params {
+				lower[strings.ToLower(fmt.Sprint(pk))] = fmt.Sprint(pv)
+			}
+			ids := make([]string, 0)
+			for _, key := range configIdentityKeys {
+				if val, ok := lower[key]; ok {
+					ids = append(ids, fmt.Sprintf("%s=%s", key, val))
+				}
+			}
+			if len(ids) > 0 {
+				return fmt.Sprintf("%s with %s", strings.ToLower(fmt.Sprint(k)), strings.Join(ids, ", ")), nil
+			}
+		}
+	}
+	itemBytes, err := yaml.Marshal(item)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(itemBytes)), nil
+}
+
+// addSource records that a file defines a config type.
+func (lc *LoadedConfig) addSource(configType string, filename string) {
+	sources := lc.Sources[configType]
+	if len(sources) == 0 || sources[len(sources)-1] != filename {
+		lc.Sources[configType] = append(sources, filename)
+	}
+}
+
+// LoadConfig loads a YAML config.  The filename may be a single file, optionally gzip or zlib
+// (deflate) compressed, or a directory, in which case all *.yml and *.yaml files in it (which may
+// also be compressed) are merged in sorted order.  Items in different fragments that set up the same thing,
+// such as two listeners on the same port, are reported as a conflict, even if their other parameters differ.
+func LoadConfig(filename string) (*LoadedConfig, error) {
+	fi, err := os.Stat(filename)
+	if err != nil {
+		return nil, err
+	}
+	lc := &LoadedConfig{
+		Sources: make(map[string][]string),
+	}
+	if !fi.IsDir() {
+		data, compressed, err := readConfigFile(filename)
+		if err != nil {
+			return nil, err
+		}
+		lc.Data = data
+		lc.Files = []string{filename}
+		lc.Expanded = compressed
+		items := make([]interface{}, 0)
+		if yaml.Unmarshal(data, &items) == nil {
+			for _, item := range items {
+				lc.addSource(configItemType(item), filename)
+			}
+		}
+
+		return lc, nil
+	}
+	entries, err := ioutil.ReadDir(filename)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0)
+	for _, entry := range entries {
+		if !entry.IsDir() && !strings.HasPrefix(entry.Name(), ".") && isConfigFragment(entry.Name()) {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+	lc.Expanded = true
+	merged := make([]interface{}, 0)
+	seen := make(map[string]string)
+	for _, name := range names {
+		fragment := filepath.Join(filename, name)
+		data, _, err := readConfigFile(fragment)
+		if err != nil {
+			return nil, err
+		}
+		items := make([]interface{}, 0)
+		err = yaml.Unmarshal(data, &items)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing config fragment %s: %s", fragment, err)
+		}
+		for _, item := range items {
+			identity, err := configItemIdentity(item)
+			if err != nil {
+				return nil, err
+			}
+			if prev, ok := seen[identity]; ok && prev != fragment {
+				return nil, fmt.Errorf("conflicting config: %s is defined in both %s and %s", identity, prev, fragment)
+			}
+			seen[identity] = fragment
+			lc.addSource(configItemType(item), fragment)
+			merged = append(merged, item)
+		}
+		lc.Files = append(lc.Files, fragment)
+	}
+	lc.Data, err = yaml.Marshal(merged)
+	if err != nil {
+		return nil, err
+	}
+
+	return lc, nil
+}
+
+// NodeDataDir returns the data dir set in the node item of the config, or an empty string if there is none.
+func (lc *LoadedConfig) NodeDataDir() string {
+	items := make([]interface{}, 0)
+	if yaml.Unmarshal(lc.Data, &items) != nil {
+		return ""
+	}
+	for _, item := range items {
+		m, ok := item.(map[interface{}]interface{})
+		if !ok || configItemType(item) != "node" {
+			continue
+		}
+		params, ok := m["node"].(map[interface{}]interface{})
+		if !ok {
+			continue
+		}
+		for k, v := range params {
+			if strings.EqualFold(fmt.Sprint(k), "datadir") {
+				return fmt.Sprint(v)
+			}
+		}
+	}
+
+	return ""
+}
+
+var onlyOneDirectiveRegex = regexp.MustCompile(`only one (\S+) directive is allowed`)
+
+// ExplainError adds the files defining a config type to an error about that type being defined more than once.
+func (lc *LoadedConfig) ExplainError(err error) error {
+	if err == nil {
+		return nil
+	}
+	m := onlyOneDirectiveRegex.FindStringSubmatch(err.Error())
+	if m == nil {
+		return err
+	}
+	files := lc.Sources[m[1]]
+	if len(files) < 2 {
+		return err
+	}
+
+	return fmt.Errorf("%s (defined in %s)", err, strings.Join(files, ", "))
+}
@@ -0,0 +1,159 @@
+package utils
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+func writeFragment(t *testing.T, dir string, name string, content string) {
+	err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// configTypes returns the config type of each item in a loaded config.
+func configTypes(t *testing.T, lc *LoadedConfig) []string {
+	items := make([]interface{}, 0)
+	err := yaml.Unmarshal(lc.Data, &items)
+	if err != nil {
+		t.Fatal(err)
+	}
+	types := make([]string, 0)
+	for _, item := range items {
+		types = append(types, configItemType(item))
+	}
+
+	return types
+}
+
+func TestLoadConfigDirectory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "receptor-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeFragment(t, dir, "20-backends.yaml", "- tcp-listener:\n    port: 2222\n")
+	writeFragment(t, dir, "10-node.yml", "- node:\n    id: foo\n- log-level: debug\n")
+	writeFragment(t, dir, "README.txt", "not config")
+	writeFragment(t, dir, ".30-hidden.yml", "- tcp-peer:\n    address: localhost:2223\n")
+
+	lc, err := LoadConfig(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !lc.Expanded {
+		t.Fatal("config directory was not marked as expanded")
+	}
+	if !reflect.DeepEqual(configTypes(t, lc), []string{"node", "log-level", "tcp-listener"}) {
+		t.Fatalf("unexpected merged config:\n%s", lc.Data)
+	}
+
+	// A reload picks up an added fragment
+	writeFragment(t, dir, "30-peers.yml", "- tcp-peer:\n    address: localhost:2223\n")
+	lc, err = LoadConfig(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(configTypes(t, lc), []string{"node", "log-level", "tcp-listener", "tcp-peer"}) {
+		t.Fatalf("unexpected merged config after adding a fragment:\n%s", lc.Data)
+	}
+	if !reflect.DeepEqual(lc.Sources["tcp-peer"], []string{filepath.Join(dir, "30-peers.yml")}) {
+		t.Fatalf("unexpected sources %v", lc.Sources)
+	}
+}
+
+func TestLoadConfigConflict(t *testing.T) {
+	dir, err := ioutil.TempDir("", "receptor-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeFragment(t, dir, "a.yml", "- node:\n    id: foo\n- tcp-listener:\n    port: 2222\n")
+	writeFragment(t, dir, "b.yml", "- tcp-listener:\n    port: 2222\n")
+	_, err = LoadConfig(dir)
+	if err == nil || !strings.Contains(err.Error(), "a.yml") || !strings.Contains(err.Error(), "b.yml") {
+		t.Fatalf("expected a conflict naming both fragments, got %v", err)
+	}
+
+	// Items that set up the same thing conflict even if their other parameters differ
+	writeFragment(t, dir, "b.yml", "- tcp-listener:\n    Port: 2222\n    cost: 2.0\n")
+	_, err = LoadConfig(dir)
+	if err == nil || !strings.Contains(err.Error(), "tcp-listener with port=2222") {
+		t.Fatalf("expected a conflict on the listener port, got %v", err)
+	}
+	writeFragment(t, dir, "b.yml", "- tcp-listener:\n    port: 2223\n- tcp-listener:\n    port: 2222\n    bindaddr: 127.0.0.1\n")
+	if _, err = LoadConfig(dir); err != nil {
+		t.Fatalf("expected listeners on other ports and addresses not to conflict, got %s", err)
+	}
+
+	writeFragment(t, dir, "b.yml", "- node:\n    id: bar\n")
+	lc, err := LoadConfig(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = lc.ExplainError(fmt.Errorf("only one node directive is allowed"))
+	if !strings.Contains(err.Error(), "a.yml") || !strings.Contains(err.Error(), "b.yml") {
+		t.Fatalf("expected the error to name both fragments, got %s", err)
+	}
+	err = lc.ExplainError(fmt.Errorf("some other error"))
+	if err.Error() != "some other error" {
+		t.Fatalf("unrelated error was changed: %s", err)
+	}
+}
+
+func TestNodeDataDir(t *testing.T) {
+	lc := &LoadedConfig{Data: []byte("- log-level: debug\n- node:\n    id: foo\n    DataDir: /var/lib/receptor\n")}
+	if dir := lc.NodeDataDir(); dir != "/var/lib/receptor" {
+		t.Fatalf("expected the node data dir, got %q", dir)
+	}
+	lc = &LoadedConfig{Data: []byte("- node:\n    id: foo\n")}
+	if dir := lc.NodeDataDir(); dir != "" {
+		t.Fatalf("expected no data dir, got %q", dir)
+	}
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "receptor-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	content := "- node:\n    id: foo\n"
+	writeFragment(t, dir, "plain.yml", content)
+	lc, err := LoadConfig(filepath.Join(dir, "plain.yml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lc.Expanded || string(lc.Data) != content {
+		t.Fatalf("plain config file was changed: %q", lc.Data)
+	}
+
+	var buf bytes.Buffer
+	gzw := gzip.NewWriter(&buf)
+	_, err = gzw.Write([]byte(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = gzw.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFragment(t, dir, "compressed.yml.gz", buf.String())
+	lc, err = LoadConfig(filepath.Join(dir, "compressed.yml.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !lc.Expanded || string(lc.Data) != content {
+		t.Fatalf("compressed config file was not decompressed: %q", lc.Data)
+	}
+}
@@ -0,0 +1,45 @@
+package utils
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// MaxDSCP is the largest Differentiated Services Code Point, which has six bits.
+const MaxDSCP = 63
+
+// ValidateDSCP returns an error if dscp is not a valid Differentiated Services Code Point.
+func ValidateDSCP(dscp int) error {
+	if dscp < 0 || dscp > MaxDSCP {
+		return fmt.Errorf("DSCP must be between 0 and %d", MaxDSCP)
+	}
+
+	return nil
+}
+
+// DSCPControl returns a function for the Control field of a net.Dialer or net.ListenConfig, which marks
+// the packets sent on its sockets with dscp, by setting IP_TOS or IPV6_TCLASS.  Sockets accepted by a
+// listener inherit the marking.  It returns nil if dscp is 0, leaving sockets unmarked.
+func DSCPControl(dscp int) func(network, address string, c syscall.RawConn) error {
+	if dscp == 0 {
+		return nil
+	}
+
+	return func(network, address string, c syscall.RawConn) error {
+		if err := ValidateDSCP(dscp); err != nil {
+			return err
+		}
+		var serr error
+		err := c.Control(func(fd uintptr) {
+			serr = setDSCP(fd, network, dscp)
+		})
+		if err != nil {
+			return err
+		}
+		if serr != nil {
+			return fmt.Errorf("could not set DSCP on socket: %w", serr)
+		}
+
+		return nil
+	}
+}
@@ -0,0 +1,86 @@
+package utils
+
+import (
+	"context"
+	"net"
+	"syscall"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+// socketTOS returns the IPv4 type of service or IPv6 traffic class of a TCP connection.
+func socketTOS(t *testing.T, conn net.Conn, ipv6 bool) int {
+	rc, err := conn.(syscall.Conn).SyscallConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tos int
+	var serr error
+	err = rc.Control(func(fd uintptr) {
+		if ipv6 {
+			tos, serr = unix.GetsockoptInt(int(fd), unix.IPPROTO_IPV6, unix.IPV6_TCLASS)
+		} else {
+			tos, serr = unix.GetsockoptInt(int(fd), unix.IPPROTO_IP, unix.IP_TOS)
+		}
+	})
+	if err != nil || serr != nil {
+		t.Fatalf("could not read socket option: %v %v", err, serr)
+	}
+
+	return tos
+}
+
+func TestDSCPControl(t *testing.T) {
+	for _, dscp := range []int{-1, MaxDSCP + 1} {
+		if err := ValidateDSCP(dscp); err == nil {
+			t.Errorf("expected DSCP %d to be refused", dscp)
+		}
+	}
+	if DSCPControl(0) != nil {
+		t.Error("expected no control function for DSCP 0")
+	}
+
+	for _, network := range []string{"tcp4", "tcp6"} {
+		address := "127.0.0.1:0"
+		if network == "tcp6" {
+			address = "[::1]:0"
+		}
+		// Expedited forwarding, as used for voice and interactive traffic
+		const dscp = 46
+		lc := &net.ListenConfig{Control: DSCPControl(dscp)}
+		li, err := lc.Listen(context.Background(), network, address)
+		if err != nil {
+			if network == "tcp6" {
+				t.Logf("skipping IPv6: %s", err)
+
+				continue
+			}
+			t.Fatal(err)
+		}
+		accepted := make(chan net.Conn, 1)
+		go func() {
+			conn, err := li.Accept()
+			if err == nil {
+				accepted <- conn
+			}
+			close(accepted)
+		}()
+		dialer := &net.Dialer{Control: DSCPControl(dscp)}
+		conn, err := dialer.Dial(network, li.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tos := socketTOS(t, conn, network == "tcp6"); tos != dscp<<2 {
+			t.Errorf("expected %s dialed socket to be marked %d, got %d", network, dscp<<2, tos)
+		}
+		if sconn := <-accepted; sconn != nil {
+			if tos := socketTOS(t, sconn, network == "tcp6"); tos != dscp<<2 {
+				t.Errorf("expected %s accepted socket to be marked %d, got %d", network, dscp<<2, tos)
+			}
+			_ = sconn.Close()
+		}
+		_ = conn.Close()
+		_ = li.Close()
+	}
+}
@@ -0,0 +1,26 @@
+//go:build !windows
+// +build !windows
+
+package utils
+
+import (
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// setDSCP sets the traffic class of a socket.  The DSCP is the upper six bits of the IPv4 type of service
+// and the IPv6 traffic class.
+func setDSCP(fd uintptr, network string, dscp int) error {
+	if strings.HasSuffix(network, "6") {
+		if err := unix.SetsockoptInt(int(fd), unix.IPPROTO_IPV6, unix.IPV6_TCLASS, dscp<<2); err != nil {
+			return err
+		}
+		// An IPv6 socket may also carry IPv4 traffic, which is marked with IP_TOS where that is supported
+		_ = unix.SetsockoptInt(int(fd), unix.IPPROTO_IP, unix.IP_TOS, dscp<<2)
+
+		return nil
+	}
+
+	return unix.SetsockoptInt(int(fd), unix.IPPROTO_IP, unix.IP_TOS, dscp<<2)
+}
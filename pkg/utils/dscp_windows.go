@@ -0,0 +1,13 @@
+//go:build windows
+// +build windows
+
+package utils
+
+import (
+	"fmt"
+)
+
+// setDSCP is not implemented on Windows, where DSCP marking is set by group policy
+func setDSCP(fd uintptr, network string, dscp int) error {
+	return fmt.Errorf("DSCP marking is not supported on Windows")
+}
@@ -0,0 +1,102 @@
+package utils
+
+import (
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// RedactedValue replaces the value of secret config fields in the effective configuration.
+const RedactedValue = "<redacted>"
+
+// effectiveConfigItem is a config object that has been run, under the name of its action.
+type effectiveConfigItem struct {
+	action string
+	cfg    interface{}
+}
+
+var (
+	effectiveConfigLock  sync.Mutex
+	effectiveConfigItems []effectiveConfigItem
+)
+
+// RecordEffectiveConfig adds a config object to the effective running configuration.  Config types call
+// this when they run, after defaults have been applied, so the recorded values are the ones in use.
+func RecordEffectiveConfig(action string, cfg interface{}) {
+	effectiveConfigLock.Lock()
+	defer effectiveConfigLock.Unlock()
+	effectiveConfigItems = append(effectiveConfigItems, effectiveConfigItem{action: action, cfg: cfg})
+}
+
+// ForgetEffectiveConfig removes the config objects of the given actions from the effective running
+// configuration.  This is used before a reload runs those actions again.
+func ForgetEffectiveConfig(actions []string) {
+	effectiveConfigLock.Lock()
+	defer effectiveConfigLock.Unlock()
+	kept := make([]effectiveConfigItem, 0, len(effectiveConfigItems))
+	for _, item := range effectiveConfigItems {
+		forget := false
+		for _, action := range actions {
+			if item.action == action {
+				forget = true
+
+				break
+			}
+		}
+		if !forget {
+			kept = append(kept, item)
+		}
+	}
+	effectiveConfigItems = kept
+}
+
+// EffectiveConfig returns the effective running configuration in the same layout as a YAML config file:
+// a list of single-key maps from action name to parameters, in the order the actions ran.  Fields tagged
+// `redact:"true"` are replaced with RedactedValue if they are set.
+func EffectiveConfig() []map[string]interface{} {
+	effectiveConfigLock.Lock()
+	defer effectiveConfigLock.Unlock()
+	items := make([]map[string]interface{}, 0, len(effectiveConfigItems))
+	for _, item := range effectiveConfigItems {
+		items = append(items, map[string]interface{}{
+			item.action: configParams(reflect.ValueOf(item.cfg)),
+		})
+	}
+
+	return items
+}
+
+// configParams returns the fields of a config struct keyed by their lowercased names, as they are
+// given in a config file.
+func configParams(v reflect.Value) map[string]interface{} {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return map[string]interface{}{}
+		}
+		v = v.Elem()
+	}
+	params := make(map[string]interface{})
+	if v.Kind() != reflect.Struct {
+		return params
+	}
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Type().Field(i)
+		if f.Anonymous {
+			for name, value := range configParams(v.Field(i)) {
+				params[name] = value
+			}
+
+			continue
+		}
+		if f.PkgPath != "" {
+			continue
+		}
+		value := v.Field(i).Interface()
+		if f.Tag.Get("redact") == "true" && !v.Field(i).IsZero() {
+			value = RedactedValue
+		}
+		params[strings.ToLower(f.Name)] = value
+	}
+
+	return params
+}
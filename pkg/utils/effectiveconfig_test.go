@@ -0,0 +1,84 @@
+package utils
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/ghjm/cmdline"
+)
+
+type testPeerCfg struct {
+	Address string  `description:"Remote address" required:"true"`
+	Cost    float64 `description:"Connection cost" default:"1.0"`
+	PSK     string  `description:"Pre-shared key" redact:"true"`
+	Token   string  `description:"Unset secret" redact:"true"`
+}
+
+func (cfg testPeerCfg) Run() error {
+	RecordEffectiveConfig("test-peer", cfg)
+
+	return nil
+}
+
+type testServiceCfg struct {
+	Service string   `description:"Service name" required:"true"`
+	Tags    []string `description:"Service tags"`
+}
+
+func (cfg testServiceCfg) Run() error {
+	RecordEffectiveConfig("test-service", cfg)
+
+	return nil
+}
+
+func TestEffectiveConfig(t *testing.T) {
+	t.Cleanup(func() {
+		ForgetEffectiveConfig([]string{"test-peer", "test-service"})
+	})
+	dir, err := ioutil.TempDir("", "receptor-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeFragment(t, dir, "receptor.yml", `
+- test-peer:
+    address: localhost:2222
+    psk: hunter2
+- test-service:
+    service: echo
+    tags:
+      - a
+      - b
+`)
+	cl := cmdline.NewCmdline()
+	cl.AddConfigType("test-peer", "Test peer", testPeerCfg{})
+	cl.AddConfigType("test-service", "Test service", testServiceCfg{})
+	err = cl.ParseAndRun([]string{"--config", filepath.Join(dir, "receptor.yml")}, []string{"Run"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []map[string]interface{}{
+		{"test-peer": map[string]interface{}{
+			"address": "localhost:2222",
+			"cost":    1.0,
+			"psk":     RedactedValue,
+			"token":   "",
+		}},
+		{"test-service": map[string]interface{}{
+			"service": "echo",
+			"tags":    []string{"a", "b"},
+		}},
+	}
+	if effective := EffectiveConfig(); !reflect.DeepEqual(effective, expected) {
+		t.Errorf("expected effective config %v, got %v", expected, effective)
+	}
+
+	ForgetEffectiveConfig([]string{"test-peer"})
+	if effective := EffectiveConfig(); !reflect.DeepEqual(effective, expected[1:]) {
+		t.Errorf("expected effective config %v after forgetting test-peer, got %v", expected[1:], effective)
+	}
+}
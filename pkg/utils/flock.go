@@ -3,38 +3,132 @@
 package utils
 
 import (
+	"errors"
 	"fmt"
+	"os"
+	"strconv"
+	"strings"
 	"syscall"
 )
 
 // ErrLocked is returned when the flock is already held.
 var ErrLocked = fmt.Errorf("fslock is already locked")
 
-// FLock represents a file lock.
+// FLock represents a file lock.  While it is held, the lock file records the ID of the process holding it.
 type FLock struct {
-	fd int
+	fd       int
+	stalePID int
 }
 
-// TryFLock non-blockingly attempts to acquire a lock on the file.
+// FLockStatus describes a lock file and whether it is held.
+type FLockStatus struct {
+	// Filename is the name of the lock file.
+	Filename string
+	// PID is the process recorded in the file as holding the lock, or 0 if there is none.
+	PID int
+	// Held is whether some process currently holds the lock.
+	Held bool
+	// Stale is whether the file records a process that no longer holds the lock, because it exited without
+	// releasing it.  A stale lock is reclaimed by the next process to take it.
+	Stale bool
+}
+
+// readLockPID returns the process ID recorded in an open lock file, or 0 if there is none.
+func readLockPID(fd int) int {
+	buf := make([]byte, 32)
+	n, err := syscall.Pread(fd, buf, 0)
+	if err != nil || n <= 0 {
+		return 0
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(buf[:n])))
+	if err != nil {
+		return 0
+	}
+
+	return pid
+}
+
+// writeLockPID replaces the process ID recorded in an open lock file, clearing it if pid is 0.
+func writeLockPID(fd int, pid int) error {
+	if err := syscall.Ftruncate(fd, 0); err != nil {
+		return err
+	}
+	if pid == 0 {
+		return nil
+	}
+	_, err := syscall.Pwrite(fd, []byte(fmt.Sprintf("%d\n", pid)), 0)
+
+	return err
+}
+
+// TryFLock non-blockingly attempts to acquire a lock on the file.  The lock is released by the kernel if
+// the holding process exits, so a lock file left behind by a process that crashed is simply taken over;
+// a lock held by a live process is never taken.
 func TryFLock(filename string) (*FLock, error) {
-	fd, err := syscall.Open(filename, syscall.O_CREAT|syscall.O_RDONLY|syscall.O_CLOEXEC, 0o600)
+	fd, err := syscall.Open(filename, syscall.O_CREAT|syscall.O_RDWR|syscall.O_CLOEXEC, 0o600)
 	if err != nil {
 		return nil, err
 	}
 	err = syscall.Flock(fd, syscall.LOCK_EX|syscall.LOCK_NB)
 	if err == syscall.EWOULDBLOCK {
 		err = ErrLocked
+		if pid := readLockPID(fd); pid != 0 {
+			err = fmt.Errorf("%w by process %d", ErrLocked, pid)
+		}
 	}
 	if err != nil {
 		_ = syscall.Close(fd)
 
 		return nil, err
 	}
+	lock := &FLock{fd: fd}
+	if pid := readLockPID(fd); pid != os.Getpid() {
+		lock.stalePID = pid
+	}
+	if err := writeLockPID(fd, os.Getpid()); err != nil {
+		_ = syscall.Close(fd)
+
+		return nil, fmt.Errorf("could not record process ID in lock file: %s", err)
+	}
 
-	return &FLock{fd: fd}, nil
+	return lock, nil
+}
+
+// StalePID returns the process that held the lock before it was acquired, if that process exited without
+// releasing it, or 0 otherwise.
+func (lock *FLock) StalePID() int {
+	return lock.stalePID
 }
 
 // Unlock unlocks the file lock.
 func (lock *FLock) Unlock() error {
+	_ = writeLockPID(lock.fd, 0)
+
 	return syscall.Close(lock.fd)
 }
+
+// InspectFLock reports whether a lock file is held, and by which process, without taking the lock.
+// The check briefly takes a shared lock if no other process holds it, so it should not be run in a loop.
+func InspectFLock(filename string) (FLockStatus, error) {
+	status := FLockStatus{Filename: filename}
+	fd, err := syscall.Open(filename, syscall.O_RDONLY|syscall.O_CLOEXEC, 0)
+	if errors.Is(err, syscall.ENOENT) {
+		return status, nil
+	}
+	if err != nil {
+		return status, err
+	}
+	defer syscall.Close(fd)
+	status.PID = readLockPID(fd)
+	err = syscall.Flock(fd, syscall.LOCK_SH|syscall.LOCK_NB)
+	switch {
+	case err == syscall.EWOULDBLOCK:
+		status.Held = true
+	case err != nil:
+		return status, err
+	default:
+		status.Stale = status.PID != 0
+	}
+
+	return status, nil
+}
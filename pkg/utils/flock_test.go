@@ -0,0 +1,108 @@
+//go:build !windows
+// +build !windows
+
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// exitedPID returns the ID of a process that has already exited.
+func exitedPID(t *testing.T) int {
+	cmd := exec.Command("true")
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	return cmd.Process.Pid
+}
+
+func TestFLockStale(t *testing.T) {
+	dir := t.TempDir()
+	socket := filepath.Join(dir, "proxy.sock")
+	lockFilename := socket + ".lock"
+
+	// A lock file left by a process that crashed while holding it
+	deadPID := exitedPID(t)
+	if err := os.WriteFile(lockFilename, []byte(fmt.Sprintf("%d\n", deadPID)), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	status, err := InspectFLock(lockFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Held || !status.Stale || status.PID != deadPID {
+		t.Fatalf("expected a stale lock from process %d, got %+v", deadPID, status)
+	}
+
+	// It is reclaimed when the socket is next listened on
+	li, lock, err := UnixSocketListen(socket, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer li.Close()
+	if lock.StalePID() != deadPID {
+		t.Errorf("expected the lock to be reclaimed from process %d, got %d", deadPID, lock.StalePID())
+	}
+	found := false
+	for _, status := range UnixSocketLocks() {
+		if status.Filename == lockFilename {
+			found = true
+			if !status.Held || status.Stale || status.PID != os.Getpid() {
+				t.Errorf("expected the lock to be held by this process, got %+v", status)
+			}
+		}
+	}
+	if !found {
+		t.Errorf("expected %s in the socket locks", lockFilename)
+	}
+
+	// Releasing it cleanly leaves nothing stale behind
+	if err := lock.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	status, err = InspectFLock(lockFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Held || status.Stale || status.PID != 0 {
+		t.Errorf("expected a free lock, got %+v", status)
+	}
+}
+
+func TestFLockLive(t *testing.T) {
+	lockFilename := filepath.Join(t.TempDir(), "proxy.sock.lock")
+	lock, err := TryFLock(lockFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lock.Unlock()
+	if lock.StalePID() != 0 {
+		t.Errorf("expected a new lock not to be stale, got process %d", lock.StalePID())
+	}
+
+	// A lock held by a live process is not taken, even when the file is rewritten to name a dead one
+	_, err = TryFLock(lockFilename)
+	if !errors.Is(err, ErrLocked) || !strings.Contains(err.Error(), fmt.Sprintf("process %d", os.Getpid())) {
+		t.Fatalf("expected the lock to be held by process %d, got %v", os.Getpid(), err)
+	}
+	if err := os.WriteFile(lockFilename, []byte(fmt.Sprintf("%d\n", exitedPID(t))), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := TryFLock(lockFilename); !errors.Is(err, ErrLocked) {
+		t.Fatalf("expected a held lock to be respected, got %v", err)
+	}
+	status, err := InspectFLock(lockFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !status.Held || status.Stale {
+		t.Errorf("expected the lock to be held, got %+v", status)
+	}
+}
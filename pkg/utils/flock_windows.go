@@ -12,6 +12,14 @@ var ErrLocked = fmt.Errorf("fslock is already locked")
 // FLock represents a Unix file lock, but is not usable on Windows
 type FLock struct{}
 
+// FLockStatus describes a lock file and whether it is held
+type FLockStatus struct {
+	Filename string
+	PID      int
+	Held     bool
+	Stale    bool
+}
+
 // TryFLock is not implemented on Windows
 func TryFLock(filename string) (*FLock, error) {
 	return nil, fmt.Errorf("file locks not implemented on Windows")
@@ -21,3 +29,13 @@ func TryFLock(filename string) (*FLock, error) {
 func (lock *FLock) Unlock() error {
 	return fmt.Errorf("file locks not implemented on Windows")
 }
+
+// StalePID is not implemented on Windows
+func (lock *FLock) StalePID() int {
+	return 0
+}
+
+// InspectFLock is not implemented on Windows
+func InspectFLock(filename string) (FLockStatus, error) {
+	return FLockStatus{Filename: filename}, fmt.Errorf("file locks not implemented on Windows")
+}
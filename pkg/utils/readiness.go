@@ -0,0 +1,91 @@
+package utils
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+
+	"github.com/ansible/receptor/pkg/logger"
+)
+
+// WriteReadinessFile writes the process ID to filename, to tell a supervisor that the process is ready.
+// The file is written under a temporary name and renamed into place, so it is never seen half written.
+func WriteReadinessFile(filename string) error {
+	f, err := ioutil.TempFile(filepath.Dir(filename), filepath.Base(filename)+".*")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(f, "%d\n", os.Getpid())
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(f.Name(), 0o644)
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), filename)
+	}
+	if err != nil {
+		_ = os.Remove(f.Name())
+
+		return err
+	}
+
+	return nil
+}
+
+// RemoveReadinessFile removes a readiness file, such as one left behind by an earlier run, so that it is
+// not mistaken for a sign that this process is ready.  It is not an error if the file does not exist.
+func RemoveReadinessFile(filename string) error {
+	err := os.Remove(filename)
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	return err
+}
+
+// SdNotify sends a state, such as "READY=1", to the systemd service manager over the socket named by
+// NOTIFY_SOCKET.  It returns false, and no error, if NOTIFY_SOCKET is not set because the process was
+// not started by systemd.
+func SdNotify(state string) (bool, error) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return false, nil
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return false, fmt.Errorf("error connecting to systemd notify socket %s: %s", socket, err)
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	if err != nil {
+		return false, fmt.Errorf("error notifying systemd: %s", err)
+	}
+
+	return true, nil
+}
+
+// NotifyReady tells a supervisor that the process is ready, by writing filename if it is not empty and by
+// notifying systemd if systemd is true.
+func NotifyReady(filename string, systemd bool) error {
+	if filename != "" {
+		if err := WriteReadinessFile(filename); err != nil {
+			return fmt.Errorf("error writing readiness file %s: %s", filename, err)
+		}
+	}
+	if systemd {
+		sent, err := SdNotify("READY=1")
+		if err != nil {
+			return err
+		}
+		if !sent {
+			logger.Warning("Not notifying systemd of readiness: NOTIFY_SOCKET is not set\n")
+		}
+	}
+	logger.Info("Node is ready\n")
+
+	return nil
+}
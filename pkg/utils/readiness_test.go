@@ -0,0 +1,81 @@
+//go:build !windows
+// +build !windows
+
+package utils
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReadinessFile(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "ready")
+	if err := RemoveReadinessFile(filename); err != nil {
+		t.Fatalf("expected removing a missing readiness file to succeed, got %s", err)
+	}
+	if err := WriteReadinessFile(filename); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != fmt.Sprintf("%d\n", os.Getpid()) {
+		t.Errorf("expected the readiness file to hold the process ID, got %q", data)
+	}
+	entries, err := os.ReadDir(filepath.Dir(filename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("expected no temporary files to be left behind, got %d entries", len(entries))
+	}
+	if err := RemoveReadinessFile(filename); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filename); !os.IsNotExist(err) {
+		t.Errorf("expected the readiness file to be removed, got %v", err)
+	}
+}
+
+func TestSdNotify(t *testing.T) {
+	defer os.Setenv("NOTIFY_SOCKET", os.Getenv("NOTIFY_SOCKET"))
+
+	// Without NOTIFY_SOCKET there is nothing to notify
+	os.Unsetenv("NOTIFY_SOCKET")
+	sent, err := SdNotify("READY=1")
+	if sent || err != nil {
+		t.Fatalf("expected nothing to be sent without NOTIFY_SOCKET, got %v (%v)", sent, err)
+	}
+
+	socket := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	os.Setenv("NOTIFY_SOCKET", socket)
+	sent, err = SdNotify("READY=1")
+	if !sent || err != nil {
+		t.Fatalf("expected the notification to be sent, got %v (%v)", sent, err)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 64)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf[:n]) != "READY=1" {
+		t.Errorf("expected READY=1, got %q", buf[:n])
+	}
+
+	// A socket that is not there is an error
+	os.Setenv("NOTIFY_SOCKET", filepath.Join(t.TempDir(), "missing.sock"))
+	if _, err := SdNotify("READY=1"); err == nil {
+		t.Error("expected an error notifying a missing socket")
+	}
+}
@@ -0,0 +1,146 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// Retry buffer overflow policies.
+const (
+	// RetryBufferBlock makes writers wait until buffered bytes have been acknowledged.
+	RetryBufferBlock = "block"
+	// RetryBufferRestart fails the write, so the stream is restarted from the last acknowledged offset.
+	RetryBufferRestart = "restart"
+)
+
+// ErrRetryBufferFull is returned by RetryBuffer.Write when the buffer is full and its policy is RetryBufferRestart.
+var ErrRetryBufferFull = errors.New("retry buffer is full")
+
+// ErrRetryBufferClosed is returned by RetryBuffer.Write after the buffer has been closed.
+var ErrRetryBufferClosed = errors.New("retry buffer is closed")
+
+// RetryBuffer holds the bytes of a stream that have been received but not yet acknowledged by the consumer.
+// Bytes are acknowledged in order, so the stream can always be resumed from the acknowledged offset without
+// losing or reordering data.
+type RetryBuffer struct {
+	lock     *sync.Mutex
+	cond     *sync.Cond
+	data     []byte
+	acked    int64
+	limit    int
+	policy   string
+	closed   bool
+	closeErr error
+}
+
+// NewRetryBuffer returns a RetryBuffer for a stream that has already been acknowledged up to offset,
+// holding at most limit unacknowledged bytes.
+func NewRetryBuffer(offset int64, limit int, policy string) (*RetryBuffer, error) {
+	if limit <= 0 {
+		return nil, fmt.Errorf("retry buffer size must be positive")
+	}
+	if policy != RetryBufferBlock && policy != RetryBufferRestart {
+		return nil, fmt.Errorf("unknown retry buffer overflow policy %s: must be %s or %s",
+			policy, RetryBufferBlock, RetryBufferRestart)
+	}
+	rb := &RetryBuffer{
+		lock:   &sync.Mutex{},
+		acked:  offset,
+		limit:  limit,
+		policy: policy,
+	}
+	rb.cond = sync.NewCond(rb.lock)
+
+	return rb, nil
+}
+
+// Write adds bytes to the end of the stream.  If the buffer fills up, Write either waits for bytes to be
+// acknowledged or fails with ErrRetryBufferFull, depending on the overflow policy.
+func (rb *RetryBuffer) Write(p []byte) (int, error) {
+	rb.lock.Lock()
+	defer rb.lock.Unlock()
+	n := 0
+	for len(p) > 0 {
+		if rb.closed {
+			return n, ErrRetryBufferClosed
+		}
+		space := rb.limit - len(rb.data)
+		if space == 0 {
+			if rb.policy == RetryBufferRestart {
+				return n, ErrRetryBufferFull
+			}
+			rb.cond.Wait()
+
+			continue
+		}
+		if space > len(p) {
+			space = len(p)
+		}
+		rb.data = append(rb.data, p[:space]...)
+		p = p[space:]
+		n += space
+		rb.cond.Broadcast()
+	}
+
+	return n, nil
+}
+
+// CloseWrite marks the end of the stream.  Bytes already buffered can still be drained.  The error, if any,
+// is why the stream ended, and is returned by Drain.
+func (rb *RetryBuffer) CloseWrite(err error) {
+	rb.lock.Lock()
+	defer rb.lock.Unlock()
+	if !rb.closed {
+		rb.closed = true
+		rb.closeErr = err
+	}
+	rb.cond.Broadcast()
+}
+
+// Acked returns the offset up to which the stream has been acknowledged.
+func (rb *RetryBuffer) Acked() int64 {
+	rb.lock.Lock()
+	defer rb.lock.Unlock()
+
+	return rb.acked
+}
+
+// Buffered returns the number of bytes that have not been acknowledged yet.
+func (rb *RetryBuffer) Buffered() int {
+	rb.lock.Lock()
+	defer rb.lock.Unlock()
+
+	return len(rb.data)
+}
+
+// Drain passes buffered bytes, in order and along with their offset in the stream, to commit, and
+// acknowledges them once commit succeeds.  It returns once the stream has ended and every byte has been
+// acknowledged, or as soon as commit fails, in which case the failed bytes remain unacknowledged.
+func (rb *RetryBuffer) Drain(commit func(offset int64, p []byte) error) error {
+	for {
+		rb.lock.Lock()
+		for len(rb.data) == 0 && !rb.closed {
+			rb.cond.Wait()
+		}
+		if len(rb.data) == 0 {
+			err := rb.closeErr
+			rb.lock.Unlock()
+
+			return err
+		}
+		data := rb.data
+		offset := rb.acked
+		rb.lock.Unlock()
+		if err := commit(offset, data); err != nil {
+			rb.CloseWrite(err)
+
+			return err
+		}
+		rb.lock.Lock()
+		rb.data = append(rb.data[:0:0], rb.data[len(data):]...)
+		rb.acked += int64(len(data))
+		rb.cond.Broadcast()
+		rb.lock.Unlock()
+	}
+}
@@ -0,0 +1,138 @@
+package utils
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+// interruptedReader fails with a link error after limit bytes.
+type interruptedReader struct {
+	r     io.Reader
+	limit int
+}
+
+var errLinkDown = errors.New("link down")
+
+func (ir *interruptedReader) Read(p []byte) (int, error) {
+	if ir.limit <= 0 {
+		return 0, errLinkDown
+	}
+	if len(p) > ir.limit {
+		p = p[:ir.limit]
+	}
+	n, err := ir.r.Read(p)
+	ir.limit -= n
+
+	return n, err
+}
+
+func TestRetryBufferResumesInterruptedStream(t *testing.T) {
+	source := make([]byte, 256*1024)
+	rand.New(rand.NewSource(1)).Read(source)
+	for _, policy := range []string{RetryBufferBlock, RetryBufferRestart} {
+		t.Run(policy, func(t *testing.T) {
+			var result []byte
+			connections := 0
+			for int64(len(result)) < int64(len(source)) {
+				connections++
+				if connections > 1000 {
+					t.Fatal("stream did not complete")
+				}
+				rb, err := NewRetryBuffer(int64(len(result)), 4096, policy)
+				if err != nil {
+					t.Fatal(err)
+				}
+				// Each connection resumes from the acknowledged offset, and the first few drop mid-stream
+				var link io.Reader = bytes.NewReader(source[rb.Acked():])
+				if connections <= 5 {
+					link = &interruptedReader{r: link, limit: 20000 + connections*777}
+				}
+				go func() {
+					_, err := io.Copy(rb, link)
+					rb.CloseWrite(err)
+				}()
+				err = rb.Drain(func(offset int64, p []byte) error {
+					if offset != int64(len(result)) {
+						return fmt.Errorf("commit at offset %d, expected %d", offset, len(result))
+					}
+					// A slow consumer, so the restart policy overflows
+					time.Sleep(100 * time.Microsecond)
+					result = append(result, p...)
+
+					return nil
+				})
+				if err != nil && !errors.Is(err, errLinkDown) && !errors.Is(err, ErrRetryBufferFull) {
+					t.Fatal(err)
+				}
+				if rb.Acked() != int64(len(result)) || rb.Buffered() != 0 {
+					t.Fatalf("acknowledged %d with %d buffered, but committed %d", rb.Acked(), rb.Buffered(), len(result))
+				}
+			}
+			if connections < 6 {
+				t.Errorf("expected at least 6 connections, got %d", connections)
+			}
+			if !bytes.Equal(result, source) {
+				t.Error("resumed stream does not match the source")
+			}
+		})
+	}
+}
+
+func TestRetryBufferOverflow(t *testing.T) {
+	rb, err := NewRetryBuffer(100, 10, RetryBufferRestart)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := rb.Write([]byte("0123456789abc"))
+	if n != 10 || !errors.Is(err, ErrRetryBufferFull) {
+		t.Errorf("expected 10 bytes and ErrRetryBufferFull, got %d and %v", n, err)
+	}
+
+	// A failed commit leaves the bytes unacknowledged
+	commitErr := errors.New("disk full")
+	err = rb.Drain(func(offset int64, p []byte) error {
+		return commitErr
+	})
+	if !errors.Is(err, commitErr) || rb.Acked() != 100 || rb.Buffered() != 10 {
+		t.Errorf("expected commit error with nothing acknowledged, got %v, acked %d, buffered %d",
+			err, rb.Acked(), rb.Buffered())
+	}
+	if _, err := rb.Write([]byte("x")); !errors.Is(err, ErrRetryBufferClosed) {
+		t.Errorf("expected ErrRetryBufferClosed, got %v", err)
+	}
+
+	rb, err = NewRetryBuffer(0, 4, RetryBufferBlock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	written := make(chan struct{})
+	go func() {
+		_, _ = rb.Write([]byte("abcdefgh"))
+		rb.CloseWrite(nil)
+		close(written)
+	}()
+	select {
+	case <-written:
+		t.Fatal("write did not block on a full buffer")
+	case <-time.After(50 * time.Millisecond):
+	}
+	var result []byte
+	err = rb.Drain(func(offset int64, p []byte) error {
+		result = append(result, p...)
+
+		return nil
+	})
+	<-written
+	if err != nil || string(result) != "abcdefgh" {
+		t.Errorf("expected abcdefgh, got %q and %v", result, err)
+	}
+
+	if _, err := NewRetryBuffer(0, 10, "drop"); err == nil {
+		t.Error("expected an error for an unknown policy")
+	}
+}
@@ -6,14 +6,31 @@ import (
 	"fmt"
 	"net"
 	"os"
+	"sort"
+	"sync"
+
+	"github.com/ansible/receptor/pkg/logger"
+)
+
+var (
+	socketLocksLock sync.Mutex
+	socketLocks     = make(map[string]bool)
 )
 
-// UnixSocketListen listens on a Unix socket, handling file locking and permissions.
+// UnixSocketListen listens on a Unix socket, handling file locking and permissions.  A lock left behind by
+// a process that exited without releasing it is reclaimed, but a lock held by a live process is respected.
 func UnixSocketListen(filename string, permissions os.FileMode) (net.Listener, *FLock, error) {
-	lock, err := TryFLock(filename + ".lock")
+	lockFilename := filename + ".lock"
+	socketLocksLock.Lock()
+	socketLocks[lockFilename] = true
+	socketLocksLock.Unlock()
+	lock, err := TryFLock(lockFilename)
 	if err != nil {
 		return nil, nil, fmt.Errorf("could not acquire lock on socket file: %s", err)
 	}
+	if pid := lock.StalePID(); pid != 0 {
+		logger.Warning("Reclaimed stale lock %s left by process %d\n", lockFilename, pid)
+	}
 	err = os.RemoveAll(filename)
 	if err != nil {
 		_ = lock.Unlock()
@@ -36,3 +53,25 @@ func UnixSocketListen(filename string, permissions os.FileMode) (net.Listener, *
 
 	return uli, lock, nil
 }
+
+// UnixSocketLocks returns the status of the lock files of every Unix socket this process has tried to
+// listen on, sorted by filename.
+func UnixSocketLocks() []FLockStatus {
+	socketLocksLock.Lock()
+	filenames := make([]string, 0, len(socketLocks))
+	for filename := range socketLocks {
+		filenames = append(filenames, filename)
+	}
+	socketLocksLock.Unlock()
+	sort.Strings(filenames)
+	locks := make([]FLockStatus, 0, len(filenames))
+	for _, filename := range filenames {
+		status, err := InspectFLock(filename)
+		if err != nil {
+			logger.Warning("Could not inspect lock file %s: %s\n", filename, err)
+		}
+		locks = append(locks, status)
+	}
+
+	return locks
+}
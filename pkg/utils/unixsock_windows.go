@@ -12,3 +12,8 @@ import (
 func UnixSocketListen(filename string, permissions os.FileMode) (net.Listener, *FLock, error) {
 	return nil, nil, fmt.Errorf("Unix sockets not available on Windows")
 }
+
+// UnixSocketLocks returns nothing on Windows, where Unix sockets are not available
+func UnixSocketLocks() []FLockStatus {
+	return nil
+}
@@ -0,0 +1,340 @@
+//go:build !no_workceptor
+// +build !no_workceptor
+
+package workceptor
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/ansible/receptor/pkg/logger"
+	"github.com/ansible/receptor/pkg/utils"
+	"github.com/ghjm/cmdline"
+)
+
+// agentMaxMessageSize is the longest line an agent may send.
+const agentMaxMessageSize = 16 * 1024 * 1024
+
+// agentUnit implements the WorkUnit interface for work delegated to a local agent over a Unix socket.
+type agentUnit struct {
+	BaseWorkUnit
+	socket string
+	params []string
+}
+
+// agentExtraData is the content of the ExtraData JSON field for an agent worker.
+type agentExtraData struct {
+	Params     map[string]string
+	AgentState string
+}
+
+// agentRequest is the first line sent to the agent, describing the work to do.  It is followed by the
+// unit's stdin, after which the write side of the connection is closed.
+type agentRequest struct {
+	UnitID   string            `json:"unit_id"`
+	WorkType string            `json:"work_type"`
+	Params   map[string]string `json:"params"`
+}
+
+// agentMessage is a line sent by the agent.  Output is appended to the unit's results, and State,
+// if present, updates the unit's state.
+type agentMessage struct {
+	State  string `json:"state"`
+	Detail string `json:"detail"`
+	Output string `json:"output"`
+}
+
+// agentStateToWorkState maps a state reported by an agent to a work unit state.
+func agentStateToWorkState(agentState string) (int, bool) {
+	switch strings.ToLower(agentState) {
+	case "pending", "queued":
+		return WorkStatePending, true
+	case "running", "started":
+		return WorkStateRunning, true
+	case "succeeded", "success", "successful", "completed", "done":
+		return WorkStateSucceeded, true
+	case "failed", "failure", "error", "canceled", "cancelled":
+		return WorkStateFailed, true
+	}
+
+	return 0, false
+}
+
+// SetFromParams sets the in-memory state from parameters.  Only the params declared for the work type are
+// kept, since they are what is forwarded to the agent.
+func (au *agentUnit) SetFromParams(params map[string]string) error {
+	au.statusLock.Lock()
+	defer au.statusLock.Unlock()
+	aed := au.status.ExtraData.(*agentExtraData)
+	aed.Params = make(map[string]string)
+	for _, k := range au.params {
+		if v, ok := params[k]; ok {
+			aed.Params[k] = v
+		}
+	}
+
+	return nil
+}
+
+// Status returns a copy of the status currently loaded in memory.  Secret params are never forwarded to an
+// agent, so there is nothing to redact.
+func (au *agentUnit) Status() *StatusFileData {
+	return au.UnredactedStatus()
+}
+
+// UnredactedStatus returns a copy of the status currently loaded in memory, including secrets.
+func (au *agentUnit) UnredactedStatus() *StatusFileData {
+	au.statusLock.RLock()
+	defer au.statusLock.RUnlock()
+	status := au.getStatus()
+	aed, ok := au.status.ExtraData.(*agentExtraData)
+	if ok {
+		aedCopy := *aed
+		aedCopy.Params = make(map[string]string)
+		for k, v := range aed.Params {
+			aedCopy.Params[k] = v
+		}
+		status.ExtraData = &aedCopy
+	}
+
+	return status
+}
+
+// sendStdin writes the unit's stdin to the agent, and then closes the write side of the connection.
+func (au *agentUnit) sendStdin(conn net.Conn) {
+	stdin, err := os.Open(path.Join(au.UnitDir(), "stdin"))
+	if err == nil {
+		_, err = io.Copy(conn, stdin)
+		stdin.Close()
+	}
+	if err != nil && !os.IsNotExist(err) {
+		logger.Warning("Error sending stdin of %s to agent: %s\n", au.ID(), err)
+	}
+	if uc, ok := conn.(*net.UnixConn); ok {
+		_ = uc.CloseWrite()
+	}
+}
+
+// runAgent submits the work to the agent and follows its progress until the work is complete.
+func (au *agentUnit) runAgent() {
+	fail := func(detail string, stdoutSize int64) {
+		logger.Error("Work unit %s: %s\n", au.ID(), detail)
+		au.UpdateBasicStatus(WorkStateFailed, detail, stdoutSize)
+	}
+	dialer := net.Dialer{}
+	conn, err := dialer.DialContext(au.ctx, "unix", au.socket)
+	if err != nil {
+		fail(fmt.Sprintf("Error connecting to agent: %s", err), 0)
+
+		return
+	}
+	defer conn.Close()
+	go func() {
+		// Unblock the reader if the unit is cancelled
+		<-au.ctx.Done()
+		conn.Close()
+	}()
+	stdout, err := newStdoutWriter(au.UnitDir())
+	if err != nil {
+		fail(fmt.Sprintf("Error opening stdout file: %s", err), 0)
+
+		return
+	}
+	status := au.UnredactedStatus()
+	req := agentRequest{
+		UnitID:   au.ID(),
+		WorkType: status.WorkType,
+		Params:   status.ExtraData.(*agentExtraData).Params,
+	}
+	reqJSON, err := json.Marshal(req)
+	if err == nil {
+		_, err = conn.Write(append(reqJSON, '\n'))
+	}
+	if err != nil {
+		fail(fmt.Sprintf("Error submitting work to agent: %s", err), 0)
+
+		return
+	}
+	go au.sendStdin(conn)
+	au.UpdateBasicStatus(WorkStatePending, "Submitted to agent", 0)
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 0, 64*1024), agentMaxMessageSize)
+	for scanner.Scan() {
+		msg := agentMessage{}
+		err := json.Unmarshal(scanner.Bytes(), &msg)
+		if err != nil {
+			logger.Warning("Work unit %s: ignoring invalid message from agent: %s\n", au.ID(), err)
+
+			continue
+		}
+		if msg.Output != "" {
+			_, err := stdout.Write([]byte(msg.Output))
+			if err != nil {
+				fail(fmt.Sprintf("Error writing stdout file: %s", err), stdout.Size())
+
+				return
+			}
+		}
+		if msg.State == "" {
+			continue
+		}
+		state, ok := agentStateToWorkState(msg.State)
+		if !ok {
+			logger.Warning("Work unit %s: ignoring unknown agent state %s\n", au.ID(), msg.State)
+
+			continue
+		}
+		detail := msg.Detail
+		if detail == "" {
+			detail = fmt.Sprintf("Agent state: %s", msg.State)
+		}
+		au.UpdateFullStatus(func(status *StatusFileData) {
+			status.State = state
+			status.Detail = detail
+			status.StdoutSize = stdout.Size()
+			if aed, ok := status.ExtraData.(*agentExtraData); ok {
+				aed.AgentState = msg.State
+			}
+		})
+		if IsComplete(state) {
+			return
+		}
+	}
+	switch {
+	case au.ctx.Err() != nil:
+		au.UpdateBasicStatus(WorkStateFailed, "Cancelled", stdout.Size())
+	case scanner.Err() != nil:
+		fail(fmt.Sprintf("Error reading from agent: %s", scanner.Err()), stdout.Size())
+	default:
+		fail("Agent closed the connection before the work completed", stdout.Size())
+	}
+}
+
+// Start launches a job with given parameters.
+func (au *agentUnit) Start() error {
+	au.UpdateBasicStatus(WorkStatePending, "Connecting to agent", 0)
+	go au.runAgent()
+
+	return nil
+}
+
+// Restart resumes monitoring a job after a Receptor restart.  The agent connection does not survive
+// a restart, so incomplete work is marked as failed.
+func (au *agentUnit) Restart() error {
+	if err := au.Load(); err != nil {
+		return err
+	}
+	if !IsComplete(au.Status().State) {
+		au.UpdateBasicStatus(WorkStateFailed, "Agent connection lost at restart", stdoutSize(au.UnitDir()))
+	}
+
+	return nil
+}
+
+// Cancel stops a running job, by closing the connection to the agent.
+func (au *agentUnit) Cancel() error {
+	au.cancel()
+
+	return nil
+}
+
+// Release releases resources associated with a job.  Implies Cancel.
+func (au *agentUnit) Release(force bool) error {
+	err := au.Cancel()
+	if err != nil && !force {
+		return err
+	}
+
+	return au.BaseWorkUnit.Release(force)
+}
+
+// validateAgentParams checks the params declared for an agent work type.  Secret params are kept out of
+// the agent's requests, so they cannot be declared.
+func validateAgentParams(params []string) error {
+	for _, p := range params {
+		if isSecretParam(p) {
+			return fmt.Errorf("secret param %s cannot be forwarded to an agent", p)
+		}
+	}
+
+	return nil
+}
+
+func newAgentWorker(socket string, params []string) NewWorkerFunc {
+	return func(w *Workceptor, unitID string, workType string) WorkUnit {
+		au := &agentUnit{
+			BaseWorkUnit: BaseWorkUnit{
+				status: StatusFileData{
+					ExtraData: &agentExtraData{},
+				},
+			},
+			socket: socket,
+			params: params,
+		}
+		au.BaseWorkUnit.Init(w, unitID, workType)
+
+		return au
+	}
+}
+
+// **************************************************************************
+// Command line
+// **************************************************************************
+
+// agentCfg is the cmdline configuration object for a worker that delegates work to a local agent.
+type agentCfg struct {
+	WorkType string   `required:"true" description:"Name for this worker type"`
+	Socket   string   `required:"true" description:"Unix socket of the local agent to delegate work to"`
+	Params   []string `description:"Names of the submission params forwarded to the agent"`
+}
+
+// Prepare checks the declared params.
+func (cfg agentCfg) Prepare() error {
+	return validateAgentParams(cfg.Params)
+}
+
+// Run runs the action.
+func (cfg agentCfg) Run() error {
+	utils.RecordEffectiveConfig("work-agent", cfg)
+	err := MainInstance.RegisterWorker(cfg.WorkType, newAgentWorker(cfg.Socket, cfg.Params))
+	if err != nil {
+		return err
+	}
+
+	return MainInstance.SetWorkTypeParams(cfg.WorkType, cfg.Params)
+}
+
+func init() {
+	cmdline.RegisterConfigTypeForApp("receptor-workers",
+		"work-agent", "Run a worker by delegating to a local agent over a Unix socket", agentCfg{}, cmdline.Section(workersSection))
+}
+
+// Agent delegates work to a local agent over a Unix socket.
+type Agent struct {
+	// Name for this worker type.
+	WorkType string `mapstructure:"work-type"`
+	// Unix socket of the local agent to delegate work to.
+	Socket string `mapstructure:"socket"`
+	// Names of the submission params forwarded to the agent.
+	Params []string `mapstructure:"params"`
+}
+
+func (a Agent) setup(wc *Workceptor) error {
+	if a.Socket == "" {
+		return fmt.Errorf("no agent socket given for work type %s", a.WorkType)
+	}
+	if err := validateAgentParams(a.Params); err != nil {
+		return err
+	}
+	if err := wc.RegisterWorker(a.WorkType, newAgentWorker(a.Socket, a.Params)); err != nil {
+		return err
+	}
+
+	return wc.SetWorkTypeParams(a.WorkType, a.Params)
+}
@@ -0,0 +1,184 @@
+//go:build !no_workceptor
+// +build !no_workceptor
+
+package workceptor
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ansible/receptor/pkg/netceptor"
+)
+
+// fakeAgent accepts one connection, and echoes the params and stdin it was given as results.
+// It finishes by reporting finalState, or by just closing the connection if finalState is empty.
+func fakeAgent(t *testing.T, li net.Listener, finalState string) {
+	conn, err := li.Accept()
+	if err != nil {
+		t.Error(err)
+
+		return
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	line, err := reader.ReadBytes('\n')
+	if err != nil {
+		t.Error(err)
+
+		return
+	}
+	req := agentRequest{}
+	err = json.Unmarshal(line, &req)
+	if err != nil {
+		t.Error(err)
+
+		return
+	}
+	stdin, err := ioutil.ReadAll(reader)
+	if err != nil {
+		t.Error(err)
+
+		return
+	}
+	send := func(msg agentMessage) {
+		data, _ := json.Marshal(msg)
+		_, _ = conn.Write(append(data, '\n'))
+	}
+	send(agentMessage{State: "running"})
+	send(agentMessage{Output: fmt.Sprintf("unit %s type %s\n", req.UnitID, req.WorkType)})
+	send(agentMessage{Output: fmt.Sprintf("params=%v\n", req.Params)})
+	send(agentMessage{Output: string(stdin)})
+	if finalState != "" {
+		send(agentMessage{State: finalState, Detail: "agent is " + finalState})
+	}
+}
+
+func runAgentTestUnit(t *testing.T, finalState string) (WorkUnit, string) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "receptor-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(tmpdir) })
+	socket := path.Join(tmpdir, "agent.sock")
+	li, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer li.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	nc := netceptor.New(ctx, "test", nil)
+	w, err := New(ctx, nc, path.Join(tmpdir, "data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = w.RegisterWorker("agent", newAgentWorker(socket, []string{"name", "size"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	unit, err := w.AllocateUnit("agent", map[string]string{"name": "foo", "other": "bar", "secret_token": "hunter2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(path.Join(unit.UnitDir(), "stdin"), []byte("payload\n"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go fakeAgent(t, li, finalState)
+	err = unit.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	for !IsComplete(unit.Status().State) {
+		if time.Now().After(deadline) {
+			t.Fatalf("unit did not complete: %+v", unit.Status())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	stdout, err := ioutil.ReadFile(unit.StdoutFileName())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return unit, string(stdout)
+}
+
+func TestAgentSucceeded(t *testing.T) {
+	unit, stdout := runAgentTestUnit(t, "succeeded")
+	status := unit.Status()
+	if status.State != WorkStateSucceeded || status.Detail != "agent is succeeded" {
+		t.Fatalf("unexpected status %+v", status)
+	}
+	// Only the declared params are forwarded, never secret ones
+	expected := fmt.Sprintf("unit %s type agent\nparams=map[name:foo]\npayload\n", unit.ID())
+	if stdout != expected {
+		t.Fatalf("expected results %q, got %q", expected, stdout)
+	}
+	if status.StdoutSize != int64(len(expected)) {
+		t.Fatalf("expected stdout size %d, got %d", len(expected), status.StdoutSize)
+	}
+	aed := status.ExtraData.(*agentExtraData)
+	if aed.AgentState != "succeeded" {
+		t.Fatalf("expected agent state succeeded, got %s", aed.AgentState)
+	}
+	if len(aed.Params) != 1 || aed.Params["name"] != "foo" {
+		t.Fatalf("expected only the declared params to be kept, got %v", aed.Params)
+	}
+}
+
+func TestAgentFailed(t *testing.T) {
+	unit, stdout := runAgentTestUnit(t, "error")
+	status := unit.Status()
+	if status.State != WorkStateFailed || status.Detail != "agent is error" {
+		t.Fatalf("unexpected status %+v", status)
+	}
+	if !strings.Contains(stdout, "params=map[name:foo]") {
+		t.Fatalf("expected results streamed before the failure, got %q", stdout)
+	}
+}
+
+func TestAgentClosedEarly(t *testing.T) {
+	unit, _ := runAgentTestUnit(t, "")
+	status := unit.Status()
+	if status.State != WorkStateFailed || !strings.Contains(status.Detail, "closed the connection") {
+		t.Fatalf("unexpected status %+v", status)
+	}
+}
+
+func TestAgentStateMapping(t *testing.T) {
+	tests := map[string]int{
+		"queued":    WorkStatePending,
+		"Running":   WorkStateRunning,
+		"done":      WorkStateSucceeded,
+		"cancelled": WorkStateFailed,
+	}
+	for agentState, expected := range tests {
+		state, ok := agentStateToWorkState(agentState)
+		if !ok || state != expected {
+			t.Errorf("expected agent state %s to map to %s, got %s", agentState,
+				WorkStateToString(expected), WorkStateToString(state))
+		}
+	}
+	if _, ok := agentStateToWorkState("bogus"); ok {
+		t.Error("expected an unknown agent state not to map")
+	}
+}
+
+func TestAgentParamsValidation(t *testing.T) {
+	if err := validateAgentParams([]string{"name", "size"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := validateAgentParams([]string{"name", "secret_token"}); err == nil {
+		t.Fatal("expected an error declaring a secret param")
+	}
+}
@@ -0,0 +1,208 @@
+//go:build !no_workceptor
+// +build !no_workceptor
+
+package workceptor
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/ansible/receptor/pkg/logger"
+)
+
+// validateCollectPatterns checks that all file collection patterns are valid globs.
+func validateCollectPatterns(patterns []string) error {
+	for _, p := range patterns {
+		if _, err := filepath.Match(p, ""); err != nil {
+			return fmt.Errorf("invalid collect-files pattern %s: %s", p, err)
+		}
+	}
+
+	return nil
+}
+
+// lockedWriter serializes writes from several sources to one io.Writer.
+type lockedWriter struct {
+	lock   sync.Mutex
+	writer io.Writer
+}
+
+// Write writes to the underlying writer while holding the lock.
+func (lw *lockedWriter) Write(p []byte) (int, error) {
+	lw.lock.Lock()
+	defer lw.lock.Unlock()
+
+	return lw.writer.Write(p)
+}
+
+// collectedFile is a file being tailed by a fileCollector.
+type collectedFile struct {
+	file    *os.File
+	partial []byte
+}
+
+// fileCollector tails files matching a set of glob patterns, writing each complete line to out
+// prefixed with the name of the file it came from.
+type fileCollector struct {
+	patterns []string
+	out      io.Writer
+	files    map[string]*collectedFile
+}
+
+// newFileCollector creates a fileCollector.  Files that already exist are tailed from their current
+// end, so only content written while the unit runs is collected.
+func newFileCollector(patterns []string, out io.Writer) *fileCollector {
+	fc := &fileCollector{
+		patterns: patterns,
+		out:      out,
+		files:    make(map[string]*collectedFile),
+	}
+	for _, fn := range fc.matches() {
+		f, err := os.Open(fn)
+		if err != nil {
+			continue
+		}
+		if _, err := f.Seek(0, io.SeekEnd); err != nil {
+			_ = f.Close()
+
+			continue
+		}
+		fc.files[fn] = &collectedFile{file: f}
+	}
+
+	return fc
+}
+
+// matches returns the sorted list of files currently matching the patterns.
+func (fc *fileCollector) matches() []string {
+	found := make(map[string]bool)
+	for _, p := range fc.patterns {
+		m, err := filepath.Glob(p)
+		if err != nil {
+			continue
+		}
+		for _, fn := range m {
+			fi, err := os.Stat(fn)
+			if err == nil && fi.Mode().IsRegular() {
+				found[fn] = true
+			}
+		}
+	}
+	names := make([]string, 0, len(found))
+	for fn := range found {
+		names = append(names, fn)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// Poll reads any new content from the collected files.
+func (fc *fileCollector) Poll() {
+	for _, fn := range fc.matches() {
+		cf, ok := fc.files[fn]
+		if !ok {
+			f, err := os.Open(fn)
+			if err != nil {
+				continue
+			}
+			cf = &collectedFile{file: f}
+			fc.files[fn] = cf
+		}
+		fc.checkRotation(fn, cf)
+		fc.readAvailable(fn, cf)
+	}
+}
+
+// checkRotation detects a file being replaced or truncated, and switches to reading the new content.
+func (fc *fileCollector) checkRotation(fn string, cf *collectedFile) {
+	pathInfo, err := os.Stat(fn)
+	if err != nil {
+		return
+	}
+	openInfo, err := cf.file.Stat()
+	if err != nil {
+		return
+	}
+	if !os.SameFile(pathInfo, openInfo) {
+		// The file was rotated by renaming: finish the old file, then start the new one from the beginning
+		fc.readAvailable(fn, cf)
+		fc.flushPartial(fn, cf)
+		f, err := os.Open(fn)
+		if err != nil {
+			return
+		}
+		_ = cf.file.Close()
+		cf.file = f
+
+		return
+	}
+	pos, err := cf.file.Seek(0, io.SeekCurrent)
+	if err == nil && openInfo.Size() < pos {
+		// The file was truncated in place
+		fc.flushPartial(fn, cf)
+		_, _ = cf.file.Seek(0, io.SeekStart)
+	}
+}
+
+// readAvailable reads to the current end of a file, writing out each complete line.
+func (fc *fileCollector) readAvailable(fn string, cf *collectedFile) {
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := cf.file.Read(buf)
+		if n > 0 {
+			cf.partial = append(cf.partial, buf[:n]...)
+			for {
+				idx := bytes.IndexByte(cf.partial, '\n')
+				if idx < 0 {
+					break
+				}
+				fc.writeLine(fn, cf.partial[:idx+1])
+				cf.partial = cf.partial[idx+1:]
+			}
+		}
+		if err != nil {
+			if err != io.EOF {
+				logger.Warning("Error reading collected file %s: %s\n", fn, err)
+			}
+
+			return
+		}
+	}
+}
+
+// flushPartial writes out any incomplete final line of a file.
+func (fc *fileCollector) flushPartial(fn string, cf *collectedFile) {
+	if len(cf.partial) > 0 {
+		fc.writeLine(fn, append(cf.partial, '\n'))
+		cf.partial = nil
+	}
+}
+
+func (fc *fileCollector) writeLine(fn string, line []byte) {
+	_, err := fc.out.Write(append([]byte(fmt.Sprintf("[%s] ", fn)), line...))
+	if err != nil {
+		logger.Warning("Error writing collected output from %s: %s\n", fn, err)
+	}
+}
+
+// Close captures any final content from the collected files and stops tailing them.
+func (fc *fileCollector) Close() {
+	fc.Poll()
+	names := make([]string, 0, len(fc.files))
+	for fn := range fc.files {
+		names = append(names, fn)
+	}
+	sort.Strings(names)
+	for _, fn := range names {
+		cf := fc.files[fn]
+		fc.flushPartial(fn, cf)
+		_ = cf.file.Close()
+	}
+	fc.files = make(map[string]*collectedFile)
+}
@@ -0,0 +1,92 @@
+//go:build !no_workceptor
+// +build !no_workceptor
+
+package workceptor
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFileCollector(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("test requires a POSIX shell")
+	}
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "receptor-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	logfile := path.Join(tmpdir, "app.log")
+	err = ioutil.WriteFile(logfile, []byte("old content\n"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := &bytes.Buffer{}
+	lw := &lockedWriter{writer: out}
+	fc := newFileCollector([]string{path.Join(tmpdir, "*.log")}, lw)
+	cmd := exec.Command("sh", "-c", `
+		echo first >> "$0"
+		sleep 0.3
+		echo second >> "$0"
+		mv "$0" "$0.1"
+		echo third >> "$0"
+		sleep 0.3
+		printf unterminated >> "$0"
+		echo on stdout`, logfile)
+	cmd.Stdout = lw
+	err = cmd.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error)
+	go func() {
+		done <- cmd.Wait()
+	}()
+loop:
+	for {
+		select {
+		case err = <-done:
+			break loop
+		case <-time.After(50 * time.Millisecond):
+			fc.Poll()
+		}
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	fc.Close()
+
+	result := out.String()
+	if strings.Contains(result, "old content") {
+		t.Fatal("content written before the unit started was collected")
+	}
+	for _, expected := range []string{
+		"[" + logfile + "] first\n",
+		"[" + logfile + "] second\n",
+		"[" + logfile + "] third\n",
+		"[" + logfile + "] unterminated\n",
+		"on stdout\n",
+	} {
+		if !strings.Contains(result, expected) {
+			t.Fatalf("expected %q in collected output:\n%s", expected, result)
+		}
+	}
+}
+
+func TestValidateCollectPatterns(t *testing.T) {
+	if err := validateCollectPatterns([]string{"/var/log/*.log", "app.log"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := validateCollectPatterns([]string{"[unclosed"}); err == nil {
+		t.Fatal("expected error for invalid pattern")
+	}
+}
@@ -4,6 +4,7 @@
 package workceptor
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
 	"os/exec"
@@ -14,6 +15,7 @@ import (
 	"time"
 
 	"github.com/ansible/receptor/pkg/logger"
+	"github.com/ansible/receptor/pkg/utils"
 	"github.com/ghjm/cmdline"
 	"github.com/google/shlex"
 )
@@ -24,6 +26,12 @@ type commandUnit struct {
 	command            string
 	baseParams         string
 	allowRuntimeParams bool
+	collectFiles       []string
+	workDir            string
+	runAs              string
+	cpuAffinity        string
+	redact             []string
+	container          *containerSpec
 	done               bool
 }
 
@@ -51,8 +59,64 @@ func cmdWaiter(cmd *exec.Cmd, doneChan chan bool) {
 	doneChan <- true
 }
 
+// newRunnerCmd builds the command for a command runner to run, in workDir and as runAs if given.
+func newRunnerCmd(command string, params string, workDir string, runAs string) (*exec.Cmd, error) {
+	var cmd *exec.Cmd
+	if params == "" {
+		cmd = exec.Command(command)
+	} else {
+		paramList, err := shlex.Split(params)
+		if err != nil {
+			return nil, err
+		}
+		cmd = exec.Command(command, paramList...)
+	}
+	cmd.Dir = workDir
+	err := cmdSetRunAs(cmd, runAs)
+	if err != nil {
+		return nil, err
+	}
+
+	return cmd, nil
+}
+
+// validateWorkDirRunAs checks that a work directory exists and a user to run as can be resolved.
+func validateWorkDirRunAs(workDir string, runAs string) error {
+	if workDir != "" {
+		fi, err := os.Stat(workDir)
+		if err != nil {
+			return fmt.Errorf("invalid work dir: %s", err)
+		}
+		if !fi.IsDir() {
+			return fmt.Errorf("invalid work dir: %s is not a directory", workDir)
+		}
+	}
+	if runAs != "" {
+		_, err := resolveRunAs(runAs)
+		if err != nil {
+			return fmt.Errorf("invalid run-as user: %s", err)
+		}
+	}
+
+	return nil
+}
+
+// validateCPUAffinity checks that a list of CPUs to run a command on is valid and available.
+func validateCPUAffinity(cpuAffinity string) error {
+	if cpuAffinity == "" {
+		return nil
+	}
+	_, err := resolveCPUAffinity(cpuAffinity)
+	if err != nil {
+		return fmt.Errorf("invalid CPU affinity: %s", err)
+	}
+
+	return nil
+}
+
 // commandRunner is run in a separate process, to monitor the subprocess and report back metadata.
-func commandRunner(command string, params string, unitdir string) error {
+func commandRunner(command string, params string, unitdir string, collectFiles []string, workDir string, runAs string,
+	cpuAffinity string, redact []string, container *containerSpec) error {
 	status := StatusFileData{}
 	status.ExtraData = &commandExtraData{}
 	statusFilename := path.Join(unitdir, "status")
@@ -61,14 +125,25 @@ func commandRunner(command string, params string, unitdir string) error {
 		logger.Error("Error updating status file %s: %s", statusFilename, err)
 	}
 	var cmd *exec.Cmd
-	if params == "" {
-		cmd = exec.Command(command)
-	} else {
-		paramList, err := shlex.Split(params)
+	if container != nil {
+		err = status.UpdateBasicStatus(statusFilename, WorkStatePending, fmt.Sprintf("Pulling image %s", container.Image), 0)
+		if err != nil {
+			logger.Error("Error updating status file %s: %s", statusFilename, err)
+		}
+		err = pullContainerImage(container, runAs)
 		if err != nil {
 			return err
 		}
-		cmd = exec.Command(command, paramList...)
+		cmd, err = newContainerCmd(container, command, params, unitdir, workDir, runAs)
+	} else {
+		cmd, err = newRunnerCmd(command, params, workDir, runAs)
+	}
+	if err != nil {
+		return err
+	}
+	redactPatterns, err := compileRedactPatterns(redact)
+	if err != nil {
+		return err
 	}
 	termChan := make(chan os.Signal, 1)
 	signal.Notify(termChan, syscall.SIGINT, syscall.SIGTERM)
@@ -77,13 +152,29 @@ func commandRunner(command string, params string, unitdir string) error {
 		return err
 	}
 	cmd.Stdin = stdin
+	cmd.Env = append(os.Environ(), progressEnv(unitdir))
 	stdout, err := os.OpenFile(path.Join(unitdir, "stdout"), os.O_CREATE+os.O_WRONLY+os.O_SYNC, 0o600)
 	if err != nil {
 		return err
 	}
 	cmd.Stdout = stdout
 	cmd.Stderr = stdout
-	err = cmd.Start()
+	// Secrets are redacted before the output is written, so they never reach the disk
+	var redactor *redactingWriter
+	if len(redactPatterns) > 0 {
+		redactor = newRedactingWriter(stdout, redactPatterns)
+		cmd.Stdout = redactor
+		cmd.Stderr = redactor
+	}
+	var collector *fileCollector
+	if len(collectFiles) > 0 {
+		// Serialize the command's output with collected file content
+		out := &lockedWriter{writer: cmd.Stdout}
+		cmd.Stdout = out
+		cmd.Stderr = out
+		collector = newFileCollector(collectFiles, out)
+	}
+	err = cmdStartWithAffinity(cmd, cpuAffinity)
 	if err != nil {
 		return err
 	}
@@ -93,16 +184,42 @@ loop:
 	for {
 		select {
 		case <-doneChan:
+			if collector != nil {
+				collector.Close()
+			}
+			if redactor != nil {
+				err = redactor.Flush()
+			}
+
 			break loop
 		case <-termChan:
 			termThenKill(cmd)
+			if collector != nil {
+				collector.Close()
+			}
+			if redactor != nil {
+				_ = redactor.Flush()
+			}
+			if container != nil {
+				// Stopping the runtime's client does not always stop the container
+				if err := removeContainer(container.Runtime, unitdir, runAs); err != nil {
+					logger.Error("%s\n", err)
+				}
+			}
 			err = status.UpdateBasicStatus(statusFilename, WorkStateFailed, "Killed", stdoutSize(unitdir))
 			if err != nil {
 				logger.Error("Error updating status file %s: %s", statusFilename, err)
 			}
 			os.Exit(-1)
 		case <-time.After(250 * time.Millisecond):
-			err = status.UpdateBasicStatus(statusFilename, WorkStateRunning, fmt.Sprintf("Running: PID %d", cmd.Process.Pid), stdoutSize(unitdir))
+			if collector != nil {
+				collector.Poll()
+			}
+			detail := fmt.Sprintf("Running: PID %d", cmd.Process.Pid)
+			if container != nil {
+				detail = fmt.Sprintf("Running in container %s: PID %d", containerName(unitdir), cmd.Process.Pid)
+			}
+			err = status.UpdateBasicStatus(statusFilename, WorkStateRunning, detail, stdoutSize(unitdir))
 			if err != nil {
 				logger.Error("Error updating status file %s: %s", statusFilename, err)
 			}
@@ -122,7 +239,11 @@ loop:
 			logger.Error("Error updating status file %s: %s", statusFilename, err)
 		}
 	} else {
-		err = status.UpdateBasicStatus(statusFilename, WorkStateFailed, cmd.ProcessState.String(), stdoutSize(unitdir))
+		detail := cmd.ProcessState.String()
+		if container != nil {
+			detail = containerExitDetail(cmd.ProcessState.ExitCode(), detail)
+		}
+		err = status.UpdateBasicStatus(statusFilename, WorkStateFailed, detail, stdoutSize(unitdir))
 		if err != nil {
 			logger.Error("Error updating status file %s: %s", statusFilename, err)
 		}
@@ -147,6 +268,15 @@ func combineParams(baseParams string, userParams string) string {
 }
 
 // SetFromParams sets the in-memory state from parameters.
+// commandRuntimeParams returns the params a command work type accepts when work is submitted.
+func commandRuntimeParams(allowRuntimeParams bool) []string {
+	if allowRuntimeParams {
+		return []string{"params"}
+	}
+
+	return []string{}
+}
+
 func (cw *commandUnit) SetFromParams(params map[string]string) error {
 	cmdParams, ok := params["params"]
 	if !ok {
@@ -184,6 +314,10 @@ func (cw *commandUnit) runCommand(cmd *exec.Cmd) error {
 	cmdSetDetach(cmd)
 	cw.done = false
 	cmd.Stdout = os.Stdout
+	if logger.StdoutReserved() {
+		// Stdout carries the mesh protocol of a stdio peer
+		cmd.Stdout = os.Stderr
+	}
 	cmd.Stderr = os.Stderr
 	if err := cmd.Start(); err != nil {
 		cw.UpdateBasicStatus(WorkStateFailed, fmt.Sprintf("Failed to start command runner: %s", err), 0)
@@ -215,10 +349,40 @@ func (cw *commandUnit) Start() error {
 	level := logger.GetLogLevel()
 	levelName, _ := logger.LogLevelToName(level)
 	cw.UpdateBasicStatus(WorkStatePending, "Launching command runner", 0)
-	cmd := exec.Command(os.Args[0], "--log-level", levelName, "--command-runner",
+	args := []string{
+		"--log-level", levelName, "--command-runner",
 		fmt.Sprintf("command=%s", cw.command),
 		fmt.Sprintf("params=%s", cw.Status().ExtraData.(*commandExtraData).Params),
-		fmt.Sprintf("unitdir=%s", cw.UnitDir()))
+		fmt.Sprintf("unitdir=%s", cw.UnitDir()),
+	}
+	if len(cw.collectFiles) > 0 {
+		collectJSON, err := json.Marshal(cw.collectFiles)
+		if err != nil {
+			return err
+		}
+		args = append(args, fmt.Sprintf("collectfiles=%s", collectJSON))
+	}
+	if cw.workDir != "" {
+		args = append(args, fmt.Sprintf("workdir=%s", cw.workDir))
+	}
+	if cw.runAs != "" {
+		args = append(args, fmt.Sprintf("runas=%s", cw.runAs))
+	}
+	if cw.cpuAffinity != "" {
+		args = append(args, fmt.Sprintf("cpuaffinity=%s", cw.cpuAffinity))
+	}
+	if len(cw.redact) > 0 {
+		redactJSON, err := json.Marshal(cw.redact)
+		if err != nil {
+			return err
+		}
+		args = append(args, fmt.Sprintf("redact=%s", redactJSON))
+	}
+	if cw.container != nil {
+		args = append(args, fmt.Sprintf("runtime=%s", cw.container.Runtime), fmt.Sprintf("image=%s", cw.container.Image),
+			fmt.Sprintf("pullretries=%d", cw.container.PullRetries))
+	}
+	cmd := exec.Command(os.Args[0], args...)
 
 	return cw.runCommand(cmd)
 }
@@ -269,12 +433,19 @@ func (cw *commandUnit) Cancel() error {
 	return nil
 }
 
-// Release releases resources associated with a job.  Implies Cancel.
+// Release releases resources associated with a job.  Implies Cancel.  The job's container, if it ran in
+// one, is removed.
 func (cw *commandUnit) Release(force bool) error {
 	err := cw.Cancel()
 	if err != nil && !force {
 		return err
 	}
+	if cw.container != nil {
+		err = removeContainer(cw.container.Runtime, cw.UnitDir(), cw.runAs)
+		if err != nil && !force {
+			return err
+		}
+	}
 
 	return cw.BaseWorkUnit.Release(force)
 }
@@ -285,45 +456,102 @@ func (cw *commandUnit) Release(force bool) error {
 
 // commandCfg is the cmdline configuration object for a worker that runs a command.
 type commandCfg struct {
-	WorkType           string `required:"true" description:"Name for this worker type"`
-	Command            string `required:"true" description:"Command to run to process units of work"`
-	Params             string `description:"Command-line parameters"`
-	AllowRuntimeParams bool   `description:"Allow users to add more parameters" default:"false"`
+	WorkType           string   `required:"true" description:"Name for this worker type"`
+	Command            string   `required:"true" description:"Command to run to process units of work"`
+	Params             string   `description:"Command-line parameters"`
+	AllowRuntimeParams bool     `description:"Allow users to add more parameters" default:"false"`
+	CollectFiles       []string `description:"Glob patterns of log files to tail into the unit results"`
+	WorkDir            string   `description:"Directory to run the command in"`
+	RunAs              string   `description:"User to run the command as: user, uid, user:group or uid:gid (not supported on Windows)"`
+	Reassignable       bool     `description:"Reassign units to another node that can run them when this node shuts down" default:"false"`
+	CPUAffinity        string   `description:"CPUs to run the command on, as a list such as 0,2-3 (Linux only)"`
+	ParamSchema        string   `description:"JSON schema file to validate the params of submitted work against"`
+	Redact             []string `description:"Regular expressions whose matches are replaced with [REDACTED] in the unit's output"`
+	Runtime            string   `description:"Container runtime to run the command in: podman or docker"`
+	Image              string   `description:"Container image to run the command in, when a runtime is set"`
+	PullRetries        int      `description:"Times to retry pulling the container image if it fails" default:"3"`
 }
 
 func (cfg commandCfg) newWorker(w *Workceptor, unitID string, workType string) WorkUnit {
-	cw := &commandUnit{
-		BaseWorkUnit: BaseWorkUnit{
-			status: StatusFileData{
-				ExtraData: &commandExtraData{},
-			},
-		},
-		command:            cfg.Command,
-		baseParams:         cfg.Params,
-		allowRuntimeParams: cfg.AllowRuntimeParams,
-	}
-	cw.BaseWorkUnit.Init(w, unitID, workType)
-
-	return cw
+	return Command{
+		WorkType:           cfg.WorkType,
+		Command:            cfg.Command,
+		Params:             cfg.Params,
+		AllowRuntimeParams: cfg.AllowRuntimeParams,
+		CollectFiles:       cfg.CollectFiles,
+		WorkDir:            cfg.WorkDir,
+		RunAs:              cfg.RunAs,
+		CPUAffinity:        cfg.CPUAffinity,
+		Redact:             cfg.Redact,
+		Runtime:            cfg.Runtime,
+		Image:              cfg.Image,
+		PullRetries:        cfg.PullRetries,
+	}.NewWorker(w, unitID, workType)
 }
 
 // Run runs the action.
 func (cfg commandCfg) Run() error {
-	err := MainInstance.RegisterWorker(cfg.WorkType, cfg.newWorker)
+	utils.RecordEffectiveConfig("work-command", cfg)
+	if err := validateCollectPatterns(cfg.CollectFiles); err != nil {
+		return err
+	}
+	if err := validateWorkDirRunAs(cfg.WorkDir, cfg.RunAs); err != nil {
+		return err
+	}
+	if err := validateCPUAffinity(cfg.CPUAffinity); err != nil {
+		return err
+	}
+	if _, err := compileRedactPatterns(cfg.Redact); err != nil {
+		return err
+	}
+	if err := validateContainer(cfg.Runtime, cfg.Image, cfg.PullRetries); err != nil {
+		return err
+	}
+	// The command runner is added through the registry like any other runner, and the main instance, which
+	// already exists by now, picks it up from there.
+	err := addWorkType(cfg.WorkType, cfg.newWorker)
+	if err != nil {
+		return err
+	}
+	err = MainInstance.registerWorkType(cfg.WorkType)
+	if err != nil {
+		return err
+	}
+	err = MainInstance.SetWorkTypeParams(cfg.WorkType, commandRuntimeParams(cfg.AllowRuntimeParams))
+	if err != nil {
+		return err
+	}
+	err = MainInstance.setParamSchemaFile(cfg.WorkType, cfg.ParamSchema)
+	if err != nil {
+		return err
+	}
 
-	return err
+	return MainInstance.SetWorkTypeReassignable(cfg.WorkType, cfg.Reassignable)
 }
 
 // commandRunnerCfg is a hidden command line option for a command runner process.
 type commandRunnerCfg struct {
-	Command string `required:"true"`
-	Params  string `required:"true"`
-	UnitDir string `required:"true"`
+	Command      string `required:"true"`
+	Params       string `required:"true"`
+	UnitDir      string `required:"true"`
+	CollectFiles []string
+	WorkDir      string
+	RunAs        string
+	CPUAffinity  string
+	Redact       []string
+	Runtime      string
+	Image        string
+	PullRetries  int
 }
 
 // Run runs the action.
 func (cfg commandRunnerCfg) Run() error {
-	err := commandRunner(cfg.Command, cfg.Params, cfg.UnitDir)
+	var container *containerSpec
+	if cfg.Runtime != "" {
+		container = &containerSpec{Runtime: cfg.Runtime, Image: cfg.Image, PullRetries: cfg.PullRetries}
+	}
+	err := commandRunner(cfg.Command, cfg.Params, cfg.UnitDir, cfg.CollectFiles, cfg.WorkDir, cfg.RunAs, cfg.CPUAffinity,
+		cfg.Redact, container)
 	if err != nil {
 		statusFilename := path.Join(cfg.UnitDir, "status")
 		err = (&StatusFileData{}).UpdateBasicStatus(statusFilename, WorkStateFailed, err.Error(), stdoutSize(cfg.UnitDir))
@@ -356,20 +584,76 @@ type Command struct {
 	Params string `mapstructure:"parameters"`
 	// Allow users to add more parameters.
 	AllowRuntimeParams bool `mapstructure:"allow-runtime-parameters"`
+	// Glob patterns of log files to tail into the unit results.
+	CollectFiles []string `mapstructure:"collect-files"`
+	// Directory to run the command in.
+	WorkDir string `mapstructure:"work-dir"`
+	// User to run the command as: user, uid, user:group or uid:gid (not supported on Windows).
+	RunAs string `mapstructure:"run-as"`
+	// Reassign units to another node that can run them when this node shuts down.
+	Reassignable bool `mapstructure:"reassignable"`
+	// CPUs to run the command on, as a list such as 0,2-3 (Linux only).
+	CPUAffinity string `mapstructure:"cpu-affinity"`
+	// JSON schema file to validate the params of submitted work against.
+	ParamSchema string `mapstructure:"param-schema"`
+	// Regular expressions whose matches are replaced with [REDACTED] in the unit's output.
+	Redact []string `mapstructure:"redact"`
+	// Container runtime to run the command in: podman or docker.
+	Runtime string `mapstructure:"runtime"`
+	// Container image to run the command in, when a runtime is set.
+	Image string `mapstructure:"image"`
+	// Times to retry pulling the container image if it fails.
+	PullRetries int `mapstructure:"pull-retries"`
 }
 
 func (c Command) setup(wc *Workceptor) error {
-	factory := func(w *Workceptor, unitID string, workType string) WorkUnit {
-		cw := &commandUnit{
-			BaseWorkUnit:       BaseWorkUnit{status: StatusFileData{ExtraData: &commandExtraData{}}},
-			command:            c.Command,
-			baseParams:         c.Params,
-			allowRuntimeParams: c.AllowRuntimeParams,
-		}
-		cw.BaseWorkUnit.Init(w, unitID, workType)
+	if err := validateCollectPatterns(c.CollectFiles); err != nil {
+		return err
+	}
+	if err := validateWorkDirRunAs(c.WorkDir, c.RunAs); err != nil {
+		return err
+	}
+	if err := validateCPUAffinity(c.CPUAffinity); err != nil {
+		return err
+	}
+	if _, err := compileRedactPatterns(c.Redact); err != nil {
+		return err
+	}
+	if err := validateContainer(c.Runtime, c.Image, c.PullRetries); err != nil {
+		return err
+	}
+
+	if err := wc.RegisterWorker(c.WorkType, c.NewWorker); err != nil {
+		return err
+	}
+	if err := wc.SetWorkTypeParams(c.WorkType, commandRuntimeParams(c.AllowRuntimeParams)); err != nil {
+		return err
+	}
+	if err := wc.setParamSchemaFile(c.WorkType, c.ParamSchema); err != nil {
+		return err
+	}
+
+	return wc.SetWorkTypeReassignable(c.WorkType, c.Reassignable)
+}
 
-		return cw
+// NewWorker creates a unit of work that runs the command.  It is the factory of the command runner, so it
+// can be passed to RegisterWorkType to add a command as a work type without configuring it.
+func (c Command) NewWorker(w *Workceptor, unitID string, workType string) WorkUnit {
+	cw := &commandUnit{
+		BaseWorkUnit:       BaseWorkUnit{status: StatusFileData{ExtraData: &commandExtraData{}}},
+		command:            c.Command,
+		baseParams:         c.Params,
+		allowRuntimeParams: c.AllowRuntimeParams,
+		collectFiles:       c.CollectFiles,
+		workDir:            c.WorkDir,
+		runAs:              c.RunAs,
+		cpuAffinity:        c.CPUAffinity,
+		redact:             c.Redact,
+	}
+	if c.Runtime != "" {
+		cw.container = &containerSpec{Runtime: c.Runtime, Image: c.Image, PullRetries: c.PullRetries}
 	}
+	cw.BaseWorkUnit.Init(w, unitID, workType)
 
-	return wc.RegisterWorker(c.WorkType, factory)
+	return cw
 }
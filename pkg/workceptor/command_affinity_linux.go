@@ -0,0 +1,100 @@
+//go:build linux && !no_workceptor
+// +build linux,!no_workceptor
+
+package workceptor
+
+import (
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/ansible/receptor/pkg/logger"
+	"golang.org/x/sys/unix"
+)
+
+// maxCPUs is the number of CPUs a CPU affinity mask can hold, which is the kernel's CPU_SETSIZE.
+const maxCPUs = len(unix.CPUSet{}) * 64
+
+// parseCPUList parses a list of CPUs such as 0,2-3, the format of /sys/devices/system/cpu/online and
+// taskset --cpu-list, into a CPU affinity mask.  CPUs must be below maxCPUs.
+func parseCPUList(cpuList string) (*unix.CPUSet, error) {
+	set := &unix.CPUSet{}
+	for _, item := range strings.Split(cpuList, ",") {
+		item = strings.TrimSpace(item)
+		first := item
+		last := item
+		if i := strings.Index(item, "-"); i >= 0 {
+			first = item[:i]
+			last = item[i+1:]
+		}
+		lo, err := strconv.Atoi(first)
+		if err != nil || lo < 0 {
+			return nil, fmt.Errorf("invalid CPU %q in %q", item, cpuList)
+		}
+		hi, err := strconv.Atoi(last)
+		if err != nil || hi < lo {
+			return nil, fmt.Errorf("invalid CPU range %q in %q", item, cpuList)
+		}
+		if hi >= maxCPUs {
+			return nil, fmt.Errorf("CPU %d in %q is out of range: CPUs must be below %d", hi, cpuList, maxCPUs)
+		}
+		for cpu := lo; cpu <= hi; cpu++ {
+			set.Set(cpu)
+		}
+	}
+
+	return set, nil
+}
+
+// resolveCPUAffinity parses a list of CPUs to run a command on, and checks that this process can run on
+// all of them.
+func resolveCPUAffinity(cpuList string) (*unix.CPUSet, error) {
+	set, err := parseCPUList(cpuList)
+	if err != nil {
+		return nil, err
+	}
+	available := unix.CPUSet{}
+	err = unix.SchedGetaffinity(0, &available)
+	if err != nil {
+		return nil, fmt.Errorf("could not get available CPUs: %s", err)
+	}
+	for cpu := 0; cpu < maxCPUs; cpu++ {
+		if set.IsSet(cpu) && !available.IsSet(cpu) {
+			return nil, fmt.Errorf("CPU %d is not available", cpu)
+		}
+	}
+
+	return set, nil
+}
+
+// cmdStartWithAffinity starts a command that only runs on the given CPUs, if any.  The affinity is set on
+// the thread that forks the command, so the command inherits it before it runs.
+func cmdStartWithAffinity(cmd *exec.Cmd, cpuList string) error {
+	if cpuList == "" {
+		return cmd.Start()
+	}
+	set, err := resolveCPUAffinity(cpuList)
+	if err != nil {
+		return err
+	}
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	saved := unix.CPUSet{}
+	err = unix.SchedGetaffinity(0, &saved)
+	if err != nil {
+		return err
+	}
+	err = unix.SchedSetaffinity(0, set)
+	if err != nil {
+		return fmt.Errorf("could not set CPU affinity: %s", err)
+	}
+	startErr := cmd.Start()
+	err = unix.SchedSetaffinity(0, &saved)
+	if err != nil {
+		logger.Warning("Could not restore CPU affinity: %s\n", err)
+	}
+
+	return startErr
+}
@@ -0,0 +1,106 @@
+//go:build linux && !no_workceptor
+// +build linux,!no_workceptor
+
+package workceptor
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestParseCPUList(t *testing.T) {
+	for _, tc := range []struct {
+		cpuList  string
+		expected []int
+	}{
+		{"0", []int{0}},
+		{"3", []int{3}},
+		{"0,2-3", []int{0, 2, 3}},
+		{" 1 , 5-6 ", []int{1, 5, 6}},
+		{"2-2", []int{2}},
+		{"1023", []int{1023}},
+	} {
+		set, err := parseCPUList(tc.cpuList)
+		if err != nil {
+			t.Fatalf("%q: %s", tc.cpuList, err)
+		}
+		if set.Count() != len(tc.expected) {
+			t.Fatalf("%q: expected %d CPUs, got %d", tc.cpuList, len(tc.expected), set.Count())
+		}
+		for _, cpu := range tc.expected {
+			if !set.IsSet(cpu) {
+				t.Fatalf("%q: expected CPU %d to be set", tc.cpuList, cpu)
+			}
+		}
+	}
+	for _, cpuList := range []string{"", "a", "1,", "-1", "3-1", "1-", "0-x", "1024", "0-1024", "0-2147483647",
+		"99999999999999999999"} {
+		if _, err := parseCPUList(cpuList); err == nil {
+			t.Errorf("expected %q to be invalid", cpuList)
+		}
+	}
+}
+
+// availableCPUs returns the CPUs this process can run on.
+func availableCPUs(t *testing.T) []int {
+	set := unix.CPUSet{}
+	if err := unix.SchedGetaffinity(0, &set); err != nil {
+		t.Fatal(err)
+	}
+	var cpus []int
+	for cpu := 0; cpu < len(set)*64; cpu++ {
+		if set.IsSet(cpu) {
+			cpus = append(cpus, cpu)
+		}
+	}
+
+	return cpus
+}
+
+func TestValidateCPUAffinity(t *testing.T) {
+	cpus := availableCPUs(t)
+	if err := validateCPUAffinity(strconv.Itoa(cpus[0])); err != nil {
+		t.Fatal(err)
+	}
+	if err := validateCPUAffinity(""); err != nil {
+		t.Fatal(err)
+	}
+	unavailable := cpus[len(cpus)-1] + 1
+	if err := validateCPUAffinity(strconv.Itoa(unavailable)); err == nil {
+		t.Fatalf("expected CPU %d to be unavailable", unavailable)
+	}
+}
+
+func TestCmdStartWithAffinity(t *testing.T) {
+	cpus := availableCPUs(t)
+	cpu := cpus[len(cpus)-1]
+	cmd, err := newRunnerCmd("sh", "-c \"while :; do :; done\"", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = cmdStartWithAffinity(cmd, strconv.Itoa(cpu))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+	}()
+	// Give the command time to get going, in case it could move off its CPU
+	time.Sleep(100 * time.Millisecond)
+	set := unix.CPUSet{}
+	err = unix.SchedGetaffinity(cmd.Process.Pid, &set)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if set.Count() != 1 || !set.IsSet(cpu) {
+		t.Fatalf("expected the command to be bound to CPU %d, got %v", cpu, set)
+	}
+	// The calling thread's own affinity is restored
+	if len(availableCPUs(t)) != len(cpus) {
+		t.Fatal("CPU affinity of this process was not restored")
+	}
+}
@@ -0,0 +1,25 @@
+//go:build !linux && !no_workceptor
+// +build !linux,!no_workceptor
+
+package workceptor
+
+import (
+	"fmt"
+	"os/exec"
+)
+
+// resolveCPUAffinity reports that CPU affinity is only supported on Linux.
+func resolveCPUAffinity(cpuList string) (interface{}, error) {
+	return nil, fmt.Errorf("CPU affinity is only supported on Linux")
+}
+
+// cmdStartWithAffinity starts a command that only runs on the given CPUs, which is only supported on Linux.
+func cmdStartWithAffinity(cmd *exec.Cmd, cpuList string) error {
+	if cpuList != "" {
+		_, err := resolveCPUAffinity(cpuList)
+
+		return err
+	}
+
+	return cmd.Start()
+}
@@ -0,0 +1,94 @@
+//go:build !windows && !no_workceptor
+// +build !windows,!no_workceptor
+
+package workceptor
+
+import (
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestRunnerCmdWorkDirRunAs(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "receptor-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	err = os.Chmod(tmpdir, 0o755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	workDir, err := filepath.EvalSymlinks(tmpdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Without privileges, we can only run as ourselves
+	runAs := strconv.Itoa(os.Geteuid())
+	expectUID := os.Geteuid()
+	if os.Geteuid() == 0 {
+		nobody, err := user.Lookup("nobody")
+		if err == nil {
+			runAs = nobody.Username
+			expectUID, _ = strconv.Atoi(nobody.Uid)
+		}
+	}
+	err = validateWorkDirRunAs(workDir, runAs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd, err := newRunnerCmd("sh", "-c \"pwd; id -u\"", workDir, runAs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("unexpected output %q", out)
+	}
+	if lines[0] != workDir {
+		t.Errorf("command ran in %s, expected %s", lines[0], workDir)
+	}
+	if lines[1] != strconv.Itoa(expectUID) {
+		t.Errorf("command ran as uid %s, expected %d", lines[1], expectUID)
+	}
+}
+
+func TestValidateWorkDirRunAs(t *testing.T) {
+	tmpfile, err := ioutil.TempFile(os.TempDir(), "receptor-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpfile.Close()
+	defer os.Remove(tmpfile.Name())
+	tests := []struct {
+		name    string
+		workDir string
+		runAs   string
+		valid   bool
+	}{
+		{"defaults", "", "", true},
+		{"existing dir", os.TempDir(), "", true},
+		{"missing dir", filepath.Join(os.TempDir(), "receptor-does-not-exist"), "", false},
+		{"file as dir", tmpfile.Name(), "", false},
+		{"numeric uid", "", "12345:12345", true},
+		{"unknown user", "", "receptor-no-such-user", false},
+		{"unknown group", "", "0:receptor-no-such-group", false},
+		{"missing user", "", ":0", false},
+	}
+	for _, tc := range tests {
+		err := validateWorkDirRunAs(tc.workDir, tc.runAs)
+		if tc.valid && err != nil {
+			t.Errorf("%s: unexpected error %s", tc.name, err)
+		} else if !tc.valid && err == nil {
+			t.Errorf("%s: expected an error", tc.name)
+		}
+	}
+}
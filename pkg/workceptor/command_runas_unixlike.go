@@ -0,0 +1,108 @@
+//go:build !windows && !no_workceptor
+// +build !windows,!no_workceptor
+
+package workceptor
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// lookupID resolves a user or group given by name or numeric ID.  Numeric IDs are accepted
+// even if they have no name on this system.
+func lookupID(name string, byName func(string) (string, error)) (uint32, error) {
+	idStr := name
+	if _, err := strconv.ParseUint(name, 10, 32); err != nil {
+		idStr, err = byName(name)
+		if err != nil {
+			return 0, err
+		}
+	}
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		return 0, err
+	}
+
+	return uint32(id), nil
+}
+
+// resolveRunAs parses a user to run a command as, given as user, uid, user:group or uid:gid.
+// If no group is given, the user's primary group is used.
+func resolveRunAs(runAs string) (*syscall.Credential, error) {
+	userName := runAs
+	groupName := ""
+	if i := strings.Index(runAs, ":"); i >= 0 {
+		userName = runAs[:i]
+		groupName = runAs[i+1:]
+	}
+	if userName == "" {
+		return nil, fmt.Errorf("no user given in %q", runAs)
+	}
+	uid, err := lookupID(userName,
+		func(n string) (string, error) {
+			u, err := user.Lookup(n)
+			if err != nil {
+				return "", err
+			}
+
+			return u.Uid, nil
+		})
+	if err != nil {
+		return nil, fmt.Errorf("could not resolve user %s: %s", userName, err)
+	}
+	var gid uint32
+	if groupName == "" {
+		u, err := user.LookupId(strconv.FormatUint(uint64(uid), 10))
+		if err != nil {
+			return nil, fmt.Errorf("no group given, and could not find the primary group of user %s: %s", userName, err)
+		}
+		g, err := strconv.ParseUint(u.Gid, 10, 32)
+		if err != nil {
+			return nil, err
+		}
+		gid = uint32(g)
+	} else {
+		gid, err = lookupID(groupName,
+			func(n string) (string, error) {
+				g, err := user.LookupGroup(n)
+				if err != nil {
+					return "", err
+				}
+
+				return g.Gid, nil
+			})
+		if err != nil {
+			return nil, fmt.Errorf("could not resolve group %s: %s", groupName, err)
+		}
+	}
+
+	return &syscall.Credential{
+		Uid: uid,
+		Gid: gid,
+		// Supplementary groups can only be changed with privileges, and don't need to be when
+		// running as ourselves
+		NoSetGroups: int(uid) == os.Geteuid(),
+	}, nil
+}
+
+// cmdSetRunAs makes a command run as another user.
+func cmdSetRunAs(cmd *exec.Cmd, runAs string) error {
+	if runAs == "" {
+		return nil
+	}
+	cred, err := resolveRunAs(runAs)
+	if err != nil {
+		return err
+	}
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Credential = cred
+
+	return nil
+}
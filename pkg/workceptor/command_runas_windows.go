@@ -0,0 +1,24 @@
+//go:build windows && !no_workceptor
+// +build windows,!no_workceptor
+
+package workceptor
+
+import (
+	"fmt"
+	"os/exec"
+)
+
+// resolveRunAs reports that running commands as another user is not supported on Windows.
+func resolveRunAs(runAs string) (interface{}, error) {
+	return nil, fmt.Errorf("running commands as another user is not supported on Windows")
+}
+
+// cmdSetRunAs makes a command run as another user, which is not supported on Windows.
+func cmdSetRunAs(cmd *exec.Cmd, runAs string) error {
+	if runAs == "" {
+		return nil
+	}
+	_, err := resolveRunAs(runAs)
+
+	return err
+}
@@ -0,0 +1,175 @@
+//go:build !no_workceptor
+// +build !no_workceptor
+
+package workceptor
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ansible/receptor/pkg/logger"
+	"github.com/google/shlex"
+)
+
+// containerRuntimes are the container runtimes a command can be run in.
+var containerRuntimes = []string{"podman", "docker"}
+
+// containerInputPath is where the unit's stdin is mounted, read-only, inside the container.
+const containerInputPath = "/receptor/stdin"
+
+// containerProgressPath is where the unit's progress file is mounted inside the container.
+const containerProgressPath = "/receptor/progress"
+
+// containerPullRetryDelay is how long to wait before retrying a failed image pull.  Each further retry
+// waits twice as long as the one before.
+var containerPullRetryDelay = 5 * time.Second
+
+// containerSpec is the container a command runner runs its command in.
+type containerSpec struct {
+	Runtime     string
+	Image       string
+	PullRetries int
+}
+
+// validateContainer checks that a container runtime and image are given together, and that the runtime
+// is a supported one that can be found.
+func validateContainer(runtime string, image string, pullRetries int) error {
+	if runtime == "" {
+		if image != "" {
+			return fmt.Errorf("container image %s requires a runtime", image)
+		}
+
+		return nil
+	}
+	if image == "" {
+		return fmt.Errorf("container runtime %s requires an image", runtime)
+	}
+	supported := false
+	for _, r := range containerRuntimes {
+		if filepath.Base(runtime) == r {
+			supported = true
+		}
+	}
+	if !supported {
+		return fmt.Errorf("unsupported container runtime %s: must be %s", runtime, strings.Join(containerRuntimes, " or "))
+	}
+	if _, err := exec.LookPath(runtime); err != nil {
+		return fmt.Errorf("invalid container runtime: %s", err)
+	}
+	if pullRetries < 0 {
+		return fmt.Errorf("pull retries must not be negative")
+	}
+
+	return nil
+}
+
+// containerName returns the name of the container that runs the unit in unitdir.
+func containerName(unitdir string) string {
+	return "receptor-" + path.Base(unitdir)
+}
+
+// newRuntimeCmd builds a command that runs the container runtime as runAs, if given.  Rootless runtimes
+// keep their images and containers per user, so every runtime command for a unit runs as the same user.
+func newRuntimeCmd(runtime string, runAs string, args ...string) (*exec.Cmd, error) {
+	cmd := exec.Command(runtime, args...)
+	if err := cmdSetRunAs(cmd, runAs); err != nil {
+		return nil, err
+	}
+
+	return cmd, nil
+}
+
+// pullContainerImage pulls the image, retrying up to retries more times if the pull fails.
+func pullContainerImage(spec *containerSpec, runAs string) error {
+	delay := containerPullRetryDelay
+	for attempt := 0; ; attempt++ {
+		cmd, err := newRuntimeCmd(spec.Runtime, runAs, "pull", spec.Image)
+		if err != nil {
+			return err
+		}
+		out, err := cmd.CombinedOutput()
+		if err == nil {
+			return nil
+		}
+		err = fmt.Errorf("could not pull image %s: %s: %s", spec.Image, err, strings.TrimSpace(string(out)))
+		if attempt >= spec.PullRetries {
+			return err
+		}
+		logger.Warning("%s. Retrying in %s.\n", err, delay)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// newContainerCmd builds the command that runs a unit's command in a container.  The runtime's own stdin and
+// output are the container's, so the command runner treats it like any other command, and the runtime exits
+// with the command's exit code.  The unit's stdin and progress file are mounted into the container, and the
+// container is kept after it exits, until the unit is released.
+func newContainerCmd(spec *containerSpec, command string, params string, unitdir string, workDir string,
+	runAs string) (*exec.Cmd, error) {
+	// The progress file must exist to be mounted
+	progressFile, err := os.OpenFile(path.Join(unitdir, progressFileName), os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	_ = progressFile.Close()
+	args := []string{
+		"run", "-i", "--name", containerName(unitdir),
+		"-v", fmt.Sprintf("%s:%s:ro", path.Join(unitdir, "stdin"), containerInputPath),
+		"-v", fmt.Sprintf("%s:%s", path.Join(unitdir, progressFileName), containerProgressPath),
+		"-e", fmt.Sprintf("%s=%s", ProgressFileEnv, containerProgressPath),
+		spec.Image, command,
+	}
+	if params != "" {
+		paramList, err := shlex.Split(params)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, paramList...)
+	}
+	cmd, err := newRuntimeCmd(spec.Runtime, runAs, args...)
+	if err != nil {
+		return nil, err
+	}
+	cmd.Dir = workDir
+
+	return cmd, nil
+}
+
+// containerExitDetail describes why a container run failed.  Podman and docker exit with 125 when the
+// runtime itself fails, and with 126 or 127 when the command cannot be run in the container, as opposed to
+// the command failing.
+func containerExitDetail(exitCode int, detail string) string {
+	switch exitCode {
+	case 125:
+		return fmt.Sprintf("Container runtime error: %s", detail)
+	case 126:
+		return fmt.Sprintf("Command could not be run in the container: %s", detail)
+	case 127:
+		return fmt.Sprintf("Command not found in the container: %s", detail)
+	default:
+		return detail
+	}
+}
+
+// removeContainer forcibly removes the container of the unit in unitdir, stopping it if it is still running.
+// It is not an error if the container does not exist.
+func removeContainer(runtime string, unitdir string, runAs string) error {
+	cmd, err := newRuntimeCmd(runtime, runAs, "rm", "-f", containerName(unitdir))
+	if err != nil {
+		return err
+	}
+	out, err := cmd.CombinedOutput()
+	if err != nil && !bytes.Contains(bytes.ToLower(out), []byte("no such container")) {
+		return fmt.Errorf("could not remove container %s: %s: %s", containerName(unitdir), err,
+			strings.TrimSpace(string(out)))
+	}
+
+	return nil
+}
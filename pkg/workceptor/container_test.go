@@ -0,0 +1,214 @@
+//go:build !no_workceptor
+// +build !no_workceptor
+
+package workceptor
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeRuntime is a stand-in for podman that logs each invocation, fails its first pulls, and runs the
+// command it is given on the host, reading the files mounted into the "container" from their host paths.
+const fakeRuntime = `#!/bin/sh
+echo "$*" >> "$FAKE_RUNTIME_DIR/calls"
+case "$1" in
+pull)
+	count=$(cat "$FAKE_RUNTIME_DIR/pulls" 2>/dev/null || echo 0)
+	echo $((count + 1)) > "$FAKE_RUNTIME_DIR/pulls"
+	if [ "$count" -lt "$FAKE_RUNTIME_PULL_FAILURES" ]; then
+		echo "registry unavailable"
+		exit 1
+	fi
+	;;
+run)
+	shift
+	while [ "$1" != "$FAKE_RUNTIME_IMAGE" ]; do
+		if [ "$1" = "-v" ]; then
+			mount="$2"
+			case "$mount" in
+			*:/receptor/progress) export RECEPTOR_PROGRESS_FILE="${mount%%:*}" ;;
+			esac
+		fi
+		shift
+	done
+	shift
+	exec "$@"
+	;;
+rm)
+	if [ -e "$FAKE_RUNTIME_DIR/removed" ]; then
+		echo "Error: no such container $3"
+		exit 1
+	fi
+	touch "$FAKE_RUNTIME_DIR/removed"
+	;;
+esac
+`
+
+func TestValidateContainer(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("test requires a POSIX shell")
+	}
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "receptor-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	podman := path.Join(tmpdir, "podman")
+	if err := ioutil.WriteFile(podman, []byte(fakeRuntime), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	good := []struct {
+		runtime string
+		image   string
+	}{
+		{"", ""},
+		{podman, "quay.io/example/worker:latest"},
+	}
+	for _, c := range good {
+		if err := validateContainer(c.runtime, c.image, 3); err != nil {
+			t.Fatalf("expected runtime %q with image %q to be valid, got %s", c.runtime, c.image, err)
+		}
+	}
+	bad := []struct {
+		runtime     string
+		image       string
+		pullRetries int
+	}{
+		{"", "quay.io/example/worker:latest", 3},
+		{podman, "", 3},
+		{"/bin/sh", "quay.io/example/worker:latest", 3},
+		{path.Join(tmpdir, "docker"), "quay.io/example/worker:latest", 3},
+		{podman, "quay.io/example/worker:latest", -1},
+	}
+	for _, c := range bad {
+		if err := validateContainer(c.runtime, c.image, c.pullRetries); err == nil {
+			t.Fatalf("expected runtime %q with image %q and %d pull retries to be refused", c.runtime, c.image, c.pullRetries)
+		}
+	}
+}
+
+func TestContainerLifecycle(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("test requires a POSIX shell")
+	}
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "receptor-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	podman := path.Join(tmpdir, "podman")
+	if err := ioutil.WriteFile(podman, []byte(fakeRuntime), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	unitdir := path.Join(tmpdir, "unit1")
+	if err := os.Mkdir(unitdir, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path.Join(unitdir, "stdin"), []byte("hello\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	image := "quay.io/example/worker:latest"
+	os.Setenv("FAKE_RUNTIME_DIR", tmpdir)
+	os.Setenv("FAKE_RUNTIME_IMAGE", image)
+	os.Setenv("FAKE_RUNTIME_PULL_FAILURES", "2")
+	defer os.Unsetenv("FAKE_RUNTIME_DIR")
+	defer os.Unsetenv("FAKE_RUNTIME_IMAGE")
+	defer os.Unsetenv("FAKE_RUNTIME_PULL_FAILURES")
+	oldDelay := containerPullRetryDelay
+	containerPullRetryDelay = time.Millisecond
+	defer func() {
+		containerPullRetryDelay = oldDelay
+	}()
+
+	// The pull fails twice, so it only succeeds with at least two retries
+	if err := pullContainerImage(&containerSpec{Runtime: podman, Image: image, PullRetries: 1}, ""); err == nil ||
+		!strings.Contains(err.Error(), "registry unavailable") {
+		t.Fatalf("expected the pull to fail with the runtime's output, got %v", err)
+	}
+	_ = os.Remove(path.Join(tmpdir, "pulls"))
+	spec := &containerSpec{Runtime: podman, Image: image, PullRetries: 2}
+	if err := pullContainerImage(spec, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd, err := newContainerCmd(spec, "sh", `-c "tr a-z A-Z; echo '{\"percent\": 50}' >> $RECEPTOR_PROGRESS_FILE"`,
+		unitdir, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdin, err := os.Open(path.Join(unitdir, "stdin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stdin.Close()
+	out := &bytes.Buffer{}
+	cmd.Stdin = stdin
+	cmd.Stdout = out
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "HELLO\n" {
+		t.Fatalf("expected the container's output, got %q", out.String())
+	}
+	progress, err := readProgress(unitdir)
+	if err != nil || progress == nil || progress.Percent != 50 {
+		t.Fatalf("expected progress reported from the container, got %+v (%v)", progress, err)
+	}
+
+	if err := removeContainer(podman, unitdir, ""); err != nil {
+		t.Fatal(err)
+	}
+	// Removing a container that is already gone is not an error
+	if err := removeContainer(podman, unitdir, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	calls, err := ioutil.ReadFile(path.Join(tmpdir, "calls"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(calls)), "\n")
+	if len(lines) != 8 {
+		t.Fatalf("expected 5 pulls, a run and 2 removals, got %q", lines)
+	}
+	for _, l := range lines[:5] {
+		if l != "pull "+image {
+			t.Fatalf("expected a pull of %s, got %q", image, l)
+		}
+	}
+	for _, arg := range []string{
+		"--name receptor-unit1",
+		path.Join(unitdir, "stdin") + ":/receptor/stdin:ro",
+		"-e RECEPTOR_PROGRESS_FILE=/receptor/progress",
+		image + " sh -c",
+	} {
+		if !strings.HasPrefix(lines[5], "run -i ") || !strings.Contains(lines[5], arg) {
+			t.Fatalf("expected the run to include %q, got %q", arg, lines[5])
+		}
+	}
+	for _, l := range lines[6:] {
+		if l != "rm -f receptor-unit1" {
+			t.Fatalf("expected the container to be removed, got %q", l)
+		}
+	}
+}
+
+func TestContainerExitDetail(t *testing.T) {
+	for code, prefix := range map[int]string{
+		1:   "exit status",
+		125: "Container runtime error",
+		126: "Command could not be run",
+		127: "Command not found",
+	} {
+		if detail := containerExitDetail(code, "exit status"); !strings.HasPrefix(detail, prefix) {
+			t.Fatalf("expected exit code %d to be described as %q, got %q", code, prefix, detail)
+		}
+	}
+}
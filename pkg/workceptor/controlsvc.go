@@ -5,13 +5,19 @@ package workceptor
 
 import (
 	"fmt"
+	"io/ioutil"
 	"os"
 	"path"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/ansible/receptor/pkg/controlsvc"
 	"github.com/ansible/receptor/pkg/netceptor"
+	"github.com/ansible/receptor/pkg/telemetry"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type workceptorCommandType struct {
@@ -45,13 +51,44 @@ func (t *workceptorCommandType) InitFromString(params string) (controlsvc.Contro
 			c.params["params"] = strings.Join(tokens[3:], " ")
 		}
 	case "list":
+		labels := make([]string, 0)
+		for i := 1; i < len(tokens); i++ {
+			switch {
+			case tokens[i] == "--label":
+				if i+1 == len(tokens) {
+					return nil, fmt.Errorf("--label requires a key=value label")
+				}
+				i++
+				labels = append(labels, tokens[i])
+			case c.params["unitid"] == nil:
+				c.params["unitid"] = tokens[i]
+			default:
+				return nil, fmt.Errorf("work list only takes an optional unit ID and --label options")
+			}
+		}
+		if len(labels) > 0 {
+			c.params["labels"] = strings.Join(labels, ",")
+		}
+	case "types":
+		if len(tokens) > 2 {
+			return nil, fmt.Errorf("work types only takes an optional node ID")
+		}
 		if len(tokens) > 1 {
-			c.params["unitid"] = tokens[1]
+			c.params["node"] = tokens[1]
+		}
+	case "drain", "undrain":
+		if len(tokens) != 2 {
+			return nil, fmt.Errorf("work %s requires a work type", c.subcommand)
 		}
-	case "status", "cancel", "release", "force-release":
+		c.params["worktype"] = tokens[1]
+	case "status", "info", "poll", "cancel", "release", "force-release":
 		if len(tokens) < 2 {
 			return nil, fmt.Errorf("work %s requires a unit ID", c.subcommand)
 		}
+		if c.subcommand == "release" && len(tokens) == 3 && tokens[2] == "--force" {
+			c.params["force"] = true
+			tokens = tokens[:2]
+		}
 		if len(tokens) > 2 {
 			return nil, fmt.Errorf("work %s does not take parameters after the unit ID", c.subcommand)
 		}
@@ -60,8 +97,8 @@ func (t *workceptorCommandType) InitFromString(params string) (controlsvc.Contro
 		if len(tokens) < 2 {
 			return nil, fmt.Errorf("work results requires a unit ID")
 		}
-		if len(tokens) > 3 {
-			return nil, fmt.Errorf("work results only takes a unit ID and optional start position")
+		if len(tokens) > 4 {
+			return nil, fmt.Errorf("work results only takes a unit ID and optional start position and length")
 		}
 		c.params["unitid"] = tokens[1]
 		if len(tokens) > 2 {
@@ -73,6 +110,13 @@ func (t *workceptorCommandType) InitFromString(params string) (controlsvc.Contro
 		} else {
 			c.params["startpos"] = int64(0)
 		}
+		if len(tokens) > 3 {
+			var err error
+			c.params["length"], err = strconv.ParseInt(tokens[3], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("error converting length to integer: %s", err)
+			}
+		}
 	}
 
 	return c, nil
@@ -144,16 +188,33 @@ func (t *workceptorCommandType) InitFromJSON(config map[string]interface{}) (con
 		if err != nil {
 			return nil, err
 		}
-	case "status", "cancel", "release", "force-release":
+	case "status", "info", "poll", "cancel", "release", "force-release":
 		c.params["unitid"], err = strFromMap(config, "unitid")
 		if err != nil {
 			return nil, err
 		}
+		if force, ok := config["force"].(bool); ok && force && c.subcommand == "release" {
+			c.params["force"] = true
+		}
+	case "drain", "undrain":
+		c.params["worktype"], err = strFromMap(config, "worktype")
+		if err != nil {
+			return nil, err
+		}
 	case "list":
 		unitID, err := strFromMap(config, "unitid")
 		if err == nil {
 			c.params["unitid"] = unitID
 		}
+		labels, err := strFromMap(config, "labels")
+		if err == nil {
+			c.params["labels"] = labels
+		}
+	case "types":
+		node, err := strFromMap(config, "node")
+		if err == nil {
+			c.params["node"] = node
+		}
 	case "results":
 		c.params["unitid"], err = strFromMap(config, "unitid")
 		if err != nil {
@@ -163,11 +224,28 @@ func (t *workceptorCommandType) InitFromJSON(config map[string]interface{}) (con
 		if err != nil {
 			return nil, err
 		}
+		if _, ok := config["length"]; ok {
+			c.params["length"], err = intFromMap(config, "length")
+			if err != nil {
+				return nil, err
+			}
+		}
 	}
 
 	return c, nil
 }
 
+// ReadOnly reports whether the command only looks at work units, rather than submitting or changing them.
+// Poll is not read-only, since it saves the status it fetches and can mark a unit failed.
+func (c *workceptorCommand) ReadOnly() bool {
+	switch c.subcommand {
+	case "list", "types", "status", "info", "results":
+		return true
+	}
+
+	return false
+}
+
 // Worker function called by the control service to process a "work" command.
 func (c *workceptorCommand) ControlFunc(nc *netceptor.Netceptor, cfo controlsvc.ControlFuncOperations) (map[string]interface{}, error) {
 	switch c.subcommand {
@@ -188,9 +266,38 @@ func (c *workceptorCommand) ControlFunc(nc *netceptor.Netceptor, cfo controlsvc.
 		if err != nil {
 			ttl = ""
 		}
+		idempotencyKey, err := strFromMap(c.params, "idempotencykey")
+		if err != nil {
+			idempotencyKey = ""
+		}
+		webhookURL, err := strFromMap(c.params, "webhook")
+		if err != nil {
+			webhookURL = ""
+		}
+		webhookSecret, err := strFromMap(c.params, "webhooksecret")
+		if err != nil {
+			webhookSecret = ""
+		}
+		if webhookURL != "" {
+			err = validateWebhookURL(webhookURL)
+			if err != nil {
+				return nil, err
+			}
+		} else if webhookSecret != "" {
+			return nil, fmt.Errorf("webhook secret given without a webhook")
+		}
+		var labels map[string]string
+		if labelsStr, err := strFromMap(c.params, "labels"); err == nil {
+			labels, err = ParseLabels(labelsStr)
+			if err != nil {
+				return nil, err
+			}
+		}
 		workParams := make(map[string]string)
 		for k, v := range c.params {
-			if k == "command" || k == "subcommand" || k == "node" || k == "worktype" || k == "tlsclient" || k == "ttl" {
+			if k == "command" || k == "subcommand" || k == "node" || k == "worktype" || k == "tlsclient" || k == "ttl" ||
+				k == "idempotencykey" || k == "webhook" || k == "webhooksecret" || k == "labels" ||
+				k == telemetry.TraceparentKey {
 				continue
 			}
 			vStr, ok := v.(string)
@@ -199,16 +306,37 @@ func (c *workceptorCommand) ControlFunc(nc *netceptor.Netceptor, cfo controlsvc.
 			}
 			workParams[k] = vStr
 		}
-		var worker WorkUnit
-		if workNode == nc.NodeID() || strings.EqualFold(workNode, "localhost") {
-			if ttl != "" {
-				return nil, fmt.Errorf("ttl option is intended for remote work only")
+		isLocal := workNode == nc.NodeID() || strings.EqualFold(workNode, "localhost")
+		if isLocal && ttl != "" {
+			return nil, fmt.Errorf("ttl option is intended for remote work only")
+		}
+		worker, created, err := c.w.AllocateUnitIdempotent(idempotencyKey, func() (WorkUnit, error) {
+			if isLocal {
+				return c.w.AllocateUnit(workType, workParams)
 			}
-			worker, err = c.w.AllocateUnit(workType, workParams)
-		} else {
-			worker, err = c.w.AllocateRemoteUnit(workNode, workType, tlsclient, ttl, workParams)
+
+			return c.w.AllocateRemoteUnit(workNode, workType, tlsclient, ttl, workParams)
+		})
+		if err != nil {
+			return nil, err
+		}
+		if !created {
+			// The unit was already submitted with this key, so its input is discarded
+			err = cfo.ReadFromConn(fmt.Sprintf("Work unit created with ID %s. Send stdin data and EOF.\n", worker.ID()),
+				ioutil.Discard)
+			if err != nil {
+				return nil, err
+			}
+			cfr := make(map[string]interface{})
+			cfr["unitid"] = worker.ID()
+			cfr["result"] = "Job Already Submitted"
+
+			return cfr, nil
 		}
+		err = saveLabels(worker.UnitDir(), labels)
 		if err != nil {
+			worker.UpdateBasicStatus(WorkStateFailed, fmt.Sprintf("Error saving labels: %s", err), 0)
+
 			return nil, err
 		}
 		stdin, err := os.OpenFile(path.Join(worker.UnitDir(), "stdin"), os.O_CREATE+os.O_WRONLY, 0o600)
@@ -228,13 +356,34 @@ func (c *workceptorCommand) ControlFunc(nc *netceptor.Netceptor, cfo controlsvc.
 
 			return nil, err
 		}
+		ctx, span := telemetry.Tracer().Start(cfo.Context(), "work submit",
+			trace.WithAttributes(
+				attribute.String("receptor.unit_id", worker.ID()),
+				attribute.String("receptor.work_type", workType),
+				attribute.String("receptor.node", workNode),
+			),
+			trace.WithAttributes(labelAttributes(labels)...))
+		c.w.startUnitSpan(ctx, worker.ID(), workType, workNode, labels)
+		if webhookURL != "" {
+			err = c.w.startWebhook(worker, webhookURL, webhookSecret)
+			if err != nil {
+				worker.UpdateBasicStatus(WorkStateFailed, fmt.Sprintf("Error saving webhook: %s", err), 0)
+				span.SetStatus(codes.Error, err.Error())
+				span.End()
+
+				return nil, err
+			}
+		}
 		worker.UpdateBasicStatus(WorkStatePending, "Starting Worker", 0)
 		err = worker.Start()
 		if err != nil && !IsPending(err) {
 			worker.UpdateBasicStatus(WorkStateFailed, fmt.Sprintf("Error starting worker: %s", err), 0)
+			span.SetStatus(codes.Error, err.Error())
+			span.End()
 
 			return nil, err
 		}
+		span.End()
 		cfr := make(map[string]interface{})
 		cfr["unitid"] = worker.ID()
 		if IsPending(err) {
@@ -245,12 +394,26 @@ func (c *workceptorCommand) ControlFunc(nc *netceptor.Netceptor, cfo controlsvc.
 
 		return cfr, nil
 	case "list":
+		var selector map[string]string
+		if labelsStr, ok := c.params["labels"].(string); ok {
+			var err error
+			selector, err = ParseLabels(labelsStr)
+			if err != nil {
+				return nil, err
+			}
+		}
 		var unitList []string
 		targetUnitID, ok := c.params["unitid"].(string)
 		if ok {
-			unitList = append(unitList, targetUnitID)
+			labels, err := c.w.UnitLabels(targetUnitID)
+			if err != nil {
+				return nil, err
+			}
+			if labelsMatch(labels, selector) {
+				unitList = append(unitList, targetUnitID)
+			}
 		} else {
-			unitList = c.w.ListKnownUnitIDs()
+			unitList = c.w.ListUnitIDsWithLabels(selector)
 		}
 		cfr := make(map[string]interface{})
 		for i := range unitList {
@@ -262,6 +425,36 @@ func (c *workceptorCommand) ControlFunc(nc *netceptor.Netceptor, cfo controlsvc.
 			cfr[unitID] = status
 		}
 
+		return cfr, nil
+	case "types":
+		node, _ := c.params["node"].(string)
+		nodes, err := c.w.MeshWorkTypes(node)
+		if err != nil {
+			return nil, err
+		}
+		cfr := make(map[string]interface{})
+		for nodeID, types := range nodes {
+			cfr[nodeID] = types
+		}
+
+		return cfr, nil
+	case "drain", "undrain":
+		workType, err := strFromMap(c.params, "worktype")
+		if err != nil {
+			return nil, err
+		}
+		cfr := make(map[string]interface{})
+		if c.subcommand == "drain" {
+			err = c.w.DrainWorkType(workType)
+			cfr["drained"] = workType
+		} else {
+			err = c.w.UndrainWorkType(workType)
+			cfr["undrained"] = workType
+		}
+		if err != nil {
+			return nil, err
+		}
+
 		return cfr, nil
 	case "status":
 		unitid, err := strFromMap(c.params, "unitid")
@@ -274,6 +467,20 @@ func (c *workceptorCommand) ControlFunc(nc *netceptor.Netceptor, cfo controlsvc.
 		}
 
 		return cfr, nil
+	case "info":
+		unitid, err := strFromMap(c.params, "unitid")
+		if err != nil {
+			return nil, err
+		}
+
+		return c.w.UnitInfo(cfo.Context(), unitid)
+	case "poll":
+		unitid, err := strFromMap(c.params, "unitid")
+		if err != nil {
+			return nil, err
+		}
+
+		return c.w.PollUnit(cfo.Context(), unitid)
 	case "cancel", "release", "force-release":
 		unitid, err := strFromMap(c.params, "unitid")
 		if err != nil {
@@ -292,6 +499,21 @@ func (c *workceptorCommand) ControlFunc(nc *netceptor.Netceptor, cfo controlsvc.
 		unit, err := c.w.findUnit(unitid)
 		if err != nil {
 			cfr["unit not found"] = unitid
+		} else if c.subcommand == "release" {
+			// --force skips retention, but unlike force-release still waits for the remote node
+			force, _ := c.params["force"].(bool)
+			until, err := c.w.ReleaseUnitRetaining(unitid, force)
+			if err != nil && !IsPending(err) {
+				return nil, err
+			}
+			if IsPending(err) {
+				cfr[pendingMsg] = unitid
+			} else {
+				cfr[completeMsg] = unitid
+			}
+			if !until.IsZero() {
+				cfr["retained until"] = until.Format(time.RFC3339)
+			}
 		} else {
 			if c.subcommand == "cancel" {
 				err = unit.Cancel()
@@ -318,15 +540,33 @@ func (c *workceptorCommand) ControlFunc(nc *netceptor.Netceptor, cfo controlsvc.
 		if err != nil {
 			return nil, err
 		}
+		length := int64(-1)
+		if _, ok := c.params["length"]; ok {
+			length, err = intFromMap(c.params, "length")
+			if err != nil {
+				return nil, err
+			}
+			if length < 0 {
+				return nil, fmt.Errorf("length must not be negative")
+			}
+		}
+		status, err := c.w.UnitStatus(unitid)
+		if err != nil {
+			return nil, err
+		}
+		header, err := resultsHeader(unitid, status, startPos, length)
+		if err != nil {
+			return nil, err
+		}
 		doneChan := make(chan struct{})
 		defer func() {
 			close(doneChan)
 		}()
-		resultChan, err := c.w.GetResults(unitid, startPos, doneChan)
+		resultChan, err := c.w.GetResultsRange(unitid, startPos, length, doneChan)
 		if err != nil {
 			return nil, err
 		}
-		err = cfo.WriteToConn(fmt.Sprintf("Streaming results for work unit %s\n", unitid), resultChan)
+		err = cfo.WriteToConn(header, resultChan)
 		if err != nil {
 			return nil, err
 		}
@@ -340,3 +580,121 @@ func (c *workceptorCommand) ControlFunc(nc *netceptor.Netceptor, cfo controlsvc.
 
 	return nil, fmt.Errorf("bad command")
 }
+
+// resultsHeader returns the line that starts a stream of results, giving the offset and length of the
+// stream and the total size of the results, so that clients can tell whether they received everything and
+// where to resume if they did not.  The total size is unknown until the unit is complete.
+func resultsHeader(unitID string, status *StatusFileData, startPos int64, length int64) (string, error) {
+	if startPos < 0 {
+		return "", fmt.Errorf("start position must not be negative")
+	}
+	lengthStr := "unknown"
+	totalStr := "unknown"
+	if IsComplete(status.State) {
+		total := status.StdoutSize
+		if startPos > total {
+			return "", fmt.Errorf("start position %d is beyond the end of the results (%d bytes)", startPos, total)
+		}
+		if length < 0 || startPos+length > total {
+			length = total - startPos
+		}
+		totalStr = strconv.FormatInt(total, 10)
+	}
+	if length >= 0 {
+		lengthStr = strconv.FormatInt(length, 10)
+	}
+
+	return fmt.Sprintf("Streaming results for work unit %s (offset %d, length %s, total %s)\n",
+		unitID, startPos, lengthStr, totalStr), nil
+}
+
+type stateCommandType struct {
+	w *Workceptor
+}
+
+type stateCommand struct {
+	w          *Workceptor
+	subcommand string
+	filename   string
+}
+
+func (t *stateCommandType) InitFromString(params string) (controlsvc.ControlCommand, error) {
+	tokens := strings.SplitN(params, " ", 2)
+	if len(tokens) < 2 || tokens[1] == "" {
+		return nil, fmt.Errorf("state command requires a subcommand and a filename")
+	}
+	c := &stateCommand{
+		w:          t.w,
+		subcommand: strings.ToLower(tokens[0]),
+		filename:   tokens[1],
+	}
+
+	return c, nil
+}
+
+func (t *stateCommandType) InitFromJSON(config map[string]interface{}) (controlsvc.ControlCommand, error) {
+	subCmd, err := strFromMap(config, "subcommand")
+	if err != nil {
+		return nil, err
+	}
+	filename, err := strFromMap(config, "filename")
+	if err != nil {
+		return nil, err
+	}
+	c := &stateCommand{
+		w:          t.w,
+		subcommand: strings.ToLower(subCmd),
+		filename:   filename,
+	}
+
+	return c, nil
+}
+
+// LocalOnly reports that the state command may only be run from the node's own host, since it reads and
+// writes files there.
+func (c *stateCommand) LocalOnly() bool {
+	return true
+}
+
+// ControlFunc is called by the control service to process a "state" command.  The filename is the name of
+// an archive in the configured state dir.
+func (c *stateCommand) ControlFunc(nc *netceptor.Netceptor, cfo controlsvc.ControlFuncOperations) (map[string]interface{}, error) {
+	filename, err := c.w.stateArchivePath(c.filename)
+	if err != nil {
+		return nil, err
+	}
+	var units []string
+	switch c.subcommand {
+	case "export":
+		f, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0o600)
+		if err != nil {
+			return nil, err
+		}
+		units, err = c.w.ExportState(f)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			_ = os.Remove(filename)
+
+			return nil, err
+		}
+	case "import":
+		f, err := os.Open(filename)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		units, err = c.w.ImportState(f)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown state subcommand %s", c.subcommand)
+	}
+	cfr := make(map[string]interface{})
+	cfr["Filename"] = filename
+	cfr["Units"] = units
+
+	return cfr, nil
+}
@@ -0,0 +1,112 @@
+//go:build !no_workceptor
+// +build !no_workceptor
+
+package workceptor
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/ansible/receptor/pkg/logger"
+)
+
+// MinDataDirFreeSpace is the free space the work data dir must have, at startup and to leave degraded mode.
+const MinDataDirFreeSpace = 16 * 1024 * 1024
+
+// storageProbeInterval is how often a degraded data dir is checked to see if it is writable again.
+const storageProbeInterval = 10 * time.Second
+
+// ErrStorageDegraded is returned when new work is refused because writes to the data dir have failed.
+var ErrStorageDegraded = fmt.Errorf("work data dir is not writable, not accepting new work")
+
+// checkDataDir checks that a directory can be written to and has at least minFree bytes free.
+func checkDataDir(dataDir string, minFree int64) error {
+	file, err := ioutil.TempFile(dataDir, ".write-test-*")
+	if err != nil {
+		return fmt.Errorf("data dir %s is not writable, check its permissions and that its filesystem is not read-only: %s",
+			dataDir, err)
+	}
+	_, err = file.Write([]byte("receptor"))
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	_ = os.Remove(file.Name())
+	if err != nil {
+		return fmt.Errorf("data dir %s is not writable, check that its filesystem is not full: %s", dataDir, err)
+	}
+	free, err := freeSpace(dataDir)
+	if err != nil {
+		return fmt.Errorf("could not get free space of data dir %s: %s", dataDir, err)
+	}
+	if free < minFree {
+		return fmt.Errorf("data dir %s has %d bytes free, at least %d are needed, free some space or use another data dir",
+			dataDir, free, minFree)
+	}
+
+	return nil
+}
+
+// validateDataDir creates the data dir if needed, and checks that it can be used for work units.
+func validateDataDir(dataDir string) error {
+	err := os.MkdirAll(dataDir, 0o700)
+	if err != nil {
+		return fmt.Errorf("could not create data dir %s: %s", dataDir, err)
+	}
+
+	return checkDataDir(dataDir, MinDataDirFreeSpace)
+}
+
+// noteStorageError puts the workceptor into degraded mode, where no new work is accepted, if err shows that
+// the data dir can no longer be written to.  Work that is already running carries on.
+func (w *Workceptor) noteStorageError(err error) {
+	if err == nil || !isStorageError(err) {
+		return
+	}
+	w.storageLock.Lock()
+	defer w.storageLock.Unlock()
+	if w.storageErr == nil {
+		logger.Error("Not accepting new work until the data dir %s can be written to: %s\n", w.dataDir, err)
+	}
+	w.storageErr = err
+	w.storageProbedAt = time.Now()
+}
+
+// checkStorage returns an error if the workceptor is in degraded mode.  Once the data dir is writable and
+// has enough free space again, degraded mode ends.
+func (w *Workceptor) checkStorage() error {
+	w.storageLock.Lock()
+	defer w.storageLock.Unlock()
+	if w.storageErr == nil {
+		return nil
+	}
+	if time.Since(w.storageProbedAt) >= storageProbeInterval {
+		w.storageProbedAt = time.Now()
+		err := checkDataDir(w.dataDir, MinDataDirFreeSpace)
+		if err == nil {
+			logger.Info("Data dir %s can be written to again, accepting new work\n", w.dataDir)
+			w.storageErr = nil
+
+			return nil
+		}
+		w.storageErr = err
+	}
+
+	return fmt.Errorf("%w: %s", ErrStorageDegraded, w.storageErr)
+}
+
+// isStorageError returns whether an error shows that the filesystem is read-only, full or inaccessible.
+func isStorageError(err error) bool {
+	if errors.Is(err, os.ErrPermission) {
+		return true
+	}
+	for _, target := range storageErrnos {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+
+	return false
+}
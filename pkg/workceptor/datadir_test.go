@@ -0,0 +1,114 @@
+//go:build !no_workceptor
+// +build !no_workceptor
+
+package workceptor
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path"
+	"runtime"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/ansible/receptor/pkg/netceptor"
+)
+
+func TestNewReadOnlyDataDir(t *testing.T) {
+	if runtime.GOOS == "windows" || os.Geteuid() == 0 {
+		t.Skip("directory permissions do not stop this user from writing")
+	}
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "receptor-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	dataDir := path.Join(tmpdir, "node1")
+	if err := os.Mkdir(dataDir, 0o500); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chmod(dataDir, 0o700)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	_, err = New(ctx, netceptor.New(ctx, "node1", nil), tmpdir)
+	if err == nil || !strings.Contains(err.Error(), "is not writable") {
+		t.Fatalf("expected a not writable error, got %v", err)
+	}
+}
+
+func TestCheckDataDir(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "receptor-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	if err := checkDataDir(tmpdir, 0); err != nil {
+		t.Fatal(err)
+	}
+	err = checkDataDir(tmpdir, math.MaxInt64)
+	if err == nil || !strings.Contains(err.Error(), "bytes free") {
+		t.Fatalf("expected a free space error, got %v", err)
+	}
+	err = checkDataDir(path.Join(tmpdir, "missing"), 0)
+	if err == nil || !strings.Contains(err.Error(), "is not writable") {
+		t.Fatalf("expected a not writable error, got %v", err)
+	}
+	// The write test leaves nothing behind
+	files, err := ioutil.ReadDir(tmpdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 0 {
+		t.Fatalf("expected an empty dir, found %d files", len(files))
+	}
+}
+
+func TestStorageDegraded(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "receptor-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	w, err := New(ctx, netceptor.New(ctx, "node1", nil), tmpdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.RegisterWorker("hold", newHoldWorker); err != nil {
+		t.Fatal(err)
+	}
+	running := startTestUnit(t, w, "hold", nil, "input")
+
+	// Errors that say nothing about the filesystem are ignored
+	w.noteStorageError(io.ErrUnexpectedEOF)
+	if _, err := w.AllocateUnit("hold", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// A failed status write stops new work from being accepted
+	w.noteStorageError(&os.PathError{Op: "write", Path: running.StatusFileName(), Err: syscall.ENOSPC})
+	_, err = w.AllocateUnit("hold", nil)
+	if !errors.Is(err, ErrStorageDegraded) || !strings.Contains(err.Error(), "no space left") {
+		t.Fatalf("expected new work to be refused, got %v", err)
+	}
+	// Work that was already running carries on
+	running.UpdateBasicStatus(WorkStateSucceeded, "Done", 0)
+	if running.LastUpdateError() != nil || running.Status().State != WorkStateSucceeded {
+		t.Fatalf("expected running unit to complete, got %+v", running.Status())
+	}
+
+	// Once the data dir is found to be writable again, new work is accepted
+	w.storageLock.Lock()
+	w.storageProbedAt = time.Now().Add(-storageProbeInterval)
+	w.storageLock.Unlock()
+	if _, err := w.AllocateUnit("hold", nil); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,24 @@
+//go:build !windows && !no_workceptor
+// +build !windows,!no_workceptor
+
+package workceptor
+
+import (
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// storageErrnos are the errors that show a filesystem is read-only or full.
+var storageErrnos = []error{syscall.EROFS, syscall.ENOSPC, syscall.EDQUOT}
+
+// freeSpace returns the bytes available to unprivileged users on the filesystem holding a directory.
+func freeSpace(dir string) (int64, error) {
+	var st unix.Statfs_t
+	err := unix.Statfs(dir, &st)
+	if err != nil {
+		return 0, err
+	}
+
+	return int64(uint64(st.Bavail) * uint64(st.Bsize)), nil
+}
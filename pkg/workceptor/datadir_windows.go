@@ -0,0 +1,26 @@
+//go:build windows && !no_workceptor
+// +build windows,!no_workceptor
+
+package workceptor
+
+import (
+	"golang.org/x/sys/windows"
+)
+
+// storageErrnos are the errors that show a filesystem is read-only or full.
+var storageErrnos = []error{windows.ERROR_WRITE_PROTECT, windows.ERROR_HANDLE_DISK_FULL, windows.ERROR_DISK_FULL}
+
+// freeSpace returns the bytes available to this user on the volume holding a directory.
+func freeSpace(dir string) (int64, error) {
+	dirPtr, err := windows.UTF16PtrFromString(dir)
+	if err != nil {
+		return 0, err
+	}
+	var free uint64
+	err = windows.GetDiskFreeSpaceEx(dirPtr, &free, nil, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	return int64(free), nil
+}
@@ -0,0 +1,68 @@
+//go:build !no_workceptor
+// +build !no_workceptor
+
+package workceptor
+
+import (
+	"io/ioutil"
+	"path"
+)
+
+// idempotencyKeyFileName is the file in a unit dir that holds the idempotency key the unit was submitted with.
+const idempotencyKeyFileName = "idempotency_key"
+
+// loadIdempotencyKeys builds the key-to-unit map from the unit dirs, if it has not been built yet.
+// Must be called with idempotencyLock held.
+func (w *Workceptor) loadIdempotencyKeys() {
+	if w.idempotencyKeys != nil {
+		return
+	}
+	w.idempotencyKeys = make(map[string]string)
+	files, err := ioutil.ReadDir(w.dataDir)
+	if err != nil {
+		return
+	}
+	for _, fi := range files {
+		data, err := ioutil.ReadFile(path.Join(w.dataDir, fi.Name(), idempotencyKeyFileName))
+		if err == nil && len(data) > 0 {
+			w.idempotencyKeys[string(data)] = fi.Name()
+		}
+	}
+}
+
+// AllocateUnitIdempotent calls allocate to create a new unit of work, unless a unit was already created with
+// the same idempotency key, in which case the existing unit is returned instead.  The returned bool is true
+// if a new unit was created.  Concurrent calls with the same key create only one unit.
+func (w *Workceptor) AllocateUnitIdempotent(key string, allocate func() (WorkUnit, error)) (WorkUnit, bool, error) {
+	if key == "" {
+		unit, err := allocate()
+
+		return unit, err == nil, err
+	}
+	w.idempotencyLock.Lock()
+	defer w.idempotencyLock.Unlock()
+	w.loadIdempotencyKeys()
+	if unitID, ok := w.idempotencyKeys[key]; ok {
+		w.activeUnitsLock.RLock()
+		unit, ok := w.activeUnits[unitID]
+		w.activeUnitsLock.RUnlock()
+		if ok {
+			return unit, false, nil
+		}
+		// The unit has been released since, so the key can be used again
+		delete(w.idempotencyKeys, key)
+	}
+	unit, err := allocate()
+	if err != nil {
+		return nil, false, err
+	}
+	err = ioutil.WriteFile(path.Join(unit.UnitDir(), idempotencyKeyFileName), []byte(key), 0o600)
+	if err != nil {
+		_ = unit.Release(true)
+
+		return nil, false, err
+	}
+	w.idempotencyKeys[key] = unit.ID()
+
+	return unit, true, nil
+}
@@ -0,0 +1,96 @@
+//go:build !no_workceptor
+// +build !no_workceptor
+
+package workceptor
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+)
+
+func TestAllocateUnitIdempotent(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "receptor-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	w := newTestWorkceptorInDir(ctx, t, tmpdir)
+	allocate := func() (WorkUnit, error) {
+		return w.AllocateUnit("command", make(map[string]string))
+	}
+
+	// Concurrent submissions with the same key create a single unit
+	const submitters = 10
+	ids := make(chan string, submitters)
+	created := make(chan bool, submitters)
+	wg := sync.WaitGroup{}
+	for i := 0; i < submitters; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			unit, isNew, err := w.AllocateUnitIdempotent("key1", allocate)
+			if err != nil {
+				t.Error(err)
+
+				return
+			}
+			ids <- unit.ID()
+			created <- isNew
+		}()
+	}
+	wg.Wait()
+	close(ids)
+	close(created)
+	var unitID string
+	for id := range ids {
+		if unitID == "" {
+			unitID = id
+		} else if id != unitID {
+			t.Errorf("expected unit %s, got %s", unitID, id)
+		}
+	}
+	newCount := 0
+	for isNew := range created {
+		if isNew {
+			newCount++
+		}
+	}
+	if newCount != 1 {
+		t.Errorf("expected one unit to be created, got %d", newCount)
+	}
+	if units := w.ListKnownUnitIDs(); len(units) != 1 {
+		t.Errorf("expected one unit, got %v", units)
+	}
+
+	// A different key creates a different unit
+	unit, isNew, err := w.AllocateUnitIdempotent("key2", allocate)
+	if err != nil || !isNew || unit.ID() == unitID {
+		t.Errorf("expected a new unit for a new key, got %v %v %v", unit, isNew, err)
+	}
+
+	// The mapping survives a restart
+	w2 := newTestWorkceptorInDir(ctx, t, tmpdir)
+	unit, isNew, err = w2.AllocateUnitIdempotent("key1", func() (WorkUnit, error) {
+		return w2.AllocateUnit("command", make(map[string]string))
+	})
+	if err != nil || isNew || unit.ID() != unitID {
+		t.Errorf("expected existing unit %s after restart, got %v %v %v", unitID, unit, isNew, err)
+	}
+
+	// Once the unit is released, the key can be used again
+	err = w2.ReleaseUnit(unitID, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unit, isNew, err = w2.AllocateUnitIdempotent("key1", func() (WorkUnit, error) {
+		return w2.AllocateUnit("command", make(map[string]string))
+	})
+	if err != nil || !isNew || unit.ID() == unitID {
+		t.Errorf("expected a new unit after release, got %v %v %v", unit, isNew, err)
+	}
+}
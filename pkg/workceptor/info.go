@@ -0,0 +1,154 @@
+//go:build !no_workceptor
+// +build !no_workceptor
+
+package workceptor
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/ansible/receptor/pkg/logger"
+	"github.com/ansible/receptor/pkg/utils"
+)
+
+// remoteInfoTimeout is how long to wait for a remote node to return its info about a unit.
+const remoteInfoTimeout = 10 * time.Second
+
+// UnitInfo returns all the metadata of a unit of work, with secret params redacted.  For a unit whose work
+// runs on a remote node, the remote node's info about the unit is included as Remote, if it can be reached.
+func (w *Workceptor) UnitInfo(ctx context.Context, unitID string) (map[string]interface{}, error) {
+	unit, err := w.findUnit(unitID)
+	if err != nil {
+		return nil, err
+	}
+	status := unit.Status()
+	info := map[string]interface{}{
+		"UnitID":     unitID,
+		"Node":       w.nc.NodeID(),
+		"WorkType":   status.WorkType,
+		"State":      status.State,
+		"StateName":  WorkStateToString(status.State),
+		"Detail":     status.Detail,
+		"StdoutSize": status.StdoutSize,
+		"ExtraData":  status.ExtraData,
+	}
+	if params := redactedParams(unit.UnredactedStatus().ExtraData); params != nil {
+		info["Params"] = params
+	}
+	var exitCode int
+	if _, err := fmt.Sscanf(status.Detail, "exit status %d", &exitCode); err == nil {
+		info["ExitCode"] = exitCode
+	}
+	unitDir := unit.UnitDir()
+	submitted, ok := unitSubmitted(unitDir)
+	if ok {
+		info["Submitted"] = submitted
+	}
+	if started, ok := unitStarted(unitDir); ok {
+		info["Started"] = started
+		if !submitted.IsZero() {
+			info["WaitTime"] = math.Max(started.Sub(submitted).Seconds(), 0)
+		}
+	}
+	if fi, err := os.Stat(path.Join(unitDir, "status")); err == nil {
+		info["Updated"] = fi.ModTime()
+	}
+	if until := retainUntil(unitDir); !until.IsZero() {
+		info["RetainUntil"] = until
+	}
+	if key, err := ioutil.ReadFile(path.Join(unitDir, idempotencyKeyFileName)); err == nil {
+		info["IdempotencyKey"] = string(key)
+	}
+	labels, err := readLabels(unitDir)
+	if err != nil {
+		logger.Warning("Error reading labels of work unit %s: %s\n", unitID, err)
+	} else if labels != nil {
+		info["Labels"] = labels
+	}
+	progress, err := w.unitProgress(unitID, unitDir)
+	if err != nil {
+		logger.Warning("Error reading progress of work unit %s: %s\n", unitID, err)
+	} else if progress != nil {
+		info["Progress"] = progress
+	}
+	rw, ok := unit.(*remoteUnit)
+	if !ok {
+		return info, nil
+	}
+	red, ok := status.ExtraData.(*remoteExtraData)
+	if !ok {
+		return info, nil
+	}
+	info["Node"] = red.RemoteNode
+	info["WorkType"] = red.RemoteWorkType
+	if red.RemoteStarted && red.RemoteUnitID != "" {
+		remote, err := rw.remoteInfo(ctx, red.RemoteUnitID)
+		if err != nil {
+			info["RemoteError"] = err.Error()
+		} else {
+			info["Remote"] = remote
+		}
+	}
+
+	return info, nil
+}
+
+// redactedParams returns the params of a unit from its ExtraData, with the values of secret params redacted.
+func redactedParams(extraData interface{}) interface{} {
+	var params map[string]string
+	switch ed := extraData.(type) {
+	case *commandExtraData:
+		return ed.Params
+	case *kubeExtraData:
+		return ed.Params
+	case *agentExtraData:
+		params = ed.Params
+	case *remoteExtraData:
+		params = ed.RemoteParams
+	default:
+		return nil
+	}
+	redacted := make(map[string]string)
+	for k, v := range params {
+		if strings.HasPrefix(strings.ToLower(k), "secret_") {
+			v = utils.RedactedValue
+		}
+		redacted[k] = v
+	}
+
+	return redacted
+}
+
+// remoteInfo asks the remote node for its info about a unit.
+func (rw *remoteUnit) remoteInfo(ctx context.Context, remoteUnitID string) (map[string]interface{}, error) {
+	ctx, cancel := context.WithTimeout(ctx, remoteInfoTimeout)
+	defer cancel()
+	var info map[string]interface{}
+	err := rw.connectAndRun(ctx, func(ctx context.Context, conn net.Conn, reader *bufio.Reader) error {
+		defer conn.Close()
+		_, err := conn.Write([]byte(fmt.Sprintf("work info %s\n", remoteUnitID)))
+		if err != nil {
+			return err
+		}
+		response, err := utils.ReadStringContext(ctx, reader, '\n')
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(response, "ERROR") {
+			return fmt.Errorf("remote error: %s", strings.TrimSpace(strings.TrimPrefix(response, "ERROR:")))
+		}
+
+		return json.Unmarshal([]byte(response), &info)
+	})
+
+	return info, err
+}
@@ -0,0 +1,97 @@
+//go:build !no_workceptor
+// +build !no_workceptor
+
+package workceptor
+
+import (
+	"context"
+	"io/ioutil"
+	"path"
+	"testing"
+
+	"github.com/ansible/receptor/pkg/utils"
+)
+
+func TestUnitInfoCommand(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	w := newTestWorkceptor(ctx, t)
+	unit, _, err := w.AllocateUnitIdempotent("key-1", func() (WorkUnit, error) {
+		return w.AllocateUnit("command", make(map[string]string))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(path.Join(unit.UnitDir(), "stdin"), []byte("input"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unit.UpdateBasicStatus(WorkStateFailed, "exit status 3", 12)
+
+	info, err := w.UnitInfo(ctx, unit.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]interface{}{
+		"UnitID":         unit.ID(),
+		"Node":           "test",
+		"WorkType":       "command",
+		"State":          WorkStateFailed,
+		"StateName":      "Failed",
+		"Detail":         "exit status 3",
+		"StdoutSize":     int64(12),
+		"ExitCode":       3,
+		"IdempotencyKey": "key-1",
+	}
+	for k, v := range expected {
+		if info[k] != v {
+			t.Errorf("expected %s to be %v, got %v", k, v, info[k])
+		}
+	}
+	for _, k := range []string{"Submitted", "Updated"} {
+		if _, ok := info[k]; !ok {
+			t.Errorf("expected %s in unit info", k)
+		}
+	}
+
+	_, err = w.UnitInfo(ctx, "nonexistent")
+	if err == nil {
+		t.Error("expected error for unknown unit")
+	}
+}
+
+func TestUnitInfoRedactsSecrets(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	w := newTestWorkceptor(ctx, t)
+	unit, err := w.AllocateUnit("remote", map[string]string{"secret_token": "hunter2", "color": "blue"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	unit.UpdateFullStatus(func(status *StatusFileData) {
+		ed := status.ExtraData.(*remoteExtraData)
+		ed.RemoteNode = "node2"
+		ed.RemoteWorkType = "echo"
+	})
+
+	info, err := w.UnitInfo(ctx, unit.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info["Node"] != "node2" || info["WorkType"] != "echo" {
+		t.Errorf("expected remote node and work type, got %v and %v", info["Node"], info["WorkType"])
+	}
+	params, ok := info["Params"].(map[string]string)
+	if !ok {
+		t.Fatalf("expected params map, got %T", info["Params"])
+	}
+	if params["secret_token"] != utils.RedactedValue {
+		t.Errorf("expected secret param to be redacted, got %s", params["secret_token"])
+	}
+	if params["color"] != "blue" {
+		t.Errorf("expected color param to be blue, got %s", params["color"])
+	}
+	if _, ok := info["Remote"]; ok {
+		t.Error("expected no remote info for a unit that was not started")
+	}
+}
@@ -11,12 +11,14 @@ import (
 	"io/ioutil"
 	"net"
 	"os"
+	"regexp"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/ansible/receptor/pkg/logger"
+	"github.com/ansible/receptor/pkg/utils"
 	"github.com/ghjm/cmdline"
 	"github.com/google/shlex"
 	corev1 "k8s.io/api/core/v1"
@@ -52,6 +54,7 @@ type kubeUnit struct {
 	clientset           *kubernetes.Clientset
 	pod                 *corev1.Pod
 	podPendingTimeout   time.Duration
+	redact              []*regexp.Regexp
 }
 
 // kubeExtraData is the content of the ExtraData JSON field for a Kubernetes worker.
@@ -368,6 +371,9 @@ func (kw *kubeUnit) runWorkUsingLogger() {
 		}()
 	}
 
+	// Secrets are redacted before the output is written, so they never reach the disk
+	out := newRedactingWriter(stdout, kw.redact)
+
 	// Actually run the streams.  This blocks until the pod finishes.
 	var errStdin error
 	var errStdout error
@@ -388,7 +394,10 @@ func (kw *kubeUnit) runWorkUsingLogger() {
 		}()
 	}
 	go func() {
-		_, errStdout = io.Copy(stdout, logStream)
+		_, errStdout = io.Copy(out, logStream)
+		if errStdout == nil {
+			errStdout = out.Flush()
+		}
 		streamWait.Done()
 	}()
 	streamWait.Wait()
@@ -568,11 +577,15 @@ func (kw *kubeUnit) runWorkUsingTCP() {
 		}
 	}()
 
-	// Read stdout from pod
-	_, err = io.Copy(stdout, conn)
+	// Read stdout from pod, redacting secrets before they reach the disk
+	out := newRedactingWriter(stdout, kw.redact)
+	_, err = io.Copy(out, conn)
 	if ctx.Err() != nil {
 		return
 	}
+	if err == nil {
+		err = out.Flush()
+	}
 	if err != nil {
 		errMsg := fmt.Sprintf("Error reading stdout from pod: %s", err)
 		logger.Error(errMsg)
@@ -677,6 +690,29 @@ func readFileToString(filename string) (string, error) {
 	return string(content), nil
 }
 
+// kubeRuntimeParams returns the params a Kubernetes work type accepts when work is submitted, which
+// depend on what it allows to be set at runtime.
+func kubeRuntimeParams(allowAuth, allowTLS, allowCommand, allowParams, allowPod bool) []string {
+	params := []string{}
+	if allowCommand {
+		params = append(params, "kube_command", "kube_image")
+	}
+	if allowParams {
+		params = append(params, "kube_params", "pod_pending_timeout")
+	}
+	if allowAuth {
+		params = append(params, "kube_namespace", "secret_kube_config")
+	}
+	if allowPod {
+		params = append(params, "secret_kube_pod")
+	}
+	if allowTLS {
+		params = append(params, "kube_verify_tls", "kube_tls_ca")
+	}
+
+	return params
+}
+
 // SetFromParams sets the in-memory state from parameters.
 //nolint:ifshort // Method to magical for linter
 func (kw *kubeUnit) SetFromParams(params map[string]string) error {
@@ -894,27 +930,31 @@ func (kw *kubeUnit) Release(force bool) error {
 
 // workKubeCfg is the cmdline configuration object for a Kubernetes worker plugin.
 type workKubeCfg struct {
-	WorkType            string `required:"true" description:"Name for this worker type"`
-	Namespace           string `description:"Kubernetes namespace to create pods in"`
-	Image               string `description:"Container image to use for the worker pod"`
-	Command             string `description:"Command to run in the container (overrides entrypoint)"`
-	Params              string `description:"Command-line parameters to pass to the entrypoint"`
-	AuthMethod          string `description:"One of: kubeconfig, incluster" default:"incluster"`
-	KubeConfig          string `description:"Kubeconfig filename (for authmethod=kubeconfig)"`
-	Pod                 string `description:"Pod definition filename, in json or yaml format"`
-	KubeVerifyTLS       bool   `description:"verify server TLS certificate/hostname" default:"true"`
-	KubeTLSCAData       string `description:"CA certificate PEM data to verify against"`
-	AllowRuntimeAuth    bool   `description:"Allow passing API parameters at runtime" default:"false"`
-	AllowRuntimeTLS     bool   `description:"Allow passing TLS parameters at runtime" default:"false"`
-	AllowRuntimeCommand bool   `description:"Allow specifying image & command at runtime" default:"false"`
-	AllowRuntimeParams  bool   `description:"Allow adding command parameters at runtime" default:"false"`
-	AllowRuntimePod     bool   `description:"Allow passing Pod at runtime" default:"false"`
-	DeletePodOnRestart  bool   `description:"On restart, delete the pod if in pending state" default:"true"`
-	StreamMethod        string `description:"Method for connecting to worker pods: logger or tcp" default:"logger"`
+	WorkType            string   `required:"true" description:"Name for this worker type"`
+	Namespace           string   `description:"Kubernetes namespace to create pods in"`
+	Image               string   `description:"Container image to use for the worker pod"`
+	Command             string   `description:"Command to run in the container (overrides entrypoint)"`
+	Params              string   `description:"Command-line parameters to pass to the entrypoint"`
+	AuthMethod          string   `description:"One of: kubeconfig, incluster" default:"incluster"`
+	KubeConfig          string   `description:"Kubeconfig filename (for authmethod=kubeconfig)"`
+	Pod                 string   `description:"Pod definition filename, in json or yaml format"`
+	KubeVerifyTLS       bool     `description:"verify server TLS certificate/hostname" default:"true"`
+	KubeTLSCAData       string   `description:"CA certificate PEM data to verify against"`
+	AllowRuntimeAuth    bool     `description:"Allow passing API parameters at runtime" default:"false"`
+	AllowRuntimeTLS     bool     `description:"Allow passing TLS parameters at runtime" default:"false"`
+	AllowRuntimeCommand bool     `description:"Allow specifying image & command at runtime" default:"false"`
+	AllowRuntimeParams  bool     `description:"Allow adding command parameters at runtime" default:"false"`
+	AllowRuntimePod     bool     `description:"Allow passing Pod at runtime" default:"false"`
+	DeletePodOnRestart  bool     `description:"On restart, delete the pod if in pending state" default:"true"`
+	StreamMethod        string   `description:"Method for connecting to worker pods: logger or tcp" default:"logger"`
+	Reassignable        bool     `description:"Reassign units to another node that can run them when this node shuts down" default:"false"`
+	ParamSchema         string   `description:"JSON schema file to validate the params of submitted work against"`
+	Redact              []string `description:"Regular expressions whose matches are replaced with [REDACTED] in the unit's output"`
 }
 
 // newWorker is a factory to produce worker instances.
 func (cfg workKubeCfg) newWorker(w *Workceptor, unitID string, workType string) WorkUnit {
+	redact, _ := compileRedactPatterns(cfg.Redact) // validated by Prepare
 	ku := &kubeUnit{
 		BaseWorkUnit: BaseWorkUnit{
 			status: StatusFileData{
@@ -939,6 +979,7 @@ func (cfg workKubeCfg) newWorker(w *Workceptor, unitID string, workType string)
 		allowRuntimePod:     cfg.AllowRuntimePod,
 		deletePodOnRestart:  cfg.DeletePodOnRestart,
 		namePrefix:          fmt.Sprintf("%s-", strings.ToLower(cfg.WorkType)),
+		redact:              redact,
 	}
 	ku.BaseWorkUnit.Init(w, unitID, workType)
 
@@ -979,15 +1020,31 @@ func (cfg workKubeCfg) Prepare() error {
 	if method != "logger" && method != "tcp" {
 		return fmt.Errorf("stream mode must be logger or tcp")
 	}
+	if _, err := compileRedactPatterns(cfg.Redact); err != nil {
+		return err
+	}
 
 	return nil
 }
 
 // Run runs the action.
 func (cfg workKubeCfg) Run() error {
+	utils.RecordEffectiveConfig("work-kubernetes", cfg)
 	err := MainInstance.RegisterWorker(cfg.WorkType, cfg.newWorker)
+	if err != nil {
+		return err
+	}
+	err = MainInstance.SetWorkTypeParams(cfg.WorkType, kubeRuntimeParams(cfg.AllowRuntimeAuth, cfg.AllowRuntimeTLS,
+		cfg.AllowRuntimeCommand, cfg.AllowRuntimeParams, cfg.AllowRuntimePod))
+	if err != nil {
+		return err
+	}
+	err = MainInstance.setParamSchemaFile(cfg.WorkType, cfg.ParamSchema)
+	if err != nil {
+		return err
+	}
 
-	return err
+	return MainInstance.SetWorkTypeReassignable(cfg.WorkType, cfg.Reassignable)
 }
 
 func init() {
@@ -1031,6 +1088,12 @@ type Kubernetes struct {
 	KeepPodOnRestart bool `mapstructure:"keep-pod-on-restart"`
 	// Method for connecting to worker pods: logger or tcp.
 	StreamMethod *string `mapstructure:""`
+	// Reassign units to another node that can run them when this node shuts down.
+	Reassignable bool `mapstructure:"reassignable"`
+	// JSON schema file to validate the params of submitted work against.
+	ParamSchema string `mapstructure:"param-schema"`
+	// Regular expressions whose matches are replaced with [REDACTED] in the unit's output.
+	Redact []string `mapstructure:"redact"`
 }
 
 func (k Kubernetes) setup(wc *Workceptor) error {
@@ -1077,6 +1140,10 @@ func (k Kubernetes) setup(wc *Workceptor) error {
 	if streamMethod != "logger" && streamMethod != "tcp" {
 		return fmt.Errorf("stream mode must be logger or tcp")
 	}
+	redact, err := compileRedactPatterns(k.Redact)
+	if err != nil {
+		return err
+	}
 
 	factory := func(w *Workceptor, unitID string, workType string) WorkUnit {
 		ku := &kubeUnit{
@@ -1103,11 +1170,24 @@ func (k Kubernetes) setup(wc *Workceptor) error {
 			allowRuntimePod:     k.AllowRuntimePod,
 			deletePodOnRestart:  !k.KeepPodOnRestart,
 			namePrefix:          fmt.Sprintf("%s-", strings.ToLower(k.WorkType)),
+			redact:              redact,
 		}
 		ku.BaseWorkUnit.Init(w, unitID, workType)
 
 		return ku
 	}
 
-	return wc.RegisterWorker(k.WorkType, factory)
+	if err := wc.RegisterWorker(k.WorkType, factory); err != nil {
+		return err
+	}
+	err = wc.SetWorkTypeParams(k.WorkType, kubeRuntimeParams(k.AllowRuntimeAuth, k.AllowRuntimeTLS,
+		k.AllowRuntimeCommand, k.AllowRuntimeParams, k.AllowRuntimePod))
+	if err != nil {
+		return err
+	}
+	if err := wc.setParamSchemaFile(k.WorkType, k.ParamSchema); err != nil {
+		return err
+	}
+
+	return wc.SetWorkTypeReassignable(k.WorkType, k.Reassignable)
 }
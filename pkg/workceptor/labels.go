@@ -0,0 +1,135 @@
+//go:build !no_workceptor
+// +build !no_workceptor
+
+package workceptor
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// labelsFileName is the file in a unit dir that holds the labels the unit was submitted with, as JSON.
+const labelsFileName = "labels"
+
+const (
+	// maxLabels is the most labels a unit may have.
+	maxLabels = 32
+	// maxLabelLength is the longest a label key or value may be.
+	maxLabelLength = 63
+)
+
+// labelRegex matches a valid label key or non-empty value: letters, digits, '-', '_' and '.', beginning and
+// ending with a letter or digit.
+var labelRegex = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9._-]*[A-Za-z0-9])?$`)
+
+// ValidateLabels returns an error if labels are not valid unit labels.  Keys and values are at most 63
+// letters, digits, '-', '_' and '.', beginning and ending with a letter or digit.  Values may be empty.
+func ValidateLabels(labels map[string]string) error {
+	if len(labels) > maxLabels {
+		return fmt.Errorf("a work unit may have at most %d labels", maxLabels)
+	}
+	for k, v := range labels {
+		if len(k) > maxLabelLength || !labelRegex.MatchString(k) {
+			return fmt.Errorf("invalid label key %q", k)
+		}
+		if len(v) > maxLabelLength || (v != "" && !labelRegex.MatchString(v)) {
+			return fmt.Errorf("invalid value %q for label %s", v, k)
+		}
+	}
+
+	return nil
+}
+
+// ParseLabels parses labels given as comma-separated key=value pairs, such as "team=infra,env=prod".
+func ParseLabels(s string) (map[string]string, error) {
+	labels := make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("label %q must be in the form key=value", pair)
+		}
+		k := strings.TrimSpace(kv[0])
+		if _, ok := labels[k]; ok {
+			return nil, fmt.Errorf("label %s given more than once", k)
+		}
+		labels[k] = strings.TrimSpace(kv[1])
+	}
+	err := ValidateLabels(labels)
+	if err != nil {
+		return nil, err
+	}
+
+	return labels, nil
+}
+
+// saveLabels records the labels of a unit in its unit dir.  Nothing is written if there are none.
+func saveLabels(unitDir string, labels map[string]string) error {
+	if len(labels) == 0 {
+		return nil
+	}
+	data, err := json.Marshal(labels)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path.Join(unitDir, labelsFileName), data, 0o600)
+}
+
+// readLabels reads the labels of a unit dir.  Returns nil if the unit has no labels.
+func readLabels(unitDir string) (map[string]string, error) {
+	data, err := ioutil.ReadFile(path.Join(unitDir, labelsFileName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	labels := make(map[string]string)
+	err = json.Unmarshal(data, &labels)
+	if err != nil {
+		return nil, err
+	}
+
+	return labels, nil
+}
+
+// labelsMatch returns true if labels has every key of selector, with the same value.
+func labelsMatch(labels map[string]string, selector map[string]string) bool {
+	for k, v := range selector {
+		lv, ok := labels[k]
+		if !ok || lv != v {
+			return false
+		}
+	}
+
+	return true
+}
+
+// UnitLabels returns the labels a unit of work was submitted with, or nil if it has none.
+func (w *Workceptor) UnitLabels(unitID string) (map[string]string, error) {
+	return readLabels(path.Join(w.dataDir, unitID))
+}
+
+// ListUnitIDsWithLabels returns the IDs of the known units of work that have all the labels in selector.
+func (w *Workceptor) ListUnitIDsWithLabels(selector map[string]string) []string {
+	unitIDs := make([]string, 0)
+	for _, unitID := range w.ListKnownUnitIDs() {
+		labels, err := w.UnitLabels(unitID)
+		if err != nil {
+			continue
+		}
+		if labelsMatch(labels, selector) {
+			unitIDs = append(unitIDs, unitID)
+		}
+	}
+
+	return unitIDs
+}
@@ -0,0 +1,202 @@
+//go:build !no_workceptor
+// +build !no_workceptor
+
+package workceptor
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/ansible/receptor/pkg/netceptor"
+)
+
+// submitOperations supplies a fixed input to a work submit command.
+type submitOperations struct {
+	ctx   context.Context
+	stdin string
+}
+
+func (so *submitOperations) BridgeConn(message string, bc io.ReadWriteCloser, bcName string) error {
+	return nil
+}
+
+func (so *submitOperations) ReadFromConn(message string, out io.Writer) error {
+	_, err := io.Copy(out, strings.NewReader(so.stdin))
+
+	return err
+}
+
+func (so *submitOperations) WriteToConn(message string, in chan []byte) error {
+	return nil
+}
+
+func (so *submitOperations) Close() error {
+	return nil
+}
+
+func (so *submitOperations) Context() context.Context {
+	return so.ctx
+}
+
+func TestParseLabels(t *testing.T) {
+	labels, err := ParseLabels("team=infra, env=prod,empty=")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{"team": "infra", "env": "prod", "empty": ""}
+	if !reflect.DeepEqual(labels, expected) {
+		t.Fatalf("expected %v, got %v", expected, labels)
+	}
+	bad := []string{
+		"team",
+		"team=infra,team=ops",
+		"=infra",
+		"-team=infra",
+		"team=in fra",
+		"team=infra/ops",
+		strings.Repeat("k", maxLabelLength+1) + "=v",
+		"k=" + strings.Repeat("v", maxLabelLength+1),
+	}
+	for _, s := range bad {
+		if _, err := ParseLabels(s); err == nil {
+			t.Errorf("expected labels %q to be refused", s)
+		}
+	}
+	tooMany := make([]string, 0)
+	for i := 0; i <= maxLabels; i++ {
+		tooMany = append(tooMany, strings.Repeat("k", i+1)+"=v")
+	}
+	if _, err := ParseLabels(strings.Join(tooMany, ",")); err == nil {
+		t.Error("expected too many labels to be refused")
+	}
+}
+
+func TestWorkListLabels(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "receptor-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	nc := netceptor.New(ctx, "node1", nil)
+	w, err := New(ctx, nc, tmpdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	registerTestWorkTypes(t, w)
+	ct := &workceptorCommandType{w: w}
+	submit := func(labels string) string {
+		config := map[string]interface{}{
+			"subcommand": "submit",
+			"node":       "localhost",
+			"worktype":   "echo",
+		}
+		if labels != "" {
+			config["labels"] = labels
+		}
+		cc, err := ct.InitFromJSON(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfr, err := cc.ControlFunc(nc, &submitOperations{ctx: ctx, stdin: "input"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return cfr["unitid"].(string)
+	}
+	list := func(command string) []string {
+		cc, err := ct.InitFromString(command)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfr, err := cc.ControlFunc(nc, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids := make([]string, 0)
+		for id := range cfr {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+
+		return ids
+	}
+	infraProd := submit("team=infra,env=prod")
+	infraDev := submit("team=infra,env=dev")
+	ops := submit("team=ops")
+	unlabeled := submit("")
+
+	expected := []string{infraProd, infraDev}
+	sort.Strings(expected)
+	if ids := list("list --label team=infra"); !reflect.DeepEqual(ids, expected) {
+		t.Errorf("expected units %v for team=infra, got %v", expected, ids)
+	}
+	if ids := list("list --label team=infra --label env=prod"); !reflect.DeepEqual(ids, []string{infraProd}) {
+		t.Errorf("expected unit %s for team=infra,env=prod, got %v", infraProd, ids)
+	}
+	if ids := list("list " + ops + " --label team=infra"); len(ids) != 0 {
+		t.Errorf("expected no units for a unit ID without the label, got %v", ids)
+	}
+	if ids := list("list --label team=nobody"); len(ids) != 0 {
+		t.Errorf("expected no units for an unused label, got %v", ids)
+	}
+	if ids := list("list"); len(ids) != 4 {
+		t.Errorf("expected all 4 units without a label filter, got %v", ids)
+	}
+
+	// Labels are listed with the unit, and survive a restart
+	w2, err := New(ctx, nc, tmpdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	registerTestWorkTypes(t, w2)
+	cc, err := (&workceptorCommandType{w: w2}).InitFromJSON(map[string]interface{}{
+		"subcommand": "list",
+		"labels":     "team=ops",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfr, err := cc.ControlFunc(nc, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, ok := cfr[ops].(map[string]interface{})
+	if len(cfr) != 1 || !ok {
+		t.Fatalf("expected only unit %s for team=ops after restart, got %v", ops, cfr)
+	}
+	if !reflect.DeepEqual(status["Labels"], map[string]string{"team": "ops"}) {
+		t.Errorf("expected the labels of unit %s to be listed, got %v", ops, status["Labels"])
+	}
+	if labels, err := w2.UnitLabels(unlabeled); err != nil || labels != nil {
+		t.Errorf("expected no labels for unit %s, got %v %v", unlabeled, labels, err)
+	}
+
+	// Invalid labels are refused before a unit is created
+	cc, err = ct.InitFromJSON(map[string]interface{}{
+		"subcommand": "submit",
+		"node":       "localhost",
+		"worktype":   "echo",
+		"labels":     "team=in fra",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cc.ControlFunc(nc, &submitOperations{ctx: ctx}); err == nil {
+		t.Error("expected invalid labels to be refused")
+	}
+	if units := w.ListKnownUnitIDs(); len(units) != 4 {
+		t.Errorf("expected no unit to be created for invalid labels, got %v", units)
+	}
+	if _, err := ct.InitFromString("list --label"); err == nil {
+		t.Error("expected --label without a value to be refused")
+	}
+}
@@ -0,0 +1,96 @@
+//go:build !no_workceptor
+// +build !no_workceptor
+
+package workceptor
+
+import (
+	"fmt"
+
+	"github.com/ansible/receptor/pkg/utils"
+	"github.com/ghjm/cmdline"
+)
+
+// DefaultMaxParamsSize is the default limit on the total size of the params of a submitted work unit.
+const DefaultMaxParamsSize = 1 << 20
+
+// ErrParamsTooLarge is returned when work is refused because its params are larger than the limit.
+var ErrParamsTooLarge = fmt.Errorf("work params are too large")
+
+// ParamsSize returns the size of a work unit's params, as the total length of their names and values.
+func ParamsSize(params map[string]string) int64 {
+	size := int64(0)
+	for k, v := range params {
+		size += int64(len(k) + len(v))
+	}
+
+	return size
+}
+
+// SetMaxParamsSize limits the total size of the params of work units submitted to this node, so that
+// large submissions cannot bloat the unit's stored status.  The limit applies to local units, and to
+// remote units before they are sent to the remote node, which also applies its own limit.  A limit of zero
+// means unlimited.
+func (w *Workceptor) SetMaxParamsSize(size int64) error {
+	if size < 0 {
+		return fmt.Errorf("maximum params size must not be negative")
+	}
+	w.paramsLock.Lock()
+	defer w.paramsLock.Unlock()
+	w.maxParamsSize = size
+
+	return nil
+}
+
+// MaxParamsSize returns the limit on the total size of the params of a submitted work unit, or 0 if there
+// is no limit.
+func (w *Workceptor) MaxParamsSize() int64 {
+	w.paramsLock.RLock()
+	defer w.paramsLock.RUnlock()
+
+	return w.maxParamsSize
+}
+
+// checkParamsSize returns an error if params are larger than the limit.
+func (w *Workceptor) checkParamsSize(params map[string]string) error {
+	limit := w.MaxParamsSize()
+	if limit <= 0 {
+		return nil
+	}
+	if size := ParamsSize(params); size > limit {
+		return fmt.Errorf("%w: %d bytes, the limit is %d bytes", ErrParamsTooLarge, size, limit)
+	}
+
+	return nil
+}
+
+// **************************************************************************
+// Command line
+// **************************************************************************
+
+// workParamsCfg is the cmdline configuration object for limits on work params.
+type workParamsCfg struct {
+	MaxSize string `description:"Largest total size of the params of a submitted work unit, such as 64K or 1M (0 for no limit)" default:"1M"`
+}
+
+// Prepare verifies the parameters are correct.
+func (cfg workParamsCfg) Prepare() error {
+	_, err := ParseByteSize(cfg.MaxSize)
+
+	return err
+}
+
+// Run runs the action.
+func (cfg workParamsCfg) Run() error {
+	utils.RecordEffectiveConfig("work-params", cfg)
+	size, err := ParseByteSize(cfg.MaxSize)
+	if err != nil {
+		return err
+	}
+
+	return MainInstance.SetMaxParamsSize(size)
+}
+
+func init() {
+	cmdline.RegisterConfigTypeForApp("receptor-workers",
+		"work-params", "Limits on the params of submitted work", workParamsCfg{}, cmdline.Singleton, cmdline.Section(workersSection))
+}
@@ -0,0 +1,86 @@
+//go:build !no_workceptor
+// +build !no_workceptor
+
+package workceptor
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/ansible/receptor/pkg/netceptor"
+)
+
+func TestMaxParamsSize(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "receptor-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	nc := netceptor.New(ctx, "node1", nil)
+	w, err := New(ctx, nc, tmpdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	registerTestWorkTypes(t, w)
+	if w.MaxParamsSize() != DefaultMaxParamsSize {
+		t.Fatalf("expected the default limit of %d bytes, got %d", DefaultMaxParamsSize, w.MaxParamsSize())
+	}
+	if err := w.SetMaxParamsSize(-1); err == nil {
+		t.Fatal("expected a negative limit to be refused")
+	}
+	if err := w.SetMaxParamsSize(100); err != nil {
+		t.Fatal(err)
+	}
+
+	// The name "suffix" counts towards the size along with the value
+	under := map[string]string{"suffix": strings.Repeat("x", 94)}
+	over := map[string]string{"suffix": strings.Repeat("x", 95)}
+	if ParamsSize(under) != 100 || ParamsSize(over) != 101 {
+		t.Fatalf("unexpected params sizes %d and %d", ParamsSize(under), ParamsSize(over))
+	}
+
+	unit, err := w.AllocateUnit("echo", under)
+	if err != nil {
+		t.Fatalf("expected params at the limit to be accepted, got %s", err)
+	}
+	defer unit.Release(true)
+	unitCount := len(w.ListKnownUnitIDs())
+	_, err = w.AllocateUnit("echo", over)
+	if !errors.Is(err, ErrParamsTooLarge) {
+		t.Fatalf("expected params over the limit to be refused, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "101 bytes, the limit is 100 bytes") {
+		t.Errorf("expected the error to give the size and limit, got %s", err)
+	}
+
+	// Remote units are checked the same way, before they are created
+	remote, err := w.AllocateRemoteUnit("node2", "echo", "", "", under)
+	if err != nil {
+		t.Fatalf("expected remote params at the limit to be accepted, got %s", err)
+	}
+	defer remote.Release(true)
+	unitCount++
+	_, err = w.AllocateRemoteUnit("node2", "echo", "", "", over)
+	if !errors.Is(err, ErrParamsTooLarge) {
+		t.Fatalf("expected remote params over the limit to be refused, got %v", err)
+	}
+	if n := len(w.ListKnownUnitIDs()); n != unitCount {
+		t.Fatalf("expected no unit to be created for refused params, got %d units instead of %d", n, unitCount)
+	}
+
+	// Zero removes the limit
+	if err := w.SetMaxParamsSize(0); err != nil {
+		t.Fatal(err)
+	}
+	big, err := w.AllocateUnit("echo", map[string]string{"suffix": strings.Repeat("x", 10000)})
+	if err != nil {
+		t.Fatalf("expected any params to be accepted with no limit, got %s", err)
+	}
+	defer big.Release(true)
+}
@@ -0,0 +1,261 @@
+//go:build !no_workceptor
+// +build !no_workceptor
+
+package workceptor
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ParamSchema validates the params of a work submission.  It is a JSON schema for an object whose values
+// are all strings, supporting the keywords that make sense for work params: type, properties, required and
+// additionalProperties for the object, and type, description, enum, pattern, minLength, maxLength, minimum
+// and maximum for each param.  A param's type says what its string value must parse as, and may be string,
+// integer, number or boolean.  Other keywords are refused, rather than silently not enforced.
+type ParamSchema struct {
+	properties           map[string]*paramProperty
+	required             []string
+	additionalProperties bool
+}
+
+// paramProperty is the schema of a single param.
+type paramProperty struct {
+	Type        string        `json:"type"`
+	Description string        `json:"description"`
+	Enum        []interface{} `json:"enum"`
+	Pattern     string        `json:"pattern"`
+	MinLength   *int          `json:"minLength"`
+	MaxLength   *int          `json:"maxLength"`
+	Minimum     *float64      `json:"minimum"`
+	Maximum     *float64      `json:"maximum"`
+	pattern     *regexp.Regexp
+	enum        []string
+}
+
+// paramSchemaKeywords are the keywords allowed at the top level of a param schema.
+var paramSchemaKeywords = map[string]bool{
+	"$schema": true, "$id": true, "title": true, "description": true,
+	"type": true, "properties": true, "required": true, "additionalProperties": true,
+}
+
+// paramPropertyKeywords are the keywords allowed in the schema of a param.
+var paramPropertyKeywords = map[string]bool{
+	"title": true, "description": true, "type": true, "enum": true, "pattern": true,
+	"minLength": true, "maxLength": true, "minimum": true, "maximum": true,
+}
+
+// checkKeywords returns an error if a schema object has a keyword that is not allowed.
+func checkKeywords(raw map[string]json.RawMessage, allowed map[string]bool, where string) error {
+	for k := range raw {
+		if !allowed[k] {
+			return fmt.Errorf("unsupported keyword %s in %s", k, where)
+		}
+	}
+
+	return nil
+}
+
+// ParseParamSchema parses a JSON param schema.
+func ParseParamSchema(data []byte) (*ParamSchema, error) {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("error parsing param schema: %w", err)
+	}
+	if err := checkKeywords(raw, paramSchemaKeywords, "param schema"); err != nil {
+		return nil, err
+	}
+	var top struct {
+		Type                 string                     `json:"type"`
+		Properties           map[string]json.RawMessage `json:"properties"`
+		Required             []string                   `json:"required"`
+		AdditionalProperties *bool                      `json:"additionalProperties"`
+	}
+	if err := json.Unmarshal(data, &top); err != nil {
+		return nil, fmt.Errorf("error parsing param schema: %w", err)
+	}
+	if top.Type != "" && top.Type != "object" {
+		return nil, fmt.Errorf("param schema type must be object, not %s", top.Type)
+	}
+	ps := &ParamSchema{
+		properties:           make(map[string]*paramProperty),
+		required:             top.Required,
+		additionalProperties: top.AdditionalProperties == nil || *top.AdditionalProperties,
+	}
+	for name, propData := range top.Properties {
+		prop, err := parseParamProperty(name, propData)
+		if err != nil {
+			return nil, err
+		}
+		ps.properties[name] = prop
+	}
+	for _, name := range ps.required {
+		if _, ok := ps.properties[name]; !ok && !ps.additionalProperties {
+			return nil, fmt.Errorf("required param %s is not allowed by the param schema", name)
+		}
+	}
+
+	return ps, nil
+}
+
+// parseParamProperty parses the schema of a single param.
+func parseParamProperty(name string, data json.RawMessage) (*paramProperty, error) {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("error parsing schema of param %s: %w", name, err)
+	}
+	if err := checkKeywords(raw, paramPropertyKeywords, "param "+name); err != nil {
+		return nil, err
+	}
+	prop := &paramProperty{}
+	if err := json.Unmarshal(data, prop); err != nil {
+		return nil, fmt.Errorf("error parsing schema of param %s: %w", name, err)
+	}
+	switch prop.Type {
+	case "":
+		prop.Type = "string"
+	case "string", "integer", "number", "boolean":
+	default:
+		return nil, fmt.Errorf("param %s has unsupported type %s", name, prop.Type)
+	}
+	if prop.Pattern != "" {
+		var err error
+		prop.pattern, err = regexp.Compile(prop.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("param %s has invalid pattern: %w", name, err)
+		}
+	}
+	for _, v := range prop.Enum {
+		switch vt := v.(type) {
+		case string:
+			prop.enum = append(prop.enum, vt)
+		case float64:
+			prop.enum = append(prop.enum, strconv.FormatFloat(vt, 'f', -1, 64))
+		case bool:
+			prop.enum = append(prop.enum, strconv.FormatBool(vt))
+		default:
+			return nil, fmt.Errorf("param %s has an enum value that is not a string, number or boolean", name)
+		}
+	}
+
+	return prop, nil
+}
+
+// LoadParamSchema reads a JSON param schema from a file.
+func LoadParamSchema(filename string) (*ParamSchema, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("error reading param schema: %w", err)
+	}
+	ps, err := ParseParamSchema(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+
+	return ps, nil
+}
+
+// Params returns the names of the params the schema describes, sorted.
+func (ps *ParamSchema) Params() []string {
+	names := make([]string, 0, len(ps.properties))
+	for name := range ps.properties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// Validate checks params against the schema, and returns an error describing every problem found.
+func (ps *ParamSchema) Validate(params map[string]string) error {
+	problems := make([]string, 0)
+	for _, name := range ps.required {
+		if _, ok := params[name]; !ok {
+			problems = append(problems, fmt.Sprintf("missing required param %s", name))
+		}
+	}
+	names := make([]string, 0, len(params))
+	for name := range params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		prop, ok := ps.properties[name]
+		if !ok {
+			if !ps.additionalProperties {
+				problems = append(problems, fmt.Sprintf("unknown param %s", name))
+			}
+
+			continue
+		}
+		shown := strconv.Quote(params[name])
+		if strings.HasPrefix(strings.ToLower(name), "secret_") {
+			shown = "the value"
+		}
+		if err := prop.validate(params[name], shown); err != nil {
+			problems = append(problems, fmt.Sprintf("param %s: %s", name, err))
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid params: %s", strings.Join(problems, "; "))
+	}
+
+	return nil
+}
+
+// validate checks the value of a param against its schema.  Errors show the value as shown, so that the
+// values of secret params can be kept out of them.
+func (prop *paramProperty) validate(value string, shown string) error {
+	var number float64
+	switch prop.Type {
+	case "integer":
+		i, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("%s is not an integer", shown)
+		}
+		number = float64(i)
+	case "number":
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("%s is not a number", shown)
+		}
+		number = f
+	case "boolean":
+		if _, err := strconv.ParseBool(value); err != nil {
+			return fmt.Errorf("%s is not a boolean", shown)
+		}
+	}
+	if prop.Type == "integer" || prop.Type == "number" {
+		if prop.Minimum != nil && number < *prop.Minimum {
+			return fmt.Errorf("%s is less than the minimum of %v", shown, *prop.Minimum)
+		}
+		if prop.Maximum != nil && number > *prop.Maximum {
+			return fmt.Errorf("%s is more than the maximum of %v", shown, *prop.Maximum)
+		}
+	}
+	if prop.MinLength != nil && len([]rune(value)) < *prop.MinLength {
+		return fmt.Errorf("%s is shorter than %d characters", shown, *prop.MinLength)
+	}
+	if prop.MaxLength != nil && len([]rune(value)) > *prop.MaxLength {
+		return fmt.Errorf("%s is longer than %d characters", shown, *prop.MaxLength)
+	}
+	if prop.pattern != nil && !prop.pattern.MatchString(value) {
+		return fmt.Errorf("%s does not match the pattern %s", shown, prop.Pattern)
+	}
+	if len(prop.enum) > 0 {
+		for _, allowed := range prop.enum {
+			if value == allowed {
+				return nil
+			}
+		}
+
+		return fmt.Errorf("%s is not one of %s", shown, strings.Join(prop.enum, ", "))
+	}
+
+	return nil
+}
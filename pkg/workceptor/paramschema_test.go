@@ -0,0 +1,133 @@
+//go:build !no_workceptor
+// +build !no_workceptor
+
+package workceptor
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/ansible/receptor/pkg/netceptor"
+)
+
+const testParamSchema = `{
+	"$schema": "https://json-schema.org/draft/2020-12/schema",
+	"type": "object",
+	"properties": {
+		"suffix": {"type": "string", "maxLength": 10, "description": "Text to add to the output"},
+		"count": {"type": "integer", "minimum": 1, "maximum": 5},
+		"mode": {"enum": ["fast", "slow"]},
+		"verbose": {"type": "boolean"},
+		"secret_token": {"pattern": "^tok-"}
+	},
+	"required": ["count"],
+	"additionalProperties": false
+}`
+
+func TestParamSchemaValidate(t *testing.T) {
+	ps, err := ParseParamSchema([]byte(testParamSchema))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, params := range []map[string]string{
+		{"count": "1"},
+		{"count": "5", "suffix": "done", "mode": "slow", "verbose": "true", "secret_token": "tok-123"},
+	} {
+		if err := ps.Validate(params); err != nil {
+			t.Fatalf("expected %v to be valid, got %s", params, err)
+		}
+	}
+	for _, tc := range []struct {
+		params   map[string]string
+		expected []string
+	}{
+		{map[string]string{}, []string{"missing required param count"}},
+		{map[string]string{"count": "two"}, []string{`param count: "two" is not an integer`}},
+		{map[string]string{"count": "9"}, []string{`param count: "9" is more than the maximum of 5`}},
+		{map[string]string{"count": "1", "mode": "medium"}, []string{`param mode: "medium" is not one of fast, slow`}},
+		{map[string]string{"count": "1", "verbose": "maybe"}, []string{`param verbose: "maybe" is not a boolean`}},
+		{map[string]string{"count": "1", "suffix": "far too long a suffix"}, []string{"is longer than 10 characters"}},
+		{map[string]string{"count": "1", "sufix": "typo"}, []string{"unknown param sufix"}},
+		{map[string]string{"mode": "medium", "extra": "x"}, []string{
+			"missing required param count", "unknown param extra", "param mode:",
+		}},
+	} {
+		err := ps.Validate(tc.params)
+		if err == nil {
+			t.Fatalf("expected %v to be invalid", tc.params)
+		}
+		for _, expected := range tc.expected {
+			if !strings.Contains(err.Error(), expected) {
+				t.Fatalf("expected the error for %v to contain %q, got %s", tc.params, expected, err)
+			}
+		}
+	}
+
+	// The values of secret params are not repeated in errors
+	err = ps.Validate(map[string]string{"count": "1", "secret_token": "hunter2"})
+	if err == nil || strings.Contains(err.Error(), "hunter2") {
+		t.Fatalf("expected an error without the secret value, got %v", err)
+	}
+}
+
+func TestParseParamSchemaErrors(t *testing.T) {
+	for _, schema := range []string{
+		`not json`,
+		`{"type": "array"}`,
+		`{"properties": {"count": {"type": "object"}}}`,
+		`{"properties": {"count": {"pattern": "("}}}`,
+		`{"properties": {"count": {"format": "uri"}}}`,
+		`{"oneOf": []}`,
+		`{"properties": {}, "required": ["count"], "additionalProperties": false}`,
+	} {
+		if _, err := ParseParamSchema([]byte(schema)); err == nil {
+			t.Fatalf("expected schema %s to be refused", schema)
+		}
+	}
+}
+
+func TestAllocateUnitParamSchema(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "receptor-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	w, err := New(ctx, netceptor.New(ctx, "node1", nil), tmpdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.RegisterWorker("echo", newEchoWorker); err != nil {
+		t.Fatal(err)
+	}
+	ps, err := ParseParamSchema([]byte(testParamSchema))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.SetWorkTypeParamSchema("echo", ps); err != nil {
+		t.Fatal(err)
+	}
+	if params := w.WorkTypes()["echo"].Params; len(params) != 5 || params[0] != "count" {
+		t.Fatalf("expected the schema's params to be declared, got %v", params)
+	}
+
+	// Invalid params are refused before a unit is created
+	_, err = w.AllocateUnit("echo", map[string]string{"count": "0"})
+	if err == nil || !strings.Contains(err.Error(), `param count: "0" is less than the minimum of 1`) {
+		t.Fatalf("expected a descriptive error, got %v", err)
+	}
+	if units := w.ListKnownUnitIDs(); len(units) != 0 {
+		t.Fatalf("expected no units to be created, got %v", units)
+	}
+	unit, err := w.AllocateUnit("echo", map[string]string{"count": "2", "suffix": "!"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if units := w.ListKnownUnitIDs(); len(units) != 1 || units[0] != unit.ID() {
+		t.Fatalf("expected unit %s to be created, got %v", unit.ID(), units)
+	}
+}
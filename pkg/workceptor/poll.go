@@ -0,0 +1,143 @@
+//go:build !no_workceptor
+// +build !no_workceptor
+
+package workceptor
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/ansible/receptor/pkg/logger"
+	"github.com/ansible/receptor/pkg/utils"
+)
+
+// remotePollTimeout is how long to wait for a remote node to return the status of a polled unit.
+const remotePollTimeout = 10 * time.Second
+
+// errRemoteUnitGone is returned when the node running a remote unit no longer knows about it.
+var errRemoteUnitGone = fmt.Errorf("remote work unit is gone")
+
+// PollUnit returns the status of a unit of work, first asking the node running it for its latest status if
+// the unit is remote, rather than waiting for the status to be copied over in the background.  Polled says
+// whether the remote node answered.  If it could not be reached, the last known status is returned along
+// with PollError.  Local units are always up to date, so they are not polled.
+func (w *Workceptor) PollUnit(ctx context.Context, unitID string) (map[string]interface{}, error) {
+	unit, err := w.findUnit(unitID)
+	if err != nil {
+		return nil, err
+	}
+	polled := false
+	var pollErr error
+	if rw, ok := unit.(*remoteUnit); ok {
+		pollErr = rw.pollRemoteStatus(ctx)
+		polled = pollErr == nil || pollErr == errRemoteUnitGone
+	}
+	cfr, err := w.unitStatusForCFR(unitID)
+	if err != nil {
+		return nil, err
+	}
+	cfr["Polled"] = polled
+	if pollErr != nil && pollErr != errRemoteUnitGone {
+		cfr["PollError"] = pollErr.Error()
+	}
+
+	return cfr, nil
+}
+
+// pollRemoteStatus makes a single attempt to fetch the status of a remote unit from the node running it,
+// and updates the local status from it.
+func (rw *remoteUnit) pollRemoteStatus(ctx context.Context) error {
+	red, ok := rw.Status().ExtraData.(*remoteExtraData)
+	if !ok {
+		return fmt.Errorf("remote ExtraData missing")
+	}
+	if !red.RemoteStarted || red.RemoteUnitID == "" {
+		return fmt.Errorf("work has not been started on node %s", red.RemoteNode)
+	}
+	ctx, cancel := context.WithTimeout(ctx, remotePollTimeout)
+	defer cancel()
+	err := rw.connectAndRun(ctx, func(ctx context.Context, conn net.Conn, reader *bufio.Reader) error {
+		defer conn.Close()
+
+		return rw.queryRemoteStatus(ctx, conn, reader, red.RemoteUnitID, red.LocalReleased)
+	})
+	if err != nil && err != errRemoteUnitGone {
+		return fmt.Errorf("could not poll node %s: %s", red.RemoteNode, err)
+	}
+
+	return err
+}
+
+// queryRemoteStatus asks the remote node for the status of a unit over a control connection, and updates the
+// local status from the response.  A unit that is gone from the remote node is marked failed, unless it is
+// being released.
+func (rw *remoteUnit) queryRemoteStatus(ctx context.Context, conn net.Conn, reader *bufio.Reader, remoteUnitID string,
+	forRelease bool) error {
+	_, err := conn.Write([]byte(fmt.Sprintf("work status %s\n", remoteUnitID)))
+	if err != nil {
+		return err
+	}
+	response, err := utils.ReadStringContext(ctx, reader, '\n')
+	if err != nil {
+		return err
+	}
+	if strings.HasPrefix(response, "ERROR") {
+		if strings.Contains(response, "unknown work unit") {
+			if !forRelease {
+				rw.UpdateFullStatus(func(status *StatusFileData) {
+					status.State = WorkStateFailed
+					status.Detail = "Remote work unit is gone"
+				})
+			}
+
+			return errRemoteUnitGone
+		}
+
+		return fmt.Errorf("remote error: %s", strings.TrimSpace(strings.TrimPrefix(response, "ERROR:")))
+	}
+
+	return rw.applyRemoteStatus(response)
+}
+
+// applyRemoteStatus updates the local status and progress of a unit from the remote node's response to a
+// work status command.  The background monitor and work poll can both fetch the status, and their responses
+// can be applied out of order, so a unit that is already complete is never moved back to an earlier state.
+func (rw *remoteUnit) applyRemoteStatus(response string) error {
+	si := StatusFileData{}
+	err := json.Unmarshal([]byte(response), &si)
+	if err != nil {
+		return fmt.Errorf("error unmarshalling JSON: %s", response)
+	}
+	stale := false
+	rw.UpdateFullStatus(func(status *StatusFileData) {
+		if IsComplete(status.State) && !IsComplete(si.State) {
+			stale = true
+
+			return
+		}
+		status.State = si.State
+		status.Detail = si.Detail
+		if si.StdoutSize >= 0 {
+			status.StdoutSize = si.StdoutSize
+		}
+	})
+	if stale {
+		return nil
+	}
+	sp := struct{ Progress *WorkProgress }{}
+	if json.Unmarshal([]byte(response), &sp) == nil && sp.Progress != nil {
+		err = writeProgress(rw.UnitDir(), sp.Progress)
+		if err != nil {
+			logger.Error("Error saving local progress file: %s\n", err)
+		} else if _, err := rw.w.unitProgress(rw.unitID, rw.UnitDir()); err != nil {
+			logger.Debug("Error reading progress of work unit %s: %s\n", rw.unitID, err)
+		}
+	}
+
+	return nil
+}
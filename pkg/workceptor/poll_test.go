@@ -0,0 +1,149 @@
+//go:build !no_workceptor
+// +build !no_workceptor
+
+package workceptor
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// loopbackStatus runs queryRemoteStatus against a fake remote node that answers work status with response.
+func loopbackStatus(t *testing.T, rw *remoteUnit, response string) error {
+	client, server := net.Pipe()
+	defer client.Close()
+	go func() {
+		defer server.Close()
+		request, err := bufio.NewReader(server).ReadString('\n')
+		if err != nil {
+			return
+		}
+		if request != "work status remote1\n" {
+			response = "ERROR: unexpected request " + request
+		}
+		_, _ = server.Write([]byte(response))
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	return rw.queryRemoteStatus(ctx, client, bufio.NewReader(client), "remote1", false)
+}
+
+func TestPollUnit(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	w := newTestWorkceptor(ctx, t)
+	unit, err := w.AllocateUnit("remote", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rw := unit.(*remoteUnit)
+	rw.UpdateFullStatus(func(status *StatusFileData) {
+		status.State = WorkStateRunning
+		status.Detail = "Running"
+		status.StdoutSize = 5
+		ed := status.ExtraData.(*remoteExtraData)
+		ed.RemoteNode = "node2"
+		ed.RemoteWorkType = "echo"
+		ed.RemoteUnitID = "remote1"
+		ed.RemoteStarted = true
+	})
+
+	// The unit has finished on the remote node, but the local status has not caught up yet
+	err = loopbackStatus(t, rw, `{"State": 2, "Detail": "exit status 0", "StdoutSize": 42, "Progress": {"Percent": 100}}`+"\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	status := unit.Status()
+	if status.State != WorkStateSucceeded || status.Detail != "exit status 0" || status.StdoutSize != 42 {
+		t.Fatalf("expected the polled status to be saved, got %+v", status)
+	}
+	progress, err := readProgress(unit.UnitDir())
+	if err != nil || progress == nil || progress.Percent != 100 {
+		t.Fatalf("expected the polled progress to be saved, got %+v (%v)", progress, err)
+	}
+
+	// A late response from before the unit finished does not move it back to running
+	err = loopbackStatus(t, rw, `{"State": 1, "Detail": "Running", "StdoutSize": 5, "Progress": {"Percent": 50}}`+"\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status := unit.Status(); status.State != WorkStateSucceeded || status.StdoutSize != 42 {
+		t.Fatalf("expected the completed status to be kept, got %+v", status)
+	}
+	if progress, err := readProgress(unit.UnitDir()); err != nil || progress == nil || progress.Percent != 100 {
+		t.Fatalf("expected the completed progress to be kept, got %+v (%v)", progress, err)
+	}
+
+	if err := loopbackStatus(t, rw, "ERROR: permission denied\n"); err == nil || !strings.Contains(err.Error(), "permission denied") {
+		t.Fatalf("expected the remote error, got %v", err)
+	}
+	if err := loopbackStatus(t, rw, "ERROR: unknown work unit remote1\n"); err != errRemoteUnitGone {
+		t.Fatalf("expected the remote unit to be gone, got %v", err)
+	}
+	if status := unit.Status(); status.State != WorkStateFailed || status.Detail != "Remote work unit is gone" {
+		t.Fatalf("expected a unit that is gone to be marked failed, got %+v", status)
+	}
+
+	// node2 is not in the mesh, so the last known status is returned with the error
+	pollCtx, pollCancel := context.WithTimeout(ctx, 2*time.Second)
+	defer pollCancel()
+	cfr, err := w.PollUnit(pollCtx, unit.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfr["Polled"] != false || !strings.Contains(cfr["PollError"].(string), "could not poll node node2") ||
+		cfr["State"] != WorkStateFailed {
+		t.Fatalf("expected the unreachable node to be reported with the cached status, got %v", cfr)
+	}
+
+	rw.UpdateFullStatus(func(status *StatusFileData) {
+		status.ExtraData.(*remoteExtraData).RemoteStarted = false
+	})
+	cfr, err = w.PollUnit(ctx, unit.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfr["Polled"] != false || !strings.Contains(cfr["PollError"].(string), "has not been started") {
+		t.Fatalf("expected a unit that was not started not to be polled, got %v", cfr)
+	}
+
+	local, err := w.AllocateUnit("command", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfr, err = w.PollUnit(ctx, local.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cfr["PollError"]; cfr["Polled"] != false || ok {
+		t.Fatalf("expected a local unit to be returned without polling, got %v", cfr)
+	}
+	if _, err := w.PollUnit(ctx, "nonexistent"); err == nil {
+		t.Fatal("expected an error for an unknown unit")
+	}
+}
+
+func TestWorkPollCommand(t *testing.T) {
+	ct := &workceptorCommandType{}
+	cmd, err := ct.InitFromString("poll abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wc := cmd.(*workceptorCommand)
+	if wc.params["unitid"] != "abc" || wc.ReadOnly() {
+		t.Fatalf("expected a poll of abc that is not read-only, got %+v", wc)
+	}
+	if _, err := ct.InitFromJSON(map[string]interface{}{"subcommand": "poll", "unitid": "abc"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, params := range []string{"poll", "poll abc def"} {
+		if _, err := ct.InitFromString(params); err == nil {
+			t.Fatalf("expected %q to be refused", params)
+		}
+	}
+}
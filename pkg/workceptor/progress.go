@@ -0,0 +1,287 @@
+//go:build !no_workceptor
+// +build !no_workceptor
+
+package workceptor
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/ansible/receptor/pkg/logger"
+)
+
+// ProgressFileEnv is the environment variable telling a command worker where to write progress events.
+const ProgressFileEnv = "RECEPTOR_PROGRESS_FILE"
+
+// progressFileName is the file in a unit dir holding progress events, one JSON object per line.
+const progressFileName = "progress"
+
+// maxProgressMilestones is the number of most recent milestones reported in a unit's progress.
+const maxProgressMilestones = 50
+
+// ProgressEvent is a line written to the progress file by a worker, such as
+// {"percent": 40, "milestone": "Gathering facts"}.  Either field may be omitted.
+type ProgressEvent struct {
+	Percent   *float64 `json:"percent,omitempty"`
+	Milestone string   `json:"milestone,omitempty"`
+}
+
+// WorkProgress is the progress of a unit of work, as reported by its worker.
+type WorkProgress struct {
+	Percent    float64
+	Milestone  string
+	Milestones []string
+	Updated    time.Time
+}
+
+// progressEnv returns the environment entry pointing a worker at the progress file of a unit dir.
+func progressEnv(unitdir string) string {
+	return fmt.Sprintf("%s=%s", ProgressFileEnv, path.Join(unitdir, progressFileName))
+}
+
+// progressSubscriberBuffer is how many progress updates are held for a subscriber that is not reading.
+// Further updates are dropped until it catches up.
+const progressSubscriberBuffer = 64
+
+// ProgressUpdate is sent to progress subscribers each time a unit reports new progress.
+type ProgressUpdate struct {
+	UnitID   string
+	Progress WorkProgress
+}
+
+// progressReader follows the progress file of a unit dir, parsing only the lines added since the last read.
+type progressReader struct {
+	filename string
+	file     os.FileInfo
+	offset   int64
+	progress *WorkProgress
+}
+
+// newProgressReader returns a progressReader for the progress file of a unit dir.
+func newProgressReader(unitdir string) *progressReader {
+	return &progressReader{
+		filename: path.Join(unitdir, progressFileName),
+	}
+}
+
+// read parses the lines added to the progress file since the last call, and returns the progress so far and
+// whether it changed.  A file that was replaced or truncated is read again from the start.  The progress is
+// nil if the worker has not reported any.
+func (pr *progressReader) read() (*WorkProgress, bool, error) {
+	file, err := os.Open(pr.filename)
+	if os.IsNotExist(err) {
+		changed := pr.progress != nil
+		pr.file = nil
+		pr.offset = 0
+		pr.progress = nil
+
+		return nil, changed, nil
+	} else if err != nil {
+		return nil, false, err
+	}
+	defer file.Close()
+	stat, err := file.Stat()
+	if err != nil {
+		return nil, false, err
+	}
+	changed := false
+	if pr.file == nil || !os.SameFile(pr.file, stat) || stat.Size() < pr.offset {
+		changed = pr.progress != nil
+		pr.offset = 0
+		pr.progress = nil
+	}
+	pr.file = stat
+	if stat.Size() > pr.offset {
+		if _, err := file.Seek(pr.offset, io.SeekStart); err != nil {
+			return nil, false, err
+		}
+		reader := bufio.NewReader(file)
+		for {
+			line, err := reader.ReadString('\n')
+			if err == io.EOF {
+				// A last line without a newline is only taken once it is a complete event.  Otherwise it is
+				// read again next time, once the worker has finished writing it.
+				if pr.parseLine(line) {
+					pr.offset += int64(len(line))
+					changed = true
+				}
+
+				break
+			} else if err != nil {
+				return nil, false, err
+			}
+			pr.offset += int64(len(line))
+			if pr.parseLine(line) {
+				changed = true
+			}
+		}
+	}
+	if pr.progress != nil && changed {
+		pr.progress.Updated = stat.ModTime()
+	}
+
+	return pr.copyProgress(), changed, nil
+}
+
+// parseLine applies a line of the progress file, returning whether it was a valid progress event.  Lines
+// that are not are ignored.
+func (pr *progressReader) parseLine(line string) bool {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return false
+	}
+	event := ProgressEvent{}
+	if json.Unmarshal([]byte(line), &event) != nil || (event.Percent == nil && event.Milestone == "") {
+		return false
+	}
+	if pr.progress == nil {
+		pr.progress = &WorkProgress{Milestones: make([]string, 0)}
+	}
+	if event.Percent != nil {
+		pr.progress.Percent = *event.Percent
+	}
+	if event.Milestone != "" {
+		pr.progress.Milestone = event.Milestone
+		pr.progress.Milestones = append(pr.progress.Milestones, event.Milestone)
+		if len(pr.progress.Milestones) > maxProgressMilestones {
+			pr.progress.Milestones = pr.progress.Milestones[1:]
+		}
+	}
+
+	return true
+}
+
+// copyProgress returns a copy of the progress read so far, or nil if there is none.
+func (pr *progressReader) copyProgress() *WorkProgress {
+	if pr.progress == nil {
+		return nil
+	}
+	progress := *pr.progress
+	progress.Milestones = append(make([]string, 0, len(pr.progress.Milestones)), pr.progress.Milestones...)
+
+	return &progress
+}
+
+// readProgress parses the whole progress file of a unit dir.  Returns nil if the worker has not reported
+// any progress.  Lines that are not valid progress events are ignored.
+func readProgress(unitdir string) (*WorkProgress, error) {
+	progress, _, err := newProgressReader(unitdir).read()
+
+	return progress, err
+}
+
+// unitProgress returns the progress of a unit, reading only what its worker added to the progress file since
+// the last call.  New progress is sent to the progress subscribers.
+func (w *Workceptor) unitProgress(unitID string, unitdir string) (*WorkProgress, error) {
+	w.progressLock.Lock()
+	defer w.progressLock.Unlock()
+	pr, ok := w.progressReaders[unitID]
+	if !ok {
+		pr = newProgressReader(unitdir)
+		w.progressReaders[unitID] = pr
+	}
+	progress, changed, err := pr.read()
+	if err != nil {
+		return nil, err
+	}
+	if changed && progress != nil {
+		w.notifyProgress(unitID, progress)
+	}
+
+	return progress, nil
+}
+
+// forgetProgress removes the progress reader of a released unit.
+func (w *Workceptor) forgetProgress(unitID string) {
+	w.progressLock.Lock()
+	defer w.progressLock.Unlock()
+	delete(w.progressReaders, unitID)
+}
+
+// notifyProgress sends a progress update to the subscribers.  The caller must hold progressLock.
+func (w *Workceptor) notifyProgress(unitID string, progress *WorkProgress) {
+	for ch := range w.progressSubs {
+		select {
+		case ch <- ProgressUpdate{UnitID: unitID, Progress: *progress}:
+		default:
+			logger.Debug("Dropping progress update for a slow subscriber\n")
+		}
+	}
+}
+
+// SubscribeProgress returns a channel that receives an update each time a unit reports new progress, until
+// ctx is done.  The channel is closed when the subscription ends.
+func (w *Workceptor) SubscribeProgress(ctx context.Context) chan ProgressUpdate {
+	ch := make(chan ProgressUpdate, progressSubscriberBuffer)
+	w.progressLock.Lock()
+	w.progressSubs[ch] = struct{}{}
+	w.progressLock.Unlock()
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-w.ctx.Done():
+		}
+		w.progressLock.Lock()
+		delete(w.progressSubs, ch)
+		w.progressLock.Unlock()
+		close(ch)
+	}()
+
+	return ch
+}
+
+// progressEvents is the event source streaming progress updates to the events command.
+func (w *Workceptor) progressEvents(ctx context.Context) <-chan map[string]interface{} {
+	updates := w.SubscribeProgress(ctx)
+	events := make(chan map[string]interface{})
+	go func() {
+		defer close(events)
+		for update := range updates {
+			ev := map[string]interface{}{
+				"Type":      "work progress",
+				"Time":      update.Progress.Updated,
+				"UnitID":    update.UnitID,
+				"Percent":   update.Progress.Percent,
+				"Milestone": update.Progress.Milestone,
+			}
+			select {
+			case events <- ev:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return events
+}
+
+// writeProgress replaces the progress file of a unit dir with events reproducing the given progress.
+// This is used to carry the progress of a remote unit over to the local unit.
+func writeProgress(unitdir string, progress *WorkProgress) error {
+	var sb strings.Builder
+	enc := json.NewEncoder(&sb)
+	for _, milestone := range progress.Milestones {
+		if err := enc.Encode(ProgressEvent{Milestone: milestone}); err != nil {
+			return err
+		}
+	}
+	percent := progress.Percent
+	if err := enc.Encode(ProgressEvent{Percent: &percent}); err != nil {
+		return err
+	}
+	filename := path.Join(unitdir, progressFileName)
+	tmpFilename := filename + ".tmp"
+	if err := ioutil.WriteFile(tmpFilename, []byte(sb.String()), 0o600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpFilename, filename)
+}
@@ -0,0 +1,182 @@
+//go:build !no_workceptor
+// +build !no_workceptor
+
+package workceptor
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"reflect"
+	"testing"
+
+	"github.com/ansible/receptor/pkg/netceptor"
+)
+
+func TestProgressFromScript(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "receptor-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	nc := netceptor.New(context.Background(), "test", nil)
+	w, err := New(context.Background(), nc, tmpdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = w.RegisterWorker("command", newCommandWorker)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unit, err := w.AllocateUnit("command", make(map[string]string))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfr, err := w.unitStatusForCFR(unit.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cfr["Progress"]; ok {
+		t.Fatal("unit reported progress before any was written")
+	}
+
+	script := `echo '{"milestone": "Gathering facts"}' >> "$RECEPTOR_PROGRESS_FILE"
+echo 'not a progress event' >> "$RECEPTOR_PROGRESS_FILE"
+echo '{"percent": 25}' >> "$RECEPTOR_PROGRESS_FILE"
+echo '{"percent": 60, "milestone": "Installing packages"}' >> "$RECEPTOR_PROGRESS_FILE"`
+	cmd := exec.Command("sh", "-c", script)
+	cmd.Env = append(os.Environ(), progressEnv(unit.UnitDir()))
+	err = cmd.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfr, err = w.unitStatusForCFR(unit.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	progress, ok := cfr["Progress"].(*WorkProgress)
+	if !ok {
+		t.Fatalf("expected progress in status, got %v", cfr)
+	}
+	if progress.Percent != 60 {
+		t.Fatalf("expected 60 percent complete, got %f", progress.Percent)
+	}
+	if progress.Milestone != "Installing packages" {
+		t.Fatalf("unexpected milestone %q", progress.Milestone)
+	}
+	if !reflect.DeepEqual(progress.Milestones, []string{"Gathering facts", "Installing packages"}) {
+		t.Fatalf("unexpected milestones %v", progress.Milestones)
+	}
+}
+
+func TestWriteProgress(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "receptor-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	progress := &WorkProgress{
+		Percent:    80,
+		Milestone:  "b",
+		Milestones: []string{"a", "b"},
+	}
+	err = writeProgress(tmpdir, progress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path.Join(tmpdir, progressFileName+".tmp")); !os.IsNotExist(err) {
+		t.Fatal("temporary progress file was left behind")
+	}
+	read, err := readProgress(tmpdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	read.Updated = progress.Updated
+	if !reflect.DeepEqual(read, progress) {
+		t.Fatalf("expected %+v, got %+v", progress, read)
+	}
+}
+
+func TestProgressIncremental(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "receptor-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	nc := netceptor.New(ctx, "test", nil)
+	w, err := New(ctx, nc, tmpdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	updates := w.SubscribeProgress(ctx)
+	unitdir := path.Join(tmpdir, "unit1")
+	if err := os.MkdirAll(unitdir, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	filename := path.Join(unitdir, progressFileName)
+	appendLine := func(line string) {
+		f, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		if _, err := f.WriteString(line); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	appendLine("{\"milestone\": \"a\"}\n")
+	progress, err := w.unitProgress("unit1", unitdir)
+	if err != nil || progress == nil || progress.Milestone != "a" {
+		t.Fatalf("unexpected progress %+v, %v", progress, err)
+	}
+	if update := <-updates; update.UnitID != "unit1" || update.Progress.Milestone != "a" {
+		t.Fatalf("unexpected update %+v", update)
+	}
+
+	// Only the new lines are read, and nothing is sent when nothing changed
+	pr := w.progressReaders["unit1"]
+	offset := pr.offset
+	if _, err := w.unitProgress("unit1", unitdir); err != nil {
+		t.Fatal(err)
+	}
+	if pr.offset != offset || len(updates) != 0 {
+		t.Fatal("progress was read again without any change")
+	}
+
+	// A partial line waits until the worker finishes writing it
+	appendLine("{\"percent\": 50, \"mile")
+	progress, err = w.unitProgress("unit1", unitdir)
+	if err != nil || progress.Percent != 0 || len(updates) != 0 {
+		t.Fatalf("partial line was read: %+v, %v", progress, err)
+	}
+	appendLine("stone\": \"b\"}\n")
+	progress, err = w.unitProgress("unit1", unitdir)
+	if err != nil || progress.Percent != 50 || !reflect.DeepEqual(progress.Milestones, []string{"a", "b"}) {
+		t.Fatalf("unexpected progress %+v, %v", progress, err)
+	}
+	if update := <-updates; update.Progress.Percent != 50 {
+		t.Fatalf("unexpected update %+v", update)
+	}
+
+	// A replaced file is read from the start
+	err = writeProgress(unitdir, &WorkProgress{Percent: 90, Milestones: []string{"c"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	progress, err = w.unitProgress("unit1", unitdir)
+	if err != nil || progress.Percent != 90 || !reflect.DeepEqual(progress.Milestones, []string{"c"}) {
+		t.Fatalf("unexpected progress %+v, %v", progress, err)
+	}
+
+	w.forgetProgress("unit1")
+	if _, ok := w.progressReaders["unit1"]; ok {
+		t.Fatal("progress reader was kept after the unit was released")
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"os/exec"
 
+	"github.com/ansible/receptor/pkg/utils"
 	"github.com/ghjm/cmdline"
 )
 
@@ -69,9 +70,13 @@ func (cfg workPythonCfg) newWorker(w *Workceptor, unitID string, workType string
 
 // Run runs the action.
 func (cfg workPythonCfg) Run() error {
+	utils.RecordEffectiveConfig("work-python", cfg)
 	err := MainInstance.RegisterWorker(cfg.WorkType, cfg.newWorker)
+	if err != nil {
+		return err
+	}
 
-	return err
+	return MainInstance.SetWorkTypeParams(cfg.WorkType, commandRuntimeParams(false))
 }
 
 func init() {
@@ -106,5 +111,9 @@ func (p Python) setup(wc *Workceptor) error {
 		return cw
 	}
 
-	return wc.RegisterWorker(p.WorkType, factory)
+	if err := wc.RegisterWorker(p.WorkType, factory); err != nil {
+		return err
+	}
+
+	return wc.SetWorkTypeParams(p.WorkType, commandRuntimeParams(false))
 }
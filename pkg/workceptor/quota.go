@@ -0,0 +1,249 @@
+//go:build !no_workceptor
+// +build !no_workceptor
+
+package workceptor
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ansible/receptor/pkg/logger"
+	"github.com/ansible/receptor/pkg/utils"
+	"github.com/ghjm/cmdline"
+)
+
+// Disk quota policies.
+const (
+	// QuotaPolicyReject refuses new work while the data dir is over quota.
+	QuotaPolicyReject = "reject"
+	// QuotaPolicyEvict deletes the oldest completed units to make room for new work.
+	QuotaPolicyEvict = "evict"
+)
+
+// quotaHighWater is the fraction of the disk quota at which the quota policy engages.
+const quotaHighWater = 0.9
+
+// ErrDiskQuota is returned when new work is refused because the data dir is over its disk quota.
+var ErrDiskQuota = fmt.Errorf("work data dir is over its disk quota")
+
+// ParseByteSize parses a size in bytes, with an optional K, M, G or T (binary) suffix.
+func ParseByteSize(s string) (int64, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	s = strings.TrimSuffix(strings.TrimSuffix(s, "B"), "I")
+	multiplier := int64(1)
+	if s != "" {
+		switch s[len(s)-1] {
+		case 'K':
+			multiplier = 1 << 10
+		case 'M':
+			multiplier = 1 << 20
+		case 'G':
+			multiplier = 1 << 30
+		case 'T':
+			multiplier = 1 << 40
+		}
+		if multiplier > 1 {
+			s = s[:len(s)-1]
+		}
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+
+	return n * multiplier, nil
+}
+
+// SetDiskQuota limits the total size of the work data dir.  A limit of zero means unlimited.
+func (w *Workceptor) SetDiskQuota(limit int64, policy string) error {
+	if policy != QuotaPolicyReject && policy != QuotaPolicyEvict {
+		return fmt.Errorf("unknown disk quota policy %s: must be %s or %s", policy, QuotaPolicyReject, QuotaPolicyEvict)
+	}
+	w.quotaLock.Lock()
+	defer w.quotaLock.Unlock()
+	w.diskQuota = limit
+	w.quotaPolicy = policy
+
+	return nil
+}
+
+// DiskUsage returns the total size of the work units known to be in the data dir.
+func (w *Workceptor) DiskUsage() int64 {
+	w.quotaLock.Lock()
+	defer w.quotaLock.Unlock()
+
+	return w.diskUsage
+}
+
+// unitDirSize returns the total size of the files in a unit dir.  Unit dirs are flat, so this is not recursive.
+func unitDirSize(unitdir string) (int64, error) {
+	files, err := ioutil.ReadDir(unitdir)
+	if err != nil {
+		return 0, err
+	}
+	var size int64
+	for _, fi := range files {
+		if fi.Mode().IsRegular() {
+			size += fi.Size()
+		}
+	}
+
+	return size, nil
+}
+
+// overQuota returns true if usage has reached the point where the quota policy engages.
+// Must be called with quotaLock held.
+func (w *Workceptor) overQuota() bool {
+	return w.diskQuota > 0 && float64(w.diskUsage) >= float64(w.diskQuota)*quotaHighWater
+}
+
+// updateUnitUsage re-measures a single unit dir and updates the total disk usage.
+func (w *Workceptor) updateUnitUsage(unitID string, unitdir string) {
+	size, err := unitDirSize(unitdir)
+	if os.IsNotExist(err) {
+		// The unit has been released
+		w.forgetUnitUsage(unitID)
+
+		return
+	}
+	w.quotaLock.Lock()
+	w.diskUsage += size - w.unitUsage[unitID]
+	w.unitUsage[unitID] = size
+	over := w.overQuota()
+	warn := over && !w.quotaWarned
+	evict := over && w.quotaPolicy == QuotaPolicyEvict && !w.evicting
+	w.quotaWarned = over
+	if evict {
+		w.evicting = true
+	}
+	usage, quota := w.diskUsage, w.diskQuota
+	w.quotaLock.Unlock()
+	if warn {
+		logger.Warning("Work data dir is using %d of its %d byte disk quota\n", usage, quota)
+	}
+	if evict {
+		go func() {
+			w.evictCompletedUnits()
+			w.quotaLock.Lock()
+			w.evicting = false
+			w.quotaLock.Unlock()
+		}()
+	}
+}
+
+// forgetUnitUsage removes a deleted unit from the total disk usage.
+func (w *Workceptor) forgetUnitUsage(unitID string) {
+	w.quotaLock.Lock()
+	defer w.quotaLock.Unlock()
+	w.diskUsage -= w.unitUsage[unitID]
+	delete(w.unitUsage, unitID)
+	if !w.overQuota() {
+		w.quotaWarned = false
+	}
+}
+
+// evictCompletedUnits deletes completed units, oldest first, until usage is under the quota policy threshold.
+// Units that are still running are never evicted.
+func (w *Workceptor) evictCompletedUnits() {
+	type candidate struct {
+		unit    WorkUnit
+		modTime time.Time
+	}
+	candidates := make([]candidate, 0)
+	w.activeUnitsLock.RLock()
+	for _, unit := range w.activeUnits {
+		if !IsComplete(unit.Status().State) {
+			continue
+		}
+		fi, err := os.Stat(path.Join(unit.UnitDir(), "status"))
+		if err != nil {
+			continue
+		}
+		candidates = append(candidates, candidate{unit: unit, modTime: fi.ModTime()})
+	}
+	w.activeUnitsLock.RUnlock()
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].modTime.Before(candidates[j].modTime)
+	})
+	for _, c := range candidates {
+		w.quotaLock.Lock()
+		over := w.overQuota()
+		w.quotaLock.Unlock()
+		if !over {
+			return
+		}
+		logger.Info("Evicting completed work unit %s to stay within disk quota\n", c.unit.ID())
+		err := c.unit.Release(true)
+		if err != nil {
+			logger.Error("Error evicting work unit %s: %s\n", c.unit.ID(), err)
+		}
+	}
+}
+
+// checkDiskQuota applies the quota policy before new work is accepted.
+func (w *Workceptor) checkDiskQuota() error {
+	w.quotaLock.Lock()
+	over := w.overQuota()
+	policy := w.quotaPolicy
+	w.quotaLock.Unlock()
+	if !over {
+		return nil
+	}
+	if policy == QuotaPolicyEvict {
+		w.evictCompletedUnits()
+		w.quotaLock.Lock()
+		over = w.overQuota()
+		w.quotaLock.Unlock()
+		if !over {
+			return nil
+		}
+	}
+	logger.Warning("Rejecting new work: %s\n", ErrDiskQuota)
+
+	return ErrDiskQuota
+}
+
+// **************************************************************************
+// Command line
+// **************************************************************************
+
+// workQuotaCfg is the cmdline configuration object for the data dir disk quota.
+type workQuotaCfg struct {
+	Limit  string `description:"Maximum total size of the work data dir, such as 500M or 10G" required:"true"`
+	Policy string `description:"What to do when the data dir nears the limit: reject new work, or evict the oldest completed units" default:"reject"`
+}
+
+// Prepare verifies the parameters are correct.
+func (cfg workQuotaCfg) Prepare() error {
+	_, err := ParseByteSize(cfg.Limit)
+	if err != nil {
+		return err
+	}
+	if cfg.Policy != QuotaPolicyReject && cfg.Policy != QuotaPolicyEvict {
+		return fmt.Errorf("unknown disk quota policy %s: must be %s or %s", cfg.Policy, QuotaPolicyReject, QuotaPolicyEvict)
+	}
+
+	return nil
+}
+
+// Run runs the action.
+func (cfg workQuotaCfg) Run() error {
+	utils.RecordEffectiveConfig("work-quota", cfg)
+	limit, err := ParseByteSize(cfg.Limit)
+	if err != nil {
+		return err
+	}
+
+	return MainInstance.SetDiskQuota(limit, cfg.Policy)
+}
+
+func init() {
+	cmdline.RegisterConfigTypeForApp("receptor-workers",
+		"work-quota", "Disk quota for the work data dir", workQuotaCfg{}, cmdline.Singleton, cmdline.Section(workersSection))
+}
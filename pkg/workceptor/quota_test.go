@@ -0,0 +1,124 @@
+//go:build !no_workceptor
+// +build !no_workceptor
+
+package workceptor
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+// newQuotaTestWorkceptor returns a Workceptor with a completed unit and a running unit, each
+// holding resultSize bytes of results.
+func newQuotaTestWorkceptor(t *testing.T, resultSize int) (*Workceptor, WorkUnit, WorkUnit, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	w := newTestWorkceptor(ctx, t)
+	results := bytes.Repeat([]byte("x"), resultSize)
+	units := make([]WorkUnit, 0)
+	for _, state := range []int{WorkStateSucceeded, WorkStateRunning} {
+		unit, err := w.AllocateUnit("command", make(map[string]string))
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = ioutil.WriteFile(unit.StdoutFileName(), results, 0o600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		unit.UpdateBasicStatus(state, "test", int64(resultSize))
+		units = append(units, unit)
+		// Give the units distinct ages
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	return w, units[0], units[1], cancel
+}
+
+func TestDiskQuotaUsage(t *testing.T) {
+	w, completed, _, cleanup := newQuotaTestWorkceptor(t, 4000)
+	defer cleanup()
+	usage := w.DiskUsage()
+	if usage < 8000 || usage > 9000 {
+		t.Fatalf("expected usage of about 8000 bytes, got %d", usage)
+	}
+	err := completed.Release(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.DiskUsage() > usage-4000 {
+		t.Fatalf("usage did not drop after release, got %d", w.DiskUsage())
+	}
+}
+
+func TestDiskQuotaReject(t *testing.T) {
+	w, completed, running, cleanup := newQuotaTestWorkceptor(t, 4000)
+	defer cleanup()
+	err := w.SetDiskQuota(9000, QuotaPolicyReject)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = w.AllocateUnit("command", make(map[string]string))
+	if err != ErrDiskQuota {
+		t.Fatalf("expected new work to be rejected, got %v", err)
+	}
+	for _, unit := range []WorkUnit{completed, running} {
+		if _, err := os.Stat(unit.StdoutFileName()); err != nil {
+			t.Fatalf("reject policy deleted results of %s: %s", unit.ID(), err)
+		}
+	}
+}
+
+func TestDiskQuotaEvict(t *testing.T) {
+	w, completed, running, cleanup := newQuotaTestWorkceptor(t, 4000)
+	defer cleanup()
+	err := w.SetDiskQuota(9000, QuotaPolicyEvict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unit, err := w.AllocateUnit("command", make(map[string]string))
+	if err != nil {
+		t.Fatalf("expected new work to be accepted after eviction, got %s", err)
+	}
+	if _, err := os.Stat(completed.UnitDir()); !os.IsNotExist(err) {
+		t.Fatal("completed unit was not evicted")
+	}
+	if _, err := os.Stat(running.StdoutFileName()); err != nil {
+		t.Fatalf("running unit was evicted: %s", err)
+	}
+	if _, err := w.findUnit(unit.ID()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the running unit is left, which cannot be evicted
+	err = w.SetDiskQuota(4000, QuotaPolicyEvict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = w.AllocateUnit("command", make(map[string]string))
+	if err != ErrDiskQuota {
+		t.Fatalf("expected new work to be rejected, got %v", err)
+	}
+	if _, err := os.Stat(running.StdoutFileName()); err != nil {
+		t.Fatalf("running unit was evicted: %s", err)
+	}
+}
+
+func TestParseByteSize(t *testing.T) {
+	for s, expected := range map[string]int64{"100": 100, "2K": 2048, "3MB": 3 << 20, "1GiB": 1 << 30, " 5g ": 5 << 30} {
+		n, err := ParseByteSize(s)
+		if err != nil {
+			t.Fatalf("error parsing %q: %s", s, err)
+		}
+		if n != expected {
+			t.Fatalf("expected %q to be %d, got %d", s, expected, n)
+		}
+	}
+	for _, s := range []string{"", "K", "-1", "12X"} {
+		if _, err := ParseByteSize(s); err == nil {
+			t.Fatalf("invalid size %q was accepted", s)
+		}
+	}
+}
@@ -0,0 +1,313 @@
+//go:build !no_workceptor
+// +build !no_workceptor
+
+package workceptor
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/ansible/receptor/pkg/logger"
+	"github.com/ansible/receptor/pkg/netceptor"
+	"github.com/ansible/receptor/pkg/utils"
+	"github.com/ghjm/cmdline"
+)
+
+// reassignParamsFileName is the file in a unit dir that holds the params a reassignable unit was submitted
+// with, so it can be resubmitted to another node.
+const reassignParamsFileName = "reassignparams"
+
+// SetWorkTypeReassignable sets whether units of a work type can be reassigned to another node that can run
+// the same work type when this node shuts down.  Only work that is safe to run again from the start, such
+// as idempotent or stateless work, should be reassignable.
+func (w *Workceptor) SetWorkTypeReassignable(typeName string, reassignable bool) error {
+	w.workTypesLock.Lock()
+	defer w.workTypesLock.Unlock()
+	wt, ok := w.workTypes[typeName]
+	if !ok {
+		return fmt.Errorf("unknown work type %s", typeName)
+	}
+	wt.reassignable = reassignable
+
+	return nil
+}
+
+// reassignParamsFile is the content of a unit's reassignparams file.  The values of secret params are
+// never written to disk, so only their names are saved, and the values are kept in memory.
+type reassignParamsFile struct {
+	Params  map[string]string
+	Secrets []string
+}
+
+// isSecretParam returns true if a param is a secret, whose value must not be written to disk.
+func isSecretParam(name string) bool {
+	return strings.HasPrefix(strings.ToLower(name), "secret_")
+}
+
+// saveReassignParams writes the params a unit was submitted with to its unit dir, keeping the values of
+// secret params in memory instead.
+func (w *Workceptor) saveReassignParams(unitID string, unitDir string, params map[string]string) error {
+	rpf := reassignParamsFile{Params: make(map[string]string)}
+	secrets := make(map[string]string)
+	for k, v := range params {
+		if isSecretParam(k) {
+			rpf.Secrets = append(rpf.Secrets, k)
+			secrets[k] = v
+		} else {
+			rpf.Params[k] = v
+		}
+	}
+	data, err := json.Marshal(rpf)
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(path.Join(unitDir, reassignParamsFileName), data, 0o600)
+	if err != nil {
+		return err
+	}
+	if len(secrets) > 0 {
+		w.reassignLock.Lock()
+		defer w.reassignLock.Unlock()
+		w.reassignSecrets[unitID] = secrets
+	}
+
+	return nil
+}
+
+// loadReassignParams reads the params a unit was submitted with from its unit dir, along with the values
+// of its secret params.  Secret values are not kept across restarts, so a unit that had secret params
+// cannot be reassigned after receptor has restarted.
+func (w *Workceptor) loadReassignParams(unitID string, unitDir string) (map[string]string, error) {
+	data, err := ioutil.ReadFile(path.Join(unitDir, reassignParamsFileName))
+	if err != nil {
+		return nil, err
+	}
+	rpf := reassignParamsFile{}
+	err = json.Unmarshal(data, &rpf)
+	if err != nil {
+		return nil, err
+	}
+	params := make(map[string]string)
+	for k, v := range rpf.Params {
+		params[k] = v
+	}
+	w.reassignLock.Lock()
+	secrets := w.reassignSecrets[unitID]
+	w.reassignLock.Unlock()
+	for _, k := range rpf.Secrets {
+		v, ok := secrets[k]
+		if !ok {
+			return nil, fmt.Errorf("secret param %s is not kept across restarts", k)
+		}
+		params[k] = v
+	}
+
+	return params, nil
+}
+
+// forgetReassignSecrets removes the secret params of a released unit from memory.
+func (w *Workceptor) forgetReassignSecrets(unitID string) {
+	w.reassignLock.Lock()
+	defer w.reassignLock.Unlock()
+	delete(w.reassignSecrets, unitID)
+}
+
+// reassignPeers returns the other nodes that advertise a control service able to run a work type.
+func (w *Workceptor) reassignPeers(workType string) []string {
+	var peers []string
+	for _, ad := range w.nc.Status().Advertisements {
+		if ad.NodeID == w.nc.NodeID() || ad.Service != "control" {
+			continue
+		}
+		for _, wc := range ad.WorkCommands {
+			if wc == workType {
+				peers = append(peers, ad.NodeID)
+
+				break
+			}
+		}
+	}
+	sort.Strings(peers)
+
+	return peers
+}
+
+// DrainAndReassign is called when the node is shutting down.  Each of its pending and running units whose
+// work type is reassignable is resubmitted, with its input, to a peer that can run the same work type.  The
+// unit is then cancelled here, and failed with a detail naming the unit that replaced it.  Units that cannot
+// be reassigned are cancelled and failed with the reason why.  Returns the number of units reassigned and
+// failed.
+func (w *Workceptor) DrainAndReassign(ctx context.Context, tlsclient string) (int, int) {
+	reassigned := 0
+	failed := 0
+	for _, unitID := range w.ListKnownUnitIDs() {
+		unit, err := w.findUnit(unitID)
+		if err != nil {
+			continue
+		}
+		status := unit.Status()
+		if IsComplete(status.State) {
+			continue
+		}
+		w.workTypesLock.RLock()
+		wt, ok := w.workTypes[status.WorkType]
+		w.workTypesLock.RUnlock()
+		if !ok || status.WorkType == "remote" {
+			// The work of remote units runs elsewhere, so is not affected by this node shutting down
+			continue
+		}
+		var detail string
+		if wt.reassignable {
+			replacement, err := w.reassignUnit(ctx, unit, tlsclient)
+			if err != nil {
+				detail = fmt.Sprintf("Node shutting down: could not reassign unit: %s", err)
+				failed++
+			} else {
+				red := replacement.Status().ExtraData.(*remoteExtraData)
+				detail = fmt.Sprintf("Reassigned to node %s as work unit %s, tracked here as %s",
+					red.RemoteNode, red.RemoteUnitID, replacement.ID())
+				reassigned++
+			}
+		} else {
+			detail = fmt.Sprintf("Node shutting down: work type %s is not reassignable", status.WorkType)
+			failed++
+		}
+		if err := unit.Cancel(); err != nil {
+			logger.Warning("Error cancelling work unit %s: %s\n", unitID, err)
+		}
+		unit.UpdateBasicStatus(WorkStateFailed, detail, stdoutSize(unit.UnitDir()))
+		logger.Info("Work unit %s: %s\n", unitID, detail)
+	}
+
+	return reassigned, failed
+}
+
+// reassignUnit resubmits a unit to the first peer that accepts it, and returns the remote unit that tracks it.
+func (w *Workceptor) reassignUnit(ctx context.Context, unit WorkUnit, tlsclient string) (WorkUnit, error) {
+	workType := unit.Status().WorkType
+	params, err := w.loadReassignParams(unit.ID(), unit.UnitDir())
+	if err != nil {
+		return nil, fmt.Errorf("error reading submitted params: %s", err)
+	}
+	peers := w.reassignPeers(workType)
+	if len(peers) == 0 {
+		return nil, fmt.Errorf("no other node can run work type %s", workType)
+	}
+	for _, peer := range peers {
+		var rw WorkUnit
+		rw, err = w.AllocateRemoteUnit(peer, workType, tlsclient, "", params)
+		if err != nil {
+			return nil, err
+		}
+		err = copyStdin(unit.UnitDir(), rw.UnitDir())
+		if err == nil {
+			err = rw.Start()
+			if IsPending(err) {
+				err = waitRemoteStarted(ctx, rw)
+			}
+		}
+		if err == nil {
+			return rw, nil
+		}
+		logger.Warning("Could not reassign work unit %s to node %s: %s\n", unit.ID(), peer, err)
+		if relErr := rw.Release(true); relErr != nil {
+			logger.Error("Error releasing work unit %s: %s\n", rw.ID(), relErr)
+		}
+	}
+
+	return nil, err
+}
+
+// copyStdin copies the input of one unit to another.
+func copyStdin(fromDir string, toDir string) error {
+	in, err := os.Open(path.Join(fromDir, "stdin"))
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(path.Join(toDir, "stdin"), os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
+
+// waitRemoteStarted waits for a remote unit that is still connecting to its node to be started there.
+func waitRemoteStarted(ctx context.Context, rw WorkUnit) error {
+	for {
+		status := rw.Status()
+		if red, ok := status.ExtraData.(*remoteExtraData); ok && red.RemoteStarted {
+			return nil
+		}
+		if IsComplete(status.State) {
+			return fmt.Errorf("%s", status.Detail)
+		}
+		if sleepOrDone(ctx.Done(), 100*time.Millisecond) {
+			return fmt.Errorf("timed out connecting to remote node")
+		}
+	}
+}
+
+// **************************************************************************
+// Command line
+// **************************************************************************
+
+// workDrainCfg is the cmdline configuration object for draining work units on shutdown.
+type workDrainCfg struct {
+	Timeout   string `description:"Maximum time to spend reassigning work units before shutting down" default:"1m"`
+	TLSClient string `description:"TLS client config to use when resubmitting work units to other nodes"`
+}
+
+// Prepare verifies the parameters are correct.
+func (cfg workDrainCfg) Prepare() error {
+	if _, err := time.ParseDuration(cfg.Timeout); err != nil {
+		return fmt.Errorf("invalid drain timeout %s: %s", cfg.Timeout, err)
+	}
+
+	return nil
+}
+
+// Run runs the action.
+func (cfg workDrainCfg) Run() error {
+	utils.RecordEffectiveConfig("work-drain", cfg)
+	timeout, err := time.ParseDuration(cfg.Timeout)
+	if err != nil {
+		return err
+	}
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-sigChan
+		// A second signal ends the process without waiting for the drain
+		signal.Stop(sigChan)
+		logger.Info("Received %s, reassigning work units before shutting down\n", sig)
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		reassigned, failed := MainInstance.DrainAndReassign(ctx, cfg.TLSClient)
+		cancel()
+		logger.Info("Reassigned %d work units and failed %d\n", reassigned, failed)
+		netceptor.MainInstance.Shutdown()
+	}()
+
+	return nil
+}
+
+func init() {
+	cmdline.RegisterConfigTypeForApp("receptor-workers",
+		"work-drain", "Reassign work units to other nodes when shutting down", workDrainCfg{},
+		cmdline.Singleton, cmdline.Section(workersSection))
+}
@@ -0,0 +1,255 @@
+//go:build !no_workceptor
+// +build !no_workceptor
+
+package workceptor
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ansible/receptor/pkg/backends/backendstest"
+	"github.com/ansible/receptor/pkg/controlsvc"
+	"github.com/ansible/receptor/pkg/netceptor"
+)
+
+// holdUnit is a runner that stays running until it is cancelled.
+type holdUnit struct {
+	BaseWorkUnit
+}
+
+func (hu *holdUnit) Start() error {
+	hu.UpdateBasicStatus(WorkStateRunning, "Holding", 0)
+
+	return nil
+}
+
+func (hu *holdUnit) Restart() error {
+	return nil
+}
+
+func (hu *holdUnit) Cancel() error {
+	return nil
+}
+
+func newHoldWorker(w *Workceptor, unitID string, workType string) WorkUnit {
+	hu := &holdUnit{}
+	hu.BaseWorkUnit.Init(w, unitID, workType)
+
+	return hu
+}
+
+// echoUnit is a runner that writes its input, followed by its suffix param, to its results.
+type echoUnit struct {
+	BaseWorkUnit
+	suffix string
+}
+
+func (eu *echoUnit) SetFromParams(params map[string]string) error {
+	eu.suffix = params["suffix"]
+
+	return nil
+}
+
+func (eu *echoUnit) Start() error {
+	stdin, err := ioutil.ReadFile(path.Join(eu.UnitDir(), "stdin"))
+	if err != nil {
+		return err
+	}
+	stdout := append(stdin, eu.suffix...)
+	if err := ioutil.WriteFile(eu.StdoutFileName(), stdout, 0o600); err != nil {
+		return err
+	}
+	eu.UpdateBasicStatus(WorkStateSucceeded, "Echoed", int64(len(stdout)))
+
+	return nil
+}
+
+func (eu *echoUnit) Restart() error {
+	return nil
+}
+
+func (eu *echoUnit) Cancel() error {
+	return nil
+}
+
+func newEchoWorker(w *Workceptor, unitID string, workType string) WorkUnit {
+	eu := &echoUnit{}
+	eu.BaseWorkUnit.Init(w, unitID, workType)
+
+	return eu
+}
+
+// startTestUnit allocates and starts a unit with some input.
+func startTestUnit(t *testing.T, w *Workceptor, workType string, params map[string]string, stdin string) WorkUnit {
+	unit, err := w.AllocateUnit(workType, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path.Join(unit.UnitDir(), "stdin"), []byte(stdin), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := unit.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	return unit
+}
+
+func TestDrainAndReassign(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "receptor-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	n1, n2 := backendstest.ConnectedNodes(ctx, t)
+	defer n2.Shutdown()
+
+	w1, err := New(ctx, n1, tmpdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, workType := range []string{"relay", "hold"} {
+		if err := w1.RegisterWorker(workType, newHoldWorker); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w1.SetWorkTypeReassignable("relay", true); err != nil {
+		t.Fatal(err)
+	}
+	w2, err := New(ctx, n2, tmpdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w2.RegisterWorker("relay", newEchoWorker); err != nil {
+		t.Fatal(err)
+	}
+	cs := controlsvc.New(true, n2)
+	if err := w2.RegisterWithControlService(cs); err != nil {
+		t.Fatal(err)
+	}
+	if err := cs.RunControlSvc(ctx, "control", nil, "", 0, "", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	relay := startTestUnit(t, w1, "relay", map[string]string{"suffix": " reassigned"}, "input")
+	hold := startTestUnit(t, w1, "hold", nil, "input")
+	deadline := time.Now().Add(10 * time.Second)
+	for len(w1.reassignPeers("relay")) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("node1 did not learn that node2 can run relay work")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	drainCtx, drainCancel := context.WithTimeout(ctx, 30*time.Second)
+	defer drainCancel()
+	reassigned, failed := w1.DrainAndReassign(drainCtx, "")
+	n1.Shutdown()
+	if reassigned != 1 || failed != 1 {
+		t.Fatalf("expected 1 unit reassigned and 1 failed, got %d and %d", reassigned, failed)
+	}
+	status := hold.Status()
+	if status.State != WorkStateFailed || !strings.Contains(status.Detail, "not reassignable") {
+		t.Fatalf("expected unreassignable unit to fail, got %+v", status)
+	}
+	status = relay.Status()
+	if status.State != WorkStateFailed || !strings.HasPrefix(status.Detail, "Reassigned to node node2") {
+		t.Fatalf("expected unit to be reassigned, got %+v", status)
+	}
+
+	// The unit carries on at node2 after node1 has shut down
+	var remoteUnitID string
+	for _, unitID := range w1.ListKnownUnitIDs() {
+		unit, err := w1.findUnit(unitID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if red, ok := unit.Status().ExtraData.(*remoteExtraData); ok {
+			remoteUnitID = red.RemoteUnitID
+		}
+	}
+	unit, err := w2.findUnit(remoteUnitID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadline = time.Now().Add(10 * time.Second)
+	for !IsComplete(unit.Status().State) {
+		if time.Now().After(deadline) {
+			t.Fatalf("reassigned unit did not complete: %+v", unit.Status())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	stdout, err := ioutil.ReadFile(unit.StdoutFileName())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if unit.Status().State != WorkStateSucceeded || string(stdout) != "input reassigned" {
+		t.Fatalf("expected reassigned unit to succeed with its input and params, got %+v and %q", unit.Status(), stdout)
+	}
+}
+
+func TestReassignParamsSecrets(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "receptor-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	nc := netceptor.New(ctx, "node1", nil)
+	w, err := New(ctx, nc, tmpdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.RegisterWorker("relay", newHoldWorker); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.SetWorkTypeReassignable("relay", true); err != nil {
+		t.Fatal(err)
+	}
+	params := map[string]string{"suffix": "plain", "secret_token": "hunter2"}
+	unit, err := w.AllocateUnit("relay", params)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Secret values are not written to the unit dir, but are still available to reassign the unit
+	data, err := ioutil.ReadFile(path.Join(unit.UnitDir(), reassignParamsFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "hunter2") {
+		t.Fatalf("expected the secret value not to be saved, got %s", data)
+	}
+	loaded, err := w.loadReassignParams(unit.ID(), unit.UnitDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded["suffix"] != "plain" || loaded["secret_token"] != "hunter2" {
+		t.Fatalf("expected the submitted params, got %v", loaded)
+	}
+
+	// After a restart the secret is gone, so the unit cannot be reassigned with it
+	w2, err := New(ctx, nc, tmpdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w2.loadReassignParams(unit.ID(), unit.UnitDir()); err == nil {
+		t.Fatal("expected loading a secret param after a restart to fail")
+	}
+
+	// Releasing the unit removes its secret from memory
+	if err := unit.Release(true); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := w.reassignSecrets[unit.ID()]; ok {
+		t.Fatal("expected the secret of a released unit to be forgotten")
+	}
+}
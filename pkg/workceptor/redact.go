@@ -0,0 +1,95 @@
+//go:build !no_workceptor
+// +build !no_workceptor
+
+package workceptor
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+)
+
+// redactionMarker replaces each match of a redaction pattern in unit output.
+const redactionMarker = "[REDACTED]"
+
+// maxRedactLineLength is the most output held back waiting for the end of a line before it is redacted
+// and written anyway.  A secret that straddles the cut is not matched.
+const maxRedactLineLength = 64 * 1024
+
+// compileRedactPatterns compiles the regular expressions whose matches are redacted from unit output.
+func compileRedactPatterns(patterns []string) ([]*regexp.Regexp, error) {
+	compiled := make([]*regexp.Regexp, 0, len(patterns))
+	for _, p := range patterns {
+		if p == "" {
+			return nil, fmt.Errorf("invalid redact pattern: pattern is empty")
+		}
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redact pattern %s: %s", p, err)
+		}
+		compiled = append(compiled, re)
+	}
+
+	return compiled, nil
+}
+
+// redactingWriter replaces matches of a set of patterns in the output written to it with redactionMarker,
+// before passing it on.  Patterns are matched one line at a time, so output is held back until its line
+// ends, and Flush must be called after the last write.  With no patterns, output is passed on as is.
+type redactingWriter struct {
+	writer   io.Writer
+	patterns []*regexp.Regexp
+	pending  []byte
+}
+
+// newRedactingWriter creates a redactingWriter that writes to w.
+func newRedactingWriter(w io.Writer, patterns []*regexp.Regexp) *redactingWriter {
+	return &redactingWriter{
+		writer:   w,
+		patterns: patterns,
+	}
+}
+
+// Write redacts and writes each complete line of output, implementing io.Writer.
+func (rw *redactingWriter) Write(p []byte) (int, error) {
+	if len(rw.patterns) == 0 {
+		return rw.writer.Write(p)
+	}
+	rw.pending = append(rw.pending, p...)
+	end := bytes.LastIndexByte(rw.pending, '\n') + 1
+	if end == 0 && len(rw.pending) >= maxRedactLineLength {
+		end = len(rw.pending)
+	}
+	if end == 0 {
+		return len(p), nil
+	}
+	err := rw.writeRedacted(rw.pending[:end])
+	rw.pending = append(rw.pending[:0], rw.pending[end:]...)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// Flush redacts and writes output held back waiting for the end of its line.
+func (rw *redactingWriter) Flush() error {
+	if len(rw.pending) == 0 {
+		return nil
+	}
+	err := rw.writeRedacted(rw.pending)
+	rw.pending = nil
+
+	return err
+}
+
+// writeRedacted writes data with every match of the patterns replaced.
+func (rw *redactingWriter) writeRedacted(data []byte) error {
+	for _, re := range rw.patterns {
+		data = re.ReplaceAllLiteral(data, []byte(redactionMarker))
+	}
+	_, err := rw.writer.Write(data)
+
+	return err
+}
@@ -0,0 +1,129 @@
+//go:build !no_workceptor
+// +build !no_workceptor
+
+package workceptor
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/ansible/receptor/pkg/netceptor"
+)
+
+func TestRedactingWriter(t *testing.T) {
+	patterns, err := compileRedactPatterns([]string{`token=\S+`, `hunter2`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := &bytes.Buffer{}
+	rw := newRedactingWriter(out, patterns)
+	// Secrets split across writes are still matched
+	for _, chunk := range []string{"login tok", "en=abc", "123 ok\npassword hun", "ter2 accepted\ndone"} {
+		n, err := rw.Write([]byte(chunk))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != len(chunk) {
+			t.Fatalf("expected %d bytes written, got %d", len(chunk), n)
+		}
+	}
+	if expected := "login [REDACTED] ok\npassword [REDACTED] accepted\n"; out.String() != expected {
+		t.Fatalf("expected only complete lines to be written, got %q", out.String())
+	}
+	if err := rw.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(out.String(), "\ndone") {
+		t.Fatalf("expected the last line to be written by Flush, got %q", out.String())
+	}
+
+	// A line that never ends is not held back forever
+	out.Reset()
+	if _, err := rw.Write(bytes.Repeat([]byte("x"), maxRedactLineLength)); err != nil {
+		t.Fatal(err)
+	}
+	if out.Len() != maxRedactLineLength {
+		t.Fatalf("expected a long line to be written, got %d bytes", out.Len())
+	}
+
+	// Without patterns, output is passed through as is
+	out.Reset()
+	if _, err := newRedactingWriter(out, nil).Write([]byte("token=abc")); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "token=abc" {
+		t.Fatalf("expected output to be unchanged, got %q", out.String())
+	}
+
+	for _, bad := range []string{"", "token=(", "[a-"} {
+		if _, err := compileRedactPatterns([]string{bad}); err == nil {
+			t.Errorf("expected pattern %q to be refused", bad)
+		}
+	}
+}
+
+func TestRedactedResults(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "receptor-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	w, err := New(ctx, netceptor.New(ctx, "test", nil), tmpdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = w.RegisterWorker("command", newCommandWorker)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unit, err := w.AllocateUnit("command", make(map[string]string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	unit.UpdateBasicStatus(WorkStateRunning, "Running", 0)
+	doneChan := make(chan struct{})
+	defer close(doneChan)
+	resultChan, err := w.GetResults(unit.ID(), 0, doneChan)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Output is written the way a worker writes it, while it is being streamed
+	patterns, err := compileRedactPatterns([]string{`(?i)password: \S+`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout, err := newStdoutWriter(unit.UnitDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	rw := newRedactingWriter(stdout, patterns)
+	for _, chunk := range []string{"TASK [login]\nPass", "word: s3cr", "et\nok: [host]\n", "changed"} {
+		if _, err := rw.Write([]byte(chunk)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := rw.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	unit.UpdateBasicStatus(WorkStateSucceeded, "Finished", stdout.Size())
+
+	expected := "TASK [login]\n[REDACTED]\nok: [host]\nchanged"
+	stored, err := ioutil.ReadFile(unit.StdoutFileName())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(stored) != expected {
+		t.Fatalf("expected stored output %q, got %q", expected, stored)
+	}
+	streamed := readResults(t, resultChan, 0)
+	if string(streamed) != expected {
+		t.Fatalf("expected streamed output %q, got %q", expected, streamed)
+	}
+}
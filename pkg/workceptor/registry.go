@@ -0,0 +1,87 @@
+//go:build !no_workceptor
+// +build !no_workceptor
+
+package workceptor
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+var (
+	workTypeRegistryLock = &sync.RWMutex{}
+	workTypeRegistry     = make(map[string]NewWorkerFunc)
+)
+
+// RegisterWorkType adds a type of work to every Workceptor created from now on, so that other packages can
+// provide their own runners without changing this one.  It is meant to be called from an init function, like
+// the registration of config types, and panics if the name is already registered.  The factory returns a
+// WorkUnit, which runs one unit of work through its lifecycle and usually embeds a BaseWorkUnit.
+func RegisterWorkType(name string, factory NewWorkerFunc) {
+	if err := addWorkType(name, factory); err != nil {
+		panic(err.Error())
+	}
+}
+
+// addWorkType adds a type of work to the registry, returning an error instead of panicking.
+func addWorkType(name string, factory NewWorkerFunc) error {
+	if name == "" || factory == nil {
+		return fmt.Errorf("work type registration requires a name and a factory")
+	}
+	workTypeRegistryLock.Lock()
+	defer workTypeRegistryLock.Unlock()
+	if _, ok := workTypeRegistry[name]; ok {
+		return fmt.Errorf("work type %s registered twice", name)
+	}
+	workTypeRegistry[name] = factory
+
+	return nil
+}
+
+// unregisterWorkType removes a type of work from the registry.  Workceptors that already have it keep it.
+func unregisterWorkType(name string) {
+	workTypeRegistryLock.Lock()
+	defer workTypeRegistryLock.Unlock()
+	delete(workTypeRegistry, name)
+}
+
+// RegisteredWorkTypes returns the names of the work types added with RegisterWorkType.
+func RegisteredWorkTypes() []string {
+	workTypeRegistryLock.RLock()
+	defer workTypeRegistryLock.RUnlock()
+	names := make([]string, 0, len(workTypeRegistry))
+	for name := range workTypeRegistry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// registerWorkTypes registers the work types from the registry with this Workceptor.
+func (w *Workceptor) registerWorkTypes() error {
+	for _, name := range RegisteredWorkTypes() {
+		if err := w.registerWorkType(name); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// registerWorkType registers one work type from the registry with this Workceptor, for types added to the
+// registry after it was created.
+func (w *Workceptor) registerWorkType(name string) error {
+	workTypeRegistryLock.RLock()
+	factory, ok := workTypeRegistry[name]
+	workTypeRegistryLock.RUnlock()
+	if !ok {
+		return fmt.Errorf("work type %s is not registered", name)
+	}
+	if err := w.RegisterWorker(name, factory); err != nil {
+		return fmt.Errorf("could not register work type %s: %w", name, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,149 @@
+//go:build !no_workceptor
+// +build !no_workceptor
+
+package workceptor
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ansible/receptor/pkg/netceptor"
+)
+
+// greetingUnit is a trivial runner that writes a greeting to its results and succeeds.
+type greetingUnit struct {
+	BaseWorkUnit
+}
+
+func (gu *greetingUnit) Start() error {
+	gu.UpdateBasicStatus(WorkStateRunning, "Greeting", 0)
+	go func() {
+		greeting := []byte("hello from a registered runner\n")
+		if err := ioutil.WriteFile(gu.StdoutFileName(), greeting, 0o600); err != nil {
+			gu.UpdateBasicStatus(WorkStateFailed, err.Error(), 0)
+
+			return
+		}
+		gu.UpdateBasicStatus(WorkStateSucceeded, "Greeted", int64(len(greeting)))
+	}()
+
+	return nil
+}
+
+func (gu *greetingUnit) Restart() error {
+	return nil
+}
+
+func (gu *greetingUnit) Cancel() error {
+	return nil
+}
+
+func newGreetingWorker(w *Workceptor, unitID string, workType string) WorkUnit {
+	gu := &greetingUnit{}
+	gu.BaseWorkUnit.Init(w, unitID, workType)
+
+	return gu
+}
+
+// registerGreeting adds the greeting runner to the registry for the length of a test.
+func registerGreeting(t *testing.T) {
+	RegisterWorkType("greeting", newGreetingWorker)
+	t.Cleanup(func() {
+		unregisterWorkType("greeting")
+	})
+}
+
+func TestRegisteredWorkType(t *testing.T) {
+	registerGreeting(t)
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "receptor-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	w, err := New(ctx, netceptor.New(ctx, "test", nil), tmpdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	unit, err := w.AllocateUnit("greeting", make(map[string]string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if unit.Status().State != WorkStatePending {
+		t.Fatalf("expected a submitted unit to be pending, got %s", WorkStateToString(unit.Status().State))
+	}
+	if err := w.StartUnit(unit.ID()); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for !IsComplete(unit.Status().State) {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the unit to complete")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if unit.Status().State != WorkStateSucceeded {
+		t.Fatalf("expected the unit to succeed, got %s: %s", WorkStateToString(unit.Status().State), unit.Status().Detail)
+	}
+	results, err := ioutil.ReadFile(unit.StdoutFileName())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(results) != "hello from a registered runner\n" {
+		t.Fatalf("unexpected results %q", results)
+	}
+}
+
+func TestRegisterWorkTypeTwice(t *testing.T) {
+	registerGreeting(t)
+	defer func() {
+		if recover() == nil {
+			t.Error("expected registering a work type twice to panic")
+		}
+	}()
+	RegisterWorkType("greeting", newGreetingWorker)
+}
+
+func TestCommandWorkerFactory(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "receptor-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	w, err := New(ctx, netceptor.New(ctx, "test", nil), tmpdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Added to the registry after the Workceptor exists, as the work-command config does
+	err = addWorkType("echo", Command{Command: "echo", Params: "foo"}.NewWorker)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		unregisterWorkType("echo")
+	})
+	if err := w.registerWorkType("echo"); err != nil {
+		t.Fatal(err)
+	}
+	if err := addWorkType("echo", Command{Command: "echo"}.NewWorker); err == nil {
+		t.Fatal("expected adding a work type twice to fail")
+	}
+	unit, err := w.AllocateUnit("echo", make(map[string]string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cu, ok := unit.(*commandUnit)
+	if !ok {
+		t.Fatalf("expected a command unit, got %T", unit)
+	}
+	if cu.command != "echo" || cu.baseParams != "foo" {
+		t.Fatalf("unexpected command %s %s", cu.command, cu.baseParams)
+	}
+}
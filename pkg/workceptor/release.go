@@ -0,0 +1,143 @@
+//go:build !no_workceptor
+// +build !no_workceptor
+
+package workceptor
+
+import (
+	"io/ioutil"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/ansible/receptor/pkg/logger"
+	"github.com/ansible/receptor/pkg/utils"
+	"github.com/ghjm/cmdline"
+)
+
+// DefaultReleaseRetention is how long the results of a released unit are kept by default.  Released units
+// are deleted immediately unless a retention period is configured.
+const DefaultReleaseRetention time.Duration = 0
+
+// releasedFileName is the file in a unit dir that marks the unit as released, and holds its deletion time.
+const releasedFileName = "released"
+
+// SetReleaseRetention sets how long the results of a released unit are kept before it is deleted.
+// Zero or negative means released units are deleted immediately.
+func (w *Workceptor) SetReleaseRetention(retention time.Duration) {
+	w.releaseLock.Lock()
+	defer w.releaseLock.Unlock()
+	w.releaseRetention = retention
+}
+
+// retainUntil returns when a released unit will be deleted, or the zero time if the unit has not been released.
+func retainUntil(unitDir string) time.Time {
+	data, err := ioutil.ReadFile(path.Join(unitDir, releasedFileName))
+	if err != nil {
+		return time.Time{}
+	}
+	until, err := time.Parse(time.RFC3339Nano, strings.TrimSpace(string(data)))
+	if err != nil {
+		// The unit was released but we don't know when, so it is due for deletion
+		return time.Unix(0, 0)
+	}
+
+	return until
+}
+
+// ReleaseUnitRetaining stops a unit of work, keeping its results until the release retention period has
+// passed, after which the unit is released.  If skipRetention is true, or retention is disabled, the unit is
+// released immediately, as by work release without retention.  Returns the time the unit will be deleted, or
+// the zero time if it was released immediately.
+func (w *Workceptor) ReleaseUnitRetaining(unitID string, skipRetention bool) (time.Time, error) {
+	unit, err := w.findUnit(unitID)
+	if err != nil {
+		return time.Time{}, err
+	}
+	w.releaseLock.RLock()
+	retention := w.releaseRetention
+	w.releaseLock.RUnlock()
+	if retention <= 0 || skipRetention {
+		return time.Time{}, unit.Release(false)
+	}
+	until := retainUntil(unit.UnitDir())
+	if !until.IsZero() {
+		return until, nil
+	}
+	err = unit.Cancel()
+	if err != nil && !IsPending(err) {
+		return time.Time{}, err
+	}
+	until = time.Now().Add(retention)
+	err = ioutil.WriteFile(path.Join(unit.UnitDir(), releasedFileName), []byte(until.Format(time.RFC3339Nano)), 0o600)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return until, nil
+}
+
+// sweepReleasedUnits deletes released units whose retention period has passed.
+func (w *Workceptor) sweepReleasedUnits() {
+	now := time.Now()
+	w.activeUnitsLock.RLock()
+	expired := make([]WorkUnit, 0)
+	for _, unit := range w.activeUnits {
+		until := retainUntil(unit.UnitDir())
+		if !until.IsZero() && now.After(until) {
+			expired = append(expired, unit)
+		}
+	}
+	w.activeUnitsLock.RUnlock()
+	for _, unit := range expired {
+		logger.Debug("Deleting released work unit %s\n", unit.ID())
+		err := unit.Release(false)
+		if err != nil && !IsPending(err) {
+			logger.Error("Error deleting released work unit %s: %s\n", unit.ID(), err)
+		}
+	}
+}
+
+// monitorReleasedUnits periodically deletes released units whose retention period has passed.
+func (w *Workceptor) monitorReleasedUnits(interval time.Duration) {
+	for {
+		select {
+		case <-w.ctx.Done():
+			return
+		case <-time.After(interval):
+			w.sweepReleasedUnits()
+		}
+	}
+}
+
+// **************************************************************************
+// Command line
+// **************************************************************************
+
+// workReleaseCfg is the cmdline configuration object for release retention.
+type workReleaseCfg struct {
+	Retention string `description:"How long to keep the results of released work units (0 to delete immediately)" default:"0"`
+}
+
+// Prepare verifies the parameters are correct.
+func (cfg workReleaseCfg) Prepare() error {
+	_, err := time.ParseDuration(cfg.Retention)
+
+	return err
+}
+
+// Run runs the action.
+func (cfg workReleaseCfg) Run() error {
+	utils.RecordEffectiveConfig("work-release", cfg)
+	retention, err := time.ParseDuration(cfg.Retention)
+	if err != nil {
+		return err
+	}
+	MainInstance.SetReleaseRetention(retention)
+
+	return nil
+}
+
+func init() {
+	cmdline.RegisterConfigTypeForApp("receptor-workers",
+		"work-release", "Retention of released work units", workReleaseCfg{}, cmdline.Singleton, cmdline.Section(workersSection))
+}
@@ -0,0 +1,139 @@
+//go:build !no_workceptor
+// +build !no_workceptor
+
+package workceptor
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func newReleaseTestUnit(t *testing.T) (*Workceptor, WorkUnit, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	w := newTestWorkceptor(ctx, t)
+	unit, err := w.AllocateUnit("command", make(map[string]string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(unit.StdoutFileName(), []byte("results"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return w, unit, cancel
+}
+
+func TestReleaseRetainsResults(t *testing.T) {
+	w, unit, cleanup := newReleaseTestUnit(t)
+	defer cleanup()
+	w.SetReleaseRetention(time.Hour)
+	until, err := w.ReleaseUnitRetaining(unit.ID(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if time.Until(until) < 59*time.Minute {
+		t.Fatalf("unexpected retention time %s", until)
+	}
+	w.sweepReleasedUnits()
+	data, err := ioutil.ReadFile(unit.StdoutFileName())
+	if err != nil {
+		t.Fatalf("results of a retained unit are gone: %s", err)
+	}
+	if string(data) != "results" {
+		t.Fatalf("unexpected results %q", data)
+	}
+	if !retainUntil(unit.UnitDir()).Equal(until) {
+		t.Fatal("release time was not recorded")
+	}
+
+	// A retained unit can still be force released
+	err = unit.Release(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(unit.UnitDir()); !os.IsNotExist(err) {
+		t.Fatal("unit dir still exists after force release")
+	}
+}
+
+func TestReleaseRetentionExpires(t *testing.T) {
+	w, unit, cleanup := newReleaseTestUnit(t)
+	defer cleanup()
+	w.SetReleaseRetention(time.Millisecond)
+	_, err := w.ReleaseUnitRetaining(unit.ID(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	w.sweepReleasedUnits()
+	if _, err := os.Stat(unit.UnitDir()); !os.IsNotExist(err) {
+		t.Fatal("unit dir still exists after retention expired")
+	}
+	if _, err := w.findUnit(unit.ID()); err == nil {
+		t.Fatal("unit is still active after retention expired")
+	}
+}
+
+func TestReleaseWithoutRetention(t *testing.T) {
+	w, unit, cleanup := newReleaseTestUnit(t)
+	defer cleanup()
+	// Released units are deleted straight away unless a retention period is configured
+	until, err := w.ReleaseUnitRetaining(unit.ID(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !until.IsZero() {
+		t.Fatalf("expected no retention by default, got %s", until)
+	}
+	if _, err := os.Stat(unit.UnitDir()); !os.IsNotExist(err) {
+		t.Fatal("unit dir still exists after release")
+	}
+}
+
+func TestReleaseForce(t *testing.T) {
+	w, unit, cleanup := newReleaseTestUnit(t)
+	defer cleanup()
+	w.SetReleaseRetention(time.Hour)
+	until, err := w.ReleaseUnitRetaining(unit.ID(), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !until.IsZero() {
+		t.Fatalf("expected release --force to skip retention, got %s", until)
+	}
+	if _, err := os.Stat(unit.StdoutFileName()); !os.IsNotExist(err) {
+		t.Fatal("results still exist after release --force")
+	}
+	if _, err := w.findUnit(unit.ID()); err == nil {
+		t.Fatal("unit is still active after release --force")
+	}
+}
+
+func TestReleaseCommandForce(t *testing.T) {
+	ct := &workceptorCommandType{}
+	cmd, err := ct.InitFromString("release abc --force")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wc := cmd.(*workceptorCommand)
+	if wc.subcommand != "release" || wc.params["force"] != true {
+		t.Fatalf("expected release --force to stay a release that skips retention, got %+v", wc)
+	}
+	cmd, err = ct.InitFromJSON(map[string]interface{}{"subcommand": "release", "unitid": "abc", "force": true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wc := cmd.(*workceptorCommand); wc.subcommand != "release" || wc.params["force"] != true {
+		t.Fatalf("expected a forced release from JSON to stay a release, got %+v", wc)
+	}
+	cmd, err = ct.InitFromString("force-release abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wc := cmd.(*workceptorCommand); wc.subcommand != "force-release" || wc.params["force"] != nil {
+		t.Fatalf("expected force-release to be unchanged, got %+v", wc)
+	}
+}
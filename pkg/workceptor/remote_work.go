@@ -7,6 +7,7 @@ import (
 	"bufio"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net"
@@ -18,7 +19,11 @@ import (
 	"time"
 
 	"github.com/ansible/receptor/pkg/logger"
+	"github.com/ansible/receptor/pkg/telemetry"
 	"github.com/ansible/receptor/pkg/utils"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // remoteUnit implements the WorkUnit interface for the Receptor remote worker plugin.
@@ -142,7 +147,7 @@ func (rw *remoteUnit) getConnectionAndRun(ctx context.Context, firstTimeSync boo
 }
 
 // startRemoteUnit makes a single attempt to start a remote unit.
-func (rw *remoteUnit) startRemoteUnit(ctx context.Context, conn net.Conn, reader *bufio.Reader) error {
+func (rw *remoteUnit) startRemoteUnit(ctx context.Context, conn net.Conn, reader *bufio.Reader) (err error) {
 	closeOnce := sync.Once{}
 	doClose := func() error {
 		var err error
@@ -163,6 +168,18 @@ func (rw *remoteUnit) startRemoteUnit(ctx context.Context, conn net.Conn, reader
 	workSubmitCmd["node"] = red.RemoteNode
 	workSubmitCmd["worktype"] = red.RemoteWorkType
 	workSubmitCmd["tlsclient"] = red.TLSClient
+	spanCtx, span := telemetry.Tracer().Start(rw.w.unitSpanContext(rw.unitID), "work remote submit",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("receptor.remote_node", red.RemoteNode)))
+	defer func() {
+		if err != nil {
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}()
+	if traceparent := telemetry.Traceparent(spanCtx); traceparent != "" {
+		workSubmitCmd[telemetry.TraceparentKey] = traceparent
+	}
 	wscBytes, err := json.Marshal(workSubmitCmd)
 	if err != nil {
 		return fmt.Errorf("error constructing work submit command: %s", err)
@@ -307,16 +324,9 @@ func (rw *remoteUnit) monitorRemoteStatus(mw *utils.JobContext, forRelease bool)
 
 			return
 		}
-		si := StatusFileData{}
-		err = json.Unmarshal([]byte(status), &si)
-		if err != nil {
-			logger.Error("Error unmarshalling JSON: %s\n", status)
-
-			return
-		}
-		rw.UpdateBasicStatus(si.State, si.Detail, si.StdoutSize)
+		err = rw.applyRemoteStatus(status)
 		if err != nil {
-			logger.Error("Error saving local status file: %s\n", err)
+			logger.Error("%s\n", err)
 
 			return
 		}
@@ -393,12 +403,22 @@ func (rw *remoteUnit) monitorRemoteStdout(mw *utils.JobContext) {
 
 				continue
 			}
-			stdout, err := os.OpenFile(rw.stdoutFileName, os.O_CREATE+os.O_APPEND+os.O_WRONLY, 0o600)
+			// Results are only written to the stdout file once received in order, so its size is the offset that
+			// has been acknowledged, and that the next connection resumes from.
+			stdout, err := os.OpenFile(rw.stdoutFileName, os.O_CREATE+os.O_WRONLY, 0o600)
 			if err != nil {
 				logger.Error("Could not open stdout file %s: %s\n", rw.stdoutFileName, err)
 
 				return
 			}
+			bufSize, policy := rw.w.ResultBuffer()
+			rb, err := utils.NewRetryBuffer(diskStdoutSize, int(bufSize), policy)
+			if err != nil {
+				_ = stdout.Close()
+				logger.Error("Could not create result buffer for %s: %s\n", rw.stdoutFileName, err)
+
+				return
+			}
 			doneChan := make(chan struct{})
 			go func() {
 				select {
@@ -414,10 +434,31 @@ func (rw *remoteUnit) monitorRemoteStdout(mw *utils.JobContext) {
 					return
 				}
 			}()
-			_, err = io.Copy(stdout, conn)
+			go func() {
+				_, err := io.Copy(rb, reader)
+				rb.CloseWrite(err)
+			}()
+			err = rb.Drain(func(offset int64, p []byte) error {
+				if _, err := stdout.WriteAt(p, offset); err != nil {
+					return err
+				}
+
+				return stdout.Sync()
+			})
 			close(doneChan)
+			_ = conn.Close()
+			closeErr := stdout.Close()
+			if errors.Is(err, utils.ErrRetryBufferFull) {
+				logger.Debug("Result buffer for %s is full, resuming from offset %d\n", rw.stdoutFileName, rb.Acked())
+
+				continue
+			}
+			if err == nil {
+				err = closeErr
+			}
 			if err != nil {
-				logger.Warning("Error copying to stdout file %s: %s\n", rw.stdoutFileName, err)
+				logger.Warning("Error copying to stdout file %s, resuming from offset %d: %s\n",
+					rw.stdoutFileName, rb.Acked(), err)
 
 				continue
 			}
@@ -0,0 +1,87 @@
+//go:build !no_workceptor
+// +build !no_workceptor
+
+package workceptor
+
+import (
+	"fmt"
+
+	"github.com/ansible/receptor/pkg/utils"
+	"github.com/ghjm/cmdline"
+)
+
+// DefaultResultBufferSize is the default size of the buffer of results received from a remote node but not yet
+// written to disk.
+const DefaultResultBufferSize = 1 << 20
+
+// SetResultBuffer sets the size and overflow policy of the buffer that holds results streamed from remote
+// nodes until they are written to disk.  With the block policy, reading from the remote node pauses while
+// the buffer is full.  With the restart policy, the stream is dropped and restarted from the last offset
+// written to disk.
+func (w *Workceptor) SetResultBuffer(size int64, policy string) error {
+	if size <= 0 {
+		return fmt.Errorf("result buffer size must be positive")
+	}
+	if policy != utils.RetryBufferBlock && policy != utils.RetryBufferRestart {
+		return fmt.Errorf("unknown result buffer overflow policy %s: must be %s or %s",
+			policy, utils.RetryBufferBlock, utils.RetryBufferRestart)
+	}
+	w.resultBufferLock.Lock()
+	defer w.resultBufferLock.Unlock()
+	w.resultBufferSize = size
+	w.resultBufferPolicy = policy
+
+	return nil
+}
+
+// ResultBuffer returns the size and overflow policy of the remote result buffer.
+func (w *Workceptor) ResultBuffer() (int64, string) {
+	w.resultBufferLock.RLock()
+	defer w.resultBufferLock.RUnlock()
+
+	return w.resultBufferSize, w.resultBufferPolicy
+}
+
+// **************************************************************************
+// Command line
+// **************************************************************************
+
+// workResultBufferCfg is the cmdline configuration object for the remote result buffer.
+type workResultBufferCfg struct {
+	Size     string `description:"Maximum size of results received from a remote node but not yet written to disk, such as 64K or 1M" default:"1M"`
+	Overflow string `description:"What to do when the buffer is full: block reading from the remote node, or restart the stream from the last offset written to disk" default:"block"`
+}
+
+// Prepare verifies the parameters are correct.
+func (cfg workResultBufferCfg) Prepare() error {
+	size, err := ParseByteSize(cfg.Size)
+	if err != nil {
+		return err
+	}
+	if size <= 0 {
+		return fmt.Errorf("result buffer size must be positive")
+	}
+	if cfg.Overflow != utils.RetryBufferBlock && cfg.Overflow != utils.RetryBufferRestart {
+		return fmt.Errorf("unknown result buffer overflow policy %s: must be %s or %s",
+			cfg.Overflow, utils.RetryBufferBlock, utils.RetryBufferRestart)
+	}
+
+	return nil
+}
+
+// Run runs the action.
+func (cfg workResultBufferCfg) Run() error {
+	utils.RecordEffectiveConfig("work-result-buffer", cfg)
+	size, err := ParseByteSize(cfg.Size)
+	if err != nil {
+		return err
+	}
+
+	return MainInstance.SetResultBuffer(size, cfg.Overflow)
+}
+
+func init() {
+	cmdline.RegisterConfigTypeForApp("receptor-workers",
+		"work-result-buffer", "Buffering of results streamed from remote nodes", workResultBufferCfg{},
+		cmdline.Singleton, cmdline.Section(workersSection))
+}
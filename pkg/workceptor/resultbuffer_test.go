@@ -0,0 +1,116 @@
+//go:build !no_workceptor
+// +build !no_workceptor
+
+package workceptor
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ansible/receptor/pkg/backends/backendstest"
+	"github.com/ansible/receptor/pkg/utils"
+)
+
+func TestRemoteResultsResumeAfterLinkDrop(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "receptor-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	n1, n2, link := backendstest.ConnectedNodesWithLink(ctx, t)
+	w, err := New(ctx, n1, tmpdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A buffer much smaller than the results makes reading from node2 wait for them to be written
+	if err := w.SetResultBuffer(4096, utils.RetryBufferBlock); err != nil {
+		t.Fatal(err)
+	}
+	data := make([]byte, 256*1024)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+
+	// node2 stands in for the remote control service.  The first stream breaks the link halfway through.
+	li, err := n2.Listen("control", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer li.Close()
+	offsets := make(chan int64, 10)
+	go func() {
+		for streams := 0; ; streams++ {
+			conn, err := li.Accept()
+			if err != nil {
+				return
+			}
+			_, _ = conn.Write([]byte("Receptor Control, node node2\n"))
+			line, err := bufio.NewReader(conn).ReadString('\n')
+			var unitID string
+			var offset int64
+			if err == nil {
+				_, err = fmt.Sscanf(line, "work results %s %d", &unitID, &offset)
+			}
+			if err != nil || offset > int64(len(data)) {
+				_ = conn.Close()
+
+				continue
+			}
+			offsets <- offset
+			_, _ = conn.Write([]byte(fmt.Sprintf("Streaming results for work unit %s\n", unitID)))
+			if streams == 0 {
+				_, _ = conn.Write(data[offset : len(data)/2])
+				link.Break()
+			} else {
+				_, _ = conn.Write(data[offset:])
+			}
+			_ = conn.Close()
+		}
+	}()
+
+	unit, err := w.AllocateRemoteUnit("node2", "echo", "", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unit.UpdateFullStatus(func(status *StatusFileData) {
+		status.State = WorkStateSucceeded
+		status.StdoutSize = int64(len(data))
+		status.ExtraData.(*remoteExtraData).RemoteUnitID = "remoteunit"
+	})
+	rw := unit.(*remoteUnit)
+	mw := &utils.JobContext{}
+	mw.NewJob(ctx, 1, false)
+	go rw.monitorRemoteStdout(mw)
+	select {
+	case <-mw.Done():
+	case <-time.After(30 * time.Second):
+		t.Fatal("timed out waiting for the results")
+	}
+
+	stdout, err := ioutil.ReadFile(rw.stdoutFileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(stdout, data) {
+		t.Fatalf("received %d bytes of results that do not match the %d bytes sent", len(stdout), len(data))
+	}
+	if first := <-offsets; first != 0 {
+		t.Errorf("expected the first stream to start at 0, got %d", first)
+	}
+	select {
+	case resumed := <-offsets:
+		if resumed < 0 || resumed > int64(len(data)/2) {
+			t.Errorf("expected the stream to resume from what was written before the link broke, got %d", resumed)
+		}
+	default:
+		t.Error("expected the stream to be resumed")
+	}
+}
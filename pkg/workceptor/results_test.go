@@ -0,0 +1,156 @@
+//go:build !no_workceptor
+// +build !no_workceptor
+
+package workceptor
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ansible/receptor/pkg/netceptor"
+	"github.com/ansible/receptor/pkg/utils"
+)
+
+// readResults collects a stream of results until it ends, or until maxChunks chunks have been received.
+func readResults(t *testing.T, resultChan chan []byte, maxChunks int) []byte {
+	var buf bytes.Buffer
+	for chunks := 0; maxChunks <= 0 || chunks < maxChunks; chunks++ {
+		select {
+		case data, ok := <-resultChan:
+			if !ok {
+				return buf.Bytes()
+			}
+			buf.Write(data)
+		case <-time.After(10 * time.Second):
+			t.Fatal("timed out reading results")
+		}
+	}
+
+	return buf.Bytes()
+}
+
+func TestResultsRangeResume(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "receptor-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	w, err := New(ctx, netceptor.New(ctx, "test", nil), tmpdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = w.RegisterWorker("command", newCommandWorker)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unit, err := w.AllocateUnit("command", make(map[string]string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	full := bytes.Repeat([]byte("0123456789abcdef"), 3*utils.NormalBufferSize/16+7)
+	err = ioutil.WriteFile(unit.StdoutFileName(), full, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unit.UpdateBasicStatus(WorkStateSucceeded, "Finished", int64(len(full)))
+
+	// The first request asks for a range
+	doneChan := make(chan struct{})
+	resultChan, err := w.GetResultsRange(unit.ID(), 0, 1000, doneChan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	received := readResults(t, resultChan, 0)
+	close(doneChan)
+	if !bytes.Equal(received, full[:1000]) {
+		t.Fatalf("expected the first 1000 bytes, got %d bytes", len(received))
+	}
+
+	// The second request is cut off after one chunk
+	doneChan = make(chan struct{})
+	resultChan, err = w.GetResultsRange(unit.ID(), int64(len(received)), -1, doneChan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	received = append(received, readResults(t, resultChan, 1)...)
+	close(doneChan)
+	if len(received) >= len(full) {
+		t.Fatal("expected the interrupted request to leave results to resume")
+	}
+
+	// The third request resumes where the second one stopped
+	doneChan = make(chan struct{})
+	defer close(doneChan)
+	resultChan, err = w.GetResults(unit.ID(), int64(len(received)), doneChan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	received = append(received, readResults(t, resultChan, 0)...)
+	if !bytes.Equal(received, full) {
+		t.Fatalf("resumed results do not match: got %d bytes, expected %d", len(received), len(full))
+	}
+
+	if _, err := w.GetResultsRange(unit.ID(), -1, -1, doneChan); err == nil {
+		t.Error("expected an error for a negative start position")
+	}
+}
+
+func TestResultsHeader(t *testing.T) {
+	complete := &StatusFileData{State: WorkStateSucceeded, StdoutSize: 5000}
+	running := &StatusFileData{State: WorkStateRunning, StdoutSize: 5000}
+	for _, tc := range []struct {
+		status   *StatusFileData
+		startPos int64
+		length   int64
+		expected string
+	}{
+		{complete, 0, -1, "Streaming results for work unit abc (offset 0, length 5000, total 5000)\n"},
+		{complete, 1000, 500, "Streaming results for work unit abc (offset 1000, length 500, total 5000)\n"},
+		{complete, 4000, 2000, "Streaming results for work unit abc (offset 4000, length 1000, total 5000)\n"},
+		{complete, 5000, -1, "Streaming results for work unit abc (offset 5000, length 0, total 5000)\n"},
+		{running, 1000, -1, "Streaming results for work unit abc (offset 1000, length unknown, total unknown)\n"},
+		{running, 1000, 500, "Streaming results for work unit abc (offset 1000, length 500, total unknown)\n"},
+	} {
+		header, err := resultsHeader("abc", tc.status, tc.startPos, tc.length)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if header != tc.expected {
+			t.Errorf("expected %q, got %q", tc.expected, header)
+		}
+	}
+	if _, err := resultsHeader("abc", complete, 5001, -1); err == nil {
+		t.Error("expected an error for a start position beyond the end of the results")
+	}
+}
+
+func TestResultsCommandRange(t *testing.T) {
+	ct := &workceptorCommandType{}
+	cmd, err := ct.InitFromString("results abc 1000 500")
+	if err != nil {
+		t.Fatal(err)
+	}
+	params := cmd.(*workceptorCommand).params
+	if params["startpos"] != int64(1000) || params["length"] != int64(500) {
+		t.Errorf("unexpected params %v", params)
+	}
+	cmd, err = ct.InitFromJSON(map[string]interface{}{
+		"command": "work", "subcommand": "results", "unitid": "abc", "startpos": 1000.0, "length": 500.0,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	params = cmd.(*workceptorCommand).params
+	if params["startpos"] != int64(1000) || params["length"] != int64(500) {
+		t.Errorf("unexpected params %v", params)
+	}
+	if _, err := ct.InitFromString("results abc 1000 500 extra"); err == nil {
+		t.Error("expected an error for too many parameters")
+	}
+}
@@ -0,0 +1,309 @@
+//go:build !no_workceptor
+// +build !no_workceptor
+
+package workceptor
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ansible/receptor/pkg/logger"
+	"github.com/ansible/receptor/pkg/utils"
+	"github.com/ansible/receptor/pkg/version"
+	"github.com/ghjm/cmdline"
+)
+
+// stateArchiveVersion is the format version of archives produced by ExportState.
+const stateArchiveVersion = 1
+
+// stateManifestName is the name of the manifest entry at the start of a state archive.
+const stateManifestName = "manifest.json"
+
+// stateManifest describes the contents of a state archive.
+type stateManifest struct {
+	ArchiveVersion  int
+	ReceptorVersion string
+	NodeID          string
+	Created         time.Time
+	Units           []string
+}
+
+// unitStateFiles are the files from a unit directory that are carried in a state archive.
+var unitStateFiles = []string{"status", "stdin", "stdout"}
+
+// ExportState writes a gzipped tar archive of the persisted work unit metadata to out.
+// It returns the list of unit IDs that were exported.
+func (w *Workceptor) ExportState(out io.Writer) ([]string, error) {
+	w.scanForUnits()
+	units := w.ListKnownUnitIDs()
+	sort.Strings(units)
+	manifest := stateManifest{
+		ArchiveVersion:  stateArchiveVersion,
+		ReceptorVersion: version.Version,
+		NodeID:          w.nc.NodeID(),
+		Created:         time.Now(),
+		Units:           units,
+	}
+	manifestBytes, err := json.Marshal(manifest)
+	if err != nil {
+		return nil, err
+	}
+	gzw := gzip.NewWriter(out)
+	tw := tar.NewWriter(gzw)
+	err = tw.WriteHeader(&tar.Header{
+		Name:    stateManifestName,
+		Mode:    0o600,
+		Size:    int64(len(manifestBytes)),
+		ModTime: manifest.Created,
+	})
+	if err == nil {
+		_, err = tw.Write(manifestBytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error writing state manifest: %s", err)
+	}
+	for _, unitID := range units {
+		for _, fn := range unitStateFiles {
+			err = addFileToTar(tw, path.Join(w.dataDir, unitID, fn), path.Join(unitID, fn))
+			if err != nil {
+				return nil, fmt.Errorf("error exporting unit %s: %s", unitID, err)
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := gzw.Close(); err != nil {
+		return nil, err
+	}
+
+	return units, nil
+}
+
+// addFileToTar copies a file into a tar archive, silently skipping files that do not exist.
+func addFileToTar(tw *tar.Writer, filename string, name string) error {
+	f, err := os.Open(filename)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	err = tw.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    0o600,
+		Size:    fi.Size(),
+		ModTime: fi.ModTime(),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.CopyN(tw, f, fi.Size())
+
+	return err
+}
+
+// ImportState restores work units from an archive produced by ExportState.  Units that were not
+// complete at export time cannot still be running here, so local units are marked failed, while remote
+// units are restarted so they resume monitoring the remote node.  If the import fails part way, the units
+// it created are removed again.  It returns the list of imported unit IDs.
+func (w *Workceptor) ImportState(in io.Reader) ([]string, error) {
+	created := make([]string, 0)
+	units, err := w.importState(in, &created)
+	if err != nil {
+		for _, unitID := range created {
+			if rerr := os.RemoveAll(path.Join(w.dataDir, unitID)); rerr != nil {
+				logger.Error("Error removing partly imported work unit %s: %s\n", unitID, rerr)
+			}
+		}
+
+		return nil, err
+	}
+	for _, unitID := range units {
+		w.scanForUnit(unitID)
+	}
+
+	return units, nil
+}
+
+// importState writes the units of a state archive to the data dir, adding each unit dir it creates to created.
+func (w *Workceptor) importState(in io.Reader, created *[]string) ([]string, error) {
+	gzr, err := gzip.NewReader(in)
+	if err != nil {
+		return nil, fmt.Errorf("error reading state archive: %s", err)
+	}
+	tr := tar.NewReader(gzr)
+	hdr, err := tr.Next()
+	if err != nil {
+		return nil, fmt.Errorf("error reading state archive: %s", err)
+	}
+	if hdr.Name != stateManifestName {
+		return nil, fmt.Errorf("state archive does not begin with a manifest")
+	}
+	manifestBytes, err := ioutil.ReadAll(tr)
+	if err != nil {
+		return nil, fmt.Errorf("error reading state manifest: %s", err)
+	}
+	manifest := stateManifest{}
+	err = json.Unmarshal(manifestBytes, &manifest)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing state manifest: %s", err)
+	}
+	if manifest.ArchiveVersion != stateArchiveVersion {
+		return nil, fmt.Errorf("unsupported state archive version %d (expected %d)",
+			manifest.ArchiveVersion, stateArchiveVersion)
+	}
+	if manifest.NodeID != w.nc.NodeID() {
+		logger.Warning("Importing state exported from node %s into node %s\n", manifest.NodeID, w.nc.NodeID())
+	}
+	expected := make(map[string]bool)
+	for _, unitID := range manifest.Units {
+		if unitID == "" || strings.ContainsAny(unitID, `/\`) || unitID == "." || unitID == ".." {
+			return nil, fmt.Errorf("invalid unit ID %q in state archive", unitID)
+		}
+		if _, err := os.Stat(path.Join(w.dataDir, unitID)); err == nil {
+			return nil, fmt.Errorf("work unit %s already exists", unitID)
+		}
+		expected[unitID] = true
+	}
+	for _, unitID := range manifest.Units {
+		// Creating the dir fails if a unit of the same ID has appeared since, which is then left alone
+		if err := os.Mkdir(path.Join(w.dataDir, unitID), 0o700); err != nil {
+			return nil, fmt.Errorf("error creating work unit %s: %s", unitID, err)
+		}
+		*created = append(*created, unitID)
+	}
+	for {
+		hdr, err = tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading state archive: %s", err)
+		}
+		unitID, fn := path.Split(hdr.Name)
+		unitID = strings.TrimSuffix(unitID, "/")
+		if !expected[unitID] || !isUnitStateFile(fn) {
+			return nil, fmt.Errorf("unexpected entry %s in state archive", hdr.Name)
+		}
+		f, err := os.OpenFile(path.Join(w.dataDir, unitID, fn), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+		if err != nil {
+			return nil, err
+		}
+		_, err = io.Copy(f, tr)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error writing %s: %s", hdr.Name, err)
+		}
+	}
+	for _, unitID := range manifest.Units {
+		statusFilename := path.Join(w.dataDir, unitID, "status")
+		sfd := &StatusFileData{}
+		err = sfd.UpdateFullStatus(statusFilename, func(status *StatusFileData) {
+			if status.WorkType != "remote" && !IsComplete(status.State) {
+				status.State = WorkStateFailed
+				status.Detail = "Unit was not complete when its state was exported"
+			}
+		})
+		if err != nil {
+			return nil, fmt.Errorf("error reconciling unit %s: %s", unitID, err)
+		}
+	}
+
+	return manifest.Units, nil
+}
+
+// SetStateDir sets the directory that the state control command exports archives to and imports them from.
+// An empty dir disables the command.
+func (w *Workceptor) SetStateDir(dir string) error {
+	if dir != "" {
+		if !path.IsAbs(dir) {
+			return fmt.Errorf("state dir %s must be an absolute path", dir)
+		}
+		fi, err := os.Stat(dir)
+		if err != nil {
+			return fmt.Errorf("invalid state dir: %w", err)
+		}
+		if !fi.IsDir() {
+			return fmt.Errorf("state dir %s is not a directory", dir)
+		}
+	}
+	w.stateDirLock.Lock()
+	defer w.stateDirLock.Unlock()
+	w.stateDir = dir
+
+	return nil
+}
+
+// stateArchivePath returns the path of a state archive with the given name in the state dir.  The name may
+// not contain a path, so that the command cannot reach files outside of the state dir.
+func (w *Workceptor) stateArchivePath(name string) (string, error) {
+	w.stateDirLock.RLock()
+	dir := w.stateDir
+	w.stateDirLock.RUnlock()
+	if dir == "" {
+		return "", fmt.Errorf("state archives are disabled because no state dir is configured")
+	}
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return "", fmt.Errorf("invalid state archive name %q: must be a file name in the state dir", name)
+	}
+
+	return path.Join(dir, name), nil
+}
+
+func isUnitStateFile(fn string) bool {
+	for _, sf := range unitStateFiles {
+		if fn == sf {
+			return true
+		}
+	}
+
+	return false
+}
+
+// **************************************************************************
+// Command line
+// **************************************************************************
+
+// workStateDirCfg is the cmdline configuration object for the state archive directory.
+type workStateDirCfg struct {
+	Dir string `description:"Directory that the state control command exports archives to and imports them from" barevalue:"yes" required:"yes"`
+}
+
+// Prepare verifies the parameters are correct.
+func (cfg workStateDirCfg) Prepare() error {
+	if !path.IsAbs(cfg.Dir) {
+		return fmt.Errorf("state dir %s must be an absolute path", cfg.Dir)
+	}
+
+	return nil
+}
+
+// Run runs the action.
+func (cfg workStateDirCfg) Run() error {
+	utils.RecordEffectiveConfig("work-state-dir", cfg)
+
+	return MainInstance.SetStateDir(cfg.Dir)
+}
+
+func init() {
+	cmdline.RegisterConfigTypeForApp("receptor-workers",
+		"work-state-dir", "Directory for exporting and importing node state", workStateDirCfg{},
+		cmdline.Singleton, cmdline.Section(workersSection))
+}
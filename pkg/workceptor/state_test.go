@@ -0,0 +1,162 @@
+//go:build !no_workceptor
+// +build !no_workceptor
+
+package workceptor
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/ansible/receptor/pkg/netceptor"
+)
+
+func TestStateExportImport(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "receptor-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	nc := netceptor.New(context.Background(), "test", nil)
+	w1, err := New(context.Background(), nc, tmpdir+"/old")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = w1.RegisterWorker("command", newCommandWorker)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cw, err := w1.AllocateUnit("command", make(map[string]string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cw.UpdateBasicStatus(WorkStateSucceeded, "Done", 0)
+
+	buf := &bytes.Buffer{}
+	units, err := w1.ExportState(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(units) != 1 || units[0] != cw.ID() {
+		t.Fatalf("unexpected exported units %v", units)
+	}
+
+	w2, err := New(context.Background(), nc, tmpdir+"/new")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = w2.RegisterWorker("command", newCommandWorker)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exported := buf.Bytes()
+	_, err = w2.ImportState(bytes.NewReader(exported))
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, err := w2.UnitStatus(cw.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.State != WorkStateSucceeded || status.Detail != "Done" {
+		t.Fatalf("imported unit has wrong status %v", status)
+	}
+
+	// Importing the same archive twice must not clobber existing units
+	_, err = w2.ImportState(bytes.NewReader(exported))
+	if err == nil {
+		t.Fatal("expected error re-importing existing unit")
+	}
+}
+
+func TestStateImportRollback(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "receptor-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	nc := netceptor.New(context.Background(), "test", nil)
+	w, err := New(context.Background(), nc, tmpdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An archive whose first unit is fine, followed by an entry that does not belong
+	buf := &bytes.Buffer{}
+	gzw := gzip.NewWriter(buf)
+	tw := tar.NewWriter(gzw)
+	manifest, err := json.Marshal(stateManifest{ArchiveVersion: stateArchiveVersion, NodeID: "test", Units: []string{"unit1", "unit2"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range []struct {
+		name string
+		data []byte
+	}{
+		{stateManifestName, manifest},
+		{"unit1/status", []byte(`{"State":2,"WorkType":"command"}`)},
+		{"unit2/passwd", []byte("nope")},
+	} {
+		if err := tw.WriteHeader(&tar.Header{Name: entry.name, Mode: 0o600, Size: int64(len(entry.data))}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(entry.data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gzw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := w.ImportState(buf); err == nil {
+		t.Fatal("expected an archive with an unexpected entry to be refused")
+	}
+	for _, unitID := range []string{"unit1", "unit2"} {
+		if _, err := os.Stat(path.Join(w.dataDir, unitID)); !os.IsNotExist(err) {
+			t.Errorf("expected partly imported unit %s to be removed, got %v", unitID, err)
+		}
+	}
+}
+
+func TestStateArchivePath(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "receptor-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	nc := netceptor.New(context.Background(), "test", nil)
+	w, err := New(context.Background(), nc, tmpdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.stateArchivePath("state.tar.gz"); err == nil {
+		t.Fatal("expected state archives to be disabled without a state dir")
+	}
+	if err := w.SetStateDir("relative"); err == nil {
+		t.Fatal("expected a relative state dir to be refused")
+	}
+	if err := w.SetStateDir(tmpdir); err != nil {
+		t.Fatal(err)
+	}
+	filename, err := w.stateArchivePath("state.tar.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filename != path.Join(tmpdir, "state.tar.gz") {
+		t.Fatalf("unexpected archive path %s", filename)
+	}
+	for _, name := range []string{"", "..", "../state.tar.gz", "/etc/passwd", `sub\\state`} {
+		if _, err := w.stateArchivePath(name); err == nil {
+			t.Errorf("expected archive name %q to be refused", name)
+		}
+	}
+}
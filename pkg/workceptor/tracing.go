@@ -0,0 +1,102 @@
+//go:build !no_workceptor
+// +build !no_workceptor
+
+package workceptor
+
+import (
+	"context"
+
+	"github.com/ansible/receptor/pkg/telemetry"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// unitSpan is the trace span covering the lifetime of a work unit.
+type unitSpan struct {
+	span      trace.Span
+	ctx       context.Context
+	lastState int
+}
+
+// startUnitSpan starts the span of a newly submitted work unit as a child of the span in ctx.
+func (w *Workceptor) startUnitSpan(ctx context.Context, unitID string, workType string, node string,
+	labels map[string]string) {
+	ctx, span := telemetry.Tracer().Start(ctx, "work unit",
+		trace.WithAttributes(
+			attribute.String("receptor.unit_id", unitID),
+			attribute.String("receptor.work_type", workType),
+			attribute.String("receptor.node", node),
+		),
+		trace.WithAttributes(labelAttributes(labels)...))
+	if !span.IsRecording() {
+		return
+	}
+	if displayName := w.nc.NodeDisplayName(); displayName != "" {
+		span.SetAttributes(attribute.String("receptor.node_display_name", displayName))
+	}
+	w.spansLock.Lock()
+	defer w.spansLock.Unlock()
+	w.unitSpans[unitID] = &unitSpan{
+		span:      span,
+		ctx:       ctx,
+		lastState: -1,
+	}
+}
+
+// labelAttributes returns the labels of a work unit as span attributes, named receptor.label.<key>.
+func labelAttributes(labels map[string]string) []attribute.KeyValue {
+	attrs := make([]attribute.KeyValue, 0, len(labels))
+	for k, v := range labels {
+		attrs = append(attrs, attribute.String("receptor.label."+k, v))
+	}
+
+	return attrs
+}
+
+// unitSpanContext returns a context carrying the span of a work unit, or a background context if the unit
+// is not being traced.
+func (w *Workceptor) unitSpanContext(unitID string) context.Context {
+	w.spansLock.Lock()
+	defer w.spansLock.Unlock()
+	us, ok := w.unitSpans[unitID]
+	if !ok {
+		return context.Background()
+	}
+
+	return us.ctx
+}
+
+// traceUnitState records a state change of a work unit on its span, and ends the span once the unit
+// has completed.
+func (w *Workceptor) traceUnitState(unitID string, state int, detail string) {
+	w.spansLock.Lock()
+	defer w.spansLock.Unlock()
+	us, ok := w.unitSpans[unitID]
+	if !ok || us.lastState == state {
+		return
+	}
+	us.lastState = state
+	us.span.AddEvent(WorkStateToString(state), trace.WithAttributes(attribute.String("receptor.detail", detail)))
+	if !IsComplete(state) {
+		return
+	}
+	if state == WorkStateFailed {
+		us.span.SetStatus(codes.Error, detail)
+	}
+	us.span.End()
+	delete(w.unitSpans, unitID)
+}
+
+// endUnitSpan ends the span of a work unit that is released before it completes.
+func (w *Workceptor) endUnitSpan(unitID string) {
+	w.spansLock.Lock()
+	defer w.spansLock.Unlock()
+	us, ok := w.unitSpans[unitID]
+	if !ok {
+		return
+	}
+	us.span.AddEvent("Released")
+	us.span.End()
+	delete(w.unitSpans, unitID)
+}
@@ -0,0 +1,95 @@
+//go:build !no_workceptor
+// +build !no_workceptor
+
+package workceptor
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/ansible/receptor/pkg/netceptor"
+	"github.com/ansible/receptor/pkg/telemetry"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestWorkUnitSpans(t *testing.T) {
+	exp := tracetest.NewInMemoryExporter()
+	telemetry.SetSpanProcessor(sdktrace.NewSimpleSpanProcessor(exp), "test")
+	defer func() {
+		_ = telemetry.Shutdown(context.Background())
+	}()
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "receptor-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	nc := netceptor.New(ctx, "test", nil)
+	if err := nc.SetNodeDisplayName("Test node"); err != nil {
+		t.Fatal(err)
+	}
+	w, err := New(ctx, nc, tmpdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = w.RegisterWorker("command", newCommandWorker)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, finalState := range []int{WorkStateSucceeded, WorkStateFailed} {
+		exp.Reset()
+		parentCtx, parent := telemetry.Tracer().Start(context.Background(), "work submit")
+		unit, err := w.AllocateUnit("command", make(map[string]string))
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.startUnitSpan(parentCtx, unit.ID(), "command", "test", nil)
+		parent.End()
+		unit.UpdateBasicStatus(WorkStateRunning, "Running", 0)
+		unit.UpdateBasicStatus(WorkStateRunning, "Still running", 0)
+		unit.UpdateBasicStatus(finalState, "Finished", 0)
+		unit.UpdateBasicStatus(finalState, "Finished again", 0)
+
+		spans := exp.GetSpans()
+		if len(spans) != 2 {
+			t.Fatalf("expected submit and unit spans, got %d spans", len(spans))
+		}
+		unitSpan := spans[1]
+		if unitSpan.Name != "work unit" || unitSpan.Parent.SpanID() != spans[0].SpanContext.SpanID() {
+			t.Errorf("expected work unit span to be a child of the submit span, got %s with parent %s",
+				unitSpan.Name, unitSpan.Parent.SpanID())
+		}
+		displayName := ""
+		for _, attr := range unitSpan.Attributes {
+			if attr.Key == "receptor.node_display_name" {
+				displayName = attr.Value.AsString()
+			}
+		}
+		if displayName != "Test node" {
+			t.Errorf("expected the node display name on the work unit span, got %q", displayName)
+		}
+		events := make([]string, 0)
+		for _, event := range unitSpan.Events {
+			events = append(events, event.Name)
+		}
+		if len(events) != 2 || events[0] != "Running" || events[1] != WorkStateToString(finalState) {
+			t.Errorf("expected Running and %s events, got %v", WorkStateToString(finalState), events)
+		}
+		expectedCode := codes.Unset
+		if finalState == WorkStateFailed {
+			expectedCode = codes.Error
+		}
+		if unitSpan.Status.Code != expectedCode {
+			t.Errorf("expected span status %v, got %v", expectedCode, unitSpan.Status.Code)
+		}
+		if spanCtx := w.unitSpanContext(unit.ID()); spanCtx != context.Background() {
+			t.Error("expected completed unit to no longer be traced")
+		}
+	}
+}
@@ -0,0 +1,314 @@
+//go:build !no_workceptor
+// +build !no_workceptor
+
+package workceptor
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ansible/receptor/pkg/randstr"
+	"github.com/ansible/receptor/pkg/utils"
+	"github.com/ghjm/cmdline"
+)
+
+// maxUnitIDLength is the longest unit ID that can be generated, to keep unit dir names well within the
+// limits of common filesystems.
+const maxUnitIDLength = 128
+
+// unitIDCounterDigits is the width the {counter} placeholder is zero padded to.  Counters that would not
+// fit are refused, so that IDs always sort in the order they were generated.
+const unitIDCounterDigits = 12
+
+// maxUnitIDCounter is the highest counter that fits in unitIDCounterDigits.
+const maxUnitIDCounter = 999999999999
+
+// unitIDCounterFile is the file in the data dir that records the highest counter used, so that the counter
+// does not go back when the units that used it are released.  Unit IDs cannot start with '.', so it never
+// clashes with a unit dir.
+const unitIDCounterFile = ".unit-id-counter"
+
+// unitIDTimeFormat formats the {time} placeholder, so that IDs sort in the order they were generated.
+const unitIDTimeFormat = "20060102T150405Z"
+
+// unitIDPlaceholders are the placeholders a unit ID template can contain.
+var unitIDPlaceholders = map[string]string{
+	"node":    `[A-Za-z0-9._-]+`,
+	"counter": `(\d+)`,
+	"time":    `\d{8}T\d{6}Z`,
+	"random":  `[0-9A-Za-z]{8}`,
+}
+
+// unitIDLiteralChars are the characters allowed in the literal parts of a unit ID template.  They are safe
+// in a directory name on any platform, and contain no spaces, so IDs can be given in string commands.
+const unitIDLiteralChars = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789._-"
+
+// unitIDPart is a literal string or a placeholder in a unit ID template.
+type unitIDPart struct {
+	literal     string
+	placeholder string
+}
+
+// UnitIDTemplate generates work unit IDs from a template of literal text and placeholders: {node} is the
+// node ID, {counter} is a 12 digit zero padded counter that increases with every ID, {time} is the UTC time the ID
+// was generated, and {random} is eight random letters and digits.  Templates must contain {counter} or
+// {random}, so that IDs are unique.
+type UnitIDTemplate struct {
+	template    string
+	parts       []unitIDPart
+	counted     bool
+	lock        sync.Mutex
+	counter     uint64
+	counterFile string
+}
+
+// ParseUnitIDTemplate parses and validates a unit ID template.
+func ParseUnitIDTemplate(template string) (*UnitIDTemplate, error) {
+	t := &UnitIDTemplate{template: template}
+	unique := false
+	rest := template
+	for rest != "" {
+		open := strings.IndexAny(rest, "{}")
+		if open < 0 {
+			open = len(rest)
+		}
+		if open > 0 {
+			literal := rest[:open]
+			for _, c := range literal {
+				if !strings.ContainsRune(unitIDLiteralChars, c) {
+					return nil, fmt.Errorf("unit ID template %q contains %q: only letters, digits, '.', '_' and '-' are allowed",
+						template, c)
+				}
+			}
+			t.parts = append(t.parts, unitIDPart{literal: literal})
+			rest = rest[open:]
+
+			continue
+		}
+		if rest[0] == '}' {
+			return nil, fmt.Errorf("unit ID template %q has an unmatched '}'", template)
+		}
+		end := strings.IndexByte(rest, '}')
+		if end < 0 {
+			return nil, fmt.Errorf("unit ID template %q has an unmatched '{'", template)
+		}
+		name := rest[1:end]
+		if _, ok := unitIDPlaceholders[name]; !ok {
+			return nil, fmt.Errorf("unit ID template %q has unknown placeholder {%s}: must be {node}, {counter}, {time} or {random}",
+				template, name)
+		}
+		if name == "counter" || name == "random" {
+			unique = true
+		}
+		if name == "counter" {
+			t.counted = true
+		}
+		t.parts = append(t.parts, unitIDPart{placeholder: name})
+		rest = rest[end+1:]
+	}
+	if !unique {
+		return nil, fmt.Errorf("unit ID template %q must contain {counter} or {random} to make IDs unique", template)
+	}
+	if strings.HasPrefix(template, ".") {
+		return nil, fmt.Errorf("unit ID template %q must not start with '.'", template)
+	}
+
+	return t, nil
+}
+
+// String returns the template the IDs are generated from.
+func (t *UnitIDTemplate) String() string {
+	return t.template
+}
+
+// safeNodeID returns a node ID with any characters that are not allowed in unit IDs replaced by '_'.
+func safeNodeID(nodeID string) string {
+	safe := []byte(nodeID)
+	for i := range safe {
+		if !strings.ContainsRune(unitIDLiteralChars, rune(safe[i])) || (i == 0 && safe[i] == '.') {
+			safe[i] = '_'
+		}
+	}
+
+	return string(safe)
+}
+
+// Next generates an ID from the template.
+func (t *UnitIDTemplate) Next(nodeID string, now time.Time) (string, error) {
+	counter, err := t.nextCounter()
+	if err != nil {
+		return "", err
+	}
+	var sb strings.Builder
+	for _, part := range t.parts {
+		switch part.placeholder {
+		case "":
+			sb.WriteString(part.literal)
+		case "node":
+			sb.WriteString(safeNodeID(nodeID))
+		case "counter":
+			fmt.Fprintf(&sb, "%0*d", unitIDCounterDigits, counter)
+		case "time":
+			sb.WriteString(now.UTC().Format(unitIDTimeFormat))
+		case "random":
+			sb.WriteString(randstr.RandomString(8))
+		}
+	}
+	if sb.Len() > maxUnitIDLength {
+		return "", fmt.Errorf("unit ID generated from template %q is longer than %d characters", t.template, maxUnitIDLength)
+	}
+
+	return sb.String(), nil
+}
+
+// nextCounter increases the counter and, if the template has a counter file, records the new counter in
+// it before the counter is used.
+func (t *UnitIDTemplate) nextCounter() (uint64, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if !t.counted {
+		return 0, nil
+	}
+	if t.counter >= maxUnitIDCounter {
+		return 0, fmt.Errorf("unit ID counter of template %q is exhausted", t.template)
+	}
+	t.counter++
+	if t.counterFile != "" {
+		tmpFile := t.counterFile + ".tmp"
+		err := ioutil.WriteFile(tmpFile, []byte(strconv.FormatUint(t.counter, 10)), 0o600)
+		if err == nil {
+			err = os.Rename(tmpFile, t.counterFile)
+		}
+		if err != nil {
+			t.counter--
+
+			return 0, fmt.Errorf("error saving unit ID counter: %s", err)
+		}
+	}
+
+	return t.counter, nil
+}
+
+// matcher returns a regular expression matching the IDs the template generates for a node, with the
+// counter, if there is one, as its first group.
+func (t *UnitIDTemplate) matcher(nodeID string) *regexp.Regexp {
+	var sb strings.Builder
+	sb.WriteString("^")
+	counted := false
+	for _, part := range t.parts {
+		switch {
+		case part.placeholder == "":
+			sb.WriteString(regexp.QuoteMeta(part.literal))
+		case part.placeholder == "node":
+			sb.WriteString(regexp.QuoteMeta(safeNodeID(nodeID)))
+		case part.placeholder == "counter" && counted:
+			sb.WriteString(`\d+`)
+		default:
+			counted = counted || part.placeholder == "counter"
+			sb.WriteString(unitIDPlaceholders[part.placeholder])
+		}
+	}
+	sb.WriteString("$")
+
+	return regexp.MustCompile(sb.String())
+}
+
+// resumeCounter starts the counter after the highest one recorded in the counter file, if there is one, or
+// used by existing IDs, so that IDs carry on increasing across restarts.
+func (t *UnitIDTemplate) resumeCounter(nodeID string, counterFile string, existing []string) {
+	re := t.matcher(nodeID)
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.counterFile = counterFile
+	if counterFile != "" {
+		data, err := ioutil.ReadFile(counterFile)
+		if err == nil {
+			counter, err := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+			if err == nil && counter > t.counter {
+				t.counter = counter
+			}
+		}
+	}
+	for _, id := range existing {
+		m := re.FindStringSubmatch(id)
+		if len(m) < 2 {
+			continue
+		}
+		counter, err := strconv.ParseUint(m[1], 10, 64)
+		if err == nil && counter > t.counter {
+			t.counter = counter
+		}
+	}
+}
+
+// SetUnitIDTemplate sets the template that IDs of new work units are generated from.  If the template has
+// a counter, it carries on from the highest counter recorded in the data dir or used by the units already
+// there.
+func (w *Workceptor) SetUnitIDTemplate(template string) error {
+	t, err := ParseUnitIDTemplate(template)
+	if err != nil {
+		return err
+	}
+	existing := make([]string, 0)
+	files, err := ioutil.ReadDir(w.dataDir)
+	if err == nil {
+		for _, fi := range files {
+			if fi.IsDir() {
+				existing = append(existing, fi.Name())
+			}
+		}
+	}
+	t.resumeCounter(w.nc.NodeID(), path.Join(w.dataDir, unitIDCounterFile), existing)
+	w.unitIDLock.Lock()
+	defer w.unitIDLock.Unlock()
+	w.unitIDTemplate = t
+
+	return nil
+}
+
+// newUnitID returns a candidate ID for a new work unit.
+func (w *Workceptor) newUnitID() (string, error) {
+	w.unitIDLock.Lock()
+	t := w.unitIDTemplate
+	w.unitIDLock.Unlock()
+	if t == nil {
+		return randstr.RandomString(8), nil
+	}
+
+	return t.Next(w.nc.NodeID(), time.Now())
+}
+
+// **************************************************************************
+// Command line
+// **************************************************************************
+
+// workUnitIDCfg is the cmdline configuration object for work unit IDs.
+type workUnitIDCfg struct {
+	Template string `description:"Template for new work unit IDs, made of letters, digits, '.', '_', '-' and the placeholders {node}, {counter}, {time} and {random}" default:"{random}"`
+}
+
+// Prepare verifies the parameters are correct.
+func (cfg workUnitIDCfg) Prepare() error {
+	_, err := ParseUnitIDTemplate(cfg.Template)
+
+	return err
+}
+
+// Run runs the action.
+func (cfg workUnitIDCfg) Run() error {
+	utils.RecordEffectiveConfig("work-unit-id", cfg)
+
+	return MainInstance.SetUnitIDTemplate(cfg.Template)
+}
+
+func init() {
+	cmdline.RegisterConfigTypeForApp("receptor-workers",
+		"work-unit-id", "Format of work unit IDs", workUnitIDCfg{}, cmdline.Singleton, cmdline.Section(workersSection))
+}
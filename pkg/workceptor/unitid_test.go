@@ -0,0 +1,201 @@
+//go:build !no_workceptor
+// +build !no_workceptor
+
+package workceptor
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ansible/receptor/pkg/netceptor"
+)
+
+func TestParseUnitIDTemplate(t *testing.T) {
+	valid := []string{"{random}", "{node}-{counter}", "{node}.{time}.{counter}", "job_{random}", "{counter}{random}"}
+	for _, template := range valid {
+		if _, err := ParseUnitIDTemplate(template); err != nil {
+			t.Errorf("expected template %q to be valid, got %s", template, err)
+		}
+	}
+	invalid := []string{
+		"",                 // nothing to make it unique
+		"{node}-{time}",    // nothing to make it unique
+		"{node}/{counter}", // not filesystem safe
+		"job {counter}",    // spaces split string commands
+		"{counter",         // unmatched brace
+		"counter}",         // unmatched brace
+		"{count}",          // unknown placeholder
+		".{counter}",       // hidden directory
+	}
+	for _, template := range invalid {
+		if _, err := ParseUnitIDTemplate(template); err == nil {
+			t.Errorf("expected template %q to be refused", template)
+		}
+	}
+}
+
+func TestUnitIDTemplateNext(t *testing.T) {
+	tmpl, err := ParseUnitIDTemplate("{node}-{time}-{counter}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "receptor-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	// Characters in the node ID that are not safe in a directory name are replaced
+	pattern := regexp.MustCompile(`^node_1_x-\d{8}T\d{6}Z-\d{12}$`)
+	now := time.Now()
+	ids := make([]string, 0)
+	seen := make(map[string]bool)
+	for i := 0; i < 1000; i++ {
+		id, err := tmpl.Next("node/1 x", now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !pattern.MatchString(id) {
+			t.Fatalf("expected ID %q to follow the template", id)
+		}
+		if seen[id] {
+			t.Fatalf("duplicate ID %q", id)
+		}
+		seen[id] = true
+		ids = append(ids, id)
+		if err := os.Mkdir(path.Join(tmpdir, id), 0o700); err != nil {
+			t.Fatalf("expected ID %q to be usable as a directory name: %s", id, err)
+		}
+	}
+	if !sort.StringsAreSorted(ids) {
+		t.Fatal("expected IDs to sort in the order they were generated")
+	}
+
+	// Node IDs that would be unusable or too long are not passed through
+	if id, _ := tmpl.Next("..", now); id[0] == '.' {
+		t.Errorf("expected a leading '.' in the node ID to be replaced, got %q", id)
+	}
+	long := make([]byte, 200)
+	for i := range long {
+		long[i] = 'a'
+	}
+	if _, err := tmpl.Next(string(long), now); err == nil {
+		t.Error("expected an ID longer than the limit to be refused")
+	}
+}
+
+func TestSetUnitIDTemplate(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "receptor-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	nc := netceptor.New(ctx, "node1", nil)
+	w, err := New(ctx, nc, tmpdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	registerTestWorkTypes(t, w)
+	if err := w.SetUnitIDTemplate("{node} {counter}"); err == nil {
+		t.Fatal("expected an invalid template to be refused")
+	}
+	if err := w.SetUnitIDTemplate("{node}-{counter}"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Units submitted at the same time all get different IDs
+	const count = 50
+	idChan := make(chan string, count)
+	wg := sync.WaitGroup{}
+	for i := 0; i < count; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			unit, err := w.AllocateUnit("echo", nil)
+			if err != nil {
+				t.Error(err)
+
+				return
+			}
+			idChan <- unit.ID()
+		}()
+	}
+	wg.Wait()
+	close(idChan)
+	seen := make(map[string]bool)
+	for id := range idChan {
+		if seen[id] {
+			t.Fatalf("duplicate unit ID %q", id)
+		}
+		seen[id] = true
+	}
+	if len(seen) != count || !seen["node1-000000000001"] || !seen["node1-000000000050"] {
+		t.Fatalf("expected units node1-000000000001 to node1-000000000050, got %v", seen)
+	}
+
+	// After a restart, the counter carries on from the units already in the data dir
+	w2, err := New(ctx, nc, tmpdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	registerTestWorkTypes(t, w2)
+	if err := w2.SetUnitIDTemplate("{node}-{counter}"); err != nil {
+		t.Fatal(err)
+	}
+	unit, err := w2.AllocateUnit("echo", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if unit.ID() != "node1-000000000051" {
+		t.Fatalf("expected the counter to resume at node1-000000000051, got %s", unit.ID())
+	}
+
+	// The counter does not go back when the units that used it have been released
+	for _, id := range w2.ListKnownUnitIDs() {
+		if _, err := w2.ReleaseUnitRetaining(id, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w3, err := New(ctx, nc, tmpdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	registerTestWorkTypes(t, w3)
+	if err := w3.SetUnitIDTemplate("{node}-{counter}"); err != nil {
+		t.Fatal(err)
+	}
+	unit, err = w3.AllocateUnit("echo", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if unit.ID() != "node1-000000000052" {
+		t.Fatalf("expected the counter to resume at node1-000000000052 after release, got %s", unit.ID())
+	}
+}
+
+func TestUnitIDCounterExhausted(t *testing.T) {
+	tmpl, err := ParseUnitIDTemplate("{counter}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl.resumeCounter("node1", "", []string{"999999999998"})
+	id, err := tmpl.Next("node1", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != "999999999999" {
+		t.Fatalf("expected the last counter to be 999999999999, got %s", id)
+	}
+	if _, err := tmpl.Next("node1", time.Now()); err == nil {
+		t.Fatal("expected a counter that does not fit to be refused")
+	}
+}
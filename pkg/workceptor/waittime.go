@@ -0,0 +1,144 @@
+//go:build !no_workceptor
+// +build !no_workceptor
+
+package workceptor
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/ansible/receptor/pkg/logger"
+	"github.com/ansible/receptor/pkg/netceptor"
+)
+
+// startedFileName is the file in a unit dir that records when the unit started.
+const startedFileName = "started"
+
+// waitTimeWindow is how far back the wait times summarized for each work type go.
+const waitTimeWindow = 15 * time.Minute
+
+// maxWaitSamples is the most wait times kept for each work type.
+const maxWaitSamples = 1000
+
+// waitSample is how long one unit waited between being submitted and starting.
+type waitSample struct {
+	at   time.Time
+	wait time.Duration
+}
+
+// unitSubmitted returns when a unit's submission finished, which is when its input was last written.
+func unitSubmitted(unitDir string) (time.Time, bool) {
+	fi, err := os.Stat(path.Join(unitDir, "stdin"))
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	return fi.ModTime(), true
+}
+
+// unitStarted returns when a unit started, if it has.
+func unitStarted(unitDir string) (time.Time, bool) {
+	data, err := ioutil.ReadFile(path.Join(unitDir, startedFileName))
+	if err != nil {
+		return time.Time{}, false
+	}
+	started, err := time.Parse(time.RFC3339Nano, string(data))
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	return started, true
+}
+
+// noteUnitState records when a unit first leaves the pending state, and how long it waited to do so.
+func (w *Workceptor) noteUnitState(unitID string, unitDir string, workType string, state int) {
+	if state == WorkStatePending {
+		return
+	}
+	w.waitLock.Lock()
+	if w.startedUnits[unitID] {
+		w.waitLock.Unlock()
+
+		return
+	}
+	w.startedUnits[unitID] = true
+	w.waitLock.Unlock()
+	if _, ok := unitStarted(unitDir); ok {
+		// Started before a restart, so its wait has already been counted
+		return
+	}
+	now := time.Now()
+	err := ioutil.WriteFile(path.Join(unitDir, startedFileName), []byte(now.Format(time.RFC3339Nano)), 0o600)
+	if err != nil {
+		logger.Warning("Error recording start of work unit %s: %s\n", unitID, err)
+	}
+	submitted, ok := unitSubmitted(unitDir)
+	if !ok {
+		return
+	}
+	wait := now.Sub(submitted)
+	if wait < 0 {
+		wait = 0
+	}
+	w.waitLock.Lock()
+	defer w.waitLock.Unlock()
+	samples := append(w.waitSamples[workType], waitSample{at: now, wait: wait})
+	if len(samples) > maxWaitSamples {
+		samples = samples[len(samples)-maxWaitSamples:]
+	}
+	w.waitSamples[workType] = samples
+}
+
+// forgetUnitStarted removes a released unit from the units known to have started.
+func (w *Workceptor) forgetUnitStarted(unitID string) {
+	w.waitLock.Lock()
+	defer w.waitLock.Unlock()
+	delete(w.startedUnits, unitID)
+}
+
+// percentile returns the nearest-rank percentile of sorted durations.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+
+	return sorted[rank-1]
+}
+
+// waitTimeStats summarizes how long the units of a work type that started within the wait time window
+// waited to start, or returns nil if none did.
+func (w *Workceptor) waitTimeStats(workType string) *netceptor.WaitTimeStats {
+	w.waitLock.Lock()
+	cutoff := time.Now().Add(-waitTimeWindow)
+	samples := w.waitSamples[workType]
+	for len(samples) > 0 && samples[0].at.Before(cutoff) {
+		samples = samples[1:]
+	}
+	if len(samples) == 0 {
+		delete(w.waitSamples, workType)
+	} else {
+		w.waitSamples[workType] = samples
+	}
+	waits := make([]time.Duration, 0, len(samples))
+	for _, s := range samples {
+		waits = append(waits, s.wait)
+	}
+	w.waitLock.Unlock()
+	if len(waits) == 0 {
+		return nil
+	}
+	sort.Slice(waits, func(i, j int) bool {
+		return waits[i] < waits[j]
+	})
+
+	return &netceptor.WaitTimeStats{
+		Units: len(waits),
+		P50:   percentile(waits, 50).Seconds(),
+		P95:   percentile(waits, 95).Seconds(),
+	}
+}
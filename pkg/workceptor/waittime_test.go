@@ -0,0 +1,99 @@
+//go:build !no_workceptor
+// +build !no_workceptor
+
+package workceptor
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/ansible/receptor/pkg/netceptor"
+)
+
+func TestPercentile(t *testing.T) {
+	sorted := make([]time.Duration, 0, 20)
+	for i := 1; i <= 20; i++ {
+		sorted = append(sorted, time.Duration(i)*time.Second)
+	}
+	for _, tc := range []struct {
+		p        float64
+		expected time.Duration
+	}{
+		{50, 10 * time.Second},
+		{95, 19 * time.Second},
+		{100, 20 * time.Second},
+		{0, time.Second},
+	} {
+		if got := percentile(sorted, tc.p); got != tc.expected {
+			t.Fatalf("expected p%v of %v, got %v", tc.p, tc.expected, got)
+		}
+	}
+}
+
+func TestWorkTypeWaitTime(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "receptor-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	w, err := New(ctx, netceptor.New(ctx, "node1", nil), tmpdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.RegisterWorker("hold", newHoldWorker); err != nil {
+		t.Fatal(err)
+	}
+	if stats := w.WorkTypes()["hold"].WaitTime; stats != nil {
+		t.Fatalf("expected no wait time before any unit started, got %v", stats)
+	}
+
+	// Units are submitted together, but held back and started one at a time
+	units := make([]WorkUnit, 0, 3)
+	for i := 0; i < 3; i++ {
+		unit, err := w.AllocateUnit("hold", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path.Join(unit.UnitDir(), "stdin"), []byte("input"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		units = append(units, unit)
+	}
+	for _, unit := range units {
+		time.Sleep(100 * time.Millisecond)
+		if err := unit.Start(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	last, err := w.UnitInfo(ctx, units[2].ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	wait, ok := last["WaitTime"].(float64)
+	if !ok || wait < 0.3 {
+		t.Fatalf("expected the last unit to have waited at least 0.3s, got %v", last["WaitTime"])
+	}
+	if _, ok := last["Started"].(time.Time); !ok {
+		t.Fatalf("expected the start time to be reported, got %v", last["Started"])
+	}
+	stats := w.WorkTypes()["hold"].WaitTime
+	if stats == nil || stats.Units != 3 {
+		t.Fatalf("expected the waits of 3 units, got %v", stats)
+	}
+	if stats.P50 < 0.2 || stats.P95 < 0.3 || stats.P95 < stats.P50 {
+		t.Fatalf("unexpected wait percentiles %+v", stats)
+	}
+
+	// Later status updates do not count the unit again
+	units[0].UpdateBasicStatus(WorkStateSucceeded, "Done", 0)
+	if stats := w.WorkTypes()["hold"].WaitTime; stats.Units != 3 {
+		t.Fatalf("expected a completed unit not to be counted again, got %v", stats)
+	}
+}
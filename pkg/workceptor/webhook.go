@@ -0,0 +1,411 @@
+//go:build !no_workceptor
+// +build !no_workceptor
+
+package workceptor
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/ansible/receptor/pkg/logger"
+)
+
+// webhookFileName is the file in a unit dir that holds the webhook the unit's results are delivered to.
+const webhookFileName = "webhook"
+
+// WebhookSignatureHeader is the HTTP header carrying the HMAC-SHA256 signature of a webhook payload.
+const WebhookSignatureHeader = "X-Receptor-Signature"
+
+// WebhookEventHeader is the HTTP header naming the kind of webhook event, results or completed.
+const WebhookEventHeader = "X-Receptor-Event"
+
+// Webhook events, which are POSTed to the webhook URL as JSON.
+const (
+	// WebhookEventResults carries a chunk of a unit's results, starting at Offset.
+	WebhookEventResults = "results"
+	// WebhookEventCompleted carries the final status of a unit, once all its results have been delivered.
+	WebhookEventCompleted = "completed"
+)
+
+const (
+	// defaultWebhookBatchSize is the most result bytes delivered in one POST.
+	defaultWebhookBatchSize = 64 * 1024
+	// defaultWebhookBatchInterval is how long results are gathered before a partial batch is delivered.
+	defaultWebhookBatchInterval = time.Second
+	// defaultWebhookRetryDelay is the delay before the first retry of a failed POST, doubling for each retry.
+	defaultWebhookRetryDelay = time.Second
+	// webhookMaxAttempts is how many times a POST is tried before its event is given up on.
+	webhookMaxAttempts = 5
+	// webhookTimeout bounds a single POST.
+	webhookTimeout = 30 * time.Second
+)
+
+// WebhookEvent is the JSON body of a webhook POST.
+type WebhookEvent struct {
+	UnitID     string
+	Event      string
+	Offset     int64             `json:",omitempty"`
+	Data       []byte            `json:",omitempty"`
+	State      int               `json:",omitempty"`
+	StateName  string            `json:",omitempty"`
+	Detail     string            `json:",omitempty"`
+	StdoutSize int64             `json:",omitempty"`
+	Labels     map[string]string `json:",omitempty"`
+}
+
+// webhookConfig is the webhook a unit was submitted with, and how much of it has been delivered.  A secret
+// given as a reference to a file or environment variable is saved as the reference, while a literal secret
+// is only kept in memory, so that it is never written to the unit dir.
+type webhookConfig struct {
+	URL       string
+	Secret    string `json:"-"`
+	SecretRef string `json:",omitempty"`
+	Signed    bool   `json:",omitempty"`
+	Delivered int64
+	Completed bool
+}
+
+// Prefixes of webhook secrets that refer to where the secret is kept, rather than being the secret.
+const (
+	webhookSecretFilePrefix = "file:"
+	webhookSecretEnvPrefix  = "env:"
+)
+
+// newWebhookConfig returns the config of a webhook, checking that a secret reference can be resolved.
+func newWebhookConfig(webhookURL string, secret string) (*webhookConfig, error) {
+	cfg := &webhookConfig{URL: webhookURL, Signed: secret != ""}
+	if strings.HasPrefix(secret, webhookSecretFilePrefix) || strings.HasPrefix(secret, webhookSecretEnvPrefix) {
+		cfg.SecretRef = secret
+		if _, err := cfg.secret(); err != nil {
+			return nil, err
+		}
+	} else {
+		cfg.Secret = secret
+	}
+
+	return cfg, nil
+}
+
+// secret returns the secret payloads are signed with, reading it from its reference if there is one.
+func (cfg *webhookConfig) secret() (string, error) {
+	switch {
+	case strings.HasPrefix(cfg.SecretRef, webhookSecretFilePrefix):
+		filename := strings.TrimPrefix(cfg.SecretRef, webhookSecretFilePrefix)
+		data, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return "", fmt.Errorf("could not read webhook secret: %w", err)
+		}
+		secret := strings.TrimSpace(string(data))
+		if secret == "" {
+			return "", fmt.Errorf("webhook secret file %s is empty", filename)
+		}
+
+		return secret, nil
+	case strings.HasPrefix(cfg.SecretRef, webhookSecretEnvPrefix):
+		name := strings.TrimPrefix(cfg.SecretRef, webhookSecretEnvPrefix)
+		secret := os.Getenv(name)
+		if secret == "" {
+			return "", fmt.Errorf("webhook secret environment variable %s is not set", name)
+		}
+
+		return secret, nil
+	}
+
+	return cfg.Secret, nil
+}
+
+// WebhookSignature returns the signature of a webhook payload, as sent in the X-Receptor-Signature header.
+func WebhookSignature(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// validateWebhookURL checks that a webhook URL is an absolute http or https URL.
+func validateWebhookURL(webhookURL string) error {
+	u, err := url.Parse(webhookURL)
+	if err != nil {
+		return fmt.Errorf("invalid webhook URL %s: %s", webhookURL, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("webhook URL %s must be an http or https URL", webhookURL)
+	}
+
+	return nil
+}
+
+// saveWebhookConfig writes the webhook config to a unit dir.
+func saveWebhookConfig(unitDir string, cfg *webhookConfig) error {
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path.Join(unitDir, webhookFileName), data, 0o600)
+}
+
+// loadWebhookConfig reads the webhook config of a unit dir.  Returns nil if the unit has no webhook.
+func loadWebhookConfig(unitDir string) (*webhookConfig, error) {
+	data, err := ioutil.ReadFile(path.Join(unitDir, webhookFileName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	cfg := &webhookConfig{}
+	err = json.Unmarshal(data, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// webhookDelivery delivers the results and final status of a unit to its webhook.
+type webhookDelivery struct {
+	w             *Workceptor
+	unitID        string
+	unitDir       string
+	cfg           *webhookConfig
+	client        *http.Client
+	batchSize     int
+	batchInterval time.Duration
+	retryDelay    time.Duration
+}
+
+// newWebhookDelivery returns a delivery of a unit's results to its webhook, with the default batching.
+func (w *Workceptor) newWebhookDelivery(unitID string, cfg *webhookConfig) *webhookDelivery {
+	return &webhookDelivery{
+		w:             w,
+		unitID:        unitID,
+		unitDir:       path.Join(w.dataDir, unitID),
+		cfg:           cfg,
+		client:        &http.Client{Timeout: webhookTimeout},
+		batchSize:     defaultWebhookBatchSize,
+		batchInterval: defaultWebhookBatchInterval,
+		retryDelay:    defaultWebhookRetryDelay,
+	}
+}
+
+// startWebhook saves the webhook of a newly submitted unit and starts delivering to it.
+func (w *Workceptor) startWebhook(unit WorkUnit, webhookURL string, secret string) error {
+	cfg, err := newWebhookConfig(webhookURL, secret)
+	if err != nil {
+		return err
+	}
+	err = saveWebhookConfig(unit.UnitDir(), cfg)
+	if err != nil {
+		return err
+	}
+	go w.newWebhookDelivery(unit.ID(), cfg).run()
+
+	return nil
+}
+
+// resumeWebhook continues delivering to the webhook of a unit loaded from disk, if it was not finished.
+func (w *Workceptor) resumeWebhook(unitID string) {
+	cfg, err := loadWebhookConfig(path.Join(w.dataDir, unitID))
+	if err != nil {
+		logger.Error("Error reading webhook of work unit %s: %s\n", unitID, err)
+
+		return
+	}
+	if cfg == nil || cfg.Completed {
+		return
+	}
+	if cfg.Signed && cfg.SecretRef == "" {
+		// The receiver expects signed payloads, but a literal secret is not kept across restarts
+		logger.Warning("Not resuming webhook of work unit %s, because its secret was not saved\n", unitID)
+
+		return
+	}
+	go w.newWebhookDelivery(unitID, cfg).run()
+}
+
+// run delivers the unit's results in batches as they are produced, and its status once it completes.  Events
+// that still fail after retrying are skipped, so the receiver should use Offset to detect gaps.
+func (d *webhookDelivery) run() {
+	doneChan := make(chan struct{})
+	defer close(doneChan)
+	resultChan, err := d.w.GetResults(d.unitID, d.cfg.Delivered, doneChan)
+	if err != nil {
+		logger.Error("Error reading results of work unit %s for webhook: %s\n", d.unitID, err)
+
+		return
+	}
+	batch := make([]byte, 0, d.batchSize)
+	ticker := time.NewTicker(d.batchInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case data, ok := <-resultChan:
+			if !ok {
+				if d.deliverResults(batch) {
+					d.deliverCompletion()
+				}
+
+				return
+			}
+			for len(data) > 0 {
+				n := d.batchSize - len(batch)
+				if n > len(data) {
+					n = len(data)
+				}
+				batch = append(batch, data[:n]...)
+				data = data[n:]
+				if len(batch) >= d.batchSize {
+					if !d.deliverResults(batch) {
+						return
+					}
+					batch = batch[:0]
+				}
+			}
+		case <-ticker.C:
+			if _, err := os.Stat(d.unitDir); os.IsNotExist(err) {
+				// The unit was released
+				return
+			}
+			if !d.deliverResults(batch) {
+				return
+			}
+			batch = batch[:0]
+		case <-d.w.ctx.Done():
+			return
+		}
+	}
+}
+
+// deliverResults POSTs a batch of results, and records that they were delivered.  A batch that still fails
+// after retrying is skipped and recorded as delivered, but one interrupted by shutdown is not, so that it is
+// sent again when delivery resumes.  Returns false if delivery should stop.
+func (d *webhookDelivery) deliverResults(batch []byte) bool {
+	if len(batch) == 0 {
+		return true
+	}
+	err := d.post(&WebhookEvent{
+		UnitID: d.unitID,
+		Event:  WebhookEventResults,
+		Offset: d.cfg.Delivered,
+		Data:   batch,
+	})
+	if err != nil {
+		if d.w.ctx.Err() != nil {
+			return false
+		}
+		logger.Warning("Skipping %d bytes of results of work unit %s for webhook: %s\n", len(batch), d.unitID, err)
+	}
+	d.cfg.Delivered += int64(len(batch))
+	d.save()
+
+	return true
+}
+
+// deliverCompletion POSTs the final status of the unit.
+func (d *webhookDelivery) deliverCompletion() {
+	unit, err := d.w.findUnit(d.unitID)
+	if err != nil {
+		logger.Error("Error finding work unit %s for webhook: %s\n", d.unitID, err)
+
+		return
+	}
+	status := unit.Status()
+	labels, err := readLabels(d.unitDir)
+	if err != nil {
+		logger.Warning("Error reading labels of work unit %s for webhook: %s\n", d.unitID, err)
+	}
+	err = d.post(&WebhookEvent{
+		UnitID:     d.unitID,
+		Event:      WebhookEventCompleted,
+		State:      status.State,
+		StateName:  WorkStateToString(status.State),
+		Detail:     status.Detail,
+		StdoutSize: status.StdoutSize,
+		Labels:     labels,
+	})
+	if err != nil {
+		if d.w.ctx.Err() != nil {
+			return
+		}
+		logger.Warning("Giving up on completion of work unit %s for webhook: %s\n", d.unitID, err)
+	}
+	d.cfg.Completed = true
+	d.save()
+}
+
+// save records the progress of the delivery, so it can be resumed after a restart.
+func (d *webhookDelivery) save() {
+	if _, err := os.Stat(d.unitDir); os.IsNotExist(err) {
+		return
+	}
+	err := saveWebhookConfig(d.unitDir, d.cfg)
+	if err != nil {
+		logger.Error("Error saving webhook of work unit %s: %s\n", d.unitID, err)
+	}
+}
+
+// post POSTs an event to the webhook, retrying with a doubling delay if it fails.
+func (d *webhookDelivery) post(event *WebhookEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	delay := d.retryDelay
+	for attempt := 1; ; attempt++ {
+		err = d.postOnce(event.Event, body)
+		if err == nil {
+			return nil
+		}
+		if attempt == webhookMaxAttempts {
+			return err
+		}
+		logger.Debug("Webhook POST for work unit %s failed, attempt %d: %s\n", d.unitID, attempt, err)
+		if sleepOrDone(d.w.ctx.Done(), delay) {
+			return d.w.ctx.Err()
+		}
+		delay *= 2
+	}
+}
+
+// postOnce makes a single POST to the webhook.
+func (d *webhookDelivery) postOnce(eventName string, body []byte) error {
+	ctx, cancel := context.WithTimeout(d.w.ctx, webhookTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookEventHeader, eventName)
+	if d.cfg.Signed {
+		secret, err := d.cfg.secret()
+		if err != nil {
+			return err
+		}
+		req.Header.Set(WebhookSignatureHeader, WebhookSignature(secret, body))
+	}
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	_ = resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+
+	return nil
+}
@@ -0,0 +1,272 @@
+//go:build !no_workceptor
+// +build !no_workceptor
+
+package workceptor
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// webhookRecorder is a webhook receiver that records the events POSTed to it.
+type webhookRecorder struct {
+	lock     sync.Mutex
+	events   []*WebhookEvent
+	failures int
+	secret   string
+	t        *testing.T
+}
+
+func (wr *webhookRecorder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+
+		return
+	}
+	wr.lock.Lock()
+	defer wr.lock.Unlock()
+	if wr.failures > 0 {
+		wr.failures--
+		w.WriteHeader(http.StatusServiceUnavailable)
+
+		return
+	}
+	sig := r.Header.Get(WebhookSignatureHeader)
+	if wr.secret != "" && sig != WebhookSignature(wr.secret, body) {
+		wr.t.Errorf("webhook signature %q does not match the payload", sig)
+	} else if wr.secret == "" && sig != "" {
+		wr.t.Errorf("unexpected webhook signature %q with no secret", sig)
+	}
+	event := &WebhookEvent{}
+	if err := json.Unmarshal(body, event); err != nil {
+		wr.t.Errorf("invalid webhook payload: %s", err)
+	}
+	if r.Header.Get(WebhookEventHeader) != event.Event {
+		wr.t.Errorf("expected event header %s, got %s", event.Event, r.Header.Get(WebhookEventHeader))
+	}
+	wr.events = append(wr.events, event)
+}
+
+// waitForEvents waits until the recorder has received at least count events, and returns them.
+func (wr *webhookRecorder) waitForEvents(t *testing.T, count int) []*WebhookEvent {
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		wr.lock.Lock()
+		events := append([]*WebhookEvent{}, wr.events...)
+		wr.lock.Unlock()
+		if len(events) >= count {
+			return events
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %d webhook events", count)
+
+	return nil
+}
+
+// newWebhookTestUnit returns a unit, and a delivery of its results to a webhook that batches and retries quickly.
+func newWebhookTestUnit(ctx context.Context, t *testing.T, webhookURL string, secret string) (WorkUnit, *webhookDelivery) {
+	w := newTestWorkceptor(ctx, t)
+	unit, err := w.AllocateUnit("command", make(map[string]string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := newWebhookConfig(webhookURL, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := saveWebhookConfig(unit.UnitDir(), cfg); err != nil {
+		t.Fatal(err)
+	}
+	d := w.newWebhookDelivery(unit.ID(), cfg)
+	d.batchSize = 10
+	d.batchInterval = 50 * time.Millisecond
+	d.retryDelay = 10 * time.Millisecond
+
+	return unit, d
+}
+
+// appendStdout adds data to the stdout of a unit.
+func appendStdout(t *testing.T, unit WorkUnit, data string) {
+	f, err := os.OpenFile(path.Join(unit.UnitDir(), "stdout"), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(data); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWebhookDelivery(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	recorder := &webhookRecorder{secret: "s3cret", t: t}
+	server := httptest.NewServer(recorder)
+	defer server.Close()
+	unit, d := newWebhookTestUnit(ctx, t, server.URL, "s3cret")
+
+	unit.UpdateBasicStatus(WorkStateRunning, "Running", 0)
+	appendStdout(t, unit, "first")
+	go d.run()
+	// Progress is delivered while the unit is still running
+	events := recorder.waitForEvents(t, 1)
+	if events[0].Event != WebhookEventResults || events[0].Offset != 0 || string(events[0].Data) != "first" {
+		t.Fatalf("unexpected first event %+v", events[0])
+	}
+
+	appendStdout(t, unit, " and the rest")
+	unit.UpdateBasicStatus(WorkStateSucceeded, "Finished", 18)
+	var results string
+	for {
+		events = recorder.waitForEvents(t, len(events)+1)
+		last := events[len(events)-1]
+		if last.Event == WebhookEventCompleted {
+			if last.StateName != "Succeeded" || last.StdoutSize != 18 || last.UnitID != unit.ID() {
+				t.Fatalf("unexpected completion event %+v", last)
+			}
+
+			break
+		}
+		if len(last.Data) > 10 {
+			t.Fatalf("expected results in batches of at most 10 bytes, got %d", len(last.Data))
+		}
+	}
+	for _, event := range events[:len(events)-1] {
+		if event.Offset != int64(len(results)) {
+			t.Fatalf("expected event at offset %d, got %d", len(results), event.Offset)
+		}
+		results += string(event.Data)
+	}
+	if results != "first and the rest" {
+		t.Fatalf("webhook received results %q", results)
+	}
+	cfg, err := loadWebhookConfig(unit.UnitDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cfg.Completed || cfg.Delivered != 18 {
+		t.Fatalf("expected delivery to be recorded as complete, got %+v", cfg)
+	}
+}
+
+func TestWebhookRetry(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	recorder := &webhookRecorder{failures: 2, t: t}
+	server := httptest.NewServer(recorder)
+	defer server.Close()
+	unit, d := newWebhookTestUnit(ctx, t, server.URL, "")
+
+	appendStdout(t, unit, "done")
+	unit.UpdateBasicStatus(WorkStateFailed, "exit status 1", 4)
+	d.run()
+	events := recorder.waitForEvents(t, 2)
+	if len(events) != 2 || string(events[0].Data) != "done" || events[1].Event != WebhookEventCompleted {
+		t.Fatalf("expected results and completion after retries, got %+v", events)
+	}
+}
+
+func TestWebhookShutdown(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	recorder := &webhookRecorder{failures: 1000, t: t}
+	server := httptest.NewServer(recorder)
+	defer server.Close()
+	unit, d := newWebhookTestUnit(ctx, t, server.URL, "")
+	d.retryDelay = time.Minute
+
+	appendStdout(t, unit, "done")
+	unit.UpdateBasicStatus(WorkStateSucceeded, "Finished", 4)
+	finished := make(chan struct{})
+	go func() {
+		d.run()
+		close(finished)
+	}()
+	for {
+		recorder.lock.Lock()
+		failures := recorder.failures
+		recorder.lock.Unlock()
+		if failures < 1000 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	select {
+	case <-finished:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for delivery to stop")
+	}
+
+	// Results interrupted by shutdown are sent again when delivery resumes
+	cfg, err := loadWebhookConfig(unit.UnitDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Delivered != 0 || cfg.Completed {
+		t.Fatalf("expected nothing to be recorded as delivered, got %+v", cfg)
+	}
+}
+
+func TestWebhookSecret(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// A literal secret is not written to the unit dir
+	unit, _ := newWebhookTestUnit(ctx, t, "https://example.com/hook", "s3cret")
+	data, err := ioutil.ReadFile(path.Join(unit.UnitDir(), webhookFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "s3cret") {
+		t.Fatalf("webhook secret saved in %s", data)
+	}
+
+	// A reference is saved, and read when signing
+	secretFile := path.Join(unit.UnitDir(), "secret")
+	if err := ioutil.WriteFile(secretFile, []byte("from a file\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	recorder := &webhookRecorder{secret: "from a file", t: t}
+	server := httptest.NewServer(recorder)
+	defer server.Close()
+	unit, d := newWebhookTestUnit(ctx, t, server.URL, "file:"+secretFile)
+	cfg, err := loadWebhookConfig(unit.UnitDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.SecretRef != "file:"+secretFile || !cfg.Signed {
+		t.Fatalf("expected the secret reference to be saved, got %+v", cfg)
+	}
+	unit.UpdateBasicStatus(WorkStateSucceeded, "Finished", 0)
+	d.run()
+	recorder.waitForEvents(t, 1)
+
+	for _, ref := range []string{"file:" + path.Join(unit.UnitDir(), "missing"), "env:RECEPTOR_TEST_NO_SUCH_SECRET"} {
+		if _, err := newWebhookConfig("https://example.com/hook", ref); err == nil {
+			t.Errorf("expected secret reference %s to be refused", ref)
+		}
+	}
+}
+
+func TestWebhookValidation(t *testing.T) {
+	for _, u := range []string{"ftp://example.com/hook", "/hook", "http://", "not a url"} {
+		if err := validateWebhookURL(u); err == nil {
+			t.Errorf("expected %q to be rejected", u)
+		}
+	}
+	if err := validateWebhookURL("https://example.com/hook"); err != nil {
+		t.Error(err)
+	}
+}
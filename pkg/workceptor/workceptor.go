@@ -18,24 +18,60 @@ import (
 	"github.com/ansible/receptor/pkg/controlsvc"
 	"github.com/ansible/receptor/pkg/logger"
 	"github.com/ansible/receptor/pkg/netceptor"
-	"github.com/ansible/receptor/pkg/randstr"
 	"github.com/ansible/receptor/pkg/utils"
 )
 
 // Workceptor is the main object that handles unit-of-work management.
 type Workceptor struct {
-	ctx             context.Context
-	nc              *netceptor.Netceptor
-	dataDir         string
-	workTypesLock   *sync.RWMutex
-	workTypes       map[string]*workType
-	activeUnitsLock *sync.RWMutex
-	activeUnits     map[string]WorkUnit
+	ctx                context.Context
+	nc                 *netceptor.Netceptor
+	dataDir            string
+	workTypesLock      *sync.RWMutex
+	workTypes          map[string]*workType
+	drainedTypes       map[string]bool
+	unitIDLock         *sync.Mutex
+	unitIDTemplate     *UnitIDTemplate
+	paramsLock         *sync.RWMutex
+	maxParamsSize      int64
+	activeUnitsLock    *sync.RWMutex
+	activeUnits        map[string]WorkUnit
+	releaseLock        *sync.RWMutex
+	releaseRetention   time.Duration
+	quotaLock          *sync.Mutex
+	diskQuota          int64
+	quotaPolicy        string
+	diskUsage          int64
+	unitUsage          map[string]int64
+	quotaWarned        bool
+	evicting           bool
+	idempotencyLock    *sync.Mutex
+	idempotencyKeys    map[string]string
+	spansLock          *sync.Mutex
+	unitSpans          map[string]*unitSpan
+	resultBufferLock   *sync.RWMutex
+	resultBufferSize   int64
+	resultBufferPolicy string
+	storageLock        *sync.Mutex
+	storageErr         error
+	storageProbedAt    time.Time
+	waitLock           *sync.Mutex
+	startedUnits       map[string]bool
+	stateDirLock       *sync.RWMutex
+	stateDir           string
+	waitSamples        map[string][]waitSample
+	reassignLock       *sync.Mutex
+	reassignSecrets    map[string]map[string]string
+	progressLock       *sync.Mutex
+	progressReaders    map[string]*progressReader
+	progressSubs       map[chan ProgressUpdate]struct{}
 }
 
 // workType is the record for a registered type of work.
 type workType struct {
 	newWorkerFunc NewWorkerFunc
+	reassignable  bool
+	params        []string
+	paramSchema   *ParamSchema
 }
 
 // New constructs a new Workceptor instance.
@@ -44,19 +80,52 @@ func New(ctx context.Context, nc *netceptor.Netceptor, dataDir string) (*Workcep
 		dataDir = path.Join(os.TempDir(), "receptor")
 	}
 	dataDir = path.Join(dataDir, nc.NodeID())
+	if err := validateDataDir(dataDir); err != nil {
+		return nil, err
+	}
 	w := &Workceptor{
-		ctx:             ctx,
-		nc:              nc,
-		dataDir:         dataDir,
-		workTypesLock:   &sync.RWMutex{},
-		workTypes:       make(map[string]*workType),
-		activeUnitsLock: &sync.RWMutex{},
-		activeUnits:     make(map[string]WorkUnit),
+		ctx:                ctx,
+		nc:                 nc,
+		dataDir:            dataDir,
+		workTypesLock:      &sync.RWMutex{},
+		workTypes:          make(map[string]*workType),
+		drainedTypes:       make(map[string]bool),
+		unitIDLock:         &sync.Mutex{},
+		paramsLock:         &sync.RWMutex{},
+		maxParamsSize:      DefaultMaxParamsSize,
+		activeUnitsLock:    &sync.RWMutex{},
+		activeUnits:        make(map[string]WorkUnit),
+		releaseLock:        &sync.RWMutex{},
+		releaseRetention:   DefaultReleaseRetention,
+		quotaLock:          &sync.Mutex{},
+		quotaPolicy:        QuotaPolicyReject,
+		unitUsage:          make(map[string]int64),
+		idempotencyLock:    &sync.Mutex{},
+		spansLock:          &sync.Mutex{},
+		unitSpans:          make(map[string]*unitSpan),
+		resultBufferLock:   &sync.RWMutex{},
+		resultBufferSize:   DefaultResultBufferSize,
+		resultBufferPolicy: utils.RetryBufferBlock,
+		storageLock:        &sync.Mutex{},
+		waitLock:           &sync.Mutex{},
+		startedUnits:       make(map[string]bool),
+		stateDirLock:       &sync.RWMutex{},
+		waitSamples:        make(map[string][]waitSample),
+		reassignLock:       &sync.Mutex{},
+		reassignSecrets:    make(map[string]map[string]string),
+		progressLock:       &sync.Mutex{},
+		progressReaders:    make(map[string]*progressReader),
+		progressSubs:       make(map[chan ProgressUpdate]struct{}),
 	}
 	err := w.RegisterWorker("remote", newRemoteWorker)
 	if err != nil {
 		return nil, fmt.Errorf("could not register remote worker function: %s", err)
 	}
+	if err := w.registerWorkTypes(); err != nil {
+		return nil, err
+	}
+	nc.SetWorkCommandInfoFunc(w.WorkTypes)
+	go w.monitorReleasedUnits(time.Minute)
 
 	return w, nil
 }
@@ -82,6 +151,13 @@ func (w *Workceptor) RegisterWithControlService(cs *controlsvc.Server) error {
 	if err != nil {
 		return fmt.Errorf("could not add work control function: %s", err)
 	}
+	err = cs.AddControlFunc("state", &stateCommandType{
+		w: w,
+	})
+	if err != nil {
+		return fmt.Errorf("could not add state control function: %s", err)
+	}
+	cs.AddEventSource(w.progressEvents)
 
 	return nil
 }
@@ -122,9 +198,11 @@ func (w *Workceptor) generateUnitID(lock bool) (string, error) {
 		w.activeUnitsLock.RLock()
 		defer w.activeUnitsLock.RUnlock()
 	}
-	var ident string
 	for {
-		ident = randstr.RandomString(8)
+		ident, err := w.newUnitID()
+		if err != nil {
+			return "", err
+		}
 		_, ok := w.activeUnits[ident]
 		if !ok {
 			unitdir := path.Join(w.dataDir, ident)
@@ -142,10 +220,34 @@ func (w *Workceptor) generateUnitID(lock bool) (string, error) {
 func (w *Workceptor) AllocateUnit(workTypeName string, params map[string]string) (WorkUnit, error) {
 	w.workTypesLock.RLock()
 	wt, ok := w.workTypes[workTypeName]
+	var reassignable bool
+	var paramSchema *ParamSchema
+	if ok {
+		reassignable = wt.reassignable
+		paramSchema = wt.paramSchema
+	}
+	drained := w.drainedTypes[workTypeName]
 	w.workTypesLock.RUnlock()
 	if !ok {
 		return nil, fmt.Errorf("unknown work type %s", workTypeName)
 	}
+	if drained {
+		return nil, fmt.Errorf("%w: %s is not accepting new work", ErrWorkTypeDrained, workTypeName)
+	}
+	if err := w.checkParamsSize(params); err != nil {
+		return nil, err
+	}
+	if paramSchema != nil {
+		if err := paramSchema.Validate(params); err != nil {
+			return nil, err
+		}
+	}
+	if err := w.checkDiskQuota(); err != nil {
+		return nil, err
+	}
+	if err := w.checkStorage(); err != nil {
+		return nil, err
+	}
 	w.activeUnitsLock.Lock()
 	defer w.activeUnitsLock.Unlock()
 	ident, err := w.generateUnitID(false)
@@ -157,7 +259,12 @@ func (w *Workceptor) AllocateUnit(workTypeName string, params map[string]string)
 	if err == nil {
 		err = worker.Save()
 	}
+	if err == nil && reassignable {
+		err = w.saveReassignParams(ident, worker.UnitDir(), params)
+	}
 	if err != nil {
+		w.noteStorageError(err)
+
 		return nil, err
 	}
 	w.activeUnits[ident] = worker
@@ -249,14 +356,20 @@ func (w *Workceptor) scanForUnit(unitID string) {
 			logger.Warning("Failed to restart worker %s due to read error: %s", unitdir, err)
 			worker.UpdateBasicStatus(WorkStateFailed, fmt.Sprintf("Failed to restart: %s", err), stdoutSize(unitdir))
 		}
-		err = worker.Restart()
+		if !retainUntil(unitdir).IsZero() {
+			// Released units are kept for their results, but not restarted
+			err = nil
+		} else {
+			err = worker.Restart()
+		}
 		if err != nil && !IsPending(err) {
 			logger.Warning("Failed to restart worker %s: %s", unitdir, err)
 			worker.UpdateBasicStatus(WorkStateFailed, fmt.Sprintf("Failed to restart: %s", err), stdoutSize(unitdir))
 		}
 		w.activeUnitsLock.Lock()
-		defer w.activeUnitsLock.Unlock()
 		w.activeUnits[ident] = worker
+		w.activeUnitsLock.Unlock()
+		w.resumeWebhook(ident)
 	}
 }
 
@@ -267,6 +380,9 @@ func (w *Workceptor) scanForUnits() {
 	}
 	for i := range files {
 		fi := files[i]
+		if !fi.IsDir() {
+			continue
+		}
 		w.scanForUnit(fi.Name())
 	}
 }
@@ -348,6 +464,21 @@ func (w *Workceptor) unitStatusForCFR(unitID string) (map[string]interface{}, er
 		retMap[t.Field(i).Name] = v.Field(i).Interface()
 	}
 	retMap["StateName"] = WorkStateToString(status.State)
+	if until := retainUntil(path.Join(w.dataDir, unitID)); !until.IsZero() {
+		retMap["RetainUntil"] = until
+	}
+	labels, err := readLabels(path.Join(w.dataDir, unitID))
+	if err != nil {
+		logger.Warning("Error reading labels of work unit %s: %s\n", unitID, err)
+	} else if labels != nil {
+		retMap["Labels"] = labels
+	}
+	progress, err := w.unitProgress(unitID, path.Join(w.dataDir, unitID))
+	if err != nil {
+		logger.Warning("Error reading progress of work unit %s: %s\n", unitID, err)
+	} else if progress != nil {
+		retMap["Progress"] = progress
+	}
 
 	return retMap, nil
 }
@@ -364,6 +495,16 @@ func sleepOrDone(doneChan <-chan struct{}, interval time.Duration) bool {
 
 // GetResults returns a live stream of the results of a unit.
 func (w *Workceptor) GetResults(unitID string, startPos int64, doneChan chan struct{}) (chan []byte, error) {
+	return w.GetResultsRange(unitID, startPos, -1, doneChan)
+}
+
+// GetResultsRange returns a live stream of at most length bytes of the results of a unit, starting at
+// startPos.  A negative length streams to the end of the results.  Clients that lose their connection can
+// resume from the number of bytes they received.
+func (w *Workceptor) GetResultsRange(unitID string, startPos int64, length int64, doneChan chan struct{}) (chan []byte, error) {
+	if startPos < 0 {
+		return nil, fmt.Errorf("start position must not be negative")
+	}
 	w.scanForUnit(unitID)
 	w.activeUnitsLock.RLock()
 	unit, ok := w.activeUnits[unitID]
@@ -404,6 +545,7 @@ func (w *Workceptor) GetResults(unitID string, startPos int64, doneChan chan str
 		var stdout *os.File
 		var err error
 		filePos := startPos
+		endPos := startPos + length
 		for {
 			if sleepOrDone(doneChan, 250*time.Millisecond) {
 				return
@@ -429,10 +571,25 @@ func (w *Workceptor) GetResults(unitID string, startPos int64, doneChan chan str
 				}
 				var n int
 				buf := make([]byte, utils.NormalBufferSize)
+				if length >= 0 && endPos-filePos < int64(len(buf)) {
+					buf = buf[:endPos-filePos]
+				}
 				n, err = stdout.Read(buf)
 				if n > 0 {
 					filePos += int64(n)
-					resultChan <- buf[:n]
+					select {
+					case resultChan <- buf[:n]:
+					case <-doneChan:
+						stdout.Close()
+
+						return
+					}
+				}
+				if length >= 0 && filePos >= endPos {
+					stdout.Close()
+					close(resultChan)
+
+					return
 				}
 			}
 			if err == io.EOF {
@@ -471,10 +628,86 @@ type Workers struct {
 	Python []Python `mapstructure:"python"`
 	// Workers interfacing with k8s.
 	Kubernetes []Kubernetes `mapstructure:"kubernetes"`
+	// Workers delegating to a local agent.
+	Agent []Agent `mapstructure:"agent"`
+	// How long to keep the results of released work units. Defaults to 0, which deletes them immediately.
+	ReleaseRetention *time.Duration `mapstructure:"release-retention"`
+	// Maximum total size of the work data dir, such as 500M or 10G. Defaults to unlimited.
+	DiskQuota string `mapstructure:"disk-quota"`
+	// What to do when the data dir nears its disk quota: reject new work, or evict the oldest completed units.
+	DiskQuotaPolicy string `mapstructure:"disk-quota-policy"`
+	// Maximum size of results received from a remote node but not yet written to disk. Defaults to 1M.
+	ResultBufferSize string `mapstructure:"result-buffer-size"`
+	// What to do when the result buffer is full: block, or restart the stream. Defaults to block.
+	ResultBufferOverflow string `mapstructure:"result-buffer-overflow"`
+	// Template for new work unit IDs, with placeholders {node}, {counter}, {time} and {random}. Defaults to {random}.
+	UnitIDTemplate string `mapstructure:"unit-id-template"`
+	// Largest total size of the params of a submitted work unit, such as 64K or 1M. Defaults to 1M, 0 for no limit.
+	MaxParamsSize string `mapstructure:"max-params-size"`
+	// Directory that the state control command exports archives to and imports them from. Defaults to none, which disables it.
+	StateDir string `mapstructure:"state-dir"`
 }
 
 // Setup attaches all its workers to a workceptor.
 func (s *Workers) Setup(wc *Workceptor) error {
+	if s.ReleaseRetention != nil {
+		wc.SetReleaseRetention(*s.ReleaseRetention)
+	}
+
+	if s.DiskQuota != "" {
+		limit, err := ParseByteSize(s.DiskQuota)
+		if err != nil {
+			return fmt.Errorf("could not parse disk quota from workers config: %w", err)
+		}
+		policy := s.DiskQuotaPolicy
+		if policy == "" {
+			policy = QuotaPolicyReject
+		}
+		if err := wc.SetDiskQuota(limit, policy); err != nil {
+			return fmt.Errorf("could not set disk quota from workers config: %w", err)
+		}
+	}
+
+	if s.ResultBufferSize != "" || s.ResultBufferOverflow != "" {
+		size := int64(DefaultResultBufferSize)
+		if s.ResultBufferSize != "" {
+			var err error
+			size, err = ParseByteSize(s.ResultBufferSize)
+			if err != nil {
+				return fmt.Errorf("could not parse result buffer size from workers config: %w", err)
+			}
+		}
+		overflow := s.ResultBufferOverflow
+		if overflow == "" {
+			overflow = utils.RetryBufferBlock
+		}
+		if err := wc.SetResultBuffer(size, overflow); err != nil {
+			return fmt.Errorf("could not set result buffer from workers config: %w", err)
+		}
+	}
+
+	if s.MaxParamsSize != "" {
+		size, err := ParseByteSize(s.MaxParamsSize)
+		if err != nil {
+			return fmt.Errorf("could not parse max params size from workers config: %w", err)
+		}
+		if err := wc.SetMaxParamsSize(size); err != nil {
+			return fmt.Errorf("could not set max params size from workers config: %w", err)
+		}
+	}
+
+	if s.UnitIDTemplate != "" {
+		if err := wc.SetUnitIDTemplate(s.UnitIDTemplate); err != nil {
+			return fmt.Errorf("could not set unit ID template from workers config: %w", err)
+		}
+	}
+
+	if s.StateDir != "" {
+		if err := wc.SetStateDir(s.StateDir); err != nil {
+			return fmt.Errorf("could not set state dir from workers config: %w", err)
+		}
+	}
+
 	for _, w := range s.Command {
 		if err := w.setup(wc); err != nil {
 			return fmt.Errorf("could not setup command worker from workers config: %w", err)
@@ -493,5 +726,11 @@ func (s *Workers) Setup(wc *Workceptor) error {
 		}
 	}
 
+	for _, w := range s.Agent {
+		if err := w.setup(wc); err != nil {
+			return fmt.Errorf("could not setup agent worker from workers config: %w", err)
+		}
+	}
+
 	return nil
 }
@@ -76,3 +76,12 @@ func (w *Workceptor) ReleaseUnit(unitID string, force bool) error {
 func (w *Workceptor) GetResults(unitID string, startPos int64, doneChan chan struct{}) (chan []byte, error) {
 	return nil, ErrNotImplemented
 }
+
+// RegisterWorkType adds a type of work to every Workceptor created from now on
+func RegisterWorkType(name string, factory NewWorkerFunc) {
+}
+
+// GetResultsRange returns a live stream of part of the results of a unit
+func (w *Workceptor) GetResultsRange(unitID string, startPos int64, length int64, doneChan chan struct{}) (chan []byte, error) {
+	return nil, ErrNotImplemented
+}
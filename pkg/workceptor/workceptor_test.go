@@ -0,0 +1,43 @@
+//go:build !no_workceptor
+// +build !no_workceptor
+
+package workceptor
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/ansible/receptor/pkg/netceptor"
+)
+
+// newTestWorkceptor returns a Workceptor with the command worker registered, keeping its data in a temporary
+// directory that is removed when the test finishes.
+func newTestWorkceptor(ctx context.Context, t *testing.T) *Workceptor {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "receptor-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		os.RemoveAll(tmpdir)
+	})
+
+	return newTestWorkceptorInDir(ctx, t, tmpdir)
+}
+
+// newTestWorkceptorInDir is newTestWorkceptor keeping its data in dataDir, so that a test can start another
+// Workceptor on the same data.
+func newTestWorkceptorInDir(ctx context.Context, t *testing.T, dataDir string) *Workceptor {
+	nc := netceptor.New(ctx, "test", nil)
+	w, err := New(ctx, nc, dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = w.RegisterWorker("command", newCommandWorker)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return w
+}
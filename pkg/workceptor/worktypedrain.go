@@ -0,0 +1,55 @@
+//go:build !no_workceptor
+// +build !no_workceptor
+
+package workceptor
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/ansible/receptor/pkg/logger"
+)
+
+// ErrWorkTypeDrained is returned when work is submitted of a type that has been drained.
+var ErrWorkTypeDrained = errors.New("work type is drained")
+
+// DrainWorkType stops this node accepting new units of a work type, such as while its runtime is upgraded,
+// without affecting units that were already submitted or other work types.  The drained state is kept
+// apart from the work type's registration, so it lasts until UndrainWorkType is called, including across
+// a reload.
+func (w *Workceptor) DrainWorkType(typeName string) error {
+	return w.setWorkTypeDrained(typeName, true)
+}
+
+// UndrainWorkType lets this node accept new units of a work type drained by DrainWorkType again.
+func (w *Workceptor) UndrainWorkType(typeName string) error {
+	return w.setWorkTypeDrained(typeName, false)
+}
+
+func (w *Workceptor) setWorkTypeDrained(typeName string, drained bool) error {
+	w.workTypesLock.Lock()
+	defer w.workTypesLock.Unlock()
+	if _, ok := w.workTypes[typeName]; !ok || typeName == "remote" {
+		return fmt.Errorf("unknown work type %s", typeName)
+	}
+	if w.drainedTypes[typeName] == drained {
+		return nil
+	}
+	if drained {
+		w.drainedTypes[typeName] = true
+		logger.Info("Work type %s is drained, and new units of it will be refused\n", typeName)
+	} else {
+		delete(w.drainedTypes, typeName)
+		logger.Info("Work type %s is no longer drained\n", typeName)
+	}
+
+	return nil
+}
+
+// WorkTypeDrained returns true if new units of a work type are being refused.
+func (w *Workceptor) WorkTypeDrained(typeName string) bool {
+	w.workTypesLock.RLock()
+	defer w.workTypesLock.RUnlock()
+
+	return w.drainedTypes[typeName]
+}
@@ -0,0 +1,99 @@
+//go:build !no_workceptor
+// +build !no_workceptor
+
+package workceptor
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/ansible/receptor/pkg/netceptor"
+)
+
+// runWorkCommand runs a work control command given in string form, with no connection.
+func runWorkCommand(t *testing.T, w *Workceptor, nc *netceptor.Netceptor, command string) (map[string]interface{}, error) {
+	cc, err := (&workceptorCommandType{w: w}).InitFromString(command)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cc.ControlFunc(nc, nil)
+}
+
+func TestDrainWorkType(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "receptor-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	nc := netceptor.New(ctx, "node1", nil)
+	w, err := New(ctx, nc, tmpdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	registerTestWorkTypes(t, w)
+	running := startTestUnit(t, w, "hold", nil, "input")
+	defer running.Cancel()
+
+	cfr, err := runWorkCommand(t, w, nc, "drain hold")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfr["drained"] != "hold" {
+		t.Fatalf("unexpected drain response %v", cfr)
+	}
+
+	// New units of the drained type are refused, while other types are still accepted
+	if _, err := w.AllocateUnit("hold", nil); !errors.Is(err, ErrWorkTypeDrained) {
+		t.Fatalf("expected a drained work type to refuse new units, got %v", err)
+	}
+	echo := startTestUnit(t, w, "echo", map[string]string{"suffix": "!"}, "input")
+	defer echo.Release(true)
+
+	// Units that were already running are not affected
+	if state := running.Status().State; IsComplete(state) {
+		t.Fatalf("expected the running unit to be unaffected by the drain, got state %s", WorkStateToString(state))
+	}
+
+	// The drained state is listed with the work types
+	cfr, err = runWorkCommand(t, w, nc, "types")
+	if err != nil {
+		t.Fatal(err)
+	}
+	types, ok := cfr["node1"].(map[string]netceptor.WorkCommandInfo)
+	if !ok {
+		t.Fatalf("unexpected work types %v", cfr["node1"])
+	}
+	if !types["hold"].Drained || types["echo"].Drained {
+		t.Fatalf("expected only hold to be listed as drained, got %v", types)
+	}
+
+	cfr, err = runWorkCommand(t, w, nc, "undrain hold")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfr["undrained"] != "hold" {
+		t.Fatalf("unexpected undrain response %v", cfr)
+	}
+	if w.WorkTypeDrained("hold") {
+		t.Fatal("expected hold to no longer be drained")
+	}
+	another := startTestUnit(t, w, "hold", nil, "input")
+	defer another.Cancel()
+
+	// Only registered work types can be drained
+	if _, err := runWorkCommand(t, w, nc, "drain bogus"); err == nil {
+		t.Error("expected draining an unknown work type to fail")
+	}
+	if _, err := runWorkCommand(t, w, nc, "drain remote"); err == nil {
+		t.Error("expected draining the remote work type to fail")
+	}
+	if _, err := (&workceptorCommandType{w: w}).InitFromString("drain"); err == nil {
+		t.Error("expected work drain without a work type to fail")
+	}
+}
@@ -0,0 +1,122 @@
+//go:build !no_workceptor
+// +build !no_workceptor
+
+package workceptor
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/ansible/receptor/pkg/netceptor"
+)
+
+// SetWorkTypeParams declares the params that can be given when submitting units of a work type, so clients
+// can discover them.  Work types without declared params do not list any.
+func (w *Workceptor) SetWorkTypeParams(typeName string, params []string) error {
+	w.workTypesLock.Lock()
+	defer w.workTypesLock.Unlock()
+	wt, ok := w.workTypes[typeName]
+	if !ok {
+		return fmt.Errorf("unknown work type %s", typeName)
+	}
+	wt.params = append([]string{}, params...)
+	sort.Strings(wt.params)
+
+	return nil
+}
+
+// SetWorkTypeParamSchema sets a schema that the params of units of a work type are validated against when
+// they are submitted, before the unit is created.  The params the schema describes are also declared, as with
+// SetWorkTypeParams.  A nil schema turns validation off.
+func (w *Workceptor) SetWorkTypeParamSchema(typeName string, schema *ParamSchema) error {
+	w.workTypesLock.Lock()
+	defer w.workTypesLock.Unlock()
+	wt, ok := w.workTypes[typeName]
+	if !ok {
+		return fmt.Errorf("unknown work type %s", typeName)
+	}
+	wt.paramSchema = schema
+	if schema != nil {
+		wt.params = schema.Params()
+	}
+
+	return nil
+}
+
+// setParamSchemaFile loads a work type's param schema from a file, if one is configured.
+func (w *Workceptor) setParamSchemaFile(typeName string, filename string) error {
+	if filename == "" {
+		return nil
+	}
+	schema, err := LoadParamSchema(filename)
+	if err != nil {
+		return err
+	}
+
+	return w.SetWorkTypeParamSchema(typeName, schema)
+}
+
+// WorkTypes describes the work types this node can run, keyed by name.  The remote work type, which every
+// node has, is not included.
+func (w *Workceptor) WorkTypes() map[string]netceptor.WorkCommandInfo {
+	types := make(map[string]netceptor.WorkCommandInfo)
+	w.workTypesLock.RLock()
+	for name, wt := range w.workTypes {
+		if name == "remote" {
+			continue
+		}
+		types[name] = netceptor.WorkCommandInfo{
+			Params:       wt.params,
+			Reassignable: wt.reassignable,
+			Drained:      w.drainedTypes[name],
+			WaitTime:     w.waitTimeStats(name),
+		}
+	}
+	w.workTypesLock.RUnlock()
+	w.activeUnitsLock.RLock()
+	units := make([]WorkUnit, 0, len(w.activeUnits))
+	for _, unit := range w.activeUnits {
+		units = append(units, unit)
+	}
+	w.activeUnitsLock.RUnlock()
+	for _, unit := range units {
+		status := unit.Status()
+		info, ok := types[status.WorkType]
+		if !ok || IsComplete(status.State) {
+			continue
+		}
+		info.ActiveUnits++
+		types[status.WorkType] = info
+	}
+
+	return types
+}
+
+// MeshWorkTypes describes the work types of the nodes on the mesh, keyed by node and then by work type.
+// This node's work types are current, and other nodes' are as of their last control service advertisement.
+// Nodes running an older version advertise only the names of their work types.  If node is not empty,
+// only that node is included, and it is an error if it does not advertise a control service.
+func (w *Workceptor) MeshWorkTypes(node string) (map[string]map[string]netceptor.WorkCommandInfo, error) {
+	nodes := make(map[string]map[string]netceptor.WorkCommandInfo)
+	if node == "" || node == w.nc.NodeID() {
+		nodes[w.nc.NodeID()] = w.WorkTypes()
+	}
+	for _, ad := range w.nc.Status().Advertisements {
+		if ad.NodeID == w.nc.NodeID() || ad.Tags["type"] != "Control Service" {
+			continue
+		}
+		if node != "" && ad.NodeID != node {
+			continue
+		}
+		types := make(map[string]netceptor.WorkCommandInfo)
+		for _, wc := range ad.WorkCommands {
+			types[wc] = ad.WorkCommandInfo[wc]
+		}
+		nodes[ad.NodeID] = types
+	}
+	if node != "" && len(nodes) == 0 {
+		return nil, fmt.Errorf("node %s does not advertise a control service", node)
+	}
+
+	return nodes, nil
+}
@@ -0,0 +1,148 @@
+//go:build !no_workceptor
+// +build !no_workceptor
+
+package workceptor
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ansible/receptor/pkg/backends/backendstest"
+	"github.com/ansible/receptor/pkg/controlsvc"
+	"github.com/ansible/receptor/pkg/netceptor"
+)
+
+// registerTestWorkTypes registers a reassignable hold work type and an echo work type taking a suffix.
+func registerTestWorkTypes(t *testing.T, w *Workceptor) {
+	if err := w.RegisterWorker("hold", newHoldWorker); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.SetWorkTypeReassignable("hold", true); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.RegisterWorker("echo", newEchoWorker); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.SetWorkTypeParams("echo", []string{"suffix"}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWorkTypesCommand(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "receptor-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	nc := netceptor.New(ctx, "node1", nil)
+	w, err := New(ctx, nc, tmpdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	registerTestWorkTypes(t, w)
+	if err := w.SetWorkTypeParams("bogus", nil); err == nil {
+		t.Fatal("expected params for an unknown work type to be refused")
+	}
+	hold := startTestUnit(t, w, "hold", nil, "input")
+	defer hold.Cancel()
+
+	cc, err := (&workceptorCommandType{w: w}).InitFromString("types")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfr, err := cc.ControlFunc(nc, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	types, ok := cfr["node1"].(map[string]netceptor.WorkCommandInfo)
+	if !ok {
+		t.Fatalf("unexpected work types %v", cfr["node1"])
+	}
+	// The started hold unit's wait is covered in TestWorkTypeWaitTime
+	holdInfo := types["hold"]
+	if holdInfo.WaitTime == nil || holdInfo.WaitTime.Units != 1 {
+		t.Fatalf("expected the hold unit's wait time to be recorded, got %v", holdInfo.WaitTime)
+	}
+	holdInfo.WaitTime = nil
+	// Work types registered globally by other tests are also listed
+	got := map[string]netceptor.WorkCommandInfo{"hold": holdInfo, "echo": types["echo"]}
+	expected := map[string]netceptor.WorkCommandInfo{
+		"hold": {Params: nil, Reassignable: true, ActiveUnits: 1},
+		"echo": {Params: []string{"suffix"}},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected %v, got %v", expected, cfr["node1"])
+	}
+
+	// Nodes without a control service are an error
+	cc, err = (&workceptorCommandType{w: w}).InitFromJSON(map[string]interface{}{"subcommand": "types", "node": "node2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cc.ControlFunc(nc, nil); err == nil {
+		t.Fatal("expected work types for an unknown node to fail")
+	}
+}
+
+func TestMeshWorkTypes(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "receptor-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	n1, n2 := backendstest.ConnectedNodes(ctx, t)
+	defer n1.Shutdown()
+	defer n2.Shutdown()
+	w1, err := New(ctx, n1, tmpdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w2, err := New(ctx, n2, tmpdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	registerTestWorkTypes(t, w2)
+	cs := controlsvc.New(true, n2)
+	if err := w2.RegisterWithControlService(cs); err != nil {
+		t.Fatal(err)
+	}
+	if err := cs.RunControlSvc(ctx, "control", nil, "", 0, "", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// node1 learns node2's work types from its control service advertisement
+	expected := map[string]netceptor.WorkCommandInfo{
+		"hold": {Reassignable: true},
+		"echo": {Params: []string{"suffix"}},
+	}
+	var types map[string]map[string]netceptor.WorkCommandInfo
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		types, err = w1.MeshWorkTypes("node2")
+		if err == nil && reflect.DeepEqual(types["node2"], expected) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected node2 to have work types %v, got %v (%v)", expected, types, err)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if _, ok := types["node1"]; ok {
+		t.Fatal("expected only node2 to be listed")
+	}
+	types, err = w1.MeshWorkTypes("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(types) != 2 || len(types["node1"]) != 0 || !reflect.DeepEqual(types["node2"], expected) {
+		t.Fatalf("unexpected mesh work types %v", types)
+	}
+}
@@ -171,8 +171,11 @@ func (sfd *StatusFileData) Save(filename string) error {
 func (bwu *BaseWorkUnit) Save() error {
 	bwu.statusLock.RLock()
 	defer bwu.statusLock.RUnlock()
+	err := bwu.status.Save(bwu.statusFileName)
+	bwu.w.noteStorageError(err)
+	bwu.w.updateUnitUsage(bwu.unitID, bwu.unitDir)
 
-	return bwu.status.Save(bwu.statusFileName)
+	return err
 }
 
 // loadFromFile loads status from an already open file.
@@ -210,8 +213,10 @@ func (sfd *StatusFileData) Load(filename string) error {
 func (bwu *BaseWorkUnit) Load() error {
 	bwu.statusLock.Lock()
 	defer bwu.statusLock.Unlock()
+	err := bwu.status.Load(bwu.statusFileName)
+	bwu.w.updateUnitUsage(bwu.unitID, bwu.unitDir)
 
-	return bwu.status.Load(bwu.statusFileName)
+	return err
 }
 
 // UpdateFullStatus atomically updates the status metadata file.  Changes should be made in the callback function.
@@ -272,7 +277,11 @@ func (bwu *BaseWorkUnit) UpdateFullStatus(statusFunc func(*StatusFileData)) {
 	bwu.lastUpdateError = err
 	if err != nil {
 		logger.Error("Error updating status file %s: %s.", bwu.statusFileName, err)
+		bwu.w.noteStorageError(err)
 	}
+	bwu.w.updateUnitUsage(bwu.unitID, bwu.unitDir)
+	bwu.w.traceUnitState(bwu.unitID, bwu.status.State, bwu.status.Detail)
+	bwu.w.noteUnitState(bwu.unitID, bwu.unitDir, bwu.status.WorkType, bwu.status.State)
 }
 
 // UpdateBasicStatus atomically updates key fields in the status metadata file.  Errors are logged rather than returned.
@@ -296,7 +305,11 @@ func (bwu *BaseWorkUnit) UpdateBasicStatus(state int, detail string, stdoutSize
 	bwu.lastUpdateError = err
 	if err != nil {
 		logger.Error("Error updating status file %s: %s.", bwu.statusFileName, err)
+		bwu.w.noteStorageError(err)
 	}
+	bwu.w.updateUnitUsage(bwu.unitID, bwu.unitDir)
+	bwu.w.traceUnitState(bwu.unitID, bwu.status.State, bwu.status.Detail)
+	bwu.w.noteUnitState(bwu.unitID, bwu.unitDir, bwu.status.WorkType, bwu.status.State)
 }
 
 // LastUpdateError returns the last error (including nil) resulting from an UpdateBasicStatus or UpdateFullStatus.
@@ -355,6 +368,9 @@ loop:
 				}
 			}
 		}
+		if _, err := bwu.w.unitProgress(bwu.unitID, bwu.unitDir); err != nil {
+			logger.Debug("Error reading progress of work unit %s: %s\n", bwu.unitID, err)
+		}
 		complete := IsComplete(bwu.Status().State)
 		if complete {
 			break
@@ -412,6 +428,11 @@ func (bwu *BaseWorkUnit) Release(force bool) error {
 	bwu.w.activeUnitsLock.Lock()
 	defer bwu.w.activeUnitsLock.Unlock()
 	delete(bwu.w.activeUnits, bwu.unitID)
+	bwu.w.forgetUnitUsage(bwu.unitID)
+	bwu.w.endUnitSpan(bwu.unitID)
+	bwu.w.forgetUnitStarted(bwu.unitID)
+	bwu.w.forgetReassignSecrets(bwu.unitID)
+	bwu.w.forgetProgress(bwu.unitID)
 
 	return nil
 }
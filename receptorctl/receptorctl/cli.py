@@ -1,5 +1,7 @@
 import sys
 import os
+import json
+import base64
 import time
 import select
 import fcntl
@@ -40,9 +42,15 @@ class IgnoreRequiredWithHelp(click.Group):
 @click.option('--key', default=None, help="Client private key filename")
 @click.option('--cert', default=None, help="Client certificate filename")
 @click.option('--insecureskipverify', default=False, help="Accept any server cert", show_default=True)
-def cli(ctx, socket, config, tlsclient, rootcas, key, cert, insecureskipverify):
+@click.option('--node', 'targetnode', default=None, envvar='RECEPTORCTL_NODE', required=False, show_envvar=True,
+              help="Run commands on this remote node's control service, via the mesh")
+@click.option('--node-service', 'nodeservice', default="control", show_default=True,
+              help="Control service name on the remote node")
+@click.option('--node-tls-client', 'nodetlsclient', default="", help="TLS client config name used when connecting to the remote node")
+def cli(ctx, socket, config, tlsclient, rootcas, key, cert, insecureskipverify, targetnode, nodeservice, nodetlsclient):
     ctx.obj = dict()
-    ctx.obj['rc'] = ReceptorControl(socket, config=config, tlsclient=tlsclient, rootcas=rootcas, key=key, cert=cert, insecureskipverify=insecureskipverify)
+    ctx.obj['rc'] = ReceptorControl(socket, config=config, tlsclient=tlsclient, rootcas=rootcas, key=key, cert=cert, insecureskipverify=insecureskipverify,
+                                    node=targetnode, node_service=nodeservice, node_tlsclient=nodetlsclient)
 def get_rc(ctx):
     return ctx.obj['rc']
 
@@ -55,12 +63,22 @@ def status(ctx):
 
     node_id = status.pop('NodeID')
     print(f"Node ID: {node_id}")
+    display_name = status.pop('NodeDisplayName', None)
+    if display_name:
+        print(f"Display Name: {display_name}")
     version = status.pop('Version')
     print(f"Version: {version}")
     sysCPU = status.pop('SystemCPUCount')
     print(f"System CPU Count: {sysCPU}")
     sysMemory = status.pop('SystemMemoryMiB')
     print(f"System Memory MiB: {sysMemory}")
+    expired = status.pop('ExpiredMessages', None)
+    if expired:
+        print(f"Messages Dropped at Hop Limit: {expired}")
+    buffers = status.pop('SessionBuffers', None)
+    if buffers and (buffers['Used'] or buffers['Refused']):
+        limit = buffers['Limit'] if buffers['Limit'] > 0 else 'unlimited'
+        print(f"Session Buffers: {buffers['Used']} of {limit} bytes used, {buffers['Refused']} sessions closed for lack of space")
 
     longest_node = 12
 
@@ -94,6 +112,20 @@ def status(ctx):
             print(f"{node:<{longest_node}} ", end="")
             pprint(costs[node])
 
+    skews = status.pop('ClockSkew', None)
+    if skews:
+        print()
+        print(f"{'Clock Skew':<{longest_node}} Offset (seconds)")
+        for node in skews:
+            print(f"{node:<{longest_node}} {skews[node]:+.3f}")
+
+    roles = status.pop('NodeRoles', None)
+    if roles:
+        print()
+        print(f"{'Node':<{longest_node}} Role")
+        for node in roles:
+            print(f"{node:<{longest_node}} {roles[node]}")
+
     routes = status.pop('RoutingTable', None)
     if routes:
         print()
@@ -137,6 +169,25 @@ def status(ctx):
             )
             print(commands)
 
+    listeners = status.pop('ListenerConnections', None)
+    if listeners:
+        print()
+        print(f"{'Service':<{longest_node}} Active    Limit     Waiting")
+        for service in listeners:
+            lc = listeners[service]
+            limit = lc['Limit'] if lc['Limit'] > 0 else '-'
+            print(f"{service:<{longest_node}} {lc['Active']:<9} {limit:<9} {lc['Waiting']}")
+
+    control_conns = status.pop('ControlConnections', None)
+    if control_conns:
+        longest_listener = max(len('Control Listener'), *(len(name) for name in control_conns))
+        print()
+        print(f"{'Control Listener':<{longest_listener}} Active    Limit     Total     Rejected")
+        for name in sorted(control_conns):
+            cc = control_conns[name]
+            limit = cc['Limit'] if cc['Limit'] > 0 else '-'
+            print(f"{name:<{longest_listener}} {cc['Active']:<9} {limit:<9} {cc['Total']:<9} {cc['Rejected']}")
+
     if status:
         print("Additional data returned from Receptor:")
         pprint(status)
@@ -178,6 +229,236 @@ def reload(ctx):
         else:
             sys.exit(4)
 
+@cli.command(help="Reopen the log file after it has been rotated.")
+@click.pass_context
+def logrotate(ctx):
+    rc = get_rc(ctx)
+    results = rc.simple_command("logrotate")
+    if "Success" in results and results["Success"]:
+        print(f"Reopened log file {results['Filename']}")
+    else:
+        print(f"Error: {results['Error']}")
+        sys.exit(1)
+
+@cli.command(help="List connections bridged by proxy services.")
+@click.pass_context
+def connections(ctx):
+    rc = get_rc(ctx)
+    results = rc.simple_command("connections")
+    if not results:
+        print("No active connections")
+        return
+    print(f"{'ID':<6} {'Source':<30} {'Destination':<30} {'In':>10} {'Out':>10} Age")
+    for conn_id in sorted(results, key=lambda c: int(c)):
+        conn = results[conn_id]
+        print(f"{conn_id:<6} {conn['Source']:<30} {conn['Destination']:<30} "
+              f"{conn['BytesIn']:>10} {conn['BytesOut']:>10} {conn['Age']}")
+
+@cli.command(help="List the lock files of Unix sockets and who holds them.")
+@click.pass_context
+def locks(ctx):
+    rc = get_rc(ctx)
+    results = rc.simple_command("locks")
+    if not results:
+        print("No socket lock files")
+        return
+    print(f"{'Lock File':<50} {'PID':>8} State")
+    for filename in sorted(results):
+        lock = results[filename]
+        if lock['Held']:
+            state = "held"
+        elif lock['Stale']:
+            state = "stale"
+        else:
+            state = "free"
+        pid = lock['PID'] if lock['PID'] else '-'
+        print(f"{filename:<50} {pid:>8} {state}")
+
+@cli.group(help="Commands related to individual bridged connections")
+def connection():
+    pass
+
+@connection.command(help="Close both ends of a bridged connection.")
+@click.pass_context
+@click.argument('connection_id')
+def kill(ctx, connection_id):
+    rc = get_rc(ctx)
+    results = rc.simple_command(f"connection kill {connection_id}")
+    if "Success" in results and results["Success"]:
+        print(f"Closed connection {connection_id}")
+    else:
+        print(f"Error: {results['Error']}")
+        sys.exit(1)
+
+@cli.command(help="Show or change the peers allowed to connect to the node.")
+@click.pass_context
+@click.option('--set', 'peers', type=str, help="Comma separated list of node IDs to allow.")
+@click.option('--all', 'allow_all', is_flag=True, help="Allow all peers to connect.")
+@click.option('--drop', is_flag=True, help="Close existing connections to peers that are no longer allowed.")
+def allowedpeers(ctx, peers, allow_all, drop):
+    if peers and allow_all:
+        print("Cannot use both --set and --all.")
+        sys.exit(1)
+    rc = get_rc(ctx)
+    if peers:
+        command = f"allowedpeers set {peers}"
+        if drop:
+            command += " --drop"
+    elif allow_all:
+        command = "allowedpeers all"
+    else:
+        command = "allowedpeers show"
+    results = rc.simple_command(command)
+    if results["AllowedPeers"] is None:
+        print("Allowed peers: all")
+    else:
+        print(f"Allowed peers: {', '.join(results['AllowedPeers'])}")
+    if results.get("Dropped"):
+        print(f"Dropped connections: {', '.join(results['Dropped'])}")
+
+@cli.command(name="display-name", help="Show or change the human-friendly name of the node.")
+@click.pass_context
+@click.option('--set', 'name', type=str, help="Display name to give the node.")
+@click.option('--clear', is_flag=True, help="Remove the node's display name.")
+def display_name(ctx, name, clear):
+    if name and clear:
+        print("Cannot use both --set and --clear.")
+        sys.exit(1)
+    rc = get_rc(ctx)
+    if name:
+        results = rc.simple_command(json.dumps({"command": "displayname", "subcommand": "set", "name": name}))
+    elif clear:
+        results = rc.simple_command("displayname clear")
+    else:
+        results = rc.simple_command("displayname show")
+    print(f"Node ID: {results['NodeID']}")
+    print(f"Display name: {results['NodeDisplayName'] or '(none)'}")
+
+@cli.command(name="service-cache", help="Show or clear the nodes that services dialed by name were last reached on.")
+@click.pass_context
+@click.option('--clear', is_flag=True, help="Clear the cache, so services are resolved again on the next dial.")
+@click.argument('service', required=False)
+def service_cache(ctx, clear, service):
+    if service and not clear:
+        print("A service can only be given with --clear.")
+        sys.exit(1)
+    rc = get_rc(ctx)
+    if clear:
+        command = "service cache clear"
+        if service:
+            command += f" {service}"
+        results = rc.simple_command(command)
+        if results["Cleared"]:
+            print(f"Cleared: {', '.join(results['Cleared'])}")
+        else:
+            print("Nothing to clear")
+        return
+    results = rc.simple_command("service cache")
+    services = results["Services"]
+    if not services:
+        print("No cached services")
+        return
+    print(f"{'Service':<10} {'Node':<30} Age")
+    for name in sorted(services):
+        print(f"{name:<10} {services[name]['NodeID']:<30} {services[name]['Age']}")
+
+@cli.command(help="Send a routing update right away instead of waiting for the periodic one.")
+@click.pass_context
+@click.option('--neighbors', is_flag=True, help="Also ask the node's neighbors to send routing updates.")
+def reconverge(ctx, neighbors):
+    rc = get_rc(ctx)
+    command = "reconverge neighbors" if neighbors else "reconverge"
+    results = rc.simple_command(command)
+    print("Sent routing update")
+    if results.get("Neighbors"):
+        print(f"Asked neighbors: {', '.join(results['Neighbors'])}")
+
+@cli.command(help="Show the traffic exchanged with each node and service.")
+@click.pass_context
+@click.option('--reset', is_flag=True, help="Clear the counters after showing them.")
+def traffic(ctx, reset):
+    rc = get_rc(ctx)
+    command = "traffic reset" if reset else "traffic"
+    results = rc.simple_command(command)
+    print(json.dumps(results["Traffic"], indent=4))
+
+@cli.command(help="Stream events from the node, such as backends reconnecting, until interrupted.")
+@click.pass_context
+def events(ctx):
+    rc = get_rc(ctx)
+    rc.connect()
+    rc.writestr("events\n")
+    header = rc.readstr()
+    if header != "Streaming events":
+        raise RuntimeError(header[7:] if header.startswith("ERROR: ") else f"Unexpected response: {header}")
+    try:
+        while True:
+            line = rc.readstr()
+            if not line:
+                return
+            print(line, flush=True)
+    except KeyboardInterrupt:
+        pass
+
+@cli.command(help="Capture a goroutine, heap or CPU profile of the node, for go tool pprof.")
+@click.pass_context
+@click.argument('profile', type=click.Choice(['goroutine', 'heap', 'cpu']))
+@click.option('--duration', default="30s", help="How long to capture a CPU profile for.")
+@click.option('--output', '-o', type=click.Path(), required=True, help="File to write the profile to.")
+def profile(ctx, profile, duration, output):
+    rc = get_rc(ctx)
+    command = f"profile cpu {duration}" if profile == "cpu" else f"profile {profile}"
+    results = rc.simple_command(command)
+    with open(output, "wb") as f:
+        f.write(base64.b64decode(results["Data"]))
+    print(f"Wrote {profile} profile to {output}")
+
+@cli.command(help="Show the memory statistics of the node.")
+@click.pass_context
+@click.option('--gc', is_flag=True, default=False, help="Force a garbage collection before reporting.")
+def memstats(ctx, gc):
+    rc = get_rc(ctx)
+    command = "memstats --gc" if gc else "memstats"
+    results = rc.simple_command(command)
+    print(json.dumps(results, indent=4))
+
+@cli.group(help="Commands related to the node configuration")
+def config():
+    pass
+
+@config.command(help="Show the effective running configuration, with secrets redacted.")
+@click.pass_context
+@click.option('--yaml', 'as_yaml', is_flag=True, help="Show the configuration as YAML instead of JSON.")
+def show(ctx, as_yaml):
+    rc = get_rc(ctx)
+    if as_yaml:
+        results = rc.simple_command("config show effective yaml")
+        print(results["YAML"], end="")
+    else:
+        results = rc.simple_command("config show effective json")
+        print(json.dumps(results["EffectiveConfig"], indent=4))
+
+@cli.command(name="test-backend", help="Try connecting with a backend dialer config, without adding it to the node.")
+@click.pass_context
+@click.argument('backend')
+@click.argument('config')
+@click.option('--timeout', default="10s", help="How long to wait for the connection.")
+def test_backend(ctx, backend, config, timeout):
+    rc = get_rc(ctx)
+    try:
+        backend_config = json.loads(config)
+    except json.JSONDecodeError as e:
+        raise click.BadParameter(f"must be JSON: {e}", param_hint="CONFIG")
+    params = {"backend": backend, "config": backend_config, "timeout": timeout}
+    results = rc.simple_command(f"test-backend {json.dumps(params)}")
+    if results["Connected"]:
+        print(f"Connected in {results['TimeStr']}")
+        for k, v in sorted(results.get("Details", {}).items()):
+            print(f"{k}: {v}")
+    else:
+        print(f"Failed after {results['TimeStr']}: {results['Error']}")
+        sys.exit(1)
+
 @cli.command(help="Do a traceroute to a Receptor node.")
 @click.pass_context
 @click.argument('node')
@@ -192,6 +473,56 @@ def traceroute(ctx, node):
             print(f"{resno}: {resval['From']} in {resval['TimeStr']}")
 
 
+@cli.command(help="Explain whether and how a Receptor node can be reached.")
+@click.pass_context
+@click.argument('node')
+def diagnose(ctx, node):
+    rc = get_rc(ctx)
+    results = rc.simple_command(f"diagnose {node}")
+    print(f"Node ID: {results['NodeID']}")
+    print(f"Known: {results['Known']}")
+    if results['LastSeen']:
+        last_seen = dateutil.parser.parse(results['LastSeen'])
+        print(f"Last Seen: {last_seen:%Y-%m-%d %H:%M:%S}")
+    print(f"Reachable: {results['Reachable']}")
+    if results['Reachable']:
+        print(f"Path: {' -> '.join(results['Path'] or [])} (cost {results['PathCost']})")
+    print(f"Direct Connection: {results['Direct']}")
+    if results['Links']:
+        print()
+        print(f"{'Link':<12} {'Cost':<6} Reachable")
+        for link in results['Links']:
+            print(f"{link['NodeID']:<12} {link['Cost']:<6} {link['Reachable']}")
+    if results['Problems']:
+        print()
+        print("Problems:")
+        for problem in results['Problems']:
+            print(f"  {problem}")
+
+
+@cli.command(help="Show which known Receptor nodes can be reached from this node.")
+@click.pass_context
+@click.option('--probe', help="Ping each node to confirm it responds", is_flag=True)
+def reachability(ctx, probe):
+    rc = get_rc(ctx)
+    results = rc.simple_command("reachability --probe" if probe else "reachability")
+    header = f"{'Node':<20} {'Reachable':<10} {'Cost':<8} {'Next Hop':<20} {'Last Seen':<20}"
+    if probe:
+        header += " Probe"
+    print(header)
+    for node in sorted(results):
+        r = results[node]
+        last_seen = ""
+        if r['LastSeen']:
+            last_seen = f"{dateutil.parser.parse(r['LastSeen']):%Y-%m-%d %H:%M:%S}"
+        cost = r['PathCost'] if r['Reachable'] else "-"
+        line = f"{node:<20} {str(r['Reachable']):<10} {cost:<8} {r['NextHop']:<20} {last_seen:<20}"
+        if probe:
+            p = r['Probe']
+            line += f" {p['TimeStr']}" if p['Success'] else f" failed: {p['Error']}"
+        print(line)
+
+
 @cli.command(help="Connect the local terminal to a Receptor service on a remote node.")
 @click.pass_context
 @click.argument('node')
@@ -254,15 +585,19 @@ def version(ctx):
 @click.option('--node', default=None, type=str, help="Receptor node to list work from. Defaults to the local node.")
 @click.option('--unit_id', type=str, required=False, default="", help="Only show detail for a specific unit id")
 @click.option('--tls-client', 'tlsclient', type=str, default="", help="TLS client config name used when connecting to remote node")
+@click.option('--label', type=str, multiple=True, help="Only list units with this label (key=value format)")
 @click.pass_context
-def list_units(ctx, unit_id, node, tlsclient, quiet):
+def list_units(ctx, unit_id, node, tlsclient, quiet, label):
     rc = get_rc(ctx)
     if node:
         rc.connect_to_service(node, "control", tlsclient)
         rc.handshake()
+    command = "work list"
     if unit_id:
-        unit_id = " " + unit_id
-    work = rc.simple_command("work list" + unit_id)
+        command += " " + unit_id
+    for lbl in label:
+        command += " --label " + lbl
+    work = rc.simple_command(command)
     if quiet:
         for k in work.keys():
             print(k)
@@ -270,6 +605,48 @@ def list_units(ctx, unit_id, node, tlsclient, quiet):
         pprint(work)
 
 
+@work.command(name="types", help="List the work types that nodes on the mesh can run.")
+@click.option('--node', default=None, type=str, help="Only list work types of this node. Defaults to all nodes.")
+@click.pass_context
+def list_work_types(ctx, node):
+    rc = get_rc(ctx)
+    command = "work types"
+    if node:
+        command += f" {node}"
+    nodes = rc.simple_command(command)
+    for node_id in sorted(nodes):
+        print(f"{node_id}:")
+        types = nodes[node_id]
+        if not types:
+            print("   (none)")
+        for work_type in sorted(types):
+            info = types[work_type] or {}
+            params = info.get("Params")
+            params = ", ".join(params) if params else "-"
+            reassignable = "yes" if info.get("Reassignable") else "no"
+            drained = "  (drained)" if info.get("Drained") else ""
+            print(f"   {work_type:<20} Active: {info.get('ActiveUnits', 0):<5} Reassignable: {reassignable:<4} Params: {params}{drained}")
+            wait = info.get("WaitTime")
+            if wait:
+                print(f"   {'':<20} Wait p50: {wait['P50']:.1f}s  p95: {wait['P95']:.1f}s  ({wait['Units']} units)")
+
+
+@work.command(help="Show everything known about a unit of work.")
+@click.pass_context
+@click.argument('unit_id', type=str, required=True)
+def info(ctx, unit_id):
+    rc = get_rc(ctx)
+    pprint(rc.simple_command(f"work info {unit_id}"))
+
+
+@work.command(help="Fetch the latest status of a unit of work from the node running it.")
+@click.pass_context
+@click.argument('unit_id', type=str, required=True)
+def poll(ctx, unit_id):
+    rc = get_rc(ctx)
+    pprint(rc.simple_command(f"work poll {unit_id}"))
+
+
 @work.command(help="Submit a new unit of work.")
 @click.pass_context
 @click.argument('worktype', type=str, required=True)
@@ -279,11 +656,16 @@ def list_units(ctx, unit_id, node, tlsclient, quiet):
 @click.option('--no-payload', '-n', is_flag=True, help="Send an empty payload.")
 @click.option('--tls-client', 'tlsclient', type=str, default="", help="TLS client used when submitting work to a remote node")
 @click.option('--ttl', type=str, default="", help="Time to live until remote work must start, e.g. 1h20m30s or 30m10s")
+@click.option('--idempotency-key', 'idempotencykey', type=str, default="", help="Key identifying this submission. Resubmitting with the same key returns the existing unit.")
+@click.option('--webhook', type=str, default="", help="URL to POST the results and final status of the unit to as it runs")
+@click.option('--webhook-secret', 'webhooksecret', type=str, default="", help="Secret to sign webhook payloads with, using HMAC-SHA256, or file:PATH or env:NAME on the node")
+@click.option('--label', type=str, multiple=True, help="Label to tag the unit with (key=value format)")
 @click.option('--follow', '-f', help="Remain attached to the job and print its results to stdout", is_flag=True)
 @click.option('--rm', help="Release unit after completion", is_flag=True)
 @click.option('--param', '-a', help="Additional Receptor parameter (key=value format)", multiple=True)
 @click.argument('cmdparams', type=str, required=False, nargs=-1)
-def submit(ctx, worktype, node, payload, no_payload, payload_literal, tlsclient, ttl, follow, rm, param, cmdparams):
+def submit(ctx, worktype, node, payload, no_payload, payload_literal, tlsclient, ttl, idempotencykey, webhook, webhooksecret,
+           label, follow, rm, param, cmdparams):
     pcmds = 0
     if payload:
         pcmds += 1
@@ -320,7 +702,9 @@ def submit(ctx, worktype, node, payload, no_payload, payload_literal, tlsclient,
         if node == "":
             node = None
         rc = get_rc(ctx)
-        work = rc.submit_work(worktype, payload_data, node=node, tlsclient=tlsclient, ttl=ttl, params=params)
+        work = rc.submit_work(worktype, payload_data, node=node, tlsclient=tlsclient, ttl=ttl, params=params,
+                              idempotencykey=idempotencykey, webhook=webhook, webhooksecret=webhooksecret,
+                              labels=",".join(label))
         result = work.pop('result')
         unitid = work.pop('unitid')
         if follow:
@@ -336,9 +720,10 @@ def submit(ctx, worktype, node, payload, no_payload, payload_literal, tlsclient,
 @work.command(help="Get results for a previously or currently running unit of work.")
 @click.pass_context
 @click.argument('unit_id', type=str, required=True)
-def results(ctx, unit_id):
+@click.option('--start', type=int, default=0, help="Byte offset to start from, to resume an interrupted download.")
+def results(ctx, unit_id, start):
     rc = get_rc(ctx)
-    resultsfile = rc.get_work_results(unit_id)
+    resultsfile = rc.get_work_results(unit_id, start=start)
     for text in iter(partial(resultsfile.readline, 256), b''):
         sys.stdout.buffer.write(text)
         sys.stdout.buffer.flush()
@@ -351,11 +736,11 @@ def results(ctx, unit_id):
         sys.exit(1)
 
 
-def op_on_unit_ids(ctx, op, unit_ids):
+def op_on_unit_ids(ctx, op, unit_ids, options=""):
     rc = get_rc(ctx)
     for unit_id in unit_ids:
         try:
-            res = list(rc.simple_command(f"work {op} {unit_id}").items())[0]
+            res = list(rc.simple_command(f"work {op} {unit_id}{options}").items())[0]
             print(f"({res[1]}, {res[0]})")
         except Exception as e:
             print(f"{unit_id}: ERROR: {e}")
@@ -373,17 +758,37 @@ def cancel(ctx, unit_ids):
     op_on_unit_ids(ctx, "cancel", unit_ids)
 
 
-@work.command(help="Release (delete) one or more units of work.")
+@work.command(help="Release (delete) one or more units of work. If the node retains released units, their results are kept for its retention period.")
 @click.option('--force', help="Delete locally even if we can't reach the remote node", is_flag=True)
+@click.option('--no-retain', help="Delete straight away, even if the node retains released units", is_flag=True)
 @click.argument('unit_ids', nargs=-1)
 @click.pass_context
-def release(ctx, force, unit_ids):
+def release(ctx, force, no_retain, unit_ids):
     if len(unit_ids) == 0:
         print("No unit IDs supplied: Not doing anything")
         return
     op = "release" if not force else "force-release"
+    options = " --force" if no_retain and not force else ""
     print("Released:")
-    op_on_unit_ids(ctx, op, unit_ids)
+    op_on_unit_ids(ctx, op, unit_ids, options)
+
+
+@work.command(help="Stop accepting new units of a work type. Units already submitted are not affected.")
+@click.argument('work_type', type=str, required=True)
+@click.pass_context
+def drain(ctx, work_type):
+    rc = get_rc(ctx)
+    rc.simple_command(f"work drain {work_type}")
+    print(f"Drained: {work_type}")
+
+
+@work.command(help="Accept new units of a drained work type again.")
+@click.argument('work_type', type=str, required=True)
+@click.pass_context
+def undrain(ctx, work_type):
+    rc = get_rc(ctx)
+    rc.simple_command(f"work undrain {work_type}")
+    print(f"Undrained: {work_type}")
 
 
 def run():
@@ -16,7 +16,8 @@ def shutdown_write(sock):
         sock.shutdown(socket.SHUT_WR)
 
 class ReceptorControl:
-    def __init__(self, socketaddress, config=None, tlsclient=None, rootcas=None, key=None, cert=None, insecureskipverify=False):
+    def __init__(self, socketaddress, config=None, tlsclient=None, rootcas=None, key=None, cert=None, insecureskipverify=False,
+                 node=None, node_service="control", node_tlsclient=""):
         if config and any((rootcas, key, cert)):
             raise RuntimeError("Cannot specify both config and rootcas, key, cert")
         if config and not tlsclient:
@@ -29,6 +30,9 @@ class ReceptorControl:
         self._key = key
         self._cert = cert
         self._insecureskipverify = insecureskipverify
+        self._target_node = node
+        self._target_service = node_service
+        self._target_tlsclient = node_tlsclient or ""
         if config and tlsclient:
             self.readconfig(config, tlsclient)
 
@@ -87,7 +91,7 @@ class ReceptorControl:
                 self._socket = socket.socket(socket.AF_UNIX, socket.SOCK_STREAM)
                 self._socket.connect(path)
                 self._sockfile = self._socket.makefile('rwb')
-                self.handshake()
+                self._finish_connect()
                 return
             elif host and port:
                 self._socket = None
@@ -116,10 +120,22 @@ class ReceptorControl:
                     break
                 if self._socket is None:
                     raise ValueError(f"Could not connect to host {host} port {port}")
-                self.handshake()
+                self._finish_connect()
                 return
         raise ValueError(f"Invalid socket address {self._socketaddress}")
 
+    def _finish_connect(self):
+        self.handshake()
+        if self._target_node:
+            # Tunnel through the local node to the control service of the target node
+            self.writestr(f"connect {self._target_node} {self._target_service} {self._target_tlsclient}\n")
+            text = self.readstr()
+            if not str.startswith(text, "Connecting"):
+                raise RuntimeError(text)
+            self.handshake()
+            if self._remote_node != self._target_node:
+                raise RuntimeError(f"Connected to node {self._remote_node} instead of {self._target_node}")
+
     def close(self):
         if self._sockfile is not None:
             try:
@@ -140,7 +156,8 @@ class ReceptorControl:
         if not str.startswith(text, "Connecting"):
             raise RuntimeError(text)
 
-    def submit_work(self, worktype, payload, node=None, tlsclient=None, ttl=None, params=None):
+    def submit_work(self, worktype, payload, node=None, tlsclient=None, ttl=None, params=None, idempotencykey=None,
+                    webhook=None, webhooksecret=None, labels=None):
         self.connect()
         if node is None:
             node = "localhost"
@@ -158,6 +175,18 @@ class ReceptorControl:
         if ttl:
             commandMap['ttl'] = ttl
 
+        if idempotencykey:
+            commandMap['idempotencykey'] = idempotencykey
+
+        if webhook:
+            commandMap['webhook'] = webhook
+
+        if webhooksecret:
+            commandMap['webhooksecret'] = webhooksecret
+
+        if labels:
+            commandMap['labels'] = labels
+
         if params:
             for k,v in params.items():
                 if k not in commandMap:
@@ -202,9 +231,9 @@ class ReceptorControl:
         result = json.loads(text)
         return result
 
-    def get_work_results(self, unit_id, return_socket=False, return_sockfile=True):
+    def get_work_results(self, unit_id, return_socket=False, return_sockfile=True, start=0):
         self.connect()
-        self.writestr(f"work results {unit_id}\n")
+        self.writestr(f"work results {unit_id} {start}\n")
         text = self.readstr()
         m = re.compile("Streaming results for work unit (.+)").fullmatch(text)
         if not m: